@@ -43,10 +43,6 @@ func (ac *accumulator) Add(
 	fields := make(map[string]interface{})
 	fields["value"] = value
 
-	if !ac.inputConfig.Filter.ShouldNamePass(measurement) {
-		return
-	}
-
 	ac.AddFields(measurement, fields, tags, t...)
 }
 
@@ -61,10 +57,14 @@ func (ac *accumulator) AddFields(
 	}
 
 	if !ac.inputConfig.Filter.ShouldNamePass(measurement) {
+		internal_models.TraceDrop(ac.inputConfig.Name, measurement, tags,
+			"namepass/namedrop")
 		return
 	}
 
 	if !ac.inputConfig.Filter.ShouldTagsPass(tags) {
+		internal_models.TraceDrop(ac.inputConfig.Name, measurement, tags,
+			"tagpass/tagdrop")
 		return
 	}
 
@@ -132,9 +132,22 @@ func (ac *accumulator) AddFields(
 	}
 	fields = nil
 	if len(result) == 0 {
+		internal_models.TraceDrop(ac.inputConfig.Name, measurement, tags,
+			"no fields left after fieldpass/fielddrop and invalid values")
 		return
 	}
 
+	if !ac.inputConfig.Limits.Apply(tags, result) {
+		internal_models.TraceDrop(ac.inputConfig.Name, measurement, tags,
+			"over max_tags, max_fields or max_tag_value_length")
+		return
+	}
+	// The route is set after the tag filters and limits, so that they cannot
+	// remove it
+	if len(ac.inputConfig.Route) != 0 {
+		tags[internal_models.RouteTag] = ac.inputConfig.Route
+	}
+
 	var timestamp time.Time
 	if len(t) > 0 {
 		timestamp = t[0]
@@ -154,7 +167,8 @@ func (ac *accumulator) AddFields(
 	if ac.trace {
 		fmt.Println("> " + m.String())
 	}
-	ac.metrics <- m
+	m = internal_models.WithPriority(m, ac.inputConfig.Priority)
+	ac.metrics <- internal_models.StartTrace(ac.inputConfig.Name, m)
 }
 
 func (ac *accumulator) Debug() bool {
@@ -332,3 +332,46 @@ func TestAccFilterTags(t *testing.T) {
 		fmt.Sprintf("acctest value=101 %d", now.UnixNano()),
 		actual)
 }
+
+// Test that the limits of the input get applied to metrics, but not to their
+// route tag.
+func TestAccLimits(t *testing.T) {
+	a := accumulator{}
+	now := time.Now()
+	a.metrics = make(chan telegraf.Metric, 10)
+	defer close(a.metrics)
+	a.inputConfig = &internal_models.InputConfig{
+		Route: "web",
+		Limits: internal_models.Limits{
+			MaxTags:           1,
+			MaxFields:         1,
+			MaxTagValueLength: 4,
+		},
+	}
+
+	a.AddFields("acctest",
+		map[string]interface{}{"a": int64(1), "b": int64(2)},
+		map[string]string{"host": "localhost", "path": "/"}, now)
+
+	testm := <-a.metrics
+	assert.Equal(t,
+		fmt.Sprintf("acctest,host=loca,route=web a=1i %d", now.UnixNano()),
+		testm.String())
+
+	a.inputConfig.Limits.Policy = internal_models.LimitDrop
+	a.AddFields("acctest", map[string]interface{}{"a": int64(1)},
+		map[string]string{"host": "localhost"}, now)
+	a.AddFields("acctest", map[string]interface{}{"a": int64(1)},
+		map[string]string{"host": "db"}, now)
+
+	testm = <-a.metrics
+	assert.Equal(t,
+		fmt.Sprintf("acctest,host=db,route=web a=1i %d", now.UnixNano()),
+		testm.String())
+	assert.Equal(t, internal_models.LimitCounters{
+		TagsDropped:        1,
+		FieldsDropped:      1,
+		TagValuesTruncated: 1,
+		MetricsDropped:     1,
+	}, a.inputConfig.Limits.Counters())
+}
@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// serveAdmin serves the admin HTTP API on the given address until shutdown is
+// closed. The address is either "host:port" or "unix:///path/to/socket".
+func (a *Agent) serveAdmin(address string, shutdown chan struct{}) error {
+	listener, err := listenAdmin(address)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/reload", a.handleReload)
+	mux.HandleFunc("/health", handleHealth)
+	mux.HandleFunc("/cluster", a.handleCluster)
+
+	go func() {
+		<-shutdown
+		listener.Close()
+	}()
+	go func() {
+		// Serve always returns an error once the listener is closed
+		http.Serve(listener, mux)
+	}()
+
+	log.Printf("Admin API listening on %s\n", address)
+	return nil
+}
+
+func listenAdmin(address string) (net.Listener, error) {
+	if strings.HasPrefix(address, "unix://") {
+		path := strings.TrimPrefix(address, "unix://")
+		// remove a socket left behind by a previous run
+		os.Remove(path)
+		return net.Listen("unix", path)
+	}
+	return net.Listen("tcp", address)
+}
+
+// handleReload reloads the configuration on POST /reload, and responds with
+// the plugins that were started and stopped.
+func (a *Agent) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	log.Printf("Reloading Telegraf config\n")
+	summary, err := a.ReloadConfig()
+	if err != nil {
+		log.Printf("Error reloading config, keeping current config: %s\n", err)
+		writeJSON(w, http.StatusInternalServerError,
+			map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, summary)
+}
+
+// handleHealth responds with the state of every running input and output.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	inputs, outputs := internal_models.Health()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"inputs":  inputs,
+		"outputs": outputs,
+	})
+}
+
+// handleCluster responds with this agent's URL and the members of its
+// cluster that are up.
+func (a *Agent) handleCluster(w http.ResponseWriter, r *http.Request) {
+	members := a.cluster.Members()
+	if members == nil {
+		members = []string{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"self":    a.cluster.Self(),
+		"members": members,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
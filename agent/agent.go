@@ -10,13 +10,52 @@ import (
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/cluster"
 	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/experimental"
 	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/otlp"
+	"github.com/influxdata/telegraf/internal/state"
 )
 
 // Agent runs telegraf and collects data based on the given config
 type Agent struct {
 	Config *config.Config
+
+	// ConfigLoader loads the configuration to apply when a reload is
+	// requested, either through SIGHUP or the admin API.
+	ConfigLoader func() (*config.Config, error)
+
+	// mu protects Config and the running plugins while the agent is running.
+	mu sync.Mutex
+	// reloadMu serializes reloads.
+	reloadMu sync.Mutex
+
+	wg       sync.WaitGroup
+	shutdown chan struct{}
+	metricC  chan telegraf.Metric
+	// stop channels of the running inputs
+	running map[*internal_models.RunningInput]chan struct{}
+	// stopped channels of the running inputs, closed once their gatherer
+	// returned and their service is stopped
+	stopped map[*internal_models.RunningInput]chan struct{}
+	// gatherers tracks the goroutines gathering from the running inputs
+	gatherers sync.WaitGroup
+	// paused is true while the inputs are paused by backpressure
+	paused bool
+	// reloaded tells the flusher that the agent config may have changed
+	reloaded chan struct{}
+	// drainC asks the flusher to drain the outputs
+	drainC chan drainRequest
+	// state persists the state of stateful inputs, nil if not configured
+	state *state.Store
+	// cluster shares the targets of sharded inputs, nil if not configured
+	cluster *cluster.Cluster
+	// ready channels of the inputs, closed once they are ready
+	readyMu sync.Mutex
+	ready   map[*internal_models.RunningInput]chan struct{}
+	// spans exports the spans of the agent, nil if not configured
+	spans *otlp.Exporter
 }
 
 // NewAgent returns an Agent struct based off the given Config
@@ -25,53 +64,76 @@ func NewAgent(config *config.Config) (*Agent, error) {
 		Config: config,
 	}
 
-	if !a.Config.Agent.OmitHostname {
-		if a.Config.Agent.Hostname == "" {
-			hostname, err := os.Hostname()
-			if err != nil {
-				return nil, err
-			}
+	if err := setHostTag(config); err != nil {
+		return nil, err
+	}
+	if err := experimental.Set(config.Experimental); err != nil {
+		return nil, err
+	}
 
-			a.Config.Agent.Hostname = hostname
+	return a, nil
+}
+
+// setHostTag sets the "host" global tag, unless omit_hostname is set.
+func setHostTag(c *config.Config) error {
+	if c.Agent.OmitHostname {
+		return nil
+	}
+	if c.Agent.Hostname == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return err
 		}
 
-		config.Tags["host"] = a.Config.Agent.Hostname
+		c.Agent.Hostname = hostname
 	}
 
-	return a, nil
+	c.Tags["host"] = c.Agent.Hostname
+	return nil
 }
 
 // Connect connects to all configured outputs
 func (a *Agent) Connect() error {
 	for _, o := range a.Config.Outputs {
-		o.Quiet = a.Config.Agent.Quiet
-
-		switch ot := o.Output.(type) {
-		case telegraf.ServiceOutput:
-			if err := ot.Start(); err != nil {
-				log.Printf("Service for output %s failed to start, exiting\n%s\n",
-					o.Name, err.Error())
-				return err
-			}
+		if err := connectOutput(o, a.Config.Agent); err != nil {
+			return err
 		}
+	}
+	return nil
+}
 
-		if a.Config.Agent.Debug {
-			log.Printf("Attempting connection to output: %s\n", o.Name)
-		}
-		err := o.Output.Connect()
+// connectOutput starts the service of a ServiceOutput and connects the output.
+func connectOutput(o *internal_models.RunningOutput, ac *config.AgentConfig) error {
+	o.Quiet = ac.Quiet
+
+	switch ot := o.Output.(type) {
+	case telegraf.ServiceOutput:
+		var err error
+		o.Usage.Do(func() { err = ot.Start() })
 		if err != nil {
-			log.Printf("Failed to connect to output %s, retrying in 15s, "+
-				"error was '%s' \n", o.Name, err)
-			time.Sleep(15 * time.Second)
-			err = o.Output.Connect()
-			if err != nil {
-				return err
-			}
+			log.Printf("Service for output %s failed to start, exiting\n%s\n",
+				o.LogName(), err.Error())
+			return err
 		}
-		if a.Config.Agent.Debug {
-			log.Printf("Successfully connected to output: %s\n", o.Name)
+	}
+
+	if ac.Debug {
+		log.Printf("Attempting connection to output: %s\n", o.LogName())
+	}
+	var err error
+	o.Usage.Do(func() { err = o.Output.Connect() })
+	if err != nil {
+		log.Printf("Failed to connect to output %s, retrying in 15s, "+
+			"error was '%s' \n", o.LogName(), err)
+		time.Sleep(15 * time.Second)
+		o.Usage.Do(func() { err = o.Output.Connect() })
+		if err != nil {
+			return err
 		}
 	}
+	if ac.Debug {
+		log.Printf("Successfully connected to output: %s\n", o.LogName())
+	}
 	return nil
 }
 
@@ -79,11 +141,17 @@ func (a *Agent) Connect() error {
 func (a *Agent) Close() error {
 	var err error
 	for _, o := range a.Config.Outputs {
-		err = o.Output.Close()
-		switch ot := o.Output.(type) {
-		case telegraf.ServiceOutput:
-			ot.Stop()
-		}
+		err = closeOutput(o)
+	}
+	return err
+}
+
+// closeOutput closes the output and stops its service, if any.
+func closeOutput(o *internal_models.RunningOutput) error {
+	err := o.Output.Close()
+	switch ot := o.Output.(type) {
+	case telegraf.ServiceOutput:
+		ot.Stop()
 	}
 	return err
 }
@@ -93,7 +161,7 @@ func panicRecover(input *internal_models.RunningInput) {
 		trace := make([]byte, 2048)
 		runtime.Stack(trace, true)
 		log.Printf("FATAL: Input [%s] panicked: %s, Stack:\n%s\n",
-			input.Name, err, trace)
+			input.LogName(), err, trace)
 		log.Println("PLEASE REPORT THIS PANIC ON GITHUB with " +
 			"stack trace, configuration, and OS information: " +
 			"https://github.com/influxdata/telegraf/issues/new")
@@ -104,6 +172,7 @@ func panicRecover(input *internal_models.RunningInput) {
 // reporting interval.
 func (a *Agent) gatherer(
 	shutdown chan struct{},
+	c *config.Config,
 	input *internal_models.RunningInput,
 	interval time.Duration,
 	metricC chan telegraf.Metric,
@@ -113,25 +182,68 @@ func (a *Agent) gatherer(
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
+	// number of consecutive failed gathers
+	var failures int
+
 	for {
 		var outerr error
 
 		acc := NewAccumulator(input.Config, metricC)
-		acc.SetDebug(a.Config.Agent.Debug)
-		acc.setDefaultTags(a.Config.Tags)
+		acc.SetDebug(c.Agent.Debug)
+		acc.setDefaultTags(c.Tags)
+
+		internal.RandomSleep(c.Agent.CollectionJitter.Duration, shutdown)
 
-		internal.RandomSleep(a.Config.Agent.CollectionJitter.Duration, shutdown)
+		if !a.ownsInput(input) {
+			select {
+			case <-shutdown:
+				return nil
+			case <-ticker.C:
+				continue
+			}
+		}
 
 		start := time.Now()
-		gatherWithTimeout(shutdown, input, acc, interval)
+		err := a.gatherSpan(shutdown, input, acc, interval)
 		elapsed := time.Since(start)
+		input.RecordGather(start, elapsed, err)
 
 		if outerr != nil {
 			return outerr
 		}
-		if a.Config.Agent.Debug {
+		if c.Agent.Debug {
 			log.Printf("Input [%s] gathered metrics, (%s interval) in %s\n",
-				input.Name, interval, elapsed)
+				input.LogName(), interval, elapsed)
+		}
+
+		if err != nil {
+			failures++
+		} else {
+			a.setReady(input)
+			if failures > 0 && input.Config.MaxBackoff > 0 {
+				log.Printf("Input [%s] recovered after %d failed gathers, "+
+					"resuming %s interval\n", input.LogName(), failures, interval)
+			}
+			failures = 0
+		}
+
+		// Back off from an input that keeps failing, so that an unreachable
+		// service doesn't cost a full timeout every interval.
+		wait := backoffInterval(interval, input.Config.MaxBackoff, failures)
+		if wait > interval {
+			log.Printf("Input [%s] failed %d consecutive gathers, "+
+				"backing off for %s\n", input.LogName(), failures, wait)
+			select {
+			case <-shutdown:
+				return nil
+			case <-time.After(wait - interval):
+			}
+			// drop the tick that fired while backing off, so that we wait
+			// for the next one.
+			select {
+			case <-ticker.C:
+			default:
+			}
 		}
 
 		select {
@@ -143,38 +255,110 @@ func (a *Agent) gatherer(
 	}
 }
 
+// backoffInterval returns how long to wait before the next collection of an
+// input that has failed the given number of consecutive times. The interval
+// is doubled for every failure, up to max. If max is not greater than
+// interval, backoff is disabled and interval is returned.
+func backoffInterval(interval, max time.Duration, failures int) time.Duration {
+	if max <= interval {
+		return interval
+	}
+	wait := interval
+	for i := 0; i < failures; i++ {
+		wait *= 2
+		if wait >= max {
+			return max
+		}
+	}
+	return wait
+}
+
+// scheduledGatherer runs an input that has been configured with a cron
+// schedule, gathering every time the schedule fires.
+func (a *Agent) scheduledGatherer(
+	shutdown chan struct{},
+	c *config.Config,
+	input *internal_models.RunningInput,
+	metricC chan telegraf.Metric,
+) error {
+	defer panicRecover(input)
+
+	schedule := input.Config.Schedule
+	for {
+		now := time.Now()
+		next := schedule.Next(now)
+		if next.IsZero() {
+			return fmt.Errorf("ERROR: input [%s] schedule never fires, "+
+				"not gathering", input.LogName())
+		}
+		select {
+		case <-shutdown:
+			return nil
+		case <-time.After(next.Sub(now)):
+		}
+		if !a.ownsInput(input) {
+			continue
+		}
+
+		acc := NewAccumulator(input.Config, metricC)
+		acc.SetDebug(c.Agent.Debug)
+		acc.setDefaultTags(c.Tags)
+
+		internal.RandomSleep(c.Agent.CollectionJitter.Duration, shutdown)
+
+		// the gather times out when the schedule fires again
+		start := time.Now()
+		timeout := c.Agent.Interval.Duration
+		if following := schedule.Next(start); !following.IsZero() {
+			timeout = following.Sub(start)
+		}
+		err := a.gatherSpan(shutdown, input, acc, timeout)
+		elapsed := time.Since(start)
+		input.RecordGather(start, elapsed, err)
+		if err == nil {
+			a.setReady(input)
+		}
+
+		if c.Agent.Debug {
+			log.Printf("Input [%s] gathered metrics, (scheduled at %s) in %s\n",
+				input.LogName(), next.Format(time.RFC3339), elapsed)
+		}
+	}
+}
+
 // gatherWithTimeout gathers from the given input, with the given timeout.
 //   when the given timeout is reached, gatherWithTimeout logs an error message
 //   but continues waiting for it to return. This is to avoid leaving behind
 //   hung processes, and to prevent re-calling the same hung process over and
-//   over.
+//   over. The error returned by the input's Gather is returned, or nil if
+//   shutdown was requested before it finished.
 func gatherWithTimeout(
 	shutdown chan struct{},
 	input *internal_models.RunningInput,
 	acc *accumulator,
 	timeout time.Duration,
-) {
+) error {
 	ticker := time.NewTicker(timeout)
 	defer ticker.Stop()
 	done := make(chan error)
-	go func() {
+	go input.Usage.Do(func() {
 		done <- input.Input.Gather(acc)
-	}()
+	})
 
 	for {
 		select {
 		case err := <-done:
 			if err != nil {
-				log.Printf("ERROR in input [%s]: %s", input.Name, err)
+				log.Printf("ERROR in input [%s]: %s", input.LogName(), err)
 			}
-			return
+			return err
 		case <-ticker.C:
 			log.Printf("ERROR: input [%s] took longer to collect than "+
 				"collection interval (%s)",
-				input.Name, timeout)
+				input.LogName(), timeout)
 			continue
 		case <-shutdown:
-			return
+			return nil
 		}
 	}
 }
@@ -182,6 +366,7 @@ func gatherWithTimeout(
 // Test verifies that we can 'Gather' from all inputs with their configured
 // Config struct
 func (a *Agent) Test() error {
+	internal_models.SetTracer(a.Config.Tracer)
 	shutdown := make(chan struct{})
 	defer close(shutdown)
 	metricC := make(chan telegraf.Metric)
@@ -229,16 +414,38 @@ func (a *Agent) Test() error {
 
 // flush writes a list of metrics to all configured outputs
 func (a *Agent) flush() {
-	var wg sync.WaitGroup
+	a.mu.Lock()
+	defer a.mu.Unlock()
 
-	wg.Add(len(a.Config.Outputs))
+	// Dead letter outputs are written last, so that they include the metrics
+	// dropped by the other outputs during this flush.
+	var outputs, deadLetters []*internal_models.RunningOutput
 	for _, o := range a.Config.Outputs {
+		if o.Config.DeadLetter {
+			deadLetters = append(deadLetters, o)
+		} else {
+			outputs = append(outputs, o)
+		}
+	}
+	span := a.spans.Start("flush")
+	writeOutputs(outputs, span)
+	writeOutputs(deadLetters, span)
+	span.End()
+}
+
+// writeOutputs writes the cached metrics of the given outputs concurrently,
+// recording the writes as child spans of the given span.
+func writeOutputs(outputs []*internal_models.RunningOutput, span *otlp.Span) {
+	var wg sync.WaitGroup
+
+	wg.Add(len(outputs))
+	for _, o := range outputs {
 		go func(output *internal_models.RunningOutput) {
 			defer wg.Done()
-			err := output.Write()
+			err := output.WriteSpan(span)
 			if err != nil {
 				log.Printf("Error writing to output [%s]: %s\n",
-					output.Name, err.Error())
+					output.LogName(), err.Error())
 			}
 		}(o)
 	}
@@ -252,7 +459,10 @@ func (a *Agent) flusher(shutdown chan struct{}, metricC chan telegraf.Metric) er
 	// the flusher will flush after metrics are collected.
 	time.Sleep(time.Millisecond * 200)
 
-	ticker := time.NewTicker(a.Config.Agent.FlushInterval.Duration)
+	a.mu.Lock()
+	ac := a.Config.Agent
+	a.mu.Unlock()
+	ticker := time.NewTicker(ac.FlushInterval.Duration)
 
 	for {
 		select {
@@ -260,43 +470,161 @@ func (a *Agent) flusher(shutdown chan struct{}, metricC chan telegraf.Metric) er
 			log.Println("Hang on, flushing any cached metrics before shutdown")
 			a.flush()
 			return nil
+		case <-a.reloaded:
+			a.mu.Lock()
+			ac = a.Config.Agent
+			a.mu.Unlock()
+			ticker.Stop()
+			ticker = time.NewTicker(ac.FlushInterval.Duration)
 		case <-ticker.C:
-			internal.RandomSleep(a.Config.Agent.FlushJitter.Duration, shutdown)
+			internal.RandomSleep(ac.FlushJitter.Duration, shutdown)
 			a.flush()
+			a.commitState()
+			a.mu.Lock()
+			a.applyBackpressure()
+			a.mu.Unlock()
+		case req := <-a.drainC:
+			req.done <- a.drain(metricC, req.deadline)
 		case m := <-metricC:
-			for _, o := range a.Config.Outputs {
-				o.AddMetric(m)
-			}
+			a.mu.Lock()
+			routeMetric(a.Config, m)
+			a.applyBackpressure()
+			a.mu.Unlock()
 		}
 	}
 }
 
+// startService sets the state and cluster of the input, if it uses them, then
+// starts the service of a ServiceInput, if input is one.
+func (a *Agent) startService(
+	c *config.Config,
+	input *internal_models.RunningInput,
+) error {
+	if err := a.setState(input); err != nil {
+		log.Printf("State of input %s failed to load, exiting\n%s\n",
+			input.LogName(), err.Error())
+		return err
+	}
+	a.setCluster(input)
+	p, ok := input.Input.(telegraf.ServiceInput)
+	if !ok {
+		return nil
+	}
+	acc := NewAccumulator(input.Config, a.metricC)
+	acc.SetDebug(c.Agent.Debug)
+	acc.setDefaultTags(c.Tags)
+	var err error
+	input.Usage.Do(func() { err = p.Start(acc) })
+	if err != nil {
+		log.Printf("Service for input %s failed to start, exiting\n%s\n",
+			input.LogName(), err.Error())
+		return err
+	}
+	a.setReady(input)
+	return nil
+}
+
+// stopService stops the service of a ServiceInput, if input is one.
+func stopService(input *internal_models.RunningInput) {
+	if p, ok := input.Input.(telegraf.ServiceInput); ok {
+		p.Stop()
+	}
+}
+
+// startInput starts gathering from the given input. The input's service (if
+// any) must already have been started, it will be stopped along with the
+// gatherer. a.mu must be held.
+func (a *Agent) startInput(c *config.Config, input *internal_models.RunningInput) {
+	stop := make(chan struct{})
+	a.running[input] = stop
+	done := make(chan struct{})
+	a.stopped[input] = done
+	if a.paused {
+		pauseInput(input, true)
+	}
+
+	a.gatherers.Add(1)
+	go func() {
+		defer a.gatherers.Done()
+		defer close(done)
+		a.runInput(c, input, stop)
+	}()
+}
+
+// runInput gathers from the given input until stop is closed, then stops its
+// service.
+func (a *Agent) runInput(
+	c *config.Config,
+	input *internal_models.RunningInput,
+	stop chan struct{},
+) {
+	defer stopService(input)
+	interval := c.Agent.Interval.Duration
+	// overwrite global interval if this plugin has it's own.
+	if input.Config.Interval != 0 {
+		interval = input.Config.Interval
+	}
+
+	var err error
+	if input.Config.Schedule != nil {
+		err = a.scheduledGatherer(stop, c, input, a.metricC)
+	} else {
+		err = a.gatherer(stop, c, input, interval, a.metricC)
+	}
+	if err != nil {
+		log.Println(err.Error())
+	}
+}
+
+// stopInput stops gathering from the given input. a.mu must be held.
+func (a *Agent) stopInput(input *internal_models.RunningInput) {
+	if stop, ok := a.running[input]; ok {
+		close(stop)
+		delete(a.running, input)
+		delete(a.stopped, input)
+	}
+	a.forgetReady(input)
+}
+
 // Run runs the agent daemon, gathering every Interval
 func (a *Agent) Run(shutdown chan struct{}) error {
-	var wg sync.WaitGroup
-
 	log.Printf("Agent Config: Interval:%s, Debug:%#v, Quiet:%#v, Hostname:%#v, "+
 		"Flush Interval:%s \n",
 		a.Config.Agent.Interval.Duration, a.Config.Agent.Debug, a.Config.Agent.Quiet,
 		a.Config.Agent.Hostname, a.Config.Agent.FlushInterval.Duration)
 
 	// channel shared between all input threads for accumulating metrics
-	metricC := make(chan telegraf.Metric, 10000)
-
+	a.metricC = make(chan telegraf.Metric, 10000)
+	a.shutdown = shutdown
+	a.running = make(map[*internal_models.RunningInput]chan struct{})
+	a.stopped = make(map[*internal_models.RunningInput]chan struct{})
+	a.reloaded = make(chan struct{}, 1)
+	a.drainC = make(chan drainRequest)
+	setDeadLetters(a.Config.Outputs)
+	internal_models.SetTracer(a.Config.Tracer)
+	if err := a.openState(); err != nil {
+		return err
+	}
+	defer a.closeState()
+	a.startCluster(shutdown)
+	a.startSpans(shutdown)
+	defer a.flushSpans()
+	a.startUsage(shutdown)
+
+	// Start service of any ServicePlugins, except the inputs waiting for
+	// others, which are started once those are ready
+	var started []*internal_models.RunningInput
 	for _, input := range a.Config.Inputs {
-		// Start service of any ServicePlugins
-		switch p := input.Input.(type) {
-		case telegraf.ServiceInput:
-			acc := NewAccumulator(input.Config, metricC)
-			acc.SetDebug(a.Config.Agent.Debug)
-			acc.setDefaultTags(a.Config.Tags)
-			if err := p.Start(acc); err != nil {
-				log.Printf("Service for input %s failed to start, exiting\n%s\n",
-					input.Name, err.Error())
-				return err
+		if len(input.Config.StartAfter) > 0 {
+			continue
+		}
+		if err := a.startService(a.Config, input); err != nil {
+			for _, input := range started {
+				stopService(input)
 			}
-			defer p.Stop()
+			return err
 		}
+		started = append(started, input)
 	}
 
 	// Round collection to nearest interval by sleeping
@@ -305,30 +633,42 @@ func (a *Agent) Run(shutdown chan struct{}) error {
 		time.Sleep(time.Duration(i - (time.Now().UnixNano() % i)))
 	}
 
-	wg.Add(1)
+	if a.Config.Agent.AdminAddress != "" {
+		if err := a.serveAdmin(a.Config.Agent.AdminAddress, shutdown); err != nil {
+			log.Printf("Error starting admin API: %s\n", err)
+		}
+	}
+
+	a.wg.Add(1)
 	go func() {
-		defer wg.Done()
-		if err := a.flusher(shutdown, metricC); err != nil {
+		defer a.wg.Done()
+		if err := a.flusher(shutdown, a.metricC); err != nil {
 			log.Printf("Flusher routine failed, exiting: %s\n", err.Error())
-			close(shutdown)
+			a.exit()
 		}
 	}()
 
-	wg.Add(len(a.Config.Inputs))
+	a.mu.Lock()
 	for _, input := range a.Config.Inputs {
-		interval := a.Config.Agent.Interval.Duration
-		// overwrite global interval if this plugin has it's own.
-		if input.Config.Interval != 0 {
-			interval = input.Config.Interval
+		if len(input.Config.StartAfter) > 0 {
+			a.startAfter(a.Config, input)
+		} else {
+			a.startInput(a.Config, input)
 		}
-		go func(in *internal_models.RunningInput, interv time.Duration) {
-			defer wg.Done()
-			if err := a.gatherer(shutdown, in, interv, metricC); err != nil {
-				log.Printf(err.Error())
-			}
-		}(input, interval)
 	}
+	internal_models.SetRunning(a.Config.Inputs, a.Config.Outputs)
+	a.mu.Unlock()
 
-	wg.Wait()
+	go func() {
+		<-shutdown
+		a.mu.Lock()
+		for input := range a.running {
+			a.stopInput(input)
+		}
+		a.mu.Unlock()
+	}()
+
+	a.wg.Wait()
+	a.gatherers.Wait()
 	return nil
 }
@@ -2,8 +2,11 @@ package agent
 
 import (
 	"testing"
+	"time"
 
+	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/testutil"
 
 	// needing to load the plugins
 	_ "github.com/influxdata/telegraf/plugins/inputs/all"
@@ -109,3 +112,59 @@ func TestAgent_LoadOutput(t *testing.T) {
 	a, _ = NewAgent(c)
 	assert.Equal(t, 3, len(a.Config.Outputs))
 }
+
+func TestAgent_BackoffInterval(t *testing.T) {
+	interval := 10 * time.Second
+
+	// backoff disabled
+	assert.Equal(t, interval, backoffInterval(interval, 0, 5))
+	assert.Equal(t, interval, backoffInterval(interval, interval, 5))
+
+	max := 60 * time.Second
+	assert.Equal(t, interval, backoffInterval(interval, max, 0))
+	assert.Equal(t, 20*time.Second, backoffInterval(interval, max, 1))
+	assert.Equal(t, 40*time.Second, backoffInterval(interval, max, 2))
+	assert.Equal(t, max, backoffInterval(interval, max, 3))
+	assert.Equal(t, max, backoffInterval(interval, max, 100))
+}
+
+func TestAgent_DeadLetters(t *testing.T) {
+	c := config.NewConfig()
+	assert.NoError(t, c.LoadConfig("../internal/config/testdata/dead_letter.toml"))
+	assert.Equal(t, 2, len(c.Outputs))
+
+	out, sink := c.Outputs[0], c.Outputs[1]
+	assert.False(t, out.Config.DeadLetter)
+	assert.True(t, sink.Config.DeadLetter)
+
+	setDeadLetters(c.Outputs)
+	assert.Nil(t, sink.DeadLetter)
+	assert.NotNil(t, out.DeadLetter)
+
+	out.DeadLetter(testutil.TestMetric(1, "dropped"), "buffer full")
+	assert.Equal(t, 1, sink.Health().BufferSize)
+}
+
+func TestAgent_Route(t *testing.T) {
+	c := config.NewConfig()
+	assert.NoError(t, c.LoadConfig("../internal/config/testdata/route.toml"))
+	billing, cpu, def, all := c.Outputs[0], c.Outputs[1], c.Outputs[2],
+		c.Outputs[3]
+
+	metric := func(name string, tags map[string]string) telegraf.Metric {
+		m, err := telegraf.NewMetric(name, tags,
+			map[string]interface{}{"value": 1}, time.Now())
+		assert.NoError(t, err)
+		return m
+	}
+	routeMetric(c, metric("mem", map[string]string{"route": "billing"}))
+	routeMetric(c, metric("cpu", map[string]string{"host": "prod"}))
+	routeMetric(c, metric("cpu", map[string]string{"host": "test1"}))
+	routeMetric(c, metric("mem", map[string]string{"route": "drop"}))
+	routeMetric(c, metric("debug_mem", nil))
+
+	assert.Equal(t, 1, billing.Health().BufferSize)
+	assert.Equal(t, 1, cpu.Health().BufferSize)
+	assert.Equal(t, 1, def.Health().BufferSize)
+	assert.Equal(t, 3, all.Health().BufferSize)
+}
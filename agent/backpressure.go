@@ -0,0 +1,85 @@
+package agent
+
+import (
+	"log"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// applyBackpressure pauses the running inputs that implement
+// telegraf.Pausable when the buffer of any output is fuller than the high
+// watermark, and resumes them once the buffers of all outputs are below the
+// low watermark. a.mu must be held.
+func (a *Agent) applyBackpressure() {
+	ac := a.Config.Agent
+	if ac.BackpressureHighWatermark == 0 {
+		// backpressure may have been disabled by a reload
+		if a.paused {
+			a.setPaused(false)
+		}
+		return
+	}
+
+	fullest, name := fullestOutput(a.Config.Outputs)
+	switch {
+	case !a.paused && fullest > ac.BackpressureHighWatermark:
+		paused := a.setPaused(true)
+		if len(paused) > 0 {
+			log.Printf("Output [%s] buffer is %.0f%% full, pausing inputs: %s\n",
+				name, 100*fullest, strings.Join(paused, " "))
+		}
+	case a.paused && fullest < ac.BackpressureLowWatermark:
+		resumed := a.setPaused(false)
+		if len(resumed) > 0 {
+			log.Printf("Output buffers drained, resuming inputs: %s\n",
+				strings.Join(resumed, " "))
+		}
+	}
+}
+
+// setPaused pauses or resumes the running inputs that can be paused, and
+// returns their names. a.mu must be held.
+func (a *Agent) setPaused(paused bool) []string {
+	a.paused = paused
+	var names []string
+	for input := range a.running {
+		if pauseInput(input, paused) {
+			names = append(names, input.LogName())
+		}
+	}
+	return names
+}
+
+// pauseInput pauses or resumes the given input, if it can be paused.
+func pauseInput(input *internal_models.RunningInput, paused bool) bool {
+	p, ok := input.Input.(telegraf.Pausable)
+	if !ok {
+		return false
+	}
+	if paused {
+		p.Pause()
+	} else {
+		p.Resume()
+	}
+	input.SetPaused(paused)
+	return true
+}
+
+// fullestOutput returns how full the fullest output buffer is, as a fraction
+// of its limit, and the name of that output.
+func fullestOutput(outputs []*internal_models.RunningOutput) (float64, string) {
+	var fullest float64
+	var name string
+	for _, o := range outputs {
+		h := o.Health()
+		if h.BufferLimit == 0 {
+			continue
+		}
+		if f := float64(h.BufferSize) / float64(h.BufferLimit); f > fullest {
+			fullest, name = f, o.LogName()
+		}
+	}
+	return fullest, name
+}
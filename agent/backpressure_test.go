@@ -0,0 +1,70 @@
+package agent
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type pausableInput struct {
+	paused bool
+}
+
+func (p *pausableInput) Description() string                 { return "" }
+func (p *pausableInput) SampleConfig() string                { return "" }
+func (p *pausableInput) Gather(_ telegraf.Accumulator) error { return nil }
+func (p *pausableInput) Start(_ telegraf.Accumulator) error  { return nil }
+func (p *pausableInput) Stop()                               {}
+func (p *pausableInput) Pause()                              { p.paused = true }
+func (p *pausableInput) Resume()                             { p.paused = false }
+
+func TestAgent_Backpressure(t *testing.T) {
+	c := config.NewConfig()
+	c.Agent.BackpressureHighWatermark = 0.8
+	c.Agent.BackpressureLowWatermark = 0.4
+	ro := internal_models.NewRunningOutput("test", &drainOutput{},
+		&internal_models.OutputConfig{Name: "test"}, 1000, 10)
+	ro.Quiet = true
+	c.Outputs = append(c.Outputs, ro)
+
+	p := &pausableInput{}
+	input := &internal_models.RunningInput{Name: "listener", Input: p}
+	a := &Agent{
+		Config: c,
+		running: map[*internal_models.RunningInput]chan struct{}{
+			input: make(chan struct{}),
+		},
+	}
+
+	for i := 0; i < 8; i++ {
+		ro.AddMetric(testutil.TestMetric(i))
+	}
+	a.applyBackpressure()
+	assert.False(t, p.paused)
+
+	ro.AddMetric(testutil.TestMetric(8))
+	a.applyBackpressure()
+	assert.True(t, p.paused)
+	assert.True(t, input.Health().Paused)
+
+	require.NoError(t, ro.Write())
+	a.applyBackpressure()
+	assert.False(t, p.paused)
+	assert.False(t, input.Health().Paused)
+
+	// inputs are resumed if backpressure is disabled while they are paused
+	for i := 0; i < 9; i++ {
+		ro.AddMetric(testutil.TestMetric(i))
+	}
+	a.applyBackpressure()
+	assert.True(t, p.paused)
+	c.Agent.BackpressureHighWatermark = 0
+	a.applyBackpressure()
+	assert.False(t, p.paused)
+}
@@ -0,0 +1,33 @@
+package agent
+
+import (
+	"github.com/influxdata/telegraf/internal/cluster"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// startCluster starts checking the peers of the cluster, if one is
+// configured, until shutdown is closed.
+func (a *Agent) startCluster(shutdown chan struct{}) {
+	ac := a.Config.Agent
+	if len(ac.ClusterPeers) == 0 {
+		return
+	}
+	a.cluster = cluster.New(ac.ClusterSelf, ac.ClusterPeers)
+	go a.cluster.Run(ac.ClusterCheckInterval.Duration, shutdown)
+}
+
+// setCluster sets the cluster of the input, if it shards its targets.
+func (a *Agent) setCluster(input *internal_models.RunningInput) {
+	if p, ok := input.Input.(cluster.Sharded); ok {
+		p.SetCluster(a.cluster)
+	}
+}
+
+// ownsInput returns true if this agent gathers the input: if the input is not
+// sharded, or this agent owns it in the cluster.
+func (a *Agent) ownsInput(input *internal_models.RunningInput) bool {
+	if !input.Config.Shard {
+		return true
+	}
+	return a.cluster.Owns(input.Config.Fingerprint)
+}
@@ -0,0 +1,48 @@
+package agent
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/influxdata/telegraf/internal/cluster"
+	"github.com/influxdata/telegraf/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgent_OwnsInput(t *testing.T) {
+	peers := []string{"http://a:8126", "http://b:8126"}
+	agents := []*Agent{
+		{cluster: cluster.New(peers[0], peers)},
+		{cluster: cluster.New(peers[1], peers)},
+	}
+
+	for i := 0; i < 10; i++ {
+		input := &internal_models.RunningInput{
+			Name: "http_response",
+			Config: &internal_models.InputConfig{
+				Shard:       true,
+				Fingerprint: fmt.Sprintf("%x", i),
+			},
+		}
+		owners := 0
+		for _, a := range agents {
+			if a.ownsInput(input) {
+				owners++
+			}
+		}
+		assert.Equal(t, 1, owners)
+
+		input.Config.Shard = false
+		for _, a := range agents {
+			assert.True(t, a.ownsInput(input))
+		}
+	}
+
+	// without a cluster, sharded inputs are always gathered
+	a := &Agent{}
+	input := &internal_models.RunningInput{
+		Config: &internal_models.InputConfig{Shard: true},
+	}
+	assert.True(t, a.ownsInput(input))
+}
@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"log"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// setDeadLetters routes the metrics dropped by the outputs to the outputs
+// configured with dead_letter = true, tagged with the name of the output that
+// dropped them and the reason. Metrics dropped by a dead letter output are not
+// routed anywhere. a.mu must be held if the agent is running.
+func setDeadLetters(outputs []*internal_models.RunningOutput) {
+	var sinks []*internal_models.RunningOutput
+	for _, o := range outputs {
+		if o.Config.DeadLetter {
+			sinks = append(sinks, o)
+		}
+	}
+
+	// outputs are written concurrently, so their dead letters may be too
+	var mu sync.Mutex
+	for _, o := range outputs {
+		if o.Config.DeadLetter || len(sinks) == 0 {
+			o.DeadLetter = nil
+			continue
+		}
+
+		name := o.Name
+		o.DeadLetter = func(metric telegraf.Metric, reason string) {
+			tags := metric.Tags()
+			tags["dead_letter_output"] = name
+			tags["dead_letter_reason"] = reason
+			m, err := telegraf.NewMetric(metric.Name(), tags, metric.Fields(),
+				metric.Time())
+			if err != nil {
+				log.Printf("Error creating dead letter for output [%s]: %s\n",
+					name, err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			for _, sink := range sinks {
+				sink.AddMetric(m)
+			}
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// DrainSummary is the outcome of draining the agent.
+type DrainSummary struct {
+	Outputs []OutputDrain `json:"outputs"`
+	// Lost is the number of metrics that were dropped or left unwritten by
+	// the outputs during the drain.
+	Lost int64 `json:"lost"`
+	// TimedOut is true if the deadline passed before the outputs were
+	// drained.
+	TimedOut bool `json:"timed_out"`
+}
+
+// OutputDrain is the outcome of draining an output.
+type OutputDrain struct {
+	Name string `json:"name"`
+	// Unwritten is the number of metrics still buffered after the drain.
+	Unwritten int `json:"unwritten"`
+	// Dropped is the number of metrics dropped during the drain because the
+	// buffer was full.
+	Dropped int64 `json:"dropped"`
+}
+
+type drainRequest struct {
+	deadline time.Time
+	done     chan *DrainSummary
+}
+
+// drainRetry is the delay between writes to outputs that failed to write
+// during a drain.
+const drainRetry = time.Second
+
+// Drain stops gathering from all inputs, then writes the buffered metrics of
+// every output until they are empty or the drain_timeout of the agent passes.
+// The agent keeps running, without inputs, until shutdown.
+func (a *Agent) Drain() (*DrainSummary, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	a.mu.Lock()
+	if a.running == nil {
+		a.mu.Unlock()
+		return nil, errors.New("agent is not running")
+	}
+	deadline := time.Now().Add(a.Config.Agent.DrainTimeout.Duration)
+	for input := range a.running {
+		a.stopInput(input)
+	}
+	a.mu.Unlock()
+
+	// Wait for the inputs to stop, so that the metrics their services emit
+	// when stopped are drained too.
+	stopped := make(chan struct{})
+	go func() {
+		a.gatherers.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(deadline.Sub(time.Now())):
+		log.Printf("Timed out waiting for inputs to stop\n")
+	}
+
+	req := drainRequest{deadline: deadline, done: make(chan *DrainSummary, 1)}
+	select {
+	case a.drainC <- req:
+	case <-a.shutdown:
+		return nil, errors.New("agent is shutting down")
+	}
+	return <-req.done, nil
+}
+
+// drain adds the metrics left in metricC to the outputs, then writes the
+// outputs until their buffers are empty or the deadline passes. It is run by
+// the flusher.
+func (a *Agent) drain(
+	metricC chan telegraf.Metric,
+	deadline time.Time,
+) *DrainSummary {
+	a.mu.Lock()
+	outputs := a.Config.Outputs
+	before := make([]int64, len(outputs))
+	for i, o := range outputs {
+		before[i] = o.Health().Dropped
+	}
+	a.mu.Unlock()
+
+	for queued := true; queued; {
+		select {
+		case m := <-metricC:
+			a.mu.Lock()
+			routeMetric(a.Config, m)
+			a.mu.Unlock()
+		default:
+			queued = false
+		}
+	}
+
+	summary := &DrainSummary{Outputs: []OutputDrain{}}
+	for {
+		a.flush()
+		if unwritten(outputs) == 0 {
+			break
+		}
+		if time.Now().Add(drainRetry).After(deadline) {
+			summary.TimedOut = true
+			break
+		}
+		time.Sleep(drainRetry)
+	}
+
+	for i, o := range outputs {
+		h := o.Health()
+		d := OutputDrain{
+			Name:      o.Name,
+			Unwritten: h.BufferSize,
+			Dropped:   h.Dropped - before[i],
+		}
+		summary.Outputs = append(summary.Outputs, d)
+		summary.Lost += int64(d.Unwritten) + d.Dropped
+	}
+	return summary
+}
+
+// unwritten returns the number of metrics buffered by the given outputs.
+func unwritten(outputs []*internal_models.RunningOutput) int {
+	n := 0
+	for _, o := range outputs {
+		n += o.Health().BufferSize
+	}
+	return n
+}
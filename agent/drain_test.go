@@ -0,0 +1,72 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type drainOutput struct {
+	fail    bool
+	written int
+}
+
+func (o *drainOutput) Connect() error       { return nil }
+func (o *drainOutput) Close() error         { return nil }
+func (o *drainOutput) Description() string  { return "" }
+func (o *drainOutput) SampleConfig() string { return "" }
+func (o *drainOutput) Write(metrics []telegraf.Metric) error {
+	if o.fail {
+		return errors.New("unavailable")
+	}
+	o.written += len(metrics)
+	return nil
+}
+
+func TestAgent_Drain(t *testing.T) {
+	ok, down := &drainOutput{}, &drainOutput{fail: true}
+	c := config.NewConfig()
+	for name, o := range map[string]*drainOutput{"ok": ok, "down": down} {
+		ro := internal_models.NewRunningOutput(name, o,
+			&internal_models.OutputConfig{Name: name}, 0, 0)
+		ro.Quiet = true
+		c.Outputs = append(c.Outputs, ro)
+	}
+	a := &Agent{Config: c}
+
+	metricC := make(chan telegraf.Metric, 10)
+	metricC <- testutil.TestMetric(1)
+	metricC <- testutil.TestMetric(2)
+
+	// the deadline has passed, so failed writes are not retried
+	summary := a.drain(metricC, time.Now())
+	assert.Equal(t, 2, ok.written)
+	assert.True(t, summary.TimedOut)
+	assert.Equal(t, int64(2), summary.Lost)
+	for _, o := range summary.Outputs {
+		if o.Name == "down" {
+			assert.Equal(t, 2, o.Unwritten)
+		} else {
+			assert.Equal(t, 0, o.Unwritten)
+		}
+	}
+
+	down.fail = false
+	summary = a.drain(metricC, time.Now())
+	assert.False(t, summary.TimedOut)
+	assert.Equal(t, int64(0), summary.Lost)
+	assert.Equal(t, 2, down.written)
+}
+
+func TestAgent_DrainNotRunning(t *testing.T) {
+	a := &Agent{Config: config.NewConfig()}
+	_, err := a.Drain()
+	assert.Error(t, err)
+}
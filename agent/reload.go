@@ -0,0 +1,316 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/experimental"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// ReloadSummary lists the plugins that were started and stopped by a reload.
+// A plugin whose configuration changed is stopped and started again.
+type ReloadSummary struct {
+	InputsStarted  []string `json:"inputs_started"`
+	InputsStopped  []string `json:"inputs_stopped"`
+	OutputsStarted []string `json:"outputs_started"`
+	OutputsStopped []string `json:"outputs_stopped"`
+}
+
+// ReloadConfig loads a new configuration with ConfigLoader and applies it
+// with Reload.
+func (a *Agent) ReloadConfig() (*ReloadSummary, error) {
+	if a.ConfigLoader == nil {
+		return nil, errors.New("no configuration loader set, cannot reload")
+	}
+	c, err := a.ConfigLoader()
+	if err != nil {
+		return nil, err
+	}
+	return a.Reload(c)
+}
+
+// Reload applies the given configuration to the running agent. Inputs and
+// outputs that were configured identically in the old and new configuration
+// keep running, so metrics buffered for unchanged outputs are kept. Removed
+// outputs are flushed before they are closed.
+//
+// Changes to the [agent] section or the global tags restart all inputs. The
+// experimental flags are applied in place.
+// Outputs are only restarted if their own configuration, or the batch size,
+// buffer limit or buffer compression, changed.
+//
+// Old service inputs with the same name as a new one are stopped before it
+// starts, so that it can listen where they did. If a new output fails to
+// connect, or a new service input fails to start, the reload is aborted, the
+// old service inputs stopped are restarted and the running configuration is
+// left untouched.
+func (a *Agent) Reload(c *config.Config) (*ReloadSummary, error) {
+	a.reloadMu.Lock()
+	defer a.reloadMu.Unlock()
+
+	if err := setHostTag(c); err != nil {
+		return nil, err
+	}
+	if err := experimental.Check(c.Experimental); err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	if a.running == nil {
+		a.mu.Unlock()
+		return nil, errors.New("agent is not running")
+	}
+	select {
+	case <-a.shutdown:
+		a.mu.Unlock()
+		return nil, errors.New("agent is shutting down")
+	default:
+	}
+	old := a.Config
+	a.mu.Unlock()
+
+	if old.Agent.AdminAddress != c.Agent.AdminAddress {
+		log.Printf("admin_address changed, restart telegraf to apply it\n")
+	}
+	if old.Agent.StateStore != c.Agent.StateStore ||
+		old.Agent.StateMaxSize != c.Agent.StateMaxSize {
+		log.Printf("state_store changed, restart telegraf to apply it\n")
+	}
+	if !reflect.DeepEqual(old.Agent.ClusterPeers, c.Agent.ClusterPeers) ||
+		old.Agent.ClusterSelf != c.Agent.ClusterSelf ||
+		old.Agent.ClusterCheckInterval != c.Agent.ClusterCheckInterval {
+		log.Printf("cluster changed, restart telegraf to apply it\n")
+	}
+	if old.Agent.OTLPEndpoint != c.Agent.OTLPEndpoint ||
+		old.Agent.OTLPServiceName != c.Agent.OTLPServiceName {
+		log.Printf("otlp_endpoint changed, restart telegraf to apply it\n")
+	}
+	if old.Agent.PluginUsage != c.Agent.PluginUsage {
+		log.Printf("plugin_usage changed, restart telegraf to apply it\n")
+	}
+	restartInputs := !reflect.DeepEqual(old.Agent, c.Agent) ||
+		!reflect.DeepEqual(old.Tags, c.Tags)
+
+	// Pair up the new plugins with the old ones that are configured the same.
+	// Plugins that could not be paired are either new or removed.
+	outputs, newOutputs, oldOutputs := matchOutputs(old.Outputs, c.Outputs)
+	var inputs, newInputs, oldInputs []*internal_models.RunningInput
+	if restartInputs {
+		inputs, newInputs, oldInputs = c.Inputs, c.Inputs, old.Inputs
+	} else {
+		inputs, newInputs, oldInputs = matchInputs(old.Inputs, c.Inputs)
+	}
+
+	// Connect new outputs and start new services before touching the running
+	// plugins, so that any failure leaves the agent as it was.
+	for i, o := range newOutputs {
+		if err := connectOutput(o, c.Agent); err != nil {
+			for _, connected := range newOutputs[:i] {
+				closeOutput(connected)
+			}
+			return nil, fmt.Errorf("output %s: %s", o.LogName(), err)
+		}
+	}
+	// The services of old inputs replaced by new ones are stopped first, since
+	// they may hold what the new ones need, such as the port they listen on.
+	var started, replaced []*internal_models.RunningInput
+	for _, input := range newInputs {
+		if len(input.Config.StartAfter) > 0 {
+			continue
+		}
+		replaced = append(replaced, a.stopReplaced(input, oldInputs)...)
+		if err := a.startService(c, input); err != nil {
+			for _, input := range started {
+				stopService(input)
+			}
+			a.restartReplaced(old, replaced)
+			for _, o := range newOutputs {
+				closeOutput(o)
+			}
+			return nil, fmt.Errorf("input %s: %s", input.LogName(), err)
+		}
+		started = append(started, input)
+	}
+
+	summary := &ReloadSummary{
+		InputsStarted:  []string{},
+		InputsStopped:  []string{},
+		OutputsStarted: []string{},
+		OutputsStopped: []string{},
+	}
+
+	a.mu.Lock()
+	for _, input := range oldInputs {
+		a.stopInput(input)
+		summary.InputsStopped = append(summary.InputsStopped, input.Name)
+	}
+	for _, o := range oldOutputs {
+		if err := o.Write(); err != nil {
+			log.Printf("Error writing to output [%s]: %s\n", o.LogName(), err)
+		}
+		closeOutput(o)
+		summary.OutputsStopped = append(summary.OutputsStopped, o.Name)
+	}
+
+	c.Inputs = inputs
+	c.Outputs = outputs
+	a.Config = c
+	setDeadLetters(c.Outputs)
+	internal_models.SetTracer(c.Tracer)
+	experimental.Set(c.Experimental)
+	internal_models.SetRunning(c.Inputs, c.Outputs)
+
+	for _, input := range newInputs {
+		if len(input.Config.StartAfter) > 0 {
+			a.startAfter(c, input)
+		} else {
+			a.startInput(c, input)
+		}
+		summary.InputsStarted = append(summary.InputsStarted, input.Name)
+	}
+	for _, o := range newOutputs {
+		summary.OutputsStarted = append(summary.OutputsStarted, o.Name)
+	}
+	a.mu.Unlock()
+
+	// let the flusher pick up a new flush interval
+	select {
+	case a.reloaded <- struct{}{}:
+	default:
+	}
+
+	log.Printf("Reloaded config, inputs started: %v stopped: %v, "+
+		"outputs started: %v stopped: %v\n",
+		summary.InputsStarted, summary.InputsStopped,
+		summary.OutputsStarted, summary.OutputsStopped)
+	return summary, nil
+}
+
+// stopReplaced stops the service inputs in prev that have the same name as a
+// new service input, and waits for their services to stop. It returns those stopped.
+func (a *Agent) stopReplaced(
+	input *internal_models.RunningInput,
+	prev []*internal_models.RunningInput,
+) []*internal_models.RunningInput {
+	if _, ok := input.Input.(telegraf.ServiceInput); !ok {
+		return nil
+	}
+	var stopped []*internal_models.RunningInput
+	for _, p := range prev {
+		if p.Name != input.Name {
+			continue
+		}
+		if _, ok := p.Input.(telegraf.ServiceInput); !ok {
+			continue
+		}
+		a.mu.Lock()
+		done, ok := a.stopped[p]
+		a.stopInput(p)
+		a.mu.Unlock()
+		if !ok {
+			// already stopped for a previous new input of the name
+			continue
+		}
+		<-done
+		stopped = append(stopped, p)
+	}
+	return stopped
+}
+
+// restartReplaced restarts the old inputs stopped by stopReplaced when a
+// reload is aborted.
+func (a *Agent) restartReplaced(
+	c *config.Config,
+	inputs []*internal_models.RunningInput,
+) {
+	for _, input := range inputs {
+		if len(input.Config.StartAfter) > 0 {
+			a.mu.Lock()
+			a.startAfter(c, input)
+			a.mu.Unlock()
+			continue
+		}
+		if err := a.startService(c, input); err != nil {
+			log.Printf("ERROR: input [%s] could not be restarted: %s\n",
+				input.LogName(), err)
+			continue
+		}
+		a.mu.Lock()
+		a.startInput(c, input)
+		a.mu.Unlock()
+	}
+}
+
+// matchInputs pairs each input of next with an identically configured input
+// of prev. It returns the inputs to run, in the order of next, with paired
+// inputs replaced by their running instance from prev. It also returns the
+// inputs of next that need to be started and those of prev that need to be
+// stopped.
+func matchInputs(
+	prev []*internal_models.RunningInput,
+	next []*internal_models.RunningInput,
+) (inputs, started, stopped []*internal_models.RunningInput) {
+	used := make([]bool, len(prev))
+	for _, n := range next {
+		match := -1
+		for i, p := range prev {
+			if !used[i] && p.Name == n.Name &&
+				p.Config.Fingerprint == n.Config.Fingerprint {
+				match = i
+				break
+			}
+		}
+		if match < 0 {
+			inputs = append(inputs, n)
+			started = append(started, n)
+			continue
+		}
+		used[match] = true
+		inputs = append(inputs, prev[match])
+	}
+	for i, p := range prev {
+		if !used[i] {
+			stopped = append(stopped, p)
+		}
+	}
+	return inputs, started, stopped
+}
+
+// matchOutputs is the output version of matchInputs.
+func matchOutputs(
+	prev []*internal_models.RunningOutput,
+	next []*internal_models.RunningOutput,
+) (outputs, started, stopped []*internal_models.RunningOutput) {
+	used := make([]bool, len(prev))
+	for _, n := range next {
+		match := -1
+		for i, p := range prev {
+			if !used[i] && p.Name == n.Name &&
+				p.Config.Fingerprint == n.Config.Fingerprint &&
+				p.MetricBatchSize == n.MetricBatchSize &&
+				p.MetricBufferLimit == n.MetricBufferLimit &&
+				p.BufferCompressed == n.BufferCompressed {
+				match = i
+				break
+			}
+		}
+		if match < 0 {
+			outputs = append(outputs, n)
+			started = append(started, n)
+			continue
+		}
+		used[match] = true
+		outputs = append(outputs, prev[match])
+	}
+	for i, p := range prev {
+		if !used[i] {
+			stopped = append(stopped, p)
+		}
+	}
+	return outputs, started, stopped
+}
@@ -0,0 +1,151 @@
+package agent
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestInput(name, fingerprint string) *internal_models.RunningInput {
+	return &internal_models.RunningInput{
+		Name: name,
+		Config: &internal_models.InputConfig{
+			Name:        name,
+			Fingerprint: fingerprint,
+		},
+	}
+}
+
+func TestReload_MatchInputs(t *testing.T) {
+	cpu := newTestInput("cpu", "a")
+	mem := newTestInput("mem", "b")
+	disk := newTestInput("disk", "c")
+	prev := []*internal_models.RunningInput{cpu, mem, disk}
+
+	newCPU := newTestInput("cpu", "a")
+	newMem := newTestInput("mem", "changed")
+	net := newTestInput("net", "d")
+	next := []*internal_models.RunningInput{net, newCPU, newMem}
+
+	inputs, started, stopped := matchInputs(prev, next)
+	// the unchanged cpu input keeps its running instance
+	assert.Equal(t, []*internal_models.RunningInput{net, cpu, newMem}, inputs)
+	assert.Equal(t, []*internal_models.RunningInput{net, newMem}, started)
+	assert.Equal(t, []*internal_models.RunningInput{mem, disk}, stopped)
+}
+
+func TestReload_MatchInputsDuplicates(t *testing.T) {
+	// two identically configured inputs must each be paired only once
+	a1 := newTestInput("exec", "a")
+	a2 := newTestInput("exec", "a")
+	prev := []*internal_models.RunningInput{a1, a2}
+
+	b1 := newTestInput("exec", "a")
+	next := []*internal_models.RunningInput{b1}
+
+	inputs, started, stopped := matchInputs(prev, next)
+	assert.Equal(t, []*internal_models.RunningInput{a1}, inputs)
+	assert.Empty(t, started)
+	assert.Equal(t, []*internal_models.RunningInput{a2}, stopped)
+}
+
+func TestReload_MatchOutputs(t *testing.T) {
+	newOutput := func(fingerprint string, batchSize int) *internal_models.RunningOutput {
+		return internal_models.NewRunningOutput("influxdb", nil,
+			&internal_models.OutputConfig{
+				Name:        "influxdb",
+				Fingerprint: fingerprint,
+			}, batchSize, 0)
+	}
+	kept := newOutput("a", 0)
+	resized := newOutput("b", 0)
+	prev := []*internal_models.RunningOutput{kept, resized}
+
+	next := []*internal_models.RunningOutput{newOutput("a", 0), newOutput("b", 5)}
+
+	outputs, started, stopped := matchOutputs(prev, next)
+	assert.Equal(t, []*internal_models.RunningOutput{kept, next[1]}, outputs)
+	assert.Equal(t, []*internal_models.RunningOutput{next[1]}, started)
+	assert.Equal(t, []*internal_models.RunningOutput{resized}, stopped)
+}
+
+// listenerInput is a service input listening on an address, like statsd.
+type listenerInput struct {
+	addr     string
+	fail     bool
+	listener net.Listener
+}
+
+func (l *listenerInput) Description() string                 { return "" }
+func (l *listenerInput) SampleConfig() string                { return "" }
+func (l *listenerInput) Gather(_ telegraf.Accumulator) error { return nil }
+func (l *listenerInput) Start(_ telegraf.Accumulator) error {
+	if l.fail {
+		return errors.New("failed to start")
+	}
+	var err error
+	l.listener, err = net.Listen("tcp", l.addr)
+	return err
+}
+func (l *listenerInput) Stop() {
+	l.listener.Close()
+}
+
+func newListener(addr, fingerprint string, fail bool) *internal_models.RunningInput {
+	input := newTestInput("tcp_listener", fingerprint)
+	input.Input = &listenerInput{addr: addr, fail: fail}
+	return input
+}
+
+func TestReload_ServiceInputListening(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	c := config.NewConfig()
+	c.Inputs = append(c.Inputs, newListener(addr, "a", false))
+	a := &Agent{
+		Config:   c,
+		metricC:  make(chan telegraf.Metric, 10),
+		shutdown: make(chan struct{}),
+		running:  make(map[*internal_models.RunningInput]chan struct{}),
+		stopped:  make(map[*internal_models.RunningInput]chan struct{}),
+	}
+	defer stopAgent(a)
+	require.NoError(t, a.startService(c, c.Inputs[0]))
+	a.mu.Lock()
+	a.startInput(c, c.Inputs[0])
+	a.mu.Unlock()
+
+	// the changed input listens on the address the running one holds
+	next := config.NewConfig()
+	next.Inputs = append(next.Inputs, newListener(addr, "b", false))
+	summary, err := a.Reload(next)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"tcp_listener"}, summary.InputsStarted)
+	assert.Equal(t, []string{"tcp_listener"}, summary.InputsStopped)
+	assert.Equal(t, next.Inputs, a.Config.Inputs)
+
+	// the running input is restarted if the new one fails to start
+	failing := config.NewConfig()
+	failing.Inputs = append(failing.Inputs, newListener(addr, "c", true))
+	_, err = a.Reload(failing)
+	assert.Error(t, err)
+	assert.Equal(t, next.Inputs, a.Config.Inputs)
+	a.mu.Lock()
+	_, running := a.running[next.Inputs[0]]
+	a.mu.Unlock()
+	assert.True(t, running)
+	conn, err := net.Dial("tcp", addr)
+	if assert.NoError(t, err) {
+		conn.Close()
+	}
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// routeMetric adds a gathered metric to the outputs it is routed to:
+//
+//   - none, if it matches the route_drop of the agent
+//   - outputs without a route_match, always
+//   - outputs whose route_match it matches
+//   - outputs with route_default, if it matched no route_match
+//
+// Dead letter outputs never receive gathered metrics. a.mu must be held.
+func routeMetric(c *config.Config, metric telegraf.Metric) {
+	if c.RouteDrop.Match(metric) {
+		internal_models.Tracef(metric, "dropped by route_drop")
+		return
+	}
+
+	routed := false
+	for _, o := range c.Outputs {
+		if o.Config.DeadLetter || o.Config.Route == nil {
+			continue
+		}
+		if o.Config.Route.Match(metric) {
+			internal_models.Tracef(metric, "routed to output [%s]", o.LogName())
+			o.AddMetric(metric)
+			routed = true
+		}
+	}
+
+	for _, o := range c.Outputs {
+		switch {
+		case o.Config.DeadLetter:
+		case o.Config.Route == nil && !o.Config.RouteDefault:
+			o.AddMetric(metric)
+		case o.Config.RouteDefault && !routed:
+			internal_models.Tracef(metric, "routed to default output [%s]",
+				o.LogName())
+			o.AddMetric(metric)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package agent
+
+import (
+	"log"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/otlp"
+)
+
+// spanExportInterval is how often the spans of the agent are exported.
+const spanExportInterval = 5 * time.Second
+
+// startSpans starts exporting the spans of the agent, if an OTLP endpoint is
+// configured.
+func (a *Agent) startSpans(shutdown chan struct{}) {
+	ac := a.Config.Agent
+	if ac.OTLPEndpoint == "" {
+		return
+	}
+	a.spans = otlp.NewExporter(ac.OTLPEndpoint, ac.OTLPServiceName)
+	go a.spans.Run(spanExportInterval, shutdown)
+}
+
+// flushSpans exports the spans not exported yet, once the agent stopped.
+func (a *Agent) flushSpans() {
+	if err := a.spans.Flush(); err != nil {
+		log.Printf("ERROR exporting spans: %s\n", err)
+	}
+}
+
+// gatherSpan gathers from the given input with gatherWithTimeout, recording
+// the gather as a span.
+func (a *Agent) gatherSpan(
+	shutdown chan struct{},
+	input *internal_models.RunningInput,
+	acc *accumulator,
+	timeout time.Duration,
+) error {
+	span := a.spans.Start("gather")
+	span.SetAttribute("input", input.Name)
+	err := gatherWithTimeout(shutdown, input, acc, timeout)
+	span.SetError(err)
+	span.End()
+	return err
+}
@@ -0,0 +1,154 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// defaultStartTimeout is how long an input waits for its start_after
+// dependencies if start_timeout is not set.
+const defaultStartTimeout = time.Minute
+
+// readyC returns the channel closed once the input is ready: once its service
+// started, or it gathered successfully.
+func (a *Agent) readyC(input *internal_models.RunningInput) chan struct{} {
+	a.readyMu.Lock()
+	defer a.readyMu.Unlock()
+	if a.ready == nil {
+		a.ready = make(map[*internal_models.RunningInput]chan struct{})
+	}
+	c, ok := a.ready[input]
+	if !ok {
+		c = make(chan struct{})
+		a.ready[input] = c
+	}
+	return c
+}
+
+// setReady marks the input as ready.
+func (a *Agent) setReady(input *internal_models.RunningInput) {
+	c := a.readyC(input)
+	a.readyMu.Lock()
+	defer a.readyMu.Unlock()
+	select {
+	case <-c:
+	default:
+		close(c)
+	}
+}
+
+// forgetReady drops the readiness of a stopped input.
+func (a *Agent) forgetReady(input *internal_models.RunningInput) {
+	a.readyMu.Lock()
+	defer a.readyMu.Unlock()
+	delete(a.ready, input)
+}
+
+// waitStartAfter waits until the start_after dependencies of the input are
+// ready, and returns an error naming those that are not if they are not
+// ready within its start_timeout. Outputs are connected before any input
+// starts, so they are always ready. It returns false if stop is closed
+// while waiting.
+func (a *Agent) waitStartAfter(
+	c *config.Config,
+	input *internal_models.RunningInput,
+	stop chan struct{},
+) (bool, error) {
+	timeout := input.Config.StartTimeout
+	if timeout <= 0 {
+		timeout = defaultStartTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for _, dep := range input.Config.StartAfter {
+		if !strings.HasPrefix(dep, "inputs.") {
+			continue
+		}
+		name := strings.TrimPrefix(dep, "inputs.")
+		for _, other := range c.Inputs {
+			if other.Name != name || other == input {
+				continue
+			}
+			select {
+			case <-a.readyC(other):
+			case <-stop:
+				return false, nil
+			case <-timer.C:
+				return true, fmt.Errorf("%s not ready after %s", dep, timeout)
+			}
+		}
+	}
+	return true, nil
+}
+
+// startAfter starts an input with start_after dependencies once they are
+// ready, in the background. The input counts as running while it waits, so
+// that it can be stopped. a.mu must be held.
+func (a *Agent) startAfter(c *config.Config, input *internal_models.RunningInput) {
+	stop := make(chan struct{})
+	a.running[input] = stop
+	done := make(chan struct{})
+	a.stopped[input] = done
+	log.Printf("Input [%s] waiting for %s before starting\n", input.LogName(),
+		strings.Join(input.Config.StartAfter, ", "))
+
+	a.gatherers.Add(1)
+	go func() {
+		defer a.gatherers.Done()
+		defer close(done)
+		ok, err := a.waitStartAfter(c, input, stop)
+		if !ok {
+			return
+		}
+		if err != nil {
+			switch input.Config.StartFailure {
+			case internal_models.StartSkip:
+				log.Printf("ERROR: input [%s]: %s, not starting it\n",
+					input.LogName(), err)
+				return
+			case internal_models.StartExit:
+				log.Printf("ERROR: input [%s]: %s, exiting\n", input.LogName(),
+					err)
+				a.exit()
+				return
+			default:
+				log.Printf("ERROR: input [%s]: %s, starting it anyway\n",
+					input.LogName(), err)
+			}
+		}
+
+		if err := a.startService(c, input); err != nil {
+			a.exit()
+			return
+		}
+		a.mu.Lock()
+		if a.running[input] != stop {
+			// stopped while starting
+			a.mu.Unlock()
+			stopService(input)
+			return
+		}
+		if a.paused {
+			pauseInput(input, true)
+		}
+		a.mu.Unlock()
+		a.runInput(c, input, stop)
+	}()
+}
+
+// exit shuts the agent down, unless it is already shutting down.
+func (a *Agent) exit() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	select {
+	case <-a.shutdown:
+	default:
+		close(a.shutdown)
+	}
+}
@@ -0,0 +1,159 @@
+package agent
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type serviceInput struct {
+	started chan struct{}
+}
+
+func (s *serviceInput) Description() string                 { return "" }
+func (s *serviceInput) SampleConfig() string                { return "" }
+func (s *serviceInput) Gather(_ telegraf.Accumulator) error { return nil }
+func (s *serviceInput) Start(_ telegraf.Accumulator) error {
+	close(s.started)
+	return nil
+}
+func (s *serviceInput) Stop() {}
+
+// startAfterAgent returns a running agent with a redis input, and a statsd
+// service input starting after it.
+func startAfterAgent(
+	timeout time.Duration,
+	failure string,
+) (*Agent, *internal_models.RunningInput, *serviceInput) {
+	c := config.NewConfig()
+	redis := &internal_models.RunningInput{
+		Name:   "redis",
+		Input:  &statefulInput{},
+		Config: &internal_models.InputConfig{Name: "redis"},
+	}
+	p := &serviceInput{started: make(chan struct{})}
+	statsd := &internal_models.RunningInput{
+		Name:  "statsd",
+		Input: p,
+		Config: &internal_models.InputConfig{
+			Name:         "statsd",
+			StartAfter:   []string{"inputs.redis"},
+			StartTimeout: timeout,
+			StartFailure: failure,
+		},
+	}
+	c.Inputs = append(c.Inputs, redis, statsd)
+	a := &Agent{
+		Config:   c,
+		metricC:  make(chan telegraf.Metric, 10),
+		shutdown: make(chan struct{}),
+		running:  make(map[*internal_models.RunningInput]chan struct{}),
+		stopped:  make(map[*internal_models.RunningInput]chan struct{}),
+	}
+	return a, redis, p
+}
+
+func stopAgent(a *Agent) {
+	a.exit()
+	a.mu.Lock()
+	for input := range a.running {
+		a.stopInput(input)
+	}
+	a.mu.Unlock()
+	a.gatherers.Wait()
+}
+
+func TestAgent_StartAfter(t *testing.T) {
+	a, redis, statsd := startAfterAgent(time.Minute, "")
+	defer stopAgent(a)
+
+	a.mu.Lock()
+	a.startAfter(a.Config, a.Config.Inputs[1])
+	a.mu.Unlock()
+
+	select {
+	case <-statsd.started:
+		t.Fatal("statsd started before redis was ready")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	a.setReady(redis)
+	select {
+	case <-statsd.started:
+	case <-time.After(time.Second):
+		t.Fatal("statsd did not start once redis was ready")
+	}
+}
+
+func TestAgent_StartAfterTimeout(t *testing.T) {
+	// started anyway
+	a, _, statsd := startAfterAgent(10*time.Millisecond, "")
+	a.mu.Lock()
+	a.startAfter(a.Config, a.Config.Inputs[1])
+	a.mu.Unlock()
+	select {
+	case <-statsd.started:
+	case <-time.After(time.Second):
+		t.Fatal("statsd was not started after the timeout")
+	}
+	stopAgent(a)
+
+	// skipped
+	a, _, statsd = startAfterAgent(10*time.Millisecond,
+		internal_models.StartSkip)
+	a.mu.Lock()
+	a.startAfter(a.Config, a.Config.Inputs[1])
+	a.mu.Unlock()
+	select {
+	case <-statsd.started:
+		t.Fatal("statsd was started after the timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+	stopAgent(a)
+
+	// exited
+	a, _, statsd = startAfterAgent(10*time.Millisecond,
+		internal_models.StartExit)
+	a.mu.Lock()
+	a.startAfter(a.Config, a.Config.Inputs[1])
+	a.mu.Unlock()
+	select {
+	case <-a.shutdown:
+	case <-time.After(time.Second):
+		t.Fatal("agent did not exit after the timeout")
+	}
+	stopAgent(a)
+	select {
+	case <-statsd.started:
+		t.Fatal("statsd was started after the timeout")
+	default:
+	}
+}
+
+func TestAgent_ReadyAfterStart(t *testing.T) {
+	a, _, statsd := startAfterAgent(time.Minute, "")
+	input := a.Config.Inputs[1]
+	require.NoError(t, a.startService(a.Config, input))
+	<-statsd.started
+	select {
+	case <-a.readyC(input):
+	default:
+		t.Fatal("service input is not ready once started")
+	}
+
+	a.mu.Lock()
+	a.stopInput(input)
+	a.mu.Unlock()
+	select {
+	case <-a.readyC(input):
+		t.Fatal("stopped input is still ready")
+	default:
+	}
+	assert.Len(t, a.ready, 1)
+}
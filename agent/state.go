@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/state"
+)
+
+// openState opens the state store of the agent, if one is configured.
+func (a *Agent) openState() error {
+	ac := a.Config.Agent
+	if ac.StateStore == "" {
+		return nil
+	}
+	s, err := state.Open(ac.StateStore, ac.StateMaxSize)
+	if err != nil {
+		return fmt.Errorf("opening state store: %s", err)
+	}
+	a.state = s
+	return nil
+}
+
+// setState sets the state of the input, if it is stateful and a state store
+// is configured.
+func (a *Agent) setState(input *internal_models.RunningInput) error {
+	p, ok := input.Input.(state.Stateful)
+	if !ok || a.state == nil {
+		return nil
+	}
+	st, err := a.state.State(input.Config.StateKey)
+	if err != nil {
+		return err
+	}
+	p.SetState(st)
+	return nil
+}
+
+// commitState saves the state of the plugins. It is called after the outputs
+// are flushed, so that the saved state never runs ahead of the written
+// metrics.
+func (a *Agent) commitState() {
+	if a.state == nil {
+		return
+	}
+	if err := a.state.Commit(); err != nil {
+		log.Printf("Error saving plugin state: %s\n", err)
+	}
+}
+
+// closeState saves the state of the plugins and closes the state store.
+func (a *Agent) closeState() {
+	if a.state == nil {
+		return
+	}
+	if err := a.state.Close(); err != nil {
+		log.Printf("Error saving plugin state: %s\n", err)
+	}
+}
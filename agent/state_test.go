@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/state"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type statefulInput struct {
+	state *state.State
+}
+
+func (s *statefulInput) Description() string                 { return "" }
+func (s *statefulInput) SampleConfig() string                { return "" }
+func (s *statefulInput) Gather(_ telegraf.Accumulator) error { return nil }
+func (s *statefulInput) SetState(st *state.State)            { s.state = st }
+
+func TestAgent_State(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	c := config.NewConfig()
+	c.Agent.StateStore = "file://" + dir
+	p := &statefulInput{}
+	input := &internal_models.RunningInput{
+		Name:   "sql",
+		Input:  p,
+		Config: &internal_models.InputConfig{StateKey: "inputs.sql"},
+	}
+	a := &Agent{Config: c}
+	require.NoError(t, a.openState())
+	require.NoError(t, a.startService(c, input))
+	require.NotNil(t, p.state)
+	assert.Equal(t, "inputs.sql", p.state.Namespace())
+
+	// the state is saved by commitState, not by the input
+	require.NoError(t, p.state.Set("high_water_mark", "1042"))
+	a.commitState()
+	a.closeState()
+
+	store, err := state.Open(c.Agent.StateStore, 0)
+	require.NoError(t, err)
+	st, err := store.State("inputs.sql")
+	require.NoError(t, err)
+	v, _ := st.Get("high_water_mark")
+	assert.Equal(t, "1042", v)
+
+	// without a store, stateful inputs get no state
+	p = &statefulInput{}
+	input.Input = p
+	a = &Agent{Config: config.NewConfig()}
+	require.NoError(t, a.openState())
+	require.NoError(t, a.startService(a.Config, input))
+	assert.Nil(t, p.state)
+}
@@ -0,0 +1,20 @@
+package agent
+
+import (
+	"time"
+
+	"github.com/influxdata/telegraf/internal/usage"
+)
+
+// usageWindow is the length of the CPU profiles that plugin usage is sampled
+// from.
+const usageWindow = 10 * time.Second
+
+// startUsage starts sampling the CPU time and memory of each plugin, if
+// plugin_usage is enabled.
+func (a *Agent) startUsage(shutdown chan struct{}) {
+	if !a.Config.Agent.PluginUsage {
+		return
+	}
+	go usage.Run(usageWindow, shutdown)
+}
@@ -0,0 +1,124 @@
+package agent
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// ValidationReport is the outcome of validating a configuration.
+type ValidationReport struct {
+	// Valid is true if the configuration loaded and every probed plugin
+	// passed.
+	Valid bool `json:"valid"`
+	// Error is the error loading the configuration, if any.
+	Error   string             `json:"error,omitempty"`
+	Plugins []PluginValidation `json:"plugins"`
+}
+
+// PluginValidation is the outcome of validating a plugin.
+type PluginValidation struct {
+	// Type is either "input" or "output".
+	Type string `json:"type"`
+	Name string `json:"name"`
+	// Probed is true if the plugin was started or connected.
+	Probed bool `json:"probed"`
+	// Duration is how long the probe took, in nanoseconds.
+	Duration time.Duration `json:"duration_ns,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Validate reports on the plugins of the agent's configuration, which was
+// loaded successfully. If probe is true, each output is connected then
+// closed, each service input is started then stopped, and every other input
+// gathers once, as with Test; a probe failing or lasting longer than timeout
+// invalidates the configuration. The agent does not run.
+func (a *Agent) Validate(probe bool, timeout time.Duration) *ValidationReport {
+	report := &ValidationReport{
+		Valid:   true,
+		Plugins: []PluginValidation{},
+	}
+	add := func(typ, name string, f func() error) {
+		v := PluginValidation{Type: typ, Name: name}
+		if probe {
+			start := time.Now()
+			err := withTimeout(f, timeout)
+			v.Probed = true
+			v.Duration = time.Since(start)
+			if err != nil {
+				v.Error = err.Error()
+				report.Valid = false
+			}
+		}
+		report.Plugins = append(report.Plugins, v)
+	}
+
+	for _, o := range a.Config.Outputs {
+		add("output", o.Name, func() error { return probeOutput(o) })
+	}
+	for _, input := range a.Config.Inputs {
+		add("input", input.Name, func() error { return a.probeInput(input) })
+	}
+	return report
+}
+
+// withTimeout runs f, and returns its error, or an error if it does not
+// return within timeout. f is left running in that case.
+func withTimeout(f func() error, timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// probeOutput connects the output, without retrying, then closes it.
+func probeOutput(o *internal_models.RunningOutput) error {
+	if ot, ok := o.Output.(telegraf.ServiceOutput); ok {
+		if err := ot.Start(); err != nil {
+			return err
+		}
+	}
+	if err := o.Output.Connect(); err != nil {
+		if ot, ok := o.Output.(telegraf.ServiceOutput); ok {
+			ot.Stop()
+		}
+		return err
+	}
+	return closeOutput(o)
+}
+
+// probeInput starts then stops a service input, or gathers once from any
+// other input. The gathered metrics are discarded.
+func (a *Agent) probeInput(input *internal_models.RunningInput) error {
+	metricC := make(chan telegraf.Metric, 100)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-metricC:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	acc := NewAccumulator(input.Config, metricC)
+	acc.setDefaultTags(a.Config.Tags)
+	if p, ok := input.Input.(telegraf.ServiceInput); ok {
+		if err := p.Start(acc); err != nil {
+			return err
+		}
+		p.Stop()
+		return nil
+	}
+	return input.Input.Gather(acc)
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type refusingOutput struct {
+	drainOutput
+}
+
+func (o *refusingOutput) Connect() error { return errors.New("refused") }
+
+type probedInput struct {
+	err   error
+	delay time.Duration
+}
+
+func (p *probedInput) Description() string  { return "" }
+func (p *probedInput) SampleConfig() string { return "" }
+func (p *probedInput) Gather(acc telegraf.Accumulator) error {
+	time.Sleep(p.delay)
+	acc.AddFields("probe", map[string]interface{}{"value": 1}, nil)
+	return p.err
+}
+
+func TestAgent_Validate(t *testing.T) {
+	c := config.NewConfig()
+	for name, o := range map[string]telegraf.Output{
+		"ok":      &drainOutput{},
+		"refused": &refusingOutput{},
+	} {
+		c.Outputs = append(c.Outputs, internal_models.NewRunningOutput(name, o,
+			&internal_models.OutputConfig{Name: name}, 0, 0))
+	}
+	for name, p := range map[string]*probedInput{
+		"ok":     {},
+		"failed": {err: errors.New("unreachable")},
+		"hung":   {delay: time.Second},
+	} {
+		c.Inputs = append(c.Inputs, &internal_models.RunningInput{
+			Name:   name,
+			Input:  p,
+			Config: &internal_models.InputConfig{Name: name},
+		})
+	}
+	a, _ := NewAgent(c)
+
+	report := a.Validate(false, time.Second)
+	assert.True(t, report.Valid)
+	assert.Len(t, report.Plugins, 5)
+	for _, p := range report.Plugins {
+		assert.False(t, p.Probed)
+		assert.Empty(t, p.Error)
+	}
+
+	report = a.Validate(true, 100*time.Millisecond)
+	assert.False(t, report.Valid)
+	errs := map[string]string{}
+	for _, p := range report.Plugins {
+		assert.True(t, p.Probed)
+		errs[p.Type+"."+p.Name] = p.Error
+	}
+	assert.Equal(t, map[string]string{
+		"output.ok":      "",
+		"output.refused": "refused",
+		"input.ok":       "",
+		"input.failed":   "unreachable",
+		"input.hung":     "timed out after 100ms",
+	}, errs)
+}
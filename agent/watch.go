@@ -0,0 +1,147 @@
+package agent
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/config"
+	"golang.org/x/crypto/ed25519"
+)
+
+// WatchConfig polls the given config files and directories every interval,
+// and reloads the configuration with ReloadConfig once they changed, then
+// stayed unchanged for debounce, so that a tool rewriting several files
+// triggers a single reload. A configuration that fails to load or to apply is
+// logged and the running one is kept, until the files change again. It
+// returns when shutdown is closed.
+func (a *Agent) WatchConfig(
+	paths []string,
+	interval time.Duration,
+	debounce time.Duration,
+	shutdown chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last := snapshotConfig(paths)
+	var changed time.Time
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		if current := snapshotConfig(paths); current != last {
+			last = current
+			changed = time.Now()
+			continue
+		}
+		if changed.IsZero() || time.Since(changed) < debounce {
+			continue
+		}
+		changed = time.Time{}
+
+		log.Printf("Config changed, reloading Telegraf config\n")
+		if _, err := a.ReloadConfig(); err != nil {
+			log.Printf("Error reloading config, keeping current config: %s\n",
+				err)
+		}
+	}
+}
+
+// PollConfig fetches the given remote configurations every interval, and
+// reloads the configuration with ReloadConfig once their contents changed.
+// key is the public key they must be signed with, if set. A configuration
+// that fails to be fetched, to load or to apply is logged and the running one
+// is kept, until the remote configurations change again. It returns when
+// shutdown is closed.
+func (a *Agent) PollConfig(
+	urls []string,
+	key ed25519.PublicKey,
+	interval time.Duration,
+	shutdown chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	last, _ := fetchConfig(urls, key)
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+
+		current, err := fetchConfig(urls, key)
+		if err != nil {
+			log.Printf("Error polling remote config: %s\n", err)
+			continue
+		}
+		if current == last {
+			continue
+		}
+		last = current
+
+		log.Printf("Remote config changed, reloading Telegraf config\n")
+		if _, err := a.ReloadConfig(); err != nil {
+			log.Printf("Error reloading config, keeping current config: %s\n",
+				err)
+		}
+	}
+}
+
+// fetchConfig returns a hash of the contents of the given remote
+// configurations.
+func fetchConfig(urls []string, key ed25519.PublicKey) (string, error) {
+	h := sha256.New()
+	for _, url := range urls {
+		contents, err := config.FetchRemote(url, key)
+		if err != nil {
+			return "", fmt.Errorf("%s: %s", url, err)
+		}
+		h.Write(contents)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// snapshotConfig returns the size and modification time of the given config
+// files, and of the *.conf files in the given directories.
+func snapshotConfig(paths []string) string {
+	var lines []string
+	add := func(path string, fi os.FileInfo) {
+		lines = append(lines, fmt.Sprintf("%s %d %d", path, fi.Size(),
+			fi.ModTime().UnixNano()))
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			lines = append(lines, path+" missing")
+			continue
+		}
+		if !fi.IsDir() {
+			add(path, fi)
+			continue
+		}
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			lines = append(lines, path+" unreadable")
+			continue
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.IsDir() && (strings.HasSuffix(name, ".conf") ||
+				strings.HasSuffix(name, ".conf.tmpl")) {
+				add(filepath.Join(path, name), entry)
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
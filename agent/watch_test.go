@@ -0,0 +1,106 @@
+package agent
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgent_WatchConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "watch")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	write := func(name, contents string) {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name),
+			[]byte(contents), 0644))
+	}
+	write("a.conf", "[[inputs.cpu]]\n")
+
+	var loads int32
+	a := &Agent{
+		ConfigLoader: func() (*config.Config, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, errors.New("invalid")
+		},
+	}
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		a.WatchConfig([]string{dir}, 10*time.Millisecond,
+			100*time.Millisecond, shutdown)
+		close(done)
+	}()
+	defer func() {
+		close(shutdown)
+		<-done
+	}()
+
+	// other files are ignored
+	write("notes.txt", "hello")
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+
+	// a burst of changes reloads once, after the debounce
+	write("a.conf", "[[inputs.cpu]]\n  percpu = true\n")
+	time.Sleep(30 * time.Millisecond)
+	write("b.conf", "[[inputs.mem]]\n")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+
+	// a failed reload is not retried until the files change again
+	time.Sleep(200 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+	require.NoError(t, os.Remove(filepath.Join(dir, "b.conf")))
+	time.Sleep(300 * time.Millisecond)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&loads))
+}
+
+func TestAgent_PollConfig(t *testing.T) {
+	var contents atomic.Value
+	contents.Store("[[inputs.cpu]]\n")
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(contents.Load().(string)))
+		}))
+	defer ts.Close()
+
+	var loads int32
+	a := &Agent{
+		ConfigLoader: func() (*config.Config, error) {
+			atomic.AddInt32(&loads, 1)
+			return nil, errors.New("invalid")
+		},
+	}
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		a.PollConfig([]string{ts.URL + "/telegraf.conf"}, nil,
+			10*time.Millisecond, shutdown)
+		close(done)
+	}()
+	defer func() {
+		close(shutdown)
+		<-done
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&loads))
+
+	// a change reloads once, a failed reload is not retried
+	contents.Store("[[inputs.cpu]]\n  percpu = true\n")
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&loads))
+}
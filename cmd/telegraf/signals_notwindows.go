@@ -0,0 +1,11 @@
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// drainSignals drain the agent and exit.
+var drainSignals = []os.Signal{syscall.SIGUSR2}
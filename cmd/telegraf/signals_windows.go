@@ -0,0 +1,10 @@
+// +build windows
+
+package main
+
+import (
+	"os"
+)
+
+// drainSignals drain the agent and exit. Windows has no SIGUSR2.
+var drainSignals = []os.Signal{}
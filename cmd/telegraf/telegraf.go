@@ -1,16 +1,23 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/influxdata/telegraf/agent"
 	"github.com/influxdata/telegraf/internal/config"
+	"github.com/influxdata/telegraf/internal/sandbox"
+	"github.com/influxdata/telegraf/plugins/external"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	_ "github.com/influxdata/telegraf/plugins/inputs/all"
 	"github.com/influxdata/telegraf/plugins/outputs"
@@ -22,13 +29,16 @@ var fDebug = flag.Bool("debug", false,
 var fQuiet = flag.Bool("quiet", false,
 	"run in quiet mode")
 var fTest = flag.Bool("test", false, "gather metrics, print them out, and exit")
-var fConfig = flag.String("config", "", "configuration file to load")
+var fConfig = flag.String("config", "",
+	"configuration file or URL to load")
 var fConfigDirectory = flag.String("config-directory", "",
 	"directory containing additional *.conf files")
 var fVersion = flag.Bool("version", false, "display the version")
 var fSampleConfig = flag.Bool("sample-config", false,
 	"print out full sample configuration")
 var fPidfile = flag.String("pidfile", "", "file to write our pid to")
+var fPluginDirectory = flag.String("plugin-directory", "",
+	"directory containing external plugin manifests")
 var fInputFilters = flag.String("input-filter", "",
 	"filter the inputs to enable, separator is :")
 var fInputList = flag.Bool("input-list", false,
@@ -45,6 +55,31 @@ var fOutputFiltersLegacy = flag.String("outputfilter", "",
 	"filter the outputs to enable, separator is :")
 var fConfigDirectoryLegacy = flag.String("configdirectory", "",
 	"directory containing additional *.conf files")
+var fWatchConfig = flag.Bool("watch-config", false,
+	"reload the config when the config file or directory change")
+var fWatchDebounce = flag.Duration("watch-debounce", 5*time.Second,
+	"how long the watched config must stay unchanged before it is reloaded")
+var fConfigPollInterval = flag.Duration("config-poll-interval", 0,
+	"how often a remote config is fetched, and reloaded if changed")
+var fConfigPublicKey = flag.String("config-public-key", "",
+	"PEM ed25519 public key remote configs must be signed with")
+var fSecretKeyFile = flag.String("secret-key-file", "",
+	"file holding the key for the ENC[...] secrets in the config")
+var fSecretKMSKey = flag.String("secret-kms-key", "",
+	"file holding the key for the ENC[...] secrets, encrypted with AWS KMS")
+var fSecretCacheTTL = flag.Duration("secret-cache-ttl", 5*time.Minute,
+	"how long the @{...} secret reference values are cached")
+
+// secretPassphraseEnv holds the passphrase for the config secrets when no
+// secret key file is given.
+const secretPassphraseEnv = "TELEGRAF_SECRET_PASSPHRASE"
+
+// watchInterval is how often the config is checked for changes with
+// -watch-config.
+const watchInterval = time.Second
+
+// drainLostExitCode is the exit status after a drain that lost metrics.
+const drainLostExitCode = 3
 
 // Telegraf version, populated linker.
 //   ie, -ldflags "-X main.version=`git describe --always --tags`"
@@ -62,10 +97,28 @@ Usage:
 
 The flags are:
 
-  -config <file>     configuration file to load
+  -config <file>     configuration file, or URL, to load
   -test              gather metrics once, print them to stdout, and exit
   -sample-config     print out full sample configuration to stdout
   -config-directory  directory containing additional *.conf files
+  -plugin-directory  directory containing external plugin manifests (*.toml)
+  -watch-config      reload the config when the -config file or the
+                     -config-directory change, as with SIGHUP
+  -watch-debounce    how long the watched config must stay unchanged before it
+                     is reloaded, default 5s
+  -config-poll-interval
+                     how often a remote -config URL is fetched, and reloaded
+                     if changed, as with SIGHUP. Disabled by default
+  -config-public-key
+                     PEM ed25519 public key remote configs must be signed
+                     with, in <url>.sig
+  -secret-key-file   file holding the 32 byte key for the ENC[...] secrets in
+                     the config, raw or base64. $TELEGRAF_SECRET_PASSPHRASE
+                     is used instead if not set
+  -secret-kms-key    file holding the key for the ENC[...] secrets,
+                     encrypted with AWS KMS
+  -secret-cache-ttl  how long the @{...} secret reference values in the
+                     config are cached across reloads, default 5m
   -input-filter      filter the input plugins to enable, separator is :
   -input-list        print all the plugins inputs
   -output-filter     filter the output plugins to enable, separator is :
@@ -93,186 +146,529 @@ Examples:
   # run a single telegraf collection, outputing metrics to stdout
   telegraf -config telegraf.conf -test
 
+  # encrypt a secret, to be pasted in the config
+  telegraf -secret-key-file secret.key secret encrypt < password.txt
+
+  # validate a config file, connecting to its outputs and starting its inputs,
+  # and print the result of each plugin as JSON
+  telegraf -config telegraf.conf config validate -probe
+
+  # list the deprecated plugins and options of a config file, then rewrite it
+  # with the automatic migrations applied
+  telegraf -config telegraf.conf config migrate -report
+  telegraf -config telegraf.conf config migrate -write
+
+  # print the effective config, with where each setting was set
+  telegraf -config telegraf.conf -config-directory telegraf.d config render
+
   # run telegraf with all plugins defined in config file
   telegraf -config telegraf.conf
 
+  # run telegraf, reloading the config whenever a file of its directory changes
+  telegraf -config telegraf.conf -config-directory telegraf.d -watch-config
+
+  # run telegraf with a config fetched from Consul, reloaded when it changes
+  telegraf -config consul://localhost:8500/telegraf/web -config-poll-interval 1m
+
   # run telegraf, enabling the cpu & memory input, and influxdb output plugins
   telegraf -config telegraf.conf -input-filter cpu:mem -output-filter influxdb
 `
 
 func main() {
-	reload := make(chan bool, 1)
-	reload <- true
-	for <-reload {
-		reload <- false
-		flag.Usage = func() { usageExit(0) }
-		flag.Parse()
-		args := flag.Args()
-
-		var inputFilters []string
-		if *fInputFiltersLegacy != "" {
-			fmt.Printf("WARNING '--filter' flag is deprecated, please use" +
-				" '--input-filter'")
-			inputFilter := strings.TrimSpace(*fInputFiltersLegacy)
-			inputFilters = strings.Split(":"+inputFilter+":", ":")
-		}
-		if *fInputFilters != "" {
-			inputFilter := strings.TrimSpace(*fInputFilters)
-			inputFilters = strings.Split(":"+inputFilter+":", ":")
-		}
+	if len(os.Args) > 1 && os.Args[1] == sandbox.ExecArg {
+		sandbox.Exec(os.Args[2:])
+	}
 
-		var outputFilters []string
-		if *fOutputFiltersLegacy != "" {
-			fmt.Printf("WARNING '--outputfilter' flag is deprecated, please use" +
-				" '--output-filter'")
-			outputFilter := strings.TrimSpace(*fOutputFiltersLegacy)
-			outputFilters = strings.Split(":"+outputFilter+":", ":")
-		}
-		if *fOutputFilters != "" {
-			outputFilter := strings.TrimSpace(*fOutputFilters)
-			outputFilters = strings.Split(":"+outputFilter+":", ":")
-		}
+	flag.Usage = func() { usageExit(0) }
+	flag.Parse()
+	args := flag.Args()
 
-		if len(args) > 0 {
-			switch args[0] {
-			case "version":
-				v := fmt.Sprintf("Telegraf - version %s", version)
-				fmt.Println(v)
-				return
-			case "config":
-				config.PrintSampleConfig(inputFilters, outputFilters)
-				return
-			}
-		}
+	var inputFilters []string
+	if *fInputFiltersLegacy != "" {
+		fmt.Printf("WARNING '--filter' flag is deprecated, please use" +
+			" '--input-filter'")
+		inputFilter := strings.TrimSpace(*fInputFiltersLegacy)
+		inputFilters = strings.Split(":"+inputFilter+":", ":")
+	}
+	if *fInputFilters != "" {
+		inputFilter := strings.TrimSpace(*fInputFilters)
+		inputFilters = strings.Split(":"+inputFilter+":", ":")
+	}
 
-		if *fOutputList {
-			fmt.Println("Available Output Plugins:")
-			for k, _ := range outputs.Outputs {
-				fmt.Printf("  %s\n", k)
-			}
-			return
-		}
+	var outputFilters []string
+	if *fOutputFiltersLegacy != "" {
+		fmt.Printf("WARNING '--outputfilter' flag is deprecated, please use" +
+			" '--output-filter'")
+		outputFilter := strings.TrimSpace(*fOutputFiltersLegacy)
+		outputFilters = strings.Split(":"+outputFilter+":", ":")
+	}
+	if *fOutputFilters != "" {
+		outputFilter := strings.TrimSpace(*fOutputFilters)
+		outputFilters = strings.Split(":"+outputFilter+":", ":")
+	}
 
-		if *fInputList {
-			fmt.Println("Available Input Plugins:")
-			for k, _ := range inputs.Inputs {
-				fmt.Printf("  %s\n", k)
-			}
-			return
+	if *fPluginDirectory != "" {
+		if err := external.LoadDirectory(*fPluginDirectory); err != nil {
+			log.Fatal(err)
 		}
+	}
 
-		if *fVersion {
+	if len(args) > 0 {
+		switch args[0] {
+		case "version":
 			v := fmt.Sprintf("Telegraf - version %s", version)
 			fmt.Println(v)
 			return
-		}
-
-		if *fSampleConfig {
+		case "config":
+			if len(args) > 1 && args[1] == "validate" {
+				os.Exit(validate(args[2:], inputFilters, outputFilters))
+			}
+			if len(args) > 1 && args[1] == "migrate" {
+				os.Exit(migrate(args[2:]))
+			}
+			if len(args) > 1 && args[1] == "render" {
+				os.Exit(render(inputFilters, outputFilters))
+			}
 			config.PrintSampleConfig(inputFilters, outputFilters)
 			return
-		}
-
-		if *fUsage != "" {
-			if err := config.PrintInputConfig(*fUsage); err != nil {
-				if err2 := config.PrintOutputConfig(*fUsage); err2 != nil {
-					log.Fatalf("%s and %s", err, err2)
-				}
+		case "secret":
+			if len(args) > 1 && args[1] == "encrypt" {
+				os.Exit(encryptSecret())
 			}
-			return
+			usageExit(1)
 		}
+	}
 
-		// If no other options are specified, load the config file and run.
-		c := config.NewConfig()
-		c.OutputFilters = outputFilters
-		c.InputFilters = inputFilters
-		err := c.LoadConfig(*fConfig)
-		if err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+	if *fOutputList {
+		fmt.Println("Available Output Plugins:")
+		for k, _ := range outputs.Outputs {
+			fmt.Printf("  %s\n", k)
 		}
+		return
+	}
 
-		if *fConfigDirectoryLegacy != "" {
-			fmt.Printf("WARNING '--configdirectory' flag is deprecated, please use" +
-				" '--config-directory'")
-			err = c.LoadDirectory(*fConfigDirectoryLegacy)
-			if err != nil {
-				log.Fatal(err)
-			}
+	if *fInputList {
+		fmt.Println("Available Input Plugins:")
+		for k, _ := range inputs.Inputs {
+			fmt.Printf("  %s\n", k)
 		}
+		return
+	}
+
+	if *fVersion {
+		v := fmt.Sprintf("Telegraf - version %s", version)
+		fmt.Println(v)
+		return
+	}
+
+	if *fSampleConfig {
+		config.PrintSampleConfig(inputFilters, outputFilters)
+		return
+	}
 
-		if *fConfigDirectory != "" {
-			err = c.LoadDirectory(*fConfigDirectory)
-			if err != nil {
-				log.Fatal(err)
+	if *fUsage != "" {
+		if err := config.PrintInputConfig(*fUsage); err != nil {
+			if err2 := config.PrintOutputConfig(*fUsage); err2 != nil {
+				log.Fatalf("%s and %s", err, err2)
 			}
 		}
-		if len(c.Outputs) == 0 {
-			log.Fatalf("Error: no outputs found, did you provide a valid config file?")
+		return
+	}
+
+	if *fConfigDirectoryLegacy != "" {
+		fmt.Printf("WARNING '--configdirectory' flag is deprecated, please use" +
+			" '--config-directory'")
+	}
+
+	// If no other options are specified, load the config file and run.
+	c, err := loadConfig(inputFilters, outputFilters)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	ag, err := agent.NewAgent(c)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ag.ConfigLoader = func() (*config.Config, error) {
+		return loadConfig(inputFilters, outputFilters)
+	}
+
+	if *fTest {
+		err = ag.Test()
+		if err != nil {
+			log.Fatal(err)
 		}
-		if len(c.Inputs) == 0 {
-			log.Fatalf("Error: no inputs found, did you provide a valid config file?")
+		return
+	}
+
+	err = ag.Connect()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shutdown := make(chan struct{})
+	exitCode := 0
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, append([]os.Signal{os.Interrupt, syscall.SIGHUP},
+		drainSignals...)...)
+	go func() {
+		for sig := range signals {
+			if sig == os.Interrupt {
+				close(shutdown)
+				return
+			}
+			if isDrainSignal(sig) {
+				exitCode = drain(ag)
+				close(shutdown)
+				return
+			}
+			if sig == syscall.SIGHUP {
+				log.Printf("Reloading Telegraf config\n")
+				if _, err := ag.ReloadConfig(); err != nil {
+					log.Printf("Error reloading config, keeping current "+
+						"config: %s\n", err)
+				}
+			}
 		}
+	}()
+
+	log.Printf("Starting Telegraf (version %s)\n", version)
+	log.Printf("Loaded outputs: %s", strings.Join(c.OutputNames(), " "))
+	log.Printf("Loaded inputs: %s", strings.Join(c.InputNames(), " "))
+	log.Printf("Tags enabled: %s", c.ListTags())
 
-		ag, err := agent.NewAgent(c)
+	if *fPidfile != "" {
+		f, err := os.Create(*fPidfile)
 		if err != nil {
-			log.Fatal(err)
+			log.Fatalf("Unable to create pidfile: %s", err)
+		}
+
+		fmt.Fprintf(f, "%d\n", os.Getpid())
+
+		f.Close()
+	}
+
+	if *fWatchConfig {
+		go ag.WatchConfig(watchedConfig(c), watchInterval, *fWatchDebounce,
+			shutdown)
+	}
+	if urls := remoteConfig(c); *fConfigPollInterval > 0 && len(urls) > 0 {
+		go ag.PollConfig(urls, c.RemoteKey, *fConfigPollInterval, shutdown)
+	}
+
+	ag.Run(shutdown)
+	if exitCode != 0 {
+		os.Exit(exitCode)
+	}
+}
+
+// watchedConfig returns the config file and directories given on the command
+// line, and the files they include, except remote ones.
+func watchedConfig(c *config.Config) []string {
+	var paths []string
+	for _, path := range append([]string{*fConfig, *fConfigDirectoryLegacy,
+		*fConfigDirectory}, c.Included...) {
+		if path != "" && !config.IsRemote(path) {
+			paths = append(paths, path)
 		}
+	}
+	return paths
+}
 
-		if *fDebug {
-			ag.Config.Agent.Debug = true
+// remoteConfig returns the remote config given on the command line, and the
+// remote configs included.
+func remoteConfig(c *config.Config) []string {
+	var urls []string
+	for _, path := range append([]string{*fConfig}, c.Included...) {
+		if config.IsRemote(path) {
+			urls = append(urls, path)
 		}
+	}
+	return urls
+}
+
+// loadedSecretKey is the secret key, once loaded by secretKey.
+var loadedSecretKey *config.SecretKey
 
-		if *fQuiet {
-			ag.Config.Agent.Quiet = true
+// secretKey returns the key for the config secrets. The key is read from
+// -secret-key-file or -secret-kms-key, or derived from the passphrase in
+// $TELEGRAF_SECRET_PASSPHRASE. It returns nil if none is set.
+func secretKey() (*config.SecretKey, error) {
+	if loadedSecretKey != nil {
+		return loadedSecretKey, nil
+	}
+	var key *config.SecretKey
+	var err error
+	switch {
+	case *fSecretKeyFile != "":
+		key, err = config.LoadSecretKey(*fSecretKeyFile)
+	case *fSecretKMSKey != "":
+		key, err = config.LoadKMSSecretKey(*fSecretKMSKey)
+	case os.Getenv(secretPassphraseEnv) != "":
+		key, err = config.NewSecretPassphrase(os.Getenv(secretPassphraseEnv))
+	}
+	if err != nil {
+		return nil, err
+	}
+	loadedSecretKey = key
+	return key, nil
+}
+
+// encryptSecret prints the secret of the value read from stdin, without its
+// trailing newline, and returns the exit status.
+func encryptSecret() int {
+	key, err := secretKey()
+	if err == nil && key == nil {
+		err = fmt.Errorf("no secret key, use -secret-key-file, "+
+			"-secret-kms-key or $%s", secretPassphraseEnv)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	value, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	value = bytes.TrimSuffix(value, []byte("\n"))
+	secret, err := key.Encrypt(value)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Println(secret)
+	return 0
+}
+
+func isDrainSignal(sig os.Signal) bool {
+	for _, s := range drainSignals {
+		if sig == s {
+			return true
 		}
+	}
+	return false
+}
 
-		if *fTest {
-			err = ag.Test()
-			if err != nil {
-				log.Fatal(err)
-			}
-			return
+// drain drains the agent, and returns the exit status: 0 if every buffered
+// metric was written, drainLostExitCode otherwise.
+func drain(ag *agent.Agent) int {
+	log.Printf("Draining Telegraf, inputs are stopped\n")
+	summary, err := ag.Drain()
+	if err != nil {
+		log.Printf("Error draining: %s\n", err)
+		return drainLostExitCode
+	}
+	for _, o := range summary.Outputs {
+		if o.Unwritten > 0 || o.Dropped > 0 {
+			log.Printf("Output [%s] lost %d unwritten and %d dropped metrics\n",
+				o.Name, o.Unwritten, o.Dropped)
 		}
+	}
+	if summary.TimedOut {
+		log.Printf("Timed out draining outputs\n")
+	}
+	if summary.Lost > 0 {
+		log.Printf("Drained with %d metrics lost\n", summary.Lost)
+		return drainLostExitCode
+	}
+	log.Printf("Drained all metrics\n")
+	return 0
+}
 
-		err = ag.Connect()
-		if err != nil {
-			log.Fatal(err)
+// validate loads the configuration and prints the validation report of its
+// plugins as JSON, probing them if -probe is given. It returns the exit
+// status: 0 if the configuration is valid, 1 if not, 2 on bad arguments.
+func validate(args []string, inputFilters, outputFilters []string) int {
+	fs := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	fs.StringVar(fConfig, "config", *fConfig, "configuration file to load")
+	fs.StringVar(fConfigDirectory, "config-directory", *fConfigDirectory,
+		"directory containing additional *.conf files")
+	probe := fs.Bool("probe", false,
+		"connect to each output and start or gather each input")
+	timeout := fs.Duration("timeout", 10*time.Second,
+		"how long each plugin may take to probe")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	report := &agent.ValidationReport{Plugins: []agent.PluginValidation{}}
+	c, err := loadConfig(inputFilters, outputFilters)
+	if err == nil {
+		var ag *agent.Agent
+		if ag, err = agent.NewAgent(c); err == nil {
+			report = ag.Validate(*probe, *timeout)
 		}
+	}
+	if err != nil {
+		report.Error = err.Error()
+	}
 
-		shutdown := make(chan struct{})
-		signals := make(chan os.Signal)
-		signal.Notify(signals, os.Interrupt, syscall.SIGHUP)
-		go func() {
-			sig := <-signals
-			if sig == os.Interrupt {
-				close(shutdown)
+	out, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("Error encoding validation report: %s\n", err)
+		return 1
+	}
+	fmt.Println(string(out))
+	if !report.Valid {
+		return 1
+	}
+	return 0
+}
+
+// migrate prints the configuration files with the deprecated plugins and
+// options migrated, or the report of the deprecations with -report, or
+// rewrites the files with -write. It returns the exit status: with -report, 1
+// if anything is deprecated.
+func migrate(args []string) int {
+	fs := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	fs.StringVar(fConfig, "config", *fConfig, "configuration file to migrate")
+	fs.StringVar(fConfigDirectory, "config-directory", *fConfigDirectory,
+		"directory containing additional *.conf files")
+	report := fs.Bool("report", false,
+		"list the deprecated plugins and options in use")
+	write := fs.Bool("write", false,
+		"rewrite the files, keeping the originals with a .bak suffix")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	c := config.NewConfig()
+	key, err := secretKey()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	c.SecretKey = key
+	migrations, err := c.Migrate(*fConfig)
+	if err == nil && *fConfigDirectory != "" {
+		var more []config.Migration
+		more, err = c.MigrateDirectory(*fConfigDirectory)
+		migrations = append(migrations, more...)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	status := 0
+	for _, m := range migrations {
+		switch {
+		case *report:
+			for _, d := range m.Deprecations {
+				fmt.Println(d)
+				status = 1
 			}
-			if sig == syscall.SIGHUP {
-				log.Printf("Reloading Telegraf config\n")
-				<-reload
-				reload <- true
-				close(shutdown)
+		case *write:
+			if err := writeMigration(m); err != nil {
+				fmt.Fprintf(os.Stderr, "Error migrating %s, %s\n", m.Path, err)
+				status = 1
 			}
-		}()
+		case m.Migrated == nil:
+			fmt.Fprintf(os.Stderr, "%s cannot be migrated, it is remote or a "+
+				"template\n", m.Path)
+			status = 1
+		default:
+			os.Stdout.Write(m.Migrated)
+		}
+	}
+	return status
+}
 
-		log.Printf("Starting Telegraf (version %s)\n", version)
-		log.Printf("Loaded outputs: %s", strings.Join(c.OutputNames(), " "))
-		log.Printf("Loaded inputs: %s", strings.Join(c.InputNames(), " "))
-		log.Printf("Tags enabled: %s", c.ListTags())
+// writeMigration rewrites a configuration file with its automatic migrations
+// applied, keeping the original with a .bak suffix.
+func writeMigration(m config.Migration) error {
+	if m.Migrated == nil {
+		return errors.New("it is remote or a template")
+	}
+	fi, err := os.Stat(m.Path)
+	if err != nil {
+		return err
+	}
+	original, err := ioutil.ReadFile(m.Path)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(original, m.Migrated) {
+		return nil
+	}
+	if err := ioutil.WriteFile(m.Path+".bak", original, fi.Mode()); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(m.Path, m.Migrated, fi.Mode())
+}
 
-		if *fPidfile != "" {
-			f, err := os.Create(*fPidfile)
-			if err != nil {
-				log.Fatalf("Unable to create pidfile: %s", err)
-			}
+// render prints the effective configuration, with the source of each
+// setting. It returns the exit status.
+func render(inputFilters, outputFilters []string) int {
+	c, err := loadConfig(inputFilters, outputFilters)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := c.Render(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
 
-			fmt.Fprintf(f, "%d\n", os.Getpid())
+// loadConfig loads the config file and config directory given on the command
+// line.
+func loadConfig(inputFilters, outputFilters []string) (*config.Config, error) {
+	c := config.NewConfig()
+	c.OutputFilters = outputFilters
+	c.InputFilters = inputFilters
+	if *fConfigPublicKey != "" {
+		key, err := config.LoadPublicKey(*fConfigPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		c.RemoteKey = key
+	}
+	key, err := secretKey()
+	if err != nil {
+		return nil, err
+	}
+	c.SecretKey = key
+	c.SecretCacheTTL = *fSecretCacheTTL
+	if err := c.LoadConfig(*fConfig); err != nil {
+		return nil, err
+	}
 
-			f.Close()
+	if *fConfigDirectoryLegacy != "" {
+		if err := c.LoadDirectory(*fConfigDirectoryLegacy); err != nil {
+			return nil, err
 		}
+	}
+
+	if *fConfigDirectory != "" {
+		if err := c.LoadDirectory(*fConfigDirectory); err != nil {
+			return nil, err
+		}
+	}
+	if err := c.CheckStartAfter(); err != nil {
+		return nil, err
+	}
+	if len(c.Outputs) == 0 {
+		return nil, errors.New("Error: no outputs found, did you provide a " +
+			"valid config file?")
+	}
+	if len(c.Inputs) == 0 {
+		return nil, errors.New("Error: no inputs found, did you provide a " +
+			"valid config file?")
+	}
+
+	if *fDebug {
+		c.Agent.Debug = true
+	}
 
-		ag.Run(shutdown)
+	if *fQuiet {
+		c.Agent.Quiet = true
 	}
+	return c, nil
 }
 
 func usageExit(rc int) {
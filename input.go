@@ -29,3 +29,17 @@ type ServiceInput interface {
 	// Stop stops the services and closes any necessary channels and connections
 	Stop()
 }
+
+// Pausable is a ServiceInput that can stop accepting data while the outputs
+// are backed up. Examples are a queue consumer that stops consuming, or a
+// listener that stops reading from its connections. The agent pauses it instead of dropping
+// the oldest buffered metrics.
+type Pausable interface {
+	ServiceInput
+
+	// Pause stops accepting new data until Resume is called
+	Pause()
+
+	// Resume accepts new data again
+	Resume()
+}
@@ -1,12 +1,106 @@
 package buffer
 
 import (
+	"sync"
+
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/experimental"
+)
+
+// priorityBatches makes Batch take the metrics of the highest priority class
+// first.
+var priorityBatches = experimental.Register("priority_batches",
+	"write the highest priority buffered metrics first, instead of the oldest"+
+		" first")
+
+// Metric priority classes. When a Buffer is full, the lowest priority metrics
+// are dropped first.
+const (
+	PriorityLow    = -1
+	PriorityNormal = 0
+	PriorityHigh   = 1
 )
 
-// Buffer is an object for storing metrics in a circular buffer.
+// Prioritized is implemented by metrics that have a priority class other
+// than PriorityNormal.
+type Prioritized interface {
+	Priority() int
+}
+
+// Priority returns the priority class of a metric.
+func Priority(m telegraf.Metric) int {
+	if p, ok := m.(Prioritized); ok {
+		return p.Priority()
+	}
+	return PriorityNormal
+}
+
+const numPriorities = PriorityHigh - PriorityLow + 1
+
+type entry struct {
+	metric telegraf.Metric
+	// seq is the order in which the metric was added
+	seq int64
+}
+
+// queue holds the metrics of a priority class, oldest first: the metrics
+// requeued at its head, then the compressed chunks, then the metrics added
+// since the last chunk was compressed.
+type queue struct {
+	head   []entry
+	chunks []*chunk
+	tail   []entry
+}
+
+// oldest returns the seq of the oldest metric in the queue, and false if the
+// queue is empty.
+func (q *queue) oldest() (int64, bool) {
+	switch {
+	case len(q.head) > 0:
+		return q.head[0].seq, true
+	case len(q.chunks) > 0:
+		return q.chunks[0].seqs[q.chunks[0].next], true
+	case len(q.tail) > 0:
+		return q.tail[0].seq, true
+	}
+	return 0, false
+}
+
+// pop removes the oldest metric of a non-empty queue. Its metric is nil if
+// it was in a chunk that could not be decompressed.
+func (q *queue) pop(priority int) entry {
+	var e entry
+	switch {
+	case len(q.head) > 0:
+		e, q.head[0] = q.head[0], entry{}
+		q.head = q.head[1:]
+	case len(q.chunks) > 0:
+		c := q.chunks[0]
+		e = entry{metric: c.pop(priority), seq: c.seqs[c.next-1]}
+		if c.next == len(c.seqs) {
+			q.chunks[0] = nil
+			q.chunks = q.chunks[1:]
+		}
+	default:
+		e, q.tail[0] = q.tail[0], entry{}
+		q.tail = q.tail[1:]
+	}
+	return e
+}
+
+// Buffer is an object for storing metrics, up to a maximum size.
 type Buffer struct {
-	buf chan telegraf.Metric
+	mu   sync.Mutex
+	size int
+	// chunkSize is the number of metrics compressed together, zero if the
+	// buffer is not compressed
+	chunkSize int
+	queues    [numPriorities]queue
+	len       int
+	// seq is the seq of the last metric added, and head the seq of the last
+	// metric requeued
+	seq  int64
+	head int64
 	// total dropped metrics
 	drops int
 	// total metrics added
@@ -15,56 +109,161 @@ type Buffer struct {
 
 // NewBuffer returns a Buffer
 //   size is the maximum number of metrics that Buffer will cache. If Add is
-//   called when the buffer is full, then the oldest metric(s) will be dropped.
+//   called when the buffer is full, then the oldest metric(s) of the lowest
+//   priority class will be dropped.
 func NewBuffer(size int) *Buffer {
 	return &Buffer{
-		buf: make(chan telegraf.Metric, size),
+		size: size,
+	}
+}
+
+// NewCompressedBuffer returns a Buffer that keeps its metrics serialized and
+// compressed in chunks of chunkSize metrics, to hold many more metrics in the
+// same memory. Metrics are decompressed a chunk at a time when they are
+// taken out of the buffer.
+func NewCompressedBuffer(size, chunkSize int) *Buffer {
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	return &Buffer{
+		size:      size,
+		chunkSize: chunkSize,
 	}
 }
 
 // IsEmpty returns true if Buffer is empty.
 func (b *Buffer) IsEmpty() bool {
-	return len(b.buf) == 0
+	return b.Len() == 0
 }
 
 // Len returns the current length of the buffer.
 func (b *Buffer) Len() int {
-	return len(b.buf)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.len
 }
 
 // Drops returns the total number of dropped metrics that have occured in this
 // buffer since instantiation.
 func (b *Buffer) Drops() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.drops
 }
 
 // Total returns the total number of metrics that have been added to this buffer.
 func (b *Buffer) Total() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	return b.total
 }
 
-// Add adds metrics to the buffer.
-func (b *Buffer) Add(metrics ...telegraf.Metric) {
-	for i, _ := range metrics {
+// Add adds metrics to the buffer, and returns the metrics that were dropped to
+// make room for them, if any. The oldest metrics of the lowest priority class
+// are dropped first. A metric of a lower priority than all the buffered
+// metrics is dropped itself.
+func (b *Buffer) Add(metrics ...telegraf.Metric) []telegraf.Metric {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var dropped []telegraf.Metric
+	for _, m := range metrics {
 		b.total++
-		select {
-		case b.buf <- metrics[i]:
-		default:
+		p := queueIndex(m)
+
+		if b.len >= b.size {
 			b.drops++
-			<-b.buf
-			b.buf <- metrics[i]
+			victim := b.lowest(p)
+			if victim < 0 {
+				dropped = append(dropped, m)
+				continue
+			}
+			e := b.queues[victim].pop(victim + PriorityLow)
+			b.len--
+			if e.metric != nil {
+				dropped = append(dropped, e.metric)
+			}
+		}
+
+		b.seq++
+		q := &b.queues[p]
+		q.tail = append(q.tail, entry{metric: m, seq: b.seq})
+		b.len++
+		if b.chunkSize > 0 && len(q.tail) >= b.chunkSize {
+			q.chunks = append(q.chunks, compress(q.tail))
+			q.tail = nil
+		}
+	}
+	return dropped
+}
+
+// Requeue puts metrics taken from the buffer by Batch back in front of all
+// the others, in the same order. It is used after a failed write. They are not
+// counted as added again, and not compressed again.
+func (b *Buffer) Requeue(metrics ...telegraf.Metric) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for i := len(metrics) - 1; i >= 0; i-- {
+		m := metrics[i]
+		b.head--
+		q := &b.queues[queueIndex(m)]
+		q.head = append([]entry{{metric: m, seq: b.head}}, q.head...)
+		b.len++
+	}
+}
+
+// queueIndex returns the index of the queue for the metric's priority.
+func queueIndex(m telegraf.Metric) int {
+	p := Priority(m) - PriorityLow
+	if p < 0 {
+		p = 0
+	} else if p >= numPriorities {
+		p = numPriorities - 1
+	}
+	return p
+}
+
+// lowest returns the lowest non-empty priority queue, up to max, or -1.
+func (b *Buffer) lowest(max int) int {
+	for p := 0; p <= max; p++ {
+		if _, ok := b.queues[p].oldest(); ok {
+			return p
 		}
 	}
+	return -1
 }
 
-// Batch returns a batch of metrics of size batchSize.
+// Batch returns a batch of metrics of size batchSize, oldest first, or
+// highest priority first if the priority_batches experimental flag is
+// enabled.
 // the batch will be of maximum length batchSize. It can be less than batchSize,
 // if the length of Buffer is less than batchSize.
 func (b *Buffer) Batch(batchSize int) []telegraf.Metric {
-	n := min(len(b.buf), batchSize)
-	out := make([]telegraf.Metric, n)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	byPriority := priorityBatches.Enabled()
+	n := min(b.len, batchSize)
+	out := make([]telegraf.Metric, 0, n)
 	for i := 0; i < n; i++ {
-		out[i] = <-b.buf
+		// take the oldest metric across all queues, or from the highest
+		// priority queue that is not empty
+		next := -1
+		var oldest int64
+		for p := range b.queues {
+			seq, ok := b.queues[p].oldest()
+			if ok && (next < 0 || byPriority || seq < oldest) {
+				next, oldest = p, seq
+			}
+		}
+		e := b.queues[next].pop(next + PriorityLow)
+		b.len--
+		if e.metric == nil {
+			b.drops++
+			continue
+		}
+		out = append(out, e.metric)
 	}
 	return out
 }
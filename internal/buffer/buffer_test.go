@@ -4,9 +4,11 @@ import (
 	"testing"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/experimental"
 	"github.com/influxdata/telegraf/testutil"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 var metricList = []telegraf.Metric{
@@ -51,15 +53,15 @@ func TestDroppingMetrics(t *testing.T) {
 	b := NewBuffer(10)
 
 	// Add up to the size of the buffer
-	b.Add(metricList...)
-	b.Add(metricList...)
+	assert.Empty(t, b.Add(metricList...))
+	assert.Empty(t, b.Add(metricList...))
 	assert.False(t, b.IsEmpty())
 	assert.Equal(t, b.Len(), 10)
 	assert.Equal(t, b.Drops(), 0)
 	assert.Equal(t, b.Total(), 10)
 
-	// Add 5 more and verify they were dropped
-	b.Add(metricList...)
+	// Add 5 more and verify the oldest 5 were dropped
+	assert.Equal(t, metricList, b.Add(metricList...))
 	assert.False(t, b.IsEmpty())
 	assert.Equal(t, b.Len(), 10)
 	assert.Equal(t, b.Drops(), 5)
@@ -92,3 +94,129 @@ func TestGettingBatches(t *testing.T) {
 	assert.Equal(t, b.Drops(), 0)
 	assert.Equal(t, b.Total(), 10)
 }
+
+type prioritized struct {
+	telegraf.Metric
+	priority int
+}
+
+func (p *prioritized) Priority() int {
+	return p.priority
+}
+
+func TestDroppingMetricsByPriority(t *testing.T) {
+	b := NewBuffer(4)
+	low1 := &prioritized{testutil.TestMetric(1, "low1"), PriorityLow}
+	low2 := &prioritized{testutil.TestMetric(2, "low2"), PriorityLow}
+	normal := testutil.TestMetric(3, "normal")
+	high1 := &prioritized{testutil.TestMetric(4, "high1"), PriorityHigh}
+	high2 := &prioritized{testutil.TestMetric(5, "high2"), PriorityHigh}
+	high3 := &prioritized{testutil.TestMetric(6, "high3"), PriorityHigh}
+
+	assert.Empty(t, b.Add(high1, low1, normal, low2))
+
+	// the oldest low priority metric is dropped first, even if newer
+	assert.Equal(t, []telegraf.Metric{low1}, b.Add(high2))
+	assert.Equal(t, []telegraf.Metric{low2}, b.Add(high3))
+	// a metric of a lower priority than all buffered metrics is dropped
+	low3 := &prioritized{testutil.TestMetric(7, "low3"), PriorityLow}
+	assert.Equal(t, []telegraf.Metric{low3}, b.Add(low3))
+	assert.Equal(t, []telegraf.Metric{normal}, b.Add(high1))
+	assert.Equal(t, 4, b.Drops())
+	assert.Equal(t, 8, b.Total())
+
+	// batches keep the order in which the metrics were added
+	assert.Equal(t, []telegraf.Metric{high1, high2, high3, high1}, b.Batch(10))
+	assert.True(t, b.IsEmpty())
+}
+
+func TestPriorityBatches(t *testing.T) {
+	require.NoError(t, experimental.Set(map[string]bool{"priority_batches": true}))
+	defer experimental.Set(nil)
+
+	b := NewBuffer(10)
+	low := &prioritized{testutil.TestMetric(1, "low"), PriorityLow}
+	normal1 := testutil.TestMetric(2, "normal1")
+	high := &prioritized{testutil.TestMetric(3, "high"), PriorityHigh}
+	normal2 := testutil.TestMetric(4, "normal2")
+	b.Add(low, normal1, high, normal2)
+
+	assert.Equal(t, []string{"high", "normal1"}, names(b.Batch(2)))
+	assert.Equal(t, []string{"normal2", "low"}, names(b.Batch(2)))
+}
+
+func names(metrics []telegraf.Metric) []string {
+	var out []string
+	for _, m := range metrics {
+		out = append(out, m.Name())
+	}
+	return out
+}
+
+func TestRequeue(t *testing.T) {
+	b := NewBuffer(10)
+	b.Add(metricList...)
+	batch := b.Batch(2)
+	b.Requeue(batch...)
+	assert.Equal(t, 5, b.Len())
+	assert.Equal(t, 5, b.Total())
+	assert.Equal(t, metricList, b.Batch(10))
+}
+
+type retained struct {
+	telegraf.Metric
+}
+
+func (r *retained) Retained() bool {
+	return true
+}
+
+func TestCompressedBuffer(t *testing.T) {
+	b := NewCompressedBuffer(10, 2)
+	traced := &retained{testutil.TestMetric(3, "traced")}
+	low := &prioritized{testutil.TestMetric(4, "low"), PriorityLow}
+	assert.Empty(t, b.Add(metricList...))
+	assert.Empty(t, b.Add(traced, low))
+	assert.Equal(t, 7, b.Len())
+	assert.Len(t, b.queues[PriorityNormal-PriorityLow].chunks, 3)
+
+	// metrics are decompressed in the order they were added
+	batch := b.Batch(3)
+	assert.Equal(t, []string{"mymetric1", "mymetric2", "mymetric3"},
+		names(batch))
+	for i, m := range batch {
+		assert.Equal(t, metricList[i].String(), m.String())
+	}
+	b.Requeue(batch...)
+
+	batch = b.Batch(10)
+	assert.Equal(t, []string{"mymetric1", "mymetric2", "mymetric3",
+		"mymetric4", "mymetric5", "traced", "low"}, names(batch))
+	// retained metrics are returned as they are, and priorities are kept
+	assert.True(t, batch[5] == traced)
+	assert.Equal(t, PriorityLow, Priority(batch[6]))
+	assert.True(t, b.IsEmpty())
+}
+
+func TestCompressedBufferDropping(t *testing.T) {
+	b := NewCompressedBuffer(4, 2)
+	high := &prioritized{testutil.TestMetric(1, "high"), PriorityHigh}
+	assert.Empty(t, b.Add(metricList[:4]...))
+
+	// the oldest metric is decompressed to be dropped
+	dropped := b.Add(high)
+	assert.Equal(t, []string{"mymetric1"}, names(dropped))
+	dropped = b.Add(metricList[4])
+	assert.Equal(t, []string{"mymetric2"}, names(dropped))
+	assert.Equal(t, 2, b.Drops())
+	assert.Equal(t, []string{"mymetric3", "mymetric4", "high", "mymetric5"},
+		names(b.Batch(10)))
+}
+
+func BenchmarkAddCompressedMetrics(b *testing.B) {
+	buf := NewCompressedBuffer(10000, 1000)
+	m := testutil.TestMetric(1, "mymetric")
+	for n := 0; n < b.N; n++ {
+		buf.Add(m)
+	}
+}
@@ -0,0 +1,104 @@
+package buffer
+
+import (
+	"bytes"
+	"compress/flate"
+	"io/ioutil"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/parsers/influx"
+)
+
+// Retained is implemented by metrics that carry more than their measurement,
+// tags, fields and time, such as traced metrics. A compressed Buffer keeps them as
+// they are, besides compressing them, and returns them in place of their
+// decompressed copy.
+type Retained interface {
+	Retained() bool
+}
+
+// chunk is a list of metrics serialized as line protocol and compressed.
+type chunk struct {
+	// seqs are the seqs of the metrics
+	seqs []int64
+	// data are the compressed metrics, nil once decompressed
+	data []byte
+	// retained are the metrics kept as they are, by index
+	retained map[int]telegraf.Metric
+	// metrics are the decompressed metrics, nil if they could not be
+	// decompressed
+	metrics []telegraf.Metric
+	// next is the index of the next metric to pop
+	next int
+}
+
+// compress returns a chunk holding the metrics of the given entries.
+func compress(entries []entry) *chunk {
+	c := &chunk{seqs: make([]int64, len(entries))}
+	var buf bytes.Buffer
+	w, _ := flate.NewWriter(&buf, flate.BestSpeed)
+	for i, e := range entries {
+		c.seqs[i] = e.seq
+		if r, ok := e.metric.(Retained); ok && r.Retained() {
+			if c.retained == nil {
+				c.retained = make(map[int]telegraf.Metric)
+			}
+			c.retained[i] = e.metric
+		}
+		w.Write([]byte(e.metric.String()))
+		w.Write([]byte("\n"))
+	}
+	w.Close()
+	c.data = buf.Bytes()
+	return c
+}
+
+// pop returns the next metric of the chunk, decompressing it first if needed.
+// The metrics of a priority class other than PriorityNormal get it back. Nil
+// is returned if the chunk could not be decompressed.
+func (c *chunk) pop(priority int) telegraf.Metric {
+	if c.data != nil {
+		c.metrics = decompress(c.data, len(c.seqs))
+		c.data = nil
+	}
+	i := c.next
+	c.next++
+	if m, ok := c.retained[i]; ok {
+		return m
+	}
+	if c.metrics == nil {
+		return nil
+	}
+	m := c.metrics[i]
+	c.metrics[i] = nil
+	if priority != PriorityNormal {
+		m = &prioritizedMetric{Metric: m, priority: priority}
+	}
+	return m
+}
+
+func decompress(data []byte, n int) []telegraf.Metric {
+	r := flate.NewReader(bytes.NewReader(data))
+	defer r.Close()
+	buf, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil
+	}
+	parser := influx.InfluxParser{}
+	metrics, err := parser.Parse(buf)
+	if err != nil || len(metrics) != n {
+		return nil
+	}
+	return metrics
+}
+
+// prioritizedMetric is a decompressed metric of a priority class other than
+// PriorityNormal.
+type prioritizedMetric struct {
+	telegraf.Metric
+	priority int
+}
+
+func (pm *prioritizedMetric) Priority() int {
+	return pm.priority
+}
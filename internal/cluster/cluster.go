@@ -0,0 +1,152 @@
+// Package cluster spreads the targets gathered by a group of telegraf agents
+// sharing the same configuration across them.
+//
+// Every agent knows the static list of its peers, and checks which of them are
+// up through their admin API. Each target is owned by one of the members that
+// are up, chosen by rendezvous hashing, so that when a member goes down or
+// comes back only its own targets move.
+package cluster
+
+import (
+	"hash/fnv"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Sharded is implemented by inputs with a list of targets, such as SNMP
+// hosts. A target is gathered only if this agent owns it. The cluster is set before
+// the input is started.
+type Sharded interface {
+	SetCluster(c *Cluster)
+}
+
+// Cluster is a group of agents. A nil *Cluster is a cluster of one, which
+// owns every target.
+type Cluster struct {
+	self   string
+	peers  []string
+	client *http.Client
+
+	mu      sync.RWMutex
+	members []string
+}
+
+// New returns a Cluster of the given peers. The peers are given by their
+// admin API URLs, and one of them is self. All the peers are members until they are checked.
+func New(self string, peers []string) *Cluster {
+	c := &Cluster{
+		self:   self,
+		peers:  peers,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}
+	c.members = append([]string(nil), peers...)
+	sort.Strings(c.members)
+	return c
+}
+
+// Self returns the URL of this agent.
+func (c *Cluster) Self() string {
+	if c == nil {
+		return ""
+	}
+	return c.self
+}
+
+// Members returns the URLs of the members that are up, sorted.
+func (c *Cluster) Members() []string {
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string(nil), c.members...)
+}
+
+// Owns returns true if this agent owns the given target: if this agent is
+// the member with the highest hash of the target.
+func (c *Cluster) Owns(target string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var owner string
+	var best uint64
+	for _, m := range c.members {
+		if h := hash(m, target); owner == "" || h > best {
+			owner, best = m, h
+		}
+	}
+	return owner == c.self
+}
+
+func hash(member, target string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(member))
+	h.Write([]byte{0})
+	h.Write([]byte(target))
+	return h.Sum64()
+}
+
+// Run checks the peers every interval until shutdown is closed.
+func (c *Cluster) Run(interval time.Duration, shutdown chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.Check()
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check updates the members of the cluster with the peers whose admin API
+// responds to /health. This agent is always a member.
+func (c *Cluster) Check() {
+	up := make([]bool, len(c.peers))
+	var wg sync.WaitGroup
+	for i, peer := range c.peers {
+		if peer == c.self {
+			up[i] = true
+			continue
+		}
+		wg.Add(1)
+		go func(i int, peer string) {
+			defer wg.Done()
+			up[i] = c.ping(peer)
+		}(i, peer)
+	}
+	wg.Wait()
+
+	var members []string
+	for i, peer := range c.peers {
+		if up[i] {
+			members = append(members, peer)
+		}
+	}
+	sort.Strings(members)
+
+	c.mu.Lock()
+	changed := strings.Join(members, " ") != strings.Join(c.members, " ")
+	c.members = members
+	c.mu.Unlock()
+	if changed {
+		log.Printf("Cluster members changed, rebalancing targets across: %s\n",
+			strings.Join(members, " "))
+	}
+}
+
+func (c *Cluster) ping(peer string) bool {
+	resp, err := c.client.Get(strings.TrimSuffix(peer, "/") + "/health")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode/100 == 2
+}
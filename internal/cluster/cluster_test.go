@@ -0,0 +1,80 @@
+package cluster
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwnsPartitions(t *testing.T) {
+	peers := []string{"http://a:8126", "http://b:8126", "http://c:8126"}
+	var clusters []*Cluster
+	for _, self := range peers {
+		clusters = append(clusters, New(self, peers))
+	}
+
+	owners := map[string]int{}
+	for i := 0; i < 300; i++ {
+		target := fmt.Sprintf("10.0.0.%d:161", i)
+		n := 0
+		for _, c := range clusters {
+			if c.Owns(target) {
+				owners[c.Self()]++
+				n++
+			}
+		}
+		assert.Equal(t, 1, n, "target %s has %d owners", target, n)
+	}
+	for _, self := range peers {
+		assert.True(t, owners[self] > 50, "%s owns %d targets", self,
+			owners[self])
+	}
+}
+
+func TestOwnsRebalance(t *testing.T) {
+	peers := []string{"http://a:8126", "http://b:8126", "http://c:8126"}
+	a := New(peers[0], peers)
+	before := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		target := fmt.Sprintf("host%d", i)
+		before[target] = a.Owns(target)
+	}
+
+	// c is down: a keeps its targets, and takes over some of c's
+	a.members = peers[:2]
+	for target, owned := range before {
+		if owned {
+			assert.True(t, a.Owns(target), target)
+		}
+	}
+}
+
+func TestNilCluster(t *testing.T) {
+	var c *Cluster
+	assert.True(t, c.Owns("anything"))
+	assert.Empty(t, c.Members())
+}
+
+func TestCheck(t *testing.T) {
+	up := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/health", r.URL.Path)
+		}))
+	defer up.Close()
+	failing := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	defer failing.Close()
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	self := "http://self:8126"
+	c := New(self, []string{self, up.URL, failing.URL, down.URL})
+	assert.Len(t, c.Members(), 4)
+	c.Check()
+	assert.Equal(t, []string{up.URL, self}, c.Members())
+}
@@ -0,0 +1,176 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/influxdata/toml/ast"
+)
+
+// condition is the enable_if of a plugin, which is only loaded if the host
+// meets it. It is a list of expressions, and the host meets the condition if
+// it meets any of them.
+//
+// Like a route expression, an expression is a list of predicates separated by
+// spaces, all of which must hold, and a predicate is either "key=pattern" or
+// "key!=pattern", where pattern is a glob. The keys are:
+//
+//	os, arch       the GOOS and GOARCH of telegraf, such as linux and amd64
+//	hostname       the hostname
+//	env.<NAME>     an environment variable, never equal to a pattern if unset
+//	cloud.<fact>   provider, region, zone, instance_id or instance_type of the
+//	               cloud instance, see the config templates
+//	file           a path, equal to the pattern if a file matches it
+//	binary         a program, equal to the name if it is in the PATH
+type condition struct {
+	exprs [][]conditionPredicate
+}
+
+type conditionPredicate struct {
+	key     string
+	negate  bool
+	pattern string
+	value   glob.Glob
+}
+
+// cloudKeys are the keys for the cloud instance facts.
+var cloudKeys = map[string]func(cloudFacts) string{
+	"provider":      func(f cloudFacts) string { return f.Provider },
+	"region":        func(f cloudFacts) string { return f.Region },
+	"zone":          func(f cloudFacts) string { return f.Zone },
+	"instance_id":   func(f cloudFacts) string { return f.InstanceID },
+	"instance_type": func(f cloudFacts) string { return f.InstanceType },
+}
+
+// buildCondition builds the condition from a plugin table's enable_if
+// option, or returns nil if it has none. enable_if is an expression, or a list of them.
+func buildCondition(tbl *ast.Table) (*condition, error) {
+	node, ok := tbl.Fields["enable_if"]
+	if !ok {
+		return nil, nil
+	}
+	delete(tbl.Fields, "enable_if")
+
+	var exprs []string
+	if kv, ok := node.(*ast.KeyValue); ok {
+		switch v := kv.Value.(type) {
+		case *ast.String:
+			exprs = append(exprs, v.Value)
+		case *ast.Array:
+			for _, elem := range v.Value {
+				if str, ok := elem.(*ast.String); ok {
+					exprs = append(exprs, str.Value)
+				}
+			}
+		}
+	}
+	if len(exprs) == 0 {
+		return nil, fmt.Errorf("enable_if must be an expression or a list of " +
+			"expressions")
+	}
+
+	c := &condition{}
+	for _, expr := range exprs {
+		var preds []conditionPredicate
+		for _, term := range strings.Fields(expr) {
+			pred, err := parseConditionPredicate(term)
+			if err != nil {
+				return nil, fmt.Errorf("enable_if expression %q: %s", expr, err)
+			}
+			preds = append(preds, pred)
+		}
+		if len(preds) == 0 {
+			return nil, fmt.Errorf("enable_if expression %q is empty", expr)
+		}
+		c.exprs = append(c.exprs, preds)
+	}
+	return c, nil
+}
+
+func parseConditionPredicate(term string) (conditionPredicate, error) {
+	var pred conditionPredicate
+	i := strings.Index(term, "=")
+	if i <= 0 {
+		return pred, fmt.Errorf("invalid predicate %q, expected key=pattern "+
+			"or key!=pattern", term)
+	}
+	key, pattern := term[:i], term[i+1:]
+	if strings.HasSuffix(key, "!") {
+		key, pred.negate = key[:len(key)-1], true
+	}
+	if key == "" || pattern == "" {
+		return pred, fmt.Errorf("invalid predicate %q, expected key=pattern "+
+			"or key!=pattern", term)
+	}
+
+	switch {
+	case key == "os", key == "arch", key == "hostname", key == "file",
+		key == "binary":
+	case strings.HasPrefix(key, "env.") && len(key) > len("env."):
+	case strings.HasPrefix(key, "cloud."):
+		if _, ok := cloudKeys[strings.TrimPrefix(key, "cloud.")]; !ok {
+			return pred, fmt.Errorf("unknown key %q", key)
+		}
+	default:
+		return pred, fmt.Errorf("unknown key %q", key)
+	}
+
+	var err error
+	pred.key, pred.pattern = key, pattern
+	if pred.value, err = glob.Compile(pattern); err != nil {
+		return pred, fmt.Errorf("invalid pattern in %q, %s", term, err)
+	}
+	return pred, nil
+}
+
+// met returns true if the host meets the condition. A nil condition is
+// always met.
+func (c *condition) met(facts hostFacts) bool {
+	if c == nil {
+		return true
+	}
+	for _, preds := range c.exprs {
+		met := true
+		for _, pred := range preds {
+			if pred.holds(facts) == pred.negate {
+				met = false
+				break
+			}
+		}
+		if met {
+			return true
+		}
+	}
+	return false
+}
+
+// holds returns true if key=pattern holds.
+func (p conditionPredicate) holds(facts hostFacts) bool {
+	var value string
+	switch {
+	case p.key == "os":
+		value = facts.OS
+	case p.key == "arch":
+		value = facts.Arch
+	case p.key == "hostname":
+		value = facts.Hostname
+	case p.key == "file":
+		matches, err := filepath.Glob(p.pattern)
+		return err == nil && len(matches) > 0
+	case p.key == "binary":
+		_, err := exec.LookPath(p.pattern)
+		return err == nil
+	case strings.HasPrefix(p.key, "env."):
+		var ok bool
+		if value, ok = os.LookupEnv(strings.TrimPrefix(p.key, "env.")); !ok {
+			return false
+		}
+	case strings.HasPrefix(p.key, "cloud."):
+		value = cloudKeys[strings.TrimPrefix(p.key, "cloud.")](facts.Cloud())
+	}
+	return p.value.Match(value)
+}
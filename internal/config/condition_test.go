@@ -0,0 +1,73 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/influxdata/telegraf/plugins/inputs/memcached"
+	"github.com/influxdata/toml"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_EnableIf(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the testdata conditions are on linux")
+	}
+	os.Setenv("TELEGRAF_TEST_ROLE", "cache-eu")
+	defer os.Unsetenv("TELEGRAF_TEST_ROLE")
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig("./testdata/enable_if.toml"))
+	var servers []string
+	for _, input := range c.Inputs {
+		servers = append(servers, input.Input.(*memcached.Memcached).Servers...)
+	}
+	assert.Equal(t, []string{"localhost", "192.168.1.1"}, servers)
+}
+
+func TestCondition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "condition")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "smart.conf"), nil,
+		0644))
+	os.Setenv("TELEGRAF_TEST_ROLE", "db")
+	defer os.Unsetenv("TELEGRAF_TEST_ROLE")
+	facts := hostFacts{Hostname: "web-1", OS: "linux", Arch: "amd64"}
+
+	for expr, met := range map[string]bool{
+		`"os=linux"`:                              true,
+		`"os!=linux"`:                             false,
+		`"os=linux arch=arm*"`:                    false,
+		`["os=windows", "hostname=web-*"]`:        true,
+		`"env.TELEGRAF_TEST_ROLE=db"`:             true,
+		`"env.TELEGRAF_TEST_UNSET=*"`:             false,
+		`"env.TELEGRAF_TEST_UNSET!=db"`:           true,
+		`"file=` + dir + `/*.conf"`:               true,
+		`"file!=` + dir + `/*.toml"`:              true,
+		`"binary=no-such-binary"`:                 false,
+		`"binary!=no-such-binary hostname=web-1"`: true,
+	} {
+		tbl, err := toml.Parse([]byte("enable_if = " + expr))
+		require.NoError(t, err)
+		cond, err := buildCondition(tbl)
+		require.NoError(t, err, expr)
+		assert.Equal(t, met, cond.met(facts), expr)
+		assert.Empty(t, tbl.Fields)
+	}
+
+	for _, expr := range []string{
+		`""`, `[]`, `1`, `"os"`, `"=linux"`, `"os="`, `"shell=bash"`,
+		`"cloud.name=x"`, `"env.=x"`, `"os=[a"`,
+	} {
+		tbl, err := toml.Parse([]byte("enable_if = " + expr))
+		require.NoError(t, err)
+		_, err = buildCondition(tbl)
+		assert.Error(t, err, expr)
+	}
+}
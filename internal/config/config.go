@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"io"
 	"io/ioutil"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -15,15 +18,20 @@ import (
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/cron"
+	"github.com/influxdata/telegraf/internal/experimental"
 	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/plugins/external"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/outputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/plugins/parsers/binary"
 	"github.com/influxdata/telegraf/plugins/serializers"
 
 	"github.com/influxdata/config"
 	"github.com/influxdata/toml"
 	"github.com/influxdata/toml/ast"
+	"golang.org/x/crypto/ed25519"
 )
 
 var (
@@ -49,6 +57,41 @@ type Config struct {
 	Agent   *AgentConfig
 	Inputs  []*internal_models.RunningInput
 	Outputs []*internal_models.RunningOutput
+
+	// Tracer selects the metrics traced through the pipeline, set by the
+	// [agent.trace] table. Nil when tracing is disabled.
+	Tracer *internal_models.Tracer
+
+	// RouteDrop selects the metrics dropped before they reach any output, set
+	// by route_drop in the [agent] table. Nil when no metrics are dropped.
+	RouteDrop *internal_models.Route
+
+	// Experimental are the experimental feature flags set in the
+	// [experimental] table, see the experimental package.
+	Experimental map[string]bool
+
+	// Included are the files loaded by include directives, in the order they
+	// were loaded.
+	Included []string
+
+	// RemoteKey, if set, is the ed25519 public key the remote configurations
+	// must be signed with, see FetchRemote.
+	RemoteKey ed25519.PublicKey
+
+	// SecretKey, if set, decrypts the ENC[...] values of the configuration.
+	SecretKey *SecretKey
+
+	// SecretCacheTTL is how long resolved @{...} secret references are
+	// cached. Zero disables the cache.
+	SecretCacheTTL time.Duration
+
+	// profiles are the TLS and auth profiles, keyed by table and then by
+	// name.
+	profiles map[string]map[string]profile
+
+	// sources records where each table was set. The keys are "global_tags",
+	// "agent", "experimental" and the running plugins.
+	sources map[interface{}]*tableSource
 }
 
 func NewConfig() *Config {
@@ -58,9 +101,17 @@ func NewConfig() *Config {
 			Interval:      internal.Duration{Duration: 10 * time.Second},
 			RoundInterval: true,
 			FlushInterval: internal.Duration{Duration: 10 * time.Second},
+			DrainTimeout:  internal.Duration{Duration: 30 * time.Second},
+			StateMaxSize:  1024 * 1024,
+
+			ClusterCheckInterval: internal.Duration{Duration: 10 * time.Second},
+			OTLPServiceName:      "telegraf",
 		},
 
+		SecretCacheTTL: 5 * time.Minute,
+
 		Tags:          make(map[string]string),
+		Experimental:  make(map[string]bool),
 		Inputs:        make([]*internal_models.RunningInput, 0),
 		Outputs:       make([]*internal_models.RunningOutput, 0),
 		InputFilters:  make([]string, 0),
@@ -92,6 +143,10 @@ type AgentConfig struct {
 	// ie, a jitter of 5s and interval 10s means flushes will happen every 10-15s
 	FlushJitter internal.Duration
 
+	// DrainTimeout is how long telegraf keeps writing the buffered metrics
+	// after a SIGUSR2.
+	DrainTimeout internal.Duration
+
 	// MetricBatchSize is the maximum number of metrics that is wrote to an
 	// output plugin in one call.
 	MetricBatchSize int
@@ -103,6 +158,20 @@ type AgentConfig struct {
 	// not be less than 2 times MetricBatchSize.
 	MetricBufferLimit int
 
+	// MetricBufferCompression compresses the metrics buffered after failed
+	// writes. A larger MetricBufferLimit then fits in the same memory, at
+	// some CPU cost.
+	MetricBufferCompression bool
+
+	// BackpressureHighWatermark is a fraction of MetricBufferLimit. When any
+	// output buffer is fuller than that, the inputs that support it are
+	// paused. Zero disables backpressure.
+	BackpressureHighWatermark float64
+	// BackpressureLowWatermark is a fraction of MetricBufferLimit. The paused
+	// inputs resume once every output buffer is below it. Defaults to half of
+	// BackpressureHighWatermark.
+	BackpressureLowWatermark float64
+
 	// FlushBufferWhenFull tells Telegraf to flush the metric buffer whenever
 	// it fills up, regardless of FlushInterval. Setting this option to true
 	// does _not_ deactivate FlushInterval.
@@ -121,6 +190,56 @@ type AgentConfig struct {
 	Quiet        bool
 	Hostname     string
 	OmitHostname bool
+
+	// AdminAddress is the address the admin HTTP API listens on, either
+	// "host:port" or "unix:///path/to/socket". Empty disables the API.
+	AdminAddress string
+
+	// PluginDirectory is a directory of external plugin manifests, which are
+	// registered before the plugins of the config file are loaded.
+	PluginDirectory string
+
+	// RouteDrop are route expressions. The metrics they match are dropped
+	// before they reach any output.
+	RouteDrop []string
+
+	// StateStore is the URL of the store that keeps plugin state across
+	// restarts, such as "file:///var/lib/telegraf/state". Empty disables it.
+	StateStore string
+	// StateMaxSize is the most state a plugin can keep, in bytes.
+	StateMaxSize int
+
+	// ClusterPeers are the admin API URLs of the agents that share the
+	// targets of sharded inputs, this one included. Empty disables sharding.
+	ClusterPeers []string
+	// ClusterSelf is the URL of this agent in ClusterPeers.
+	ClusterSelf string
+	// ClusterCheckInterval is how often the peers are checked.
+	ClusterCheckInterval internal.Duration
+
+	// OTLPEndpoint is the OTLP/HTTP endpoint that receives the spans for
+	// gathers and output writes, such as "http://localhost:4318". Empty
+	// disables them.
+	OTLPEndpoint string
+	// OTLPServiceName is the service.name of the exported spans.
+	OTLPServiceName string
+
+	// PluginUsage reports the CPU time and memory allocations of each plugin
+	// in its health, measured from sampled CPU profiles.
+	PluginUsage bool
+
+	// MaxTags, MaxFields and MaxTagValueLength limit the metrics of the
+	// inputs that do not set their own limits. Zero is no limit. LimitPolicy
+	// is what happens to a metric over a limit: "truncate" (if empty) or
+	// "drop".
+	MaxTags           int
+	MaxFields         int
+	MaxTagValueLength int
+	LimitPolicy       string
+
+	// Strict makes unknown options and subtables errors, such as misspelled
+	// ones. Otherwise they are ignored with a warning.
+	Strict bool
 }
 
 // Inputs returns a list of strings of the configured inputs.
@@ -194,6 +313,16 @@ var header = `# Telegraf Configuration
   ## output, and will flush this buffer on a successful write. Oldest metrics
   ## are dropped first when this buffer fills.
   metric_buffer_limit = 10000
+  ## Compress the metrics buffered after failed writes, a batch at a time.
+  ## A much larger metric_buffer_limit then fits in the same memory during
+  ## long output outages, at some CPU cost.
+  # metric_buffer_compression = false
+  ## Pause the inputs that support it, such as tcp_listener and
+  ## kafka_consumer, when any output buffer is fuller than this fraction of
+  ## metric_buffer_limit. Otherwise the oldest metrics are dropped. The inputs
+  ## resume once every buffer is below the low watermark.
+  # backpressure_high_watermark = 0.8
+  # backpressure_low_watermark = 0.4
 
   ## Collection jitter is used to jitter the collection by a random amount.
   ## Each plugin will sleep for a random time within jitter before collecting.
@@ -208,6 +337,9 @@ var header = `# Telegraf Configuration
   ## large write spikes for users running a large number of telegraf instances.
   ## ie, a jitter of 5s and interval 10s means flushes will happen every 10-15s
   flush_jitter = "0s"
+  ## How long to keep writing the buffered metrics after a SIGUSR2. Metrics
+  ## that are still unwritten then are reported as lost.
+  # drain_timeout = "30s"
 
   ## Run telegraf in debug mode
   debug = false
@@ -218,6 +350,67 @@ var header = `# Telegraf Configuration
   ## If set to true, do no set the "host" tag in the telegraf agent.
   omit_hostname = false
 
+  ## Address of the admin HTTP API, as "host:port" or "unix:///path/to/sock".
+  ## POST /reload reloads the configuration, restarting only changed plugins.
+  ## GET /health returns the state of every running input and output.
+  ## Leave empty to disable.
+  # admin_address = "localhost:8126"
+
+  ## Directory of external plugin manifests (*.toml). Each manifest registers
+  ## a plugin binary as an input or output that can be configured below.
+  # plugin_directory = "/etc/telegraf/plugins"
+
+  ## Drop the metrics that match these route expressions before they reach
+  ## any output. The syntax is the same as route_match in the outputs.
+  # route_drop = ["route=drop"]
+
+  ## URL of the store that keeps plugin state across restarts, such as the
+  ## tail input offsets. Use "file:///path/to/dir" or "redis://host:port/db".
+  ## Each plugin can keep at most state_max_size bytes.
+  # state_store = "file:///var/lib/telegraf/state"
+  # state_max_size = 1048576
+
+  ## Share the targets of sharded inputs between agents that have the same
+  ## configuration. cluster_peers are the admin API URLs of all the agents,
+  ## this one included. They are checked every cluster_check_interval.
+  # cluster_peers = ["http://10.0.0.1:8126", "http://10.0.0.2:8126"]
+  # cluster_self = "http://10.0.0.1:8126"
+  # cluster_check_interval = "10s"
+
+  ## Export spans for gathers, flushes and output writes to an OpenTelemetry
+  ## collector, over OTLP/HTTP. They show where the agent spends its time and
+  ## where it retries.
+  # otlp_endpoint = "http://localhost:4318"
+  # otlp_service_name = "telegraf"
+
+  ## Report the CPU time and memory allocations of each plugin in its health,
+  ## as shown by the internal input. This keeps a CPU profile running, which costs
+  ## a few percent of CPU.
+  # plugin_usage = false
+
+  ## Limit the tags and fields of each metric, and the length of tag values.
+  ## This keeps a misbehaving input from creating too many series. Metrics
+  ## over a limit are truncated, or dropped if limit_policy is "drop". Each
+  ## input can set its own limits and limit_policy.
+  # max_tags = 32
+  # max_fields = 256
+  # max_tag_value_length = 256
+  # limit_policy = "truncate"
+
+  ## Fail to start on options that a plugin or the agent does not have, such
+  ## as misspelled ones, instead of ignoring them with a warning.
+  # strict = false
+
+  ## Log every step of the selected metrics through the pipeline: the input
+  ## that gathered them, the filters that dropped or modified them, and the
+  ## outputs that buffered, wrote or dropped them.
+  # [agent.trace]
+  #   namepass = ["cpu"]
+  #   ## fraction of the selected metrics to trace
+  #   sample_rate = 0.1
+  #   [agent.trace.tagpass]
+  #     cpu = ["cpu-total"]
+
 
 ###############################################################################
 #                            OUTPUT PLUGINS                                   #
@@ -391,24 +584,37 @@ func PrintOutputConfig(name string) error {
 }
 
 func (c *Config) LoadDirectory(path string) error {
-	directoryEntries, err := ioutil.ReadDir(path)
+	files, err := directoryFiles(path)
 	if err != nil {
 		return err
 	}
+	for _, file := range files {
+		if err := c.LoadConfig(file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// directoryFiles returns the *.conf and *.conf.tmpl files of a directory.
+func directoryFiles(path string) ([]string, error) {
+	directoryEntries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
 	for _, entry := range directoryEntries {
 		if entry.IsDir() {
 			continue
 		}
 		name := entry.Name()
-		if len(name) < 6 || name[len(name)-5:] != ".conf" {
+		if !strings.HasSuffix(name, ".conf") &&
+			!strings.HasSuffix(name, ".conf"+templateSuffix) {
 			continue
 		}
-		err := c.LoadConfig(filepath.Join(path, name))
-		if err != nil {
-			return err
-		}
+		files = append(files, filepath.Join(path, name))
 	}
-	return nil
+	return files, nil
 }
 
 // Try to find a default config file at these locations (in order):
@@ -440,11 +646,186 @@ func (c *Config) LoadConfig(path string) error {
 			return err
 		}
 	}
-	tbl, err := parseFile(path)
+	files, err := c.parseIncludes(path, nil)
+	if err != nil {
+		return err
+	}
+
+	// strict applies to the files before the one setting it too
+	for _, f := range files {
+		if isStrict(f.tbl) {
+			c.Agent.Strict = true
+		}
+	}
+
+	// The agent settings and tags of every file apply before any plugin is
+	// added, so that the included files override them for all the plugins.
+	for _, f := range files {
+		if err = c.loadGlobals(f.path, f.tbl); err != nil {
+			return err
+		}
+	}
+
+	// Register external plugins before they are referenced below:
+	if c.Agent.PluginDirectory != "" {
+		if err = external.LoadDirectory(c.Agent.PluginDirectory); err != nil {
+			return fmt.Errorf("Error loading plugin directory %s, %s",
+				c.Agent.PluginDirectory, err)
+		}
+	}
+
+	for _, f := range files {
+		if err = c.loadPlugins(f.path, f.tbl); err != nil {
+			return err
+		}
+	}
+	for _, f := range files[1:] {
+		c.Included = append(c.Included, f.path)
+	}
+	c.assignAliases()
+	return nil
+}
+
+// isStrict returns true if the agent table of a file sets strict.
+func isStrict(tbl *ast.Table) bool {
+	agent, ok := tbl.Fields["agent"].(*ast.Table)
+	if !ok {
+		return false
+	}
+	kv, ok := agent.Fields["strict"].(*ast.KeyValue)
+	if !ok {
+		return false
+	}
+	b, ok := kv.Value.(*ast.Boolean)
+	if !ok {
+		return false
+	}
+	strict, err := b.Boolean()
+	return err == nil && strict
+}
+
+// configFile is a parsed configuration file.
+type configFile struct {
+	path string
+	tbl  *ast.Table
+}
+
+// parseIncludes parses a configuration file, followed by the files it
+// includes and their own includes, depth first. loading are the files
+// including it, which it must not include again.
+func (c *Config) parseIncludes(
+	path string,
+	loading []string,
+) ([]configFile, error) {
+	abs := path
+	if !IsRemote(path) {
+		var err error
+		if abs, err = filepath.Abs(path); err != nil {
+			return nil, err
+		}
+	}
+	for _, l := range loading {
+		if l == abs {
+			return nil, fmt.Errorf("Error parsing %s, include cycle", path)
+		}
+	}
+	tbl, err := c.parseFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing %s, %s", path, err)
+	}
+	patterns, err := includePatterns(path, tbl)
 	if err != nil {
-		return fmt.Errorf("Error parsing %s, %s", path, err)
+		return nil, fmt.Errorf("Error parsing %s, %s", path, err)
+	}
+
+	files := []configFile{{path: path, tbl: tbl}}
+	for _, pattern := range patterns {
+		// remote files are included as is, without globbing
+		if IsRemote(pattern) {
+			included, err := c.parseIncludes(pattern, append(loading, abs))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, included...)
+			continue
+		}
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing %s, include %q: %s", path,
+				pattern, err)
+		}
+		sort.Strings(matches)
+		for _, match := range matches {
+			included, err := c.parseIncludes(match, append(loading, abs))
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, included...)
+		}
+	}
+	return files, nil
+}
+
+// includePatterns removes the include directive from the table of a file,
+// and returns its glob patterns, relative to the directory of the file, with
+// the ${VAR} environment variables in them expanded.
+func includePatterns(path string, tbl *ast.Table) ([]string, error) {
+	node, ok := tbl.Fields["include"]
+	if !ok {
+		return nil, nil
+	}
+	delete(tbl.Fields, "include")
+
+	kv, ok := node.(*ast.KeyValue)
+	if !ok {
+		return nil, errors.New("include must be an array of strings")
+	}
+	ary, ok := kv.Value.(*ast.Array)
+	if !ok {
+		return nil, errors.New("include must be an array of strings")
+	}
+	var patterns []string
+	for _, elem := range ary.Value {
+		str, ok := elem.(*ast.String)
+		if !ok {
+			return nil, errors.New("include must be an array of strings")
+		}
+		var unset []string
+		pattern := os.Expand(str.Value, func(name string) string {
+			value := os.Getenv(name)
+			if value == "" {
+				unset = append(unset, name)
+			}
+			return value
+		})
+		if len(unset) > 0 {
+			return nil, fmt.Errorf("include %q: %s not set", str.Value,
+				strings.Join(unset, ", "))
+		}
+		switch {
+		case IsRemote(pattern) || filepath.IsAbs(pattern):
+		case IsRemote(path):
+			// relative to the URL of a remote file
+			base, err := url.Parse(path)
+			if err != nil {
+				return nil, err
+			}
+			ref, err := url.Parse(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("include %q: %s", str.Value, err)
+			}
+			pattern = base.ResolveReference(ref).String()
+		default:
+			pattern = filepath.Join(filepath.Dir(path), pattern)
+		}
+		patterns = append(patterns, pattern)
 	}
+	return patterns, nil
+}
 
+// loadGlobals applies the tags and agent tables of a file.
+func (c *Config) loadGlobals(path string, tbl *ast.Table) error {
+	var err error
 	// Parse tags tables first:
 	for _, tableName := range []string{"tags", "global_tags"} {
 		if val, ok := tbl.Fields[tableName]; ok {
@@ -452,6 +833,8 @@ func (c *Config) LoadConfig(path string) error {
 			if !ok {
 				return fmt.Errorf("%s: invalid configuration", path)
 			}
+			c.setSource("global_tags",
+				c.sources["global_tags"].merge(newTableSource(path, subTable)))
 			if err = config.UnmarshalTable(subTable, c.Tags); err != nil {
 				log.Printf("Could not parse [global_tags] config\n")
 				return fmt.Errorf("Error parsing %s, %s", path, err)
@@ -465,13 +848,66 @@ func (c *Config) LoadConfig(path string) error {
 		if !ok {
 			return fmt.Errorf("%s: invalid configuration", path)
 		}
-		if err = config.UnmarshalTable(subTable, c.Agent); err != nil {
+		c.setSource("agent",
+			c.sources["agent"].merge(newTableSource(path, subTable)))
+		if node, ok := subTable.Fields["trace"]; ok {
+			traceTable, ok := node.(*ast.Table)
+			if !ok {
+				return fmt.Errorf("%s: invalid [agent.trace] configuration", path)
+			}
+			if c.Tracer, err = buildTracer(traceTable); err != nil {
+				return fmt.Errorf("Error parsing %s, %s", path, err)
+			}
+			delete(subTable.Fields, "trace")
+		}
+		err = c.unmarshalTable(path, "agent", subTable, c.Agent)
+		if err != nil {
 			log.Printf("Could not parse [agent] config\n")
+			return fileError(path, "agent", err)
+		}
+		if err = checkBackpressure(c.Agent); err != nil {
+			return fmt.Errorf("Error parsing %s, %s", path, err)
+		}
+		if err = checkCluster(c.Agent); err != nil {
+			return fmt.Errorf("Error parsing %s, %s", path, err)
+		}
+		if err = internal_models.CheckPolicy(c.Agent.LimitPolicy); err != nil {
+			return fmt.Errorf("Error parsing %s, %s", path, err)
+		}
+		if len(c.Agent.RouteDrop) != 0 {
+			c.RouteDrop, err = internal_models.NewRoute(c.Agent.RouteDrop)
+			if err != nil {
+				return fmt.Errorf("Error parsing %s, route_drop: %s", path, err)
+			}
+		}
+	}
+
+	if err = c.loadProfiles(path, tbl); err != nil {
+		return err
+	}
+
+	// Parse experimental table:
+	if val, ok := tbl.Fields["experimental"]; ok {
+		subTable, ok := val.(*ast.Table)
+		if !ok {
+			return fmt.Errorf("%s: invalid configuration", path)
+		}
+		c.setSource("experimental",
+			c.sources["experimental"].merge(newTableSource(path, subTable)))
+		if err = config.UnmarshalTable(subTable, c.Experimental); err != nil {
+			log.Printf("Could not parse [experimental] config\n")
+			return fmt.Errorf("Error parsing %s, %s", path, err)
+		}
+		if err = experimental.Check(c.Experimental); err != nil {
 			return fmt.Errorf("Error parsing %s, %s", path, err)
 		}
 	}
+	return nil
+}
 
-	// Parse all the rest of the plugins:
+// loadPlugins adds the plugins of a file.
+func (c *Config) loadPlugins(path string, tbl *ast.Table) error {
+	var err error
 	for name, val := range tbl.Fields {
 		subTable, ok := val.(*ast.Table)
 		if !ok {
@@ -479,18 +915,20 @@ func (c *Config) LoadConfig(path string) error {
 		}
 
 		switch name {
-		case "agent", "global_tags", "tags":
+		case "agent", "global_tags", "tags", "experimental", "tls_profiles",
+			"auth_profiles":
 		case "outputs":
 			for pluginName, pluginVal := range subTable.Fields {
 				switch pluginSubTable := pluginVal.(type) {
 				case *ast.Table:
-					if err = c.addOutput(pluginName, pluginSubTable); err != nil {
-						return fmt.Errorf("Error parsing %s, %s", path, err)
+					err = c.addOutput(path, pluginName, pluginSubTable)
+					if err != nil {
+						return fileError(path, "outputs."+pluginName, err)
 					}
 				case []*ast.Table:
 					for _, t := range pluginSubTable {
-						if err = c.addOutput(pluginName, t); err != nil {
-							return fmt.Errorf("Error parsing %s, %s", path, err)
+						if err = c.addOutput(path, pluginName, t); err != nil {
+							return fileError(path, "outputs."+pluginName, err)
 						}
 					}
 				default:
@@ -502,13 +940,14 @@ func (c *Config) LoadConfig(path string) error {
 			for pluginName, pluginVal := range subTable.Fields {
 				switch pluginSubTable := pluginVal.(type) {
 				case *ast.Table:
-					if err = c.addInput(pluginName, pluginSubTable); err != nil {
-						return fmt.Errorf("Error parsing %s, %s", path, err)
+					err = c.addInput(path, pluginName, pluginSubTable)
+					if err != nil {
+						return fileError(path, name+"."+pluginName, err)
 					}
 				case []*ast.Table:
 					for _, t := range pluginSubTable {
-						if err = c.addInput(pluginName, t); err != nil {
-							return fmt.Errorf("Error parsing %s, %s", path, err)
+						if err = c.addInput(path, pluginName, t); err != nil {
+							return fileError(path, name+"."+pluginName, err)
 						}
 					}
 				default:
@@ -519,22 +958,58 @@ func (c *Config) LoadConfig(path string) error {
 		// Assume it's an input input for legacy config file support if no other
 		// identifiers are present
 		default:
-			if err = c.addInput(name, subTable); err != nil {
-				return fmt.Errorf("Error parsing %s, %s", path, err)
+			if err = c.addInput(path, name, subTable); err != nil {
+				return fileError(path, name, err)
 			}
 		}
 	}
 	return nil
 }
 
-// parseFile loads a TOML configuration from a provided path and
-// returns the AST produced from the TOML parser. When loading the file, it
-// will find environment variables and replace them.
-func parseFile(fpath string) (*ast.Table, error) {
-	contents, err := ioutil.ReadFile(fpath)
+// parseFile loads a TOML configuration from a provided path, or remote URL,
+// and returns the AST produced from the TOML parser. When loading the file,
+// it will execute it if it is a template, find environment variables and
+// replace them, decrypt the ENC[...] values with the SecretKey, and resolve
+// the @{...} references to secret stores.
+func (c *Config) parseFile(fpath string) (*ast.Table, error) {
+	contents, err := c.readFile(fpath)
+	if err != nil {
+		return nil, err
+	}
+	if contents, err = expandFile(fpath, contents); err != nil {
+		return nil, err
+	}
+
+	tbl, err := toml.Parse(contents)
 	if err != nil {
 		return nil, err
 	}
+	if err := c.SecretKey.decryptTable(tbl); err != nil {
+		return nil, err
+	}
+	if err := c.resolveSecrets(tbl); err != nil {
+		return nil, err
+	}
+	return tbl, nil
+}
+
+// readFile returns the contents of a configuration file, or remote URL.
+func (c *Config) readFile(fpath string) ([]byte, error) {
+	if IsRemote(fpath) {
+		return FetchRemote(fpath, c.RemoteKey)
+	}
+	return ioutil.ReadFile(fpath)
+}
+
+// expandFile executes the contents of a configuration file if it is a
+// template, and replaces its environment variables.
+func expandFile(fpath string, contents []byte) ([]byte, error) {
+	if isTemplate(fpath) {
+		var err error
+		if contents, err = executeTemplate(fpath, contents); err != nil {
+			return nil, err
+		}
+	}
 
 	env_vars := envVarRe.FindAll(contents, -1)
 	for _, env_var := range env_vars {
@@ -543,47 +1018,237 @@ func parseFile(fpath string) (*ast.Table, error) {
 			contents = bytes.Replace(contents, env_var, []byte(env_val), 1)
 		}
 	}
+	return contents, nil
+}
+
+// fingerprint returns a hash of a plugin table's contents. Two plugins
+// with the same fingerprint were configured identically.
+func fingerprint(tbl *ast.Table) string {
+	h := fnv.New64a()
+	writeTable(h, tbl)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
 
-	return toml.Parse(contents)
+func writeTable(w io.Writer, tbl *ast.Table) {
+	var keys []string
+	for k := range tbl.Fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		switch v := tbl.Fields[k].(type) {
+		case *ast.KeyValue:
+			fmt.Fprintf(w, "%s=%s\n", k, v.Value.Source())
+		case *ast.Table:
+			fmt.Fprintf(w, "[%s]\n", k)
+			writeTable(w, v)
+		case []*ast.Table:
+			for _, t := range v {
+				fmt.Fprintf(w, "[[%s]]\n", k)
+				writeTable(w, t)
+			}
+		}
+	}
 }
 
-func (c *Config) addOutput(name string, table *ast.Table) error {
+func (c *Config) addOutput(path, name string, table *ast.Table) error {
 	if len(c.OutputFilters) > 0 && !sliceContains(name, c.OutputFilters) {
 		return nil
 	}
+	ro, err := c.newRunningOutput(path, name, table)
+	if err != nil || ro == nil {
+		return err
+	}
+	for i, other := range c.Outputs {
+		if other.Name == name && ro.Config.Alias != "" &&
+			other.Config.Alias == ro.Config.Alias {
+			c.Outputs[i] = ro
+			return nil
+		}
+	}
+	for _, other := range c.Outputs {
+		if other.Name == name &&
+			other.Config.Fingerprint == ro.Config.Fingerprint {
+			log.Printf("WARNING: output [%s] at %s:%d is configured identically"+
+				" to an earlier one, metrics are written twice\n",
+				name, path, table.Line)
+			break
+		}
+	}
+	c.Outputs = append(c.Outputs, ro)
+	return nil
+}
+
+// newRunningOutput builds the running output for an output table, or
+// returns nil if it is disabled by its enable_if.
+func (c *Config) newRunningOutput(
+	path, name string,
+	table *ast.Table,
+) (*internal_models.RunningOutput, error) {
+	source := newTableSource(path, table)
+	if err := c.applyProfiles(table, source); err != nil {
+		return nil, err
+	}
+	fp := fingerprint(table)
+	cond, err := buildCondition(table)
+	if err != nil {
+		return nil, err
+	}
 	creator, ok := outputs.Outputs[name]
 	if !ok {
-		return fmt.Errorf("Undefined but requested output: %s", name)
+		var names []string
+		for n := range outputs.Outputs {
+			names = append(names, n)
+		}
+		return nil, unknownPluginError("output", name, names)
 	}
 	output := creator()
 
+	if router, ok := output.(internal_models.OutputRouter); ok {
+		if err := c.addRoutes(path, name, table, router); err != nil {
+			return nil, err
+		}
+	}
+
 	// If the output has a SetSerializer function, then this means it can write
-	// arbitrary types of output, so build the serializer and set it.
-	switch t := output.(type) {
-	case serializers.SerializerOutput:
-		serializer, err := buildSerializer(name, table)
+	// arbitrary types of output, so build the serializer and set it once the
+	// running output exists.
+	var serializer serializers.Serializer
+	if _, ok := output.(serializers.SerializerOutput); ok {
+		var err error
+		serializer, err = buildSerializer(name, table)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		t.SetSerializer(serializer)
 	}
 
 	outputConfig, err := buildOutput(name, table)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	outputConfig.Fingerprint = fp
 
-	if err := config.UnmarshalTable(table, output); err != nil {
-		return err
+	if err := c.unmarshalTable(path, "outputs."+name, table, output,
+		outputOptions...); err != nil {
+		return nil, err
+	}
+	if !cond.met(newHostFacts()) {
+		log.Printf("Output [%s] at %s:%d is disabled by its enable_if\n", name,
+			path, table.Line)
+		return nil, nil
 	}
 
 	ro := internal_models.NewRunningOutput(name, output, outputConfig,
 		c.Agent.MetricBatchSize, c.Agent.MetricBufferLimit)
-	c.Outputs = append(c.Outputs, ro)
+	if c.Agent.MetricBufferCompression {
+		ro.CompressBuffer()
+	}
+	if serializer != nil {
+		// metrics that cannot be serialized are sent to the dead letter outputs
+		output.(serializers.SerializerOutput).SetSerializer(
+			ro.WrapSerializer(serializer))
+	}
+	c.setSource(ro, source)
+	return ro, nil
+}
+
+// addRoutes adds the child outputs in a router output's
+// [[outputs.<name>.route]] tables. Each route table has the match and default
+// options for the metrics routed to its child. It also holds the child output
+// table, such as [outputs.router.route.influxdb].
+func (c *Config) addRoutes(
+	path, name string,
+	table *ast.Table,
+	router internal_models.OutputRouter,
+) error {
+	routes, ok := table.Fields["route"].([]*ast.Table)
+	if !ok {
+		return fmt.Errorf("output %s: no routes, expected [[outputs.%s.route]]"+
+			" tables", name, name)
+	}
+	delete(table.Fields, "route")
+
+	for _, rt := range routes {
+		var match []string
+		var matchKV *ast.KeyValue
+		var isDefault bool
+		var child string
+		var childTable *ast.Table
+		for key, val := range rt.Fields {
+			switch v := val.(type) {
+			case *ast.KeyValue:
+				switch key {
+				case "match":
+					ary, ok := v.Value.(*ast.Array)
+					if !ok {
+						return optionError("match", v,
+							fmt.Errorf("expected an array of strings"))
+					}
+					for _, elem := range ary.Value {
+						if str, ok := elem.(*ast.String); ok {
+							match = append(match, str.Value)
+						}
+					}
+					matchKV = v
+				case "default":
+					b, ok := v.Value.(*ast.Boolean)
+					if !ok {
+						return optionError("default", v,
+							fmt.Errorf("expected a boolean"))
+					}
+					var err error
+					if isDefault, err = b.Boolean(); err != nil {
+						return err
+					}
+				default:
+					return fmt.Errorf("line %d: route of output %s: unknown "+
+						"option %q, expected match or default", v.Line, name,
+						key)
+				}
+			case *ast.Table:
+				if childTable != nil {
+					return fmt.Errorf("line %d: route of output %s: outputs "+
+						"%s and %s, expected a single output", rt.Line, name,
+						child, key)
+				}
+				child, childTable = key, v
+			default:
+				return fmt.Errorf("line %d: route of output %s: invalid "+
+					"option %q", rt.Line, name, key)
+			}
+		}
+		if childTable == nil {
+			return fmt.Errorf("line %d: route of output %s: no output table",
+				rt.Line, name)
+		}
+		var route *internal_models.Route
+		if len(match) != 0 {
+			var err error
+			if route, err = internal_models.NewRoute(match); err != nil {
+				return optionError("match", matchKV, err)
+			}
+		}
+		ro, err := c.newRunningOutput(path, child, childTable)
+		if err != nil {
+			return fmt.Errorf("outputs.%s.route.%s: %s", name, child, err)
+		}
+		if ro == nil {
+			continue
+		}
+		if ro.Config.DeadLetter || ro.Config.Route != nil ||
+			ro.Config.RouteDefault {
+			return fmt.Errorf("outputs.%s.route.%s: dead_letter, route_match "+
+				"and route_default are options of top-level outputs", name,
+				child)
+		}
+		ro.Quiet = c.Agent.Quiet
+		router.AddRoute(route, isDefault, ro)
+	}
 	return nil
 }
 
-func (c *Config) addInput(name string, table *ast.Table) error {
+func (c *Config) addInput(path, name string, table *ast.Table) error {
 	if len(c.InputFilters) > 0 && !sliceContains(name, c.InputFilters) {
 		return nil
 	}
@@ -591,10 +1256,23 @@ func (c *Config) addInput(name string, table *ast.Table) error {
 	if name == "io" {
 		name = "diskio"
 	}
+	source := newTableSource(path, table)
+	if err := c.applyProfiles(table, source); err != nil {
+		return err
+	}
+	fp := fingerprint(table)
+	cond, err := buildCondition(table)
+	if err != nil {
+		return err
+	}
 
 	creator, ok := inputs.Inputs[name]
 	if !ok {
-		return fmt.Errorf("Undefined but requested input: %s", name)
+		var names []string
+		for n := range inputs.Inputs {
+			names = append(names, n)
+		}
+		return unknownPluginError("input", name, names)
 	}
 	input := creator()
 
@@ -613,20 +1291,103 @@ func (c *Config) addInput(name string, table *ast.Table) error {
 	if err != nil {
 		return err
 	}
+	pluginConfig.Fingerprint = fp
+	pluginConfig.Limits, err = buildLimits(table, internal_models.Limits{
+		MaxTags:           c.Agent.MaxTags,
+		MaxFields:         c.Agent.MaxFields,
+		MaxTagValueLength: c.Agent.MaxTagValueLength,
+		Policy:            c.Agent.LimitPolicy,
+	})
+	if err != nil {
+		return err
+	}
+
+	// An input with the alias of an earlier one replaces it, and keeps its
+	// default state key.
+	replaced := -1
+	for i, other := range c.Inputs {
+		if other.Name == name && pluginConfig.Alias != "" &&
+			other.Config.Alias == pluginConfig.Alias {
+			replaced = i
+		}
+	}
+	if pluginConfig.StateKey == "" {
+		if replaced >= 0 {
+			pluginConfig.StateKey = c.Inputs[replaced].Config.StateKey
+		} else {
+			pluginConfig.StateKey = c.defaultStateKey(name)
+		}
+	}
 
-	if err := config.UnmarshalTable(table, input); err != nil {
+	if err := c.unmarshalTable(path, "inputs."+name, table, input,
+		inputOptions...); err != nil {
 		return err
 	}
+	if !cond.met(newHostFacts()) {
+		log.Printf("Input [%s] at %s:%d is disabled by its enable_if\n", name,
+			path, table.Line)
+		return nil
+	}
 
 	rp := &internal_models.RunningInput{
 		Name:   name,
 		Input:  input,
 		Config: pluginConfig,
 	}
+	c.setSource(rp, source)
+	if replaced >= 0 {
+		c.Inputs[replaced] = rp
+		return nil
+	}
+	for _, other := range c.Inputs {
+		if other.Name == name && other.Config.Fingerprint == fp {
+			log.Printf("WARNING: input [%s] at %s:%d is configured identically"+
+				" to an earlier one, metrics are gathered twice\n",
+				name, path, table.Line)
+			break
+		}
+	}
 	c.Inputs = append(c.Inputs, rp)
 	return nil
 }
 
+// defaultStateKey returns the state key of an input without a state_key:
+// "inputs.<name>" for the first instance of the plugin, and
+// "inputs.<name>.<n>" for the n-th one.
+func (c *Config) defaultStateKey(name string) string {
+	n := 1
+	for _, input := range c.Inputs {
+		if input.Name == name {
+			n++
+		}
+	}
+	if n == 1 {
+		return "inputs." + name
+	}
+	return fmt.Sprintf("inputs.%s.%d", name, n)
+}
+
+// assignAliases gives the second and later unaliased instances of a plugin
+// the alias "<name>#<n>", where n is the instance's position among those of
+// the plugin. This way the logs and internal metrics tell them apart.
+func (c *Config) assignAliases() {
+	n := make(map[string]int)
+	for _, input := range c.Inputs {
+		n[input.Name]++
+		if n[input.Name] > 1 && input.Config.Alias == "" {
+			input.Config.Alias = fmt.Sprintf("%s#%d", input.Name, n[input.Name])
+		}
+	}
+	n = make(map[string]int)
+	for _, output := range c.Outputs {
+		n[output.Name]++
+		if n[output.Name] > 1 && output.Config.Alias == "" {
+			output.Config.Alias = fmt.Sprintf("%s#%d", output.Name,
+				n[output.Name])
+		}
+	}
+}
+
 // buildFilter builds a Filter
 // (tagpass/tagdrop/namepass/namedrop/fieldpass/fielddrop) to
 // be inserted into the internal_models.OutputConfig/internal_models.InputConfig
@@ -770,6 +1531,25 @@ func buildFilter(tbl *ast.Table) (internal_models.Filter, error) {
 	return f, nil
 }
 
+// inputOptions are the options every input has, besides the plugin's own.
+var inputOptions = []string{
+	"alias", "interval", "schedule", "schedule_timezone", "max_backoff",
+	"name_prefix", "name_suffix", "name_override", "route", "priority",
+	"state_key", "shard", "start_after", "start_timeout", "start_failure",
+	"tags", "namepass", "namedrop", "fieldpass", "fielddrop", "tagpass",
+	"tagdrop", "tagexclude", "taginclude", "enable_if", "tls_profile",
+	"auth_profile", "data_format", "max_tags", "max_fields",
+	"max_tag_value_length", "limit_policy",
+}
+
+// outputOptions are the options every output has, besides the plugin's own.
+var outputOptions = []string{
+	"alias", "dead_letter", "buffer_max_age", "serializer_workers",
+	"route_match", "route_default", "namepass", "namedrop", "fieldpass",
+	"fielddrop", "tagpass", "tagdrop", "tagexclude", "taginclude",
+	"enable_if", "tls_profile", "auth_profile", "data_format",
+}
+
 // buildInput parses input specific items from the ast.Table,
 // builds the filter and returns a
 // internal_models.InputConfig to be inserted into internal_models.RunningInput
@@ -780,7 +1560,7 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 			if str, ok := kv.Value.(*ast.String); ok {
 				dur, err := time.ParseDuration(str.Value)
 				if err != nil {
-					return nil, err
+					return nil, optionError("interval", kv, err)
 				}
 
 				cp.Interval = dur
@@ -788,20 +1568,64 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 		}
 	}
 
-	if node, ok := tbl.Fields["name_prefix"]; ok {
+	if node, ok := tbl.Fields["schedule"]; ok {
 		if kv, ok := node.(*ast.KeyValue); ok {
 			if str, ok := kv.Value.(*ast.String); ok {
-				cp.MeasurementPrefix = str.Value
+				if cp.Interval != 0 {
+					return nil, optionError("schedule", kv, errors.New(
+						"interval and schedule cannot both be set"))
+				}
+
+				loc := time.Local
+				if node, ok := tbl.Fields["schedule_timezone"]; ok {
+					if kv, ok := node.(*ast.KeyValue); ok {
+						if tz, ok := kv.Value.(*ast.String); ok {
+							var err error
+							loc, err = time.LoadLocation(tz.Value)
+							if err != nil {
+								return nil, optionError("schedule_timezone",
+									kv, err)
+							}
+						}
+					}
+				}
+
+				sched, err := cron.Parse(str.Value, loc)
+				if err != nil {
+					return nil, optionError("schedule", kv, err)
+				}
+				cp.Schedule = sched
 			}
 		}
 	}
 
-	if node, ok := tbl.Fields["name_suffix"]; ok {
+	if node, ok := tbl.Fields["max_backoff"]; ok {
 		if kv, ok := node.(*ast.KeyValue); ok {
 			if str, ok := kv.Value.(*ast.String); ok {
-				cp.MeasurementSuffix = str.Value
-			}
-		}
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, optionError("max_backoff", kv, err)
+				}
+
+				cp.MaxBackoff = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["name_prefix"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.MeasurementPrefix = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["name_suffix"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.MeasurementSuffix = str.Value
+			}
+		}
 	}
 
 	if node, ok := tbl.Fields["name_override"]; ok {
@@ -812,6 +1636,97 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 		}
 	}
 
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.Alias = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["route"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.Route = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["priority"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				p, err := internal_models.ParsePriority(str.Value)
+				if err != nil {
+					return nil, optionError("priority", kv, err, "low",
+						"normal", "high")
+				}
+				cp.Priority = p
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["state_key"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				cp.StateKey = str.Value
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["shard"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if b, ok := kv.Value.(*ast.Boolean); ok {
+				var err error
+				cp.Shard, err = b.Boolean()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["start_after"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						cp.StartAfter = append(cp.StartAfter, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["start_timeout"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, optionError("start_timeout", kv, err)
+				}
+
+				cp.StartTimeout = dur
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["start_failure"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				switch str.Value {
+				case internal_models.StartAnyway, internal_models.StartSkip,
+					internal_models.StartExit:
+					cp.StartFailure = str.Value
+				default:
+					return nil, optionError("start_failure", kv, errors.New(
+						"must be \"start\", \"skip\" or \"exit\""),
+						internal_models.StartAnyway, internal_models.StartSkip,
+						internal_models.StartExit)
+				}
+			}
+		}
+	}
+
 	cp.Tags = make(map[string]string)
 	if node, ok := tbl.Fields["tags"]; ok {
 		if subtbl, ok := node.(*ast.Table); ok {
@@ -824,7 +1739,18 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 	delete(tbl.Fields, "name_prefix")
 	delete(tbl.Fields, "name_suffix")
 	delete(tbl.Fields, "name_override")
+	delete(tbl.Fields, "alias")
 	delete(tbl.Fields, "interval")
+	delete(tbl.Fields, "schedule")
+	delete(tbl.Fields, "schedule_timezone")
+	delete(tbl.Fields, "max_backoff")
+	delete(tbl.Fields, "route")
+	delete(tbl.Fields, "priority")
+	delete(tbl.Fields, "state_key")
+	delete(tbl.Fields, "shard")
+	delete(tbl.Fields, "start_after")
+	delete(tbl.Fields, "start_timeout")
+	delete(tbl.Fields, "start_failure")
 	delete(tbl.Fields, "tags")
 	var err error
 	cp.Filter, err = buildFilter(tbl)
@@ -834,6 +1760,196 @@ func buildInput(name string, tbl *ast.Table) (*internal_models.InputConfig, erro
 	return cp, nil
 }
 
+// checkBackpressure validates the backpressure watermarks of the agent, and
+// defaults the low watermark to half of the high one.
+func checkBackpressure(ac *AgentConfig) error {
+	high, low := ac.BackpressureHighWatermark, ac.BackpressureLowWatermark
+	if high == 0 {
+		return nil
+	}
+	if high < 0 || high > 1 {
+		return fmt.Errorf("backpressure_high_watermark must be between 0 "+
+			"and 1, not %g", high)
+	}
+	if low == 0 {
+		ac.BackpressureLowWatermark = high / 2
+	} else if low < 0 || low >= high {
+		return fmt.Errorf("backpressure_low_watermark must be between 0 "+
+			"and backpressure_high_watermark, not %g", low)
+	}
+	return nil
+}
+
+// checkCluster validates the cluster peers of the agent.
+func checkCluster(ac *AgentConfig) error {
+	if len(ac.ClusterPeers) == 0 {
+		return nil
+	}
+	if !sliceContains(ac.ClusterSelf, ac.ClusterPeers) {
+		return fmt.Errorf("cluster_self %q is not one of the cluster_peers",
+			ac.ClusterSelf)
+	}
+	if ac.ClusterCheckInterval.Duration <= 0 {
+		return fmt.Errorf("cluster_check_interval must be positive")
+	}
+	return nil
+}
+
+// CheckStartAfter validates the start_after dependencies of the inputs, once
+// the whole configuration is loaded: they must name configured plugins, and
+// the inputs must not wait for each other in a cycle.
+func (c *Config) CheckStartAfter() error {
+	inputs := make(map[string][]string)
+	for _, input := range c.Inputs {
+		inputs[input.Name] = append(inputs[input.Name],
+			input.Config.StartAfter...)
+	}
+	outputs := make(map[string]bool)
+	for _, o := range c.Outputs {
+		outputs[o.Name] = true
+	}
+
+	for name, deps := range inputs {
+		for _, dep := range deps {
+			switch {
+			case strings.HasPrefix(dep, "outputs.") &&
+				outputs[strings.TrimPrefix(dep, "outputs.")]:
+			case strings.HasPrefix(dep, "inputs.") &&
+				c.hasInput(strings.TrimPrefix(dep, "inputs.")):
+			default:
+				return fmt.Errorf("input %s: start_after %q is not a "+
+					"configured plugin", name, dep)
+			}
+		}
+	}
+
+	// depth-first search of a cycle between the inputs
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	marks := make(map[string]int)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case visiting:
+			return fmt.Errorf("input %s: start_after has a cycle", name)
+		case visited:
+			return nil
+		}
+		marks[name] = visiting
+		for _, dep := range inputs[name] {
+			if strings.HasPrefix(dep, "inputs.") {
+				if err := visit(strings.TrimPrefix(dep, "inputs.")); err != nil {
+					return err
+				}
+			}
+		}
+		marks[name] = visited
+		return nil
+	}
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) hasInput(name string) bool {
+	for _, input := range c.Inputs {
+		if input.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// buildTracer builds the Tracer of the [agent.trace] table, which selects
+// metrics with the namepass/namedrop and tagpass/tagdrop filters.
+func buildTracer(tbl *ast.Table) (*internal_models.Tracer, error) {
+	filter, err := buildFilter(tbl)
+	if err != nil {
+		return nil, err
+	}
+	t := &internal_models.Tracer{
+		Filter:     filter,
+		SampleRate: 1,
+	}
+
+	if node, ok := tbl.Fields["sample_rate"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			switch v := kv.Value.(type) {
+			case *ast.Float:
+				t.SampleRate, err = v.Float()
+			case *ast.Integer:
+				var i int64
+				i, err = v.Int()
+				t.SampleRate = float64(i)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if t.SampleRate <= 0 || t.SampleRate > 1 {
+		return nil, fmt.Errorf("trace sample_rate must be in (0, 1], not %v",
+			t.SampleRate)
+	}
+	return t, nil
+}
+
+// buildLimits builds the Limits of an input, from its max_tags, max_fields,
+// max_tag_value_length and limit_policy options, or those of the agent for
+// the ones it does not set.
+func buildLimits(
+	tbl *ast.Table,
+	limits internal_models.Limits,
+) (internal_models.Limits, error) {
+	for key, limit := range map[string]*int{
+		"max_tags":             &limits.MaxTags,
+		"max_fields":           &limits.MaxFields,
+		"max_tag_value_length": &limits.MaxTagValueLength,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				integer, ok := kv.Value.(*ast.Integer)
+				if !ok {
+					return limits, optionError(key, kv,
+						errors.New("must be an integer"))
+				}
+				v, err := integer.Int()
+				if err != nil || v < 0 {
+					return limits, optionError(key, kv,
+						errors.New("must be zero or more"))
+				}
+				*limit = int(v)
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
+	if node, ok := tbl.Fields["limit_policy"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				if err := internal_models.CheckPolicy(str.Value); err != nil {
+					return limits, optionError("limit_policy", kv, err,
+						internal_models.LimitTruncate,
+						internal_models.LimitDrop)
+				}
+				limits.Policy = str.Value
+			}
+		}
+	}
+	delete(tbl.Fields, "limit_policy")
+	return limits, nil
+}
+
 // buildParser grabs the necessary entries from the ast.Table for creating
 // a parsers.Parser object, and creates it, which can then be added onto
 // an Input object.
@@ -895,6 +2011,121 @@ func buildParser(name string, tbl *ast.Table) (parsers.Parser, error) {
 		}
 	}
 
+	for key, value := range map[string]*string{
+		"protobuf_descriptor_set":  &c.ProtobufDescriptorSet,
+		"protobuf_message_type":    &c.ProtobufMessageType,
+		"protobuf_timestamp_path":  &c.ProtobufTimestampPath,
+		"protobuf_timestamp_unit":  &c.ProtobufTimestampUnit,
+		"cbor_query":               &c.CBORQuery,
+		"cbor_name_key":            &c.CBORNameKey,
+		"cbor_time_key":            &c.CBORTimeKey,
+		"cbor_time_format":         &c.CBORTimeFormat,
+		"parquet_timestamp_column": &c.ParquetTimestampColumn,
+		"parquet_timestamp_unit":   &c.ParquetTimestampUnit,
+		"parquet_time_after":       &c.ParquetTimeAfter,
+		"parquet_time_before":      &c.ParquetTimeBefore,
+		"syslog_format":            &c.SyslogFormat,
+		"syslog_timezone":          &c.SyslogTimezone,
+		"syslog_year":              &c.SyslogYear,
+		"xml_query":                &c.XMLQuery,
+		"xml_time_key":             &c.XMLTimeKey,
+		"xml_time_format":          &c.XMLTimeFormat,
+		"binary_endianness":        &c.BinaryEndianness,
+		"binary_time_key":          &c.BinaryTimeKey,
+		"binary_time_format":       &c.BinaryTimeFormat,
+		"csv_delimiter":            &c.CSVDelimiter,
+		"csv_timestamp_column":     &c.CSVTimestampColumn,
+		"csv_timestamp_format":     &c.CSVTimestampFormat,
+		"cobol_copybook":           &c.COBOLCopybook,
+		"cobol_record":             &c.COBOLRecord,
+		"cobol_encoding":           &c.COBOLEncoding,
+		"cobol_record_format":      &c.COBOLRecordFormat,
+		"cobol_time_key":           &c.COBOLTimeKey,
+		"cobol_time_format":        &c.COBOLTimeFormat,
+		"keyvalue_pair_delimiter":  &c.KeyValuePairDelimiter,
+		"keyvalue_delimiter":       &c.KeyValueDelimiter,
+		"keyvalue_quotes":          &c.KeyValueQuotes,
+		"keyvalue_time_key":        &c.KeyValueTimeKey,
+		"keyvalue_time_format":     &c.KeyValueTimeFormat,
+		"influx_parse_errors":      &c.InfluxParseErrors,
+		"influx_error_measurement": &c.InfluxErrorMeasurement,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				if str, ok := kv.Value.(*ast.String); ok {
+					*value = str.Value
+				}
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
+	for key, values := range map[string]*[]string{
+		"protobuf_proto_files":  &c.ProtobufProtoFiles,
+		"protobuf_import_paths": &c.ProtobufImportPaths,
+		"protobuf_fields":       &c.ProtobufFields,
+		"parquet_fields":        &c.ParquetFields,
+		"w3c_field_types":       &c.W3CFieldTypes,
+		"w3c_fields":            &c.W3CFields,
+		"csv_column_names":      &c.CSVColumnNames,
+		"csv_column_types":      &c.CSVColumnTypes,
+		"csv_null_values":       &c.CSVNullValues,
+		"keyvalue_types":        &c.KeyValueTypes,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				if ary, ok := kv.Value.(*ast.Array); ok {
+					for _, elem := range ary.Value {
+						if str, ok := elem.(*ast.String); ok {
+							*values = append(*values, str.Value)
+						}
+					}
+				}
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
+	if node, ok := tbl.Fields["csv_infer_rows"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if integer, ok := kv.Value.(*ast.Integer); ok {
+				v, err := integer.Int()
+				if err != nil {
+					return nil, err
+				}
+				c.CSVInferRows = int(v)
+			}
+		}
+	}
+	delete(tbl.Fields, "csv_infer_rows")
+
+	if node, ok := tbl.Fields["influx_error_payload_size"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if integer, ok := kv.Value.(*ast.Integer); ok {
+				v, err := integer.Int()
+				if err != nil {
+					return nil, err
+				}
+				c.InfluxErrorPayloadSize = int(v)
+			}
+		}
+	}
+	delete(tbl.Fields, "influx_error_payload_size")
+
+	if node, ok := tbl.Fields["binary_fields"]; ok {
+		if subtbls, ok := node.([]*ast.Table); ok {
+			c.BinaryFields = make([]binary.Field, len(subtbls))
+			for i, subtbl := range subtbls {
+				err := config.UnmarshalTable(subtbl, &c.BinaryFields[i])
+				if err != nil {
+					return nil, fmt.Errorf("invalid binary_fields in %s: %s",
+						name, err)
+				}
+			}
+		}
+	}
+	delete(tbl.Fields, "binary_fields")
+
 	c.MetricName = name
 
 	delete(tbl.Fields, "data_format")
@@ -940,9 +2171,126 @@ func buildSerializer(name string, tbl *ast.Table) (serializers.Serializer, error
 		}
 	}
 
+	for key, value := range map[string]*string{
+		"influx_float_format":     &c.InfluxFloatFormat,
+		"json_layout":             &c.JSONLayout,
+		"json_timestamp_key":      &c.JSONTimestampKey,
+		"json_timestamp_format":   &c.JSONTimestampFormat,
+		"parquet_compression":     &c.ParquetCompression,
+		"parquet_timestamp_unit":  &c.ParquetTimestampUnit,
+		"avro_schema_registry":    &c.AvroSchemaRegistry,
+		"avro_namespace":          &c.AvroNamespace,
+		"template_header":         &c.TemplateHeader,
+		"template_footer":         &c.TemplateFooter,
+		"msgpack_format":          &c.MsgpackFormat,
+		"msgpack_forward_tag":     &c.MsgpackForwardTag,
+		"msgpack_batch":           &c.MsgpackBatch,
+		"graphite_sanitize_mode":  &c.GraphiteSanitizeMode,
+		"splunkmetric_index":      &c.SplunkMetricIndex,
+		"splunkmetric_source":     &c.SplunkMetricSource,
+		"splunkmetric_sourcetype": &c.SplunkMetricSourceType,
+		"emf_namespace":           &c.EMFNamespace,
+		"emf_namespace_tag":       &c.EMFNamespaceTag,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				if str, ok := kv.Value.(*ast.String); ok {
+					*value = str.Value
+				}
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
+	if node, ok := tbl.Fields["parquet_schema"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						c.ParquetSchema = append(c.ParquetSchema, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["otlp_resource_tags"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						c.OTLPResourceTags = append(c.OTLPResourceTags, str.Value)
+					}
+				}
+			}
+		}
+	}
+
+	for key, values := range map[string]*[]string{
+		"graphite_templates":    &c.GraphiteTemplates,
+		"graphite_replacements": &c.GraphiteReplacements,
+		"emf_dimensions":        &c.EMFDimensions,
+		"emf_units":             &c.EMFUnits,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				if ary, ok := kv.Value.(*ast.Array); ok {
+					for _, elem := range ary.Value {
+						if str, ok := elem.(*ast.String); ok {
+							*values = append(*values, str.Value)
+						}
+					}
+				}
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
+	for key, value := range map[string]*int{
+		"parquet_row_group_size": &c.ParquetRowGroupSize,
+		"influx_float_precision": &c.InfluxFloatPrecision,
+		"graphite_max_length":    &c.GraphiteMaxLength,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				if integer, ok := kv.Value.(*ast.Integer); ok {
+					v, err := integer.Int()
+					if err != nil {
+						return nil, err
+					}
+					*value = int(v)
+				}
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
+	for key, value := range map[string]*bool{
+		"influx_uint_support":      &c.InfluxUintSupport,
+		"influx_unsorted_tags":     &c.InfluxUnsortedTags,
+		"influx_strict":            &c.InfluxStrict,
+		"splunkmetric_hec_routing": &c.SplunkMetricHECRouting,
+		"splunkmetric_multimetric": &c.SplunkMetricMultiMetric,
+	} {
+		if node, ok := tbl.Fields[key]; ok {
+			if kv, ok := node.(*ast.KeyValue); ok {
+				if b, ok := kv.Value.(*ast.Boolean); ok {
+					v, err := b.Boolean()
+					if err != nil {
+						return nil, err
+					}
+					*value = v
+				}
+			}
+		}
+		delete(tbl.Fields, key)
+	}
+
 	delete(tbl.Fields, "data_format")
 	delete(tbl.Fields, "prefix")
 	delete(tbl.Fields, "template")
+	delete(tbl.Fields, "parquet_schema")
+	delete(tbl.Fields, "otlp_resource_tags")
 	return serializers.NewSerializer(c)
 }
 
@@ -959,6 +2307,90 @@ func buildOutput(name string, tbl *ast.Table) (*internal_models.OutputConfig, er
 		Name:   name,
 		Filter: filter,
 	}
+
+	if node, ok := tbl.Fields["alias"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				oc.Alias = str.Value
+			}
+		}
+	}
+	delete(tbl.Fields, "alias")
+
+	if node, ok := tbl.Fields["dead_letter"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if b, ok := kv.Value.(*ast.Boolean); ok {
+				oc.DeadLetter, err = b.Boolean()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	delete(tbl.Fields, "dead_letter")
+
+	if node, ok := tbl.Fields["buffer_max_age"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				dur, err := time.ParseDuration(str.Value)
+				if err != nil {
+					return nil, optionError("buffer_max_age", kv, err)
+				}
+
+				oc.BufferMaxAge = dur
+			}
+		}
+	}
+	delete(tbl.Fields, "buffer_max_age")
+
+	if node, ok := tbl.Fields["serializer_workers"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if integer, ok := kv.Value.(*ast.Integer); ok {
+				v, err := integer.Int()
+				if err != nil {
+					return nil, err
+				}
+				oc.SerializerWorkers = int(v)
+			}
+		}
+	}
+	delete(tbl.Fields, "serializer_workers")
+
+	if node, ok := tbl.Fields["route_match"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if ary, ok := kv.Value.(*ast.Array); ok {
+				var exprs []string
+				for _, elem := range ary.Value {
+					if str, ok := elem.(*ast.String); ok {
+						exprs = append(exprs, str.Value)
+					}
+				}
+				oc.Route, err = internal_models.NewRoute(exprs)
+				if err != nil {
+					return nil, optionError("route_match", kv, err)
+				}
+			}
+		}
+	}
+
+	if node, ok := tbl.Fields["route_default"]; ok {
+		if kv, ok := node.(*ast.KeyValue); ok {
+			if b, ok := kv.Value.(*ast.Boolean); ok {
+				oc.RouteDefault, err = b.Boolean()
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	delete(tbl.Fields, "route_match")
+	delete(tbl.Fields, "route_default")
+
+	if oc.DeadLetter && (oc.Route != nil || oc.RouteDefault) {
+		return nil, fmt.Errorf("output %s: dead letter outputs cannot be "+
+			"routed", name)
+	}
+
 	// Outputs don't support FieldDrop/FieldPass, so set to NameDrop/NamePass
 	if len(oc.Filter.FieldDrop) > 0 {
 		oc.Filter.NameDrop = oc.Filter.FieldDrop
@@ -1,18 +1,27 @@
 package config
 
 import (
+	"bytes"
+	"log"
 	"os"
 	"testing"
 	"time"
 
+	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/plugins/external"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/inputs/exec"
+	"github.com/influxdata/telegraf/plugins/inputs/http_response"
 	"github.com/influxdata/telegraf/plugins/inputs/memcached"
 	"github.com/influxdata/telegraf/plugins/inputs/procstat"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	_ "github.com/influxdata/telegraf/plugins/outputs/file"
+	"github.com/influxdata/telegraf/plugins/outputs/influxdb"
 	"github.com/influxdata/telegraf/plugins/parsers"
-
+	"github.com/influxdata/toml"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestConfig_LoadSingleInputWithEnvVars(t *testing.T) {
@@ -50,11 +59,13 @@ func TestConfig_LoadSingleInputWithEnvVars(t *testing.T) {
 		Name:     "memcached",
 		Filter:   filter,
 		Interval: 10 * time.Second,
+		StateKey: "inputs.memcached",
 	}
 	mConfig.Tags = make(map[string]string)
 
 	assert.Equal(t, memcached, c.Inputs[0].Input,
 		"Testdata did not produce a correct memcached struct.")
+	mConfig.Fingerprint = c.Inputs[0].Config.Fingerprint
 	assert.Equal(t, mConfig, c.Inputs[0].Config,
 		"Testdata did not produce correct memcached metadata.")
 }
@@ -90,11 +101,13 @@ func TestConfig_LoadSingleInput(t *testing.T) {
 		Name:     "memcached",
 		Filter:   filter,
 		Interval: 5 * time.Second,
+		StateKey: "inputs.memcached",
 	}
 	mConfig.Tags = make(map[string]string)
 
 	assert.Equal(t, memcached, c.Inputs[0].Input,
 		"Testdata did not produce a correct memcached struct.")
+	mConfig.Fingerprint = c.Inputs[0].Config.Fingerprint
 	assert.Equal(t, mConfig, c.Inputs[0].Config,
 		"Testdata did not produce correct memcached metadata.")
 }
@@ -137,11 +150,13 @@ func TestConfig_LoadDirectory(t *testing.T) {
 		Name:     "memcached",
 		Filter:   filter,
 		Interval: 5 * time.Second,
+		StateKey: "inputs.memcached",
 	}
 	mConfig.Tags = make(map[string]string)
 
 	assert.Equal(t, memcached, c.Inputs[0].Input,
 		"Testdata did not produce a correct memcached struct.")
+	mConfig.Fingerprint = c.Inputs[0].Config.Fingerprint
 	assert.Equal(t, mConfig, c.Inputs[0].Config,
 		"Testdata did not produce correct memcached metadata.")
 
@@ -153,27 +168,411 @@ func TestConfig_LoadDirectory(t *testing.T) {
 	eConfig := &internal_models.InputConfig{
 		Name:              "exec",
 		MeasurementSuffix: "_myothercollector",
+		StateKey:          "inputs.exec",
 	}
 	eConfig.Tags = make(map[string]string)
 	assert.Equal(t, ex, c.Inputs[1].Input,
 		"Merged Testdata did not produce a correct exec struct.")
+	eConfig.Fingerprint = c.Inputs[1].Config.Fingerprint
 	assert.Equal(t, eConfig, c.Inputs[1].Config,
 		"Merged Testdata did not produce correct exec metadata.")
 
 	memcached.Servers = []string{"192.168.1.1"}
 	assert.Equal(t, memcached, c.Inputs[2].Input,
 		"Testdata did not produce a correct memcached struct.")
+	mConfig.Fingerprint = c.Inputs[2].Config.Fingerprint
+	mConfig.StateKey = "inputs.memcached.2"
+	mConfig.Alias = "memcached#2"
 	assert.Equal(t, mConfig, c.Inputs[2].Config,
 		"Testdata did not produce correct memcached metadata.")
 
 	pstat := inputs.Inputs["procstat"]().(*procstat.Procstat)
 	pstat.PidFile = "/var/run/grafana-server.pid"
 
-	pConfig := &internal_models.InputConfig{Name: "procstat"}
+	pConfig := &internal_models.InputConfig{
+		Name:     "procstat",
+		StateKey: "inputs.procstat",
+	}
 	pConfig.Tags = make(map[string]string)
 
 	assert.Equal(t, pstat, c.Inputs[3].Input,
 		"Merged Testdata did not produce a correct procstat struct.")
+	pConfig.Fingerprint = c.Inputs[3].Config.Fingerprint
 	assert.Equal(t, pConfig, c.Inputs[3].Config,
 		"Merged Testdata did not produce correct procstat metadata.")
 }
+
+func TestConfig_Fingerprint(t *testing.T) {
+	c1 := NewConfig()
+	assert.NoError(t, c1.LoadConfig("./testdata/single_plugin.toml"))
+	c2 := NewConfig()
+	assert.NoError(t, c2.LoadConfig("./testdata/single_plugin.toml"))
+	assert.NoError(t, c2.LoadDirectory("./testdata/subconfig"))
+
+	// identical tables produce identical fingerprints
+	assert.NotEmpty(t, c1.Inputs[0].Config.Fingerprint)
+	assert.Equal(t, c1.Inputs[0].Config.Fingerprint,
+		c2.Inputs[0].Config.Fingerprint)
+
+	// the second memcached input only differs by its servers
+	assert.NotEqual(t, c2.Inputs[0].Config.Fingerprint,
+		c2.Inputs[2].Config.Fingerprint)
+}
+
+func TestConfig_PluginDirectory(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/external_plugin.toml"))
+	assert.Equal(t, 1, len(c.Inputs))
+
+	ri := c.Inputs[0]
+	assert.Equal(t, "external_example", ri.Name)
+	assert.Equal(t, "ext_", ri.Config.MeasurementPrefix)
+	input, ok := ri.Input.(*external.Input)
+	assert.True(t, ok)
+	assert.Equal(t, time.Second, input.RestartDelay.Duration)
+	assert.Equal(t, "Example external plugin", input.Description())
+}
+
+func TestConfig_Trace(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/trace.toml"))
+	assert.NotNil(t, c.Tracer)
+	assert.Equal(t, 0.5, c.Tracer.SampleRate)
+	assert.Equal(t, []string{"cpu"}, c.Tracer.Filter.NamePass)
+	assert.True(t, c.Tracer.Filter.ShouldNamePass("cpu"))
+	assert.False(t, c.Tracer.Filter.ShouldNamePass("mem"))
+	assert.Equal(t, "10s", c.Agent.Interval.Duration.String())
+}
+
+func TestConfig_Schedule(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/schedule.toml"))
+	assert.Equal(t, 1, len(c.Inputs))
+
+	schedule := c.Inputs[0].Config.Schedule
+	assert.NotNil(t, schedule)
+	from := time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC)
+	assert.Equal(t, time.Date(2016, 6, 13, 18, 0, 0, 0, time.UTC),
+		schedule.Next(from))
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/schedule_interval.toml"))
+}
+
+func TestConfig_Route(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/route.toml"))
+	assert.Equal(t, []string{"route=drop", "_name=debug_*"}, c.RouteDrop.Exprs)
+	assert.Equal(t, "billing", c.Inputs[0].Config.Route)
+
+	assert.Equal(t, 4, len(c.Outputs))
+	assert.Equal(t, []string{"route=billing"}, c.Outputs[0].Config.Route.Exprs)
+	assert.Equal(t, []string{"_name=cpu host!=test*"},
+		c.Outputs[1].Config.Route.Exprs)
+	assert.Nil(t, c.Outputs[2].Config.Route)
+	assert.True(t, c.Outputs[2].Config.RouteDefault)
+	assert.Nil(t, c.Outputs[3].Config.Route)
+	assert.False(t, c.Outputs[3].Config.RouteDefault)
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/route_dead_letter.toml"))
+}
+
+// testRouter is a router output that holds the routes to its child outputs.
+type testRouter struct {
+	routes   []*internal_models.Route
+	defaults []bool
+	children []*internal_models.RunningOutput
+}
+
+func (r *testRouter) AddRoute(route *internal_models.Route, isDefault bool,
+	output *internal_models.RunningOutput) {
+	r.routes = append(r.routes, route)
+	r.defaults = append(r.defaults, isDefault)
+	r.children = append(r.children, output)
+}
+
+func (r *testRouter) Connect() error                  { return nil }
+func (r *testRouter) Close() error                    { return nil }
+func (r *testRouter) Description() string             { return "" }
+func (r *testRouter) SampleConfig() string            { return "" }
+func (r *testRouter) Write(_ []telegraf.Metric) error { return nil }
+
+func TestConfig_Router(t *testing.T) {
+	outputs.Add("test_router", func() telegraf.Output { return &testRouter{} })
+	defer delete(outputs.Outputs, "test_router")
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig("./testdata/router.toml"))
+	require.Equal(t, 1, len(c.Outputs))
+	r := c.Outputs[0].Output.(*testRouter)
+	require.Len(t, r.children, 3)
+	assert.Equal(t, []string{"route=billing", "_name=cpu"}, r.routes[0].Exprs)
+	assert.False(t, r.defaults[0])
+	assert.Equal(t, "file", r.children[0].Name)
+	assert.Equal(t, []string{"cpu*"}, r.children[0].Config.Filter.NamePass)
+	assert.Nil(t, r.routes[1])
+	assert.True(t, r.defaults[1])
+	assert.Nil(t, r.routes[2])
+	assert.False(t, r.defaults[2])
+	assert.Equal(t, "all", r.children[2].Config.Alias)
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/router_invalid.toml"))
+}
+
+func TestConfig_Backpressure(t *testing.T) {
+	ac := &AgentConfig{BackpressureHighWatermark: 0.8}
+	assert.NoError(t, checkBackpressure(ac))
+	assert.Equal(t, 0.4, ac.BackpressureLowWatermark)
+
+	assert.NoError(t, checkBackpressure(&AgentConfig{}))
+	assert.Error(t, checkBackpressure(
+		&AgentConfig{BackpressureHighWatermark: 1.5}))
+	assert.Error(t, checkBackpressure(&AgentConfig{
+		BackpressureHighWatermark: 0.5,
+		BackpressureLowWatermark:  0.6,
+	}))
+}
+
+func TestConfig_Priority(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/priority.toml"))
+	assert.Equal(t, 2, len(c.Inputs))
+	priorities := map[string]int{}
+	for _, input := range c.Inputs {
+		priorities[input.Name] = input.Config.Priority
+	}
+	assert.Equal(t, map[string]int{"memcached": 1, "procstat": -1}, priorities)
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/priority_invalid.toml"))
+}
+
+func TestConfig_Limits(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/limits.toml"))
+	require.Equal(t, 2, len(c.Inputs))
+	limits := map[string]*internal_models.Limits{}
+	for _, input := range c.Inputs {
+		limits[input.Name] = &input.Config.Limits
+	}
+	l := limits["memcached"]
+	assert.Equal(t, 16, l.MaxTags)
+	assert.Equal(t, 0, l.MaxFields)
+	assert.Equal(t, 128, l.MaxTagValueLength)
+	assert.Equal(t, "", l.Policy)
+	l = limits["procstat"]
+	assert.Equal(t, 8, l.MaxTags)
+	assert.Equal(t, 32, l.MaxFields)
+	assert.Equal(t, 128, l.MaxTagValueLength)
+	assert.Equal(t, internal_models.LimitDrop, l.Policy)
+
+	c = NewConfig()
+	err := c.LoadConfig("./testdata/limits_invalid.toml")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `did you mean "truncate"?`)
+}
+
+func TestConfig_StateKey(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/state.toml"))
+	assert.Equal(t, "file:///var/lib/telegraf/state", c.Agent.StateStore)
+	assert.Equal(t, 4096, c.Agent.StateMaxSize)
+	var keys []string
+	for _, input := range c.Inputs {
+		keys = append(keys, input.Config.StateKey)
+	}
+	assert.Equal(t,
+		[]string{"inputs.memcached", "inputs.memcached.2", "cache"}, keys)
+}
+
+func TestConfig_Cluster(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/cluster.toml"))
+	assert.Equal(t, "http://10.0.0.2:8126", c.Agent.ClusterSelf)
+	assert.Equal(t, 10*time.Second, c.Agent.ClusterCheckInterval.Duration)
+	assert.Equal(t, 2, len(c.Inputs))
+	// the inputs of different plugins are not loaded in a fixed order
+	for _, input := range c.Inputs {
+		assert.Equal(t, input.Name == "memcached", input.Config.Shard)
+	}
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/cluster_invalid.toml"))
+}
+
+func TestConfig_BufferCompression(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/buffer_compression.toml"))
+	assert.Equal(t, 1, len(c.Outputs))
+	assert.True(t, c.Outputs[0].BufferCompressed)
+	assert.Equal(t, 1000000, c.Outputs[0].MetricBufferLimit)
+}
+
+func TestConfig_StartAfter(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/start_after.toml"))
+	assert.NoError(t, c.CheckStartAfter())
+	assert.Equal(t, 2, len(c.Inputs))
+	memcached, exec := c.Inputs[0], c.Inputs[1]
+	if memcached.Name != "memcached" {
+		memcached, exec = exec, memcached
+	}
+	assert.Equal(t, []string{"inputs.exec", "outputs.file"},
+		memcached.Config.StartAfter)
+	assert.Equal(t, 30*time.Second, memcached.Config.StartTimeout)
+	assert.Equal(t, "skip", memcached.Config.StartFailure)
+	assert.Empty(t, exec.Config.StartAfter)
+
+	memcached.Config.StartAfter = []string{"outputs.influxdb"}
+	assert.Error(t, c.CheckStartAfter())
+
+	c = NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/start_after_cycle.toml"))
+	assert.Error(t, c.CheckStartAfter())
+}
+
+func TestConfig_BufferMaxAge(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/buffer_max_age.toml"))
+	assert.Equal(t, 1, len(c.Outputs))
+	assert.Equal(t, time.Hour, c.Outputs[0].Config.BufferMaxAge)
+}
+
+func TestConfig_Include(t *testing.T) {
+	os.Setenv("TELEGRAF_ENVIRONMENT", "prod")
+	defer os.Unsetenv("TELEGRAF_ENVIRONMENT")
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/include/telegraf.toml"))
+	assert.Equal(t, []string{
+		"testdata/include/conf.d/memcached.toml",
+		"testdata/include/overrides/prod/overrides.toml",
+	}, c.Included)
+
+	// the overlay overrides the agent settings for every plugin
+	assert.Equal(t, 30*time.Second, c.Agent.Interval.Duration)
+	assert.Equal(t, 1, len(c.Outputs))
+	assert.Equal(t, 500, c.Outputs[0].MetricBatchSize)
+
+	// and replaces the plugins of the same alias, in place
+	assert.Equal(t, 2, len(c.Inputs))
+	local := c.Inputs[0].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"192.168.1.1"}, local.Servers)
+	assert.Equal(t, "local", c.Inputs[0].Config.Alias)
+	assert.Equal(t, "inputs.memcached", c.Inputs[0].Config.StateKey)
+	other := c.Inputs[1].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"10.0.0.1"}, other.Servers)
+}
+
+func TestConfig_IncludeUnsetVariable(t *testing.T) {
+	os.Unsetenv("TELEGRAF_ENVIRONMENT")
+	c := NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/include/telegraf.toml"))
+}
+
+func TestConfig_IncludeCycle(t *testing.T) {
+	c := NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/include_cycle/a.toml"))
+}
+
+func TestConfig_SerializerWorkers(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/serializer_workers.toml"))
+	assert.Equal(t, 1, len(c.Outputs))
+	assert.Equal(t, 4, c.Outputs[0].Config.SerializerWorkers)
+}
+
+func TestConfig_Duplicate(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/duplicate.toml"))
+	assert.Contains(t, buf.String(), "input [memcached] at "+
+		"./testdata/duplicate.toml:8 is configured identically")
+	assert.NotContains(t, buf.String(), "output [file]")
+
+	// the second and later instances are told apart by their position
+	var aliases []string
+	for _, input := range c.Inputs {
+		aliases = append(aliases, input.Config.Alias)
+	}
+	assert.Equal(t, []string{"", "remote", "memcached#3"}, aliases)
+	assert.Equal(t, "memcached#3", c.Inputs[2].LogName())
+	assert.Equal(t, "", c.Outputs[0].Config.Alias)
+	assert.Equal(t, "file#2", c.Outputs[1].Config.Alias)
+}
+
+func TestConfig_Experimental(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/experimental.toml"))
+	assert.Equal(t, map[string]bool{"priority_batches": true}, c.Experimental)
+	assert.Equal(t, 1, len(c.Inputs))
+
+	c = NewConfig()
+	err := c.LoadConfig("./testdata/experimental_invalid.toml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "no_such_flag")
+}
+
+func TestConfig_Profiles(t *testing.T) {
+	c := NewConfig()
+	assert.NoError(t, c.LoadConfig("./testdata/profiles/telegraf.toml"))
+
+	assert.Equal(t, 1, len(c.Outputs))
+	influx := c.Outputs[0].Output.(*influxdb.InfluxDB)
+	assert.Equal(t, "/etc/telegraf/ca.pem", influx.SSLCA)
+	assert.Equal(t, "/etc/telegraf/key.pem", influx.SSLKey)
+	assert.Equal(t, "telegraf", influx.Username)
+	assert.Equal(t, "secret", influx.Password)
+
+	// the options of the plugin override those of the profile
+	assert.Equal(t, 1, len(c.Inputs))
+	http := c.Inputs[0].Input.(*http_response.HTTPResponse)
+	assert.Equal(t, "/etc/telegraf/ca.pem", http.SSLCA)
+	assert.Equal(t, "/etc/telegraf/other.pem", http.SSLKey)
+
+	c = NewConfig()
+	err := c.LoadConfig("./testdata/profiles/undefined.toml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `tls_profile "corp" is not defined`)
+
+	c = NewConfig()
+	err = c.LoadConfig("./testdata/profiles/invalid.toml")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "[tls_profiles.corp] cannot set username")
+}
+
+func TestBuildParser_BinaryFields(t *testing.T) {
+	tbl, err := toml.Parse([]byte(`
+data_format = "binary"
+binary_endianness = "little"
+binary_fields = [
+  { name = "temperature", type = "int16" },
+  { name = "status", type = "uint8", offset = 4, bits = 2 },
+]
+`))
+	require.NoError(t, err)
+	p, err := buildParser("udp_listener", tbl)
+	require.NoError(t, err)
+	assert.Empty(t, tbl.Fields)
+
+	metrics, err := p.Parse([]byte{0xfe, 0xff, 0, 0, 0x07})
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "udp_listener", metrics[0].Name())
+	assert.Equal(t, map[string]interface{}{
+		"temperature": int64(-2),
+		"status":      int64(3),
+	}, metrics[0].Fields())
+
+	tbl, err = toml.Parse([]byte(`
+data_format = "binary"
+binary_fields = [{ name = "a", type = "uint8", nope = 1 }]
+`))
+	require.NoError(t, err)
+	_, err = buildParser("udp_listener", tbl)
+	assert.Error(t, err)
+}
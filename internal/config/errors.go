@@ -0,0 +1,391 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/config"
+	"github.com/influxdata/toml/ast"
+)
+
+// ParseError is an error in a configuration file option. It records where
+// the option is. If the option or its value looks misspelled, it also holds
+// the closest valid one.
+type ParseError struct {
+	Path string
+	Line int
+	// Table holds the option, such as inputs.memcached or agent
+	Table string
+	Key   string
+	// Value is the option value, as written in the file
+	Value string
+	Err   error
+	// Suggestion is the closest valid option or value, if any is close
+	Suggestion string
+}
+
+func (e *ParseError) Error() string {
+	var b bytes.Buffer
+	if e.Path != "" {
+		fmt.Fprintf(&b, "%s:%d: ", e.Path, e.Line)
+	} else {
+		fmt.Fprintf(&b, "line %d: ", e.Line)
+	}
+	if e.Table != "" {
+		fmt.Fprintf(&b, "[%s] ", e.Table)
+	}
+	if e.Key != "" {
+		b.WriteString(e.Key)
+		if e.Value != "" {
+			fmt.Fprintf(&b, " = %s", e.Value)
+		}
+		b.WriteString(": ")
+	}
+	b.WriteString(e.Err.Error())
+	if e.Suggestion != "" {
+		fmt.Fprintf(&b, ", did you mean %q?", e.Suggestion)
+	}
+	return b.String()
+}
+
+// optionError returns the ParseError for an option value. It suggests the
+// valid value closest to it, if any.
+func optionError(key string, kv *ast.KeyValue, err error,
+	valid ...string) error {
+	e := &ParseError{
+		Line:  kv.Line - strings.Count(kv.Value.Source(), "\n"),
+		Key:   key,
+		Value: kv.Value.Source(),
+		Err:   err,
+	}
+	if str, ok := kv.Value.(*ast.String); ok {
+		e.Suggestion = suggest(str.Value, valid)
+	}
+	return e
+}
+
+// unmarshalTable unmarshals a table like config.UnmarshalTable, but returns
+// ParseErrors. An option the struct does not have gets a suggestion: the
+// closest struct option or entry in options. Such options are an error in
+// strict mode, and are otherwise ignored with a warning.
+func (c *Config) unmarshalTable(
+	path, table string,
+	tbl *ast.Table,
+	v interface{},
+	options ...string,
+) error {
+	if u, ok := v.(tableUnmarshaler); ok {
+		if err := u.UnmarshalTable(tbl); err != nil {
+			return &ParseError{Line: tbl.Line,
+				Err: errors.New(unmarshalCause(err))}
+		}
+		return nil
+	}
+
+	typ := reflect.TypeOf(v)
+	unknown := unknownOptions(tbl, typ, options)
+	if len(unknown) > 0 {
+		if c.Agent.Strict {
+			return unknown[0].err
+		}
+		for _, u := range unknown {
+			log.Printf("WARNING: %s, ignored\n", fileError(path, table, u.err))
+			delete(u.tbl.Fields, u.field)
+		}
+	}
+
+	err := config.UnmarshalTable(tbl, v)
+	if err == nil {
+		return nil
+	}
+	// report the first option in the file that fails on its own
+	elem := typ
+	if elem.Kind() == reflect.Ptr {
+		elem = elem.Elem()
+	}
+	for _, key := range sortedFields(tbl) {
+		one := *tbl
+		one.Fields = map[string]interface{}{key: tbl.Fields[key]}
+		keyErr := config.UnmarshalTable(&one, reflect.New(elem).Interface())
+		if keyErr == nil {
+			continue
+		}
+		e := &ParseError{Line: fieldLine(tbl.Fields[key]), Key: key,
+			Err: errors.New(unmarshalCause(keyErr))}
+		if kv, ok := tbl.Fields[key].(*ast.KeyValue); ok {
+			e.Value = kv.Value.Source()
+		}
+		return e
+	}
+	return &ParseError{Line: tbl.Line, Err: errors.New(unmarshalCause(err))}
+}
+
+// tableUnmarshaler is implemented by plugins whose tables have options
+// beyond their struct fields, such as the external plugins.
+type tableUnmarshaler interface {
+	UnmarshalTable(tbl *ast.Table) error
+}
+
+// unknownOption is an option in a table that the target struct does not
+// have.
+type unknownOption struct {
+	// tbl is the table holding the option. It is a subtable of the one
+	// unmarshaled if the option belongs to a nested struct. field is the
+	// option key in tbl
+	tbl   *ast.Table
+	field string
+	err   *ParseError
+}
+
+// unknownOptions returns the options in a table and its subtables that have
+// no field in the type, in file order. Options are matched to fields like
+// config.UnmarshalTable does: by the toml tag, or by the field name, which
+// is the key in camel case.
+func unknownOptions(
+	tbl *ast.Table,
+	typ reflect.Type,
+	options []string,
+) []unknownOption {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct ||
+		reflect.PtrTo(typ).Implements(unmarshalerType) {
+		return nil
+	}
+
+	var unknown []unknownOption
+	for _, key := range sortedFields(tbl) {
+		val := tbl.Fields[key]
+		sf, ok := fieldByKey(typ, key)
+		if !ok {
+			candidates := append(structKeys(typ), options...)
+			e := &ParseError{
+				Line:       fieldLine(val),
+				Key:        key,
+				Err:        errUnknownOption,
+				Suggestion: suggest(key, candidates),
+			}
+			if kv, ok := val.(*ast.KeyValue); ok {
+				e.Value = kv.Value.Source()
+			}
+			unknown = append(unknown, unknownOption{tbl, key, e})
+			continue
+		}
+
+		// the subtable options are checked against the field's struct
+		var subtables []*ast.Table
+		elem := sf.Type
+		switch v := val.(type) {
+		case *ast.Table:
+			subtables = []*ast.Table{v}
+		case []*ast.Table:
+			subtables = v
+			for elem.Kind() == reflect.Ptr {
+				elem = elem.Elem()
+			}
+			if elem.Kind() == reflect.Slice || elem.Kind() == reflect.Array {
+				elem = elem.Elem()
+			}
+		}
+		for _, sub := range subtables {
+			for _, u := range unknownOptions(sub, elem, nil) {
+				u.err.Key = key + "." + u.err.Key
+				unknown = append(unknown, u)
+			}
+		}
+	}
+	return unknown
+}
+
+// unmarshalerType is the type of values that unmarshal themselves.
+var unmarshalerType = reflect.TypeOf((*interface {
+	UnmarshalTOML([]byte) error
+})(nil)).Elem()
+
+// errUnknownOption is the error for an option a table does not have.
+var errUnknownOption = errors.New("unknown option")
+
+// fieldByKey returns the struct field for an option key. The structs it
+// embeds are searched too.
+func fieldByKey(typ reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < typ.NumField(); i++ {
+		sf := typ.Field(i)
+		if sf.Anonymous {
+			embedded := sf.Type
+			for embedded.Kind() == reflect.Ptr {
+				embedded = embedded.Elem()
+			}
+			if embedded.Kind() == reflect.Struct {
+				if f, ok := fieldByKey(embedded, key); ok {
+					return f, true
+				}
+			}
+		}
+		if sf.PkgPath != "" {
+			continue
+		}
+		if tag := sf.Tag.Get("toml"); tag != "" && tag != "-" {
+			if strings.Split(tag, ",")[0] == key {
+				return sf, true
+			}
+		}
+		if sf.Name == strings.Title(key) || sf.Name == strings.ToUpper(key) ||
+			snakeCase(sf.Name) == key {
+			return sf, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// sortedFields returns the field keys of a table, in file order.
+func sortedFields(tbl *ast.Table) []string {
+	keys := make([]string, 0, len(tbl.Fields))
+	for key := range tbl.Fields {
+		keys = append(keys, key)
+	}
+	sort.Sort(byLine{keys, tbl})
+	return keys
+}
+
+// byLine sorts the keys of a table by the line their fields start on.
+type byLine struct {
+	keys []string
+	tbl  *ast.Table
+}
+
+func (b byLine) Len() int      { return len(b.keys) }
+func (b byLine) Swap(i, j int) { b.keys[i], b.keys[j] = b.keys[j], b.keys[i] }
+func (b byLine) Less(i, j int) bool {
+	li := fieldLine(b.tbl.Fields[b.keys[i]])
+	lj := fieldLine(b.tbl.Fields[b.keys[j]])
+	if li != lj {
+		return li < lj
+	}
+	return b.keys[i] < b.keys[j]
+}
+
+// fieldLine returns the line a table field starts on. For an option this is
+// the line of its key, since its Line is where its value ends. For a
+// subtable it is the line of its header.
+func fieldLine(val interface{}) int {
+	switch v := val.(type) {
+	case *ast.KeyValue:
+		return v.Line - strings.Count(v.Value.Source(), "\n")
+	case *ast.Table:
+		return v.Line
+	case []*ast.Table:
+		if len(v) > 0 {
+			return v[0].Line
+		}
+	}
+	return 0
+}
+
+// unmarshalCause returns the cause of a config.UnmarshalTable error. It
+// strips the line and struct field prefixes, since the ParseError has
+// those.
+func unmarshalCause(err error) string {
+	msg := linePrefix.ReplaceAllString(err.Error(), "")
+	return fieldPrefix.ReplaceAllString(msg, "")
+}
+
+var (
+	linePrefix  = regexp.MustCompile(`^line \d+: `)
+	fieldPrefix = regexp.MustCompile(`^\*?[\w]+\.[\w.]+: `)
+)
+
+// fileError returns an error for a file. A ParseError gets the path set,
+// and any other error is prefixed with it.
+func fileError(path, table string, err error) error {
+	if e, ok := err.(*ParseError); ok {
+		if e.Path == "" {
+			e.Path = path
+		}
+		if e.Table == "" {
+			e.Table = table
+		}
+		return e
+	}
+	return fmt.Errorf("Error parsing %s, %s", path, err)
+}
+
+// unknownPluginError returns the error for a plugin that is not in names.
+func unknownPluginError(kind, name string, names []string) error {
+	sort.Strings(names)
+	if suggestion := suggest(name, names); suggestion != "" {
+		return fmt.Errorf("Undefined but requested %s: %s, did you mean %q?",
+			kind, name, suggestion)
+	}
+	return fmt.Errorf("Undefined but requested %s: %s", kind, name)
+}
+
+// structKeys returns the option keys for a struct type.
+func structKeys(typ reflect.Type) []string {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	var keys []string
+	for _, f := range structFields(reflect.New(typ).Elem()) {
+		keys = append(keys, f.key)
+	}
+	return keys
+}
+
+// suggest returns the candidate closest to a misspelled word, if any is
+// close enough to be what was meant.
+func suggest(word string, candidates []string) string {
+	// at most a third of the word is mistyped
+	best, bestDistance := "", len(word)/3+2
+	for _, c := range candidates {
+		if c == word {
+			return ""
+		}
+		d := levenshtein(strings.ToLower(word), strings.ToLower(c))
+		if d < bestDistance {
+			best, bestDistance = c, d
+		}
+	}
+	return best
+}
+
+// levenshtein returns the edit distance of two strings.
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,152 @@
+package config
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/influxdata/toml"
+	"github.com/influxdata/toml/ast"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_ParseErrors(t *testing.T) {
+	for file, message := range map[string]string{
+		"unknown_option.toml": "./testdata/errors/unknown_option.toml:5: " +
+			`[inputs.memcached] servrs = ["localhost"]: unknown option, ` +
+			`did you mean "servers"?`,
+		"duration.toml": "./testdata/errors/duration.toml:3: " +
+			`[inputs.memcached] interval = "10x": time: unknown unit "x" ` +
+			`in duration "10x"`,
+		"enum.toml": "./testdata/errors/enum.toml:2: " +
+			`[inputs.memcached] start_failure = "skp": must be "start", ` +
+			`"skip" or "exit", did you mean "skip"?`,
+		"unknown_plugin.toml": "Error parsing " +
+			"./testdata/errors/unknown_plugin.toml, Undefined but " +
+			`requested input: memcache, did you mean "memcached"?`,
+		"agent.toml": "./testdata/errors/agent.toml:2: " +
+			`[agent] flush_intervl = "10s": unknown option, did you mean ` +
+			`"flush_interval"?`,
+	} {
+		c := NewConfig()
+		err := c.LoadConfig("./testdata/errors/" + file)
+		if assert.Error(t, err, file) {
+			assert.Equal(t, message, err.Error(), file)
+		}
+	}
+}
+
+func TestConfig_TypeError(t *testing.T) {
+	c := NewConfig()
+	err := c.LoadConfig("./testdata/errors/type.toml")
+	require.Error(t, err)
+	// the cause is worded by the TOML library
+	assert.True(t, strings.HasPrefix(err.Error(),
+		"./testdata/errors/type.toml:2: "+
+			`[inputs.memcached] servers = "localhost": `), err.Error())
+}
+
+func TestUnknownOptions(t *testing.T) {
+	type server struct {
+		Host string `toml:"host"`
+	}
+	type plugin struct {
+		Servers     []*server
+		Timeout     string
+		UnixSockets []string
+		Tags        map[string]string
+	}
+	tbl, err := toml.Parse([]byte(`
+timeout = "5s"
+unix_sockets = ["/run/a.sock"]
+tmeout = "5s"
+[tags]
+  any = "value"
+[[servers]]
+  host = "a"
+[[servers]]
+  hots = "b"
+`))
+	require.NoError(t, err)
+
+	var found []string
+	for _, u := range unknownOptions(tbl, reflect.TypeOf(&plugin{}), nil) {
+		found = append(found, u.err.Error())
+	}
+	assert.Equal(t, []string{
+		`line 4: tmeout = "5s": unknown option, did you mean "timeout"?`,
+		`line 10: servers.hots = "b": unknown option, did you mean "host"?`,
+	}, found)
+}
+
+func TestConfig_UnmarshalTableStrict(t *testing.T) {
+	type server struct {
+		Host string `toml:"host"`
+	}
+	type plugin struct {
+		Timeout string
+		Server  server
+	}
+	parse := func() *ast.Table {
+		tbl, err := toml.Parse([]byte(`
+timeout = "5s"
+[server]
+  host = "a"
+  hots = "b"
+`))
+		require.NoError(t, err)
+		return tbl
+	}
+
+	c := NewConfig()
+	c.Agent.Strict = true
+	var p plugin
+	err := c.unmarshalTable("telegraf.conf", "inputs.test", parse(), &p)
+	if assert.Error(t, err) {
+		assert.Equal(t, `telegraf.conf:5: [inputs.test] server.hots = "b": `+
+			`unknown option, did you mean "host"?`,
+			fileError("telegraf.conf", "inputs.test", err).Error())
+	}
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c.Agent.Strict = false
+	p = plugin{}
+	require.NoError(t, c.unmarshalTable("telegraf.conf", "inputs.test",
+		parse(), &p))
+	assert.Equal(t, plugin{Timeout: "5s", Server: server{Host: "a"}}, p)
+	assert.Contains(t, buf.String(), `WARNING: telegraf.conf:5: `+
+		`[inputs.test] server.hots = "b": unknown option, did you mean `+
+		`"host"?, ignored`)
+}
+
+func TestSuggest(t *testing.T) {
+	candidates := []string{"servers", "interval", "unix_sockets"}
+	assert.Equal(t, "servers", suggest("server", candidates))
+	assert.Equal(t, "interval", suggest("Intervl", candidates))
+	assert.Equal(t, "unix_sockets", suggest("unixsockets", candidates))
+	assert.Equal(t, "", suggest("timeout", candidates))
+	assert.Equal(t, "", suggest("servers", candidates))
+}
+
+func TestConfig_IgnoredOptions(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig("./testdata/errors/ignored.toml"))
+	assert.Contains(t, buf.String(), "WARNING: ./testdata/errors/"+
+		`ignored.toml:2: [agent] flush_intervl = "10s": unknown option, `+
+		`did you mean "flush_interval"?, ignored`)
+	assert.Contains(t, buf.String(), "WARNING: ./testdata/errors/"+
+		`ignored.toml:5: [inputs.memcached] servrs = ["localhost"]: `+
+		`unknown option, did you mean "servers"?, ignored`)
+	assert.Equal(t, 1, len(c.Inputs))
+}
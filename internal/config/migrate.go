@@ -0,0 +1,390 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/toml"
+	"github.com/influxdata/toml/ast"
+)
+
+// Deprecation is a deprecated plugin or option in use in a configuration
+// file.
+type Deprecation struct {
+	Path string
+	Line int
+	// Plugin is the table holding the plugin or option, such as
+	// inputs.statsd or agent
+	Plugin string
+	// Option is the deprecated option, empty if the plugin is deprecated
+	Option string
+	// Replacement replaces the plugin or option, empty if it has no effect
+	// and is removed
+	Replacement string
+	// Note is what to look out for when migrating
+	Note string
+	// Automatic is true if the migration is applied by Migrate
+	Automatic bool
+}
+
+func (d Deprecation) String() string {
+	var s string
+	switch {
+	case d.Option == "":
+		s = fmt.Sprintf("[%s] is deprecated, use [%s] instead", d.Plugin,
+			d.Replacement)
+	case d.Replacement == "":
+		s = fmt.Sprintf("[%s] %s is deprecated and has no effect, remove it",
+			d.Plugin, d.Option)
+	default:
+		s = fmt.Sprintf("[%s] %s is deprecated, use %s instead", d.Plugin,
+			d.Option, d.Replacement)
+	}
+	if d.Note != "" {
+		s += ", " + d.Note
+	}
+	if d.Automatic {
+		s += " (automatic)"
+	} else {
+		s += " (manual)"
+	}
+	return fmt.Sprintf("%s:%d: %s", d.Path, d.Line, s)
+}
+
+// Migration is the migration of a configuration file.
+type Migration struct {
+	Path         string
+	Deprecations []Deprecation
+	// Migrated is the file with the automatic migrations applied. It is nil
+	// if the file cannot be rewritten, such as a remote file or a template
+	Migrated []byte
+}
+
+// renamedPlugins are the input plugins that were renamed.
+var renamedPlugins = map[string]struct {
+	name string
+	note string
+}{
+	"io":    {name: "diskio"},
+	"kafka": {name: "kafka_consumer", note: "most of its options changed"},
+}
+
+// removedOptions are the options that have no effect anymore, by the table
+// they are in.
+var removedOptions = map[string][]string{
+	"agent":               {"utc", "precision", "flush_buffer_when_full"},
+	"inputs.statsd":       {"udp_packet_size"},
+	"inputs.udp_listener": {"udp_packet_size"},
+}
+
+// renamedOptions are the options that were renamed, by the kind of plugin
+// they are in.
+var renamedOptions = map[string]map[string]string{
+	"inputs":  {"pass": "fieldpass", "drop": "fielddrop"},
+	"outputs": {"pass": "fieldpass", "drop": "fielddrop"},
+}
+
+// Migrate returns the migrations for a configuration file and the files it
+// includes.
+func (c *Config) Migrate(path string) ([]Migration, error) {
+	var err error
+	if path == "" {
+		if path, err = getDefaultConfigPath(); err != nil {
+			return nil, err
+		}
+	}
+	files, err := c.parseIncludes(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, f := range files {
+		m, err := c.migrateFile(f.path)
+		if err != nil {
+			return nil, fmt.Errorf("Error migrating %s, %s", f.path, err)
+		}
+		migrations = append(migrations, m)
+	}
+	return migrations, nil
+}
+
+// MigrateDirectory returns the migrations for the configuration files in a
+// directory and the files they include.
+func (c *Config) MigrateDirectory(path string) ([]Migration, error) {
+	files, err := directoryFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, file := range files {
+		m, err := c.Migrate(file)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, m...)
+	}
+	return migrations, nil
+}
+
+func (c *Config) migrateFile(path string) (Migration, error) {
+	raw, err := c.readFile(path)
+	if err != nil {
+		return Migration{}, err
+	}
+	contents, err := expandFile(path, raw)
+	if err != nil {
+		return Migration{}, err
+	}
+	tbl, err := toml.Parse(contents)
+	if err != nil {
+		return Migration{}, err
+	}
+
+	m := &migrator{
+		path:  path,
+		data:  []rune(string(contents)),
+		edits: make(map[int]lineEdit),
+	}
+	m.migrate(tbl)
+	sort.Stable(deprecationsByLine(m.deprecations))
+	migration := Migration{Path: path, Deprecations: m.deprecations}
+	// the edits apply to the lines of the expanded file, which only match
+	// the file on disk when it is not a template
+	if !IsRemote(path) && !isTemplate(path) {
+		migration.Migrated = m.apply(raw)
+	}
+	return migration, nil
+}
+
+// lineEdit edits a line of a configuration file, without its newline. It
+// returns false to remove the line.
+type lineEdit func(line string) (string, bool)
+
+func removeLine(string) (string, bool) {
+	return "", false
+}
+
+// deprecationsByLine sorts deprecations by their line, and then option.
+type deprecationsByLine []Deprecation
+
+func (d deprecationsByLine) Len() int      { return len(d) }
+func (d deprecationsByLine) Swap(i, j int) { d[i], d[j] = d[j], d[i] }
+func (d deprecationsByLine) Less(i, j int) bool {
+	return d[i].Line < d[j].Line ||
+		d[i].Line == d[j].Line && d[i].Option < d[j].Option
+}
+
+// migrator finds the deprecations in a configuration file, and the line
+// edits that migrate them.
+type migrator struct {
+	path string
+	// data are the contents of the file. The AST positions are offsets into
+	// it
+	data         []rune
+	deprecations []Deprecation
+	// edits are the edits of the lines, by line number
+	edits map[int]lineEdit
+}
+
+func (m *migrator) migrate(tbl *ast.Table) {
+	for name, val := range tbl.Fields {
+		subTable, ok := val.(*ast.Table)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "global_tags", "tags", "experimental", "tls_profiles",
+			"auth_profiles":
+		case "agent":
+			m.options("agent", "agent", subTable)
+		case "inputs", "outputs", "plugins":
+			for pluginName, pluginVal := range subTable.Fields {
+				for _, t := range tables(pluginVal) {
+					m.plugin([]string{name, pluginName}, t)
+				}
+			}
+		default:
+			// legacy input, outside of any section
+			m.plugin([]string{name}, subTable)
+		}
+	}
+}
+
+// plugin migrates a plugin table found at the given path.
+func (m *migrator) plugin(from []string, tbl *ast.Table) {
+	name := from[len(from)-1]
+	kind := "inputs"
+	if from[0] == "outputs" {
+		kind = "outputs"
+	}
+	to := []string{kind, name}
+	rename := from[0] != kind
+	automatic := true
+	var note string
+	if renamed, ok := renamedPlugins[name]; ok && kind == "inputs" {
+		to = []string{kind, renamed.name}
+		rename = true
+		automatic = renamed.note == ""
+		note = renamed.note
+	}
+	if rename {
+		m.add(Deprecation{
+			Line:        tbl.Line,
+			Plugin:      strings.Join(from, "."),
+			Replacement: strings.Join(to, "."),
+			Note:        note,
+			Automatic:   automatic,
+		})
+		if automatic {
+			m.renameTables(tbl, from, to)
+		}
+	}
+	m.options(kind, strings.Join(to, "."), tbl)
+}
+
+// options migrates the options in a table. kind is the plugin kind, such as
+// inputs, and name is the table name, such as inputs.statsd.
+func (m *migrator) options(kind, name string, tbl *ast.Table) {
+	for key, val := range tbl.Fields {
+		kv, ok := val.(*ast.KeyValue)
+		if !ok {
+			continue
+		}
+		begin, end := m.lines(kv)
+		d := Deprecation{Line: begin, Plugin: name, Option: key}
+
+		if renamed, ok := renamedOptions[kind][key]; ok {
+			d.Replacement = renamed
+			d.Automatic = true
+			m.add(d)
+			m.edits[begin] = renameKey(key, renamed)
+			continue
+		}
+		for _, removed := range removedOptions[name] {
+			if key == removed {
+				d.Automatic = true
+				m.add(d)
+				m.remove(begin, end)
+			}
+		}
+		if name == "inputs.statsd" && key == "convert_names" {
+			// convert_names = false is the default, true replaced . with
+			// _, like metric_separator, but also - with __
+			if b, ok := kv.Value.(*ast.Boolean); ok && b.Value == "false" {
+				d.Automatic = true
+				m.add(d)
+				m.remove(begin, end)
+				continue
+			}
+			d.Replacement = "metric_separator"
+			d.Note = "which does not replace - with __"
+			m.add(d)
+		}
+	}
+}
+
+func (m *migrator) add(d Deprecation) {
+	d.Path = m.path
+	m.deprecations = append(m.deprecations, d)
+}
+
+// lines returns the first and last lines of a key/value pair.
+func (m *migrator) lines(kv *ast.KeyValue) (int, int) {
+	return m.line(kv.Value.Pos()), m.line(kv.Value.End() - 1)
+}
+
+// line returns the line number at an offset into the data.
+func (m *migrator) line(offset int) int {
+	if offset > len(m.data) {
+		offset = len(m.data)
+	}
+	line := 1
+	for _, r := range m.data[:offset] {
+		if r == '\n' {
+			line++
+		}
+	}
+	return line
+}
+
+func (m *migrator) remove(begin, end int) {
+	for line := begin; line <= end; line++ {
+		m.edits[line] = removeLine
+	}
+}
+
+// renameTables renames the header of a table and its subtables from the path
+// from to the path to.
+func (m *migrator) renameTables(tbl *ast.Table, from, to []string) {
+	m.edits[tbl.Line] = renameHeader(from, to)
+	for _, val := range tbl.Fields {
+		for _, t := range tables(val) {
+			m.renameTables(t, from, to)
+		}
+	}
+}
+
+// apply applies the edits to the lines of a file.
+func (m *migrator) apply(contents []byte) []byte {
+	lines := strings.SplitAfter(string(contents), "\n")
+	var out []string
+	for i, line := range lines {
+		edit, ok := m.edits[i+1]
+		if !ok {
+			out = append(out, line)
+			continue
+		}
+		text := strings.TrimRight(line, "\r\n")
+		text, keep := edit(text)
+		if keep {
+			out = append(out, text+line[len(strings.TrimRight(line, "\r\n")):])
+		}
+	}
+	return []byte(strings.Join(out, ""))
+}
+
+// tables returns the tables of a field, none if it is not a table or an array
+// of tables.
+func tables(val interface{}) []*ast.Table {
+	switch t := val.(type) {
+	case *ast.Table:
+		return []*ast.Table{t}
+	case []*ast.Table:
+		return t
+	}
+	return nil
+}
+
+var headerRe = regexp.MustCompile(`^(\s*\[\[?\s*)([^\]]*?)(\s*\]\]?.*)$`)
+
+// renameHeader returns the edit of a table header starting with the path
+// from, replacing it with to. Other lines are left as is.
+func renameHeader(from, to []string) lineEdit {
+	return func(line string) (string, bool) {
+		match := headerRe.FindStringSubmatch(line)
+		if match == nil {
+			return line, true
+		}
+		path := strings.Split(match[2], ".")
+		if len(path) < len(from) {
+			return line, true
+		}
+		for i, name := range from {
+			if strings.TrimSpace(path[i]) != name {
+				return line, true
+			}
+		}
+		path = append(append([]string{}, to...), path[len(from):]...)
+		return match[1] + strings.Join(path, ".") + match[3], true
+	}
+}
+
+// renameKey returns the edit of a line renaming the key from to to.
+func renameKey(from, to string) lineEdit {
+	re := regexp.MustCompile(`^(\s*)` + regexp.QuoteMeta(from) + `(\s*=)`)
+	return func(line string) (string, bool) {
+		return re.ReplaceAllString(line, "${1}"+to+"${2}"), true
+	}
+}
@@ -0,0 +1,71 @@
+package config
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Migrate(t *testing.T) {
+	c := NewConfig()
+	migrations, err := c.Migrate("./testdata/migrate/telegraf.conf")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(migrations))
+	m := migrations[0]
+
+	var report []string
+	for _, d := range m.Deprecations {
+		report = append(report, d.String())
+	}
+	path := "./testdata/migrate/telegraf.conf"
+	assert.Equal(t, []string{
+		path + ":3: [agent] utc is deprecated and has no effect, remove it " +
+			"(automatic)",
+		path + ":4: [agent] flush_buffer_when_full is deprecated and has no " +
+			"effect, remove it (automatic)",
+		path + ":6: [plugins.io] is deprecated, use [inputs.diskio] instead " +
+			"(automatic)",
+		path + ":13: [inputs.cpu] pass is deprecated, use fieldpass instead " +
+			"(automatic)",
+		path + ":18: [inputs.kafka] is deprecated, use " +
+			"[inputs.kafka_consumer] instead, most of its options changed " +
+			"(manual)",
+		path + ":22: [inputs.statsd] udp_packet_size is deprecated and has " +
+			"no effect, remove it (automatic)",
+		path + ":23: [inputs.statsd] convert_names is deprecated and has no " +
+			"effect, remove it (automatic)",
+		path + ":25: [mem] is deprecated, use [inputs.mem] instead " +
+			"(automatic)",
+		path + ":26: [inputs.mem] drop is deprecated, use fielddrop instead " +
+			"(automatic)",
+	}, report)
+
+	expected, err := ioutil.ReadFile("./testdata/migrate/migrated.conf")
+	require.NoError(t, err)
+	assert.Equal(t, string(expected), string(m.Migrated))
+
+	// the migrated configuration has nothing left to migrate automatically
+	c = NewConfig()
+	migrations, err = c.Migrate("./testdata/migrate/migrated.conf")
+	require.NoError(t, err)
+	require.Equal(t, 1, len(migrations[0].Deprecations))
+	assert.False(t, migrations[0].Deprecations[0].Automatic)
+}
+
+func TestRenameHeader(t *testing.T) {
+	rename := renameHeader([]string{"plugins", "io"}, []string{"inputs", "diskio"})
+	for line, expected := range map[string]string{
+		"[[plugins.io]]":              "[[inputs.diskio]]",
+		"  [plugins.io.tagpass]":      "  [inputs.diskio.tagpass]",
+		"[[plugins.io]] # comment":    "[[inputs.diskio]] # comment",
+		"[[plugins.iostat]]":          "[[plugins.iostat]]",
+		"[[inputs.io]]":               "[[inputs.io]]",
+		`  devices = ["[plugins.io"]`: `  devices = ["[plugins.io"]`,
+	} {
+		migrated, keep := rename(line)
+		assert.True(t, keep)
+		assert.Equal(t, expected, migrated, line)
+	}
+}
@@ -0,0 +1,107 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/influxdata/toml/ast"
+)
+
+// profileKind is a kind of profile: a named set of options defined once, in
+// a [<table>.<name>] table, and applied to the plugins that set <key> to its
+// name.
+type profileKind struct {
+	table string
+	key   string
+	// options are the options a profile of the kind may set, any if nil
+	options []string
+}
+
+var profileKinds = []profileKind{
+	{
+		table: "tls_profiles",
+		key:   "tls_profile",
+		options: []string{
+			"ssl_ca", "ssl_cert", "ssl_key", "insecure_skip_verify",
+		},
+	},
+	{
+		table: "auth_profiles",
+		key:   "auth_profile",
+	},
+}
+
+// profile is a profile, and the file it was defined in.
+type profile struct {
+	path string
+	tbl  *ast.Table
+}
+
+// loadProfiles loads the profiles defined in a file. A profile replaces one
+// with the same kind and name from an earlier file.
+func (c *Config) loadProfiles(path string, tbl *ast.Table) error {
+	for _, kind := range profileKinds {
+		val, ok := tbl.Fields[kind.table]
+		if !ok {
+			continue
+		}
+		profiles, ok := val.(*ast.Table)
+		if !ok {
+			return fmt.Errorf("%s: invalid [%s] configuration", path,
+				kind.table)
+		}
+		for name, val := range profiles.Fields {
+			profileTable, ok := val.(*ast.Table)
+			if !ok {
+				return fmt.Errorf("%s: invalid [%s.%s] configuration", path,
+					kind.table, name)
+			}
+			for key, val := range profileTable.Fields {
+				if _, ok := val.(*ast.KeyValue); !ok ||
+					kind.options != nil && !sliceContains(key, kind.options) {
+					return fmt.Errorf("%s: [%s.%s] cannot set %s", path,
+						kind.table, name, key)
+				}
+			}
+			if c.profiles == nil {
+				c.profiles = make(map[string]map[string]profile)
+			}
+			if c.profiles[kind.table] == nil {
+				c.profiles[kind.table] = make(map[string]profile)
+			}
+			c.profiles[kind.table][name] = profile{path: path, tbl: profileTable}
+		}
+	}
+	return nil
+}
+
+// applyProfiles sets the options of the profiles a plugin table refers to,
+// that the table does not set itself, and records where they were set in
+// the source of the table.
+func (c *Config) applyProfiles(tbl *ast.Table, source *tableSource) error {
+	for _, kind := range profileKinds {
+		val, ok := tbl.Fields[kind.key]
+		if !ok {
+			continue
+		}
+		var name string
+		if kv, ok := val.(*ast.KeyValue); ok {
+			if str, ok := kv.Value.(*ast.String); ok {
+				name = str.Value
+			}
+		}
+		p, ok := c.profiles[kind.table][name]
+		if !ok {
+			return fmt.Errorf("%s %q is not defined in [%s]", kind.key, name,
+				kind.table)
+		}
+		delete(tbl.Fields, kind.key)
+		for key, val := range p.tbl.Fields {
+			if _, ok := tbl.Fields[key]; ok {
+				continue
+			}
+			tbl.Fields[key] = val
+			source.options[key] = newOptionSource(p.path, val.(*ast.KeyValue))
+		}
+	}
+	return nil
+}
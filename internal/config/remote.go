@@ -0,0 +1,286 @@
+package config
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+// remoteTimeout is how long fetching a remote configuration may take.
+const remoteTimeout = 30 * time.Second
+
+// signatureSuffix is appended to the path of a remote configuration to get
+// the URL of its signature.
+const signatureSuffix = ".sig"
+
+var remoteClient = &http.Client{Timeout: remoteTimeout}
+
+// IsRemote returns true if path is the URL of a remote configuration: an
+// http, https, s3, gs, consul or etcd URL.
+func IsRemote(path string) bool {
+	u, err := url.Parse(path)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "http", "https", "s3", "gs", "consul", "etcd":
+		return true
+	}
+	return false
+}
+
+// FetchRemote returns the contents of a remote configuration. If key is set,
+// the configuration must have a valid ed25519 signature by it at the same
+// URL with signatureSuffix appended to its path.
+func FetchRemote(path string, key ed25519.PublicKey) ([]byte, error) {
+	u, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := fetch(u)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return contents, nil
+	}
+
+	sigURL := *u
+	sigURL.Path += signatureSuffix
+	sig, err := fetch(&sigURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetching signature: %s", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		// the signature is either raw or base64 encoded
+		if sig, err = base64.StdEncoding.DecodeString(
+			strings.TrimSpace(string(sig))); err != nil {
+			return nil, fmt.Errorf("invalid signature: %s", err)
+		}
+	}
+	if !ed25519.Verify(key, contents, sig) {
+		return nil, errors.New("invalid signature")
+	}
+	return contents, nil
+}
+
+// LoadPublicKey loads the ed25519 public key that remote configurations must
+// be signed with, from a PEM file.
+//
+// The key is decoded with encoding/asn1 rather than x509.ParsePKIXPublicKey:
+// crypto/x509 only parses ed25519 keys since Go 1.13, and telegraf is built
+// with older releases of Go.
+func LoadPublicKey(path string) (ed25519.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM public key", path)
+	}
+	var info publicKeyInfo
+	if _, err := asn1.Unmarshal(block.Bytes, &info); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	key := info.PublicKey.RightAlign()
+	if !info.Algorithm.Algorithm.Equal(oidEd25519) ||
+		len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("%s: not an ed25519 public key", path)
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// publicKeyInfo is the PKIX SubjectPublicKeyInfo structure of RFC 5280.
+type publicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// oidEd25519 is the algorithm identifier of ed25519 keys, from RFC 8410.
+var oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// fetch fetches the object at a remote URL.
+func fetch(u *url.URL) ([]byte, error) {
+	var req *http.Request
+	var err error
+	switch u.Scheme {
+	case "http", "https":
+		req, err = http.NewRequest("GET", u.String(), nil)
+	case "s3":
+		req, err = s3Request(u)
+	case "gs":
+		req, err = http.NewRequest("GET", "https://storage.googleapis.com/"+
+			u.Host+u.EscapedPath(), nil)
+		if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); err == nil &&
+			token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+	case "consul":
+		// the raw value of the key, see Consul's KV store endpoint
+		req, err = http.NewRequest("GET", "http://"+u.Host+"/v1/kv"+
+			u.EscapedPath()+"?raw", nil)
+		if token := os.Getenv("CONSUL_HTTP_TOKEN"); err == nil && token != "" {
+			req.Header.Set("X-Consul-Token", token)
+		}
+	case "etcd":
+		return fetchEtcd(u)
+	default:
+		return nil, fmt.Errorf("unsupported configuration URL %s", u)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return do(req)
+}
+
+func do(req *http.Request) ([]byte, error) {
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+	return body, nil
+}
+
+// fetchEtcd returns the value of a key through the JSON gateway of the etcd
+// v3 API.
+func fetchEtcd(u *url.URL) ([]byte, error) {
+	body, err := json.Marshal(map[string]string{
+		"key": base64.StdEncoding.EncodeToString([]byte(u.Path)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", "http://"+u.Host+"/v3/kv/range",
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	var r struct {
+		Kvs []struct {
+			Value []byte `json:"value"`
+		} `json:"kvs"`
+	}
+	if err := json.Unmarshal(resp, &r); err != nil {
+		return nil, err
+	}
+	if len(r.Kvs) == 0 {
+		return nil, fmt.Errorf("%s not found", u)
+	}
+	return r.Kvs[0].Value, nil
+}
+
+// s3Request returns the request of an S3 object, signed with AWS signature
+// version 4 if AWS_ACCESS_KEY_ID is set.
+func s3Request(u *url.URL) (*http.Request, error) {
+	region := awsRegion()
+	host := fmt.Sprintf("%s.s3.%s.amazonaws.com", u.Host, region)
+	req, err := http.NewRequest("GET", "https://"+host+u.EscapedPath(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if id := os.Getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		signV4(req, region, "s3", "UNSIGNED-PAYLOAD", id,
+			os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"),
+			time.Now().UTC())
+	}
+	return req, nil
+}
+
+// awsRegion returns the region of AWS_REGION, us-east-1 if not set.
+func awsRegion() string {
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return region
+}
+
+// signV4 signs a request to an AWS service with AWS signature version 4.
+// payload is the hex SHA-256 of the request body, or UNSIGNED-PAYLOAD.
+func signV4(
+	req *http.Request,
+	region, service, payload string,
+	id, secret, token string,
+	now time.Time,
+) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+	headers := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	values := []string{req.URL.Host, payload, amzDate}
+	if token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+		headers = append(headers, "x-amz-security-token")
+		values = append(values, token)
+	}
+	var canonicalHeaders string
+	for i, h := range headers {
+		canonicalHeaders += h + ":" + values[i] + "\n"
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	canonical := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payload,
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	hash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" +
+		hex.EncodeToString(hash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf(
+		"AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		id, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,194 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ed25519"
+)
+
+const remoteConfig = `include = ["overrides/prod.toml"]
+
+[[inputs.memcached]]
+  servers = ["localhost"]
+`
+
+const remoteOverride = `[agent]
+  interval = "30s"
+
+[[outputs.file]]
+  files = ["stdout"]
+`
+
+func TestConfig_LoadRemote(t *testing.T) {
+	files := map[string]string{
+		"/telegraf.toml":       remoteConfig,
+		"/overrides/prod.toml": remoteOverride,
+	}
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			contents, ok := files[r.URL.Path]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(contents))
+		}))
+	defer ts.Close()
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig(ts.URL+"/telegraf.toml"))
+	assert.Equal(t, []string{ts.URL + "/overrides/prod.toml"}, c.Included)
+	assert.Equal(t, 30*time.Second, c.Agent.Interval.Duration)
+	assert.Equal(t, 1, len(c.Inputs))
+	assert.Equal(t, 1, len(c.Outputs))
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig(ts.URL+"/missing.toml"))
+}
+
+func TestFetchRemoteSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	sig := base64.StdEncoding.EncodeToString(
+		ed25519.Sign(priv, []byte(remoteOverride)))
+	contents := remoteOverride
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/telegraf.toml":
+				w.Write([]byte(contents))
+			case "/telegraf.toml.sig":
+				w.Write([]byte(sig + "\n"))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+	defer ts.Close()
+
+	out, err := FetchRemote(ts.URL+"/telegraf.toml", pub)
+	require.NoError(t, err)
+	assert.Equal(t, remoteOverride, string(out))
+
+	// tampered with
+	contents = remoteOverride + "\n[[inputs.exec]]\n"
+	_, err = FetchRemote(ts.URL+"/telegraf.toml", pub)
+	assert.Error(t, err)
+
+	// unsigned
+	_, err = FetchRemote(ts.URL+"/unsigned.toml", pub)
+	assert.Error(t, err)
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	der, err := asn1.Marshal(publicKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidEd25519},
+		PublicKey: asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)},
+	})
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "key")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(path, pem.EncodeToMemory(
+		&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0644))
+
+	key, err := LoadPublicKey(path)
+	require.NoError(t, err)
+	assert.Equal(t, pub, key)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("not a key"), 0644))
+	_, err = LoadPublicKey(path)
+	assert.Error(t, err)
+}
+
+func TestFetchConsul(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/kv/telegraf/web" ||
+				r.URL.RawQuery != "raw" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(remoteOverride))
+		}))
+	defer ts.Close()
+
+	url := "consul://" + strings.TrimPrefix(ts.URL, "http://") +
+		"/telegraf/web"
+	out, err := FetchRemote(url, nil)
+	require.NoError(t, err)
+	assert.Equal(t, remoteOverride, string(out))
+}
+
+func TestFetchEtcd(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				Key []byte `json:"key"`
+			}
+			if r.URL.Path != "/v3/kv/range" ||
+				json.NewDecoder(r.Body).Decode(&req) != nil ||
+				string(req.Key) != "/telegraf/web" {
+				http.NotFound(w, r)
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"kvs": []map[string][]byte{
+					{"key": req.Key, "value": []byte(remoteOverride)},
+				},
+			})
+		}))
+	defer ts.Close()
+
+	host := strings.TrimPrefix(ts.URL, "http://")
+	out, err := FetchRemote("etcd://"+host+"/telegraf/web", nil)
+	require.NoError(t, err)
+	assert.Equal(t, remoteOverride, string(out))
+
+	_, err = FetchRemote("etcd://"+host+"/missing", nil)
+	assert.Error(t, err)
+}
+
+func TestSignV4(t *testing.T) {
+	req, err := http.NewRequest("GET",
+		"https://bucket.s3.eu-west-1.amazonaws.com/telegraf/web.toml", nil)
+	require.NoError(t, err)
+	now := time.Date(2016, 6, 1, 12, 0, 0, 0, time.UTC)
+	signV4(req, "eu-west-1", "s3", "UNSIGNED-PAYLOAD", "AKID", "SECRET",
+		"TOKEN", now)
+
+	assert.Equal(t, "20160601T120000Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "TOKEN", req.Header.Get("X-Amz-Security-Token"))
+	auth := req.Header.Get("Authorization")
+	assert.True(t, strings.HasPrefix(auth, "AWS4-HMAC-SHA256 "+
+		"Credential=AKID/20160601/eu-west-1/s3/aws4_request, "+
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date;"+
+		"x-amz-security-token, Signature="), auth)
+}
+
+func TestIsRemote(t *testing.T) {
+	assert.True(t, IsRemote("https://example.com/telegraf.conf"))
+	assert.True(t, IsRemote("s3://bucket/telegraf.conf"))
+	assert.True(t, IsRemote("etcd://localhost:2379/telegraf"))
+	assert.False(t, IsRemote("/etc/telegraf/telegraf.conf"))
+	assert.False(t, IsRemote("telegraf.conf"))
+	assert.False(t, IsRemote(`C:\telegraf\telegraf.conf`))
+}
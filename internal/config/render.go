@@ -0,0 +1,445 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/experimental"
+	"github.com/influxdata/toml/ast"
+)
+
+// redacted replaces the values of secret options when rendering.
+const redacted = `"<redacted>"`
+
+// sensitiveNames are the name parts that mark an option as secret. Its value
+// is redacted when rendering, even if not encrypted.
+var sensitiveNames = []string{"password", "secret", "token"}
+
+var durationType = reflect.TypeOf(internal.Duration{})
+
+// tableSource is where a table and its options were set in the configuration
+// files.
+type tableSource struct {
+	path    string
+	line    int
+	options map[string]optionSource
+	tables  map[string][]*tableSource
+}
+
+// optionSource is where an option was set.
+type optionSource struct {
+	path  string
+	line  int
+	value ast.Value
+}
+
+func newOptionSource(path string, kv *ast.KeyValue) optionSource {
+	// the line of a key/value pair is the line its value ends on
+	line := kv.Line - strings.Count(kv.Value.Source(), "\n")
+	return optionSource{path: path, line: line, value: kv.Value}
+}
+
+func (s optionSource) String() string {
+	return fmt.Sprintf("%s:%d", s.path, s.line)
+}
+
+// secret returns true if the option was set to an ENC[...] secret, or to a
+// reference to a secret store.
+func (s optionSource) secret() bool {
+	source := s.value.Source()
+	return strings.Contains(source, secretPrefix) ||
+		strings.Contains(source, secretRefPrefix)
+}
+
+// newTableSource records where the options of a table and its subtables were
+// set, before loading the table consumes them.
+func newTableSource(path string, tbl *ast.Table) *tableSource {
+	s := &tableSource{
+		path:    path,
+		line:    tbl.Line,
+		options: make(map[string]optionSource),
+		tables:  make(map[string][]*tableSource),
+	}
+	for key, val := range tbl.Fields {
+		switch v := val.(type) {
+		case *ast.KeyValue:
+			s.options[key] = newOptionSource(path, v)
+		case *ast.Table:
+			s.tables[key] = []*tableSource{newTableSource(path, v)}
+		case []*ast.Table:
+			for _, t := range v {
+				s.tables[key] = append(s.tables[key], newTableSource(path, t))
+			}
+		}
+	}
+	return s
+}
+
+// merge merges the source of a table set again in a later file.
+func (s *tableSource) merge(later *tableSource) *tableSource {
+	if s == nil {
+		return later
+	}
+	for key, option := range later.options {
+		s.options[key] = option
+	}
+	for key, tables := range later.tables {
+		s.tables[key] = tables
+	}
+	return s
+}
+
+// setSource records the source of the global tags, the agent settings or a
+// plugin.
+func (c *Config) setSource(key interface{}, source *tableSource) {
+	if c.sources == nil {
+		c.sources = make(map[interface{}]*tableSource)
+	}
+	c.sources[key] = source
+}
+
+// Render writes the effective configuration. This covers the global tags, the
+// agent settings, the experimental flags and every plugin's settings,
+// including their defaults. A comment on each gives the file and line it was
+// set at, or "default". Secret values are redacted.
+//
+// Generic plugin options, such as interval or tagpass, are only rendered if
+// they were set.
+func (c *Config) Render(w io.Writer) error {
+	r := &renderer{}
+
+	r.header("[global_tags]", c.sources["global_tags"])
+	var tags []string
+	for k := range c.Tags {
+		tags = append(tags, k)
+	}
+	sort.Strings(tags)
+	for _, k := range tags {
+		r.option(k, strconv.Quote(c.Tags[k]), c.sources["global_tags"])
+	}
+
+	r.table("[agent]", "agent", reflect.ValueOf(c.Agent).Elem(),
+		c.sources["agent"])
+
+	r.header("[experimental]", c.sources["experimental"])
+	for _, f := range experimental.Flags() {
+		r.option(f.Name, strconv.FormatBool(c.Experimental[f.Name]),
+			c.sources["experimental"])
+	}
+
+	for _, ro := range c.Outputs {
+		r.table("[[outputs."+ro.Name+"]]", "outputs."+ro.Name,
+			reflect.ValueOf(ro.Output), c.sources[ro])
+	}
+	for _, ri := range c.Inputs {
+		r.table("[[inputs."+ri.Name+"]]", "inputs."+ri.Name,
+			reflect.ValueOf(ri.Input), c.sources[ri])
+	}
+	_, err := w.Write(r.Bytes())
+	return err
+}
+
+// renderer renders tables of the configuration.
+type renderer struct {
+	bytes.Buffer
+}
+
+// header writes the header of a table, after a blank line.
+func (r *renderer) header(header string, src *tableSource) {
+	if r.Len() > 0 {
+		r.WriteString("\n")
+	}
+	if src != nil && src.line > 0 {
+		fmt.Fprintf(r, "%s # %s:%d\n", header, src.path, src.line)
+		return
+	}
+	fmt.Fprintf(r, "%s # default\n", header)
+}
+
+// option writes an option of a table, with its source.
+func (r *renderer) option(key, value string, src *tableSource) {
+	annotation := "default"
+	if src != nil {
+		if s, ok := src.options[key]; ok {
+			annotation = s.String()
+			if s.secret() {
+				value = redacted
+			}
+		}
+	}
+	if isSensitive(key) && value != `""` {
+		value = redacted
+	}
+	fmt.Fprintf(r, "  %s = %s # %s\n", key, value, annotation)
+}
+
+// table writes a table for a struct, such as a plugin. It writes the struct
+// fields, and the options set in its source that are not struct fields.
+func (r *renderer) table(
+	header, name string,
+	rv reflect.Value,
+	src *tableSource,
+) {
+	for rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Interface {
+		if rv.IsNil() {
+			return
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return
+	}
+	r.header(header, src)
+
+	fields := structFields(rv)
+	rendered := make(map[string]bool)
+	for _, f := range fields {
+		rendered[f.key] = true
+	}
+
+	// the options consumed when loading the table, such as interval
+	if src != nil {
+		var keys []string
+		for key := range src.options {
+			if !rendered[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			r.option(key, src.options[key].value.Source(), src)
+		}
+	}
+
+	var subtables []field
+	for _, f := range fields {
+		if value, ok := scalar(f.value); ok {
+			r.option(f.key, value, src)
+		} else {
+			subtables = append(subtables, f)
+		}
+	}
+
+	if src != nil {
+		var keys []string
+		for key := range src.tables {
+			if !rendered[key] {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, t := range src.tables[key] {
+				r.sourceTable(name+"."+key, t)
+			}
+		}
+	}
+
+	for _, f := range subtables {
+		r.subtable(name+"."+f.key, f, src)
+	}
+}
+
+// subtable writes a struct field that is a table, a map or an array of
+// tables.
+func (r *renderer) subtable(name string, f field, src *tableSource) {
+	var sources []*tableSource
+	if src != nil {
+		sources = src.tables[f.key]
+	}
+	source := func(i int) *tableSource {
+		if i < len(sources) {
+			return sources[i]
+		}
+		return nil
+	}
+
+	rv := f.value
+	switch rv.Kind() {
+	case reflect.Struct:
+		if len(structFields(rv)) == 0 && source(0) == nil {
+			return
+		}
+		r.table("["+name+"]", name, rv, source(0))
+	case reflect.Map:
+		if rv.Len() == 0 {
+			return
+		}
+		r.header("["+name+"]", source(0))
+		var keys []string
+		values := make(map[string]string)
+		for _, k := range rv.MapKeys() {
+			value, ok := scalar(rv.MapIndex(k))
+			if !ok {
+				continue
+			}
+			key := fmt.Sprint(k.Interface())
+			keys = append(keys, key)
+			values[key] = value
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			r.option(key, values[key], source(0))
+		}
+	case reflect.Slice:
+		for i := 0; i < rv.Len(); i++ {
+			r.table("[["+name+"]]", name, rv.Index(i), source(i))
+		}
+	}
+}
+
+// sourceTable writes a source table that is not a field of its parent
+// struct, such as tagpass.
+func (r *renderer) sourceTable(name string, src *tableSource) {
+	r.header("["+name+"]", src)
+	var keys []string
+	for key := range src.options {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		r.option(key, src.options[key].value.Source(), src)
+	}
+	keys = keys[:0]
+	for key := range src.tables {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, t := range src.tables[key] {
+			r.sourceTable(name+"."+key, t)
+		}
+	}
+}
+
+// field is a field of a struct that is set from the configuration.
+type field struct {
+	key   string
+	value reflect.Value
+}
+
+// structFields returns the struct fields set from the configuration,
+// including those of embedded structs, with their TOML keys.
+func structFields(rv reflect.Value) []field {
+	var fields []field
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		sf := rt.Field(i)
+		if sf.PkgPath != "" && !sf.Anonymous {
+			continue
+		}
+		fv := rv.Field(i)
+		if sf.Anonymous {
+			if fv.Kind() == reflect.Struct && fv.Type() != durationType {
+				fields = append(fields, structFields(fv)...)
+			}
+			continue
+		}
+		key := sf.Tag.Get("toml")
+		if key == "-" {
+			continue
+		}
+		if key == "" {
+			key = snakeCase(sf.Name)
+		}
+		if !configurable(fv.Type()) {
+			continue
+		}
+		fields = append(fields, field{key: key, value: fv})
+	}
+	return fields
+}
+
+// configurable returns true if a field of the type can be set from the
+// configuration.
+func configurable(rt reflect.Type) bool {
+	switch rt.Kind() {
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64, reflect.Float32, reflect.Float64:
+		return true
+	case reflect.Struct:
+		return true
+	case reflect.Slice, reflect.Array:
+		return configurable(rt.Elem())
+	case reflect.Map:
+		return rt.Key().Kind() == reflect.String && configurable(rt.Elem())
+	}
+	return false
+}
+
+// scalar returns the TOML of a value that is not a table, false if it is one.
+func scalar(rv reflect.Value) (string, bool) {
+	if rv.Type() == durationType {
+		return strconv.Quote(rv.Interface().(internal.Duration).Duration.String()),
+			true
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return strconv.FormatBool(rv.Bool()), true
+	case reflect.String:
+		return strconv.Quote(rv.String()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32,
+		reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64), true
+	case reflect.Slice, reflect.Array:
+		elems := make([]string, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			elem, ok := scalar(rv.Index(i))
+			if !ok {
+				return "", false
+			}
+			elems = append(elems, elem)
+		}
+		if len(elems) == 0 && rv.Type().Elem().Kind() == reflect.Struct &&
+			rv.Type().Elem() != durationType {
+			return "", false
+		}
+		return "[" + strings.Join(elems, ", ") + "]", true
+	}
+	return "", false
+}
+
+// snakeCase returns the key for a field name, such as otlp_endpoint for
+// OTLPEndpoint. The keys are matched to the fields without their
+// underscores, so any splitting loads, but this one is the conventional one.
+func snakeCase(name string) string {
+	runes := []rune(name)
+	var b bytes.Buffer
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) ||
+				(unicode.IsUpper(prev) && nextLower) {
+				b.WriteByte('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// isSensitive returns true if the option with the key holds a secret.
+func isSensitive(key string) bool {
+	key = strings.ToLower(key)
+	for _, name := range sensitiveNames {
+		if strings.Contains(key, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package config
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_Render(t *testing.T) {
+	key, err := LoadSecretKey("./testdata/secret.key")
+	require.NoError(t, err)
+	c := NewConfig()
+	c.SecretKey = key
+	require.NoError(t, c.LoadConfig("./testdata/render.toml"))
+
+	var buf bytes.Buffer
+	require.NoError(t, c.Render(&buf))
+	rendered := buf.String()
+	for _, line := range []string{
+		"[global_tags] # ./testdata/render.toml:1\n",
+		`  dc = "us-east-1" # ./testdata/render.toml:2` + "\n",
+		"[agent] # ./testdata/render.toml:4\n",
+		`  interval = "5s" # ./testdata/render.toml:5` + "\n",
+		"  round_interval = true # default\n",
+		"[[outputs.file]] # ./testdata/render.toml:7\n",
+		`  files = ["stdout"] # ./testdata/render.toml:8` + "\n",
+		"[[inputs.memcached]] # ./testdata/render.toml:10\n",
+		`  interval = "5s" # ./testdata/render.toml:12` + "\n",
+		`  servers = "<redacted>" # ./testdata/render.toml:11` + "\n",
+		"  unix_sockets = [] # default\n",
+		"[inputs.memcached.tagpass] # ./testdata/render.toml:13\n",
+		`  goodtag = ["mytag"] # ./testdata/render.toml:14` + "\n",
+	} {
+		assert.Contains(t, rendered, line)
+	}
+	assert.NotContains(t, rendered, "192.168.1.1")
+	assert.NotContains(t, rendered, "ENC[")
+}
+
+func TestSnakeCase(t *testing.T) {
+	for name, key := range map[string]string{
+		"Interval":        "interval",
+		"MetricBatchSize": "metric_batch_size",
+		"OTLPEndpoint":    "otlp_endpoint",
+		"SSLCA":           "sslca",
+		"Log2Size":        "log2_size",
+	} {
+		assert.Equal(t, key, snakeCase(name))
+	}
+}
@@ -0,0 +1,291 @@
+package config
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/toml/ast"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	secretPrefix = "ENC["
+	secretSuffix = "]"
+
+	// secretKeySize is the size of the AES-256 secret keys.
+	secretKeySize = 32
+	// secretSaltSize is the size of each secret's salt, which its key is
+	// derived from.
+	secretSaltSize = 16
+)
+
+// SecretKey decrypts the secrets in a configuration. These are the string
+// values in an ENC[...] envelope, such as password = "ENC[...]".
+//
+// A secret is base64 encoded: a random salt, then a nonce and the value
+// encrypted with AES-256-GCM. Its key is derived from the salt and the
+// SecretKey, with scrypt for a passphrase, HMAC-SHA256 for a key.
+type SecretKey struct {
+	key        []byte
+	passphrase []byte
+
+	mu sync.Mutex
+	// derived are the keys derived from the passphrase, by salt, since
+	// scrypt is purposely slow
+	derived map[string][]byte
+}
+
+// IsSecret returns true if the value is in an ENC[...] envelope.
+func IsSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix) &&
+		strings.HasSuffix(value, secretSuffix)
+}
+
+// NewSecretKey returns the SecretKey of a 32 byte key.
+func NewSecretKey(key []byte) (*SecretKey, error) {
+	if len(key) != secretKeySize {
+		return nil, fmt.Errorf("secret key must be %d bytes, not %d",
+			secretKeySize, len(key))
+	}
+	return &SecretKey{key: key}, nil
+}
+
+// NewSecretPassphrase returns the SecretKey of a passphrase.
+func NewSecretPassphrase(passphrase string) (*SecretKey, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty secret passphrase")
+	}
+	return &SecretKey{passphrase: []byte(passphrase)}, nil
+}
+
+// LoadSecretKey loads a 32 byte key from a file, raw or base64 encoded.
+func LoadSecretKey(path string) (*SecretKey, error) {
+	key, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewSecretKey(key)
+}
+
+// LoadKMSSecretKey loads a key encrypted with AWS KMS from a file, raw or
+// base64 encoded, and decrypts it with KMS, in the region of AWS_REGION and
+// with the credentials of AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY.
+func LoadKMSSecretKey(path string) (*SecretKey, error) {
+	blob, err := readKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := kmsDecrypt(blob)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting %s with KMS: %s", path, err)
+	}
+	return NewSecretKey(key)
+}
+
+func readKeyFile(path string) ([]byte, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.TrimSpace(string(data))
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+		return decoded, nil
+	}
+	return data, nil
+}
+
+// Encrypt returns the secret of a value, in its ENC[...] envelope.
+func (k *SecretKey) Encrypt(value []byte) (string, error) {
+	salt := make([]byte, secretSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return "", err
+	}
+	aead, err := k.aead(salt)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	secret := append(salt, nonce...)
+	secret = aead.Seal(secret, nonce, value, salt)
+	return secretPrefix + base64.StdEncoding.EncodeToString(secret) +
+		secretSuffix, nil
+}
+
+// Decrypt returns the value of a secret in its ENC[...] envelope.
+func (k *SecretKey) Decrypt(secret string) ([]byte, error) {
+	if !IsSecret(secret) {
+		return nil, errors.New("not an ENC[...] secret")
+	}
+	data, err := base64.StdEncoding.DecodeString(
+		secret[len(secretPrefix) : len(secret)-len(secretSuffix)])
+	if err != nil {
+		return nil, fmt.Errorf("invalid secret: %s", err)
+	}
+	if len(data) < secretSaltSize {
+		return nil, errors.New("invalid secret: too short")
+	}
+	salt, data := data[:secretSaltSize], data[secretSaltSize:]
+	aead, err := k.aead(salt)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < aead.NonceSize() {
+		return nil, errors.New("invalid secret: too short")
+	}
+	nonce, data := data[:aead.NonceSize()], data[aead.NonceSize():]
+	value, err := aead.Open(nil, nonce, data, salt)
+	if err != nil {
+		return nil, errors.New("cannot decrypt secret, wrong key?")
+	}
+	return value, nil
+}
+
+// aead returns the cipher for secrets with the given salt.
+func (k *SecretKey) aead(salt []byte) (cipher.AEAD, error) {
+	key, err := k.derive(salt)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (k *SecretKey) derive(salt []byte) ([]byte, error) {
+	if k.passphrase == nil {
+		h := hmac.New(sha256.New, k.key)
+		h.Write(salt)
+		return h.Sum(nil), nil
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.derived[string(salt)]; ok {
+		return key, nil
+	}
+	key, err := scrypt.Key(k.passphrase, salt, 1<<15, 8, 1, secretKeySize)
+	if err != nil {
+		return nil, err
+	}
+	if k.derived == nil {
+		k.derived = make(map[string][]byte)
+	}
+	k.derived[string(salt)] = key
+	return key, nil
+}
+
+// decryptTable replaces the secrets in a table and its subtables with their
+// values. The source of the values is left as is, so that the values do
+// not end up in the fingerprints of the plugins.
+func (k *SecretKey) decryptTable(tbl *ast.Table) error {
+	return walkStrings(tbl, func(v *ast.String) error {
+		if !IsSecret(v.Value) {
+			return nil
+		}
+		if k == nil {
+			return errors.New("encrypted value, but no secret key given")
+		}
+		plain, err := k.Decrypt(v.Value)
+		if err != nil {
+			return err
+		}
+		v.Value = string(plain)
+		return nil
+	})
+}
+
+// walkStrings calls f with the string values in a table and its subtables,
+// including those in arrays.
+func walkStrings(tbl *ast.Table, f func(*ast.String) error) error {
+	for name, field := range tbl.Fields {
+		var err error
+		switch v := field.(type) {
+		case *ast.KeyValue:
+			err = walkValue(v.Value, f)
+		case *ast.Table:
+			err = walkStrings(v, f)
+		case []*ast.Table:
+			for _, t := range v {
+				if err = walkStrings(t, f); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", name, err)
+		}
+	}
+	return nil
+}
+
+func walkValue(value ast.Value, f func(*ast.String) error) error {
+	switch v := value.(type) {
+	case *ast.String:
+		return f(v)
+	case *ast.Array:
+		for _, elem := range v.Value {
+			if err := walkValue(elem, f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// kmsDecrypt decrypts a ciphertext blob with the Decrypt action of AWS KMS.
+func kmsDecrypt(blob []byte) ([]byte, error) {
+	id := os.Getenv("AWS_ACCESS_KEY_ID")
+	if id == "" {
+		return nil, errors.New("AWS_ACCESS_KEY_ID not set")
+	}
+	body, err := json.Marshal(map[string][]byte{"CiphertextBlob": blob})
+	if err != nil {
+		return nil, err
+	}
+	region := awsRegion()
+	req, err := http.NewRequest("POST",
+		fmt.Sprintf("https://kms.%s.amazonaws.com/", region),
+		bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService.Decrypt")
+	hash := sha256.Sum256(body)
+	signV4(req, region, "kms", hex.EncodeToString(hash[:]), id,
+		os.Getenv("AWS_SECRET_ACCESS_KEY"), os.Getenv("AWS_SESSION_TOKEN"),
+		time.Now().UTC())
+
+	resp, err := do(req)
+	if err != nil {
+		return nil, err
+	}
+	var r struct {
+		Plaintext []byte `json:"Plaintext"`
+	}
+	if err := json.Unmarshal(resp, &r); err != nil {
+		return nil, err
+	}
+	return r.Plaintext, nil
+}
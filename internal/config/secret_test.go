@@ -0,0 +1,85 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/influxdata/telegraf/plugins/inputs/memcached"
+	"github.com/influxdata/toml/ast"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretKey(t *testing.T) {
+	key, err := NewSecretKey([]byte("0123456789abcdef0123456789abcdef"))
+	require.NoError(t, err)
+	secret, err := key.Encrypt([]byte(`p@ss"word`))
+	require.NoError(t, err)
+	assert.True(t, IsSecret(secret))
+
+	value, err := key.Decrypt(secret)
+	require.NoError(t, err)
+	assert.Equal(t, `p@ss"word`, string(value))
+
+	// every secret has its own salt and nonce
+	other, err := key.Encrypt([]byte(`p@ss"word`))
+	require.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+
+	wrong, err := NewSecretKey([]byte("fedcba9876543210fedcba9876543210"))
+	require.NoError(t, err)
+	_, err = wrong.Decrypt(secret)
+	assert.Error(t, err)
+
+	tampered := strings.Replace(secret, secret[10:11], "A", 1)
+	if tampered == secret {
+		tampered = strings.Replace(secret, secret[10:11], "B", 1)
+	}
+	_, err = key.Decrypt(tampered)
+	assert.Error(t, err)
+
+	_, err = NewSecretKey([]byte("short"))
+	assert.Error(t, err)
+}
+
+func TestSecretPassphrase(t *testing.T) {
+	key, err := NewSecretPassphrase("correct horse battery staple")
+	require.NoError(t, err)
+	secret, err := key.Encrypt([]byte("password"))
+	require.NoError(t, err)
+
+	other, err := NewSecretPassphrase("correct horse battery staple")
+	require.NoError(t, err)
+	value, err := other.Decrypt(secret)
+	require.NoError(t, err)
+	assert.Equal(t, "password", string(value))
+
+	wrong, err := NewSecretPassphrase("hunter2")
+	require.NoError(t, err)
+	_, err = wrong.Decrypt(secret)
+	assert.Error(t, err)
+}
+
+func TestConfig_Secret(t *testing.T) {
+	key, err := LoadSecretKey("./testdata/secret.key")
+	require.NoError(t, err)
+	c := NewConfig()
+	c.SecretKey = key
+	require.NoError(t, c.LoadConfig("./testdata/secret.toml"))
+	require.Equal(t, 1, len(c.Inputs))
+	m := c.Inputs[0].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"192.168.1.1"}, m.Servers)
+
+	// the source of the value, that the fingerprint is computed from, is
+	// left encrypted
+	tbl, err := c.parseFile("./testdata/secret.toml")
+	require.NoError(t, err)
+	plugin := tbl.Fields["inputs"].(*ast.Table).Fields["memcached"]
+	servers := plugin.([]*ast.Table)[0].Fields["servers"].(*ast.KeyValue)
+	assert.Contains(t, servers.Value.Source(), "ENC[")
+	assert.NotContains(t, servers.Value.Source(), "192.168.1.1")
+
+	c = NewConfig()
+	assert.Error(t, c.LoadConfig("./testdata/secret.toml"))
+}
@@ -0,0 +1,206 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/toml/ast"
+)
+
+const (
+	secretRefPrefix = "@{"
+	secretRefSuffix = "}"
+
+	// vaultDefaultField is the field of a vault secret read if the reference
+	// names none.
+	vaultDefaultField = "value"
+)
+
+// errSecretMiss is returned by a secret store that does not have a secret.
+var errSecretMiss = errors.New("secret not found")
+
+// secretStores are the stores a secret reference can read from, by name. A
+// store returns errSecretMiss if it does not have the secret, so that the
+// reference's next store is tried.
+var secretStores = map[string]func(key string) (string, error){
+	"env":   envSecret,
+	"file":  fileSecret,
+	"vault": vaultSecret,
+}
+
+// IsSecretRef returns true if the value references a secret in secret
+// stores, such as @{vault:secret/data/db#password, env:DB_PASSWORD}.
+func IsSecretRef(value string) bool {
+	return strings.HasPrefix(value, secretRefPrefix) &&
+		strings.HasSuffix(value, secretRefSuffix)
+}
+
+// secretCache holds the resolved secret values, by reference. It is kept
+// across configuration loads so that reloading does not query the stores
+// every time.
+var secretCache struct {
+	sync.Mutex
+	values map[string]cachedSecret
+}
+
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// resolveSecrets replaces the secret references in a table and its
+// subtables. Each takes its value from the first store in the reference that
+// has the secret. Like the ENC[...] secrets, the source of the values is left as
+// is.
+func (c *Config) resolveSecrets(tbl *ast.Table) error {
+	return walkStrings(tbl, func(v *ast.String) error {
+		if !IsSecretRef(v.Value) {
+			return nil
+		}
+		value, err := resolveSecret(v.Value, c.SecretCacheTTL)
+		if err != nil {
+			return err
+		}
+		v.Value = value
+		return nil
+	})
+}
+
+// resolveSecret returns the value of a secret reference, cached for ttl.
+func resolveSecret(ref string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	secretCache.Lock()
+	cached, ok := secretCache.values[ref]
+	secretCache.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.value, nil
+	}
+
+	inner := strings.TrimSuffix(strings.TrimPrefix(ref, secretRefPrefix),
+		secretRefSuffix)
+	var stores []string
+	for _, store := range strings.Split(inner, ",") {
+		store = strings.TrimSpace(store)
+		name, key := store, ""
+		if i := strings.Index(store, ":"); i >= 0 {
+			name, key = store[:i], store[i+1:]
+		}
+		read, ok := secretStores[name]
+		if !ok || key == "" {
+			return "", fmt.Errorf("invalid secret store %q in %s, expected "+
+				"env:<variable>, file:<path> or vault:<path>[#<field>]",
+				store, ref)
+		}
+		value, err := read(key)
+		if err == errSecretMiss {
+			stores = append(stores, store)
+			continue
+		}
+		if err != nil {
+			return "", fmt.Errorf("secret store %s: %s", store, err)
+		}
+		if ttl > 0 {
+			secretCache.Lock()
+			if secretCache.values == nil {
+				secretCache.values = make(map[string]cachedSecret)
+			}
+			secretCache.values[ref] = cachedSecret{
+				value:   value,
+				expires: now.Add(ttl),
+			}
+			secretCache.Unlock()
+		}
+		return value, nil
+	}
+	return "", fmt.Errorf("secret not found in %s", strings.Join(stores, ", "))
+}
+
+// envSecret reads a secret from an environment variable.
+func envSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errSecretMiss
+	}
+	return value, nil
+}
+
+// fileSecret reads a secret from a file, without its trailing newline. Docker
+// and kubernetes secrets are such files.
+func fileSecret(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", errSecretMiss
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(string(data), "\n"), nil
+}
+
+// vaultSecret reads a field of a HashiCorp Vault secret, at <path>#<field>.
+// It uses the VAULT_ADDR server and the VAULT_TOKEN token. Both the KV version 1 and 2 secrets engines are supported.
+func vaultSecret(key string) (string, error) {
+	addr := os.Getenv("VAULT_ADDR")
+	if addr == "" {
+		return "", errSecretMiss
+	}
+	path, field := key, vaultDefaultField
+	if i := strings.LastIndex(key, "#"); i >= 0 {
+		path, field = key[:i], key[i+1:]
+	}
+
+	req, err := http.NewRequest("GET",
+		strings.TrimSuffix(addr, "/")+"/v1/"+strings.TrimPrefix(path, "/"),
+		nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv("VAULT_TOKEN"))
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := remoteClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errSecretMiss
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", req.URL, resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", err
+	}
+	data := secret.Data
+	// the KV version 2 engine nests the fields, with the metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	value, ok := data[field]
+	if !ok {
+		return "", errSecretMiss
+	}
+	if s, ok := value.(string); ok {
+		return s, nil
+	}
+	return fmt.Sprint(value), nil
+}
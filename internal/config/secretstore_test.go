@@ -0,0 +1,103 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/plugins/inputs/memcached"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfig_SecretRef(t *testing.T) {
+	os.Unsetenv("VAULT_ADDR")
+	os.Setenv("TELEGRAF_TEST_SERVER", "192.168.1.1")
+	defer os.Unsetenv("TELEGRAF_TEST_SERVER")
+
+	c := NewConfig()
+	c.SecretCacheTTL = 0
+	require.NoError(t, c.LoadConfig("./testdata/secret_ref.toml"))
+	m := c.Inputs[0].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"192.168.1.1"}, m.Servers)
+
+	// the references are not in the fingerprint of the plugin
+	os.Setenv("TELEGRAF_TEST_SERVER", "192.168.1.2")
+	other := NewConfig()
+	other.SecretCacheTTL = 0
+	require.NoError(t, other.LoadConfig("./testdata/secret_ref.toml"))
+	m = other.Inputs[0].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"192.168.1.2"}, m.Servers)
+	assert.Equal(t, c.Inputs[0].Config.Fingerprint,
+		other.Inputs[0].Config.Fingerprint)
+}
+
+func TestResolveSecret(t *testing.T) {
+	var requests int
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			assert.Equal(t, "token", r.Header.Get("X-Vault-Token"))
+			switch r.URL.Path {
+			case "/v1/secret/data/db":
+				fmt.Fprint(w, `{"data": {"data": {"password": "kv2"},`+
+					` "metadata": {"version": 1}}}`)
+			case "/v1/kv/db":
+				fmt.Fprint(w, `{"data": {"value": "kv1"}}`)
+			case "/v1/forbidden":
+				w.WriteHeader(http.StatusForbidden)
+			default:
+				w.WriteHeader(http.StatusNotFound)
+			}
+		}))
+	defer ts.Close()
+	os.Setenv("VAULT_ADDR", ts.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	os.Setenv("TELEGRAF_TEST_PASSWORD", "env")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+	defer os.Unsetenv("TELEGRAF_TEST_PASSWORD")
+
+	dir, err := ioutil.TempDir("", "secret")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "password")
+	require.NoError(t, ioutil.WriteFile(file, []byte("file\n"), 0600))
+
+	for ref, value := range map[string]string{
+		"@{vault:secret/data/db#password}":                           "kv2",
+		"@{vault:kv/db}":                                             "kv1",
+		"@{vault:kv/missing, env:TELEGRAF_TEST_PASSWORD}":            "env",
+		"@{env:TELEGRAF_TEST_UNSET, file:" + file + "}":              "file",
+		"@{file:" + file + ".missing,vault:secret/data/db#password}": "kv2",
+	} {
+		got, err := resolveSecret(ref, 0)
+		require.NoError(t, err, ref)
+		assert.Equal(t, value, got, ref)
+	}
+
+	for _, ref := range []string{
+		"@{env:TELEGRAF_TEST_UNSET, vault:kv/missing}",
+		"@{vault:forbidden, env:TELEGRAF_TEST_PASSWORD}",
+		"@{consul:db}",
+		"@{env:}",
+	} {
+		_, err := resolveSecret(ref, 0)
+		assert.Error(t, err, ref)
+	}
+
+	// the values are cached for the ttl
+	requests = 0
+	for i := 0; i < 2; i++ {
+		got, err := resolveSecret("@{vault:kv/db}", time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, "kv1", got)
+	}
+	assert.Equal(t, 1, requests)
+}
@@ -0,0 +1,284 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"runtime"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+// templateSuffix is the suffix of the config files that are templates.
+const templateSuffix = ".tmpl"
+
+// metadataTimeout is how long the metadata service of each cloud is waited
+// for.
+const metadataTimeout = time.Second
+
+// The metadata services of the clouds, variables for testing.
+var (
+	awsMetadataURL   = "http://169.254.169.254"
+	gcpMetadataURL   = "http://metadata.google.internal"
+	azureMetadataURL = "http://169.254.169.254"
+)
+
+// isTemplate returns true if the config file is a template.
+func isTemplate(path string) bool {
+	return strings.HasSuffix(path, templateSuffix)
+}
+
+// executeTemplate executes the Go template of a config file, with the facts
+// of the host as data.
+func executeTemplate(name string, contents []byte) ([]byte, error) {
+	tmpl, err := template.New(name).
+		Option("missingkey=error").
+		Funcs(templateFuncs).
+		Parse(string(contents))
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, newHostFacts()); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+var templateFuncs = template.FuncMap{
+	// default returns value, or def if value is empty
+	"default": func(def, value interface{}) interface{} {
+		if value == nil || value == "" || value == 0 || value == false {
+			return def
+		}
+		return value
+	},
+	// env returns an environment variable, or the first fallback if it is
+	// not set
+	"env": func(name string, fallback ...string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		if len(fallback) > 0 {
+			return fallback[0]
+		}
+		return ""
+	},
+	// file returns the contents of a file, without its trailing newline
+	"file": func(path string) (string, error) {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(string(data), "\n"), nil
+	},
+}
+
+// hostFacts are the data of the config templates.
+type hostFacts struct {
+	Hostname string
+	// OS and Arch are the GOOS and GOARCH of telegraf, such as linux and amd64
+	OS     string
+	Arch   string
+	NumCPU int
+}
+
+func newHostFacts() hostFacts {
+	hostname, _ := os.Hostname()
+	return hostFacts{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		NumCPU:   runtime.NumCPU(),
+	}
+}
+
+// cloudFacts are the facts of the cloud instance telegraf runs on, empty if
+// it runs on none.
+type cloudFacts struct {
+	// Provider is aws, gcp or azure
+	Provider     string
+	Region       string
+	Zone         string
+	InstanceID   string
+	InstanceType string
+}
+
+var cloud struct {
+	once  sync.Once
+	facts cloudFacts
+}
+
+// Cloud returns the facts of the cloud instance, queried from the metadata
+// services of the clouds the first time it is called.
+func (hostFacts) Cloud() cloudFacts {
+	cloud.once.Do(func() {
+		cloud.facts = queryCloud()
+	})
+	return cloud.facts
+}
+
+// queryCloud queries the metadata services of the clouds concurrently, and
+// returns the facts of the first that answers.
+func queryCloud() cloudFacts {
+	client := &http.Client{Timeout: metadataTimeout}
+	queries := []func(*http.Client) (cloudFacts, error){
+		queryAWS, queryGCP, queryAzure,
+	}
+	results := make(chan cloudFacts, len(queries))
+	for _, query := range queries {
+		go func(query func(*http.Client) (cloudFacts, error)) {
+			facts, err := query(client)
+			if err != nil {
+				facts = cloudFacts{}
+			}
+			results <- facts
+		}(query)
+	}
+	for range queries {
+		if facts := <-results; facts.Provider != "" {
+			return facts
+		}
+	}
+	return cloudFacts{}
+}
+
+func metadataGet(
+	client *http.Client,
+	url string,
+	headers map[string]string,
+) ([]byte, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return body, nil
+}
+
+// queryAWS queries the instance identity document of EC2, with IMDSv2.
+func queryAWS(client *http.Client) (cloudFacts, error) {
+	req, err := http.NewRequest("PUT", awsMetadataURL+"/latest/api/token", nil)
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := client.Do(req)
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cloudFacts{}, fmt.Errorf("token request returned %s",
+			resp.Status)
+	}
+
+	body, err := metadataGet(client,
+		awsMetadataURL+"/latest/dynamic/instance-identity/document",
+		map[string]string{"X-aws-ec2-metadata-token": string(token)})
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	var doc struct {
+		Region           string `json:"region"`
+		AvailabilityZone string `json:"availabilityZone"`
+		InstanceID       string `json:"instanceId"`
+		InstanceType     string `json:"instanceType"`
+	}
+	if err := json.Unmarshal(body, &doc); err != nil {
+		return cloudFacts{}, err
+	}
+	return cloudFacts{
+		Provider:     "aws",
+		Region:       doc.Region,
+		Zone:         doc.AvailabilityZone,
+		InstanceID:   doc.InstanceID,
+		InstanceType: doc.InstanceType,
+	}, nil
+}
+
+// queryGCP queries the metadata server of Compute Engine.
+func queryGCP(client *http.Client) (cloudFacts, error) {
+	get := func(key string) (string, error) {
+		body, err := metadataGet(client,
+			gcpMetadataURL+"/computeMetadata/v1/instance/"+key,
+			map[string]string{"Metadata-Flavor": "Google"})
+		return string(body), err
+	}
+	// the zone and machine type are paths, such as
+	// projects/1234/zones/us-central1-a
+	zone, err := get("zone")
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	id, err := get("id")
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	machineType, err := get("machine-type")
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	zone = path.Base(zone)
+	region := zone
+	if i := strings.LastIndex(zone, "-"); i > 0 {
+		region = zone[:i]
+	}
+	return cloudFacts{
+		Provider:     "gcp",
+		Region:       region,
+		Zone:         zone,
+		InstanceID:   id,
+		InstanceType: path.Base(machineType),
+	}, nil
+}
+
+// queryAzure queries the instance metadata service of Azure.
+func queryAzure(client *http.Client) (cloudFacts, error) {
+	body, err := metadataGet(client,
+		azureMetadataURL+"/metadata/instance/compute?api-version=2021-02-01",
+		map[string]string{"Metadata": "true"})
+	if err != nil {
+		return cloudFacts{}, err
+	}
+	var compute struct {
+		Location string `json:"location"`
+		Zone     string `json:"zone"`
+		VMID     string `json:"vmId"`
+		VMSize   string `json:"vmSize"`
+	}
+	if err := json.Unmarshal(body, &compute); err != nil {
+		return cloudFacts{}, err
+	}
+	return cloudFacts{
+		Provider:     "azure",
+		Region:       compute.Location,
+		Zone:         compute.Zone,
+		InstanceID:   compute.VMID,
+		InstanceType: compute.VMSize,
+	}, nil
+}
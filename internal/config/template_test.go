@@ -0,0 +1,110 @@
+package config
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/influxdata/telegraf/plugins/inputs/memcached"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// awsMetadata serves the instance identity document of an EC2 instance.
+func awsMetadata(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/latest/api/token":
+				assert.Equal(t, "PUT", r.Method)
+				w.Write([]byte("token"))
+			case "/latest/dynamic/instance-identity/document":
+				assert.Equal(t, "token",
+					r.Header.Get("X-aws-ec2-metadata-token"))
+				w.Write([]byte(`{"region": "eu-west-1",
+					"availabilityZone": "eu-west-1a",
+					"instanceId": "i-1234",
+					"instanceType": "m5.large"}`))
+			default:
+				http.NotFound(w, r)
+			}
+		}))
+}
+
+func TestConfig_Template(t *testing.T) {
+	ts := awsMetadata(t)
+	defer ts.Close()
+	awsMetadataURL, gcpMetadataURL, azureMetadataURL = ts.URL, ts.URL, ts.URL
+
+	c := NewConfig()
+	require.NoError(t, c.LoadConfig("./testdata/template.conf.tmpl"))
+	hostname, _ := os.Hostname()
+	assert.Equal(t, hostname, c.Tags["host"])
+	assert.Equal(t, runtime.GOOS, c.Tags["os"])
+	require.Equal(t, 1, len(c.Inputs))
+	m := c.Inputs[0].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"192.168.1.1", "192.168.1.2"}, m.Servers)
+	assert.Equal(t, []string{"/var/run/memcached-aws.sock"}, m.UnixSockets)
+
+	os.Setenv("TELEGRAF_TEMPLATE_SERVER", "192.168.1.3")
+	defer os.Unsetenv("TELEGRAF_TEMPLATE_SERVER")
+	c = NewConfig()
+	require.NoError(t, c.LoadConfig("./testdata/template.conf.tmpl"))
+	m = c.Inputs[0].Input.(*memcached.Memcached)
+	assert.Equal(t, []string{"192.168.1.3", "192.168.1.2"}, m.Servers)
+}
+
+func TestExecuteTemplate_Errors(t *testing.T) {
+	_, err := executeTemplate("t", []byte(`{{ .Unknown }}`))
+	assert.Error(t, err)
+	_, err = executeTemplate("t", []byte(`{{ file "./testdata/missing" }}`))
+	assert.Error(t, err)
+	_, err = executeTemplate("t", []byte(`{{ if }}`))
+	assert.Error(t, err)
+}
+
+func TestQueryCloud(t *testing.T) {
+	ts := awsMetadata(t)
+	defer ts.Close()
+	awsMetadataURL = ts.URL
+	facts, err := queryAWS(ts.Client())
+	require.NoError(t, err)
+	assert.Equal(t, cloudFacts{
+		Provider:     "aws",
+		Region:       "eu-west-1",
+		Zone:         "eu-west-1a",
+		InstanceID:   "i-1234",
+		InstanceType: "m5.large",
+	}, facts)
+
+	gcp := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "Google", r.Header.Get("Metadata-Flavor"))
+			switch r.URL.Path {
+			case "/computeMetadata/v1/instance/zone":
+				w.Write([]byte("projects/1234/zones/us-central1-a"))
+			case "/computeMetadata/v1/instance/id":
+				w.Write([]byte("5678"))
+			case "/computeMetadata/v1/instance/machine-type":
+				w.Write([]byte("projects/1234/machineTypes/n1-standard-1"))
+			}
+		}))
+	defer gcp.Close()
+	gcpMetadataURL = gcp.URL
+	facts, err = queryGCP(gcp.Client())
+	require.NoError(t, err)
+	assert.Equal(t, cloudFacts{
+		Provider:     "gcp",
+		Region:       "us-central1",
+		Zone:         "us-central1-a",
+		InstanceID:   "5678",
+		InstanceType: "n1-standard-1",
+	}, facts)
+
+	// not an Azure instance
+	azureMetadataURL = ts.URL
+	_, err = queryAzure(ts.Client())
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+// Package cron parses cron expressions and computes when they next fire.
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression. It fires at every second that matches
+// all of its fields, in its location.
+type Schedule struct {
+	second, minute, hour, dom, month, dow uint64
+
+	// domStar and dowStar are true if the day of month or day of week field
+	// is unrestricted. If both are restricted, a day matches if either does.
+	domStar, dowStar bool
+
+	loc *time.Location
+}
+
+type bounds struct {
+	min, max uint
+	names    map[string]uint
+}
+
+var (
+	seconds = bounds{0, 59, nil}
+	minutes = bounds{0, 59, nil}
+	hours   = bounds{0, 23, nil}
+	doms    = bounds{1, 31, nil}
+	months  = bounds{1, 12, map[string]uint{
+		"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+		"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+	}}
+	// 7 is accepted as Sunday, and folded into 0
+	dows = bounds{0, 7, map[string]uint{
+		"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}}
+)
+
+var descriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+// Parse parses a cron expression, evaluated in the given location. The
+// expression has six fields:
+//
+//	second minute hour day-of-month month day-of-week
+//
+// or five, without the seconds, which are then 0. Each field is a "*", a
+// value, a range "a-b", or a list of those separated by commas, each
+// optionally followed by a step "/n". Months and days of the week may be given
+// by their first three letters. The descriptors @yearly, @monthly, @weekly,
+// @daily and @hourly are also accepted.
+func Parse(spec string, loc *time.Location) (*Schedule, error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	spec = strings.TrimSpace(spec)
+	if d, ok := descriptors[strings.ToLower(spec)]; ok {
+		spec = d
+	}
+
+	fields := strings.Fields(spec)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("cron expression %q must have 5 or 6 fields, "+
+			"not %d", spec, len(fields))
+	}
+
+	s := &Schedule{loc: loc}
+	var err error
+	if s.second, err = parseField(fields[0], seconds); err != nil {
+		return nil, fmt.Errorf("cron expression %q, seconds: %s", spec, err)
+	}
+	if s.minute, err = parseField(fields[1], minutes); err != nil {
+		return nil, fmt.Errorf("cron expression %q, minutes: %s", spec, err)
+	}
+	if s.hour, err = parseField(fields[2], hours); err != nil {
+		return nil, fmt.Errorf("cron expression %q, hours: %s", spec, err)
+	}
+	if s.dom, err = parseField(fields[3], doms); err != nil {
+		return nil, fmt.Errorf("cron expression %q, day of month: %s", spec, err)
+	}
+	if s.month, err = parseField(fields[4], months); err != nil {
+		return nil, fmt.Errorf("cron expression %q, month: %s", spec, err)
+	}
+	if s.dow, err = parseField(fields[5], dows); err != nil {
+		return nil, fmt.Errorf("cron expression %q, day of week: %s", spec, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domStar = isStar(fields[3])
+	s.dowStar = isStar(fields[5])
+	return s, nil
+}
+
+func isStar(field string) bool {
+	return field == "*" || field == "?"
+}
+
+// parseField returns the bitmask of the values matched by a field.
+func parseField(field string, b bounds) (uint64, error) {
+	var bits uint64
+	for _, expr := range strings.Split(field, ",") {
+		rangeExpr, step := expr, uint(1)
+		if i := strings.Index(expr, "/"); i >= 0 {
+			n, err := strconv.ParseUint(expr[i+1:], 10, 8)
+			if err != nil || n == 0 {
+				return 0, fmt.Errorf("invalid step in %q", expr)
+			}
+			rangeExpr, step = expr[:i], uint(n)
+		}
+
+		var start, end uint
+		switch {
+		case isStar(rangeExpr):
+			start, end = b.min, b.max
+		case strings.Contains(rangeExpr, "-"):
+			parts := strings.SplitN(rangeExpr, "-", 2)
+			var err error
+			if start, err = parseValue(parts[0], b); err != nil {
+				return 0, err
+			}
+			if end, err = parseValue(parts[1], b); err != nil {
+				return 0, err
+			}
+			if start > end {
+				return 0, fmt.Errorf("invalid range %q", rangeExpr)
+			}
+		default:
+			var err error
+			if start, err = parseValue(rangeExpr, b); err != nil {
+				return 0, err
+			}
+			end = start
+			// "a/n" means from a to the maximum
+			if step > 1 {
+				end = b.max
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, b bounds) (uint, error) {
+	if v, ok := b.names[strings.ToLower(s)]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if uint(v) < b.min || uint(v) > b.max {
+		return 0, fmt.Errorf("value %d out of range [%d, %d]", v, b.min, b.max)
+	}
+	return uint(v), nil
+}
+
+// Next returns the first time after t at which the schedule fires. It returns
+// the zero time if the schedule does not fire within the next five years, as
+// with "0 0 0 30 2 *". Times skipped by daylight saving time are skipped by the
+// schedule too.
+func (s *Schedule) Next(t time.Time) time.Time {
+	// start at the next whole second
+	t = t.In(s.loc).Truncate(time.Second).Add(time.Second)
+	yearLimit := t.Year() + 5
+
+wrap:
+	if t.Year() > yearLimit {
+		return time.Time{}
+	}
+
+	for s.month&(1<<uint(t.Month())) == 0 {
+		t = startOfDay(t.Year(), t.Month()+1, 1, s.loc)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+
+	for !s.dayMatches(t) {
+		t = startOfDay(t.Year(), t.Month(), t.Day()+1, s.loc)
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		// add an hour of elapsed time rather than of wall clock time, which
+		// may be skipped or repeated by daylight saving time
+		t = t.Add(time.Hour - time.Duration(t.Minute())*time.Minute -
+			time.Duration(t.Second())*time.Second)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		t = t.Truncate(time.Minute).Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+
+	for s.second&(1<<uint(t.Second())) == 0 {
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+
+	return t
+}
+
+// startOfDay returns the first time of the given day. If midnight is skipped
+// by daylight saving time, that is 1am.
+func startOfDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	t := time.Date(year, month, day, 0, 0, 0, 0, loc)
+	if t.Hour() == 23 {
+		// Date picked the time before the skipped hour, on the previous day
+		t = t.Add(time.Hour)
+	}
+	return t
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
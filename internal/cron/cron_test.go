@@ -0,0 +1,88 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseInvalid(t *testing.T) {
+	specs := []string{
+		"",
+		"* * * *",
+		"* * * * * * *",
+		"60 * * * * *",
+		"* * 24 * * *",
+		"* * * 0 * *",
+		"* * * * 13 *",
+		"* * * * * 8",
+		"* * * * foo *",
+		"*/0 * * * * *",
+		"5-1 * * * * *",
+	}
+	for _, spec := range specs {
+		_, err := Parse(spec, time.UTC)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		spec string
+		from string
+		next string
+	}{
+		// every 5 minutes
+		{"0 */5 * * * *", "2016-06-13T17:43:50Z", "2016-06-13T17:45:00Z"},
+		{"0 */5 * * * *", "2016-06-13T17:45:00Z", "2016-06-13T17:50:00Z"},
+		// five fields, without seconds
+		{"*/5 * * * *", "2016-06-13T17:43:50Z", "2016-06-13T17:45:00Z"},
+		// hourly, wrapping to the next day
+		{"@hourly", "2016-06-13T23:59:59Z", "2016-06-14T00:00:00Z"},
+		// ranges and lists
+		{"30 0 9-17 * * mon-fri", "2016-06-10T17:00:31Z", "2016-06-13T09:00:30Z"},
+		{"0 0 0,12 * * *", "2016-06-13T00:00:00Z", "2016-06-13T12:00:00Z"},
+		// a value with a step runs from the value to the maximum
+		{"0 50/5 * * * *", "2016-06-13T17:56:00Z", "2016-06-13T18:50:00Z"},
+		// both day fields restricted: either one matches
+		{"0 0 0 1 * sun", "2016-06-13T00:00:00Z", "2016-06-19T00:00:00Z"},
+		// 7 is sunday
+		{"0 0 0 * * 7", "2016-06-13T00:00:00Z", "2016-06-19T00:00:00Z"},
+		// leap day
+		{"0 0 0 29 feb *", "2016-03-01T00:00:00Z", "2020-02-29T00:00:00Z"},
+		// never
+		{"0 0 0 30 feb *", "2016-03-01T00:00:00Z", "0001-01-01T00:00:00Z"},
+	}
+
+	for _, test := range tests {
+		s, err := Parse(test.spec, time.UTC)
+		require.NoError(t, err, test.spec)
+		from, _ := time.Parse(time.RFC3339, test.from)
+		next, _ := time.Parse(time.RFC3339, test.next)
+		assert.Equal(t, next.UTC(), s.Next(from).UTC(),
+			"%s from %s", test.spec, test.from)
+	}
+}
+
+func TestNextTimezone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("Skipping test, no timezone database")
+	}
+	s, err := Parse("0 0 9 * * *", loc)
+	require.NoError(t, err)
+
+	from := time.Date(2016, 6, 13, 12, 0, 0, 0, time.UTC)
+	// 9:00 in New York is 13:00 UTC during daylight saving time
+	assert.Equal(t, time.Date(2016, 6, 13, 13, 0, 0, 0, time.UTC),
+		s.Next(from).UTC())
+
+	// 2:30 is skipped by daylight saving time on March 13th
+	s, err = Parse("0 30 2 * * *", loc)
+	require.NoError(t, err)
+	from = time.Date(2016, 3, 13, 0, 0, 0, 0, loc)
+	assert.Equal(t, time.Date(2016, 3, 14, 2, 30, 0, 0, loc),
+		s.Next(from))
+}
@@ -0,0 +1,113 @@
+// Package experimental holds the feature flags that gate the behaviors of
+// telegraf still in development. A behavior registers its Flag, and checks
+// that it is enabled, which it is if it is set to true in the [experimental]
+// table of the configuration.
+//
+// The behaviors of the flags may change, or go away along with their flag,
+// in any release.
+package experimental
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Flag is a feature flag gating an experimental behavior.
+type Flag struct {
+	Name        string
+	Description string
+
+	// enabled is 1 while the flag is enabled
+	enabled int32
+}
+
+// Enabled returns true if the flag is enabled.
+func (f *Flag) Enabled() bool {
+	return atomic.LoadInt32(&f.enabled) == 1
+}
+
+var registry struct {
+	sync.Mutex
+	flags map[string]*Flag
+}
+
+// Register registers the flag of an experimental behavior, disabled. It
+// panics if a flag of the same name is already registered, so it is called
+// when initializing the package of the behavior:
+//
+//	var priorityBatches = experimental.Register("priority_batches", "...")
+func Register(name, description string) *Flag {
+	registry.Lock()
+	defer registry.Unlock()
+	if registry.flags == nil {
+		registry.flags = make(map[string]*Flag)
+	}
+	if _, ok := registry.flags[name]; ok {
+		panic("experimental: Register called twice for flag " + name)
+	}
+	f := &Flag{Name: name, Description: description}
+	registry.flags[name] = f
+	return f
+}
+
+// Flags returns the registered flags, by name.
+func Flags() []*Flag {
+	registry.Lock()
+	defer registry.Unlock()
+	flags := make([]*Flag, 0, len(registry.flags))
+	for _, f := range registry.flags {
+		flags = append(flags, f)
+	}
+	sort.Sort(byName(flags))
+	return flags
+}
+
+// byName sorts flags by their name.
+type byName []*Flag
+
+func (f byName) Len() int           { return len(f) }
+func (f byName) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byName) Less(i, j int) bool { return f[i].Name < f[j].Name }
+
+// Check returns an error if any of the flags is not registered.
+func Check(flags map[string]bool) error {
+	registry.Lock()
+	defer registry.Unlock()
+	var unknown []string
+	for name := range flags {
+		if _, ok := registry.flags[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) == 0 {
+		return nil
+	}
+	sort.Strings(unknown)
+	known := make([]string, 0, len(registry.flags))
+	for name := range registry.flags {
+		known = append(known, name)
+	}
+	sort.Strings(known)
+	return fmt.Errorf("unknown experimental flags %s, the flags are %s",
+		strings.Join(unknown, ", "), strings.Join(known, ", "))
+}
+
+// Set enables the flags set to true, and disables every other one.
+func Set(flags map[string]bool) error {
+	if err := Check(flags); err != nil {
+		return err
+	}
+	registry.Lock()
+	defer registry.Unlock()
+	for name, f := range registry.flags {
+		var enabled int32
+		if flags[name] {
+			enabled = 1
+		}
+		atomic.StoreInt32(&f.enabled, enabled)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package experimental
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSet(t *testing.T) {
+	a := Register("test_a", "a")
+	b := Register("test_b", "b")
+	defer Set(nil)
+
+	require.NoError(t, Set(map[string]bool{"test_a": true, "test_b": false}))
+	assert.True(t, a.Enabled())
+	assert.False(t, b.Enabled())
+
+	// the flags not set are disabled
+	require.NoError(t, Set(map[string]bool{"test_b": true}))
+	assert.False(t, a.Enabled())
+	assert.True(t, b.Enabled())
+
+	// unknown flags are an error, and leave the flags as they were
+	err := Set(map[string]bool{"test_a": true, "test_c": true})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "test_c")
+	assert.False(t, a.Enabled())
+	assert.True(t, b.Enabled())
+
+	var names []string
+	for _, f := range Flags() {
+		names = append(names, f.Name)
+	}
+	assert.Equal(t, []string{"test_a", "test_b"}, names)
+
+	assert.Panics(t, func() { Register("test_a", "again") })
+}
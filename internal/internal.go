@@ -15,6 +15,7 @@ import (
 	"os/exec"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
@@ -38,8 +39,11 @@ type Duration struct {
 func (d *Duration) UnmarshalTOML(b []byte) error {
 	var err error
 	// Parse string duration, ie, "1s"
-	d.Duration, err = time.ParseDuration(string(b[1 : len(b)-1]))
-	if err == nil {
+	if len(b) >= 2 && (b[0] == '"' || b[0] == '\'') {
+		d.Duration, err = time.ParseDuration(string(b[1 : len(b)-1]))
+		if err != nil {
+			return err
+		}
 		return nil
 	}
 
@@ -56,7 +60,7 @@ func (d *Duration) UnmarshalTOML(b []byte) error {
 		return nil
 	}
 
-	return nil
+	return fmt.Errorf("invalid duration %s", b)
 }
 
 // ReadLines reads contents from a file and splits them by new lines.
@@ -253,3 +257,44 @@ func RandomSleep(max time.Duration, shutdown chan struct{}) {
 		return
 	}
 }
+
+// PauseGate blocks the callers of Wait while it is paused. It lets service
+// inputs implement telegraf.Pausable. The zero value is not paused.
+type PauseGate struct {
+	mu sync.Mutex
+	// resume is closed on Resume, nil when not paused
+	resume chan struct{}
+}
+
+// Pause makes Wait block until Resume is called.
+func (g *PauseGate) Pause() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume == nil {
+		g.resume = make(chan struct{})
+	}
+}
+
+// Resume unblocks the callers of Wait.
+func (g *PauseGate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.resume != nil {
+		close(g.resume)
+		g.resume = nil
+	}
+}
+
+// Wait blocks while the gate is paused, or until done is closed.
+func (g *PauseGate) Wait(done chan struct{}) {
+	g.mu.Lock()
+	resume := g.resume
+	g.mu.Unlock()
+	if resume == nil {
+		return
+	}
+	select {
+	case <-resume:
+	case <-done:
+	}
+}
@@ -162,3 +162,48 @@ func TestRandomSleep(t *testing.T) {
 	elapsed = time.Since(s)
 	assert.True(t, elapsed < time.Millisecond*150)
 }
+
+func TestPauseGate(t *testing.T) {
+	var g PauseGate
+	done := make(chan struct{})
+	// not paused, does not block
+	g.Wait(done)
+
+	g.Pause()
+	g.Pause()
+	waited := make(chan struct{})
+	go func() {
+		g.Wait(done)
+		close(waited)
+	}()
+	select {
+	case <-waited:
+		t.Fatal("Wait returned while paused")
+	case <-time.After(10 * time.Millisecond):
+	}
+	g.Resume()
+	<-waited
+
+	// closing done unblocks a paused gate
+	g.Pause()
+	close(done)
+	g.Wait(done)
+}
+
+func TestDurationUnmarshalTOML(t *testing.T) {
+	for raw, expected := range map[string]time.Duration{
+		`"1m30s"`: 90 * time.Second,
+		`'10s'`:   10 * time.Second,
+		`5`:       5 * time.Second,
+		`1.0`:     time.Second,
+	} {
+		var d Duration
+		assert.NoError(t, d.UnmarshalTOML([]byte(raw)), raw)
+		assert.Equal(t, expected, d.Duration, raw)
+	}
+
+	for _, raw := range []string{`"10x"`, `""`, `true`} {
+		var d Duration
+		assert.Error(t, d.UnmarshalTOML([]byte(raw)), raw)
+	}
+}
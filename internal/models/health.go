@@ -0,0 +1,102 @@
+package internal_models
+
+import (
+	"sync"
+	"time"
+)
+
+// InputHealth is a snapshot of a running input's state.
+type InputHealth struct {
+	Name  string `json:"name"`
+	Alias string `json:"alias,omitempty"`
+	// Gathers is the number of gathers since the input was started.
+	Gathers int64 `json:"gathers"`
+	// Errors is the number of failed gathers since the input was started.
+	Errors int64 `json:"errors"`
+	// ConsecutiveFailures is the number of failed gathers since the last
+	// successful one.
+	ConsecutiveFailures int64     `json:"consecutive_failures"`
+	LastGather          time.Time `json:"last_gather"`
+	LastGatherDuration  int64     `json:"last_gather_duration_ns"`
+	LastError           string    `json:"last_error,omitempty"`
+	// Paused is true while the input is paused by backpressure.
+	Paused bool `json:"paused"`
+	// GatherTime is the distribution of the time taken by gathers.
+	GatherTime Histogram `json:"gather_time"`
+	// CPUTime and Allocated are the CPU time and bytes allocated attributed
+	// to the input, while plugin_usage is enabled.
+	CPUTime   int64 `json:"cpu_time_ns"`
+	Allocated int64 `json:"allocated_bytes"`
+	// Limits are the enforcements of the limits on the tags and fields of
+	// the input's metrics, nil if the input has none.
+	Limits *LimitCounters `json:"limits,omitempty"`
+}
+
+// OutputHealth is a snapshot of a running output's state.
+type OutputHealth struct {
+	Name  string `json:"name"`
+	Alias string `json:"alias,omitempty"`
+	// Writes is the number of batches written since the output was started.
+	Writes int64 `json:"writes"`
+	// Errors is the number of failed writes since the output was started.
+	Errors int64 `json:"errors"`
+	// ConsecutiveFailures is the number of failed writes since the last
+	// successful one.
+	ConsecutiveFailures int64     `json:"consecutive_failures"`
+	LastWrite           time.Time `json:"last_write"`
+	LastWriteDuration   int64     `json:"last_write_duration_ns"`
+	LastError           string    `json:"last_error,omitempty"`
+	// BufferSize is the number of metrics waiting to be written.
+	BufferSize  int `json:"buffer_size"`
+	BufferLimit int `json:"buffer_limit"`
+	// BufferHighWatermark is the largest BufferSize since the output was
+	// started.
+	BufferHighWatermark int `json:"buffer_high_watermark"`
+	// Dropped is the number of metrics dropped because the buffer was full.
+	Dropped int64 `json:"dropped"`
+	// Expired is the number of metrics dropped because they were older than
+	// the output's buffer_max_age.
+	Expired int64 `json:"expired"`
+	// WriteTime is the distribution of the time taken by writes.
+	WriteTime Histogram `json:"write_time"`
+	// SerializeTime is the distribution of the time taken to serialize a
+	// metric, for outputs with a data_format.
+	SerializeTime Histogram `json:"serialize_time"`
+	// CPUTime and Allocated are the CPU time and bytes allocated attributed
+	// to the output, while plugin_usage is enabled.
+	CPUTime   int64 `json:"cpu_time_ns"`
+	Allocated int64 `json:"allocated_bytes"`
+}
+
+// running holds the plugins currently run by the agent.
+var running struct {
+	sync.Mutex
+	inputs  []*RunningInput
+	outputs []*RunningOutput
+}
+
+// SetRunning records the plugins currently run by the agent, whose health is
+// then returned by Health.
+func SetRunning(inputs []*RunningInput, outputs []*RunningOutput) {
+	running.Lock()
+	defer running.Unlock()
+	running.inputs = inputs
+	running.outputs = outputs
+}
+
+// Health returns a snapshot of the state of the plugins the agent is
+// currently running.
+func Health() ([]InputHealth, []OutputHealth) {
+	running.Lock()
+	defer running.Unlock()
+
+	inputs := make([]InputHealth, 0, len(running.inputs))
+	for _, ri := range running.inputs {
+		inputs = append(inputs, ri.Health())
+	}
+	outputs := make([]OutputHealth, 0, len(running.outputs))
+	for _, ro := range running.outputs {
+		outputs = append(outputs, ro.Health())
+	}
+	return inputs, outputs
+}
@@ -0,0 +1,47 @@
+package internal_models
+
+import (
+	"time"
+)
+
+// HistogramBuckets are the upper bounds and labels of the Histogram
+// buckets.
+var HistogramBuckets = [...]struct {
+	Label string
+	Bound time.Duration
+}{
+	{"1us", time.Microsecond},
+	{"10us", 10 * time.Microsecond},
+	{"100us", 100 * time.Microsecond},
+	{"1ms", time.Millisecond},
+	{"10ms", 10 * time.Millisecond},
+	{"100ms", 100 * time.Millisecond},
+	{"1s", time.Second},
+	{"10s", 10 * time.Second},
+	{"1m", time.Minute},
+}
+
+// Histogram is the distribution of a duration, such as the time an input
+// takes to gather. Like the other counters, it is never reset.
+type Histogram struct {
+	// Count is the number of durations observed.
+	Count int64 `json:"count"`
+	// Sum is the sum of the durations observed, in nanoseconds.
+	Sum int64 `json:"sum_ns"`
+	// Buckets are the number of durations less than or equal to each of the
+	// HistogramBuckets. Durations longer than all of them are only counted
+	// in Count.
+	Buckets [len(HistogramBuckets)]int64 `json:"buckets"`
+}
+
+// Observe adds a duration to the histogram.
+func (h *Histogram) Observe(d time.Duration) {
+	h.Count++
+	h.Sum += d.Nanoseconds()
+	for i := len(HistogramBuckets) - 1; i >= 0; i-- {
+		if d > HistogramBuckets[i].Bound {
+			break
+		}
+		h.Buckets[i]++
+	}
+}
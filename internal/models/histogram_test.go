@@ -0,0 +1,21 @@
+package internal_models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHistogramObserve(t *testing.T) {
+	var h Histogram
+	h.Observe(500 * time.Nanosecond)
+	h.Observe(50 * time.Millisecond)
+	h.Observe(time.Hour)
+
+	assert.Equal(t, int64(3), h.Count)
+	assert.Equal(t, int64(500+50*time.Millisecond+time.Hour), h.Sum)
+	// buckets are cumulative, and the hour is past the last one
+	assert.Equal(t,
+		[len(HistogramBuckets)]int64{1, 1, 1, 1, 1, 2, 2, 2, 2}, h.Buckets)
+}
@@ -0,0 +1,141 @@
+package internal_models
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+	"unicode/utf8"
+)
+
+// Policies for metrics that exceed their input's limits.
+const (
+	// LimitTruncate removes the tags and fields over the limits, and
+	// truncates the tag values too long, the default.
+	LimitTruncate = "truncate"
+	// LimitDrop drops the metric.
+	LimitDrop = "drop"
+)
+
+// Limits cap the tags and fields of an input's metrics. They keep an input
+// from exploding the series cardinality of the databases it writes to. A zero
+// limit is no limit.
+type Limits struct {
+	MaxTags           int
+	MaxFields         int
+	MaxTagValueLength int
+	// Policy is what happens to a metric over the limits: LimitTruncate (if
+	// empty) or LimitDrop.
+	Policy string
+
+	tagsDropped        int64
+	fieldsDropped      int64
+	tagValuesTruncated int64
+	metricsDropped     int64
+}
+
+// LimitCounters count how often an input's limits were enforced.
+type LimitCounters struct {
+	// TagsDropped and FieldsDropped are the tags and fields removed from
+	// metrics over MaxTags and MaxFields.
+	TagsDropped   int64 `json:"tags_dropped"`
+	FieldsDropped int64 `json:"fields_dropped"`
+	// TagValuesTruncated are the tag values longer than MaxTagValueLength
+	// truncated.
+	TagValuesTruncated int64 `json:"tag_values_truncated"`
+	// MetricsDropped are the metrics over the limits dropped.
+	MetricsDropped int64 `json:"metrics_dropped"`
+}
+
+// CheckPolicy returns an error if the policy is not a limit policy.
+func CheckPolicy(policy string) error {
+	switch policy {
+	case "", LimitTruncate, LimitDrop:
+		return nil
+	}
+	return fmt.Errorf("invalid limit policy %q, must be %q or %q", policy,
+		LimitTruncate, LimitDrop)
+}
+
+// Enabled returns true if any limit is set.
+func (l *Limits) Enabled() bool {
+	return l.MaxTags > 0 || l.MaxFields > 0 || l.MaxTagValueLength > 0
+}
+
+// Apply enforces the limits on the tags and fields of a metric. It returns
+// false if the metric must be dropped. The tags and fields kept are the first
+// ones in key order, so that an input's series stay the same from one gather
+// to the next.
+func (l *Limits) Apply(
+	tags map[string]string,
+	fields map[string]interface{},
+) bool {
+	if !l.Enabled() {
+		return true
+	}
+
+	var longValues []string
+	if l.MaxTagValueLength > 0 {
+		for k, v := range tags {
+			if len(v) > l.MaxTagValueLength {
+				longValues = append(longValues, k)
+			}
+		}
+	}
+	extraTags := l.MaxTags > 0 && len(tags) > l.MaxTags
+	extraFields := l.MaxFields > 0 && len(fields) > l.MaxFields
+	if len(longValues) == 0 && !extraTags && !extraFields {
+		return true
+	}
+
+	if l.Policy == LimitDrop {
+		atomic.AddInt64(&l.metricsDropped, 1)
+		return false
+	}
+
+	for _, k := range longValues {
+		tags[k] = truncate(tags[k], l.MaxTagValueLength)
+	}
+	atomic.AddInt64(&l.tagValuesTruncated, int64(len(longValues)))
+	if extraTags {
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys[l.MaxTags:] {
+			delete(tags, k)
+		}
+		atomic.AddInt64(&l.tagsDropped, int64(len(keys)-l.MaxTags))
+	}
+	if extraFields {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys[l.MaxFields:] {
+			delete(fields, k)
+		}
+		atomic.AddInt64(&l.fieldsDropped, int64(len(keys)-l.MaxFields))
+	}
+	return true
+}
+
+// Counters returns how often the limits were enforced.
+func (l *Limits) Counters() LimitCounters {
+	return LimitCounters{
+		TagsDropped:        atomic.LoadInt64(&l.tagsDropped),
+		FieldsDropped:      atomic.LoadInt64(&l.fieldsDropped),
+		TagValuesTruncated: atomic.LoadInt64(&l.tagValuesTruncated),
+		MetricsDropped:     atomic.LoadInt64(&l.metricsDropped),
+	}
+}
+
+// truncate truncates a string to at most n bytes, without splitting a
+// character.
+func truncate(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
@@ -0,0 +1,49 @@
+package internal_models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitsTruncate(t *testing.T) {
+	l := &Limits{MaxTags: 2, MaxFields: 1, MaxTagValueLength: 3}
+	tags := map[string]string{"c": "three", "a": "one", "b": "two"}
+	fields := map[string]interface{}{"y": 2, "x": 1}
+
+	assert.True(t, l.Apply(tags, fields))
+	assert.Equal(t, map[string]string{"a": "one", "b": "two"}, tags)
+	assert.Equal(t, map[string]interface{}{"x": 1}, fields)
+
+	tags = map[string]string{"a": "aañ"}
+	assert.True(t, l.Apply(tags, map[string]interface{}{"x": 1}))
+	assert.Equal(t, map[string]string{"a": "aa"}, tags)
+
+	assert.Equal(t, LimitCounters{
+		TagsDropped:        1,
+		FieldsDropped:      1,
+		TagValuesTruncated: 2,
+	}, l.Counters())
+}
+
+func TestLimitsDrop(t *testing.T) {
+	l := &Limits{MaxTags: 1, Policy: LimitDrop}
+	tags := map[string]string{"a": "one", "b": "two"}
+	assert.False(t, l.Apply(tags, map[string]interface{}{"x": 1}))
+	assert.Len(t, tags, 2)
+	assert.True(t, l.Apply(map[string]string{"a": "one"}, nil))
+	assert.Equal(t, LimitCounters{MetricsDropped: 1}, l.Counters())
+}
+
+func TestLimitsDisabled(t *testing.T) {
+	l := &Limits{}
+	assert.False(t, l.Enabled())
+	assert.True(t, l.Apply(map[string]string{"a": "one", "b": "two"}, nil))
+	assert.Equal(t, LimitCounters{}, l.Counters())
+}
+
+func TestCheckPolicy(t *testing.T) {
+	assert.NoError(t, CheckPolicy(""))
+	assert.NoError(t, CheckPolicy(LimitDrop))
+	assert.Error(t, CheckPolicy("discard"))
+}
@@ -0,0 +1,48 @@
+package internal_models
+
+import (
+	"fmt"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/buffer"
+)
+
+// priorities are the names of the metric priority classes.
+var priorities = map[string]int{
+	"low":    buffer.PriorityLow,
+	"normal": buffer.PriorityNormal,
+	"high":   buffer.PriorityHigh,
+}
+
+// ParsePriority returns the priority class of the given name: "low",
+// "normal" or "high".
+func ParsePriority(name string) (int, error) {
+	p, ok := priorities[name]
+	if !ok {
+		return 0, fmt.Errorf("invalid priority %q, must be one of low, "+
+			"normal or high", name)
+	}
+	return p, nil
+}
+
+// prioritizedMetric is a metric of a priority class other than normal.
+type prioritizedMetric struct {
+	telegraf.Metric
+	priority int
+}
+
+func (pm *prioritizedMetric) Priority() int {
+	return pm.priority
+}
+
+// WithPriority returns the metric, in the given priority class. The output
+// buffers drop the metrics of the lowest priority class first.
+func WithPriority(metric telegraf.Metric, priority int) telegraf.Metric {
+	if pm, ok := metric.(*prioritizedMetric); ok {
+		metric = pm.Metric
+	}
+	if priority == buffer.PriorityNormal {
+		return metric
+	}
+	return &prioritizedMetric{Metric: metric, priority: priority}
+}
@@ -0,0 +1,54 @@
+package internal_models
+
+import (
+	"testing"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/buffer"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePriority(t *testing.T) {
+	p, err := ParsePriority("high")
+	require.NoError(t, err)
+	assert.Equal(t, buffer.PriorityHigh, p)
+
+	_, err = ParsePriority("urgent")
+	assert.Error(t, err)
+}
+
+// Verify that the priority of a metric survives tracing and tag filtering,
+// and that the output drops low priority metrics first.
+func TestRunningOutputPriority(t *testing.T) {
+	SetTracer(&Tracer{SampleRate: 1})
+	defer SetTracer(nil)
+	_, restore := captureLog()
+	defer restore()
+
+	conf := &OutputConfig{
+		Filter: Filter{TagExclude: []string{"tag1"}},
+	}
+	require.NoError(t, conf.Filter.CompileFilter())
+	m := &mockOutput{failWrite: true}
+	ro := NewRunningOutput("test", m, conf, 1, 2)
+	ro.Quiet = true
+
+	var dropped []string
+	ro.DeadLetter = func(metric telegraf.Metric, reason string) {
+		dropped = append(dropped, metric.Name())
+	}
+
+	add := func(name string, priority int) {
+		metric := WithPriority(testutil.TestMetric(1, name), priority)
+		ro.AddMetric(StartTrace("test", metric))
+	}
+	add("high", buffer.PriorityHigh)
+	add("low", buffer.PriorityLow)
+	add("normal", buffer.PriorityNormal)
+	add("debug", buffer.PriorityLow)
+
+	assert.Equal(t, []string{"low", "debug"}, dropped)
+}
@@ -0,0 +1,124 @@
+package internal_models
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gobwas/glob"
+
+	"github.com/influxdata/telegraf"
+)
+
+// RouteTag is the tag set by the route option of an input.
+const RouteTag = "route"
+
+// RouteNameKey is the key of route predicates on the measurement name.
+const RouteNameKey = "_name"
+
+// Route selects metrics by measurement name and tags. It is a list of
+// expressions, and a metric matches the route if it matches any of them.
+//
+// An expression is a list of predicates separated by spaces, all of which
+// must hold. A predicate is either "key=pattern" or "key!=pattern", where key
+// is a tag, or _name for the measurement name, and pattern is a glob. A metric
+// without the tag never matches "key=pattern", and always matches
+// "key!=pattern".
+type Route struct {
+	Exprs []string
+
+	exprs [][]routePredicate
+}
+
+type routePredicate struct {
+	key    string
+	negate bool
+	value  glob.Glob
+}
+
+// NewRoute compiles the given route expressions.
+func NewRoute(exprs []string) (*Route, error) {
+	r := &Route{Exprs: exprs}
+	for _, expr := range exprs {
+		var preds []routePredicate
+		for _, term := range strings.Fields(expr) {
+			pred, err := parseRoutePredicate(term)
+			if err != nil {
+				return nil, fmt.Errorf("route expression %q: %s", expr, err)
+			}
+			preds = append(preds, pred)
+		}
+		if len(preds) == 0 {
+			return nil, fmt.Errorf("route expression %q is empty", expr)
+		}
+		r.exprs = append(r.exprs, preds)
+	}
+	return r, nil
+}
+
+func parseRoutePredicate(term string) (routePredicate, error) {
+	var pred routePredicate
+	i := strings.Index(term, "=")
+	if i <= 0 {
+		return pred, fmt.Errorf("invalid predicate %q, expected key=pattern "+
+			"or key!=pattern", term)
+	}
+	key, pattern := term[:i], term[i+1:]
+	if strings.HasSuffix(key, "!") {
+		key, pred.negate = key[:len(key)-1], true
+	}
+	if key == "" || pattern == "" {
+		return pred, fmt.Errorf("invalid predicate %q, expected key=pattern "+
+			"or key!=pattern", term)
+	}
+
+	var err error
+	pred.key = key
+	if pred.value, err = glob.Compile(pattern); err != nil {
+		return pred, fmt.Errorf("invalid pattern in %q, %s", term, err)
+	}
+	return pred, nil
+}
+
+// Match returns true if the metric matches any expression of the route.
+func (r *Route) Match(metric telegraf.Metric) bool {
+	if r == nil {
+		return false
+	}
+	name := metric.Name()
+	tags := metric.Tags()
+	for _, preds := range r.exprs {
+		if matchPredicates(preds, name, tags) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPredicates(
+	preds []routePredicate,
+	name string,
+	tags map[string]string,
+) bool {
+	for _, pred := range preds {
+		var value string
+		var ok bool
+		if pred.key == RouteNameKey {
+			value, ok = name, true
+		} else {
+			value, ok = tags[pred.key]
+		}
+		if (ok && pred.value.Match(value)) == pred.negate {
+			return false
+		}
+	}
+	return true
+}
+
+// OutputRouter is an output that routes the metrics written to it to child
+// outputs, configured by the [[outputs.<name>.route]] tables of the output.
+type OutputRouter interface {
+	// AddRoute adds a child output, that receives the metrics that match the
+	// route, and, if isDefault, those that match no route of the router.
+	// Without either, the child receives every metric.
+	AddRoute(route *Route, isDefault bool, output *RunningOutput)
+}
@@ -0,0 +1,52 @@
+package internal_models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoute_Match(t *testing.T) {
+	r, err := NewRoute([]string{
+		"route=billing",
+		"_name=cpu* host!=test*",
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		tags  map[string]string
+		match bool
+	}{
+		{"mem", map[string]string{"route": "billing"}, true},
+		{"mem", map[string]string{"route": "metrics"}, false},
+		{"cpu", map[string]string{"host": "prod"}, true},
+		{"cpu_total", map[string]string{}, true},
+		{"cpu", map[string]string{"host": "test1"}, false},
+		{"mem", map[string]string{"host": "prod"}, false},
+	}
+	for _, test := range tests {
+		m, err := telegraf.NewMetric(test.name, test.tags,
+			map[string]interface{}{"value": 1}, time.Now())
+		require.NoError(t, err)
+		assert.Equal(t, test.match, r.Match(m), "%s %v", test.name, test.tags)
+	}
+
+	// a nil route matches nothing
+	var nilRoute *Route
+	m, _ := telegraf.NewMetric("cpu", nil,
+		map[string]interface{}{"value": 1}, time.Now())
+	assert.False(t, nilRoute.Match(m))
+}
+
+func TestRoute_Invalid(t *testing.T) {
+	for _, expr := range []string{"", "route", "=billing", "route=", "!=a",
+		"route=[a"} {
+		_, err := NewRoute([]string{expr})
+		assert.Error(t, err, expr)
+	}
+}
@@ -1,24 +1,147 @@
 package internal_models
 
 import (
+	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/cron"
+	"github.com/influxdata/telegraf/internal/usage"
 )
 
 type RunningInput struct {
 	Name   string
 	Input  telegraf.Input
 	Config *InputConfig
+	// Usage is the CPU time and memory attributed to the input.
+	Usage usage.Account
+
+	mu     sync.Mutex
+	health InputHealth
+}
+
+// RecordGather records the outcome of a gather that started at start and took
+// the given time.
+func (ri *RunningInput) RecordGather(
+	start time.Time,
+	elapsed time.Duration,
+	err error,
+) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	ri.health.Gathers++
+	ri.health.LastGather = start
+	ri.health.LastGatherDuration = elapsed.Nanoseconds()
+	ri.health.GatherTime.Observe(elapsed)
+	if err != nil {
+		ri.health.Errors++
+		ri.health.ConsecutiveFailures++
+		ri.health.LastError = err.Error()
+	} else {
+		ri.health.ConsecutiveFailures = 0
+	}
+}
+
+// SetPaused records whether the input is paused by backpressure.
+func (ri *RunningInput) SetPaused(paused bool) {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+	ri.health.Paused = paused
+}
+
+// LogName returns the name of the input in logs: its alias if it has one, its
+// plugin name otherwise.
+func (ri *RunningInput) LogName() string {
+	if ri.Config != nil && ri.Config.Alias != "" {
+		return ri.Config.Alias
+	}
+	return ri.Name
+}
+
+// Health returns a snapshot of the input's state.
+func (ri *RunningInput) Health() InputHealth {
+	ri.mu.Lock()
+	defer ri.mu.Unlock()
+
+	h := ri.health
+	h.Name = ri.Name
+	if ri.Config != nil {
+		h.Alias = ri.Config.Alias
+		if ri.Config.Limits.Enabled() {
+			counters := ri.Config.Limits.Counters()
+			h.Limits = &counters
+		}
+	}
+	h.CPUTime = ri.Usage.CPUTime().Nanoseconds()
+	h.Allocated = ri.Usage.Allocated()
+	return h
 }
 
+// Behaviors of an input whose StartAfter are not ready in time.
+const (
+	// StartAnyway starts the input anyway, the default.
+	StartAnyway = "start"
+	// StartSkip does not start the input.
+	StartSkip = "skip"
+	// StartExit stops telegraf.
+	StartExit = "exit"
+)
+
 // InputConfig containing a name, interval, and filter
 type InputConfig struct {
-	Name              string
+	Name string
+	// Alias identifies the input among those of the same plugin. An input
+	// with the same alias as an earlier one replaces it, for example in an
+	// included file.
+	// The second and later unaliased inputs of a plugin are given the alias
+	// "<name>#<n>" when loaded.
+	Alias             string
 	NameOverride      string
 	MeasurementPrefix string
 	MeasurementSuffix string
 	Tags              map[string]string
 	Filter            Filter
 	Interval          time.Duration
+
+	// Schedule, if set, is the cron schedule the input is gathered on,
+	// instead of every Interval.
+	Schedule *cron.Schedule
+
+	// MaxBackoff is the longest interval the input will wait between
+	// collections after consecutive gather errors. Each failed gather doubles
+	// the wait, starting from Interval. Zero disables backoff.
+	MaxBackoff time.Duration
+
+	// Route, if set, is the RouteTag value set on the input's metrics.
+	Route string
+
+	// Priority is the priority class of the input's metrics. When an output
+	// buffer is full, the metrics of the lowest class are dropped first.
+	Priority int
+
+	// StateKey is the namespace of the input's state in the state store.
+	StateKey string
+
+	// Shard, if set, gathers the input only on the agent of the cluster that
+	// owns it.
+	Shard bool
+
+	// StartAfter are the plugins the input waits for before starting, such
+	// as "outputs.influxdb" or "inputs.redis". An output is ready once it is
+	// connected, an input once its service started or it gathered once.
+	StartAfter []string
+	// StartTimeout is how long the input waits for StartAfter, one minute
+	// if zero.
+	StartTimeout time.Duration
+	// StartFailure is what happens if StartAfter are not ready within
+	// StartTimeout: StartAnyway (if empty), StartSkip or StartExit.
+	StartFailure string
+
+	// Limits are the limits on the tags and fields of the input's metrics.
+	Limits Limits
+
+	// Fingerprint is a hash of the input's configuration table, used to tell
+	// whether the input changed when the configuration is reloaded.
+	Fingerprint string
 }
@@ -1,11 +1,16 @@
 package internal_models
 
 import (
+	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/buffer"
+	"github.com/influxdata/telegraf/internal/otlp"
+	"github.com/influxdata/telegraf/internal/usage"
+	"github.com/influxdata/telegraf/plugins/serializers"
 )
 
 const (
@@ -24,9 +29,24 @@ type RunningOutput struct {
 	Quiet             bool
 	MetricBufferLimit int
 	MetricBatchSize   int
+	// BufferCompressed is true if the metrics of failed writes are kept
+	// compressed, see CompressBuffer.
+	BufferCompressed bool
+
+	// DeadLetter, if set, is called with every metric the output drops and
+	// the reason it was dropped.
+	DeadLetter func(metric telegraf.Metric, reason string)
+
+	// Usage is the CPU time and memory attributed to the output.
+	Usage usage.Account
 
 	metrics     *buffer.Buffer
 	failMetrics *buffer.Buffer
+	// serializer is the serializer set by WrapSerializer, if any
+	serializer *outputSerializer
+
+	mu     sync.Mutex
+	health OutputHealth
 }
 
 func NewRunningOutput(
@@ -54,11 +74,22 @@ func NewRunningOutput(
 	return ro
 }
 
+// CompressBuffer makes the output keep the metrics of failed writes
+// serialized and compressed in memory, a batch at a time, trading CPU for a
+// larger buffer during long outages. It must be called before any metric is
+// added.
+func (ro *RunningOutput) CompressBuffer() {
+	ro.BufferCompressed = true
+	ro.failMetrics = buffer.NewCompressedBuffer(ro.MetricBufferLimit,
+		ro.MetricBatchSize)
+}
+
 // AddMetric adds a metric to the output. This function can also write cached
 // points if FlushBufferWhenFull is true.
 func (ro *RunningOutput) AddMetric(metric telegraf.Metric) {
 	if ro.Config.Filter.IsActive {
 		if !ro.Config.Filter.ShouldMetricPass(metric) {
+			Tracef(metric, "dropped by the filters of output [%s]", ro.LogName())
 			return
 		}
 	}
@@ -73,88 +104,406 @@ func (ro *RunningOutput) AddMetric(metric telegraf.Metric) {
 		name := metric.Name()
 		ro.Config.Filter.FilterTags(tags)
 		// error is not possible if creating from another metric, so ignore.
-		metric, _ = telegraf.NewMetric(name, tags, fields, t)
+		filtered, _ := telegraf.NewMetric(name, tags, fields, t)
+		metric = Retrace(metric, filtered)
+		Tracef(metric, "tags filtered by output [%s]: %s", ro.LogName(),
+			metric.String())
 	}
 
-	ro.metrics.Add(metric)
+	Tracef(metric, "buffered by output [%s]", ro.LogName())
+	ro.dropped(ro.metrics.Add(metric))
+	ro.recordBufferSize()
 	if ro.metrics.Len() == ro.MetricBatchSize {
-		batch := ro.metrics.Batch(ro.MetricBatchSize)
-		err := ro.write(batch)
+		batch := ro.expire(ro.metrics.Batch(ro.MetricBatchSize))
+		err := ro.write(batch, nil, false)
 		if err != nil {
-			ro.failMetrics.Add(batch...)
+			ro.dropped(ro.failMetrics.Add(batch...))
 		}
 	}
 }
 
 // Write writes all cached points to this output.
 func (ro *RunningOutput) Write() error {
+	return ro.WriteSpan(nil)
+}
+
+// WriteSpan writes all cached points to this output, recording the write as
+// a child span of parent, and each batch as a child span of the write.
+func (ro *RunningOutput) WriteSpan(parent *otlp.Span) (err error) {
+	span := parent.Child("write")
+	span.SetAttribute("output", ro.Name)
+	span.SetAttribute("buffered", ro.failMetrics.Len()+ro.metrics.Len())
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
 	if !ro.Quiet {
 		log.Printf("Output [%s] buffer fullness: %d / %d metrics. "+
 			"Total gathered metrics: %d. Total dropped metrics: %d.",
-			ro.Name,
+			ro.LogName(),
 			ro.failMetrics.Len()+ro.metrics.Len(),
 			ro.MetricBufferLimit,
 			ro.metrics.Total(),
 			ro.metrics.Drops()+ro.failMetrics.Drops())
 	}
 
-	var err error
-	if !ro.failMetrics.IsEmpty() {
-		bufLen := ro.failMetrics.Len()
-		// how many batches of failed writes we need to write.
-		nBatches := bufLen/ro.MetricBatchSize + 1
-		batchSize := ro.MetricBatchSize
-
-		for i := 0; i < nBatches; i++ {
-			// If it's the last batch, only grab the metrics that have not had
-			// a write attempt already (this is primarily to preserve order).
-			if i == nBatches-1 {
-				batchSize = bufLen % ro.MetricBatchSize
-			}
-			batch := ro.failMetrics.Batch(batchSize)
-			// If we've already failed previous writes, don't bother trying to
-			// write to this output again. We are not exiting the loop just so
-			// that we can rotate the metrics to preserve order.
-			if err == nil {
-				err = ro.write(batch)
-			}
-			if err != nil {
-				ro.failMetrics.Add(batch...)
-			}
+	// Write the metrics of failed writes first, oldest first. If a batch
+	// fails, it is put back in front of the others, and the output is not
+	// tried again until the next write.
+	for !ro.failMetrics.IsEmpty() {
+		batch := ro.expire(ro.failMetrics.Batch(ro.MetricBatchSize))
+		if err = ro.write(batch, span, true); err != nil {
+			ro.failMetrics.Requeue(batch...)
+			break
 		}
 	}
 
-	batch := ro.metrics.Batch(ro.MetricBatchSize)
-	// see comment above about not trying to write to an already failed output.
-	// if ro.failMetrics is empty then err will always be nil at this point.
+	batch := ro.expire(ro.metrics.Batch(ro.MetricBatchSize))
+	// don't try to write to an already failed output.
 	if err == nil {
-		err = ro.write(batch)
+		err = ro.write(batch, span, false)
 	}
 	if err != nil {
-		ro.failMetrics.Add(batch...)
+		ro.dropped(ro.failMetrics.Add(batch...))
 		return err
 	}
 	return nil
 }
 
-func (ro *RunningOutput) write(metrics []telegraf.Metric) error {
+// recordBufferSize records the current size of the buffers in the output's
+// high watermark.
+func (ro *RunningOutput) recordBufferSize() {
+	size := ro.metrics.Len() + ro.failMetrics.Len()
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+	if size > ro.health.BufferHighWatermark {
+		ro.health.BufferHighWatermark = size
+	}
+}
+
+// dropped traces the metrics dropped from a full buffer and sends them to
+// DeadLetter.
+func (ro *RunningOutput) dropped(metrics []telegraf.Metric) {
 	if len(metrics) == 0 {
-		return nil
+		return
+	}
+
+	reason := fmt.Sprintf("metric buffer limit of %d exceeded",
+		ro.MetricBufferLimit)
+	ro.mu.Lock()
+	ro.health.Dropped += int64(len(metrics))
+	if ro.health.ConsecutiveFailures > 0 {
+		reason += ", last write error: " + ro.health.LastError
+	}
+	ro.mu.Unlock()
+
+	for _, metric := range metrics {
+		Tracef(metric, "dropped by output [%s]: %s", ro.LogName(), reason)
+		if ro.DeadLetter != nil {
+			ro.DeadLetter(metric, reason)
+		}
+	}
+}
+
+// expire drops the metrics of the batch older than BufferMaxAge, tracing them
+// and sending them to DeadLetter, and returns the others.
+func (ro *RunningOutput) expire(batch []telegraf.Metric) []telegraf.Metric {
+	maxAge := ro.Config.BufferMaxAge
+	if maxAge <= 0 {
+		return batch
 	}
+
+	oldest := time.Now().Add(-maxAge)
+	kept := batch[:0]
+	var expired []telegraf.Metric
+	for _, metric := range batch {
+		if metric.Time().Before(oldest) {
+			expired = append(expired, metric)
+		} else {
+			kept = append(kept, metric)
+		}
+	}
+	if len(expired) == 0 {
+		return kept
+	}
+
+	ro.mu.Lock()
+	ro.health.Expired += int64(len(expired))
+	ro.mu.Unlock()
+	reason := fmt.Sprintf("older than buffer_max_age of %s", maxAge)
+	for _, metric := range expired {
+		Tracef(metric, "dropped by output [%s]: %s", ro.LogName(), reason)
+		if ro.DeadLetter != nil {
+			ro.DeadLetter(metric, reason)
+		}
+	}
+	return kept
+}
+
+// WrapSerializer returns a serializer that records the time the given
+// serializer takes in the output's health. The metrics it fails to serialize
+// are sent to DeadLetter and skipped, rather than failing the whole write.
+// Without a DeadLetter the error is returned as is.
+//
+// With SerializerWorkers, every batch is serialized concurrently before it is
+// written, and the output gets the results when it serializes the metrics.
+// The given serializer must then be safe for concurrent use.
+//
+// The serializer returned is a serializers.BatchSerializer if the given one
+// is.
+func (ro *RunningOutput) WrapSerializer(
+	serializer serializers.Serializer,
+) serializers.Serializer {
+	ro.serializer = &outputSerializer{ro: ro, serializer: serializer}
+	if batch, ok := serializer.(serializers.BatchSerializer); ok {
+		return &outputBatchSerializer{ro.serializer, batch}
+	}
+	return ro.serializer
+}
+
+type outputSerializer struct {
+	ro         *RunningOutput
+	serializer serializers.Serializer
+
+	mu sync.Mutex
+	// serialized are the metrics of the batch being written, serialized
+	// ahead of the output by prepare
+	serialized map[telegraf.Metric]serialized
+}
+
+type serialized struct {
+	out []string
+	err error
+}
+
+// prepare serializes the metrics of a batch with the given number of
+// goroutines, until the batch is written and reset is called.
+func (s *outputSerializer) prepare(metrics []telegraf.Metric, workers int) {
+	results := make([]serialized, len(metrics))
+	var wg sync.WaitGroup
+	next := make(chan int)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				results[i].out, results[i].err = s.serialize(metrics[i])
+			}
+		}()
+	}
+	for i := range metrics {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serialized = make(map[telegraf.Metric]serialized, len(metrics))
+	for i, metric := range metrics {
+		s.serialized[metric] = results[i]
+	}
+}
+
+// reset forgets the metrics serialized by prepare.
+func (s *outputSerializer) reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.serialized = nil
+}
+
+// serialize serializes a metric, recording the time it takes.
+func (s *outputSerializer) serialize(
+	metric telegraf.Metric,
+) ([]string, error) {
+	start := time.Now()
+	out, err := s.serializer.Serialize(metric)
+	elapsed := time.Since(start)
+	s.ro.mu.Lock()
+	s.ro.health.SerializeTime.Observe(elapsed)
+	s.ro.mu.Unlock()
+	return out, err
+}
+
+func (s *outputSerializer) Serialize(
+	metric telegraf.Metric,
+) ([]string, error) {
+	s.mu.Lock()
+	result, ok := s.serialized[metric]
+	if ok {
+		delete(s.serialized, metric)
+	}
+	s.mu.Unlock()
+
+	out, err := result.out, result.err
+	if !ok {
+		out, err = s.serialize(metric)
+	}
+
+	if err != nil {
+		Tracef(metric, "could not be serialized by output [%s]: %s",
+			s.ro.LogName(), err)
+	}
+	if err != nil && s.ro.DeadLetter != nil {
+		s.ro.DeadLetter(metric, "serialization failed: "+err.Error())
+		return []string{}, nil
+	}
+	return out, err
+}
+
+type outputBatchSerializer struct {
+	*outputSerializer
+	batch serializers.BatchSerializer
+}
+
+// SerializeBatch serializes a batch of metrics, recording the time it takes.
+// A batch that fails to be serialized is sent to DeadLetter and skipped.
+func (s *outputBatchSerializer) SerializeBatch(
+	metrics []telegraf.Metric,
+) ([]byte, error) {
 	start := time.Now()
-	err := ro.Output.Write(metrics)
+	out, err := s.batch.SerializeBatch(metrics)
 	elapsed := time.Since(start)
+	s.ro.mu.Lock()
+	s.ro.health.SerializeTime.Observe(elapsed)
+	s.ro.mu.Unlock()
+
+	if err != nil && s.ro.DeadLetter != nil {
+		for _, metric := range metrics {
+			Tracef(metric, "could not be serialized by output [%s]: %s",
+				s.ro.LogName(), err)
+			s.ro.DeadLetter(metric, "serialization failed: "+err.Error())
+		}
+		return nil, nil
+	}
+	return out, err
+}
+
+// write writes a batch of metrics, recording it as a child span of parent.
+// retry is true if the batch failed to be written before.
+func (ro *RunningOutput) write(
+	metrics []telegraf.Metric,
+	parent *otlp.Span,
+	retry bool,
+) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	span := parent.Child("write_batch")
+	span.SetAttribute("metrics", len(metrics))
+	span.SetAttribute("retry", retry)
+	var start time.Time
+	var elapsed time.Duration
+	var err error
+	ro.Usage.Do(func() {
+		if workers := ro.Config.SerializerWorkers; ro.serializer != nil &&
+			workers > 1 {
+			ro.serializer.prepare(metrics, workers)
+			defer ro.serializer.reset()
+		}
+		start = time.Now()
+		err = ro.Output.Write(metrics)
+		elapsed = time.Since(start)
+	})
+	span.SetError(err)
+	span.End()
+	ro.recordWrite(start, elapsed, err)
+	for _, metric := range metrics {
+		if err != nil {
+			Tracef(metric, "write to output [%s] failed, will retry: %s",
+				ro.LogName(), err)
+		} else {
+			Tracef(metric, "written by output [%s]", ro.LogName())
+		}
+	}
 	if err == nil {
 		if !ro.Quiet {
 			log.Printf("Output [%s] wrote batch of %d metrics in %s\n",
-				ro.Name, len(metrics), elapsed)
+				ro.LogName(), len(metrics), elapsed)
 		}
 	}
 	return err
 }
 
+func (ro *RunningOutput) recordWrite(
+	start time.Time,
+	elapsed time.Duration,
+	err error,
+) {
+	ro.mu.Lock()
+	defer ro.mu.Unlock()
+
+	ro.health.Writes++
+	ro.health.LastWrite = start
+	ro.health.LastWriteDuration = elapsed.Nanoseconds()
+	ro.health.WriteTime.Observe(elapsed)
+	if err != nil {
+		ro.health.Errors++
+		ro.health.ConsecutiveFailures++
+		ro.health.LastError = err.Error()
+	} else {
+		ro.health.ConsecutiveFailures = 0
+	}
+}
+
+// LogName returns the name of the output in logs: its alias if it has one,
+// its plugin name otherwise.
+func (ro *RunningOutput) LogName() string {
+	if ro.Config.Alias != "" {
+		return ro.Config.Alias
+	}
+	return ro.Name
+}
+
+// Health returns a snapshot of the output's state.
+func (ro *RunningOutput) Health() OutputHealth {
+	ro.mu.Lock()
+	h := ro.health
+	ro.mu.Unlock()
+
+	h.Name = ro.Name
+	h.Alias = ro.Config.Alias
+	h.BufferSize = ro.metrics.Len() + ro.failMetrics.Len()
+	h.BufferLimit = ro.MetricBufferLimit
+	h.CPUTime = ro.Usage.CPUTime().Nanoseconds()
+	h.Allocated = ro.Usage.Allocated()
+	return h
+}
+
 // OutputConfig containing name and filter
 type OutputConfig struct {
 	Name   string
 	Filter Filter
+
+	// Alias identifies the output among those of the same plugin. An output
+	// with the same alias as an earlier one replaces it, for example in an
+	// included file.
+	// The second and later unaliased outputs of a plugin are given the alias
+	// "<name>#<n>" when loaded.
+	Alias string
+
+	// DeadLetter outputs receive the metrics dropped by the other outputs,
+	// instead of the gathered metrics.
+	DeadLetter bool
+
+	// BufferMaxAge, if set, drops the buffered metrics older than it instead
+	// of writing them.
+	BufferMaxAge time.Duration
+
+	// SerializerWorkers is the number of goroutines serializing a batch
+	// before it is written, for outputs with a data_format. Below 2, the
+	// output serializes the metrics itself as it writes them.
+	SerializerWorkers int
+
+	// Route, if set, selects the metrics routed to the output. Outputs
+	// without a route receive every metric.
+	Route *Route
+	// RouteDefault outputs also receive the metrics that do not match the
+	// route of any output.
+	RouteDefault bool
+
+	// Fingerprint is a hash of the output's configuration table, used to tell
+	// whether the output changed when the configuration is reloaded.
+	Fingerprint string
 }
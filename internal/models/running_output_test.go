@@ -1,11 +1,18 @@
 package internal_models
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/otlp"
+	"github.com/influxdata/telegraf/plugins/serializers"
 	"github.com/influxdata/telegraf/testutil"
 
 	"github.com/stretchr/testify/assert"
@@ -491,6 +498,329 @@ func TestRunningOutputWriteFailOrder3(t *testing.T) {
 	assert.Equal(t, expected, m.Metrics())
 }
 
+// Verify that the order of points is preserved by a compressed buffer during
+// many write failures.
+func TestRunningOutputCompressedWriteFailOrder(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{
+			IsActive: false,
+		},
+	}
+
+	m := &mockOutput{}
+	m.failWrite = true
+	ro := NewRunningOutput("test", m, conf, 5, 100)
+	ro.CompressBuffer()
+
+	var expected []string
+	for i := 0; i < 4; i++ {
+		for _, metric := range append(first5, next5...) {
+			ro.AddMetric(metric)
+			expected = append(expected, metric.String())
+		}
+		require.Error(t, ro.Write())
+		assert.Len(t, m.Metrics(), 0)
+	}
+	assert.Equal(t, 40, ro.Health().BufferSize)
+
+	m.failWrite = false
+	require.NoError(t, ro.Write())
+	var written []string
+	for _, metric := range m.Metrics() {
+		written = append(written, metric.String())
+	}
+	assert.Equal(t, expected, written)
+	assert.Equal(t, 0, ro.Health().BufferSize)
+}
+
+// Verify that writes are recorded as spans, each batch as a child of the
+// write.
+func TestRunningOutputWriteSpans(t *testing.T) {
+	var spans []map[string]interface{}
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			var req struct {
+				ResourceSpans []struct {
+					ScopeSpans []struct {
+						Spans []map[string]interface{}
+					}
+				}
+			}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			spans = req.ResourceSpans[0].ScopeSpans[0].Spans
+		}))
+	defer ts.Close()
+
+	conf := &OutputConfig{
+		Filter: Filter{
+			IsActive: false,
+		},
+	}
+	m := &mockOutput{}
+	m.failWrite = true
+	ro := NewRunningOutput("test", m, conf, 5, 100)
+	ro.Quiet = true
+	for _, metric := range first5 {
+		ro.AddMetric(metric)
+	}
+	for _, metric := range next5[:3] {
+		ro.AddMetric(metric)
+	}
+	require.Error(t, ro.Write())
+
+	// the failed batch of 5 is retried, then the 3 others are written
+	m.failWrite = false
+	e := otlp.NewExporter(ts.URL, "telegraf")
+	flush := e.Start("flush")
+	require.NoError(t, ro.WriteSpan(flush))
+	flush.End()
+	require.NoError(t, e.Flush())
+
+	require.Len(t, spans, 4)
+	names := []string{}
+	for _, s := range spans {
+		names = append(names, s["name"].(string))
+	}
+	assert.Equal(t, []string{"write_batch", "write_batch", "write", "flush"},
+		names)
+	assert.Equal(t, spans[2]["spanId"], spans[0]["parentSpanId"])
+	assert.Equal(t, spans[3]["spanId"], spans[2]["parentSpanId"])
+	assert.Contains(t, spans[0]["attributes"], map[string]interface{}{
+		"key": "retry", "value": map[string]interface{}{"boolValue": true}})
+	assert.Contains(t, spans[1]["attributes"], map[string]interface{}{
+		"key": "metrics", "value": map[string]interface{}{"intValue": "3"}})
+	assert.Contains(t, spans[2]["attributes"], map[string]interface{}{
+		"key": "buffered", "value": map[string]interface{}{"intValue": "8"}})
+}
+
+// Verify that buffered metrics older than BufferMaxAge are dropped.
+func TestRunningOutputBufferMaxAge(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{
+			IsActive: false,
+		},
+		BufferMaxAge: time.Hour,
+	}
+	m := &mockOutput{}
+	ro := NewRunningOutput("test", m, conf, 5, 100)
+	ro.Quiet = true
+	var reasons []string
+	ro.DeadLetter = func(metric telegraf.Metric, reason string) {
+		reasons = append(reasons, reason)
+	}
+
+	old, err := telegraf.NewMetric("old", nil,
+		map[string]interface{}{"value": 1}, time.Now().Add(-2*time.Hour))
+	require.NoError(t, err)
+	recent, err := telegraf.NewMetric("recent", nil,
+		map[string]interface{}{"value": 1}, time.Now())
+	require.NoError(t, err)
+
+	ro.AddMetric(old)
+	ro.AddMetric(recent)
+	require.NoError(t, ro.Write())
+	require.Len(t, m.Metrics(), 1)
+	assert.Equal(t, "recent", m.Metrics()[0].Name())
+	assert.Equal(t, int64(1), ro.Health().Expired)
+	assert.Equal(t, int64(0), ro.Health().Dropped)
+	assert.Equal(t, []string{"older than buffer_max_age of 1h0m0s"}, reasons)
+}
+
+// Verify that the health of an output tracks its writes and buffer.
+func TestRunningOutputHealth(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{
+			IsActive: false,
+		},
+	}
+
+	m := &mockOutput{}
+	ro := NewRunningOutput("test", m, conf, 1000, 10000)
+	ro.Quiet = true
+
+	for _, metric := range first5 {
+		ro.AddMetric(metric)
+	}
+	h := ro.Health()
+	assert.Equal(t, "test", h.Name)
+	assert.Equal(t, 5, h.BufferSize)
+	assert.Equal(t, 10000, h.BufferLimit)
+	assert.Equal(t, int64(0), h.Writes)
+
+	m.failWrite = true
+	require.Error(t, ro.Write())
+	require.Error(t, ro.Write())
+	h = ro.Health()
+	assert.Equal(t, int64(2), h.Writes)
+	assert.Equal(t, int64(2), h.Errors)
+	assert.Equal(t, int64(2), h.ConsecutiveFailures)
+	assert.Equal(t, "Failed Write!", h.LastError)
+	assert.Equal(t, 5, h.BufferSize)
+
+	m.failWrite = false
+	require.NoError(t, ro.Write())
+	h = ro.Health()
+	assert.Equal(t, int64(3), h.Writes)
+	assert.Equal(t, int64(2), h.Errors)
+	assert.Equal(t, int64(0), h.ConsecutiveFailures)
+	assert.Equal(t, 0, h.BufferSize)
+	assert.Equal(t, 5, h.BufferHighWatermark)
+	assert.Equal(t, int64(3), h.WriteTime.Count)
+	assert.False(t, h.LastWrite.IsZero())
+}
+
+// Verify that metrics dropped from a full buffer are sent to DeadLetter.
+func TestRunningOutputDeadLetter(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{
+			IsActive: false,
+		},
+	}
+
+	m := &mockOutput{}
+	m.failWrite = true
+	ro := NewRunningOutput("test", m, conf, 5, 5)
+	ro.Quiet = true
+
+	var dropped []telegraf.Metric
+	var reasons []string
+	ro.DeadLetter = func(metric telegraf.Metric, reason string) {
+		dropped = append(dropped, metric)
+		reasons = append(reasons, reason)
+	}
+
+	for _, metric := range first5 {
+		ro.AddMetric(metric)
+	}
+	assert.Len(t, dropped, 0)
+	for _, metric := range next5 {
+		ro.AddMetric(metric)
+	}
+
+	// the first 5 metrics were pushed out of the buffer by the next 5
+	assert.Equal(t, first5, dropped)
+	assert.Equal(t, int64(5), ro.Health().Dropped)
+	assert.Equal(t, "metric buffer limit of 5 exceeded, last write error: "+
+		"Failed Write!", reasons[0])
+}
+
+// Verify that metrics that fail to serialize are sent to DeadLetter and
+// skipped, and that the error is returned without a DeadLetter.
+func TestRunningOutputWrapSerializer(t *testing.T) {
+	ro := NewRunningOutput("test", &mockOutput{}, &OutputConfig{}, 0, 0)
+	s := ro.WrapSerializer(&mockSerializer{})
+
+	_, err := s.Serialize(first5[0])
+	require.Error(t, err)
+
+	var reason string
+	ro.DeadLetter = func(metric telegraf.Metric, r string) {
+		reason = r
+	}
+	out, err := s.Serialize(first5[0])
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	assert.Equal(t, "serialization failed: cannot serialize", reason)
+}
+
+// Verify that batch serializers stay batch serializers once wrapped, and
+// that the batches that fail to serialize are sent to DeadLetter.
+func TestRunningOutputWrapBatchSerializer(t *testing.T) {
+	ro := NewRunningOutput("test", &mockOutput{}, &OutputConfig{}, 0, 0)
+	_, ok := ro.WrapSerializer(&mockSerializer{}).(serializers.BatchSerializer)
+	assert.False(t, ok)
+
+	wrapped := ro.WrapSerializer(&mockBatchSerializer{})
+	s, ok := wrapped.(serializers.BatchSerializer)
+	require.True(t, ok)
+	_, err := s.SerializeBatch(first5)
+	require.Error(t, err)
+	assert.Equal(t, int64(1), ro.Health().SerializeTime.Count)
+
+	var dropped []telegraf.Metric
+	ro.DeadLetter = func(metric telegraf.Metric, r string) {
+		dropped = append(dropped, metric)
+	}
+	out, err := s.SerializeBatch(first5)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	assert.Equal(t, first5, dropped)
+}
+
+// Verify that with SerializerWorkers a batch is serialized once, ahead of the
+// output, which gets the results in its order.
+func TestRunningOutputSerializerWorkers(t *testing.T) {
+	conf := &OutputConfig{
+		Filter: Filter{
+			IsActive: false,
+		},
+		SerializerWorkers: 4,
+	}
+	m := &serializerOutput{}
+	ro := NewRunningOutput("test", m, conf, 5, 100)
+	ro.Quiet = true
+	counter := &countingSerializer{}
+	m.serializer = ro.WrapSerializer(counter)
+
+	for _, metric := range first5 {
+		ro.AddMetric(metric)
+	}
+	require.NoError(t, ro.Write())
+	var names []string
+	for _, metric := range first5 {
+		names = append(names, metric.Name())
+	}
+	assert.Equal(t, names, m.lines)
+	assert.Equal(t, int64(5), atomic.LoadInt64(&counter.calls))
+	assert.Equal(t, int64(5), ro.Health().SerializeTime.Count)
+	assert.Empty(t, ro.serializer.serialized)
+}
+
+type countingSerializer struct {
+	calls int64
+}
+
+func (s *countingSerializer) Serialize(
+	metric telegraf.Metric,
+) ([]string, error) {
+	atomic.AddInt64(&s.calls, 1)
+	return []string{metric.Name()}, nil
+}
+
+// serializerOutput serializes the metrics it writes.
+type serializerOutput struct {
+	mockOutput
+	serializer serializers.Serializer
+	lines      []string
+}
+
+func (o *serializerOutput) Write(metrics []telegraf.Metric) error {
+	for _, metric := range metrics {
+		out, err := o.serializer.Serialize(metric)
+		if err != nil {
+			return err
+		}
+		o.lines = append(o.lines, out...)
+	}
+	return nil
+}
+
+type mockSerializer struct{}
+
+func (s *mockSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	return nil, fmt.Errorf("cannot serialize")
+}
+
+type mockBatchSerializer struct {
+	mockSerializer
+}
+
+func (s *mockBatchSerializer) SerializeBatch(
+	metrics []telegraf.Metric,
+) ([]byte, error) {
+	return nil, fmt.Errorf("cannot serialize")
+}
+
 type mockOutput struct {
 	sync.Mutex
 
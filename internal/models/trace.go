@@ -0,0 +1,118 @@
+package internal_models
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/buffer"
+)
+
+// Tracer selects the metrics that are traced through the pipeline. Every step
+// a traced metric goes through, from the input that gathered it to the
+// outputs that wrote it, is logged with the id of the metric.
+type Tracer struct {
+	// Filter selects the metrics to trace by name and tags
+	Filter Filter
+	// SampleRate is the fraction of the selected metrics that are traced
+	SampleRate float64
+
+	// lastID is the id of the last traced metric
+	lastID uint64
+}
+
+// tracedMetric is a metric that is being traced.
+type tracedMetric struct {
+	telegraf.Metric
+	id uint64
+}
+
+// Priority returns the priority class of the traced metric.
+func (tm *tracedMetric) Priority() int {
+	return buffer.Priority(tm.Metric)
+}
+
+// Retained keeps the traced metric as it is in compressed buffers, so that
+// it is still traced once written.
+func (tm *tracedMetric) Retained() bool {
+	return true
+}
+
+var tracer struct {
+	sync.RWMutex
+	t *Tracer
+}
+
+// SetTracer sets the tracer used for the metrics gathered from now on. A nil
+// tracer disables tracing.
+func SetTracer(t *Tracer) {
+	tracer.Lock()
+	defer tracer.Unlock()
+	tracer.t = t
+}
+
+func getTracer() *Tracer {
+	tracer.RLock()
+	defer tracer.RUnlock()
+	return tracer.t
+}
+
+func (t *Tracer) selects(name string, tags map[string]string) bool {
+	if !t.Filter.ShouldNamePass(name) || !t.Filter.ShouldTagsPass(tags) {
+		return false
+	}
+	return t.SampleRate >= 1 || rand.Float64() < t.SampleRate
+}
+
+// StartTrace returns the metric gathered by the given input, which is traced
+// from now on if the tracer selects it.
+func StartTrace(input string, metric telegraf.Metric) telegraf.Metric {
+	t := getTracer()
+	if t == nil || !t.selects(metric.Name(), metric.Tags()) {
+		return metric
+	}
+	tm := &tracedMetric{
+		Metric: metric,
+		id:     atomic.AddUint64(&t.lastID, 1),
+	}
+	Tracef(tm, "gathered by input [%s]: %s", input, metric.String())
+	return tm
+}
+
+// TraceDrop logs that an input dropped a metric before creating it, if the
+// tracer selects it.
+func TraceDrop(
+	input string,
+	name string,
+	tags map[string]string,
+	reason string,
+) {
+	t := getTracer()
+	if t == nil || !t.selects(name, tags) {
+		return
+	}
+	log.Printf("TRACE input [%s] dropped %s %v: %s\n", input, name, tags, reason)
+}
+
+// Retrace returns next, traced and prioritized like prev. It is used when a
+// metric is replaced by a modified copy.
+func Retrace(prev telegraf.Metric, next telegraf.Metric) telegraf.Metric {
+	next = WithPriority(next, buffer.Priority(prev))
+	if tm, ok := prev.(*tracedMetric); ok {
+		return &tracedMetric{Metric: next, id: tm.id}
+	}
+	return next
+}
+
+// Tracef logs a step of the given metric through the pipeline, if it is
+// being traced.
+func Tracef(metric telegraf.Metric, format string, args ...interface{}) {
+	tm, ok := metric.(*tracedMetric)
+	if !ok {
+		return
+	}
+	log.Printf("TRACE #%d %s\n", tm.id, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,81 @@
+package internal_models
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func captureLog() (*bytes.Buffer, func()) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	return &buf, func() { log.SetOutput(os.Stderr) }
+}
+
+func TestTrace_Disabled(t *testing.T) {
+	m := testutil.TestMetric(1, "cpu")
+	assert.Equal(t, m, StartTrace("cpu", m))
+}
+
+func TestTrace_Output(t *testing.T) {
+	tracer := &Tracer{
+		Filter:     Filter{NamePass: []string{"traced"}},
+		SampleRate: 1,
+	}
+	require.NoError(t, tracer.Filter.CompileFilter())
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	buf, restore := captureLog()
+	defer restore()
+
+	untraced := StartTrace("test", testutil.TestMetric(1, "untraced"))
+	traced := StartTrace("test", testutil.TestMetric(1, "traced"))
+	assert.Equal(t, "untraced", untraced.Name())
+	assert.Equal(t, "traced", traced.Name())
+
+	conf := &OutputConfig{
+		Filter: Filter{TagExclude: []string{"tag1"}},
+	}
+	require.NoError(t, conf.Filter.CompileFilter())
+	m := &mockOutput{}
+	ro := NewRunningOutput("test", m, conf, 1000, 10000)
+	ro.Quiet = true
+	ro.AddMetric(untraced)
+	ro.AddMetric(traced)
+	require.NoError(t, ro.Write())
+
+	out := buf.String()
+	assert.NotContains(t, out, "untraced")
+	assert.Contains(t, out, "TRACE #1 gathered by input [test]: "+
+		"traced,tag1=value1 value=1i 1257894000000000000")
+	assert.Contains(t, out, "TRACE #1 tags filtered by output [test]: "+
+		"traced value=1i 1257894000000000000")
+	assert.Contains(t, out, "TRACE #1 buffered by output [test]")
+	assert.Contains(t, out, "TRACE #1 written by output [test]")
+
+	// the output receives the metric without its tag
+	written := m.Metrics()
+	require.Len(t, written, 2)
+	assert.Equal(t, map[string]string{}, written[1].Tags())
+}
+
+func TestTrace_Drop(t *testing.T) {
+	tracer := &Tracer{SampleRate: 1}
+	require.NoError(t, tracer.Filter.CompileFilter())
+	SetTracer(tracer)
+	defer SetTracer(nil)
+
+	buf, restore := captureLog()
+	defer restore()
+
+	TraceDrop("test", "cpu", map[string]string{"cpu": "cpu0"}, "namepass")
+	assert.Contains(t, buf.String(),
+		"TRACE input [test] dropped cpu map[cpu:cpu0]: namepass")
+}
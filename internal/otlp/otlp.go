@@ -0,0 +1,267 @@
+// Package otlp records spans for the agent's own work, such as gathers and
+// output writes. It exports them to an OpenTelemetry collector with OTLP over HTTP,
+// JSON encoded.
+//
+// A nil *Exporter records nothing, and so does a nil *Span, so that the
+// instrumented code does not check whether tracing is enabled.
+package otlp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxQueued is the most spans waiting to be exported, later spans are dropped.
+const maxQueued = 4096
+
+// tracesPath is the OTLP/HTTP traces endpoint path.
+const tracesPath = "/v1/traces"
+
+// Exporter exports the spans it records to an OTLP endpoint.
+type Exporter struct {
+	url     string
+	service string
+	client  *http.Client
+
+	mu      sync.Mutex
+	queued  []*Span
+	dropped int
+}
+
+// NewExporter returns an Exporter that sends the spans of the given service
+// to an OTLP/HTTP endpoint, such as "http://localhost:4318".
+func NewExporter(endpoint, service string) *Exporter {
+	url := strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(url, tracesPath) {
+		url += tracesPath
+	}
+	return &Exporter{
+		url:     url,
+		service: service,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Start starts a span without a parent, as the root of a new trace.
+func (e *Exporter) Start(name string) *Span {
+	if e == nil {
+		return nil
+	}
+	s := &Span{exporter: e, name: name, start: time.Now()}
+	rand.Read(s.traceID[:])
+	rand.Read(s.spanID[:])
+	return s
+}
+
+func (e *Exporter) queue(s *Span) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.queued) >= maxQueued {
+		e.dropped++
+		return
+	}
+	e.queued = append(e.queued, s)
+}
+
+// Run exports the spans every interval until shutdown is closed.
+func (e *Exporter) Run(interval time.Duration, shutdown chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdown:
+			return
+		case <-ticker.C:
+			if err := e.Flush(); err != nil {
+				log.Printf("ERROR exporting spans: %s\n", err)
+			}
+		}
+	}
+}
+
+// Flush exports the spans ended since the last export. They are dropped if
+// the export fails.
+func (e *Exporter) Flush() error {
+	if e == nil {
+		return nil
+	}
+	e.mu.Lock()
+	spans, dropped := e.queued, e.dropped
+	e.queued, e.dropped = nil, 0
+	e.mu.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped %d spans, more than %d were waiting to be "+
+			"exported\n", dropped, maxQueued)
+	}
+	if len(spans) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(e.request(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := e.client.Post(e.url, "application/json",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", e.url, resp.Status)
+	}
+	return nil
+}
+
+// request returns the ExportTraceServiceRequest for the spans.
+func (e *Exporter) request(spans []*Span) map[string]interface{} {
+	encoded := make([]map[string]interface{}, 0, len(spans))
+	for _, s := range spans {
+		encoded = append(encoded, s.encode())
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource": map[string]interface{}{
+					"attributes": []interface{}{
+						attribute{"service.name", e.service}.encode(),
+					},
+				},
+				"scopeSpans": []interface{}{
+					map[string]interface{}{
+						"scope": map[string]interface{}{"name": "telegraf"},
+						"spans": encoded,
+					},
+				},
+			},
+		},
+	}
+}
+
+// Span is an agent operation, such as a gather.
+type Span struct {
+	exporter *Exporter
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []attribute
+	err   string
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+// Child starts a span in the same trace, with s as its parent.
+func (s *Span) Child(name string) *Span {
+	if s == nil {
+		return nil
+	}
+	c := &Span{
+		exporter: s.exporter,
+		traceID:  s.traceID,
+		parentID: s.spanID,
+		name:     name,
+		start:    time.Now(),
+	}
+	rand.Read(c.spanID[:])
+	return c
+}
+
+// SetAttribute sets a span attribute. Its value is a string, bool,
+// int, int64 or float64.
+func (s *Span) SetAttribute(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attrs = append(s.attrs, attribute{key, value})
+}
+
+// SetError marks the span as failed, if err is not nil.
+func (s *Span) SetError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End ends the span, which is exported with the next export.
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.end = time.Now()
+	s.mu.Unlock()
+	s.exporter.queue(s)
+}
+
+func (s *Span) encode() map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	span := map[string]interface{}{
+		"traceId": hex.EncodeToString(s.traceID[:]),
+		"spanId":  hex.EncodeToString(s.spanID[:]),
+		"name":    s.name,
+		// SPAN_KIND_INTERNAL
+		"kind":              1,
+		"startTimeUnixNano": strconv.FormatInt(s.start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.end.UnixNano(), 10),
+	}
+	if s.parentID != [8]byte{} {
+		span["parentSpanId"] = hex.EncodeToString(s.parentID[:])
+	}
+	attrs := make([]interface{}, 0, len(s.attrs))
+	for _, a := range s.attrs {
+		attrs = append(attrs, a.encode())
+	}
+	span["attributes"] = attrs
+	if s.err != "" {
+		// STATUS_CODE_ERROR
+		span["status"] = map[string]interface{}{"code": 2, "message": s.err}
+	}
+	return span
+}
+
+func (a attribute) encode() map[string]interface{} {
+	var value map[string]interface{}
+	switch v := a.value.(type) {
+	case string:
+		value = map[string]interface{}{"stringValue": v}
+	case bool:
+		value = map[string]interface{}{"boolValue": v}
+	case int:
+		value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+	case int64:
+		value = map[string]interface{}{
+			"intValue": strconv.FormatInt(v, 10)}
+	case float64:
+		value = map[string]interface{}{"doubleValue": v}
+	default:
+		value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+	}
+	return map[string]interface{}{"key": a.key, "value": value}
+}
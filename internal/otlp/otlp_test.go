@@ -0,0 +1,96 @@
+package otlp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNilExporter(t *testing.T) {
+	var e *Exporter
+	s := e.Start("flush")
+	assert.Nil(t, s)
+	c := s.Child("write")
+	c.SetAttribute("output", "influxdb")
+	c.SetError(errors.New("unavailable"))
+	c.End()
+	s.End()
+	assert.NoError(t, e.Flush())
+}
+
+func TestExport(t *testing.T) {
+	var req struct {
+		ResourceSpans []struct {
+			Resource struct {
+				Attributes []map[string]interface{}
+			}
+			ScopeSpans []struct {
+				Spans []map[string]interface{}
+			}
+		}
+	}
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v1/traces", r.URL.Path)
+			assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+		}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL, "telegraf")
+	flush := e.Start("flush")
+	write := flush.Child("write")
+	write.SetAttribute("output", "influxdb")
+	write.SetAttribute("metrics", 42)
+	write.SetError(errors.New("unavailable"))
+	write.End()
+	flush.End()
+	require.NoError(t, e.Flush())
+
+	require.Len(t, req.ResourceSpans, 1)
+	assert.Equal(t, map[string]interface{}{
+		"key":   "service.name",
+		"value": map[string]interface{}{"stringValue": "telegraf"},
+	}, req.ResourceSpans[0].Resource.Attributes[0])
+	spans := req.ResourceSpans[0].ScopeSpans[0].Spans
+	require.Len(t, spans, 2)
+
+	w, f := spans[0], spans[1]
+	assert.Equal(t, "write", w["name"])
+	assert.Equal(t, "flush", f["name"])
+	assert.Equal(t, f["traceId"], w["traceId"])
+	assert.Equal(t, f["spanId"], w["parentSpanId"])
+	assert.Nil(t, f["parentSpanId"])
+	assert.Len(t, f["traceId"], 32)
+	assert.Equal(t, []interface{}{
+		map[string]interface{}{"key": "output",
+			"value": map[string]interface{}{"stringValue": "influxdb"}},
+		map[string]interface{}{"key": "metrics",
+			"value": map[string]interface{}{"intValue": "42"}},
+	}, w["attributes"])
+	assert.Equal(t, map[string]interface{}{
+		"code": float64(2), "message": "unavailable"}, w["status"])
+	assert.Nil(t, f["status"])
+
+	// exported spans are not exported again
+	req.ResourceSpans = nil
+	require.NoError(t, e.Flush())
+	assert.Nil(t, req.ResourceSpans)
+}
+
+func TestExportError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}))
+	defer ts.Close()
+
+	e := NewExporter(ts.URL+"/v1/traces", "telegraf")
+	e.Start("gather").End()
+	assert.Error(t, e.Flush())
+}
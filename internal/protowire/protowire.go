@@ -0,0 +1,195 @@
+// Package protowire encodes and decodes the wire format of protocol buffers,
+// for the plugins that write or read messages without generated code.
+package protowire
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Wire types of the protobuf encoding. Groups are deprecated, and not
+// supported.
+const (
+	WireVarint  = 0
+	WireFixed64 = 1
+	WireBytes   = 2
+	WireFixed32 = 5
+)
+
+// ErrTruncated is returned for a message ending in the middle of a field.
+var ErrTruncated = errors.New("unexpected end of message")
+
+// Encoder encodes a protobuf message. Fields are written in the order they
+// are given, and embedded messages are written into an Encoder of their own
+// to be length prefixed.
+type Encoder struct {
+	// Buf is the encoded message.
+	Buf []byte
+}
+
+// Key writes the key of a field.
+func (e *Encoder) Key(field int, wire int) {
+	e.Uvarint(uint64(field)<<3 | uint64(wire))
+}
+
+// Uvarint writes a varint, without a key.
+func (e *Encoder) Uvarint(v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	e.Buf = append(e.Buf, b[:binary.PutUvarint(b[:], v)]...)
+}
+
+// Varint writes a varint field. Negative integers are converted to uint64,
+// and take ten bytes.
+func (e *Encoder) Varint(field int, v uint64) {
+	e.Key(field, WireVarint)
+	e.Uvarint(v)
+}
+
+// Fixed64 writes a 64-bit field.
+func (e *Encoder) Fixed64(field int, v uint64) {
+	e.Key(field, WireFixed64)
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	e.Buf = append(e.Buf, b[:]...)
+}
+
+// Fixed32 writes a 32-bit field.
+func (e *Encoder) Fixed32(field int, v uint32) {
+	e.Key(field, WireFixed32)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	e.Buf = append(e.Buf, b[:]...)
+}
+
+// Double writes a double field.
+func (e *Encoder) Double(field int, v float64) {
+	e.Fixed64(field, math.Float64bits(v))
+}
+
+// Bytes writes a length delimited field.
+func (e *Encoder) Bytes(field int, v []byte) {
+	e.Key(field, WireBytes)
+	e.Uvarint(uint64(len(v)))
+	e.Buf = append(e.Buf, v...)
+}
+
+// String writes a string field.
+func (e *Encoder) String(field int, v string) {
+	e.Key(field, WireBytes)
+	e.Uvarint(uint64(len(v)))
+	e.Buf = append(e.Buf, v...)
+}
+
+// Message writes an embedded message field, whose fields fn writes.
+func (e *Encoder) Message(field int, fn func(*Encoder)) {
+	m := &Encoder{}
+	fn(m)
+	e.Bytes(field, m.Buf)
+}
+
+// Field is a field of an encoded message.
+type Field struct {
+	Number int
+	Wire   int
+	// Value is the value of varint and fixed fields.
+	Value uint64
+	// Raw is the value of length delimited fields.
+	Raw []byte
+}
+
+// Range calls fn with each field of a message, in order, and stops at the
+// first error it returns.
+func Range(b []byte, fn func(Field) error) error {
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return ErrTruncated
+		}
+		b = b[n:]
+		f := Field{Number: int(key >> 3), Wire: int(key & 7)}
+		switch f.Wire {
+		case WireVarint:
+			f.Value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return ErrTruncated
+			}
+		case WireFixed64:
+			if len(b) < 8 {
+				return ErrTruncated
+			}
+			f.Value, n = binary.LittleEndian.Uint64(b), 8
+		case WireFixed32:
+			if len(b) < 4 {
+				return ErrTruncated
+			}
+			f.Value, n = uint64(binary.LittleEndian.Uint32(b)), 4
+		case WireBytes:
+			size, m := binary.Uvarint(b)
+			if m <= 0 || uint64(len(b)-m) < size {
+				return ErrTruncated
+			}
+			f.Raw, n = b[m:m+int(size)], m+int(size)
+		default:
+			return fmt.Errorf("field %d: unsupported wire type %d", f.Number,
+				f.Wire)
+		}
+		b = b[n:]
+		if err := fn(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fields decodes the fields of a message by number, for messages whose
+// schema is known to the caller. Varint and fixed fields are uint64, and
+// length delimited fields are []byte.
+func Fields(b []byte) (map[uint64][]interface{}, error) {
+	m := make(map[uint64][]interface{})
+	err := Range(b, func(f Field) error {
+		var v interface{} = f.Value
+		if f.Wire == WireBytes {
+			v = f.Raw
+		}
+		m[uint64(f.Number)] = append(m[uint64(f.Number)], v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Packed calls fn with each value of a packed repeated field, whose values
+// have the given wire type.
+func Packed(raw []byte, wire int, fn func(uint64) error) error {
+	for len(raw) > 0 {
+		var v uint64
+		var n int
+		switch wire {
+		case WireVarint:
+			if v, n = binary.Uvarint(raw); n <= 0 {
+				return ErrTruncated
+			}
+		case WireFixed64:
+			if len(raw) < 8 {
+				return ErrTruncated
+			}
+			v, n = binary.LittleEndian.Uint64(raw), 8
+		case WireFixed32:
+			if len(raw) < 4 {
+				return ErrTruncated
+			}
+			v, n = uint64(binary.LittleEndian.Uint32(raw)), 4
+		default:
+			return fmt.Errorf("unsupported packed wire type %d", wire)
+		}
+		raw = raw[n:]
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
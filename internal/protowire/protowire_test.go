@@ -0,0 +1,75 @@
+package protowire
+
+import (
+	"math"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncoder(t *testing.T) {
+	e := &Encoder{}
+	e.Varint(1, 150)
+	e.String(2, "testing")
+	e.Message(3, func(e *Encoder) {
+		e.Varint(1, 1)
+	})
+	e.Double(4, 1.5)
+	e.Fixed32(5, 7)
+	// the examples from the protocol buffers encoding documentation
+	assert.Equal(t, []byte{0x08, 0x96, 0x01}, e.Buf[:3])
+	assert.Equal(t, []byte{0x12, 0x07, 't', 'e', 's', 't', 'i', 'n', 'g'},
+		e.Buf[3:12])
+	assert.Equal(t, []byte{0x1a, 0x02, 0x08, 0x01}, e.Buf[12:16])
+
+	// the encoding of the vendored proto package
+	b := proto.NewBuffer(nil)
+	b.EncodeVarint(4<<3 | WireFixed64)
+	b.EncodeFixed64(math.Float64bits(1.5))
+	b.EncodeVarint(5<<3 | WireFixed32)
+	b.EncodeFixed32(7)
+	assert.Equal(t, b.Bytes(), e.Buf[16:])
+}
+
+func TestFields(t *testing.T) {
+	e := &Encoder{}
+	e.Varint(1, 150)
+	e.String(2, "testing")
+	e.Varint(1, 3)
+	e.Double(4, 1.5)
+	e.Fixed32(5, 7)
+
+	m, err := Fields(e.Buf)
+	require.NoError(t, err)
+	assert.Equal(t, map[uint64][]interface{}{
+		1: {uint64(150), uint64(3)},
+		2: {[]byte("testing")},
+		4: {math.Float64bits(1.5)},
+		5: {uint64(7)},
+	}, m)
+
+	_, err = Fields(e.Buf[:len(e.Buf)-1])
+	assert.Equal(t, ErrTruncated, err)
+	_, err = Fields([]byte{0x0b})
+	assert.Error(t, err)
+}
+
+func TestPacked(t *testing.T) {
+	e := &Encoder{}
+	e.Uvarint(3)
+	e.Uvarint(270)
+	e.Uvarint(86942)
+	var values []uint64
+	require.NoError(t, Packed(e.Buf, WireVarint, func(v uint64) error {
+		values = append(values, v)
+		return nil
+	}))
+	assert.Equal(t, []uint64{3, 270, 86942}, values)
+
+	err := Packed([]byte{1, 2, 3}, WireFixed32, func(uint64) error {
+		return nil
+	})
+	assert.Equal(t, ErrTruncated, err)
+}
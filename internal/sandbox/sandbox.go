@@ -0,0 +1,204 @@
+// Package sandbox restricts the processes spawned by plugins that run
+// external commands, such as the exec input and external plugins.
+//
+// A Sandbox can run the processes as another user, prevent them from gaining
+// privileges through setuid binaries, pass them only some of telegraf's
+// environment, and limit their CPU and memory with a cgroup v2. Only Linux is
+// supported.
+package sandbox
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ExecArg is the first argument telegraf gets when it runs as the helper. The
+// helper sets no_new_privs on itself, then executes a sandboxed command.
+const ExecArg = "sandbox-exec"
+
+// CgroupRoot is where the cgroup v2 hierarchy is mounted.
+var CgroupRoot = "/sys/fs/cgroup"
+
+// cpuPeriod is the CPU limit period, in microseconds.
+const cpuPeriod = 100000
+
+// Sandbox holds the restrictions for the processes of a plugin. The zero
+// value does not restrict anything.
+type Sandbox struct {
+	// User is the name or uid of the user the processes run as.
+	User string `toml:"user"`
+	// NoNewPrivileges prevents the processes from gaining privileges through
+	// setuid binaries such as sudo.
+	NoNewPrivileges bool `toml:"no_new_privileges"`
+	// RestrictEnvironment passes only the variables named in Environment to
+	// the processes, instead of all of telegraf's environment.
+	RestrictEnvironment bool     `toml:"restrict_environment"`
+	Environment         []string `toml:"environment"`
+	// CPULimit is the number of CPUs the processes may use, such as 0.5.
+	CPULimit float64 `toml:"cpu_limit"`
+	// MemoryLimit is the memory the processes may use, in bytes.
+	MemoryLimit int64 `toml:"memory_limit"`
+	// Cgroup is the cgroup path for the processes under CgroupRoot. It is
+	// used if a limit is set. Plugins sharing a cgroup share its limits.
+	Cgroup string `toml:"cgroup"`
+
+	mu sync.Mutex
+	// cgroup is the cgroup path once it is created
+	cgroup string
+}
+
+// Enabled returns true if the sandbox restricts anything.
+func (s *Sandbox) Enabled() bool {
+	return s != nil && (s.User != "" || s.NoNewPrivileges ||
+		s.RestrictEnvironment || s.limited())
+}
+
+func (s *Sandbox) limited() bool {
+	return s.CPULimit > 0 || s.MemoryLimit > 0
+}
+
+// Environ returns the environment for the processes. It is telegraf's
+// environment, restricted to the variables named in Environment if
+// RestrictEnvironment is set.
+func (s *Sandbox) Environ() []string {
+	env := os.Environ()
+	if s == nil || !s.RestrictEnvironment {
+		return env
+	}
+	allowed := make(map[string]bool, len(s.Environment))
+	for _, name := range s.Environment {
+		allowed[name] = true
+	}
+	restricted := []string{}
+	for _, kv := range env {
+		if allowed[strings.SplitN(kv, "=", 2)[0]] {
+			restricted = append(restricted, kv)
+		}
+	}
+	return restricted
+}
+
+// Start starts cmd in the sandbox. If Cgroup is not set, name is the cgroup
+// for the processes under CgroupRoot/telegraf. The environment of cmd is left
+// as is, see Environ.
+func (s *Sandbox) Start(cmd *exec.Cmd, name string) error {
+	if !s.Enabled() {
+		return cmd.Start()
+	}
+	if err := prepare(s, cmd); err != nil {
+		return fmt.Errorf("sandbox: %s", err)
+	}
+
+	var cgroup string
+	if s.limited() {
+		var err error
+		if cgroup, err = s.createCgroup(name); err != nil {
+			return fmt.Errorf("sandbox: error creating cgroup: %s", err)
+		}
+	}
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if cgroup != "" {
+		// the process runs unlimited until it is moved to the cgroup
+		pid := strconv.Itoa(cmd.Process.Pid)
+		err := writeFile(filepath.Join(cgroup, "cgroup.procs"), pid)
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return fmt.Errorf("sandbox: error moving process to cgroup: %s",
+				err)
+		}
+	}
+	return nil
+}
+
+// createCgroup returns the cgroup path for the processes. The first call
+// creates the cgroup and sets its limits.
+func (s *Sandbox) createCgroup(name string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cgroup != "" {
+		return s.cgroup, nil
+	}
+
+	rel := s.Cgroup
+	if rel == "" {
+		rel = filepath.Join("telegraf", name)
+	}
+	rel = filepath.Clean("/" + rel)[1:]
+	if rel == "" {
+		return "", fmt.Errorf("invalid cgroup %q", s.Cgroup)
+	}
+
+	var controllers []string
+	if s.CPULimit > 0 {
+		controllers = append(controllers, "+cpu")
+	}
+	if s.MemoryLimit > 0 {
+		controllers = append(controllers, "+memory")
+	}
+
+	// the controllers must be enabled in every parent of the cgroup
+	dir := CgroupRoot
+	for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+		err := writeFile(filepath.Join(dir, "cgroup.subtree_control"),
+			strings.Join(controllers, " "))
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(dir, elem)
+		if err := os.Mkdir(dir, 0755); err != nil && !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	if s.CPULimit > 0 {
+		quota := int64(s.CPULimit * cpuPeriod)
+		err := writeFile(filepath.Join(dir, "cpu.max"),
+			fmt.Sprintf("%d %d", quota, cpuPeriod))
+		if err != nil {
+			return "", err
+		}
+	}
+	if s.MemoryLimit > 0 {
+		err := writeFile(filepath.Join(dir, "memory.max"),
+			strconv.FormatInt(s.MemoryLimit, 10))
+		if err != nil {
+			return "", err
+		}
+	}
+	s.cgroup = dir
+	return dir, nil
+}
+
+func writeFile(path, data string) error {
+	return ioutil.WriteFile(path, []byte(data), 0644)
+}
+
+// Exec is the main function of the helper, which telegraf runs when its first
+// argument is ExecArg. It sets no_new_privs on itself, then executes the
+// command given by its arguments: the path followed by the argv. On error it
+// exits instead of returning.
+func Exec(args []string) {
+	if len(args) < 2 {
+		fmt.Fprintf(os.Stderr, "usage: telegraf %s <path> <argv...>\n",
+			ExecArg)
+		os.Exit(2)
+	}
+	if err := setNoNewPrivs(); err != nil {
+		fmt.Fprintf(os.Stderr, "sandbox: error setting no_new_privs: %s\n",
+			err)
+		os.Exit(126)
+	}
+	err := execve(args[0], args[1:], os.Environ())
+	fmt.Fprintf(os.Stderr, "sandbox: error executing %s: %s\n", args[0], err)
+	os.Exit(126)
+}
@@ -0,0 +1,83 @@
+// +build linux
+
+package sandbox
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+const prSetNoNewPrivs = 38
+
+// prepare sets the user for cmd. If cmd must not gain privileges, it runs
+// cmd through the helper.
+func prepare(s *Sandbox, cmd *exec.Cmd) error {
+	if s.User != "" {
+		cred, err := credential(s.User)
+		if err != nil {
+			return err
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = cred
+	}
+
+	if s.NoNewPrivileges {
+		self, err := os.Readlink("/proc/self/exe")
+		if err != nil {
+			return err
+		}
+		// the helper runs as the cmd user, and executes the cmd path
+		cmd.Args = append([]string{self, ExecArg, cmd.Path}, cmd.Args...)
+		cmd.Path = self
+	}
+	return nil
+}
+
+// credential returns the credential for a user, given its name or uid.
+func credential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if _, numErr := strconv.Atoi(name); numErr != nil {
+			return nil, err
+		}
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(g))
+			}
+		}
+	}
+	return cred, nil
+}
+
+func setNoNewPrivs() error {
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs,
+		1, 0, 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func execve(path string, argv []string, env []string) error {
+	return syscall.Exec(path, argv, env)
+}
@@ -0,0 +1,26 @@
+// +build !linux
+
+package sandbox
+
+import (
+	"errors"
+	"os/exec"
+)
+
+var errUnsupported = errors.New("only supported on Linux")
+
+// prepare only allows restricting the environment.
+func prepare(s *Sandbox, cmd *exec.Cmd) error {
+	if s.User != "" || s.NoNewPrivileges || s.limited() {
+		return errUnsupported
+	}
+	return nil
+}
+
+func setNoNewPrivs() error {
+	return errUnsupported
+}
+
+func execve(path string, argv []string, env []string) error {
+	return errUnsupported
+}
@@ -0,0 +1,120 @@
+package sandbox
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+
+	"github.com/influxdata/config"
+	"github.com/influxdata/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnabled(t *testing.T) {
+	var nilSandbox *Sandbox
+	assert.False(t, nilSandbox.Enabled())
+	assert.False(t, (&Sandbox{}).Enabled())
+	assert.True(t, (&Sandbox{User: "nobody"}).Enabled())
+	assert.True(t, (&Sandbox{MemoryLimit: 1024}).Enabled())
+}
+
+func TestUnmarshal(t *testing.T) {
+	tbl, err := toml.Parse([]byte(`
+user = "nobody"
+no_new_privileges = true
+cpu_limit = 0.5
+memory_limit = 1048576
+`))
+	require.NoError(t, err)
+	s := &Sandbox{}
+	require.NoError(t, config.UnmarshalTable(tbl, s))
+	assert.Equal(t, "nobody", s.User)
+	assert.True(t, s.NoNewPrivileges)
+	assert.Equal(t, 0.5, s.CPULimit)
+	assert.Equal(t, int64(1048576), s.MemoryLimit)
+}
+
+func TestEnviron(t *testing.T) {
+	os.Setenv("SANDBOX_TEST_ALLOWED", "1")
+	os.Setenv("SANDBOX_TEST_DENIED", "1")
+	defer os.Unsetenv("SANDBOX_TEST_ALLOWED")
+	defer os.Unsetenv("SANDBOX_TEST_DENIED")
+
+	var nilSandbox *Sandbox
+	assert.Equal(t, os.Environ(), nilSandbox.Environ())
+
+	s := &Sandbox{
+		RestrictEnvironment: true,
+		Environment:         []string{"SANDBOX_TEST_ALLOWED"},
+	}
+	assert.Equal(t, []string{"SANDBOX_TEST_ALLOWED=1"}, s.Environ())
+
+	s.Environment = nil
+	assert.Empty(t, s.Environ())
+}
+
+func TestStartCgroup(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test, requires Linux")
+	}
+	root, err := ioutil.TempDir("", "cgroup")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+	defer func(old string) { CgroupRoot = old }(CgroupRoot)
+	CgroupRoot = root
+
+	s := &Sandbox{CPULimit: 0.5, MemoryLimit: 1048576}
+	cmd := exec.Command("true")
+	require.NoError(t, s.Start(cmd, "exec-test"))
+	require.NoError(t, cmd.Wait())
+
+	read := func(path ...string) string {
+		b, err := ioutil.ReadFile(filepath.Join(
+			append([]string{root}, path...)...))
+		require.NoError(t, err)
+		return string(b)
+	}
+	assert.Equal(t, "+cpu +memory", read("cgroup.subtree_control"))
+	assert.Equal(t, "+cpu +memory",
+		read("telegraf", "cgroup.subtree_control"))
+	assert.Equal(t, "50000 100000", read("telegraf", "exec-test", "cpu.max"))
+	assert.Equal(t, "1048576", read("telegraf", "exec-test", "memory.max"))
+	assert.Equal(t, strconv.Itoa(cmd.Process.Pid),
+		read("telegraf", "exec-test", "cgroup.procs"))
+}
+
+func TestStartCgroupInvalid(t *testing.T) {
+	s := &Sandbox{MemoryLimit: 1024, Cgroup: "/"}
+	err := s.Start(exec.Command("true"), "exec-test")
+	assert.Error(t, err)
+}
+
+func TestNoNewPrivileges(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test, requires Linux")
+	}
+	cmd := exec.Command("/bin/echo", "hello")
+	require.NoError(t, prepare(&Sandbox{NoNewPrivileges: true}, cmd))
+	self, err := os.Readlink("/proc/self/exe")
+	require.NoError(t, err)
+	assert.Equal(t, self, cmd.Path)
+	assert.Equal(t, []string{self, ExecArg, "/bin/echo", "/bin/echo", "hello"},
+		cmd.Args)
+}
+
+func TestUser(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("Skipping test, requires Linux")
+	}
+	cmd := exec.Command("true")
+	require.NoError(t, prepare(&Sandbox{User: "0"}, cmd))
+	assert.Equal(t, uint32(0), cmd.SysProcAttr.Credential.Uid)
+
+	err := prepare(&Sandbox{User: "no-such-user-telegraf"}, exec.Command("true"))
+	assert.Error(t, err)
+}
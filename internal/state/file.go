@@ -0,0 +1,83 @@
+package state
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+)
+
+// fileBackend saves each namespace as a JSON file in a directory.
+type fileBackend struct {
+	dir string
+}
+
+// NewFileBackend returns a Backend saving to the given directory, which is
+// created if it does not exist.
+func NewFileBackend(dir string) (Backend, error) {
+	if dir == "" {
+		return nil, fmt.Errorf("file state store needs a directory")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &fileBackend{dir: dir}, nil
+}
+
+func (f *fileBackend) path(namespace string) string {
+	return filepath.Join(f.dir, url.QueryEscape(namespace)+".json")
+}
+
+func (f *fileBackend) Load(namespace string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(f.path(namespace))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var values map[string]string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Save writes the namespace to a temporary file, then renames it over the
+// previous one, so that a crash never leaves a partially written state.
+func (f *fileBackend) Save(namespace string, values map[string]string) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(f.dir, ".state")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), f.path(namespace))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+func (f *fileBackend) Close() error {
+	return nil
+}
+
+func init() {
+	Add("file", func(u *url.URL) (Backend, error) {
+		return NewFileBackend(u.Path)
+	})
+}
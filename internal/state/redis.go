@@ -0,0 +1,163 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const redisTimeout = 5 * time.Second
+
+// redisBackend saves each namespace as a JSON string in a redis key. A
+// connection is made for each operation, since the state is only saved every
+// flush interval.
+type redisBackend struct {
+	addr     string
+	password string
+	db       string
+	prefix   string
+}
+
+// NewRedisBackend returns a Backend that saves to the redis server at the
+// given URL, such as "redis://:password@localhost:6379/0". The keys are prefixed with
+// the "prefix" query parameter, "telegraf:state:" by default.
+func NewRedisBackend(u *url.URL) (Backend, error) {
+	r := &redisBackend{
+		addr:   u.Host,
+		db:     strings.TrimPrefix(u.Path, "/"),
+		prefix: "telegraf:state:",
+	}
+	if _, _, err := net.SplitHostPort(r.addr); err != nil {
+		r.addr = net.JoinHostPort(r.addr, "6379")
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+	}
+	if r.db != "" {
+		if _, err := strconv.Atoi(r.db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", r.db)
+		}
+	}
+	if p, ok := u.Query()["prefix"]; ok {
+		r.prefix = p[0]
+	}
+	return r, nil
+}
+
+func (r *redisBackend) Load(namespace string) (map[string]string, error) {
+	var reply interface{}
+	err := r.do(func(c *redisConn) error {
+		var err error
+		reply, err = c.cmd("GET", r.prefix+namespace)
+		return err
+	})
+	if err != nil || reply == nil {
+		return nil, err
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(reply.(string)), &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// Save sets the key of the namespace with a single SET, which redis applies
+// atomically.
+func (r *redisBackend) Save(namespace string, values map[string]string) error {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return r.do(func(c *redisConn) error {
+		_, err := c.cmd("SET", r.prefix+namespace, string(data))
+		return err
+	})
+}
+
+func (r *redisBackend) Close() error {
+	return nil
+}
+
+// do connects to the server, authenticates and selects the database, then
+// runs f.
+func (r *redisBackend) do(f func(c *redisConn) error) error {
+	conn, err := net.DialTimeout("tcp", r.addr, redisTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	c := &redisConn{conn: conn, rdr: bufio.NewReader(conn)}
+	if r.password != "" {
+		if _, err := c.cmd("AUTH", r.password); err != nil {
+			return err
+		}
+	}
+	if r.db != "" {
+		if _, err := c.cmd("SELECT", r.db); err != nil {
+			return err
+		}
+	}
+	return f(c)
+}
+
+type redisConn struct {
+	conn net.Conn
+	rdr  *bufio.Reader
+}
+
+// cmd sends a command and returns its reply: a string, an int64, or nil.
+func (c *redisConn) cmd(args ...string) (interface{}, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	return c.reply()
+}
+
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.rdr.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply from redis")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, errors.New(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rdr, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	}
+	return nil, fmt.Errorf("unexpected reply from redis: %q", line)
+}
+
+func init() {
+	Add("redis", NewRedisBackend)
+}
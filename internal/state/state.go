@@ -0,0 +1,246 @@
+// Package state keeps plugin state, such as the offsets of tailed files,
+// across telegraf restarts.
+//
+// The state of each plugin instance is a set of string keys and values, kept
+// in its own namespace. Changes are made in memory and committed to a Backend
+// as a whole, so a namespace is always saved consistently.
+package state
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// Backend stores the state of namespaces.
+type Backend interface {
+	// Load returns the saved state of a namespace, or nil if it has none.
+	Load(namespace string) (map[string]string, error)
+	// Save replaces the saved state of a namespace. It must either save all
+	// the values, or leave the previous state untouched.
+	Save(namespace string, values map[string]string) error
+	Close() error
+}
+
+// Creator opens a Backend for the given URL.
+type Creator func(u *url.URL) (Backend, error)
+
+// Backends are the registered backends, by URL scheme.
+var Backends = map[string]Creator{}
+
+// Add registers a backend for the given URL scheme.
+func Add(scheme string, creator Creator) {
+	Backends[scheme] = creator
+}
+
+// Stateful is implemented by plugins that keep state across restarts. The
+// state is set before the plugin is started.
+type Stateful interface {
+	SetState(s *State)
+}
+
+// ErrTooLarge is returned when a change would make the state of a namespace
+// larger than the size limit of its Store.
+var ErrTooLarge = errors.New("state is larger than the size limit")
+
+// Store hands out the State of namespaces and commits them to its Backend.
+type Store struct {
+	backend Backend
+	maxSize int
+
+	mu     sync.Mutex
+	states map[string]*State
+}
+
+// Open opens the store at the given URL, such as
+// "file:///var/lib/telegraf/state" or "redis://localhost:6379/0". maxSize is
+// the largest total size of the keys and values in a namespace, in bytes.
+// Zero means no limit.
+func Open(rawurl string, maxSize int) (*Store, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	creator, ok := Backends[u.Scheme]
+	if !ok {
+		return nil, fmt.Errorf("unknown state store %q", u.Scheme)
+	}
+	backend, err := creator(u)
+	if err != nil {
+		return nil, err
+	}
+	return NewStore(backend, maxSize), nil
+}
+
+// NewStore returns a Store saving to the given backend.
+func NewStore(backend Backend, maxSize int) *Store {
+	return &Store{
+		backend: backend,
+		maxSize: maxSize,
+		states:  make(map[string]*State),
+	}
+}
+
+// State returns the state of a namespace, loading it from the backend the
+// first time. Later calls return the same State, so that a plugin restarted
+// by a reload continues where the previous instance left off.
+func (s *Store) State(namespace string) (*State, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if st, ok := s.states[namespace]; ok {
+		return st, nil
+	}
+
+	values, err := s.backend.Load(namespace)
+	if err != nil {
+		return nil, fmt.Errorf("loading state %s: %s", namespace, err)
+	}
+	st := &State{
+		store:     s,
+		namespace: namespace,
+		values:    make(map[string]string),
+	}
+	for k, v := range values {
+		st.values[k] = v
+		st.size += len(k) + len(v)
+	}
+	s.states[namespace] = st
+	return st, nil
+}
+
+// Commit commits the changes of every namespace. It returns the first error,
+// but commits all the namespaces it can.
+func (s *Store) Commit() error {
+	s.mu.Lock()
+	states := make([]*State, 0, len(s.states))
+	for _, st := range s.states {
+		states = append(states, st)
+	}
+	s.mu.Unlock()
+
+	var first error
+	for _, st := range states {
+		if err := st.Commit(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Close commits the changes of every namespace and closes the backend.
+func (s *Store) Close() error {
+	err := s.Commit()
+	if cerr := s.backend.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// State is the state of a namespace. A nil *State is valid and keeps
+// nothing, so plugins need not check whether a store is configured.
+type State struct {
+	store     *Store
+	namespace string
+
+	mu     sync.Mutex
+	values map[string]string
+	size   int
+	dirty  bool
+}
+
+// Namespace returns the namespace of the state.
+func (s *State) Namespace() string {
+	if s == nil {
+		return ""
+	}
+	return s.namespace
+}
+
+// Get returns the value of a key.
+func (s *State) Get(key string) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.values[key]
+	return v, ok
+}
+
+// Keys returns the keys of the state, sorted.
+func (s *State) Keys() []string {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]string, 0, len(s.values))
+	for k := range s.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Set sets the value of a key. The change is saved by the next Commit. It
+// returns ErrTooLarge, and changes nothing, if the state would exceed the
+// size limit of the store.
+func (s *State) Set(key, value string) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := s.size + len(key) + len(value)
+	if old, ok := s.values[key]; ok {
+		size -= len(key) + len(old)
+		if old == value {
+			return nil
+		}
+	}
+	if s.store.maxSize > 0 && size > s.store.maxSize {
+		return ErrTooLarge
+	}
+	s.values[key] = value
+	s.size = size
+	s.dirty = true
+	return nil
+}
+
+// Delete removes a key. The change is saved by the next Commit.
+func (s *State) Delete(key string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if old, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.size -= len(key) + len(old)
+		s.dirty = true
+	}
+}
+
+// Commit saves the state to the backend of its store, if it changed since
+// the last commit. All the changes are saved together, or none of them.
+func (s *State) Commit() error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.dirty {
+		return nil
+	}
+	values := make(map[string]string, len(s.values))
+	for k, v := range s.values {
+		values[k] = v
+	}
+	if err := s.store.backend.Save(s.namespace, values); err != nil {
+		return fmt.Errorf("saving state %s: %s", s.namespace, err)
+	}
+	s.dirty = false
+	return nil
+}
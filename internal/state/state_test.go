@@ -0,0 +1,228 @@
+package state
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := Open("file://"+dir, 0)
+	require.NoError(t, err)
+	tail, err := s.State("inputs.tail")
+	require.NoError(t, err)
+	other, err := s.State("inputs.tail.2")
+	require.NoError(t, err)
+
+	require.NoError(t, tail.Set("/var/log/syslog", "1024"))
+	require.NoError(t, tail.Set("/var/log/auth.log", "42"))
+	require.NoError(t, other.Set("/var/log/syslog", "7"))
+	same, err := s.State("inputs.tail")
+	require.NoError(t, err)
+	assert.True(t, same == tail)
+	require.NoError(t, s.Close())
+
+	// a new store loads what the previous one committed
+	s, err = Open("file://"+dir, 0)
+	require.NoError(t, err)
+	tail, err = s.State("inputs.tail")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/var/log/auth.log", "/var/log/syslog"}, tail.Keys())
+	v, ok := tail.Get("/var/log/syslog")
+	assert.True(t, ok)
+	assert.Equal(t, "1024", v)
+	other, err = s.State("inputs.tail.2")
+	require.NoError(t, err)
+	v, _ = other.Get("/var/log/syslog")
+	assert.Equal(t, "7", v)
+
+	tail.Delete("/var/log/auth.log")
+	require.NoError(t, tail.Commit())
+	values, err := s.backend.Load("inputs.tail")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/var/log/syslog": "1024"}, values)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 2, "temporary files left behind")
+}
+
+func TestStateSizeLimit(t *testing.T) {
+	backend := &memoryBackend{saved: map[string]map[string]string{}}
+	s := NewStore(backend, 10)
+	st, err := s.State("dedupe")
+	require.NoError(t, err)
+
+	require.NoError(t, st.Set("a", "1234"))
+	require.NoError(t, st.Set("b", "1234"))
+	assert.Equal(t, ErrTooLarge, st.Set("c", "1"))
+	_, ok := st.Get("c")
+	assert.False(t, ok)
+	// replacing a value only counts the difference
+	require.NoError(t, st.Set("a", "123"))
+	require.NoError(t, st.Set("c", ""))
+
+	require.NoError(t, s.Commit())
+	assert.Equal(t, map[string]string{"a": "123", "b": "1234", "c": ""},
+		backend.saved["dedupe"])
+	assert.Equal(t, 1, backend.saves)
+	// nothing changed, nothing saved
+	require.NoError(t, s.Commit())
+	assert.Equal(t, 1, backend.saves)
+}
+
+func TestNilState(t *testing.T) {
+	var st *State
+	assert.NoError(t, st.Set("a", "1"))
+	_, ok := st.Get("a")
+	assert.False(t, ok)
+	st.Delete("a")
+	assert.Empty(t, st.Keys())
+	assert.NoError(t, st.Commit())
+}
+
+func TestOpenUnknown(t *testing.T) {
+	_, err := Open("bolt:///var/lib/telegraf/state.db", 0)
+	assert.Error(t, err)
+}
+
+func TestRedisStore(t *testing.T) {
+	srv := newFakeRedis(t)
+	defer srv.Close()
+
+	u, err := url.Parse("redis://:secret@" + srv.Addr().String() + "/2")
+	require.NoError(t, err)
+	backend, err := NewRedisBackend(u)
+	require.NoError(t, err)
+
+	values, err := backend.Load("inputs.tail")
+	require.NoError(t, err)
+	assert.Nil(t, values)
+
+	s := NewStore(backend, 0)
+	st, err := s.State("inputs.tail")
+	require.NoError(t, err)
+	require.NoError(t, st.Set("/var/log/syslog", "1024"))
+	require.NoError(t, s.Close())
+	assert.Equal(t, `{"/var/log/syslog":"1024"}`,
+		srv.keys["2/telegraf:state:inputs.tail"])
+
+	values, err = backend.Load("inputs.tail")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"/var/log/syslog": "1024"}, values)
+
+	u.User = url.UserPassword("", "wrong")
+	backend, err = NewRedisBackend(u)
+	require.NoError(t, err)
+	_, err = backend.Load("inputs.tail")
+	assert.Error(t, err)
+}
+
+type memoryBackend struct {
+	saved map[string]map[string]string
+	saves int
+}
+
+func (m *memoryBackend) Load(namespace string) (map[string]string, error) {
+	return m.saved[namespace], nil
+}
+
+func (m *memoryBackend) Save(namespace string, values map[string]string) error {
+	m.saved[namespace] = values
+	m.saves++
+	return nil
+}
+
+func (m *memoryBackend) Close() error {
+	return nil
+}
+
+// fakeRedis serves AUTH, SELECT, GET and SET, with the password "secret".
+type fakeRedis struct {
+	net.Listener
+	mu   sync.Mutex
+	keys map[string]string
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	srv := &fakeRedis{Listener: l, keys: map[string]string{}}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go srv.serve(conn)
+		}
+	}()
+	return srv
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rdr := bufio.NewReader(conn)
+	db := "0"
+	for {
+		args, err := readCommand(rdr)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			reply = "+OK\r\n"
+			if args[1] != "secret" {
+				reply = "-ERR invalid password\r\n"
+			}
+		case "SELECT":
+			db, reply = args[1], "+OK\r\n"
+		case "GET":
+			reply = "$-1\r\n"
+			if v, ok := f.keys[db+"/"+args[1]]; ok {
+				reply = "$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n"
+			}
+		case "SET":
+			f.keys[db+"/"+args[1]], reply = args[2], "+OK\r\n"
+		}
+		f.mu.Unlock()
+		io.WriteString(conn, reply)
+	}
+}
+
+func readCommand(rdr *bufio.Reader) ([]string, error) {
+	line, err := rdr.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = rdr.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rdr, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
@@ -0,0 +1,255 @@
+package usage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// profile is the part of a CPU profile the usage is attributed from.
+type profile struct {
+	samples []sample
+}
+
+// sample is a sample of a CPU profile.
+type sample struct {
+	// label is the value of the labelKey label, if any
+	label string
+	// cpu is the CPU time of the sample, in ns
+	cpu int64
+	// inMalloc is true if the memory allocator is in the stack of the sample
+	inMalloc bool
+}
+
+// The decoded fields of the profile.proto messages.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+)
+
+const (
+	sampleLocationID = 1
+	sampleValue      = 2
+	sampleLabel      = 3
+
+	labelKeyField = 1
+	labelStr      = 2
+
+	locationID   = 1
+	locationLine = 4
+	lineFunction = 1
+
+	functionID   = 1
+	functionName = 2
+
+	valueTypeType = 1
+)
+
+// rawSample is a sample before its strings and locations are resolved.
+type rawSample struct {
+	locations []uint64
+	values    []int64
+	labels    [][2]int64
+}
+
+// parseProfile decodes the samples of a gzipped CPU profile, in the protocol
+// buffer format of pprof.
+func parseProfile(data []byte) (*profile, error) {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	data, err = ioutil.ReadAll(zr)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sampleTypes []int64
+		raw         []rawSample
+		table       []string
+		// functions of each location, names of each function
+		locations = make(map[uint64][]uint64)
+		functions = make(map[uint64]int64)
+	)
+	err = protowire.Range(data, func(f protowire.Field) error {
+		if f.Wire != protowire.WireBytes {
+			return nil
+		}
+		switch f.Number {
+		case profileSampleType:
+			t, err := decodeValueType(f.Raw)
+			if err != nil {
+				return err
+			}
+			sampleTypes = append(sampleTypes, t)
+		case profileSample:
+			s, err := decodeSample(f.Raw)
+			if err != nil {
+				return err
+			}
+			raw = append(raw, s)
+		case profileLocation:
+			id, funcs, err := decodeLocation(f.Raw)
+			if err != nil {
+				return err
+			}
+			locations[id] = funcs
+		case profileFunction:
+			id, name, err := decodeFunction(f.Raw)
+			if err != nil {
+				return err
+			}
+			functions[id] = name
+		case profileStringTable:
+			table = append(table, string(f.Raw))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	str := func(i int64) string {
+		if i < 0 || int(i) >= len(table) {
+			return ""
+		}
+		return table[i]
+	}
+	// the CPU time is the value of type "cpu", the last one if none is
+	cpuIndex := len(sampleTypes) - 1
+	for i, t := range sampleTypes {
+		if str(t) == "cpu" {
+			cpuIndex = i
+		}
+	}
+	mallocs := make(map[uint64]bool)
+	for id, funcs := range locations {
+		for _, f := range funcs {
+			if str(functions[f]) == mallocFunction {
+				mallocs[id] = true
+			}
+		}
+	}
+
+	p := &profile{}
+	for _, r := range raw {
+		if cpuIndex < 0 || cpuIndex >= len(r.values) {
+			continue
+		}
+		s := sample{cpu: r.values[cpuIndex]}
+		for _, l := range r.labels {
+			if str(l[0]) == labelKey {
+				s.label = str(l[1])
+			}
+		}
+		for _, loc := range r.locations {
+			if mallocs[loc] {
+				s.inMalloc = true
+			}
+		}
+		p.samples = append(p.samples, s)
+	}
+	return p, nil
+}
+
+func decodeValueType(msg []byte) (int64, error) {
+	var typ int64
+	err := protowire.Range(msg, func(f protowire.Field) error {
+		if f.Number == valueTypeType && f.Wire == protowire.WireVarint {
+			typ = int64(f.Value)
+		}
+		return nil
+	})
+	return typ, err
+}
+
+func decodeSample(msg []byte) (rawSample, error) {
+	var s rawSample
+	err := protowire.Range(msg, func(f protowire.Field) error {
+		switch f.Number {
+		case sampleLocationID:
+			return uint64s(f, func(v uint64) {
+				s.locations = append(s.locations, v)
+			})
+		case sampleValue:
+			return uint64s(f, func(v uint64) {
+				s.values = append(s.values, int64(v))
+			})
+		case sampleLabel:
+			if f.Wire != protowire.WireBytes {
+				return nil
+			}
+			var label [2]int64
+			err := protowire.Range(f.Raw, func(f protowire.Field) error {
+				if (f.Number == labelKeyField || f.Number == labelStr) &&
+					f.Wire == protowire.WireVarint {
+					label[f.Number-1] = int64(f.Value)
+				}
+				return nil
+			})
+			s.labels = append(s.labels, label)
+			return err
+		}
+		return nil
+	})
+	return s, err
+}
+
+func decodeLocation(msg []byte) (uint64, []uint64, error) {
+	var id uint64
+	var funcs []uint64
+	err := protowire.Range(msg, func(f protowire.Field) error {
+		switch {
+		case f.Number == locationID && f.Wire == protowire.WireVarint:
+			id = f.Value
+		case f.Number == locationLine && f.Wire == protowire.WireBytes:
+			return protowire.Range(f.Raw, func(f protowire.Field) error {
+				if f.Number == lineFunction && f.Wire == protowire.WireVarint {
+					funcs = append(funcs, f.Value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	return id, funcs, err
+}
+
+func decodeFunction(msg []byte) (uint64, int64, error) {
+	var id uint64
+	var name int64
+	err := protowire.Range(msg, func(f protowire.Field) error {
+		if f.Wire != protowire.WireVarint {
+			return nil
+		}
+		switch f.Number {
+		case functionID:
+			id = f.Value
+		case functionName:
+			name = int64(f.Value)
+		}
+		return nil
+	})
+	return id, name, err
+}
+
+// uint64s decodes a repeated integer field, packed or not.
+func uint64s(f protowire.Field, fn func(uint64)) error {
+	switch f.Wire {
+	case protowire.WireVarint:
+		fn(f.Value)
+	case protowire.WireBytes:
+		return protowire.Packed(f.Raw, protowire.WireVarint,
+			func(v uint64) error {
+				fn(v)
+				return nil
+			})
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package usage attributes the CPU time and memory allocations of telegraf to
+// the plugins that cause them, approximately, by sampling CPU profiles.
+//
+// The work of a plugin runs with a pprof label naming its Account, which the
+// goroutines it starts inherit. The samples of a CPU profile carrying the
+// label are the CPU time of the plugin, and the bytes allocated while the
+// profile ran are split between the plugins in proportion to their samples in
+// the memory allocator.
+//
+// Labels are only supported by Go 1.9 or later. Built with an older Go, Do
+// runs f with no label and Run samples nothing.
+package usage
+
+import (
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// labelKey is the pprof label naming the account of the running plugin.
+const labelKey = "telegraf_plugin"
+
+// mallocFunction is the function of the memory allocator in stack traces.
+const mallocFunction = "runtime.mallocgc"
+
+// Account is the usage attributed to a plugin. The zero value is ready to
+// use.
+type Account struct {
+	// cpu is the CPU time in ns, allocated the allocated bytes
+	cpu       int64
+	allocated int64
+
+	once sync.Once
+	id   string
+}
+
+// accounts are the accounts by their label value.
+var accounts struct {
+	sync.Mutex
+	byID map[string]*Account
+	next int
+}
+
+// sampling is 1 while the profiles are sampled.
+var sampling int32
+
+// Sampling returns true if the usage of the plugins is being sampled.
+func Sampling() bool {
+	return atomic.LoadInt32(&sampling) == 1
+}
+
+func (a *Account) register() {
+	accounts.Lock()
+	defer accounts.Unlock()
+	if accounts.byID == nil {
+		accounts.byID = make(map[string]*Account)
+	}
+	accounts.next++
+	a.id = strconv.Itoa(accounts.next)
+	accounts.byID[a.id] = a
+}
+
+// CPUTime returns the CPU time attributed to the account.
+func (a *Account) CPUTime() time.Duration {
+	return time.Duration(atomic.LoadInt64(&a.cpu))
+}
+
+// Allocated returns the bytes allocated attributed to the account.
+func (a *Account) Allocated() int64 {
+	return atomic.LoadInt64(&a.allocated)
+}
+
+func totalAlloc() uint64 {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.TotalAlloc
+}
+
+// attribute adds the CPU time of a profile's labeled samples to their
+// accounts, and splits the bytes allocated during the profile between them.
+func attribute(p *profile, allocated int64) {
+	cpu := make(map[string]int64)
+	malloc := make(map[string]int64)
+	var totalMalloc int64
+	for _, s := range p.samples {
+		if s.inMalloc {
+			totalMalloc += s.cpu
+		}
+		if s.label == "" {
+			continue
+		}
+		cpu[s.label] += s.cpu
+		if s.inMalloc {
+			malloc[s.label] += s.cpu
+		}
+	}
+
+	accounts.Lock()
+	defer accounts.Unlock()
+	for id, ns := range cpu {
+		a, ok := accounts.byID[id]
+		if !ok {
+			continue
+		}
+		atomic.AddInt64(&a.cpu, ns)
+		if totalMalloc > 0 {
+			share := float64(malloc[id]) / float64(totalMalloc)
+			atomic.AddInt64(&a.allocated, int64(share*float64(allocated)))
+		}
+	}
+}
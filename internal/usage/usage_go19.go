@@ -0,0 +1,66 @@
+// +build go1.9
+
+package usage
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"runtime/pprof"
+	"sync/atomic"
+	"time"
+)
+
+// Do runs f with the usage it causes, in its goroutine and those it starts,
+// attributed to the account.
+func (a *Account) Do(f func()) {
+	a.once.Do(a.register)
+	pprof.Do(context.Background(), pprof.Labels(labelKey, a.id),
+		func(context.Context) { f() })
+}
+
+// Run samples CPU profiles of the given length back to back, attributing
+// their usage to the accounts, until shutdown is closed. It returns at once
+// if another CPU profile is running.
+func Run(window time.Duration, shutdown chan struct{}) {
+	atomic.StoreInt32(&sampling, 1)
+	defer atomic.StoreInt32(&sampling, 0)
+	for {
+		stopped, err := profileOnce(window, shutdown)
+		if err != nil {
+			log.Printf("ERROR sampling the usage of the plugins: %s\n", err)
+			return
+		}
+		if stopped {
+			return
+		}
+	}
+}
+
+// profileOnce profiles the CPU for the given window, or until shutdown is
+// closed, and attributes the usage. It returns true if shutdown was closed.
+func profileOnce(window time.Duration, shutdown chan struct{}) (bool, error) {
+	var buf bytes.Buffer
+	if err := pprof.StartCPUProfile(&buf); err != nil {
+		return false, err
+	}
+	before := totalAlloc()
+
+	stopped := false
+	timer := time.NewTimer(window)
+	select {
+	case <-shutdown:
+		stopped = true
+	case <-timer.C:
+	}
+	timer.Stop()
+
+	pprof.StopCPUProfile()
+	allocated := totalAlloc() - before
+	p, err := parseProfile(buf.Bytes())
+	if err != nil {
+		return stopped, err
+	}
+	attribute(p, int64(allocated))
+	return stopped, nil
+}
@@ -0,0 +1,39 @@
+// +build go1.9
+
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var sink []byte
+
+// Verify that the CPU time and allocations of the work run by an account are
+// attributed to it, and not to another.
+func TestProfileOnce(t *testing.T) {
+	busy, idle := &Account{}, &Account{}
+	idle.Do(func() {})
+
+	done := make(chan struct{})
+	go busy.Do(func() {
+		defer close(done)
+		deadline := time.Now().Add(500 * time.Millisecond)
+		for time.Now().Before(deadline) {
+			sink = make([]byte, 4096)
+		}
+	})
+
+	stopped, err := profileOnce(time.Second, nil)
+	require.NoError(t, err)
+	assert.False(t, stopped)
+	<-done
+
+	assert.True(t, busy.CPUTime() > 0)
+	assert.True(t, busy.Allocated() > 0)
+	assert.Equal(t, time.Duration(0), idle.CPUTime())
+	assert.Equal(t, int64(0), idle.Allocated())
+}
@@ -0,0 +1,20 @@
+// +build !go1.9
+
+package usage
+
+import (
+	"log"
+	"time"
+)
+
+// Do runs f. The usage is not attributed without pprof labels.
+func (a *Account) Do(f func()) {
+	a.once.Do(a.register)
+	f()
+}
+
+// Run returns at once, as the usage is not sampled without pprof labels.
+func Run(window time.Duration, shutdown chan struct{}) {
+	log.Printf("ERROR sampling the usage of the plugins: " +
+		"requires telegraf built with Go 1.9 or later\n")
+}
@@ -0,0 +1,30 @@
+package usage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Verify that sampling stops when shutdown is closed.
+func TestRunShutdown(t *testing.T) {
+	shutdown := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		Run(time.Hour, shutdown)
+		close(done)
+	}()
+	close(shutdown)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run did not return after shutdown")
+	}
+	assert.False(t, Sampling())
+}
+
+func TestParseProfileInvalid(t *testing.T) {
+	_, err := parseProfile([]byte("not a profile"))
+	assert.Error(t, err)
+}
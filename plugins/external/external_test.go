@@ -0,0 +1,258 @@
+package external
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/parsers"
+	"github.com/influxdata/telegraf/plugins/serializers"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/influxdata/toml"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const inputScript = `#!/bin/sh
+while read line; do
+  echo "example,source=script value=42i 1465839830100400200"
+done
+`
+
+const outputScript = `#!/bin/sh
+echo "$TELEGRAF_PLUGIN_CONFIG" > "$(dirname $0)/config"
+cat > "$(dirname $0)/out"
+`
+
+func writeFile(t *testing.T, dir, name, contents string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(contents), 0755))
+	return path
+}
+
+func unregister(typ, name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(registered, typ+"."+name)
+	if typ == "input" {
+		delete(inputs.Inputs, name)
+	} else {
+		delete(outputs.Outputs, name)
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := writeFile(t, dir, "random.toml", `
+type = "input"
+name = "random"
+description = "Generate random numbers"
+command = ["bin/random", "--seed", "42"]
+signal = "stdin"
+sample_config = '''
+  max = 100
+'''
+`)
+	m, err := LoadManifest(path)
+	require.NoError(t, err)
+	assert.Equal(t, "input", m.Type)
+	assert.Equal(t, "random", m.Name)
+	assert.Equal(t, "Generate random numbers", m.Description)
+	assert.Equal(t,
+		[]string{filepath.Join(dir, "bin/random"), "--seed", "42"}, m.Command)
+	assert.Equal(t, "stdin", m.Signal)
+	assert.Equal(t, "  max = 100\n", m.SampleConfig)
+}
+
+func TestLoadManifestInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	manifests := []string{
+		`type = "aggregator"
+name = "a"
+command = ["a"]`,
+		`type = "input"
+command = ["a"]`,
+		`type = "input"
+name = "a"`,
+		`type = "input"
+name = "a"
+command = ["a"]
+signal = "SIGHUP"`,
+	}
+	for _, contents := range manifests {
+		_, err := LoadManifest(writeFile(t, dir, "a.toml", contents))
+		assert.Error(t, err, contents)
+	}
+}
+
+func TestLoadDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	writeFile(t, dir, "a.toml", `
+type = "input"
+name = "external_test_a"
+command = ["a"]
+`)
+	writeFile(t, dir, "b.toml", `
+type = "output"
+name = "external_test_b"
+command = ["b"]
+`)
+	writeFile(t, dir, "README.md", "not a manifest")
+
+	require.NoError(t, LoadDirectory(dir))
+	defer unregister("input", "external_test_a")
+	defer unregister("output", "external_test_b")
+
+	assert.IsType(t, &Input{}, inputs.Inputs["external_test_a"]())
+	assert.IsType(t, &Output{}, outputs.Outputs["external_test_b"]())
+
+	// loading the same directory again is fine
+	require.NoError(t, LoadDirectory(dir))
+}
+
+func TestRegisterConflict(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	inputs.Add("external_test_builtin", func() telegraf.Input { return nil })
+	defer delete(inputs.Inputs, "external_test_builtin")
+
+	m, err := LoadManifest(writeFile(t, dir, "a.toml", `
+type = "input"
+name = "external_test_builtin"
+command = ["a"]
+`))
+	require.NoError(t, err)
+	assert.Error(t, Register(m))
+}
+
+func TestInput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test, requires a shell")
+	}
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := NewInput(&Manifest{
+		Name:    "example",
+		Command: []string{writeFile(t, dir, "input.sh", inputScript)},
+		Signal:  "stdin",
+	})
+	parser, err := parsers.NewInfluxParser()
+	require.NoError(t, err)
+	e.SetParser(parser)
+
+	var acc testutil.Accumulator
+	require.NoError(t, e.Start(&acc))
+	defer e.Stop()
+
+	require.NoError(t, e.Gather(&acc))
+	for i := 0; i < 100 && acc.NFields() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	acc.AssertContainsTaggedFields(t, "example",
+		map[string]interface{}{"value": int64(42)},
+		map[string]string{"source": "script"})
+}
+
+func TestInputStopAfterFailedStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the binary does not exist
+	e := NewInput(&Manifest{
+		Name:    "example",
+		Command: []string{filepath.Join(dir, "missing")},
+	})
+	var acc testutil.Accumulator
+	require.Error(t, e.Start(&acc))
+	e.Stop()
+
+	// the options cannot be passed to the binary
+	e = NewInput(&Manifest{
+		Name:    "example",
+		Command: []string{filepath.Join(dir, "missing")},
+	})
+	e.options = map[string]interface{}{"invalid": make(chan int)}
+	require.Error(t, e.Start(&acc))
+	e.Stop()
+}
+
+func TestOutput(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Skipping test, requires a shell")
+	}
+	dir, err := ioutil.TempDir("", "external")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e := NewOutput(&Manifest{
+		Name:    "example",
+		Command: []string{writeFile(t, dir, "output.sh", outputScript)},
+	})
+	e.options = map[string]interface{}{"url": "http://localhost"}
+	serializer, err := serializers.NewInfluxSerializer()
+	require.NoError(t, err)
+	e.SetSerializer(serializer)
+
+	require.NoError(t, e.Connect())
+	require.NoError(t, e.Write([]telegraf.Metric{testutil.TestMetric(1.0)}))
+	require.NoError(t, e.Close())
+
+	out, err := ioutil.ReadFile(filepath.Join(dir, "out"))
+	require.NoError(t, err)
+	assert.Equal(t, "test1,tag1=value1 value=1 1257894000000000000\n",
+		string(out))
+
+	config, err := ioutil.ReadFile(filepath.Join(dir, "config"))
+	require.NoError(t, err)
+	assert.Equal(t, "{\"url\":\"http://localhost\"}\n", string(config))
+}
+
+func TestUnmarshalSandbox(t *testing.T) {
+	tbl, err := toml.Parse([]byte(`
+url = "http://localhost"
+restart_delay = "1s"
+
+[sandbox]
+  user = "nobody"
+  restrict_environment = true
+  environment = ["PATH"]
+  memory_limit = 1024
+
+[headers]
+  accept = "text/plain"
+`))
+	require.NoError(t, err)
+
+	e := NewInput(&Manifest{Name: "example"})
+	require.NoError(t, e.UnmarshalTable(tbl))
+	assert.Equal(t, map[string]interface{}{
+		"url":     "http://localhost",
+		"headers": map[string]interface{}{"accept": "text/plain"},
+	}, e.options)
+	assert.Equal(t, time.Second, e.RestartDelay.Duration)
+	assert.Equal(t, "nobody", e.Sandbox.User)
+	assert.True(t, e.Sandbox.RestrictEnvironment)
+	assert.Equal(t, []string{"PATH"}, e.Sandbox.Environment)
+	assert.Equal(t, int64(1024), e.Sandbox.MemoryLimit)
+}
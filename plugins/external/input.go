@@ -0,0 +1,202 @@
+package external
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/sandbox"
+	"github.com/influxdata/telegraf/plugins/parsers"
+
+	"github.com/influxdata/config"
+	"github.com/influxdata/toml/ast"
+)
+
+const inputSampleConfig = `
+  ## Delay before restarting the plugin after it exits.
+  # restart_delay = "10s"
+
+  ## Restrict the plugin's process, Linux only, as with the exec input.
+  # [inputs.<name>.sandbox]
+  #   user = "nobody"
+  #   no_new_privileges = true
+  #   restrict_environment = true
+  #   environment = ["PATH"]
+  #   cpu_limit = 0.5
+  #   memory_limit = 268435456
+
+  ## Data format the plugin writes to stdout.
+  ## Each data format has it's own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_INPUT.md
+  data_format = "influx"
+`
+
+// configEnv is the environment variable holding the JSON encoded options of
+// the plugin's table in the telegraf config.
+const configEnv = "TELEGRAF_PLUGIN_CONFIG"
+
+// Input is a service input that runs an external plugin binary, and parses
+// the metrics it writes to stdout, one per line.
+type Input struct {
+	RestartDelay internal.Duration `toml:"restart_delay"`
+	Sandbox      sandbox.Sandbox   `toml:"sandbox"`
+
+	manifest *Manifest
+	// options are the settings of the plugin's table, passed to the binary
+	options map[string]interface{}
+	parser  parsers.Parser
+	process *process
+}
+
+// NewInput returns an Input running the binary of the given manifest.
+func NewInput(m *Manifest) *Input {
+	return &Input{
+		RestartDelay: internal.Duration{Duration: 10 * time.Second},
+		manifest:     m,
+	}
+}
+
+func (e *Input) Description() string {
+	return e.manifest.Description
+}
+
+func (e *Input) SampleConfig() string {
+	return sampleConfig(e.manifest,
+		strings.Replace(inputSampleConfig, "<name>", e.manifest.Name, -1))
+}
+
+func (e *Input) SetParser(parser parsers.Parser) {
+	e.parser = parser
+}
+
+// UnmarshalTable decodes the plugin's table. The options defined by the
+// external plugin rather than by telegraf are kept to pass to the binary.
+func (e *Input) UnmarshalTable(tbl *ast.Table) error {
+	options, err := unmarshalOptions(tbl, e)
+	if err != nil {
+		return err
+	}
+	e.options = options
+	return nil
+}
+
+func (e *Input) Start(acc telegraf.Accumulator) error {
+	env, err := optionsEnv(e.options)
+	if err != nil {
+		return err
+	}
+	p := newProcess("inputs."+e.manifest.Name, e.manifest.Command, env,
+		&e.Sandbox, e.RestartDelay.Duration, func(r io.Reader) { e.read(r, acc) })
+	if err := p.Start(); err != nil {
+		return err
+	}
+	e.process = p
+	return nil
+}
+
+func (e *Input) read(r io.Reader, acc telegraf.Accumulator) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		metrics, err := e.parser.Parse(line)
+		if err != nil {
+			log.Printf("ERROR [inputs.%s]: %s\n", e.manifest.Name, err)
+			continue
+		}
+		for _, m := range metrics {
+			acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+		}
+	}
+}
+
+// Gather asks the plugin for metrics if its signal is "stdin". The metrics are
+// added to the accumulator as they are read.
+func (e *Input) Gather(acc telegraf.Accumulator) error {
+	if e.process == nil || e.manifest.Signal != "stdin" {
+		return nil
+	}
+	return e.process.Write([]byte("\n"))
+}
+
+func (e *Input) Stop() {
+	// the process is not running if Start failed
+	if e.process == nil {
+		return
+	}
+	e.process.Stop()
+	e.process = nil
+}
+
+// unmarshalOptions decodes the restart_delay option and the sandbox table of
+// a plugin's table into v, and returns its other options.
+func unmarshalOptions(tbl *ast.Table, v interface{}) (
+	map[string]interface{}, error) {
+	own, other := *tbl, *tbl
+	own.Fields = map[string]interface{}{}
+	other.Fields = map[string]interface{}{}
+	for key, val := range tbl.Fields {
+		if key == "restart_delay" || key == "sandbox" {
+			own.Fields[key] = val
+		} else {
+			other.Fields[key] = val
+		}
+	}
+	if err := config.UnmarshalTable(&own, v); err != nil {
+		return nil, err
+	}
+	return tableOptions(&other)
+}
+
+// tableOptions returns a table's options, with the subtable options as
+// nested maps.
+func tableOptions(tbl *ast.Table) (map[string]interface{}, error) {
+	options := map[string]interface{}{}
+	values := *tbl
+	values.Fields = map[string]interface{}{}
+	for key, val := range tbl.Fields {
+		switch v := val.(type) {
+		case *ast.Table:
+			sub, err := tableOptions(v)
+			if err != nil {
+				return nil, err
+			}
+			options[key] = sub
+		case []*ast.Table:
+			var subs []interface{}
+			for _, t := range v {
+				sub, err := tableOptions(t)
+				if err != nil {
+					return nil, err
+				}
+				subs = append(subs, sub)
+			}
+			options[key] = subs
+		default:
+			values.Fields[key] = val
+		}
+	}
+	if err := config.UnmarshalTable(&values, options); err != nil {
+		return nil, err
+	}
+	return options, nil
+}
+
+func optionsEnv(options map[string]interface{}) ([]string, error) {
+	if len(options) == 0 {
+		return nil, nil
+	}
+	b, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+	return []string{configEnv + "=" + string(b)}, nil
+}
@@ -0,0 +1,183 @@
+package external
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+	"github.com/influxdata/telegraf/plugins/outputs"
+
+	"github.com/influxdata/toml"
+)
+
+// Manifest describes an external plugin binary. Manifests are TOML files with
+// a .toml extension, for example:
+//
+//	type = "input"
+//	name = "random"
+//	description = "Generate random numbers"
+//	command = ["./random", "--seed", "42"]
+//	signal = "stdin"
+//	sample_config = '''
+//	  ## maximum value generated
+//	  max = 100
+//	'''
+type Manifest struct {
+	// Type is either "input" or "output"
+	Type string
+	// Name is the name the plugin is configured with, such as [[inputs.random]]
+	Name        string
+	Description string
+	// Command is the binary to run followed by its arguments. A relative path
+	// to the binary is relative to the directory of the manifest.
+	Command []string
+	// Signal is how an input is told to gather: "none" if the plugin emits
+	// metrics on its own schedule, or "stdin" to write a newline to its stdin
+	// at every interval.
+	Signal       string
+	SampleConfig string
+
+	// path is the file the manifest was loaded from
+	path string
+}
+
+var (
+	mu sync.Mutex
+	// registered maps "<type>.<name>" to the path of the manifest that
+	// registered it
+	registered = map[string]string{}
+)
+
+// LoadManifest reads and validates the manifest at the given path.
+func LoadManifest(path string) (*Manifest, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := toml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("Error parsing %s, %s", path, err)
+	}
+	m.path = path
+
+	switch m.Type {
+	case "input", "output":
+	default:
+		return nil, fmt.Errorf("%s: type must be \"input\" or \"output\", "+
+			"not %q", path, m.Type)
+	}
+	if m.Name == "" {
+		return nil, fmt.Errorf("%s: name is required", path)
+	}
+	if len(m.Command) == 0 {
+		return nil, fmt.Errorf("%s: command is required", path)
+	}
+	switch m.Signal {
+	case "":
+		m.Signal = "none"
+	case "none", "stdin":
+	default:
+		return nil, fmt.Errorf("%s: signal must be \"none\" or \"stdin\", "+
+			"not %q", path, m.Signal)
+	}
+
+	bin := m.Command[0]
+	if strings.ContainsRune(bin, '/') && !filepath.IsAbs(bin) {
+		m.Command[0] = filepath.Join(filepath.Dir(path), bin)
+	}
+	return m, nil
+}
+
+// LoadDirectory loads every manifest in the given directory and registers the
+// plugins they describe, so that they can be configured like any other
+// plugin. Loading the same directory again, such as on a config reload,
+// picks up new and updated manifests.
+func LoadDirectory(path string) error {
+	files, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasSuffix(f.Name(), ".toml") {
+			continue
+		}
+		m, err := LoadManifest(filepath.Join(path, f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := Register(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Register adds the plugin described by the manifest to the input or output
+// registry. It is an error to register a plugin with the name of a built-in
+// plugin, or of a plugin registered by another manifest.
+func Register(m *Manifest) error {
+	mu.Lock()
+	defer mu.Unlock()
+
+	key := m.Type + "." + m.Name
+	if path, ok := registered[key]; ok {
+		if !sameFile(path, m.path) {
+			return fmt.Errorf("%s: %s %s is already registered by %s",
+				m.path, m.Type, m.Name, path)
+		}
+	} else {
+		var exists bool
+		if m.Type == "input" {
+			_, exists = inputs.Inputs[m.Name]
+		} else {
+			_, exists = outputs.Outputs[m.Name]
+		}
+		if exists {
+			return fmt.Errorf("%s: %s %s conflicts with a built-in plugin",
+				m.path, m.Type, m.Name)
+		}
+	}
+	registered[key] = m.path
+
+	if m.Type == "input" {
+		inputs.Add(m.Name, func() telegraf.Input {
+			return NewInput(m)
+		})
+	} else {
+		outputs.Add(m.Name, func() telegraf.Output {
+			return NewOutput(m)
+		})
+	}
+	return nil
+}
+
+// sampleConfig returns the sample config of the manifest followed by the
+// options telegraf handles for the plugin.
+func sampleConfig(m *Manifest, options string) string {
+	sample := strings.Trim(m.SampleConfig, "\n")
+	if sample == "" {
+		return options
+	}
+	return "\n" + sample + "\n" + options
+}
+
+func sameFile(a, b string) bool {
+	if a == b {
+		return true
+	}
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
@@ -0,0 +1,122 @@
+package external
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/sandbox"
+	"github.com/influxdata/telegraf/plugins/serializers"
+
+	"github.com/influxdata/toml/ast"
+)
+
+const outputSampleConfig = `
+  ## Delay before restarting the plugin after it exits.
+  # restart_delay = "10s"
+
+  ## Restrict the plugin's process, Linux only, as with the exec input.
+  # [outputs.<name>.sandbox]
+  #   user = "nobody"
+  #   no_new_privileges = true
+
+  ## Data format to write to the plugin's stdin.
+  ## Each data format has it's own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+// Output is an output that runs an external plugin binary, and writes metrics
+// to its stdin, one per line.
+type Output struct {
+	RestartDelay internal.Duration `toml:"restart_delay"`
+	Sandbox      sandbox.Sandbox   `toml:"sandbox"`
+
+	manifest   *Manifest
+	options    map[string]interface{}
+	serializer serializers.Serializer
+	process    *process
+}
+
+// NewOutput returns an Output running the binary of the given manifest.
+func NewOutput(m *Manifest) *Output {
+	return &Output{
+		RestartDelay: internal.Duration{Duration: 10 * time.Second},
+		manifest:     m,
+	}
+}
+
+func (e *Output) Description() string {
+	return e.manifest.Description
+}
+
+func (e *Output) SampleConfig() string {
+	return sampleConfig(e.manifest,
+		strings.Replace(outputSampleConfig, "<name>", e.manifest.Name, -1))
+}
+
+func (e *Output) SetSerializer(serializer serializers.Serializer) {
+	e.serializer = serializer
+}
+
+// UnmarshalTable decodes the plugin's table. The options defined by the
+// external plugin rather than by telegraf are kept to pass to the binary.
+func (e *Output) UnmarshalTable(tbl *ast.Table) error {
+	options, err := unmarshalOptions(tbl, e)
+	if err != nil {
+		return err
+	}
+	e.options = options
+	return nil
+}
+
+func (e *Output) Connect() error {
+	env, err := optionsEnv(e.options)
+	if err != nil {
+		return err
+	}
+	p := newProcess("outputs."+e.manifest.Name, e.manifest.Command, env,
+		&e.Sandbox, e.RestartDelay.Duration, e.logStdout)
+	if err := p.Start(); err != nil {
+		return err
+	}
+	e.process = p
+	return nil
+}
+
+// logStdout logs anything the plugin writes to stdout.
+func (e *Output) logStdout(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Printf("[outputs.%s] %s\n", e.manifest.Name, scanner.Text())
+	}
+}
+
+func (e *Output) Close() error {
+	if e.process != nil {
+		e.process.Stop()
+		e.process = nil
+	}
+	return nil
+}
+
+func (e *Output) Write(metrics []telegraf.Metric) error {
+	var buf bytes.Buffer
+	for _, m := range metrics {
+		lines, err := e.serializer.Serialize(m)
+		if err != nil {
+			return err
+		}
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return e.process.Write(buf.Bytes())
+}
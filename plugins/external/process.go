@@ -0,0 +1,181 @@
+package external
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/sandbox"
+)
+
+// stopTimeout is how long a plugin has to exit after its stdin is closed
+// before it is killed.
+const stopTimeout = 5 * time.Second
+
+// process runs an external plugin binary, and restarts it whenever it exits
+// until it is stopped.
+type process struct {
+	// name is used to prefix the log messages, such as "inputs.random"
+	name         string
+	command      []string
+	env          []string
+	sandbox      *sandbox.Sandbox
+	restartDelay time.Duration
+	// readStdout is called with the stdout of every started process, and
+	// returns once it is closed.
+	readStdout func(io.Reader)
+
+	mu    sync.Mutex
+	cmd   *exec.Cmd
+	stdin io.WriteCloser
+
+	done   chan struct{}
+	exited chan struct{}
+}
+
+func newProcess(
+	name string,
+	command []string,
+	env []string,
+	sb *sandbox.Sandbox,
+	restartDelay time.Duration,
+	readStdout func(io.Reader),
+) *process {
+	return &process{
+		name:         name,
+		command:      command,
+		env:          env,
+		sandbox:      sb,
+		restartDelay: restartDelay,
+		readStdout:   readStdout,
+	}
+}
+
+// Start starts the process. An error is only returned if the first start
+// fails, later restarts are logged.
+func (p *process) Start() error {
+	p.done = make(chan struct{})
+	p.exited = make(chan struct{})
+
+	cmd, stdout, err := p.start()
+	if err != nil {
+		return err
+	}
+	go p.run(cmd, stdout)
+	return nil
+}
+
+func (p *process) start() (*exec.Cmd, io.Reader, error) {
+	cmd := exec.Command(p.command[0], p.command[1:]...)
+	cmd.Env = append(p.sandbox.Environ(), p.env...)
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := p.sandbox.Start(cmd, p.name); err != nil {
+		return nil, nil, fmt.Errorf("error starting %s: %s", p.command[0], err)
+	}
+	go p.logStderr(stderr)
+
+	p.mu.Lock()
+	p.cmd = cmd
+	p.stdin = stdin
+	p.mu.Unlock()
+	return cmd, stdout, nil
+}
+
+// run waits for the process to exit and restarts it, until Stop is called.
+func (p *process) run(cmd *exec.Cmd, stdout io.Reader) {
+	defer close(p.exited)
+	for {
+		p.readStdout(stdout)
+		err := cmd.Wait()
+
+		p.mu.Lock()
+		p.stdin = nil
+		p.mu.Unlock()
+
+		select {
+		case <-p.done:
+			return
+		default:
+		}
+		log.Printf("ERROR [%s]: process exited: %v, restarting in %s\n",
+			p.name, err, p.restartDelay)
+
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-time.After(p.restartDelay):
+			}
+			cmd, stdout, err = p.start()
+			if err == nil {
+				break
+			}
+			log.Printf("ERROR [%s]: %s, retrying in %s\n",
+				p.name, err, p.restartDelay)
+		}
+	}
+}
+
+func (p *process) logStderr(r io.Reader) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		log.Printf("[%s] %s\n", p.name, scanner.Text())
+	}
+}
+
+// Write writes b to the stdin of the process.
+func (p *process) Write(b []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stdin == nil {
+		return errors.New("process is not running")
+	}
+	_, err := p.stdin.Write(b)
+	return err
+}
+
+// Stop closes the stdin of the process and waits for it to exit, killing it
+// if it does not exit within stopTimeout.
+func (p *process) Stop() {
+	close(p.done)
+
+	p.mu.Lock()
+	if p.stdin != nil {
+		p.stdin.Close()
+	}
+	p.mu.Unlock()
+
+	select {
+	case <-p.exited:
+	case <-time.After(stopTimeout):
+		log.Printf("ERROR [%s]: process did not exit within %s, killing it\n",
+			p.name, stopTimeout)
+		p.kill()
+		<-p.exited
+	}
+}
+
+func (p *process) kill() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.cmd != nil && p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+}
@@ -17,8 +17,10 @@ const (
 	measurement = "ceph"
 	typeMon     = "monitor"
 	typeOsd     = "osd"
+	typeRgw     = "rgw"
 	osdPrefix   = "ceph-osd"
 	monPrefix   = "ceph-mon"
+	rgwPrefix   = "ceph-client.rgw"
 	sockSuffix  = "asok"
 )
 
@@ -26,8 +28,14 @@ type Ceph struct {
 	CephBinary   string
 	OsdPrefix    string
 	MonPrefix    string
+	RgwPrefix    string
 	SocketDir    string
 	SocketSuffix string
+	CephUser     string
+	CephConfig   string
+
+	GatherAdminSocketStats bool
+	GatherClusterStats     bool
 }
 
 func (c *Ceph) setDefaults() {
@@ -43,6 +51,10 @@ func (c *Ceph) setDefaults() {
 		c.MonPrefix = monPrefix
 	}
 
+	if c.RgwPrefix == "" {
+		c.RgwPrefix = rgwPrefix
+	}
+
 	if c.SocketDir == "" {
 		c.SocketDir = "/var/run/ceph"
 	}
@@ -50,10 +62,18 @@ func (c *Ceph) setDefaults() {
 	if c.SocketSuffix == "" {
 		c.SocketSuffix = sockSuffix
 	}
+
+	if c.CephUser == "" {
+		c.CephUser = "client.admin"
+	}
+
+	if c.CephConfig == "" {
+		c.CephConfig = "/etc/ceph/ceph.conf"
+	}
 }
 
 func (c *Ceph) Description() string {
-	return "Collects performance metrics from the MON and OSD nodes in a Ceph storage cluster."
+	return "Collects performance metrics from the MON, OSD and RGW nodes in a Ceph storage cluster."
 }
 
 var sampleConfig = `
@@ -65,12 +85,27 @@ var sampleConfig = `
   ## directory in which to look for socket files
   socket_dir = "/var/run/ceph"
 
-  ## prefix of MON and OSD socket files, used to determine socket type
+  ## prefix of MON, OSD and RGW socket files, used to determine socket type
   mon_prefix = "ceph-mon"
   osd_prefix = "ceph-osd"
+  rgw_prefix = "ceph-client.rgw"
 
   ## suffix used to identify socket files
   socket_suffix = "asok"
+
+  ## Ceph user to authenticate as, and the ceph config file. Only used for
+  ## the cluster stats.
+  ceph_user = "client.admin"
+  ceph_config = "/etc/ceph/ceph.conf"
+
+  ## Whether to gather performance counters from the daemon sockets in
+  ## socket_dir
+  gather_admin_socket_stats = true
+
+  ## Whether to gather cluster wide pool IO and placement group states with
+  ## the ceph binary. Enable it on one host that has a keyring for
+  ## ceph_user.
+  gather_cluster_stats = false
 `
 
 func (c *Ceph) SampleConfig() string {
@@ -79,6 +114,20 @@ func (c *Ceph) SampleConfig() string {
 
 func (c *Ceph) Gather(acc telegraf.Accumulator) error {
 	c.setDefaults()
+	if c.GatherAdminSocketStats {
+		if err := c.gatherAdminSocketStats(acc); err != nil {
+			return err
+		}
+	}
+	if c.GatherClusterStats {
+		if err := c.gatherClusterStats(acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Ceph) gatherAdminSocketStats(acc telegraf.Accumulator) error {
 	sockets, err := findSockets(c)
 	if err != nil {
 		return fmt.Errorf("failed to find sockets at path '%s': %v", c.SocketDir, err)
@@ -100,17 +149,163 @@ func (c *Ceph) Gather(acc telegraf.Accumulator) error {
 				map[string]interface{}(metrics),
 				map[string]string{"type": s.sockType, "id": s.sockId, "collection": tag})
 		}
+
+		if s.sockType != typeRgw {
+			continue
+		}
+		// bucket and user counters require Ceph 18 or later
+		dump, err = counterDump(c.CephBinary, s)
+		if err != nil {
+			log.Printf("error reading counters from socket '%s': %v", s.socket, err)
+			continue
+		}
+		if err := gatherRgwOps(acc, s.sockId, dump); err != nil {
+			log.Printf("error parsing counters from socket '%s': %v", s.socket, err)
+		}
+	}
+	return nil
+}
+
+func (c *Ceph) gatherClusterStats(acc telegraf.Accumulator) error {
+	for _, command := range []struct {
+		args   []string
+		gather func(telegraf.Accumulator, string) error
+	}{
+		{[]string{"osd", "pool", "stats"}, gatherPoolStats},
+		{[]string{"status"}, gatherPgStates},
+	} {
+		out, err := clusterCommand(c, command.args...)
+		if err != nil {
+			return err
+		}
+		if err := command.gather(acc, out); err != nil {
+			return fmt.Errorf("error parsing output of 'ceph %s': %v",
+				strings.Join(command.args, " "), err)
+		}
+	}
+	return nil
+}
+
+// rgwOps are the labeled RGW op counters under one key of a counter dump,
+// one entry per bucket or user.
+type rgwOps []struct {
+	Labels   map[string]string      `json:"labels"`
+	Counters map[string]interface{} `json:"counters"`
+}
+
+// gatherRgwOps gathers the op counters of each bucket and user from the
+// counter dump of an RGW socket. They are flattened like a perf dump.
+func gatherRgwOps(acc telegraf.Accumulator, id string, dump string) error {
+	data := make(map[string]json.RawMessage)
+	if err := json.Unmarshal([]byte(dump), &data); err != nil {
+		return fmt.Errorf("failed to parse json: '%s': %v", dump, err)
+	}
+	// Ceph 18 uses rgw_op, later releases rgw_op_per_bucket and rgw_op_per_user
+	for key, raw := range data {
+		if !strings.HasPrefix(key, "rgw_op") {
+			continue
+		}
+		var ops rgwOps
+		if err := json.Unmarshal(raw, &ops); err != nil {
+			return fmt.Errorf("failed to parse '%s': %v", key, err)
+		}
+		for _, op := range ops {
+			if len(op.Labels) == 0 {
+				continue
+			}
+			tags := map[string]string{"id": id}
+			for label, value := range op.Labels {
+				tags[strings.ToLower(label)] = value
+			}
+			fields := make(map[string]interface{})
+			for _, m := range flatten(op.Counters) {
+				fields[m.name()] = m.value
+			}
+			acc.AddFields("ceph_rgw_op", fields, tags)
+		}
 	}
 	return nil
 }
 
+// poolStats are the client IO and recovery rates of the pools, as reported
+// by 'ceph osd pool stats'.
+type poolStats []struct {
+	PoolName     string             `json:"pool_name"`
+	ClientIORate map[string]float64 `json:"client_io_rate"`
+	RecoveryRate map[string]float64 `json:"recovery_rate"`
+}
+
+// gatherPoolStats gathers the IO of each pool. Ceph leaves out rates when
+// there is no IO, so missing rates are 0.
+func gatherPoolStats(acc telegraf.Accumulator, out string) error {
+	var pools poolStats
+	if err := json.Unmarshal([]byte(out), &pools); err != nil {
+		return fmt.Errorf("failed to parse json: '%s': %v", out, err)
+	}
+	for _, pool := range pools {
+		fields := map[string]interface{}{
+			"read_bytes_sec":             float64(0),
+			"write_bytes_sec":            float64(0),
+			"read_op_per_sec":            float64(0),
+			"write_op_per_sec":           float64(0),
+			"recovering_objects_per_sec": float64(0),
+			"recovering_bytes_per_sec":   float64(0),
+			"recovering_keys_per_sec":    float64(0),
+		}
+		for name, value := range pool.ClientIORate {
+			fields[name] = value
+		}
+		for name, value := range pool.RecoveryRate {
+			fields[name] = value
+		}
+		acc.AddFields("ceph_pool_stats", fields,
+			map[string]string{"name": pool.PoolName})
+	}
+	return nil
+}
+
+// pgMap holds the placement groups of the cluster from 'ceph status'.
+type pgMap struct {
+	PGMap struct {
+		NumPGs     float64 `json:"num_pgs"`
+		PGsByState []struct {
+			StateName string  `json:"state_name"`
+			Count     float64 `json:"count"`
+		} `json:"pgs_by_state"`
+	} `json:"pgmap"`
+}
+
+// gatherPgStates counts the placement groups in each reported state. It also
+// counts them per single state, so degraded includes both
+// active+undersized+degraded and active+recovering+degraded.
+func gatherPgStates(acc telegraf.Accumulator, out string) error {
+	var status pgMap
+	if err := json.Unmarshal([]byte(out), &status); err != nil {
+		return fmt.Errorf("failed to parse json: '%s': %v", out, err)
+	}
+	fields := map[string]interface{}{"num_pgs": status.PGMap.NumPGs}
+	for _, state := range status.PGMap.PGsByState {
+		acc.AddFields("ceph_pgmap_state",
+			map[string]interface{}{"count": state.Count},
+			map[string]string{"state": state.StateName})
+		for _, s := range strings.Split(state.StateName, "+") {
+			count, _ := fields[s].(float64)
+			fields[s] = count + state.Count
+		}
+	}
+	acc.AddFields("ceph_pgmap", fields, map[string]string{})
+	return nil
+}
+
 func init() {
-	inputs.Add(measurement, func() telegraf.Input { return &Ceph{} })
+	inputs.Add(measurement, func() telegraf.Input {
+		return &Ceph{GatherAdminSocketStats: true}
+	})
 }
 
 var perfDump = func(binary string, socket *socket) (string, error) {
 	cmdArgs := []string{"--admin-daemon", socket.socket}
-	if socket.sockType == typeOsd {
+	if socket.sockType == typeOsd || socket.sockType == typeRgw {
 		cmdArgs = append(cmdArgs, "perf", "dump")
 	} else if socket.sockType == typeMon {
 		cmdArgs = append(cmdArgs, "perfcounters_dump")
@@ -129,6 +324,36 @@ var perfDump = func(binary string, socket *socket) (string, error) {
 	return out.String(), nil
 }
 
+// counterDump returns the labeled counters of a socket. It requires Ceph 18
+// or later.
+var counterDump = func(binary string, socket *socket) (string, error) {
+	cmd := exec.Command(binary, "--admin-daemon", socket.socket, "counter", "dump")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("error running ceph counter dump: %s", err)
+	}
+
+	return out.String(), nil
+}
+
+// clusterCommand runs a cluster command as the configured user, with JSON
+// output.
+var clusterCommand = func(c *Ceph, args ...string) (string, error) {
+	cmdArgs := append([]string{"--conf", c.CephConfig, "--name", c.CephUser,
+		"--format", "json"}, args...)
+	cmd := exec.Command(c.CephBinary, cmdArgs...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("error running ceph %s: %s", strings.Join(args, " "), err)
+	}
+
+	return out.String(), nil
+}
+
 var findSockets = func(c *Ceph) ([]*socket, error) {
 	listing, err := ioutil.ReadDir(c.SocketDir)
 	if err != nil {
@@ -148,7 +373,11 @@ var findSockets = func(c *Ceph) ([]*socket, error) {
 			sockPrefix = osdPrefix
 
 		}
-		if sockType == typeOsd || sockType == typeMon {
+		if strings.HasPrefix(f, c.RgwPrefix) {
+			sockType = typeRgw
+			sockPrefix = c.RgwPrefix
+		}
+		if sockType == typeOsd || sockType == typeMon || sockType == typeRgw {
 			path := filepath.Join(c.SocketDir, f)
 			sockets = append(sockets, &socket{parseSockId(f, sockPrefix, c.SocketSuffix), sockType, path})
 		}
@@ -52,11 +52,129 @@ func TestGather(t *testing.T) {
 	}
 
 	acc := &testutil.Accumulator{}
-	c := &Ceph{}
+	c := &Ceph{GatherAdminSocketStats: true}
 	c.Gather(acc)
 
 }
 
+func TestGatherRgw(t *testing.T) {
+	saveFind := findSockets
+	saveDump := perfDump
+	saveCounterDump := counterDump
+	defer func() {
+		findSockets = saveFind
+		perfDump = saveDump
+		counterDump = saveCounterDump
+	}()
+
+	findSockets = func(c *Ceph) ([]*socket, error) {
+		return []*socket{&socket{"gw1", typeRgw, ""}}, nil
+	}
+	perfDump = func(binary string, s *socket) (string, error) {
+		return `{"rgw": {"req": 120, "failed_req": 2, "get_initial_lat": {"avgcount": 80, "sum": 1.6}}}`, nil
+	}
+	counterDump = func(binary string, s *socket) (string, error) {
+		return rgwCounterDump, nil
+	}
+
+	acc := &testutil.Accumulator{}
+	c := &Ceph{GatherAdminSocketStats: true}
+	assert.NoError(t, c.Gather(acc))
+
+	acc.AssertContainsTaggedFields(t, measurement,
+		map[string]interface{}{
+			"req":                      float64(120),
+			"failed_req":               float64(2),
+			"get_initial_lat.avgcount": float64(80),
+			"get_initial_lat.sum":      float64(1.6),
+		},
+		map[string]string{"type": typeRgw, "id": "gw1", "collection": "rgw"})
+	acc.AssertContainsTaggedFields(t, "ceph_rgw_op",
+		map[string]interface{}{
+			"put_obj_ops":          float64(2),
+			"put_obj_bytes":        float64(5327),
+			"put_obj_lat.avgcount": float64(2),
+			"put_obj_lat.sum":      float64(0.004),
+			"put_obj_lat.avgtime":  float64(0.002),
+			"get_obj_ops":          float64(5),
+		},
+		map[string]string{"id": "gw1", "bucket": "bucket1"})
+	acc.AssertContainsTaggedFields(t, "ceph_rgw_op",
+		map[string]interface{}{
+			"list_buckets_ops": float64(1),
+		},
+		map[string]string{"id": "gw1", "user": "alice"})
+	// counters without labels come from perf dump
+	assert.Len(t, acc.Metrics, 3)
+}
+
+func TestGatherClusterStats(t *testing.T) {
+	saveCommand := clusterCommand
+	defer func() {
+		clusterCommand = saveCommand
+	}()
+
+	clusterCommand = func(c *Ceph, args ...string) (string, error) {
+		switch strings.Join(args, " ") {
+		case "osd pool stats":
+			return poolStatsOutput, nil
+		case "status":
+			return statusOutput, nil
+		}
+		return "", fmt.Errorf("unexpected command %v", args)
+	}
+
+	acc := &testutil.Accumulator{}
+	c := &Ceph{GatherClusterStats: true}
+	assert.NoError(t, c.Gather(acc))
+
+	acc.AssertContainsTaggedFields(t, "ceph_pool_stats",
+		map[string]interface{}{
+			"read_bytes_sec":             float64(10240),
+			"write_bytes_sec":            float64(4096),
+			"read_op_per_sec":            float64(12),
+			"write_op_per_sec":           float64(3),
+			"recovering_objects_per_sec": float64(0),
+			"recovering_bytes_per_sec":   float64(0),
+			"recovering_keys_per_sec":    float64(0),
+		},
+		map[string]string{"name": "rbd"})
+	// no IO
+	acc.AssertContainsTaggedFields(t, "ceph_pool_stats",
+		map[string]interface{}{
+			"read_bytes_sec":             float64(0),
+			"write_bytes_sec":            float64(0),
+			"read_op_per_sec":            float64(0),
+			"write_op_per_sec":           float64(0),
+			"recovering_objects_per_sec": float64(6),
+			"recovering_bytes_per_sec":   float64(24576),
+			"recovering_keys_per_sec":    float64(0),
+		},
+		map[string]string{"name": "default.rgw.buckets.data"})
+
+	acc.AssertContainsTaggedFields(t, "ceph_pgmap_state",
+		map[string]interface{}{"count": float64(120)},
+		map[string]string{"state": "active+clean"})
+	acc.AssertContainsTaggedFields(t, "ceph_pgmap_state",
+		map[string]interface{}{"count": float64(5)},
+		map[string]string{"state": "active+undersized+degraded"})
+	acc.AssertContainsTaggedFields(t, "ceph_pgmap",
+		map[string]interface{}{
+			"num_pgs":    float64(128),
+			"active":     float64(128),
+			"clean":      float64(120),
+			"undersized": float64(5),
+			"degraded":   float64(8),
+			"recovering": float64(3),
+		},
+		map[string]string{})
+
+	clusterCommand = func(c *Ceph, args ...string) (string, error) {
+		return "", fmt.Errorf("error running ceph %s", strings.Join(args, " "))
+	}
+	assert.Error(t, c.Gather(&testutil.Accumulator{}))
+}
+
 func TestFindSockets(t *testing.T) {
 	tmpdir, err := ioutil.TempDir("", "socktest")
 	assert.NoError(t, err)
@@ -84,6 +202,10 @@ func TestFindSockets(t *testing.T) {
 		for i := 1; i <= st.mons; i++ {
 			assertFoundSocket(t, tmpdir, typeMon, i, sockets)
 		}
+
+		for i := 1; i <= st.rgws; i++ {
+			assertFoundSocket(t, tmpdir, typeRgw, i, sockets)
+		}
 		cleanupTestFiles(tmpdir, st)
 	}
 }
@@ -92,6 +214,8 @@ func assertFoundSocket(t *testing.T, dir, sockType string, i int, sockets []*soc
 	var prefix string
 	if sockType == typeOsd {
 		prefix = osdPrefix
+	} else if sockType == typeRgw {
+		prefix = rgwPrefix
 	} else {
 		prefix = monPrefix
 	}
@@ -140,17 +264,22 @@ func tstFileApply(st *SockTest, fn func(prefix string, i int)) {
 	for i := 1; i <= st.mons; i++ {
 		fn(monPrefix, i)
 	}
+	for i := 1; i <= st.rgws; i++ {
+		fn(rgwPrefix, i)
+	}
 }
 
 type SockTest struct {
 	osds int
 	mons int
+	rgws int
 }
 
 var sockTestParams = []*SockTest{
 	&SockTest{
 		osds: 2,
 		mons: 2,
+		rgws: 2,
 	},
 	&SockTest{
 		mons: 1,
@@ -680,3 +809,102 @@ var osdPerfDump = `
       "wait": { "avgcount": 0,
           "sum": 0.000000000}}}
 `
+
+var rgwCounterDump = `
+{
+    "rgw": [
+        {
+            "labels": {},
+            "counters": {
+                "req": 120,
+                "failed_req": 2
+            }
+        }
+    ],
+    "rgw_op": [
+        {
+            "labels": {
+                "Bucket": "bucket1"
+            },
+            "counters": {
+                "put_obj_ops": 2,
+                "put_obj_bytes": 5327,
+                "put_obj_lat": {
+                    "avgcount": 2,
+                    "sum": 0.004,
+                    "avgtime": 0.002
+                },
+                "get_obj_ops": 5
+            }
+        },
+        {
+            "labels": {
+                "User": "alice"
+            },
+            "counters": {
+                "list_buckets_ops": 1
+            }
+        }
+    ]
+}
+`
+
+var poolStatsOutput = `
+[
+    {
+        "pool_name": "rbd",
+        "pool_id": 1,
+        "recovery": {},
+        "recovery_rate": {},
+        "client_io_rate": {
+            "read_bytes_sec": 10240,
+            "write_bytes_sec": 4096,
+            "read_op_per_sec": 12,
+            "write_op_per_sec": 3
+        }
+    },
+    {
+        "pool_name": "default.rgw.buckets.data",
+        "pool_id": 7,
+        "recovery": {
+            "degraded_objects": 18,
+            "degraded_total": 2048,
+            "degraded_ratio": 0.0087
+        },
+        "recovery_rate": {
+            "recovering_objects_per_sec": 6,
+            "recovering_bytes_per_sec": 24576
+        },
+        "client_io_rate": {}
+    }
+]
+`
+
+var statusOutput = `
+{
+    "fsid": "8e7e6f0c-0b6e-4a5e-9f63-2c1a1f3c0b8d",
+    "health": {
+        "status": "HEALTH_WARN"
+    },
+    "pgmap": {
+        "pgs_by_state": [
+            {
+                "state_name": "active+clean",
+                "count": 120
+            },
+            {
+                "state_name": "active+undersized+degraded",
+                "count": 5
+            },
+            {
+                "state_name": "active+recovering+degraded",
+                "count": 3
+            }
+        ],
+        "num_pgs": 128,
+        "num_pools": 2,
+        "num_objects": 2048,
+        "data_bytes": 8589934592
+    }
+}
+`
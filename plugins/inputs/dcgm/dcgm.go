@@ -0,0 +1,221 @@
+package dcgm
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/glob"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// fieldPrefixes are the DCGM field name prefixes that are trimmed from the
+// metric field names. For example, DCGM_FI_DEV_GPU_UTIL becomes gpu_util.
+var fieldPrefixes = []string{"DCGM_FI_DEV_", "DCGM_FI_PROF_", "DCGM_FI_"}
+
+// labelTags maps the dcgm-exporter labels whose tag is not just lower cased.
+var labelTags = map[string]string{
+	"UUID":                   "uuid",
+	"modelName":              "model",
+	"GPU_I_ID":               "gpu_instance_id",
+	"GPU_I_PROFILE":          "gpu_instance_profile",
+	"DCGM_FI_DRIVER_VERSION": "driver_version",
+}
+
+type DCGM struct {
+	// Urls are the dcgm-exporter metrics endpoints.
+	Urls []string
+	// Fields are globs of the DCGM fields to gather. All are gathered if empty.
+	Fields          []string
+	ResponseTimeout internal.Duration `toml:"response_timeout"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client *http.Client
+	fields glob.Glob
+}
+
+var sampleConfig = `
+  ## dcgm-exporter metrics endpoints. The exporter reads the GPU fields
+  ## from nv-hostengine
+  urls = ["http://localhost:9400/metrics"]
+
+  ## Globs of the DCGM fields to gather. If empty, every field the exporter
+  ## is configured with is gathered
+  # fields = ["DCGM_FI_DEV_*", "DCGM_FI_PROF_NVLINK_*"]
+
+  ## Request timeout
+  # response_timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (d *DCGM) SampleConfig() string {
+	return sampleConfig
+}
+
+func (d *DCGM) Description() string {
+	return "Read NVIDIA GPU metrics from DCGM through dcgm-exporter"
+}
+
+func (d *DCGM) Gather(acc telegraf.Accumulator) error {
+	if d.client == nil {
+		tlsCfg, err := internal.GetTLSConfig(
+			d.SSLCert, d.SSLKey, d.SSLCA, d.InsecureSkipVerify)
+		if err != nil {
+			return err
+		}
+		if d.fields, err = internal.CompileFilter(d.Fields); err != nil {
+			return err
+		}
+		d.client = &http.Client{
+			Transport: &http.Transport{TLSClientConfig: tlsCfg},
+			Timeout:   d.ResponseTimeout.Duration,
+		}
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(d.Urls))
+	for _, u := range d.Urls {
+		wg.Add(1)
+		go func(u string) {
+			defer wg.Done()
+			if err := d.gatherURL(u, acc); err != nil {
+				errs <- err
+			}
+		}(u)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+func (d *DCGM) gatherURL(u string, acc telegraf.Accumulator) error {
+	resp, err := d.client.Get(u)
+	if err != nil {
+		return fmt.Errorf("dcgm: requesting %s: %s", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("dcgm: %s returned HTTP status %s", u, resp.Status)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return fmt.Errorf("dcgm: reading %s: %s", u, err)
+	}
+
+	// the fields of a GPU, or of a MIG instance, share the same labels
+	now := time.Now()
+	type group struct {
+		tags   map[string]string
+		fields map[string]interface{}
+	}
+	groups := make(map[string]*group)
+	var keys []string
+	for name, family := range families {
+		if !strings.HasPrefix(name, "DCGM_") ||
+			d.fields != nil && !d.fields.Match(name) {
+			continue
+		}
+		for _, m := range family.Metric {
+			value, ok := metricValue(m)
+			if !ok {
+				continue
+			}
+			tags := labels(m)
+			key := tagsKey(tags)
+			g, ok := groups[key]
+			if !ok {
+				g = &group{tags: tags, fields: make(map[string]interface{})}
+				groups[key] = g
+				keys = append(keys, key)
+			}
+			g.fields[fieldName(name)] = value
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		acc.AddFields("dcgm", groups[key].fields, groups[key].tags, now)
+	}
+	return nil
+}
+
+// metricValue returns the value of a gauge or counter.
+func metricValue(m *dto.Metric) (float64, bool) {
+	switch {
+	case m.Gauge != nil:
+		return m.GetGauge().GetValue(), true
+	case m.Counter != nil:
+		return m.GetCounter().GetValue(), true
+	case m.Untyped != nil:
+		return m.GetUntyped().GetValue(), true
+	}
+	return 0, false
+}
+
+// labels converts the labels of a metric to tags.
+func labels(m *dto.Metric) map[string]string {
+	tags := make(map[string]string)
+	for _, l := range m.Label {
+		if l.GetValue() == "" {
+			continue
+		}
+		name, ok := labelTags[l.GetName()]
+		if !ok {
+			name = strings.ToLower(l.GetName())
+		}
+		tags[name] = l.GetValue()
+	}
+	return tags
+}
+
+func tagsKey(tags map[string]string) string {
+	var pairs []string
+	for k, v := range tags {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+// fieldName returns the metric field name for a DCGM field. It is lower
+// cased with the prefix removed, so DCGM_FI_DEV_SM_CLOCK becomes sm_clock.
+func fieldName(name string) string {
+	for _, prefix := range fieldPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			name = name[len(prefix):]
+			break
+		}
+	}
+	return strings.ToLower(name)
+}
+
+func init() {
+	inputs.Add("dcgm", func() telegraf.Input {
+		return &DCGM{
+			Urls:            []string{"http://localhost:9400/metrics"},
+			ResponseTimeout: internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package dcgm
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+const exporterOutput = `# HELP DCGM_FI_DEV_SM_CLOCK SM clock frequency (in MHz).
+# TYPE DCGM_FI_DEV_SM_CLOCK gauge
+DCGM_FI_DEV_SM_CLOCK{gpu="0",UUID="GPU-5a0e1b1c-1d2e-4f3a-8b9c-0d1e2f3a4b5c",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 1410
+DCGM_FI_DEV_SM_CLOCK{gpu="1",UUID="GPU-6b1f2c2d-2e3f-4a4b-9cad-1e2f3a4b5c6d",device="nvidia1",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 210
+# HELP DCGM_FI_DEV_GPU_UTIL GPU utilization (in %).
+# TYPE DCGM_FI_DEV_GPU_UTIL gauge
+DCGM_FI_DEV_GPU_UTIL{gpu="0",UUID="GPU-5a0e1b1c-1d2e-4f3a-8b9c-0d1e2f3a4b5c",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 87
+DCGM_FI_DEV_GPU_UTIL{gpu="1",UUID="GPU-6b1f2c2d-2e3f-4a4b-9cad-1e2f3a4b5c6d",device="nvidia1",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 0
+# HELP DCGM_FI_DEV_FB_USED Framebuffer memory used (in MiB).
+# TYPE DCGM_FI_DEV_FB_USED gauge
+DCGM_FI_DEV_FB_USED{gpu="0",UUID="GPU-5a0e1b1c-1d2e-4f3a-8b9c-0d1e2f3a4b5c",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 30210
+# HELP DCGM_FI_DEV_ECC_DBE_VOL_TOTAL Total number of double-bit volatile ECC errors.
+# TYPE DCGM_FI_DEV_ECC_DBE_VOL_TOTAL counter
+DCGM_FI_DEV_ECC_DBE_VOL_TOTAL{gpu="0",UUID="GPU-5a0e1b1c-1d2e-4f3a-8b9c-0d1e2f3a4b5c",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 2
+# HELP DCGM_FI_PROF_NVLINK_TX_BYTES The rate of data transmitted over NVLink, not including protocol headers, in bytes per second.
+# TYPE DCGM_FI_PROF_NVLINK_TX_BYTES gauge
+DCGM_FI_PROF_NVLINK_TX_BYTES{gpu="0",UUID="GPU-5a0e1b1c-1d2e-4f3a-8b9c-0d1e2f3a4b5c",device="nvidia0",modelName="NVIDIA A100-SXM4-40GB",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05"} 1.2e+09
+# HELP DCGM_FI_PROF_GR_ENGINE_ACTIVE Ratio of time the graphics engine is active.
+# TYPE DCGM_FI_PROF_GR_ENGINE_ACTIVE gauge
+DCGM_FI_PROF_GR_ENGINE_ACTIVE{gpu="1",UUID="GPU-6b1f2c2d-2e3f-4a4b-9cad-1e2f3a4b5c6d",device="nvidia1",modelName="NVIDIA A100-SXM4-40GB",GPU_I_PROFILE="1g.5gb",GPU_I_ID="7",Hostname="gpu1",DCGM_FI_DRIVER_VERSION="535.104.05",container="",namespace="",pod=""} 0.25
+# HELP go_goroutines Number of goroutines that currently exist.
+# TYPE go_goroutines gauge
+go_goroutines 15
+`
+
+func TestGather(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, exporterOutput)
+		}))
+	defer ts.Close()
+
+	acc := &testutil.Accumulator{}
+	d := &DCGM{Urls: []string{ts.URL}}
+	require.NoError(t, d.Gather(acc))
+
+	// the GPUs, and the MIG instance
+	require.Len(t, acc.Metrics, 3)
+	gpu := map[string]string{
+		"gpu":            "0",
+		"uuid":           "GPU-5a0e1b1c-1d2e-4f3a-8b9c-0d1e2f3a4b5c",
+		"device":         "nvidia0",
+		"model":          "NVIDIA A100-SXM4-40GB",
+		"hostname":       "gpu1",
+		"driver_version": "535.104.05",
+	}
+	acc.AssertContainsTaggedFields(t, "dcgm",
+		map[string]interface{}{
+			"sm_clock":          float64(1410),
+			"gpu_util":          float64(87),
+			"fb_used":           float64(30210),
+			"ecc_dbe_vol_total": float64(2),
+			"nvlink_tx_bytes":   1.2e+09,
+		}, gpu)
+	mig := map[string]string{
+		"gpu":                  "1",
+		"uuid":                 "GPU-6b1f2c2d-2e3f-4a4b-9cad-1e2f3a4b5c6d",
+		"device":               "nvidia1",
+		"model":                "NVIDIA A100-SXM4-40GB",
+		"hostname":             "gpu1",
+		"driver_version":       "535.104.05",
+		"gpu_instance_id":      "7",
+		"gpu_instance_profile": "1g.5gb",
+	}
+	acc.AssertContainsTaggedFields(t, "dcgm",
+		map[string]interface{}{"gr_engine_active": 0.25}, mig)
+
+	acc = &testutil.Accumulator{}
+	d = &DCGM{Urls: []string{ts.URL}, Fields: []string{"DCGM_FI_DEV_*"},
+		InsecureSkipVerify: true}
+	require.NoError(t, d.Gather(acc))
+	require.Len(t, acc.Metrics, 2)
+	assert.Len(t, acc.Metrics[0].Fields, 4)
+	assert.NotContains(t, acc.Metrics[0].Fields, "nvlink_tx_bytes")
+}
+
+func TestGatherError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+	defer ts.Close()
+
+	acc := &testutil.Accumulator{}
+	d := &DCGM{Urls: []string{ts.URL}}
+	assert.Error(t, d.Gather(acc))
+	assert.Empty(t, acc.Metrics)
+}
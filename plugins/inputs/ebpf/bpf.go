@@ -0,0 +1,490 @@
+// +build linux
+
+package ebpf
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// bpfSyscalls are the bpf syscall numbers, by architecture.
+var bpfSyscalls = map[string]uintptr{
+	"amd64": 321, "386": 357, "arm64": 280, "arm": 386,
+}
+
+// bpf syscall commands.
+const (
+	cmdMapCreate     = 0
+	cmdMapLookupElem = 1
+	cmdMapDeleteElem = 3
+	cmdMapGetNextKey = 4
+	cmdProgLoad      = 5
+)
+
+const (
+	mapTypeHash         = 1
+	progTypeTracepoint  = 5
+	perfTypeTracepoint  = 2
+	perfEventIocEnable  = 0x2400
+	perfEventIocSetBPF  = 0x40042408
+	perfFlagFdCloexec   = 1 << 3
+	rlimitMemlock       = 8
+	bpfLogSize          = 1 << 16
+	updateNoExist       = 1
+	pseudoMapFd         = 1
+	perfEventAttrSizeV0 = 64
+)
+
+// Instruction classes, sizes, modes and operations.
+const (
+	classLD    = 0x00
+	classLDX   = 0x01
+	classST    = 0x02
+	classSTX   = 0x03
+	classJMP   = 0x05
+	classALU64 = 0x07
+
+	sizeW  = 0x00
+	sizeH  = 0x08
+	sizeDW = 0x18
+
+	modeIMM  = 0x00
+	modeMEM  = 0x60
+	modeXADD = 0xc0
+
+	srcK = 0x00
+	srcX = 0x08
+
+	aluAdd = 0x00
+	aluSub = 0x10
+	aluRsh = 0x70
+	aluMov = 0xb0
+
+	jmpJA   = 0x00
+	jmpJEQ  = 0x10
+	jmpJNE  = 0x50
+	jmpCall = 0x80
+	jmpExit = 0x90
+)
+
+// BPF helper functions used by the programs.
+const (
+	fnMapLookupElem     = 1
+	fnMapUpdateElem     = 2
+	fnMapDeleteElem     = 3
+	fnKtimeGetNs        = 5
+	fnGetCurrentPidTgid = 14
+	fnGetCurrentComm    = 16
+)
+
+// Registers; r10 is the read-only frame pointer.
+const (
+	r0 = iota
+	r1
+	r2
+	r3
+	r4
+	r5
+	r6
+	r7
+	r8
+	r9
+	r10
+)
+
+func bpf(cmd int, attr unsafe.Pointer, size uintptr) (uintptr, error) {
+	nr, ok := bpfSyscalls[runtime.GOARCH]
+	if !ok {
+		return 0, fmt.Errorf("bpf is not supported on %s", runtime.GOARCH)
+	}
+	r, _, errno := syscall.Syscall(nr, uintptr(cmd), uintptr(attr), size)
+	runtime.KeepAlive(attr)
+	if errno != 0 {
+		return 0, errno
+	}
+	return r, nil
+}
+
+// bpfMap is a BPF map used by the programs.
+type bpfMap struct {
+	fd        int
+	keySize   int
+	valueSize int
+}
+
+func newMap(keySize, valueSize, maxEntries int) (*bpfMap, error) {
+	attr := struct {
+		mapType    uint32
+		keySize    uint32
+		valueSize  uint32
+		maxEntries uint32
+		mapFlags   uint32
+	}{mapTypeHash, uint32(keySize), uint32(valueSize), uint32(maxEntries), 0}
+	fd, err := bpf(cmdMapCreate, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	if err != nil {
+		return nil, fmt.Errorf("creating map: %s", err)
+	}
+	return &bpfMap{fd: int(fd), keySize: keySize, valueSize: valueSize}, nil
+}
+
+type mapElemAttr struct {
+	fd    uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// entries returns the keys and values of the map.
+func (m *bpfMap) entries() (map[string][]byte, error) {
+	entries := make(map[string][]byte)
+	var key []byte
+	next := make([]byte, m.keySize)
+	for {
+		attr := mapElemAttr{fd: uint32(m.fd),
+			value: uint64(uintptr(unsafe.Pointer(&next[0])))}
+		if key != nil {
+			attr.key = uint64(uintptr(unsafe.Pointer(&key[0])))
+		}
+		_, err := bpf(cmdMapGetNextKey, unsafe.Pointer(&attr),
+			unsafe.Sizeof(attr))
+		runtime.KeepAlive(key)
+		runtime.KeepAlive(next)
+		if err == syscall.ENOENT {
+			return entries, nil
+		} else if err != nil {
+			return nil, err
+		}
+		key = append([]byte(nil), next...)
+
+		value := make([]byte, m.valueSize)
+		attr = mapElemAttr{fd: uint32(m.fd),
+			key:   uint64(uintptr(unsafe.Pointer(&key[0]))),
+			value: uint64(uintptr(unsafe.Pointer(&value[0])))}
+		_, err = bpf(cmdMapLookupElem, unsafe.Pointer(&attr),
+			unsafe.Sizeof(attr))
+		runtime.KeepAlive(value)
+		if err == nil {
+			entries[string(key)] = value
+		} else if err != syscall.ENOENT {
+			return nil, err
+		}
+	}
+}
+
+func (m *bpfMap) delete(key []byte) error {
+	attr := mapElemAttr{fd: uint32(m.fd),
+		key: uint64(uintptr(unsafe.Pointer(&key[0])))}
+	_, err := bpf(cmdMapDeleteElem, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(key)
+	return err
+}
+
+func (m *bpfMap) Close() error {
+	return syscall.Close(m.fd)
+}
+
+// insn is an instruction of a program.
+type insn struct {
+	code uint8
+	dst  uint8
+	src  uint8
+	off  int16
+	imm  int32
+	// label is the label the jump goes to, or a map whose fd is loaded.
+	label string
+	m     *bpfMap
+}
+
+// asm assembles a program of instructions and labels.
+type asm struct {
+	insns  []insn
+	labels map[string]int
+}
+
+func newAsm() *asm {
+	return &asm{labels: make(map[string]int)}
+}
+
+func (a *asm) emit(i insn) *asm {
+	a.insns = append(a.insns, i)
+	return a
+}
+
+// label labels the next instruction.
+func (a *asm) label(name string) *asm {
+	a.labels[name] = len(a.insns)
+	return a
+}
+
+func (a *asm) mov(dst, src uint8) *asm {
+	return a.emit(insn{code: classALU64 | aluMov | srcX, dst: dst, src: src})
+}
+
+func (a *asm) movImm(dst uint8, imm int32) *asm {
+	return a.emit(insn{code: classALU64 | aluMov | srcK, dst: dst, imm: imm})
+}
+
+func (a *asm) addImm(dst uint8, imm int32) *asm {
+	return a.emit(insn{code: classALU64 | aluAdd | srcK, dst: dst, imm: imm})
+}
+
+func (a *asm) sub(dst, src uint8) *asm {
+	return a.emit(insn{code: classALU64 | aluSub | srcX, dst: dst, src: src})
+}
+
+func (a *asm) rshImm(dst uint8, imm int32) *asm {
+	return a.emit(insn{code: classALU64 | aluRsh | srcK, dst: dst, imm: imm})
+}
+
+// load loads dst from src+off, with a size of sizeW, sizeH or sizeDW.
+func (a *asm) load(size, dst, src uint8, off int16) *asm {
+	return a.emit(insn{code: classLDX | modeMEM | size, dst: dst, src: src,
+		off: off})
+}
+
+// store stores the 64 bits of src to dst+off.
+func (a *asm) store(dst uint8, off int16, src uint8) *asm {
+	return a.emit(insn{code: classSTX | modeMEM | sizeDW, dst: dst, src: src,
+		off: off})
+}
+
+// storeImm stores a 64 bit immediate to dst+off.
+func (a *asm) storeImm(dst uint8, off int16, imm int32) *asm {
+	return a.emit(insn{code: classST | modeMEM | sizeDW, dst: dst, off: off,
+		imm: imm})
+}
+
+// xadd adds src to the 64 bits at dst+off atomically.
+func (a *asm) xadd(dst uint8, off int16, src uint8) *asm {
+	return a.emit(insn{code: classSTX | modeXADD | sizeDW, dst: dst, src: src,
+		off: off})
+}
+
+// loadMap loads the fd of a map into dst. It takes two instructions.
+func (a *asm) loadMap(dst uint8, m *bpfMap) *asm {
+	a.emit(insn{code: classLD | modeIMM | sizeDW, dst: dst, src: pseudoMapFd,
+		m: m})
+	return a.emit(insn{})
+}
+
+// stackPtr sets dst to the frame pointer plus off.
+func (a *asm) stackPtr(dst uint8, off int32) *asm {
+	return a.mov(dst, r10).addImm(dst, off)
+}
+
+func (a *asm) jeqImm(dst uint8, imm int32, label string) *asm {
+	return a.emit(insn{code: classJMP | jmpJEQ | srcK, dst: dst, imm: imm,
+		label: label})
+}
+
+func (a *asm) jneImm(dst uint8, imm int32, label string) *asm {
+	return a.emit(insn{code: classJMP | jmpJNE | srcK, dst: dst, imm: imm,
+		label: label})
+}
+
+func (a *asm) ja(label string) *asm {
+	return a.emit(insn{code: classJMP | jmpJA, label: label})
+}
+
+func (a *asm) call(fn int32) *asm {
+	return a.emit(insn{code: classJMP | jmpCall, imm: fn})
+}
+
+func (a *asm) exit() *asm {
+	return a.emit(insn{code: classJMP | jmpExit})
+}
+
+// assemble returns the bytecode of the program.
+func (a *asm) assemble() ([]byte, error) {
+	b := make([]byte, 0, 8*len(a.insns))
+	for i, in := range a.insns {
+		if in.label != "" {
+			target, ok := a.labels[in.label]
+			if !ok {
+				return nil, fmt.Errorf("undefined label %s", in.label)
+			}
+			in.off = int16(target - i - 1)
+		}
+		if in.m != nil {
+			in.imm = int32(in.m.fd)
+		}
+		var buf [8]byte
+		buf[0] = in.code
+		buf[1] = in.dst | in.src<<4
+		binary.LittleEndian.PutUint16(buf[2:], uint16(in.off))
+		binary.LittleEndian.PutUint32(buf[4:], uint32(in.imm))
+		b = append(b, buf[:]...)
+	}
+	return b, nil
+}
+
+// loadProgram loads a tracepoint program. If the kernel rejects it, the
+// error includes the verifier log.
+func loadProgram(a *asm) (int, error) {
+	code, err := a.assemble()
+	if err != nil {
+		return 0, err
+	}
+	license := []byte("Dual MIT/GPL\x00")
+	logBuf := make([]byte, bpfLogSize)
+	attr := struct {
+		progType    uint32
+		insnCnt     uint32
+		insns       uint64
+		license     uint64
+		logLevel    uint32
+		logSize     uint32
+		logBuf      uint64
+		kernVersion uint32
+		progFlags   uint32
+	}{
+		progType: progTypeTracepoint,
+		insnCnt:  uint32(len(code) / 8),
+		insns:    uint64(uintptr(unsafe.Pointer(&code[0]))),
+		license:  uint64(uintptr(unsafe.Pointer(&license[0]))),
+		logLevel: 1,
+		logSize:  uint32(len(logBuf)),
+		logBuf:   uint64(uintptr(unsafe.Pointer(&logBuf[0]))),
+	}
+	fd, err := bpf(cmdProgLoad, unsafe.Pointer(&attr), unsafe.Sizeof(attr))
+	runtime.KeepAlive(code)
+	runtime.KeepAlive(license)
+	runtime.KeepAlive(logBuf)
+	if err != nil {
+		log := strings.TrimRight(string(logBuf), "\x00\n")
+		if i := strings.LastIndex(log, "\n"); i >= 0 {
+			log = log[i+1:]
+		}
+		return 0, fmt.Errorf("loading program: %s: %s", err, log)
+	}
+	return int(fd), nil
+}
+
+// attachTracepoint attaches a program to the tracepoint with an id, and
+// returns the fd of its perf event.
+func attachTracepoint(id uint64, prog int) (int, error) {
+	attr := struct {
+		typ          uint32
+		size         uint32
+		config       uint64
+		samplePeriod uint64
+		sampleType   uint64
+		readFormat   uint64
+		flags        uint64
+		wakeupEvents uint32
+		bpType       uint32
+		config1      uint64
+	}{
+		typ:          perfTypeTracepoint,
+		size:         perfEventAttrSizeV0,
+		config:       id,
+		samplePeriod: 1,
+		wakeupEvents: 1,
+	}
+	// a tracepoint program runs for events on every cpu
+	fd, _, errno := syscall.Syscall6(syscall.SYS_PERF_EVENT_OPEN,
+		uintptr(unsafe.Pointer(&attr)), ^uintptr(0), 0, ^uintptr(0),
+		perfFlagFdCloexec, 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("opening perf event: %s", errno)
+	}
+	for _, req := range []struct{ op, arg uintptr }{
+		{perfEventIocSetBPF, uintptr(prog)},
+		{perfEventIocEnable, 0},
+	} {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, req.op, req.arg)
+		if errno != 0 {
+			syscall.Close(int(fd))
+			return 0, fmt.Errorf("attaching program: %s", errno)
+		}
+	}
+	return int(fd), nil
+}
+
+// tracepoint is a tracepoint format from tracefs: its id and its field
+// offsets.
+type tracepoint struct {
+	id      uint64
+	offsets map[string]int16
+}
+
+// tracefsPaths are the usual tracefs mount points.
+var tracefsPaths = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// findTracefs returns the tracefs mount point, or "" if there is none.
+func findTracefs() string {
+	for _, path := range tracefsPaths {
+		if _, err := os.Stat(filepath.Join(path, "events")); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// readTracepoint reads the format of a tracepoint such as
+// sched/sched_process_exec.
+func readTracepoint(tracefs, name string) (*tracepoint, error) {
+	dir := filepath.Join(tracefs, "events", name)
+	id, err := readID(filepath.Join(dir, "id"))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(dir, "format"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	tp := &tracepoint{id: id, offsets: make(map[string]int16)}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// field:int newstate;	offset:20;	size:4;	signed:1;
+		parts := strings.Split(strings.TrimSpace(scanner.Text()), ";")
+		if len(parts) < 2 || !strings.HasPrefix(parts[0], "field:") {
+			continue
+		}
+		decl := strings.Fields(parts[0])
+		name := decl[len(decl)-1]
+		if i := strings.Index(name, "["); i >= 0 {
+			name = name[:i]
+		}
+		offset := strings.TrimPrefix(strings.TrimSpace(parts[1]), "offset:")
+		if v, err := strconv.ParseInt(offset, 10, 16); err == nil {
+			tp.offsets[name] = int16(v)
+		}
+	}
+	return tp, scanner.Err()
+}
+
+func readID(path string) (uint64, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// field returns the offset of a tracepoint field.
+func (tp *tracepoint) field(name string) (int16, error) {
+	offset, ok := tp.offsets[name]
+	if !ok {
+		return 0, fmt.Errorf("tracepoint has no field %s", name)
+	}
+	return offset, nil
+}
+
+// raiseMemlock raises the locked memory limit. Kernels before 5.11 charge
+// BPF maps against it.
+func raiseMemlock() {
+	limit := &syscall.Rlimit{Cur: ^uint64(0), Max: ^uint64(0)}
+	syscall.Setrlimit(rlimitMemlock, limit)
+}
@@ -0,0 +1,255 @@
+// +build linux
+
+package ebpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// socketEntries is the most sockets connecting, or connected, tracked of
+// the tcp probe.
+const socketEntries = 65536
+
+type EBPF struct {
+	// Probes are the probes loaded: tcp and exec.
+	Probes []string
+	// Tracefs is the mount point of tracefs. It is detected if empty.
+	Tracefs string
+	// MaxEntries is the maximum number of processes, or exec commands,
+	// counted.
+	MaxEntries int `toml:"max_entries"`
+
+	sockets *bpfMap
+	stats   *bpfMap
+	execs   *bpfMap
+	// fds are the fds of the programs and their perf events.
+	fds []int
+
+	sync.Mutex
+}
+
+var sampleConfig = `
+  ## Probes to load:
+  ##   tcp:  TCP connects, connect time and retransmits, per process
+  ##   exec: process execs, per command
+  # probes = ["tcp", "exec"]
+
+  ## Mount point of tracefs, which has the tracepoint formats. If empty,
+  ## /sys/kernel/tracing or /sys/kernel/debug/tracing is used.
+  # tracefs = ""
+
+  ## Maximum number of processes, or exec commands, counted by a probe
+  # max_entries = 10240
+`
+
+func (e *EBPF) SampleConfig() string {
+	return sampleConfig
+}
+
+func (e *EBPF) Description() string {
+	return "Count the TCP connects, retransmits and process execs with eBPF probes"
+}
+
+// Start loads the probes. A probe the kernel does not support is logged and
+// skipped, so that the others are still loaded.
+func (e *EBPF) Start(_ telegraf.Accumulator) error {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, probe := range e.Probes {
+		if probe != "tcp" && probe != "exec" {
+			return fmt.Errorf("ebpf: invalid probe %q, must be tcp or exec",
+				probe)
+		}
+	}
+	tracefs := e.Tracefs
+	if tracefs == "" {
+		tracefs = findTracefs()
+	}
+	if tracefs == "" {
+		log.Printf("ebpf: tracefs is not mounted, no probes loaded")
+		return nil
+	}
+	raiseMemlock()
+
+	for _, probe := range e.Probes {
+		var err error
+		switch probe {
+		case "tcp":
+			err = e.startTCP(tracefs)
+		case "exec":
+			err = e.startExec(tracefs)
+		}
+		if err != nil {
+			log.Printf("ebpf: skipping the %s probe: %s", probe, err)
+		}
+	}
+	return nil
+}
+
+func (e *EBPF) startTCP(tracefs string) error {
+	state, err := readTracepoint(tracefs, "sock/inet_sock_set_state")
+	if err != nil {
+		return err
+	}
+	retransmit, err := readTracepoint(tracefs, "tcp/tcp_retransmit_skb")
+	if err != nil {
+		return err
+	}
+	if e.sockets, err = newMap(8, socketValueSize, socketEntries); err != nil {
+		return err
+	}
+	if e.stats, err = newMap(statsKeySize, statsValueSize,
+		e.MaxEntries); err != nil {
+		return e.closeTCP(err)
+	}
+
+	prog, err := tcpStateProgram(state, e.sockets, e.stats)
+	if err != nil {
+		return e.closeTCP(err)
+	}
+	if err := e.attach(state, prog); err != nil {
+		return e.closeTCP(err)
+	}
+	if prog, err = tcpRetransmitProgram(retransmit, e.sockets,
+		e.stats); err != nil {
+		return e.closeTCP(err)
+	}
+	if err := e.attach(retransmit, prog); err != nil {
+		return e.closeTCP(err)
+	}
+	return nil
+}
+
+// closeTCP closes the maps of a tcp probe that failed to load.
+func (e *EBPF) closeTCP(err error) error {
+	for _, m := range []*bpfMap{e.sockets, e.stats} {
+		if m != nil {
+			m.Close()
+		}
+	}
+	e.sockets, e.stats = nil, nil
+	return err
+}
+
+func (e *EBPF) startExec(tracefs string) error {
+	exec, err := readTracepoint(tracefs, "sched/sched_process_exec")
+	if err != nil {
+		return err
+	}
+	if e.execs, err = newMap(commSize, 8, e.MaxEntries); err != nil {
+		return err
+	}
+	if err := e.attach(exec, execProgram(e.execs)); err != nil {
+		e.execs.Close()
+		e.execs = nil
+		return err
+	}
+	return nil
+}
+
+// attach loads a program and attaches it to its tracepoint.
+func (e *EBPF) attach(tp *tracepoint, prog *asm) error {
+	fd, err := loadProgram(prog)
+	if err != nil {
+		return err
+	}
+	event, err := attachTracepoint(tp.id, fd)
+	if err != nil {
+		syscall.Close(fd)
+		return err
+	}
+	e.fds = append(e.fds, fd, event)
+	return nil
+}
+
+// Stop detaches the programs and closes the maps.
+func (e *EBPF) Stop() {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, fd := range e.fds {
+		syscall.Close(fd)
+	}
+	e.fds = nil
+	e.closeTCP(nil)
+	if e.execs != nil {
+		e.execs.Close()
+		e.execs = nil
+	}
+}
+
+// Gather adds the counts of the probes since they were loaded. Counts of
+// processes that exited are added a last time and then deleted.
+func (e *EBPF) Gather(acc telegraf.Accumulator) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.stats != nil {
+		entries, err := e.stats.entries()
+		if err != nil {
+			return fmt.Errorf("ebpf: reading the tcp counts: %s", err)
+		}
+		for key, value := range entries {
+			pid := binary.LittleEndian.Uint64([]byte(key))
+			tags := map[string]string{"pid": strconv.FormatUint(pid, 10)}
+			if comm := commString([]byte(key[8:])); comm != "" {
+				tags["comm"] = comm
+			}
+			acc.AddFields("ebpf_tcp", map[string]interface{}{
+				"connects":        int64(binary.LittleEndian.Uint64(value)),
+				"connect_time_ns": int64(binary.LittleEndian.Uint64(value[8:])),
+				"retransmits":     int64(binary.LittleEndian.Uint64(value[16:])),
+			}, tags)
+			if pid != 0 && !alive(pid) {
+				e.stats.delete([]byte(key))
+			}
+		}
+	}
+
+	if e.execs != nil {
+		entries, err := e.execs.entries()
+		if err != nil {
+			return fmt.Errorf("ebpf: reading the exec counts: %s", err)
+		}
+		for key, value := range entries {
+			acc.AddFields("ebpf_exec", map[string]interface{}{
+				"execs": int64(binary.LittleEndian.Uint64(value)),
+			}, map[string]string{"comm": commString([]byte(key))})
+		}
+	}
+	return nil
+}
+
+// commString returns the comm of a process, up to the first NUL byte.
+func commString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+// alive returns whether the process with a pid is running.
+func alive(pid uint64) bool {
+	_, err := os.Stat("/proc/" + strconv.FormatUint(pid, 10))
+	return err == nil
+}
+
+func init() {
+	inputs.Add("ebpf", func() telegraf.Input {
+		return &EBPF{
+			Probes:     []string{"tcp", "exec"},
+			MaxEntries: 10240,
+		}
+	})
+}
@@ -0,0 +1,159 @@
+// +build linux
+
+package ebpf
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+const execFormat = `name: sched_process_exec
+ID: 365
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:__data_loc char[] filename;	offset:8;	size:4;	signed:0;
+	field:pid_t pid;	offset:12;	size:4;	signed:1;
+	field:pid_t old_pid;	offset:16;	size:4;	signed:1;
+	field:__u8 saddr[4];	offset:20;	size:4;	signed:0;
+
+print fmt: "filename=%s pid=%d old_pid=%d", __get_str(filename), REC->pid, REC->old_pid
+`
+
+func writeTracepoint(t *testing.T, tracefs, name string, id int,
+	format string) {
+	dir := filepath.Join(tracefs, "events", name)
+	require.NoError(t, os.MkdirAll(dir, 0700))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "id"),
+		[]byte(strconv.Itoa(id)+"\n"), 0600))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "format"),
+		[]byte(format), 0600))
+}
+
+func TestReadTracepoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ebpf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	writeTracepoint(t, dir, "sched/sched_process_exec", 365, execFormat)
+
+	tp, err := readTracepoint(dir, "sched/sched_process_exec")
+	require.NoError(t, err)
+	assert.Equal(t, uint64(365), tp.id)
+	assert.Equal(t, int16(0), tp.offsets["common_type"])
+	assert.Equal(t, int16(8), tp.offsets["filename"])
+	assert.Equal(t, int16(16), tp.offsets["old_pid"])
+	assert.Equal(t, int16(20), tp.offsets["saddr"])
+	_, err = tp.field("skaddr")
+	assert.Error(t, err)
+
+	_, err = readTracepoint(dir, "tcp/tcp_retransmit_skb")
+	assert.Error(t, err)
+}
+
+func TestAssemble(t *testing.T) {
+	m := &bpfMap{fd: 7}
+	a := newAsm()
+	a.load(sizeW, r7, r1, 20).
+		jeqImm(r7, 2, "exit").
+		loadMap(r1, m).
+		stackPtr(r2, -8).
+		xadd(r0, 16, r1)
+	a.label("exit").
+		movImm(r0, 0).
+		exit()
+	b, err := a.assemble()
+	require.NoError(t, err)
+	assert.Equal(t, []byte{
+		0x61, 0x17, 20, 0, 0, 0, 0, 0,
+		0x15, 0x07, 5, 0, 2, 0, 0, 0,
+		0x18, 0x11, 0, 0, 7, 0, 0, 0,
+		0x00, 0x00, 0, 0, 0, 0, 0, 0,
+		0xbf, 0xa2, 0, 0, 0, 0, 0, 0,
+		0x07, 0x02, 0, 0, 0xf8, 0xff, 0xff, 0xff,
+		0xdb, 0x10, 16, 0, 0, 0, 0, 0,
+		0xb7, 0x00, 0, 0, 0, 0, 0, 0,
+		0x95, 0x00, 0, 0, 0, 0, 0, 0,
+	}, b)
+
+	_, err = newAsm().ja("missing").assemble()
+	assert.Error(t, err)
+}
+
+func TestStartUnsupported(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ebpf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "events"), 0700))
+
+	// probes whose tracepoints the kernel lacks are skipped
+	e := &EBPF{Probes: []string{"tcp", "exec"}, Tracefs: dir,
+		MaxEntries: 1024}
+	require.NoError(t, e.Start(nil))
+	defer e.Stop()
+	assert.Empty(t, e.fds)
+	acc := &testutil.Accumulator{}
+	require.NoError(t, e.Gather(acc))
+	assert.Empty(t, acc.Metrics)
+
+	e = &EBPF{Probes: []string{"udp"}}
+	assert.Error(t, e.Start(nil))
+}
+
+func TestGather(t *testing.T) {
+	e := &EBPF{Probes: []string{"tcp", "exec"}, MaxEntries: 1024}
+	require.NoError(t, e.Start(nil))
+	defer e.Stop()
+	if e.stats == nil || e.execs == nil {
+		t.Skip("Skipping test, bpf or tracefs is not available.")
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			c.Close()
+		}
+	}()
+	for i := 0; i < 3; i++ {
+		c, err := net.Dial("tcp", l.Addr().String())
+		require.NoError(t, err)
+		c.Close()
+	}
+	require.NoError(t, exec.Command("true").Run())
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, e.Gather(acc))
+	pid := strconv.Itoa(os.Getpid())
+	var connects, execs bool
+	for _, m := range acc.Metrics {
+		switch {
+		case m.Measurement == "ebpf_tcp" && m.Tags["pid"] == pid:
+			connects = true
+			assert.Equal(t, int64(3), m.Fields["connects"])
+			assert.True(t, m.Fields["connect_time_ns"].(int64) > 0)
+		case m.Measurement == "ebpf_exec" && m.Tags["comm"] == "true":
+			execs = true
+			assert.True(t, m.Fields["execs"].(int64) >= 1)
+		}
+	}
+	assert.True(t, connects)
+	assert.True(t, execs)
+}
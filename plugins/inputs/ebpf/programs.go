@@ -0,0 +1,194 @@
+// +build linux
+
+package ebpf
+
+// The programs are assembled at load time, using the tracepoint field
+// offsets read from tracefs. So they run on the current kernel without BTF
+// or a compiler.
+
+// TCP states used by inet_sock_set_state.
+const (
+	tcpEstablished = 1
+	tcpSynSent     = 2
+	tcpClose       = 7
+	ipprotoTCP     = 6
+)
+
+const (
+	commSize = 16
+	// socketValueSize is the value size of the sockets map: the connect
+	// time, and the pid and comm of the process.
+	socketValueSize = 16 + commSize
+	// statsKeySize is the stats map key size: a process pid and comm. statsValueSize is its value size: connects, connect time and
+	// retransmits.
+	statsKeySize   = 8 + commSize
+	statsValueSize = 24
+)
+
+// tcpStateProgram records each connecting socket in the sockets map, with
+// the time it enters SYN_SENT and the connecting process. It adds the
+// latency to the stats map when the socket is established, and deletes the
+// socket when it is closed.
+func tcpStateProgram(tp *tracepoint, sockets, stats *bpfMap) (*asm, error) {
+	skaddr, err := tp.field("skaddr")
+	if err != nil {
+		return nil, err
+	}
+	oldstate, err := tp.field("oldstate")
+	if err != nil {
+		return nil, err
+	}
+	newstate, err := tp.field("newstate")
+	if err != nil {
+		return nil, err
+	}
+	protocol, err := tp.field("protocol")
+	if err != nil {
+		return nil, err
+	}
+
+	// the stack: the socket at fp-8, its value at fp-40, or the stats key
+	// at fp-32 and the initial stats value at fp-56
+	a := newAsm()
+	a.mov(r6, r1).
+		load(sizeH, r1, r6, protocol).
+		jneImm(r1, ipprotoTCP, "exit").
+		load(sizeDW, r1, r6, skaddr).
+		store(r10, -8, r1).
+		load(sizeW, r7, r6, newstate).
+		jeqImm(r7, tcpSynSent, "connect").
+		jeqImm(r7, tcpClose, "close").
+		jneImm(r7, tcpEstablished, "exit").
+		load(sizeW, r1, r6, oldstate).
+		jeqImm(r1, tcpSynSent, "established").
+		ja("exit")
+
+	a.label("connect").
+		call(fnKtimeGetNs).
+		store(r10, -40, r0).
+		call(fnGetCurrentPidTgid).
+		rshImm(r0, 32).
+		store(r10, -32, r0).
+		stackPtr(r1, -24).
+		movImm(r2, commSize).
+		call(fnGetCurrentComm).
+		loadMap(r1, sockets).
+		stackPtr(r2, -8).
+		stackPtr(r3, -40).
+		movImm(r4, 0).
+		call(fnMapUpdateElem).
+		ja("exit")
+
+	a.label("established").
+		loadMap(r1, sockets).
+		stackPtr(r2, -8).
+		call(fnMapLookupElem).
+		jeqImm(r0, 0, "exit").
+		mov(r7, r0).
+		call(fnKtimeGetNs).
+		load(sizeDW, r1, r7, 0).
+		sub(r0, r1).
+		mov(r8, r0)
+	copyStatsKey(a, r7)
+	lookupStats(a, stats)
+	a.movImm(r1, 1).
+		xadd(r0, 0, r1).
+		xadd(r0, 8, r8).
+		ja("exit")
+
+	a.label("close").
+		loadMap(r1, sockets).
+		stackPtr(r2, -8).
+		call(fnMapDeleteElem)
+
+	a.label("exit").
+		movImm(r0, 0).
+		exit()
+	return a, nil
+}
+
+// tcpRetransmitProgram adds socket retransmits to the stats map, under the
+// process that connected the socket. Sockets the probe did not see connect,
+// such as accepted ones, count under pid 0.
+func tcpRetransmitProgram(tp *tracepoint, sockets, stats *bpfMap) (*asm, error) {
+	skaddr, err := tp.field("skaddr")
+	if err != nil {
+		return nil, err
+	}
+
+	a := newAsm()
+	a.load(sizeDW, r2, r1, skaddr).
+		store(r10, -8, r2).
+		loadMap(r1, sockets).
+		stackPtr(r2, -8).
+		call(fnMapLookupElem).
+		jeqImm(r0, 0, "unknown")
+	copyStatsKey(a, r0)
+	a.ja("add")
+
+	a.label("unknown").
+		storeImm(r10, -32, 0).
+		storeImm(r10, -24, 0).
+		storeImm(r10, -16, 0)
+
+	a.label("add")
+	lookupStats(a, stats)
+	a.movImm(r1, 1).
+		xadd(r0, 16, r1)
+
+	a.label("exit").
+		movImm(r0, 0).
+		exit()
+	return a, nil
+}
+
+// execProgram counts process execs in the execs map, keyed by comm.
+func execProgram(execs *bpfMap) *asm {
+	a := newAsm()
+	a.stackPtr(r1, -16).
+		movImm(r2, commSize).
+		call(fnGetCurrentComm).
+		storeImm(r10, -24, 0).
+		loadMap(r1, execs).
+		stackPtr(r2, -16).
+		stackPtr(r3, -24).
+		movImm(r4, updateNoExist).
+		call(fnMapUpdateElem).
+		loadMap(r1, execs).
+		stackPtr(r2, -16).
+		call(fnMapLookupElem).
+		jeqImm(r0, 0, "exit").
+		movImm(r1, 1).
+		xadd(r0, 0, r1)
+
+	a.label("exit").
+		movImm(r0, 0).
+		exit()
+	return a
+}
+
+// copyStatsKey copies the pid and comm from a socket value, at src, to
+// the stats key at fp-32.
+func copyStatsKey(a *asm, src uint8) {
+	for off := int16(0); off < statsKeySize; off += 8 {
+		a.load(sizeDW, r1, src, 8+off).
+			store(r10, -32+off, r1)
+	}
+}
+
+// lookupStats sets r0 to the stats value of the key at fp-32. It inserts a
+// zero value for a new key, and jumps to exit if the map is full.
+func lookupStats(a *asm, stats *bpfMap) {
+	a.storeImm(r10, -56, 0).
+		storeImm(r10, -48, 0).
+		storeImm(r10, -40, 0).
+		loadMap(r1, stats).
+		stackPtr(r2, -32).
+		stackPtr(r3, -56).
+		movImm(r4, updateNoExist).
+		call(fnMapUpdateElem).
+		loadMap(r1, stats).
+		stackPtr(r2, -32).
+		call(fnMapLookupElem).
+		jeqImm(r0, 0, "exit")
+}
@@ -3,6 +3,7 @@ package exec
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
 	"os/exec"
 	"path/filepath"
 	"strings"
@@ -14,6 +15,7 @@ import (
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/sandbox"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
 	"github.com/influxdata/telegraf/plugins/parsers/nagios"
@@ -38,12 +40,30 @@ const sampleConfig = `
   ## more about them here:
   ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_INPUT.md
   data_format = "influx"
+
+  ## Restrict the processes the commands run in, Linux only. Running as
+  ## another user and cgroup limits require telegraf to run as root.
+  # [inputs.exec.sandbox]
+  #   ## User the commands run as.
+  #   user = "nobody"
+  #   ## Prevent the commands from gaining privileges, such as with sudo.
+  #   no_new_privileges = true
+  #   ## Pass only the listed variables from telegraf's environment.
+  #   restrict_environment = true
+  #   environment = ["PATH", "LANG"]
+  #   ## Number of CPUs and bytes of memory the commands may use together.
+  #   cpu_limit = 0.5
+  #   memory_limit = 268435456
+  #   ## Cgroup for the commands under /sys/fs/cgroup. Defaults to
+  #   ## "telegraf/exec-<hash>", a hash of the commands.
+  #   # cgroup = "telegraf/scripts"
 `
 
 type Exec struct {
 	Commands []string
 	Command  string
 	Timeout  internal.Duration
+	Sandbox  sandbox.Sandbox
 
 	parser parsers.Parser
 
@@ -97,11 +117,18 @@ func (c CommandRunner) Run(
 	}
 
 	cmd := exec.Command(split_cmd[0], split_cmd[1:]...)
+	if e.Sandbox.Enabled() {
+		cmd.Env = e.Sandbox.Environ()
+	}
 
 	var out bytes.Buffer
 	cmd.Stdout = &out
 
-	if err := internal.RunTimeout(cmd, e.Timeout.Duration); err != nil {
+	err = e.Sandbox.Start(cmd, e.cgroup())
+	if err == nil {
+		err = internal.WaitTimeout(cmd, e.Timeout.Duration)
+	}
+	if err != nil {
 		switch e.parser.(type) {
 		case *nagios.NagiosParser:
 			AddNagiosState(err, acc)
@@ -118,6 +145,17 @@ func (c CommandRunner) Run(
 	return out.Bytes(), nil
 }
 
+// cgroup returns the default cgroup for the sandboxed commands. It is unique
+// to the configured commands.
+func (e *Exec) cgroup() string {
+	h := fnv.New32a()
+	for _, c := range e.Commands {
+		h.Write([]byte(c))
+		h.Write([]byte{0})
+	}
+	return fmt.Sprintf("exec-%08x", h.Sum32())
+}
+
 func (e *Exec) ProcessCommand(command string, acc telegraf.Accumulator) {
 	defer e.wg.Done()
 
@@ -12,6 +12,7 @@ import (
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/cluster"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
 )
@@ -33,7 +34,8 @@ type HttpJson struct {
 	// Use SSL but skip chain & host verification
 	InsecureSkipVerify bool
 
-	client HTTPClient
+	client  HTTPClient
+	cluster *cluster.Cluster
 }
 
 type HTTPClient interface {
@@ -139,6 +141,9 @@ func (h *HttpJson) Gather(acc telegraf.Accumulator) error {
 	errorChannel := make(chan error, len(h.Servers))
 
 	for _, server := range h.Servers {
+		if !h.cluster.Owns(server) {
+			continue
+		}
 		wg.Add(1)
 		go func(server string) {
 			defer wg.Done()
@@ -287,6 +292,11 @@ func (h *HttpJson) sendRequest(serverURL string) (string, float64, error) {
 	return string(body), responseTime, err
 }
 
+// SetCluster sets the cluster the servers are spread across.
+func (h *HttpJson) SetCluster(c *cluster.Cluster) {
+	h.cluster = c
+}
+
 func init() {
 	inputs.Add("httpjson", func() telegraf.Input {
 		return &HttpJson{
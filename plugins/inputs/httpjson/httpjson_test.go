@@ -8,6 +8,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/influxdata/telegraf/internal/cluster"
 	"github.com/influxdata/telegraf/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -511,3 +512,29 @@ func TestHttpJson200Tags(t *testing.T) {
 		}
 	}
 }
+
+// Test that the servers are spread across the agents of a cluster
+func TestHttpJsonCluster(t *testing.T) {
+	peers := []string{"http://a:8126", "http://b:8126"}
+	gathered := map[string]int{}
+	for _, self := range peers {
+		service := genMockHttpJson(validJSON, 200)[0]
+		service.Servers = []string{
+			"http://server1.example.com/metrics/",
+			"http://server2.example.com/metrics/",
+			"http://server3.example.com/metrics/",
+			"http://server4.example.com/metrics/",
+		}
+		service.SetCluster(cluster.New(self, peers))
+
+		var acc testutil.Accumulator
+		require.NoError(t, service.Gather(&acc))
+		for _, p := range acc.Metrics {
+			gathered[p.Tags["server"]]++
+		}
+	}
+	assert.Len(t, gathered, 4)
+	for server, n := range gathered {
+		assert.Equal(t, 1, n, "%s gathered %d times", server, n)
+	}
+}
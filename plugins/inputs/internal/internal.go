@@ -0,0 +1,161 @@
+package internal
+
+import (
+	"runtime"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/experimental"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/internal/usage"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// Internal reports the health of the inputs and outputs run by telegraf, and
+// the runtime statistics of the telegraf process.
+type Internal struct {
+	Histograms      bool
+	CollectMemstats bool
+}
+
+var sampleConfig = `
+  ## Report the distribution of the gather, write and serialization times of
+  ## each plugin, as cumulative histogram buckets.
+  histograms = true
+
+  ## Report memory and garbage collector statistics of the telegraf process.
+  collect_memstats = true
+`
+
+func (s *Internal) Description() string {
+	return "Collect metrics about the health of the running telegraf plugins"
+}
+
+func (s *Internal) SampleConfig() string {
+	return sampleConfig
+}
+
+func (s *Internal) Gather(acc telegraf.Accumulator) error {
+	inputs, outputs := internal_models.Health()
+
+	for _, h := range inputs {
+		fields := map[string]interface{}{
+			"gathers":              h.Gathers,
+			"errors":               h.Errors,
+			"consecutive_failures": h.ConsecutiveFailures,
+			"gather_time_ns":       h.LastGatherDuration,
+			"paused":               h.Paused,
+		}
+		if !h.LastGather.IsZero() {
+			fields["last_gather"] = h.LastGather.UnixNano()
+		}
+		if s.Histograms {
+			addHistogram(fields, "gather_time", h.GatherTime)
+		}
+		if usage.Sampling() {
+			fields["cpu_time_ns"] = h.CPUTime
+			fields["allocated_bytes"] = h.Allocated
+		}
+		if h.Limits != nil {
+			fields["limit_tags_dropped"] = h.Limits.TagsDropped
+			fields["limit_fields_dropped"] = h.Limits.FieldsDropped
+			fields["limit_tag_values_truncated"] = h.Limits.TagValuesTruncated
+			fields["limit_metrics_dropped"] = h.Limits.MetricsDropped
+		}
+		tags := map[string]string{"input": h.Name}
+		if h.Alias != "" {
+			tags["alias"] = h.Alias
+		}
+		acc.AddFields("internal_input", fields, tags)
+	}
+
+	for _, h := range outputs {
+		fields := map[string]interface{}{
+			"writes":                h.Writes,
+			"errors":                h.Errors,
+			"consecutive_failures":  h.ConsecutiveFailures,
+			"write_time_ns":         h.LastWriteDuration,
+			"buffer_size":           h.BufferSize,
+			"buffer_limit":          h.BufferLimit,
+			"buffer_high_watermark": h.BufferHighWatermark,
+			"dropped":               h.Dropped,
+			"expired":               h.Expired,
+		}
+		if !h.LastWrite.IsZero() {
+			fields["last_write"] = h.LastWrite.UnixNano()
+		}
+		if s.Histograms {
+			addHistogram(fields, "write_time", h.WriteTime)
+			addHistogram(fields, "serialize_time", h.SerializeTime)
+		}
+		if usage.Sampling() {
+			fields["cpu_time_ns"] = h.CPUTime
+			fields["allocated_bytes"] = h.Allocated
+		}
+		tags := map[string]string{"output": h.Name}
+		if h.Alias != "" {
+			tags["alias"] = h.Alias
+		}
+		acc.AddFields("internal_output", fields, tags)
+	}
+
+	for _, f := range experimental.Flags() {
+		if f.Enabled() {
+			acc.AddFields("internal_experimental",
+				map[string]interface{}{"enabled": true},
+				map[string]string{"flag": f.Name})
+		}
+	}
+
+	if s.CollectMemstats {
+		gatherMemstats(acc)
+	}
+	return nil
+}
+
+// addHistogram adds the fields for a histogram: gather_time_count,
+// gather_time_sum_ns and a gather_time_le_<bound> field per bucket.
+func addHistogram(
+	fields map[string]interface{},
+	name string,
+	h internal_models.Histogram,
+) {
+	fields[name+"_count"] = h.Count
+	fields[name+"_sum_ns"] = h.Sum
+	for i, b := range internal_models.HistogramBuckets {
+		fields[name+"_le_"+b.Label] = h.Buckets[i]
+	}
+}
+
+func gatherMemstats(acc telegraf.Accumulator) {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	fields := map[string]interface{}{
+		"goroutines":         runtime.NumGoroutine(),
+		"alloc_bytes":        m.Alloc,
+		"total_alloc_bytes":  m.TotalAlloc,
+		"sys_bytes":          m.Sys,
+		"mallocs":            m.Mallocs,
+		"frees":              m.Frees,
+		"heap_alloc_bytes":   m.HeapAlloc,
+		"heap_sys_bytes":     m.HeapSys,
+		"heap_idle_bytes":    m.HeapIdle,
+		"heap_in_use_bytes":  m.HeapInuse,
+		"heap_objects":       m.HeapObjects,
+		"num_gc":             m.NumGC,
+		"gc_pause_total_ns":  m.PauseTotalNs,
+		"gc_pause_last_ns":   m.PauseNs[(m.NumGC+255)%256],
+		"gc_cpu_fraction":    m.GCCPUFraction,
+		"next_gc_heap_bytes": m.NextGC,
+	}
+	acc.AddFields("internal_runtime", fields, nil)
+}
+
+func init() {
+	inputs.Add("internal", func() telegraf.Input {
+		return &Internal{
+			Histograms:      true,
+			CollectMemstats: true,
+		}
+	})
+}
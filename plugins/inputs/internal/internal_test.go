@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/influxdata/telegraf/internal/experimental"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInternalGather(t *testing.T) {
+	cpu := &internal_models.RunningInput{Name: "cpu"}
+	snmp := &internal_models.RunningInput{Name: "snmp"}
+	snmp2 := &internal_models.RunningInput{
+		Name:   "snmp",
+		Config: &internal_models.InputConfig{Alias: "snmp#2"},
+	}
+	start := time.Unix(1465839830, 0)
+	cpu.RecordGather(start, time.Millisecond, nil)
+	snmp.RecordGather(start, time.Second, errors.New("timeout"))
+	snmp.RecordGather(start, time.Second, errors.New("timeout"))
+
+	o := internal_models.NewRunningOutput("influxdb", nil,
+		&internal_models.OutputConfig{}, 0, 100)
+
+	internal_models.SetRunning(
+		[]*internal_models.RunningInput{cpu, snmp, snmp2},
+		[]*internal_models.RunningOutput{o})
+	defer internal_models.SetRunning(nil, nil)
+
+	var acc testutil.Accumulator
+	require.NoError(t, (&Internal{}).Gather(&acc))
+
+	acc.AssertContainsTaggedFields(t, "internal_input",
+		map[string]interface{}{
+			"gathers":              int64(1),
+			"errors":               int64(0),
+			"consecutive_failures": int64(0),
+			"gather_time_ns":       int64(time.Millisecond),
+			"paused":               false,
+			"last_gather":          start.UnixNano(),
+		},
+		map[string]string{"input": "cpu"})
+	acc.AssertContainsTaggedFields(t, "internal_input",
+		map[string]interface{}{
+			"gathers":              int64(2),
+			"errors":               int64(2),
+			"consecutive_failures": int64(2),
+			"gather_time_ns":       int64(time.Second),
+			"paused":               false,
+			"last_gather":          start.UnixNano(),
+		},
+		map[string]string{"input": "snmp"})
+	acc.AssertContainsTaggedFields(t, "internal_input",
+		map[string]interface{}{
+			"gathers":              int64(0),
+			"errors":               int64(0),
+			"consecutive_failures": int64(0),
+			"gather_time_ns":       int64(0),
+			"paused":               false,
+		},
+		map[string]string{"input": "snmp", "alias": "snmp#2"})
+	acc.AssertContainsTaggedFields(t, "internal_output",
+		map[string]interface{}{
+			"writes":                int64(0),
+			"errors":                int64(0),
+			"consecutive_failures":  int64(0),
+			"write_time_ns":         int64(0),
+			"buffer_size":           0,
+			"buffer_limit":          100,
+			"buffer_high_watermark": 0,
+			"dropped":               int64(0),
+			"expired":               int64(0),
+		},
+		map[string]string{"output": "influxdb"})
+}
+
+func TestInternalGatherHistograms(t *testing.T) {
+	cpu := &internal_models.RunningInput{Name: "cpu"}
+	start := time.Unix(1465839830, 0)
+	cpu.RecordGather(start, 5*time.Millisecond, nil)
+	cpu.RecordGather(start, 2*time.Second, nil)
+	cpu.RecordGather(start, 2*time.Minute, nil)
+
+	internal_models.SetRunning([]*internal_models.RunningInput{cpu}, nil)
+	defer internal_models.SetRunning(nil, nil)
+
+	var acc testutil.Accumulator
+	require.NoError(t, (&Internal{Histograms: true}).Gather(&acc))
+
+	m, ok := acc.Get("internal_input")
+	require.True(t, ok)
+	assert.Equal(t, int64(3), m.Fields["gather_time_count"])
+	assert.Equal(t, int64(5*time.Millisecond+2*time.Second+2*time.Minute),
+		m.Fields["gather_time_sum_ns"])
+	assert.Equal(t, int64(0), m.Fields["gather_time_le_1ms"])
+	assert.Equal(t, int64(1), m.Fields["gather_time_le_10ms"])
+	assert.Equal(t, int64(1), m.Fields["gather_time_le_1s"])
+	assert.Equal(t, int64(2), m.Fields["gather_time_le_10s"])
+	assert.Equal(t, int64(2), m.Fields["gather_time_le_1m"])
+}
+
+func TestInternalGatherMemstats(t *testing.T) {
+	var acc testutil.Accumulator
+	require.NoError(t, (&Internal{CollectMemstats: true}).Gather(&acc))
+
+	m, ok := acc.Get("internal_runtime")
+	require.True(t, ok)
+	assert.Contains(t, m.Fields, "goroutines")
+	assert.Contains(t, m.Fields, "heap_alloc_bytes")
+	assert.Contains(t, m.Fields, "gc_pause_total_ns")
+}
+
+func TestInternalGatherNotRunning(t *testing.T) {
+	var acc testutil.Accumulator
+	require.NoError(t, (&Internal{}).Gather(&acc))
+	assert.Equal(t, 0, len(acc.Metrics))
+}
+
+func TestInternalGatherExperimental(t *testing.T) {
+	require.NoError(t,
+		experimental.Set(map[string]bool{"priority_batches": true}))
+	defer experimental.Set(nil)
+
+	var acc testutil.Accumulator
+	require.NoError(t, (&Internal{}).Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "internal_experimental",
+		map[string]interface{}{"enabled": true},
+		map[string]string{"flag": "priority_batches"})
+}
+
+func TestInternalGatherLimits(t *testing.T) {
+	cpu := &internal_models.RunningInput{
+		Name: "cpu",
+		Config: &internal_models.InputConfig{
+			Limits: internal_models.Limits{MaxTags: 1},
+		},
+	}
+	cpu.Config.Limits.Apply(map[string]string{"a": "1", "b": "2"}, nil)
+
+	internal_models.SetRunning([]*internal_models.RunningInput{cpu}, nil)
+	defer internal_models.SetRunning(nil, nil)
+
+	var acc testutil.Accumulator
+	require.NoError(t, (&Internal{}).Gather(&acc))
+
+	m, ok := acc.Get("internal_input")
+	require.True(t, ok)
+	assert.Equal(t, int64(1), m.Fields["limit_tags_dropped"])
+	assert.Equal(t, int64(0), m.Fields["limit_metrics_dropped"])
+}
@@ -0,0 +1,192 @@
+package ipmi_sensor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Raw DCMI commands, prefixed with the DCMI group extension
+var (
+	rawPowerReading = []string{"raw", "0x2c", "0x02", "0xdc", "0x01", "0x00", "0x00"}
+	rawPowerLimit   = []string{"raw", "0x2c", "0x03", "0xdc", "0x00", "0x00"}
+)
+
+// gatherPower gathers the DCMI power reading and power limit of a server
+// with "ipmitool dcmi". It uses raw commands if ipmitool has no dcmi
+// command.
+func (m *Ipmi) gatherPower(conn *Connection, tags map[string]string,
+	acc telegraf.Accumulator) error {
+	var fields map[string]interface{}
+	raw := false
+	res, err := m.runner.Run(conn, "dcmi", "power", "reading")
+	if err == nil {
+		fields, err = parsePowerReading(res)
+	}
+	if err != nil {
+		res, rawErr := m.runner.Run(conn, rawPowerReading...)
+		if rawErr != nil {
+			return err
+		}
+		if fields, err = parseRawPowerReading(res); err != nil {
+			return err
+		}
+		raw = true
+	}
+
+	// the limit command fails if the BMC has no power limit set, and the
+	// limit is then left out
+	if raw {
+		if res, err := m.runner.Run(conn, rawPowerLimit...); err == nil {
+			parseRawPowerLimit(res, fields)
+		}
+	} else {
+		if res, err := m.runner.Run(conn, "dcmi", "power", "get_limit"); err == nil {
+			parsePowerLimit(res, fields)
+		}
+	}
+
+	acc.AddFields("ipmi_power", fields, tags, time.Now())
+	return nil
+}
+
+// parsePowerReading parses the output of "ipmitool dcmi power reading":
+//
+//	Instantaneous power reading:                   220 Watts
+//	Minimum during sampling period:                 96 Watts
+//	Maximum during sampling period:                388 Watts
+//	Average power reading over sample period:      221 Watts
+//	IPMI timestamp:                           Thu Jun  2 10:20:28 2016
+//	Sampling period:                          00000005 Seconds.
+//	Power reading state is:                   activated
+func parsePowerReading(res string) (map[string]interface{}, error) {
+	names := map[string]string{
+		"instantaneous power reading":              "instantaneous_watts",
+		"minimum during sampling period":           "minimum_watts",
+		"maximum during sampling period":           "maximum_watts",
+		"average power reading over sample period": "average_watts",
+		"sampling period":                          "sampling_period_seconds",
+	}
+	fields := make(map[string]interface{})
+	for _, line := range strings.Split(res, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.ToLower(trim(kv[0]))
+		if key == "power reading state is" {
+			fields["reading_active"] = trim(kv[1]) == "activated"
+			continue
+		}
+		name, ok := names[key]
+		if !ok {
+			continue
+		}
+		if v, ok := leadingInt(kv[1]); ok {
+			fields[name] = v
+		}
+	}
+	if _, ok := fields["instantaneous_watts"]; !ok {
+		return nil, fmt.Errorf("no DCMI power reading in: %s", res)
+	}
+	return fields, nil
+}
+
+// parsePowerLimit parses the output of "ipmitool dcmi power get_limit":
+//
+//	Current Limit State: Power Limit Active
+//	Exception actions:   Hard Power Off & Log Event to SEL
+//	Power Limit:         500   Watts
+//	Correction time:     1000 milliseconds
+//	Sampling period:     5 seconds
+func parsePowerLimit(res string, fields map[string]interface{}) {
+	for _, line := range strings.Split(res, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := trim(kv[1])
+		switch strings.ToLower(trim(kv[0])) {
+		case "current limit state":
+			// "No Active Power Limit" when no limit is active
+			fields["power_limit_active"] = value == "Power Limit Active"
+		case "power limit":
+			if v, ok := leadingInt(value); ok {
+				fields["power_limit_watts"] = v
+			}
+		case "correction time":
+			if v, ok := leadingInt(value); ok {
+				fields["correction_time_ms"] = v
+			}
+		}
+	}
+}
+
+// leadingInt returns the integer a value starts with, such as 220 for
+// "220 Watts".
+func leadingInt(value string) (int64, bool) {
+	f := strings.Fields(value)
+	if len(f) == 0 {
+		return 0, false
+	}
+	v, err := strconv.ParseInt(f[0], 10, 64)
+	return v, err == nil
+}
+
+// parseRawBytes parses the bytes printed by "ipmitool raw" for a DCMI
+// command. The response starts with the group extension.
+func parseRawBytes(res string, n int) ([]byte, error) {
+	var b []byte
+	for _, f := range strings.Fields(res) {
+		v, err := strconv.ParseUint(f, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DCMI response: %s", res)
+		}
+		b = append(b, byte(v))
+	}
+	if len(b) < n || b[0] != 0xdc {
+		return nil, fmt.Errorf("invalid DCMI response: %s", res)
+	}
+	return b, nil
+}
+
+// parseRawPowerReading parses the response of the DCMI Get Power Reading
+// command. It has the current, minimum, maximum and average watts in 2 bytes
+// each, the timestamp, the sampling period in milliseconds in 4 bytes, and
+// the reading state. All values are least significant byte first.
+func parseRawPowerReading(res string) (map[string]interface{}, error) {
+	b, err := parseRawBytes(res, 18)
+	if err != nil {
+		return nil, err
+	}
+	word := func(i int) int64 {
+		return int64(b[i]) | int64(b[i+1])<<8
+	}
+	period := int64(b[13]) | int64(b[14])<<8 | int64(b[15])<<16 | int64(b[16])<<24
+	return map[string]interface{}{
+		"instantaneous_watts":     word(1),
+		"minimum_watts":           word(3),
+		"maximum_watts":           word(5),
+		"average_watts":           word(7),
+		"sampling_period_seconds": period / 1000,
+		"reading_active":          b[17]&0x40 != 0,
+	}, nil
+}
+
+// parseRawPowerLimit parses the response of the DCMI Get Power Limit
+// command. It has the exception actions, the limit in watts in 2 bytes, and
+// the correction time in milliseconds in 4 bytes. The BMC fails the command
+// if no limit is set, or if the limit is not active.
+func parseRawPowerLimit(res string, fields map[string]interface{}) {
+	b, err := parseRawBytes(res, 10)
+	if err != nil {
+		return
+	}
+	fields["power_limit_active"] = true
+	fields["power_limit_watts"] = int64(b[4]) | int64(b[5])<<8
+	fields["correction_time_ms"] = int64(b[6]) | int64(b[7])<<8 |
+		int64(b[8])<<16 | int64(b[9])<<24
+}
@@ -11,7 +11,14 @@ import (
 
 type Ipmi struct {
 	Servers []string
+	// Dcmi gathers the DCMI power readings and power limit of the servers
+	Dcmi bool
+	// FruTags are the FRU inventory fields added as tags to the metrics
+	FruTags []string
 	runner  Runner
+
+	// the FRU tags of each server, read once
+	fru map[string]map[string]string
 }
 
 var sampleConfig = `
@@ -21,6 +28,13 @@ var sampleConfig = `
   ##    root:passwd@lan(127.0.0.1)
   ##
   servers = ["USERID:PASSW0RD@lan(192.168.1.1)"]
+
+  ## Gather the DCMI power readings and power limit. Raw commands are used
+  ## if ipmitool has no dcmi command.
+  # dcmi = false
+
+  ## FRU inventory fields to add as tags, from "ipmitool fru print 0"
+  # fru_tags = ["Product Manufacturer", "Product Name", "Product Serial"]
 `
 
 func NewIpmi() *Ipmi {
@@ -54,6 +68,11 @@ func (m *Ipmi) Gather(acc telegraf.Accumulator) error {
 func (m *Ipmi) gatherServer(serv string, acc telegraf.Accumulator) error {
 	conn := NewConnection(serv)
 
+	serverTags, err := m.serverTags(serv, conn)
+	if err != nil {
+		return err
+	}
+
 	res, err := m.runner.Run(conn, "sdr")
 	if err != nil {
 		return err
@@ -68,9 +87,9 @@ func (m *Ipmi) gatherServer(serv string, acc telegraf.Accumulator) error {
 			continue
 		}
 
-		tags := map[string]string{
-			"server": conn.Hostname,
-			"name":   transform(vals[0]),
+		tags := map[string]string{"name": transform(vals[0])}
+		for k, v := range serverTags {
+			tags[k] = v
 		}
 
 		fields := make(map[string]interface{})
@@ -96,9 +115,62 @@ func (m *Ipmi) gatherServer(serv string, acc telegraf.Accumulator) error {
 		acc.AddFields("ipmi_sensor", fields, tags, time.Now())
 	}
 
+	if m.Dcmi {
+		return m.gatherPower(conn, serverTags, acc)
+	}
 	return nil
 }
 
+// serverTags returns the tags for the metrics of a server. The FRU
+// inventory is read on the first gather.
+func (m *Ipmi) serverTags(serv string, conn *Connection) (map[string]string, error) {
+	tags := map[string]string{"server": conn.Hostname}
+	if len(m.FruTags) == 0 {
+		return tags, nil
+	}
+
+	fru, ok := m.fru[serv]
+	if !ok {
+		res, err := m.runner.Run(conn, "fru", "print", "0")
+		if err != nil {
+			return nil, err
+		}
+		fru = parseFru(res, m.FruTags)
+		if m.fru == nil {
+			m.fru = make(map[string]map[string]string)
+		}
+		m.fru[serv] = fru
+	}
+	for k, v := range fru {
+		tags[k] = v
+	}
+	return tags, nil
+}
+
+// parseFru returns tags for the selected FRU inventory fields. For example,
+// this line becomes the product_serial tag:
+//
+//	Product Serial        : S1234567
+func parseFru(res string, fields []string) map[string]string {
+	wanted := make(map[string]bool)
+	for _, field := range fields {
+		wanted[transform(field)] = true
+	}
+
+	tags := make(map[string]string)
+	for _, line := range strings.Split(res, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name := transform(kv[0])
+		if value := trim(kv[1]); wanted[name] && value != "" {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
 type Runner interface {
 	Run(conn *Connection, args ...string) (string, error)
 }
@@ -1,6 +1,8 @@
 package ipmi_sensor
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/influxdata/telegraf/testutil"
@@ -273,3 +275,134 @@ func TestIpmiConnection(t *testing.T) {
 	assert.Equal(t, "lan", conn.Interface)
 
 }
+
+// commandMock returns the output of each command, and fails the others.
+type commandMock map[string]string
+
+func (r commandMock) Run(conn *Connection, args ...string) (string, error) {
+	out, ok := r[strings.Join(args, " ")]
+	if !ok {
+		return "", fmt.Errorf("run ipmitool %s: exit status 1", strings.Join(args, " "))
+	}
+	return out, nil
+}
+
+const fruReturn = `
+FRU Device Description : Builtin FRU Device (ID 0)
+ Chassis Type          : Rack Mount Chassis
+ Chassis Serial        : C1234567
+ Board Mfg             : Supermicro
+ Board Serial          : ZM18AS000000
+ Product Manufacturer  : Supermicro
+ Product Name          : SYS-6029P-TR
+ Product Serial        : S1234567
+`
+
+const dcmiReadingReturn = `
+    Instantaneous power reading:                   220 Watts
+    Minimum during sampling period:                 96 Watts
+    Maximum during sampling period:                388 Watts
+    Average power reading over sample period:      221 Watts
+    IPMI timestamp:                           Thu Jun  2 10:20:28 2016
+    Sampling period:                          00000005 Seconds.
+    Power reading state is:                   activated
+`
+
+const dcmiLimitReturn = `
+    Current Limit State: Power Limit Active
+    Exception actions:   Hard Power Off & Log Event to SEL
+    Power Limit:         500   Watts
+    Correction time:     1000 milliseconds
+    Sampling period:     5 seconds
+`
+
+func TestIpmiDcmi(t *testing.T) {
+	i := &Ipmi{
+		Servers: []string{serv},
+		Dcmi:    true,
+		FruTags: []string{"Product Name", "Product Serial", "Board Asset Tag"},
+		runner: commandMock{
+			"sdr":                  "Ambient Temp     | 20 degrees C      | ok\n",
+			"fru print 0":          fruReturn,
+			"dcmi power reading":   dcmiReadingReturn,
+			"dcmi power get_limit": dcmiLimitReturn,
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, i.Gather(&acc))
+
+	tags := map[string]string{
+		"server":         "192.168.1.1",
+		"product_name":   "SYS-6029P-TR",
+		"product_serial": "S1234567",
+	}
+	acc.AssertContainsTaggedFields(t, "ipmi_power",
+		map[string]interface{}{
+			"instantaneous_watts":     int64(220),
+			"minimum_watts":           int64(96),
+			"maximum_watts":           int64(388),
+			"average_watts":           int64(221),
+			"sampling_period_seconds": int64(5),
+			"reading_active":          true,
+			"power_limit_active":      true,
+			"power_limit_watts":       int64(500),
+			"correction_time_ms":      int64(1000),
+		}, tags)
+	acc.AssertContainsTaggedFields(t, "ipmi_sensor",
+		map[string]interface{}{
+			"value":  float64(20),
+			"status": int(1),
+		},
+		map[string]string{
+			"name":           "ambient_temp",
+			"server":         "192.168.1.1",
+			"unit":           "degrees_c",
+			"product_name":   "SYS-6029P-TR",
+			"product_serial": "S1234567",
+		})
+
+	// the FRU inventory is read once
+	delete(i.runner.(commandMock), "fru print 0")
+	acc = testutil.Accumulator{}
+	require.NoError(t, i.Gather(&acc))
+	assert.Equal(t, "S1234567", acc.Metrics[0].Tags["product_serial"])
+}
+
+func TestIpmiDcmiRaw(t *testing.T) {
+	i := &Ipmi{
+		Servers: []string{serv},
+		Dcmi:    true,
+		runner: commandMock{
+			"sdr": "",
+			// 220, 96, 388 and 221 watts, over 5000 ms, activated
+			"raw 0x2c 0x02 0xdc 0x01 0x00 0x00": " dc dc 00 60 00 84 01 dd 00 c4 2a 50 57 88 13\n 00 00 40\n",
+			// no limit set
+		},
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, i.Gather(&acc))
+	acc.AssertContainsTaggedFields(t, "ipmi_power",
+		map[string]interface{}{
+			"instantaneous_watts":     int64(220),
+			"minimum_watts":           int64(96),
+			"maximum_watts":           int64(388),
+			"average_watts":           int64(221),
+			"sampling_period_seconds": int64(5),
+			"reading_active":          true,
+		},
+		map[string]string{"server": "192.168.1.1"})
+
+	fields := make(map[string]interface{})
+	parseRawPowerLimit(" dc 00 00 01 f4 01 e8 03 00 00 00 00 05 00\n", fields)
+	assert.Equal(t, map[string]interface{}{
+		"power_limit_active": true,
+		"power_limit_watts":  int64(500),
+		"correction_time_ms": int64(1000),
+	}, fields)
+
+	// without DCMI
+	i.runner = commandMock{"sdr": ""}
+	assert.Error(t, i.Gather(&testutil.Accumulator{}))
+}
@@ -0,0 +1,405 @@
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// systemd journal files are read in place, following
+// https://systemd.io/JOURNAL_FILE_FORMAT/. Entries are walked in the order
+// of the entry array chain that starts in the header. The fields of each
+// entry are its data objects.
+
+var journalSignature = []byte("LPKSHHRH")
+
+// Incompatible header flags.
+const (
+	headerCompressedXZ   = 1 << 0
+	headerCompressedLZ4  = 1 << 1
+	headerKeyedHash      = 1 << 2
+	headerCompressedZSTD = 1 << 3
+	headerCompact        = 1 << 4
+	headerSupported      = headerCompressedXZ | headerCompressedLZ4 |
+		headerKeyedHash | headerCompressedZSTD | headerCompact
+)
+
+// Object types.
+const (
+	objectData       = 1
+	objectEntry      = 3
+	objectEntryArray = 6
+)
+
+// Data object compression flags.
+const (
+	objectCompressedXZ   = 1 << 0
+	objectCompressedLZ4  = 1 << 1
+	objectCompressedZSTD = 1 << 2
+)
+
+const objectHeaderSize = 16
+
+// errCompressed is returned for data objects compressed with XZ or ZSTD.
+// Their fields are skipped.
+var errCompressed = errors.New("unsupported compression")
+
+// journalFile is an open journal file.
+type journalFile struct {
+	f       *os.File
+	compact bool
+
+	seqnumID     [16]byte
+	tailSeqnum   uint64
+	tailRealtime uint64
+	entryArray   uint64
+}
+
+// openJournal opens a journal file and reads its header.
+func openJournal(path string) (*journalFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, 208)
+	if _, err := f.ReadAt(header, 0); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: invalid header: %s", path, err)
+	}
+	if !bytes.Equal(header[:8], journalSignature) {
+		f.Close()
+		return nil, fmt.Errorf("%s: not a journal file", path)
+	}
+	flags := binary.LittleEndian.Uint32(header[12:])
+	if flags&^headerSupported != 0 {
+		f.Close()
+		return nil, fmt.Errorf("%s: unsupported incompatible flags 0x%x", path,
+			flags)
+	}
+	j := &journalFile{
+		f:            f,
+		compact:      flags&headerCompact != 0,
+		tailSeqnum:   binary.LittleEndian.Uint64(header[160:]),
+		entryArray:   binary.LittleEndian.Uint64(header[176:]),
+		tailRealtime: binary.LittleEndian.Uint64(header[192:]),
+	}
+	copy(j.seqnumID[:], header[72:88])
+	return j, nil
+}
+
+func (j *journalFile) Close() error {
+	return j.f.Close()
+}
+
+// object reads the object of a type at an offset.
+func (j *journalFile) object(offset uint64, typ byte) ([]byte, byte, error) {
+	var header [objectHeaderSize]byte
+	if _, err := j.f.ReadAt(header[:], int64(offset)); err != nil {
+		return nil, 0, err
+	}
+	size := binary.LittleEndian.Uint64(header[8:])
+	if header[0] != typ || size < objectHeaderSize || size > 1<<30 {
+		return nil, 0, fmt.Errorf("invalid object at 0x%x", offset)
+	}
+	b := make([]byte, size)
+	if _, err := j.f.ReadAt(b, int64(offset)); err != nil {
+		return nil, 0, err
+	}
+	return b, header[1], nil
+}
+
+// items returns the entry offsets in an entry array, and the offset of the
+// next array in the chain.
+func (j *journalFile) items(offset uint64) ([]uint64, uint64, error) {
+	b, _, err := j.object(offset, objectEntryArray)
+	if err != nil {
+		return nil, 0, err
+	}
+	next := binary.LittleEndian.Uint64(b[16:])
+	var items []uint64
+	for p := b[24:]; ; {
+		var item uint64
+		if j.compact && len(p) >= 4 {
+			item, p = uint64(binary.LittleEndian.Uint32(p)), p[4:]
+		} else if !j.compact && len(p) >= 8 {
+			item, p = binary.LittleEndian.Uint64(p), p[8:]
+		}
+		// unused items in the last array are zero
+		if item == 0 {
+			break
+		}
+		items = append(items, item)
+	}
+	return items, next, nil
+}
+
+// entry is an entry of the journal.
+type entry struct {
+	seqnumID  [16]byte
+	seqnum    uint64
+	realtime  uint64
+	monotonic uint64
+	bootID    [16]byte
+	xorHash   uint64
+	fields    map[string]string
+}
+
+// cursor returns the cursor of the entry in the systemd format.
+func (e *entry) cursor() string {
+	return fmt.Sprintf("s=%x;i=%x;b=%x;m=%x;t=%x;x=%x", e.seqnumID[:],
+		e.seqnum, e.bootID[:], e.monotonic, e.realtime, e.xorHash)
+}
+
+// header reads the entry at an offset, without its fields.
+func (j *journalFile) header(offset uint64) (*entry, []byte, error) {
+	b, _, err := j.object(offset, objectEntry)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(b) < 64 {
+		return nil, nil, fmt.Errorf("invalid entry at 0x%x", offset)
+	}
+	e := &entry{
+		seqnumID:  j.seqnumID,
+		seqnum:    binary.LittleEndian.Uint64(b[16:]),
+		realtime:  binary.LittleEndian.Uint64(b[24:]),
+		monotonic: binary.LittleEndian.Uint64(b[32:]),
+		xorHash:   binary.LittleEndian.Uint64(b[56:]),
+	}
+	copy(e.bootID[:], b[40:56])
+	return e, b, nil
+}
+
+// entry reads the entry at an offset, with the fields of its data objects.
+func (j *journalFile) entry(offset uint64) (*entry, error) {
+	e, b, err := j.header(offset)
+	if err != nil {
+		return nil, err
+	}
+	e.fields = make(map[string]string)
+	for p := b[64:]; len(p) > 0; {
+		var data uint64
+		if j.compact {
+			if len(p) < 4 {
+				break
+			}
+			data, p = uint64(binary.LittleEndian.Uint32(p)), p[4:]
+		} else {
+			if len(p) < 16 {
+				break
+			}
+			data, p = binary.LittleEndian.Uint64(p), p[16:]
+		}
+		payload, err := j.data(data)
+		if err != nil {
+			// skip fields with an unsupported compression or invalid data
+			// objects
+			continue
+		}
+		if i := bytes.IndexByte(payload, '='); i > 0 {
+			e.fields[string(payload[:i])] = string(payload[i+1:])
+		}
+	}
+	return e, nil
+}
+
+// data returns the payload of a data object, FIELD=value.
+func (j *journalFile) data(offset uint64) ([]byte, error) {
+	b, flags, err := j.object(offset, objectData)
+	if err != nil {
+		return nil, err
+	}
+	start := 64
+	if j.compact {
+		start = 72
+	}
+	if len(b) < start {
+		return nil, fmt.Errorf("invalid data at 0x%x", offset)
+	}
+	payload := b[start:]
+	switch {
+	case flags&objectCompressedLZ4 != 0:
+		// an 8 byte payload size, then an LZ4 block
+		if len(payload) < 8 {
+			return nil, fmt.Errorf("invalid data at 0x%x", offset)
+		}
+		size := binary.LittleEndian.Uint64(payload)
+		if size > 1<<30 {
+			return nil, fmt.Errorf("invalid data at 0x%x", offset)
+		}
+		return lz4Block(payload[8:], int(size))
+	case flags&(objectCompressedXZ|objectCompressedZSTD) != 0:
+		return nil, errCompressed
+	}
+	return payload, nil
+}
+
+// walk calls fn with the entries of the file in order, until fn returns
+// false. It starts at the first entry array whose last entry is after the
+// cursor.
+func (j *journalFile) walk(after *cursor, fn func(*entry) bool) error {
+	for offset := j.entryArray; offset != 0; {
+		items, next, err := j.items(offset)
+		if err != nil {
+			return err
+		}
+		offset = next
+		if len(items) == 0 {
+			continue
+		}
+		if after != nil {
+			last, _, err := j.header(items[len(items)-1])
+			if err != nil {
+				return err
+			}
+			if !after.before(last) {
+				continue
+			}
+		}
+		for _, item := range items {
+			e, err := j.entry(item)
+			if err != nil {
+				return err
+			}
+			if !fn(e) {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// last returns the last entry of the file, or nil if it has none.
+func (j *journalFile) last() (*entry, error) {
+	var last uint64
+	for offset := j.entryArray; offset != 0; {
+		items, next, err := j.items(offset)
+		if err != nil {
+			return nil, err
+		}
+		if len(items) > 0 {
+			last = items[len(items)-1]
+		}
+		offset = next
+	}
+	if last == 0 {
+		return nil, nil
+	}
+	return j.entry(last)
+}
+
+// cursor is the position of an entry. The sequence number orders entries
+// with the same seqnum id, and the time orders the others.
+type cursor struct {
+	seqnumID [16]byte
+	seqnum   uint64
+	realtime uint64
+}
+
+// parseCursor parses an entry cursor in the systemd format:
+// s=<seqnum id>;i=<seqnum>;b=<boot id>;m=<monotonic>;t=<realtime>;x=<hash>.
+func parseCursor(s string) (*cursor, error) {
+	c := &cursor{}
+	var seen int
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+		var err error
+		switch kv[0] {
+		case "s":
+			var id []byte
+			if id, err = hex.DecodeString(kv[1]); err == nil && len(id) == 16 {
+				copy(c.seqnumID[:], id)
+				seen |= 1
+			}
+		case "i":
+			c.seqnum, err = strconv.ParseUint(kv[1], 16, 64)
+			seen |= 2
+		case "t":
+			c.realtime, err = strconv.ParseUint(kv[1], 16, 64)
+			seen |= 4
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid cursor %q", s)
+		}
+	}
+	if seen != 7 {
+		return nil, fmt.Errorf("invalid cursor %q", s)
+	}
+	return c, nil
+}
+
+// before returns whether the cursor is before an entry. Entries with the
+// same seqnum id compare sequence numbers, and others compare times.
+func (c *cursor) before(e *entry) bool {
+	if c.seqnumID == e.seqnumID {
+		return c.seqnum < e.seqnum
+	}
+	return c.realtime < e.realtime
+}
+
+// lz4Block decompresses an LZ4 block into size bytes.
+func lz4Block(src []byte, size int) ([]byte, error) {
+	errInvalid := errors.New("invalid LZ4 block")
+	length := func(i int, n int) (int, int, error) {
+		if n != 15 {
+			return i, n, nil
+		}
+		for {
+			if i >= len(src) {
+				return 0, 0, errInvalid
+			}
+			b := src[i]
+			i++
+			n += int(b)
+			if b != 255 {
+				return i, n, nil
+			}
+		}
+	}
+
+	dst := make([]byte, 0, size)
+	for i := 0; i < len(src); {
+		token := src[i]
+		var n int
+		var err error
+		if i, n, err = length(i+1, int(token>>4)); err != nil ||
+			i+n > len(src) {
+			return nil, errInvalid
+		}
+		dst = append(dst, src[i:i+n]...)
+		i += n
+		// the last sequence only has literals
+		if i == len(src) {
+			break
+		}
+		if i+2 > len(src) {
+			return nil, errInvalid
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return nil, errInvalid
+		}
+		i, n, err = length(i, int(token&15))
+		if err != nil || len(dst)+n+4 > size {
+			return nil, errInvalid
+		}
+		// the match may overlap the bytes it copies
+		start := len(dst) - offset
+		for k := 0; k < n+4; k++ {
+			dst = append(dst, dst[start+k])
+		}
+	}
+	if len(dst) != size {
+		return nil, errInvalid
+	}
+	return dst, nil
+}
@@ -0,0 +1,340 @@
+package journald
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gobwas/glob"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// priorities are the names of the syslog priorities.
+var priorities = []string{"emerg", "alert", "crit", "err", "warning",
+	"notice", "info", "debug"}
+
+// integerFields are the journal fields whose values are integers.
+var integerFields = map[string]bool{
+	"_PID": true, "_UID": true, "_GID": true, "SYSLOG_PID": true,
+	"ERRNO": true, "CODE_LINE": true, "_AUDIT_SESSION": true,
+	"_AUDIT_LOGINUID": true,
+}
+
+// cursorKey is the state key that holds the cursor of the last entry read.
+const cursorKey = "cursor"
+
+type Journald struct {
+	// Paths are journal files, or directories of journal files. The machine
+	// id subdirectories of a directory are read too.
+	Paths         []string
+	FromBeginning bool
+	// Units and SyslogIdentifiers are globs that filter entries by their
+	// _SYSTEMD_UNIT and SYSLOG_IDENTIFIER. Empty lists read every entry.
+	Units             []string
+	SyslogIdentifiers []string `toml:"syslog_identifiers"`
+	// Priority is the least severe priority to read.
+	Priority string
+	// TagFields are the journal fields read as tags, and Fields those read
+	// as fields. "*" in Fields selects every other journal field.
+	TagFields []string `toml:"tag_fields"`
+	Fields    []string
+	// MaxEntries is the maximum number of entries read per gather.
+	MaxEntries int `toml:"max_entries"`
+
+	units       glob.Glob
+	identifiers glob.Glob
+	priority    int
+	compiled    bool
+
+	// cursor is the cursor of the last entry read. It is saved in the state.
+	cursor string
+	state  *state.State
+	sync.Mutex
+}
+
+var sampleConfig = `
+  ## Journal files, or directories of system and user journal files. The
+  ## machine id subdirectories of a directory are read too.
+  # paths = ["/var/log/journal", "/run/log/journal"]
+
+  ## Read the journal from the beginning instead of from the end. A cursor
+  ## saved in the state_store takes precedence.
+  # from_beginning = false
+
+  ## Only read entries whose _SYSTEMD_UNIT or SYSLOG_IDENTIFIER match these
+  ## globs. Empty lists read every entry.
+  # units = ["ssh.service", "docker*"]
+  # syslog_identifiers = ["kernel"]
+
+  ## Least severe priority to read: emerg, alert, crit, err, warning,
+  ## notice, info or debug
+  # priority = "debug"
+
+  ## Journal fields to read as tags and as fields. "*" in fields selects
+  ## every other field. Names are lower cased and lose leading underscores.
+  # tag_fields = ["_SYSTEMD_UNIT", "SYSLOG_IDENTIFIER"]
+  # fields = ["MESSAGE", "_PID"]
+
+  ## Maximum number of entries read per gather
+  # max_entries = 10000
+`
+
+func (j *Journald) SampleConfig() string {
+	return sampleConfig
+}
+
+func (j *Journald) Description() string {
+	return "Read the entries of the systemd journal"
+}
+
+// SetState sets the state that stores the cursor of the last entry read, so
+// that reading resumes after it when telegraf restarts.
+func (j *Journald) SetState(s *state.State) {
+	j.state = s
+}
+
+func (j *Journald) compile() error {
+	var err error
+	if j.units, err = internal.CompileFilter(j.Units); err != nil {
+		return err
+	}
+	j.identifiers, err = internal.CompileFilter(j.SyslogIdentifiers)
+	if err != nil {
+		return err
+	}
+	j.priority = -1
+	for i, p := range priorities {
+		if p == j.Priority {
+			j.priority = i
+		}
+	}
+	if j.priority < 0 {
+		return fmt.Errorf("invalid priority %q, must be one of %s", j.Priority,
+			strings.Join(priorities, ", "))
+	}
+	j.compiled = true
+	return nil
+}
+
+// Gather reads the journal entries after the cursor from every file, in time
+// order.
+func (j *Journald) Gather(acc telegraf.Accumulator) error {
+	j.Lock()
+	defer j.Unlock()
+
+	if !j.compiled {
+		if err := j.compile(); err != nil {
+			return err
+		}
+	}
+	if j.cursor == "" {
+		j.cursor, _ = j.state.Get(cursorKey)
+	}
+	var after *cursor
+	if j.cursor != "" {
+		var err error
+		if after, err = parseCursor(j.cursor); err != nil {
+			log.Printf("journald: %s, reading from the end of the journal", err)
+			j.cursor = ""
+		}
+	}
+
+	files, err := j.open()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	if after == nil && !j.FromBeginning {
+		// without a cursor, start after the last entry
+		var last *entry
+		for _, f := range files {
+			e, err := f.last()
+			if err != nil {
+				log.Printf("journald: reading %s: %s", f.f.Name(), err)
+				continue
+			}
+			if e != nil && (last == nil || last.realtime < e.realtime) {
+				last = e
+			}
+		}
+		if last != nil {
+			j.setCursor(last.cursor())
+		}
+		return nil
+	}
+
+	var entries []*entry
+	for _, f := range files {
+		// skip files without entries after the cursor
+		if after != nil && (f.seqnumID == after.seqnumID &&
+			f.tailSeqnum <= after.seqnum || f.seqnumID != after.seqnumID &&
+			f.tailRealtime <= after.realtime) {
+			continue
+		}
+		n := 0
+		err := f.walk(after, func(e *entry) bool {
+			if after == nil || after.before(e) {
+				entries = append(entries, e)
+				n++
+			}
+			return j.MaxEntries <= 0 || n < j.MaxEntries
+		})
+		if err != nil {
+			log.Printf("journald: reading %s: %s", f.f.Name(), err)
+		}
+	}
+	sort.Stable(byTime(entries))
+	if j.MaxEntries > 0 && len(entries) > j.MaxEntries {
+		entries = entries[:j.MaxEntries]
+	}
+
+	for _, e := range entries {
+		j.add(acc, e)
+	}
+	if len(entries) > 0 {
+		j.setCursor(entries[len(entries)-1].cursor())
+	}
+	return nil
+}
+
+// open opens the journal files in the paths, and skips other files.
+func (j *Journald) open() ([]*journalFile, error) {
+	var paths []string
+	for _, path := range j.Paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			paths = append(paths, path)
+			continue
+		}
+		for _, pattern := range []string{"*.journal", "*/*.journal"} {
+			matches, err := filepath.Glob(filepath.Join(path, pattern))
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, matches...)
+		}
+	}
+
+	var files []*journalFile
+	for _, path := range paths {
+		f, err := openJournal(path)
+		if err != nil {
+			log.Printf("journald: skipping %s", err)
+			continue
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// add adds the metric of an entry, unless it is filtered out.
+func (j *Journald) add(acc telegraf.Accumulator, e *entry) {
+	if j.units != nil && !j.units.Match(e.fields["_SYSTEMD_UNIT"]) {
+		return
+	}
+	if j.identifiers != nil &&
+		!j.identifiers.Match(e.fields["SYSLOG_IDENTIFIER"]) {
+		return
+	}
+	tags := make(map[string]string)
+	if p, err := strconv.Atoi(e.fields["PRIORITY"]); err == nil &&
+		p >= 0 && p < len(priorities) {
+		if p > j.priority {
+			return
+		}
+		tags["priority"] = priorities[p]
+	}
+
+	isTag := make(map[string]bool)
+	for _, name := range j.TagFields {
+		isTag[name] = true
+		if v, ok := e.fields[name]; ok && v != "" {
+			tags[fieldName(name)] = v
+		}
+	}
+	fields := make(map[string]interface{})
+	for _, name := range j.Fields {
+		if name == "*" {
+			for name, v := range e.fields {
+				if !isTag[name] && name != "PRIORITY" {
+					fields[fieldName(name)] = fieldValue(name, v)
+				}
+			}
+			continue
+		}
+		if v, ok := e.fields[name]; ok {
+			fields[fieldName(name)] = fieldValue(name, v)
+		}
+	}
+	if len(fields) == 0 {
+		return
+	}
+	acc.AddFields("journald", fields, tags,
+		time.Unix(0, int64(e.realtime)*int64(time.Microsecond)))
+}
+
+func (j *Journald) setCursor(c string) {
+	j.cursor = c
+	if err := j.state.Set(cursorKey, c); err != nil {
+		log.Printf("journald: saving the cursor: %s", err)
+	}
+}
+
+// byTime sorts entries by their realtime timestamp, and then sequence number.
+type byTime []*entry
+
+func (e byTime) Len() int      { return len(e) }
+func (e byTime) Swap(i, j int) { e[i], e[j] = e[j], e[i] }
+func (e byTime) Less(i, j int) bool {
+	if e[i].realtime != e[j].realtime {
+		return e[i].realtime < e[j].realtime
+	}
+	return e[i].seqnum < e[j].seqnum
+}
+
+// fieldName returns the tag or field name for a journal field. It is lower
+// case without leading underscores, so _SYSTEMD_UNIT becomes systemd_unit.
+func fieldName(name string) string {
+	return strings.ToLower(strings.TrimLeft(name, "_"))
+}
+
+// fieldValue returns the value of a journal field. Values of the
+// integerFields are integers.
+func fieldValue(name, v string) interface{} {
+	if integerFields[name] {
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	}
+	return v
+}
+
+func init() {
+	inputs.Add("journald", func() telegraf.Input {
+		return &Journald{
+			Paths:      []string{"/var/log/journal", "/run/log/journal"},
+			Priority:   "debug",
+			TagFields:  []string{"_SYSTEMD_UNIT", "SYSLOG_IDENTIFIER"},
+			Fields:     []string{"MESSAGE", "_PID"},
+			MaxEntries: 10000,
+		}
+	})
+}
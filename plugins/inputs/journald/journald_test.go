@@ -0,0 +1,255 @@
+package journald
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/internal/state"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+var testSeqnumID = [16]byte{0x8e, 0x90, 0x97, 0x06, 0x93, 0xb5, 0x42, 0xfc,
+	0xbe, 0x74, 0xc0, 0x54, 0xd1, 0x5a, 0x55, 0xed}
+
+// lz4Message is testMessage as an LZ4 block, with a match for the repeated
+// "ab".
+var (
+	testMessage = "MESSAGE=" + strings.Repeat("ab", 20)
+	lz4Message  = append(append(append([]byte{0xaf}, testMessage[:10]...),
+		0x02, 0x00, 14, 0x50), testMessage[43:]...)
+)
+
+// journalWriter writes a journal file in the regular format, without hash
+// tables, with two items per entry array.
+type journalWriter struct {
+	buf     []byte
+	entries []uint64
+	seqnum  uint64
+	flags   uint32
+}
+
+func newJournalWriter() *journalWriter {
+	return &journalWriter{buf: make([]byte, 208)}
+}
+
+func (w *journalWriter) object(typ, flags byte, body []byte) uint64 {
+	offset := uint64(len(w.buf))
+	header := make([]byte, 16)
+	header[0], header[1] = typ, flags
+	binary.LittleEndian.PutUint64(header[8:], uint64(16+len(body)))
+	w.buf = append(append(w.buf, header...), body...)
+	for len(w.buf)%8 != 0 {
+		w.buf = append(w.buf, 0)
+	}
+	return offset
+}
+
+func (w *journalWriter) data(payload []byte, flags byte) uint64 {
+	return w.object(objectData, flags, append(make([]byte, 48), payload...))
+}
+
+// entry writes an entry with FIELD=value fields at a time. A data object
+// of lz4Message is compressed with LZ4.
+func (w *journalWriter) entry(realtime uint64, fields []string,
+	compressed bool) {
+	var items []uint64
+	for _, f := range fields {
+		items = append(items, w.data([]byte(f), 0))
+	}
+	if compressed {
+		payload := make([]byte, 8)
+		binary.LittleEndian.PutUint64(payload, uint64(len(testMessage)))
+		items = append(items, w.data(append(payload, lz4Message...),
+			objectCompressedLZ4))
+		w.flags |= headerCompressedLZ4
+	}
+
+	w.seqnum++
+	body := make([]byte, 48)
+	binary.LittleEndian.PutUint64(body[0:], w.seqnum)
+	binary.LittleEndian.PutUint64(body[8:], realtime)
+	binary.LittleEndian.PutUint64(body[16:], realtime/2)
+	for _, item := range items {
+		var b [16]byte
+		binary.LittleEndian.PutUint64(b[:], item)
+		body = append(body, b[:]...)
+	}
+	w.entries = append(w.entries, w.object(objectEntry, 0, body))
+}
+
+// write writes the file. Its last entry array has two unused items.
+func (w *journalWriter) write(t *testing.T, path string) {
+	var head, prev uint64
+	for i := 0; i < len(w.entries); i += 2 {
+		body := make([]byte, 8+2*8)
+		for k := 0; k < 2 && i+k < len(w.entries); k++ {
+			binary.LittleEndian.PutUint64(body[8+k*8:], w.entries[i+k])
+		}
+		if i+2 >= len(w.entries) {
+			body = append(body, make([]byte, 16)...)
+		}
+		offset := w.object(objectEntryArray, 0, body)
+		if prev == 0 {
+			head = offset
+		} else {
+			binary.LittleEndian.PutUint64(w.buf[prev+16:], offset)
+		}
+		prev = offset
+	}
+
+	h := w.buf[:208]
+	copy(h, journalSignature)
+	binary.LittleEndian.PutUint32(h[12:], w.flags)
+	copy(h[72:], testSeqnumID[:])
+	binary.LittleEndian.PutUint64(h[88:], 208)
+	binary.LittleEndian.PutUint64(h[96:], uint64(len(w.buf)-208))
+	binary.LittleEndian.PutUint64(h[152:], uint64(len(w.entries)))
+	binary.LittleEndian.PutUint64(h[160:], w.seqnum)
+	binary.LittleEndian.PutUint64(h[168:], 1)
+	binary.LittleEndian.PutUint64(h[176:], head)
+	require.NoError(t, ioutil.WriteFile(path, w.buf, 0600))
+}
+
+func newJournald(path string) *Journald {
+	return &Journald{
+		Paths:      []string{path},
+		Priority:   "debug",
+		TagFields:  []string{"_SYSTEMD_UNIT", "SYSLOG_IDENTIFIER"},
+		Fields:     []string{"MESSAGE", "_PID"},
+		MaxEntries: 10000,
+	}
+}
+
+func TestGather(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	machine := filepath.Join(dir, "fed6b2924c424cf1b9a322f606b4de6d")
+	require.NoError(t, os.Mkdir(machine, 0700))
+
+	w := newJournalWriter()
+	w.entry(1465839830000001, []string{"_SYSTEMD_UNIT=ssh.service",
+		"SYSLOG_IDENTIFIER=sshd", "PRIORITY=3", "_PID=812",
+		"MESSAGE=Failed password for root"}, false)
+	w.entry(1465839830000002, []string{"SYSLOG_IDENTIFIER=myapp",
+		"PRIORITY=6", "_PID=900"}, true)
+	w.entry(1465839830000003, []string{"SYSLOG_IDENTIFIER=myapp",
+		"PRIORITY=7", "MESSAGE=debug line", "CODE_LINE=12"}, false)
+	w.write(t, filepath.Join(machine, "system.journal"))
+
+	acc := &testutil.Accumulator{}
+	j := newJournald(dir)
+	j.FromBeginning = true
+	require.NoError(t, j.Gather(acc))
+
+	require.Len(t, acc.Metrics, 3)
+	acc.AssertContainsTaggedFields(t, "journald",
+		map[string]interface{}{
+			"message": "Failed password for root",
+			"pid":     int64(812),
+		},
+		map[string]string{
+			"priority":          "err",
+			"systemd_unit":      "ssh.service",
+			"syslog_identifier": "sshd",
+		})
+	assert.Equal(t, time.Unix(1465839830, 1000), acc.Metrics[0].Time)
+	// the compressed message
+	assert.Equal(t, testMessage[len("MESSAGE="):],
+		acc.Metrics[1].Fields["message"])
+	assert.Equal(t, "debug", acc.Metrics[2].Tags["priority"])
+	assert.Equal(t,
+		"s=8e90970693b542fcbe74c054d15a55ed;i=3;b=00000000000000000000000000000000;"+
+			"m=29a96372eb0c1;t=5352c6e5d6183;x=0", j.cursor)
+
+	// the filtered entries after the cursor
+	w.entry(1465839840000000, []string{"SYSLOG_IDENTIFIER=myapp",
+		"PRIORITY=7", "MESSAGE=filtered"}, false)
+	w.entry(1465839850000000, []string{"SYSLOG_IDENTIFIER=myapp",
+		"PRIORITY=4", "MESSAGE=low disk", "CODE_LINE=20"}, false)
+	w.write(t, filepath.Join(machine, "system.journal"))
+
+	acc = &testutil.Accumulator{}
+	j.Priority = "info"
+	j.compiled = false
+	j.Fields = []string{"*"}
+	require.NoError(t, j.Gather(acc))
+	require.Len(t, acc.Metrics, 1)
+	acc.AssertContainsTaggedFields(t, "journald",
+		map[string]interface{}{
+			"message":   "low disk",
+			"code_line": int64(20),
+		},
+		map[string]string{
+			"priority":          "warning",
+			"syslog_identifier": "myapp",
+		})
+	assert.Contains(t, j.cursor, ";i=5;")
+
+	acc = &testutil.Accumulator{}
+	require.NoError(t, j.Gather(acc))
+	assert.Empty(t, acc.Metrics)
+}
+
+func TestGatherState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journald")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "system.journal")
+
+	w := newJournalWriter()
+	w.entry(1465839830000000, []string{"MESSAGE=before"}, false)
+	w.write(t, path)
+
+	store, err := state.Open("file://"+dir, 0)
+	require.NoError(t, err)
+	st, err := store.State("inputs.journald")
+	require.NoError(t, err)
+
+	// without a cursor, the journal is read from its end
+	acc := &testutil.Accumulator{}
+	j := newJournald(path)
+	j.SetState(st)
+	require.NoError(t, j.Gather(acc))
+	assert.Empty(t, acc.Metrics)
+	require.NoError(t, store.Close())
+
+	w.entry(1465839840000000, []string{"MESSAGE=after"}, false)
+	w.write(t, path)
+
+	// a restart resumes after the saved cursor
+	store, err = state.Open("file://"+dir, 0)
+	require.NoError(t, err)
+	defer store.Close()
+	st, err = store.State("inputs.journald")
+	require.NoError(t, err)
+	acc = &testutil.Accumulator{}
+	j = newJournald(path)
+	j.SetState(st)
+	require.NoError(t, j.Gather(acc))
+	require.Len(t, acc.Metrics, 1)
+	assert.Equal(t, "after", acc.Metrics[0].Fields["message"])
+}
+
+func TestParseCursor(t *testing.T) {
+	c, err := parseCursor("s=8e90970693b542fcbe74c054d15a55ed;i=5;" +
+		"b=e6ee7b31e7244b828335106642ef96f5;m=5b1936488;t=65dfa71ef87d9;" +
+		"x=af1f0b020b054a29")
+	require.NoError(t, err)
+	assert.Equal(t, testSeqnumID, c.seqnumID)
+	assert.Equal(t, uint64(5), c.seqnum)
+	assert.Equal(t, uint64(0x65dfa71ef87d9), c.realtime)
+
+	_, err = parseCursor("s=8e90970693b542fcbe74c054d15a55ed;i=5")
+	assert.Error(t, err)
+	_, err = parseCursor("garbage")
+	assert.Error(t, err)
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
 
@@ -35,6 +36,8 @@ type Kafka struct {
 	// channel for all kafka consumer errors
 	errs <-chan *sarama.ConsumerError
 	done chan struct{}
+	// pause stops the receiver from consuming messages while paused
+	pause internal.PauseGate
 
 	// keep the accumulator internally:
 	acc telegraf.Accumulator
@@ -124,6 +127,8 @@ func (k *Kafka) Start(acc telegraf.Accumulator) error {
 // influxdb metric points.
 func (k *Kafka) receiver() {
 	for {
+		// messages are left in kafka, uncommitted, while paused
+		k.pause.Wait(k.done)
 		select {
 		case <-k.done:
 			return
@@ -151,6 +156,16 @@ func (k *Kafka) receiver() {
 	}
 }
 
+// Pause stops consuming messages, which are left in kafka until Resume.
+func (k *Kafka) Pause() {
+	k.pause.Pause()
+}
+
+// Resume consumes messages again.
+func (k *Kafka) Resume() {
+	k.pause.Resume()
+}
+
 func (k *Kafka) Stop() {
 	k.Lock()
 	defer k.Unlock()
@@ -0,0 +1,451 @@
+package kafka_consumer_lag
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/gobwas/glob"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+type KafkaConsumerLag struct {
+	// Brokers are the seed brokers of the cluster.
+	Brokers []string
+	// IncludeGroups and ExcludeGroups are globs matching the consumer groups
+	// to gather. An empty IncludeGroups gathers every group.
+	IncludeGroups []string `toml:"include_groups"`
+	ExcludeGroups []string `toml:"exclude_groups"`
+	// IncludeTopics and ExcludeTopics are globs matching the topics to
+	// gather. An empty IncludeTopics gathers every topic but the internal ones.
+	IncludeTopics []string `toml:"include_topics"`
+	ExcludeTopics []string `toml:"exclude_topics"`
+	// EstimateWindow is how long the end offsets are kept to estimate the lag
+	// in seconds.
+	EstimateWindow internal.Duration `toml:"estimate_window"`
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client  sarama.Client
+	config  *sarama.Config
+	filters map[string]glob.Glob
+	// the connections to the brokers listing their groups, by address
+	brokers map[string]*sarama.Broker
+	// the end offsets of each partition within the estimate window
+	history map[topicPartition][]offsetSample
+}
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// offsetSample is the end offset of a partition at a time.
+type offsetSample struct {
+	time   time.Time
+	offset int64
+}
+
+var sampleConfig = `
+  ## Seed brokers of the Kafka cluster
+  brokers = ["localhost:9092"]
+
+  ## Consumer groups to gather; all groups if empty
+  # include_groups = []
+  # exclude_groups = ["console-consumer-*"]
+
+  ## Topics to gather; all but the internal topics if empty
+  # include_topics = []
+  # exclude_topics = []
+
+  ## How long to keep the end offsets gathered each interval. The lag in
+  ## seconds is estimated from them.
+  # estimate_window = "15m"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (k *KafkaConsumerLag) SampleConfig() string {
+	return sampleConfig
+}
+
+func (k *KafkaConsumerLag) Description() string {
+	return "Read the lag of Kafka consumer groups from the brokers"
+}
+
+func (k *KafkaConsumerLag) connect() error {
+	k.filters = make(map[string]glob.Glob)
+	for name, patterns := range map[string][]string{
+		"include_groups": k.IncludeGroups,
+		"exclude_groups": k.ExcludeGroups,
+		"include_topics": k.IncludeTopics,
+		"exclude_topics": k.ExcludeTopics,
+	} {
+		g, err := internal.CompileFilter(patterns)
+		if err != nil {
+			return fmt.Errorf("kafka_consumer_lag: %s: %s", name, err)
+		}
+		k.filters[name] = g
+	}
+
+	config := sarama.NewConfig()
+	config.ClientID = "telegraf"
+	tlsConfig, err := internal.GetTLSConfig(
+		k.SSLCert, k.SSLKey, k.SSLCA, k.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		config.Net.TLS.Config = tlsConfig
+		config.Net.TLS.Enable = true
+	}
+
+	client, err := sarama.NewClient(k.Brokers, config)
+	if err != nil {
+		return fmt.Errorf("kafka_consumer_lag: connecting to %s: %s",
+			strings.Join(k.Brokers, ","), err)
+	}
+	k.client = client
+	k.config = config
+	k.brokers = make(map[string]*sarama.Broker)
+	k.history = make(map[topicPartition][]offsetSample)
+	return nil
+}
+
+// included returns whether a group or topic name passes the include and
+// exclude filters of its kind, "groups" or "topics".
+func (k *KafkaConsumerLag) included(kind, name string) bool {
+	if include := k.filters["include_"+kind]; include != nil &&
+		!include.Match(name) {
+		return false
+	}
+	if exclude := k.filters["exclude_"+kind]; exclude != nil &&
+		exclude.Match(name) {
+		return false
+	}
+	return true
+}
+
+func (k *KafkaConsumerLag) Gather(acc telegraf.Accumulator) error {
+	if k.client == nil {
+		if err := k.connect(); err != nil {
+			return err
+		}
+	}
+	if err := k.client.RefreshMetadata(); err != nil {
+		return fmt.Errorf("kafka_consumer_lag: refreshing metadata: %s", err)
+	}
+
+	now := time.Now()
+	ends, err := k.endOffsets()
+	if err != nil {
+		return err
+	}
+	for tp, offset := range ends {
+		k.record(tp, offsetSample{time: now, offset: offset})
+	}
+	// forget the partitions of deleted topics
+	for tp := range k.history {
+		if _, ok := ends[tp]; !ok {
+			delete(k.history, tp)
+		}
+	}
+
+	groups, err := k.groups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		if err := k.gatherGroup(group, ends, now, acc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// endOffsets returns the end offset for every partition in the gathered
+// topics. The offsets are requested from the leader of each partition.
+func (k *KafkaConsumerLag) endOffsets() (map[topicPartition]int64, error) {
+	topics, err := k.client.Topics()
+	if err != nil {
+		return nil, err
+	}
+	requests := make(map[*sarama.Broker]*sarama.OffsetRequest)
+	for _, topic := range topics {
+		if len(k.IncludeTopics) == 0 && strings.HasPrefix(topic, "__") ||
+			!k.included("topics", topic) {
+			continue
+		}
+		partitions, err := k.client.Partitions(topic)
+		if err != nil {
+			return nil, err
+		}
+		for _, partition := range partitions {
+			leader, err := k.client.Leader(topic, partition)
+			if err != nil {
+				return nil, err
+			}
+			request, ok := requests[leader]
+			if !ok {
+				request = &sarama.OffsetRequest{}
+				requests[leader] = request
+			}
+			request.AddBlock(topic, partition, sarama.OffsetNewest, 1)
+		}
+	}
+
+	ends := make(map[topicPartition]int64)
+	for leader, request := range requests {
+		response, err := leader.GetAvailableOffsets(request)
+		if err != nil {
+			return nil, fmt.Errorf("kafka_consumer_lag: offsets of broker %s: %s",
+				leader.Addr(), err)
+		}
+		for topic, blocks := range response.Blocks {
+			for partition, block := range blocks {
+				if block.Err != sarama.ErrNoError {
+					return nil, fmt.Errorf(
+						"kafka_consumer_lag: offset of %s/%d: %s",
+						topic, partition, block.Err)
+				}
+				if len(block.Offsets) == 0 {
+					return nil, fmt.Errorf(
+						"kafka_consumer_lag: no offset of %s/%d", topic, partition)
+				}
+				ends[topicPartition{topic, partition}] = block.Offsets[0]
+			}
+		}
+	}
+	return ends, nil
+}
+
+// groups returns the consumer groups to gather. Each broker lists only the
+// groups it coordinates, so every broker of the cluster is asked.
+func (k *KafkaConsumerLag) groups() ([]string, error) {
+	brokers, err := k.clusterBrokers()
+	if err != nil {
+		return nil, err
+	}
+	var groups []string
+	for _, addr := range brokers {
+		broker, err := k.broker(addr)
+		if err != nil {
+			return nil, err
+		}
+		response, err := broker.ListGroups(&sarama.ListGroupsRequest{})
+		if err != nil {
+			k.closeBroker(addr)
+			return nil, fmt.Errorf("kafka_consumer_lag: groups of broker %s: %s",
+				addr, err)
+		}
+		if response.Err != sarama.ErrNoError {
+			return nil, fmt.Errorf("kafka_consumer_lag: groups of broker %s: %s",
+				addr, response.Err)
+		}
+		for group, protocolType := range response.Groups {
+			// groups that only commit offsets have no protocol type
+			if protocolType != "consumer" && protocolType != "" ||
+				!k.included("groups", group) {
+				continue
+			}
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// clusterBrokers returns the cluster broker addresses, as listed in the
+// metadata from the first seed broker that answers.
+func (k *KafkaConsumerLag) clusterBrokers() ([]string, error) {
+	var lastErr error
+	for _, addr := range k.Brokers {
+		broker, err := k.broker(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		response, err := broker.GetMetadata(&sarama.MetadataRequest{})
+		if err != nil {
+			k.closeBroker(addr)
+			lastErr = err
+			continue
+		}
+		var addrs []string
+		for _, b := range response.Brokers {
+			addrs = append(addrs, b.Addr())
+		}
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("kafka_consumer_lag: brokers of %s: %s",
+		strings.Join(k.Brokers, ","), lastErr)
+}
+
+// broker returns the connection to the broker at addr, opening it if needed.
+func (k *KafkaConsumerLag) broker(addr string) (*sarama.Broker, error) {
+	if broker, ok := k.brokers[addr]; ok {
+		return broker, nil
+	}
+	broker := sarama.NewBroker(addr)
+	if err := broker.Open(k.config); err != nil &&
+		err != sarama.ErrAlreadyConnected {
+		return nil, fmt.Errorf("kafka_consumer_lag: connecting to %s: %s",
+			addr, err)
+	}
+	k.brokers[addr] = broker
+	return broker, nil
+}
+
+// closeBroker closes the connection to the broker at addr, so that the next
+// gather opens a new one.
+func (k *KafkaConsumerLag) closeBroker(addr string) {
+	if broker, ok := k.brokers[addr]; ok {
+		broker.Close()
+		delete(k.brokers, addr)
+	}
+}
+
+// gatherGroup gathers the lag of each partition where a group committed an
+// offset, and the total lag of each topic.
+func (k *KafkaConsumerLag) gatherGroup(
+	group string,
+	ends map[topicPartition]int64,
+	now time.Time,
+	acc telegraf.Accumulator,
+) error {
+	coordinator, err := k.client.Coordinator(group)
+	if err != nil {
+		return fmt.Errorf("kafka_consumer_lag: coordinator of group %s: %s",
+			group, err)
+	}
+	request := &sarama.OffsetFetchRequest{ConsumerGroup: group, Version: 1}
+	for tp := range ends {
+		request.AddPartition(tp.topic, tp.partition)
+	}
+	response, err := coordinator.FetchOffset(request)
+	if err != nil {
+		return fmt.Errorf("kafka_consumer_lag: offsets of group %s: %s",
+			group, err)
+	}
+
+	type topicLag struct {
+		lag        int64
+		lagSeconds float64
+		partitions int64
+	}
+	topics := make(map[string]*topicLag)
+	for tp, end := range ends {
+		block := response.GetBlock(tp.topic, tp.partition)
+		// skip the partitions without a committed offset
+		if block == nil || block.Err != sarama.ErrNoError || block.Offset < 0 {
+			continue
+		}
+		lag := end - block.Offset
+		if lag < 0 {
+			lag = 0
+		}
+		fields := map[string]interface{}{
+			"committed_offset": block.Offset,
+			"end_offset":       end,
+			"lag":              lag,
+		}
+		seconds, estimated := estimateLagSeconds(k.history[tp], block.Offset, now)
+		if estimated {
+			fields["lag_seconds"] = seconds
+		}
+		acc.AddFields("kafka_consumer_lag", fields, map[string]string{
+			"group":     group,
+			"topic":     tp.topic,
+			"partition": strconv.Itoa(int(tp.partition)),
+		}, now)
+
+		t, ok := topics[tp.topic]
+		if !ok {
+			t = &topicLag{}
+			topics[tp.topic] = t
+		}
+		t.lag += lag
+		t.partitions++
+		if seconds > t.lagSeconds {
+			t.lagSeconds = seconds
+		}
+	}
+	for topic, t := range topics {
+		acc.AddFields("kafka_consumer_group", map[string]interface{}{
+			"lag":             t.lag,
+			"max_lag_seconds": t.lagSeconds,
+			"partitions":      t.partitions,
+		}, map[string]string{"group": group, "topic": topic}, now)
+	}
+	return nil
+}
+
+// record adds an end offset sample to the history of a partition. Samples
+// older than the estimate window are dropped, except the newest of them.
+func (k *KafkaConsumerLag) record(tp topicPartition, sample offsetSample) {
+	samples := append(k.history[tp], sample)
+	start := sample.time.Add(-k.EstimateWindow.Duration)
+	i := 0
+	for i+1 < len(samples) && !samples[i+1].time.After(start) {
+		i++
+	}
+	k.history[tp] = samples[i:]
+}
+
+// estimateLagSeconds estimates how long ago the message at the committed
+// offset was produced. It finds when the end offset passed the committed
+// offset by interpolating between the samples. If the committed offset is
+// older than every sample, it extrapolates from the average rate.
+func estimateLagSeconds(samples []offsetSample, committed int64,
+	now time.Time) (float64, bool) {
+	if len(samples) == 0 {
+		return 0, false
+	}
+	newest := samples[len(samples)-1]
+	if committed >= newest.offset {
+		return 0, true
+	}
+	for i := 1; i < len(samples); i++ {
+		prev, next := samples[i-1], samples[i]
+		if prev.offset <= committed && committed < next.offset {
+			fraction := float64(committed-prev.offset) /
+				float64(next.offset-prev.offset)
+			produced := prev.time.Add(
+				time.Duration(fraction * float64(next.time.Sub(prev.time))))
+			return now.Sub(produced).Seconds(), true
+		}
+	}
+
+	oldest := samples[0]
+	elapsed := newest.time.Sub(oldest.time).Seconds()
+	if elapsed <= 0 || newest.offset <= oldest.offset {
+		return 0, false
+	}
+	rate := float64(newest.offset-oldest.offset) / elapsed
+	return now.Sub(oldest.time).Seconds() +
+		float64(oldest.offset-committed)/rate, true
+}
+
+func init() {
+	inputs.Add("kafka_consumer_lag", func() telegraf.Input {
+		return &KafkaConsumerLag{
+			EstimateWindow: internal.Duration{Duration: 15 * time.Minute},
+		}
+	})
+}
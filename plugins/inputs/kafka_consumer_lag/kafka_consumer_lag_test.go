@@ -0,0 +1,158 @@
+package kafka_consumer_lag
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+func handlers(t *testing.T, broker *sarama.MockBroker,
+	end0, end1 int64) map[string]sarama.MockResponse {
+	return map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("metrics", 0, broker.BrokerID()).
+			SetLeader("metrics", 1, broker.BrokerID()).
+			SetLeader("logs", 0, broker.BrokerID()).
+			SetLeader("__consumer_offsets", 0, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("metrics", 0, sarama.OffsetNewest, end0).
+			SetOffset("metrics", 1, sarama.OffsetNewest, end1).
+			SetOffset("logs", 0, sarama.OffsetNewest, 10),
+		"ListGroupsRequest": sarama.NewMockWrapper(&sarama.ListGroupsResponse{
+			Groups: map[string]string{
+				"app":                "consumer",
+				"console-consumer-1": "consumer",
+				"connect-cluster":    "connect",
+			},
+		}),
+		"ConsumerMetadataRequest": sarama.NewMockConsumerMetadataResponse(t).
+			SetCoordinator("app", broker).
+			SetCoordinator("console-consumer-1", broker),
+		// no offset is committed for partition 1 of metrics
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("app", "metrics", 0, 90, "", sarama.ErrNoError).
+			SetOffset("app", "logs", 0, 10, "", sarama.ErrNoError),
+	}
+}
+
+func TestGather(t *testing.T) {
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	broker.SetHandlerByMap(handlers(t, broker, 100, 50))
+
+	k := &KafkaConsumerLag{
+		Brokers:        []string{broker.Addr()},
+		ExcludeGroups:  []string{"console-consumer-*"},
+		EstimateWindow: internal.Duration{Duration: time.Minute},
+	}
+	acc := &testutil.Accumulator{}
+	require.NoError(t, k.Gather(acc))
+
+	acc.AssertContainsTaggedFields(t, "kafka_consumer_lag",
+		map[string]interface{}{
+			"committed_offset": int64(90),
+			"end_offset":       int64(100),
+			"lag":              int64(10),
+		},
+		map[string]string{"group": "app", "topic": "metrics", "partition": "0"})
+	// caught up
+	acc.AssertContainsTaggedFields(t, "kafka_consumer_lag",
+		map[string]interface{}{
+			"committed_offset": int64(10),
+			"end_offset":       int64(10),
+			"lag":              int64(0),
+			"lag_seconds":      float64(0),
+		},
+		map[string]string{"group": "app", "topic": "logs", "partition": "0"})
+	acc.AssertContainsTaggedFields(t, "kafka_consumer_group",
+		map[string]interface{}{
+			"lag":             int64(10),
+			"max_lag_seconds": float64(0),
+			"partitions":      int64(1),
+		},
+		map[string]string{"group": "app", "topic": "metrics"})
+	for _, m := range acc.Metrics {
+		assert.Equal(t, "app", m.Tags["group"])
+		assert.NotEqual(t, "__consumer_offsets", m.Tags["topic"])
+	}
+	assert.Len(t, acc.Metrics, 4)
+
+	// the lag in seconds is estimated from the previous end offsets
+	broker.SetHandlerByMap(handlers(t, broker, 110, 50))
+	acc = &testutil.Accumulator{}
+	require.NoError(t, k.Gather(acc))
+	for _, m := range acc.Metrics {
+		if m.Measurement == "kafka_consumer_lag" && m.Tags["topic"] == "metrics" {
+			assert.True(t, m.Fields["lag_seconds"].(float64) > 0)
+		}
+	}
+
+	k = &KafkaConsumerLag{
+		Brokers:       []string{broker.Addr()},
+		IncludeTopics: []string{"logs"},
+	}
+	acc = &testutil.Accumulator{}
+	require.NoError(t, k.Gather(acc))
+	for _, m := range acc.Metrics {
+		assert.Equal(t, "logs", m.Tags["topic"])
+	}
+}
+
+func TestEstimateLagSeconds(t *testing.T) {
+	start := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	at := func(seconds int) time.Time {
+		return start.Add(time.Duration(seconds) * time.Second)
+	}
+	samples := []offsetSample{
+		{at(0), 100},
+		{at(10), 200},
+		{at(20), 400},
+	}
+	now := at(20)
+
+	for _, tt := range []struct {
+		committed int64
+		seconds   float64
+		ok        bool
+	}{
+		{400, 0, true},
+		// produced between the samples
+		{300, 5, true},
+		{150, 15, true},
+		{100, 20, true},
+		// produced before the window, at 15 messages a second
+		{40, 24, true},
+	} {
+		seconds, ok := estimateLagSeconds(samples, tt.committed, now)
+		assert.Equal(t, tt.ok, ok, "committed %d", tt.committed)
+		assert.InDelta(t, tt.seconds, seconds, 1e-9, "committed %d", tt.committed)
+	}
+
+	_, ok := estimateLagSeconds(samples[:1], 50, now)
+	assert.False(t, ok)
+	_, ok = estimateLagSeconds(nil, 50, now)
+	assert.False(t, ok)
+}
+
+func TestRecord(t *testing.T) {
+	k := &KafkaConsumerLag{
+		EstimateWindow: internal.Duration{Duration: 30 * time.Second},
+		history:        make(map[topicPartition][]offsetSample),
+	}
+	tp := topicPartition{"metrics", 0}
+	start := time.Date(2016, 6, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i <= 10; i++ {
+		k.record(tp, offsetSample{start.Add(time.Duration(i*10) * time.Second),
+			int64(i)})
+	}
+	// the last sample before the window is kept, to interpolate in it
+	require.Len(t, k.history[tp], 4)
+	assert.Equal(t, int64(7), k.history[tp][0].offset)
+}
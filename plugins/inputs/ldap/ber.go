@@ -0,0 +1,330 @@
+package ldap
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// BER encoding of the RFC 4511 LDAP messages. The client only binds and
+// searches the base object of a DN, which is all a health check needs.
+
+// BER tags.
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	// LDAP message operations, in the application class
+	tagBindRequest       = 0x60
+	tagBindResponse      = 0x61
+	tagUnbindRequest     = 0x42
+	tagSearchRequest     = 0x63
+	tagSearchResultEntry = 0x64
+	tagSearchResultDone  = 0x65
+	tagSearchResultRef   = 0x73
+
+	// simple bind authentication, and the present search filter
+	tagAuthSimple    = 0x80
+	tagFilterPresent = 0x87
+)
+
+const (
+	scopeBaseObject    = 0
+	derefNever         = 0
+	resultSuccess      = 0
+	resultNoSuchObject = 32
+)
+
+var errInvalidBER = errors.New("invalid BER")
+
+// tlv is an encoded BER element.
+type tlv struct {
+	tag   byte
+	value []byte
+}
+
+func encode(tag byte, value []byte) []byte {
+	n := len(value)
+	b := []byte{tag}
+	switch {
+	case n < 0x80:
+		b = append(b, byte(n))
+	case n < 0x100:
+		b = append(b, 0x81, byte(n))
+	case n < 0x10000:
+		b = append(b, 0x82, byte(n>>8), byte(n))
+	default:
+		b = append(b, 0x84, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+	return append(b, value...)
+}
+
+func encodeInt(tag byte, v int) []byte {
+	var b []byte
+	for {
+		b = append([]byte{byte(v)}, b...)
+		v >>= 8
+		if v == 0 && b[0] < 0x80 || v == -1 && b[0] >= 0x80 {
+			return encode(tag, b)
+		}
+	}
+}
+
+func encodeString(tag byte, s string) []byte {
+	return encode(tag, []byte(s))
+}
+
+func concat(elements ...[]byte) []byte {
+	var b []byte
+	for _, e := range elements {
+		b = append(b, e...)
+	}
+	return b
+}
+
+// parse parses the BER elements of a value.
+func parse(b []byte) ([]tlv, error) {
+	var elements []tlv
+	for len(b) > 0 {
+		if len(b) < 2 {
+			return nil, errInvalidBER
+		}
+		tag, n := b[0], int(b[1])
+		b = b[2:]
+		if n >= 0x80 {
+			size := n & 0x7f
+			if size == 0 || size > 4 || len(b) < size {
+				return nil, errInvalidBER
+			}
+			n = 0
+			for _, c := range b[:size] {
+				n = n<<8 | int(c)
+			}
+			b = b[size:]
+		}
+		if n < 0 || n > len(b) {
+			return nil, errInvalidBER
+		}
+		elements = append(elements, tlv{tag: tag, value: b[:n]})
+		b = b[n:]
+	}
+	return elements, nil
+}
+
+func parseInt(b []byte) int {
+	var v int
+	for i, c := range b {
+		if i == 0 && c >= 0x80 {
+			v = -1
+		}
+		v = v<<8 | int(c)
+	}
+	return v
+}
+
+// readMessage reads the BER element of an LDAP message.
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if header[0] != tagSequence {
+		return nil, errInvalidBER
+	}
+	n := int(header[1])
+	if n >= 0x80 {
+		size := make([]byte, n&0x7f)
+		if len(size) == 0 || len(size) > 4 {
+			return nil, errInvalidBER
+		}
+		if _, err := io.ReadFull(r, size); err != nil {
+			return nil, err
+		}
+		header = append(header, size...)
+		n = 0
+		for _, c := range size {
+			n = n<<8 | int(c)
+		}
+	}
+	if n < 0 || n > 1<<24 {
+		return nil, errInvalidBER
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return append(header, b...), nil
+}
+
+// ldapError is an LDAP result that is not a success.
+type ldapError struct {
+	code    int
+	message string
+}
+
+func (e *ldapError) Error() string {
+	if e.message != "" {
+		return fmt.Sprintf("LDAP result code %d: %s", e.code, e.message)
+	}
+	return fmt.Sprintf("LDAP result code %d", e.code)
+}
+
+// result returns the error of an LDAPResult, or nil on success.
+func result(b []byte) error {
+	elements, err := parse(b)
+	if err != nil {
+		return err
+	}
+	if len(elements) < 3 || elements[0].tag != tagEnumerated {
+		return errInvalidBER
+	}
+	if code := parseInt(elements[0].value); code != resultSuccess {
+		return &ldapError{code: code, message: string(elements[2].value)}
+	}
+	return nil
+}
+
+// conn is a connection to an LDAP server.
+type conn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	timeout time.Duration
+	id      int
+}
+
+func (c *conn) Close() error {
+	c.send(encode(tagUnbindRequest, nil))
+	return c.conn.Close()
+}
+
+func (c *conn) send(op []byte) error {
+	c.id++
+	c.conn.SetDeadline(time.Now().Add(c.timeout))
+	_, err := c.conn.Write(encode(tagSequence,
+		concat(encodeInt(tagInteger, c.id), op)))
+	return err
+}
+
+// receive reads the operation of the next message for the last request.
+func (c *conn) receive() (tlv, error) {
+	for {
+		b, err := readMessage(c.r)
+		if err != nil {
+			return tlv{}, err
+		}
+		message, err := parse(b)
+		if err != nil || len(message) != 1 {
+			return tlv{}, errInvalidBER
+		}
+		elements, err := parse(message[0].value)
+		if err != nil || len(elements) < 2 || elements[0].tag != tagInteger {
+			return tlv{}, errInvalidBER
+		}
+		// notices of disconnection have message id 0
+		if id := parseInt(elements[0].value); id == c.id || id == 0 {
+			return elements[1], nil
+		}
+	}
+}
+
+// bind binds with a DN and password, or anonymously if both are empty.
+func (c *conn) bind(dn, password string) error {
+	err := c.send(encode(tagBindRequest, concat(
+		encodeInt(tagInteger, 3),
+		encodeString(tagOctetString, dn),
+		encodeString(tagAuthSimple, password))))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive()
+	if err != nil {
+		return err
+	}
+	if op.tag != tagBindResponse {
+		return fmt.Errorf("unexpected response 0x%x to bind", op.tag)
+	}
+	return result(op.value)
+}
+
+// search returns the attribute values of the object at a DN, or nil if
+// there is no such object.
+func (c *conn) search(dn string, attributes ...string) (map[string][]string,
+	error) {
+	var attrs []byte
+	for _, a := range attributes {
+		attrs = append(attrs, encodeString(tagOctetString, a)...)
+	}
+	err := c.send(encode(tagSearchRequest, concat(
+		encodeString(tagOctetString, dn),
+		encodeInt(tagEnumerated, scopeBaseObject),
+		encodeInt(tagEnumerated, derefNever),
+		encodeInt(tagInteger, 0),
+		encodeInt(tagInteger, int(c.timeout/time.Second)),
+		encode(tagBoolean, []byte{0}),
+		encodeString(tagFilterPresent, "objectClass"),
+		encode(tagSequence, attrs))))
+	if err != nil {
+		return nil, err
+	}
+
+	var entry map[string][]string
+	for {
+		op, err := c.receive()
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case tagSearchResultEntry:
+			if entry, err = parseEntry(op.value); err != nil {
+				return nil, err
+			}
+		case tagSearchResultRef:
+		case tagSearchResultDone:
+			err := result(op.value)
+			if e, ok := err.(*ldapError); ok && e.code == resultNoSuchObject {
+				return nil, nil
+			}
+			return entry, err
+		default:
+			return nil, fmt.Errorf("unexpected response 0x%x to search",
+				op.tag)
+		}
+	}
+}
+
+// parseEntry parses the attributes of a search result entry. Attribute
+// types are case insensitive, so they are lower cased.
+func parseEntry(b []byte) (map[string][]string, error) {
+	elements, err := parse(b)
+	if err != nil || len(elements) != 2 {
+		return nil, errInvalidBER
+	}
+	attributes, err := parse(elements[1].value)
+	if err != nil {
+		return nil, err
+	}
+	entry := make(map[string][]string)
+	for _, a := range attributes {
+		parts, err := parse(a.value)
+		if err != nil || len(parts) != 2 {
+			return nil, errInvalidBER
+		}
+		values, err := parse(parts[1].value)
+		if err != nil {
+			return nil, err
+		}
+		name := strings.ToLower(string(parts[0].value))
+		for _, v := range values {
+			entry[name] = append(entry[name], string(v.value))
+		}
+	}
+	return entry, nil
+}
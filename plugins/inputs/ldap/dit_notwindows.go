@@ -0,0 +1,9 @@
+// +build !windows
+
+package ldap
+
+// ditSize returns the size of the Active Directory database. It is only
+// supported on Windows domain controllers.
+func ditSize() (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,40 @@
+// +build windows
+
+package ldap
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// ntdsParameters is the registry key with the directory service parameters
+// of a domain controller.
+const ntdsParameters = `SYSTEM\CurrentControlSet\Services\NTDS\Parameters`
+
+// ditSize returns the size of the directory database, ntds.dit, on the
+// domain controller telegraf runs on.
+func ditSize() (int64, bool) {
+	var key syscall.Handle
+	err := syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE,
+		syscall.StringToUTF16Ptr(ntdsParameters), 0, syscall.KEY_READ, &key)
+	if err != nil {
+		return 0, false
+	}
+	defer syscall.RegCloseKey(key)
+
+	buf := make([]uint16, syscall.MAX_PATH)
+	n := uint32(len(buf) * 2)
+	var typ uint32
+	err = syscall.RegQueryValueEx(key,
+		syscall.StringToUTF16Ptr("DSA Database file"), nil, &typ,
+		(*byte)(unsafe.Pointer(&buf[0])), &n)
+	if err != nil || typ != syscall.REG_SZ {
+		return 0, false
+	}
+	fi, err := os.Stat(syscall.UTF16ToString(buf))
+	if err != nil {
+		return 0, false
+	}
+	return fi.Size(), true
+}
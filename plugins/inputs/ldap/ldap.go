@@ -0,0 +1,331 @@
+package ldap
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+type LDAP struct {
+	// Servers are the server URLs, ldap:// or ldaps://.
+	Servers      []string
+	BindDN       string `toml:"bind_dn"`
+	BindPassword string `toml:"bind_password"`
+	// SearchBase is the DN of the object searched to measure the search
+	// time. The root DSE is searched if empty.
+	SearchBase string `toml:"search_base"`
+	// ActiveDirectory gathers the replication and FSMO roles of domain
+	// controllers.
+	ActiveDirectory bool `toml:"active_directory"`
+	Timeout         internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+}
+
+var sampleConfig = `
+  ## Server URLs, ldap:// or ldaps://
+  servers = ["ldap://localhost:389"]
+
+  ## Bind DN and password. Binds anonymously if empty.
+  # bind_dn = "CN=telegraf,CN=Users,DC=corp,DC=example,DC=com"
+  # bind_password = ""
+
+  ## DN of the object searched to measure the search time. The root DSE is
+  ## searched if empty.
+  # search_base = ""
+
+  ## Gather the replication, FSMO roles and DIT size of Active Directory
+  ## domain controllers
+  # active_directory = false
+
+  ## Timeout for the connection and for each request
+  # timeout = "5s"
+
+  ## Optional SSL Config, for ldaps://
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (l *LDAP) SampleConfig() string {
+	return sampleConfig
+}
+
+func (l *LDAP) Description() string {
+	return "Gather the latency of LDAP servers, and the health of Active Directory domain controllers"
+}
+
+func (l *LDAP) Gather(acc telegraf.Accumulator) error {
+	var wg sync.WaitGroup
+	errs := make(chan error, len(l.Servers))
+	for _, server := range l.Servers {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			if err := l.gatherServer(server, acc); err != nil {
+				errs <- fmt.Errorf("ldap: %s: %s", server, err)
+			}
+		}(server)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// dial connects to a server URL, and returns the host to use in the tags.
+func (l *LDAP) dial(server string) (*conn, string, error) {
+	u, err := url.Parse(server)
+	if err != nil {
+		return nil, "", err
+	}
+	host := u.Host
+	var c net.Conn
+	dialer := &net.Dialer{Timeout: l.Timeout.Duration}
+	switch u.Scheme {
+	case "ldap":
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "389")
+		}
+		c, err = dialer.Dial("tcp", host)
+	case "ldaps":
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, "636")
+		}
+		var tlsCfg *tls.Config
+		tlsCfg, err = internal.GetTLSConfig(
+			l.SSLCert, l.SSLKey, l.SSLCA, l.InsecureSkipVerify)
+		if err != nil {
+			return nil, "", err
+		}
+		if tlsCfg == nil {
+			tlsCfg = &tls.Config{}
+		}
+		c, err = tls.DialWithDialer(dialer, "tcp", host, tlsCfg)
+	default:
+		return nil, "", fmt.Errorf("invalid scheme %q, must be ldap or ldaps",
+			u.Scheme)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	return &conn{conn: c, r: bufio.NewReader(c),
+		timeout: l.Timeout.Duration}, host, nil
+}
+
+func (l *LDAP) gatherServer(server string, acc telegraf.Accumulator) error {
+	start := time.Now()
+	c, host, err := l.dial(server)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	tags := map[string]string{"server": host}
+	fields := map[string]interface{}{
+		"connect_time": time.Since(start).Seconds(),
+	}
+
+	// a refused bind reports its result code, and skips the search
+	start = time.Now()
+	err = c.bind(l.BindDN, l.BindPassword)
+	fields["bind_time"] = time.Since(start).Seconds()
+	if e, ok := err.(*ldapError); ok {
+		fields["bind_result"] = e.code
+		acc.AddFields("ldap", fields, tags)
+		return nil
+	} else if err != nil {
+		return err
+	}
+	fields["bind_result"] = resultSuccess
+
+	// the attribute list "1.1" requests no attributes
+	start = time.Now()
+	if _, err := c.search(l.SearchBase, "1.1"); err != nil {
+		return err
+	}
+	fields["search_time"] = time.Since(start).Seconds()
+
+	if l.ActiveDirectory {
+		if hostname, _, err := net.SplitHostPort(host); err == nil &&
+			isLocal(hostname) {
+			if size, ok := ditSize(); ok {
+				fields["dit_size"] = size
+			}
+		}
+		if err := gatherAD(c, host, acc); err != nil {
+			acc.AddFields("ldap", fields, tags)
+			return err
+		}
+	}
+	acc.AddFields("ldap", fields, tags)
+	return nil
+}
+
+// fsmoRoles are the FSMO roles, with the DN of the object holding their
+// fSMORoleOwner. The DNs are relative to the default, configuration or
+// schema naming context.
+var fsmoRoles = []struct {
+	role string
+	rdn  string
+	nc   string
+}{
+	{"schema", "", "schemanamingcontext"},
+	{"domain_naming", "CN=Partitions,", "configurationnamingcontext"},
+	{"pdc", "", "defaultnamingcontext"},
+	{"rid", "CN=RID Manager$,CN=System,", "defaultnamingcontext"},
+	{"infrastructure", "CN=Infrastructure,", "defaultnamingcontext"},
+}
+
+// replNeighbor is an inbound replication neighbor of a domain controller,
+// parsed from a value of msDS-ReplAllInboundNeighbors.
+type replNeighbor struct {
+	NamingContext       string `xml:"pszNamingContext"`
+	SourceDsaDN         string `xml:"pszSourceDsaDN"`
+	LastSyncSuccess     string `xml:"ftimeLastSyncSuccess"`
+	LastSyncAttempt     string `xml:"ftimeLastSyncAttempt"`
+	LastSyncResult      int    `xml:"dwLastSyncResult"`
+	ConsecutiveFailures int    `xml:"cNumConsecutiveSyncFailures"`
+}
+
+// gatherAD gathers the FSMO role holders and the inbound replication of a
+// domain controller from the attributes of its root DSE.
+func gatherAD(c *conn, host string, acc telegraf.Accumulator) error {
+	root, err := c.search("", "defaultNamingContext",
+		"configurationNamingContext", "schemaNamingContext", "dsServiceName",
+		"msDS-ReplAllInboundNeighbors")
+	if err != nil {
+		return err
+	}
+	if len(root["defaultnamingcontext"]) == 0 {
+		return fmt.Errorf("not an Active Directory domain controller")
+	}
+	first := func(name string) string {
+		if v := root[name]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	self := first("dsservicename")
+
+	for _, role := range fsmoRoles {
+		nc := first(role.nc)
+		if nc == "" {
+			continue
+		}
+		entry, err := c.search(role.rdn+nc, "fSMORoleOwner")
+		if err != nil {
+			return err
+		}
+		owner := entry["fsmoroleowner"]
+		if len(owner) == 0 {
+			continue
+		}
+		server, site := dsa(owner[0])
+		acc.AddFields("ldap_ad_fsmo", map[string]interface{}{
+			"holder":      server,
+			"holder_site": site,
+			"local":       strings.EqualFold(owner[0], self),
+		}, map[string]string{"server": host, "role": role.role})
+	}
+
+	now := time.Now()
+	for _, v := range root["msds-replallinboundneighbors"] {
+		var n replNeighbor
+		if err := xml.Unmarshal([]byte(v), &n); err != nil {
+			return fmt.Errorf("invalid replication neighbor: %s", err)
+		}
+		source, site := dsa(n.SourceDsaDN)
+		fields := map[string]interface{}{
+			"last_sync_result":     n.LastSyncResult,
+			"consecutive_failures": n.ConsecutiveFailures,
+		}
+		if t, ok := fileTime(n.LastSyncSuccess); ok {
+			fields["last_success_age"] = now.Sub(t).Seconds()
+		}
+		if t, ok := fileTime(n.LastSyncAttempt); ok {
+			fields["last_attempt_age"] = now.Sub(t).Seconds()
+		}
+		acc.AddFields("ldap_ad_replication", fields, map[string]string{
+			"server":         host,
+			"naming_context": n.NamingContext,
+			"source":         source,
+			"source_site":    site,
+		})
+	}
+	return nil
+}
+
+// dsa returns the server and site from the NTDS Settings DN of a domain
+// controller. For example, it returns DC1 and Site1 for CN=NTDS Settings,
+// CN=DC1,CN=Servers,CN=Site1,CN=Sites,CN=Configuration,DC=corp,DC=example,
+// DC=com.
+func dsa(dn string) (string, string) {
+	rdns := strings.Split(dn, ",")
+	value := func(i int) string {
+		if i >= len(rdns) {
+			return ""
+		}
+		kv := strings.SplitN(rdns[i], "=", 2)
+		if len(kv) != 2 {
+			return ""
+		}
+		return kv[1]
+	}
+	return value(1), value(3)
+}
+
+// fileTime parses a replication neighbor time. The zero FILETIME, in 1601,
+// means no time.
+func fileTime(s string) (time.Time, bool) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil || t.Year() <= 1601 {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// isLocal returns whether a host is the one telegraf runs on.
+func isLocal(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.IsLoopback()
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return false
+	}
+	short := func(name string) string {
+		return strings.SplitN(name, ".", 2)[0]
+	}
+	return strings.EqualFold(short(host), short(hostname))
+}
+
+func init() {
+	inputs.Add("ldap", func() telegraf.Input {
+		return &LDAP{
+			Servers: []string{"ldap://localhost:389"},
+			Timeout: internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
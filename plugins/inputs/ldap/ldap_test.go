@@ -0,0 +1,238 @@
+package ldap
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/testutil"
+)
+
+const (
+	testNC   = "DC=corp,DC=example,DC=com"
+	testDC1  = "CN=NTDS Settings,CN=DC1,CN=Servers,CN=HQ,CN=Sites,CN=Configuration," + testNC
+	testDC2  = "CN=NTDS Settings,CN=DC2,CN=Servers,CN=Branch,CN=Sites,CN=Configuration," + testNC
+	neighbor = `<DS_REPL_NEIGHBOR>
+	<pszNamingContext>` + testNC + `</pszNamingContext>
+	<pszSourceDsaDN>` + testDC2 + `</pszSourceDsaDN>
+	<pszSourceDsaAddress>1f2e3d4c._msdcs.corp.example.com</pszSourceDsaAddress>
+	<dwReplicaFlags>1879048304</dwReplicaFlags>
+	<ftimeLastSyncSuccess>1601-01-01T00:00:00Z</ftimeLastSyncSuccess>
+	<ftimeLastSyncAttempt>2016-06-13T17:43:50Z</ftimeLastSyncAttempt>
+	<dwLastSyncResult>8524</dwLastSyncResult>
+	<cNumConsecutiveSyncFailures>12</cNumConsecutiveSyncFailures>
+</DS_REPL_NEIGHBOR>`
+)
+
+// testDirectory maps the DNs of the test server to their attributes.
+var testDirectory = map[string]map[string][]string{
+	"": {
+		"defaultNamingContext":         {testNC},
+		"configurationNamingContext":   {"CN=Configuration," + testNC},
+		"schemaNamingContext":          {"CN=Schema,CN=Configuration," + testNC},
+		"dsServiceName":                {testDC1},
+		"msDS-ReplAllInboundNeighbors": {neighbor},
+	},
+	testNC:                                     {"fSMORoleOwner": {testDC1}},
+	"CN=RID Manager$,CN=System," + testNC:      {"fSMORoleOwner": {testDC1}},
+	"CN=Infrastructure," + testNC:              {"fSMORoleOwner": {testDC1}},
+	"CN=Partitions,CN=Configuration," + testNC: {"fSMORoleOwner": {testDC2}},
+	"CN=Schema,CN=Configuration," + testNC:     {"fSMORoleOwner": {testDC2}},
+}
+
+// serve serves the test directory to a connection. It refuses binds with a
+// DN unless the password is "secret".
+func serve(t *testing.T, c net.Conn, requests chan<- []byte) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	for {
+		b, err := readMessage(r)
+		if err != nil {
+			return
+		}
+		requests <- b
+		message, _ := parse(b)
+		elements, _ := parse(message[0].value)
+		id, op := elements[0], elements[1]
+		reply := func(tag byte, value []byte) {
+			c.Write(encode(tagSequence, concat(encode(tagInteger, id.value),
+				encode(tag, value))))
+		}
+		ldapResult := func(code int) []byte {
+			return concat(encodeInt(tagEnumerated, code),
+				encodeString(tagOctetString, ""),
+				encodeString(tagOctetString, ""))
+		}
+
+		switch op.tag {
+		case tagBindRequest:
+			parts, _ := parse(op.value)
+			code := resultSuccess
+			if len(parts[1].value) > 0 && string(parts[2].value) != "secret" {
+				code = 49
+			}
+			reply(tagBindResponse, ldapResult(code))
+		case tagSearchRequest:
+			parts, _ := parse(op.value)
+			object, ok := testDirectory[string(parts[0].value)]
+			if !ok {
+				reply(tagSearchResultDone, ldapResult(resultNoSuchObject))
+				continue
+			}
+			requested, _ := parse(parts[7].value)
+			var attrs []byte
+			for _, a := range requested {
+				values, ok := object[string(a.value)]
+				if !ok {
+					continue
+				}
+				var vals []byte
+				for _, v := range values {
+					vals = append(vals, encodeString(tagOctetString, v)...)
+				}
+				attrs = append(attrs, encode(tagSequence, concat(
+					encode(tagOctetString, a.value), encode(tagSet, vals)))...)
+			}
+			reply(tagSearchResultEntry, concat(
+				encode(tagOctetString, parts[0].value),
+				encode(tagSequence, attrs)))
+			reply(tagSearchResultDone, ldapResult(resultSuccess))
+		case tagUnbindRequest:
+			return
+		}
+	}
+}
+
+func newServer(t *testing.T) (string, chan []byte) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	requests := make(chan []byte, 100)
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go serve(t, c, requests)
+		}
+	}()
+	return "ldap://" + l.Addr().String(), requests
+}
+
+func newLDAP(server string) *LDAP {
+	return &LDAP{
+		Servers: []string{server},
+		Timeout: internal.Duration{Duration: 5 * time.Second},
+	}
+}
+
+func TestGather(t *testing.T) {
+	server, requests := newServer(t)
+	acc := &testutil.Accumulator{}
+	require.NoError(t, newLDAP(server).Gather(acc))
+
+	// an anonymous bind, and a search of the root DSE without attributes
+	assert.Equal(t, []byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x60, 0x07, 0x02,
+		0x01, 0x03, 0x04, 0x00, 0x80, 0x00}, <-requests)
+	assert.Equal(t, concat([]byte{0x30, 0x2a, 0x02, 0x01, 0x02, 0x63, 0x25,
+		0x04, 0x00, 0x0a, 0x01, 0x00, 0x0a, 0x01, 0x00, 0x02, 0x01, 0x00,
+		0x02, 0x01, 0x05, 0x01, 0x01, 0x00, 0x87, 0x0b},
+		[]byte("objectClass"), []byte{0x30, 0x05, 0x04, 0x03},
+		[]byte("1.1")), <-requests)
+
+	require.Len(t, acc.Metrics, 1)
+	m := acc.Metrics[0]
+	assert.Equal(t, "ldap", m.Measurement)
+	assert.Equal(t, strings.TrimPrefix(server, "ldap://"), m.Tags["server"])
+	assert.Equal(t, resultSuccess, m.Fields["bind_result"])
+	for _, field := range []string{"connect_time", "bind_time", "search_time"} {
+		assert.True(t, m.Fields[field].(float64) > 0)
+	}
+}
+
+func TestGatherBindRefused(t *testing.T) {
+	server, _ := newServer(t)
+	acc := &testutil.Accumulator{}
+	l := newLDAP(server)
+	l.BindDN = "CN=telegraf,CN=Users," + testNC
+	l.BindPassword = "wrong"
+	require.NoError(t, l.Gather(acc))
+	require.Len(t, acc.Metrics, 1)
+	assert.Equal(t, 49, acc.Metrics[0].Fields["bind_result"])
+	assert.NotContains(t, acc.Metrics[0].Fields, "search_time")
+
+	l.SearchBase = "CN=missing," + testNC
+	l.BindPassword = "secret"
+	acc = &testutil.Accumulator{}
+	require.NoError(t, l.Gather(acc))
+	assert.Equal(t, resultSuccess, acc.Metrics[0].Fields["bind_result"])
+
+	l.Servers = []string{"http://localhost"}
+	assert.Error(t, l.Gather(acc))
+}
+
+func TestGatherActiveDirectory(t *testing.T) {
+	server, _ := newServer(t)
+	acc := &testutil.Accumulator{}
+	l := newLDAP(server)
+	l.ActiveDirectory = true
+	require.NoError(t, l.Gather(acc))
+
+	host := strings.TrimPrefix(server, "ldap://")
+	for role, holder := range map[string]string{
+		"schema":         "DC2",
+		"domain_naming":  "DC2",
+		"pdc":            "DC1",
+		"rid":            "DC1",
+		"infrastructure": "DC1",
+	} {
+		site := map[string]string{"DC1": "HQ", "DC2": "Branch"}[holder]
+		acc.AssertContainsTaggedFields(t, "ldap_ad_fsmo",
+			map[string]interface{}{
+				"holder":      holder,
+				"holder_site": site,
+				"local":       holder == "DC1",
+			},
+			map[string]string{"server": host, "role": role})
+	}
+
+	var repl *testutil.Metric
+	for _, m := range acc.Metrics {
+		if m.Measurement == "ldap_ad_replication" {
+			repl = m
+		}
+	}
+	require.NotNil(t, repl)
+	assert.Equal(t, map[string]string{
+		"server":         host,
+		"naming_context": testNC,
+		"source":         "DC2",
+		"source_site":    "Branch",
+	}, repl.Tags)
+	assert.Equal(t, 8524, repl.Fields["last_sync_result"])
+	assert.Equal(t, 12, repl.Fields["consecutive_failures"])
+	// never synchronized
+	assert.NotContains(t, repl.Fields, "last_success_age")
+	assert.True(t, repl.Fields["last_attempt_age"].(float64) > 0)
+}
+
+func TestGatherNotActiveDirectory(t *testing.T) {
+	server, _ := newServer(t)
+	root := testDirectory[""]
+	testDirectory[""] = map[string][]string{}
+	defer func() { testDirectory[""] = root }()
+
+	acc := &testutil.Accumulator{}
+	l := newLDAP(server)
+	l.ActiveDirectory = true
+	assert.Error(t, l.Gather(acc))
+	// the latency is still gathered
+	require.Len(t, acc.Metrics, 1)
+	assert.Equal(t, "ldap", acc.Metrics[0].Measurement)
+}
@@ -31,6 +31,9 @@ type Mysql struct {
 	GatherTableSchema                   bool     `toml:"gather_table_schema"`
 	GatherFileEventsStats               bool     `toml:"gather_file_events_stats"`
 	GatherPerfEventsStatements          bool     `toml:"gather_perf_events_statements"`
+	GatherGroupReplication              bool     `toml:"gather_group_replication"`
+	GatherCloneStatus                   bool     `toml:"gather_clone_status"`
+	GatherReplicationLag                bool     `toml:"gather_replication_lag"`
 	IntervalSlow                        string   `toml:"interval_slow"`
 }
 
@@ -85,6 +88,18 @@ var sampleConfig = `
   ## gather metrics from PERFORMANCE_SCHEMA.EVENTS_STATEMENTS_SUMMARY_BY_DIGEST
   gather_perf_events_statements             = false
   #
+  ## gather group replication members from PERFORMANCE_SCHEMA.REPLICATION_GROUP_MEMBERS
+  ## and PERFORMANCE_SCHEMA.REPLICATION_GROUP_MEMBER_STATS (MySQL 8.0)
+  gather_group_replication                  = false
+  #
+  ## gather clone plugin progress from PERFORMANCE_SCHEMA.CLONE_STATUS
+  ## and PERFORMANCE_SCHEMA.CLONE_PROGRESS (MySQL 8.0.17)
+  gather_clone_status                       = false
+  #
+  ## gather the lag of each replication channel, using the original commit
+  ## timestamps in PERFORMANCE_SCHEMA.REPLICATION_*_STATUS (MySQL 8.0)
+  gather_replication_lag                    = false
+  #
   ## Some queries we may want to run less often (such as SHOW GLOBAL VARIABLES)
   interval_slow                   = "30m"
 `
@@ -534,6 +549,56 @@ const (
             SCHEMA_NAME
             FROM information_schema.schemata
         WHERE SCHEMA_NAME NOT IN ('mysql', 'performance_schema', 'information_schema')
+    `
+	groupReplicationQuery = `
+        SELECT
+            m.CHANNEL_NAME, m.MEMBER_ID, m.MEMBER_HOST, m.MEMBER_PORT,
+            m.MEMBER_STATE, m.MEMBER_ROLE,
+            s.COUNT_TRANSACTIONS_IN_QUEUE,
+            s.COUNT_TRANSACTIONS_CHECKED,
+            s.COUNT_CONFLICTS_DETECTED,
+            s.COUNT_TRANSACTIONS_ROWS_VALIDATING,
+            s.COUNT_TRANSACTIONS_REMOTE_IN_APPLIER_QUEUE,
+            s.COUNT_TRANSACTIONS_REMOTE_APPLIED,
+            s.COUNT_TRANSACTIONS_LOCAL_PROPOSED,
+            s.COUNT_TRANSACTIONS_LOCAL_ROLLBACK
+        FROM performance_schema.replication_group_members m
+        LEFT JOIN performance_schema.replication_group_member_stats s
+            ON s.CHANNEL_NAME = m.CHANNEL_NAME AND s.MEMBER_ID = m.MEMBER_ID
+    `
+	cloneStatusQuery = `
+        SELECT
+            SOURCE, STATE, ERROR_NO,
+            TIMESTAMPDIFF(MICROSECOND, BEGIN_TIME, COALESCE(END_TIME, NOW(6))) / 1e6
+        FROM performance_schema.clone_status
+    `
+	cloneProgressQuery = `
+        SELECT
+            STAGE, STATE, THREADS, ESTIMATE, DATA, NETWORK, DATA_SPEED, NETWORK_SPEED,
+            TIMESTAMPDIFF(MICROSECOND, BEGIN_TIME, COALESCE(END_TIME, NOW(6))) / 1e6
+        FROM performance_schema.clone_progress
+    `
+	replicationLagQuery = `
+        SELECT
+            c.CHANNEL_NAME, c.SOURCE_UUID, c.SERVICE_STATE, a.SERVICE_STATE,
+            c.LAST_ERROR_NUMBER,
+            (SELECT MAX(w.LAST_ERROR_NUMBER)
+                FROM performance_schema.replication_applier_status_by_worker w
+                WHERE w.CHANNEL_NAME = c.CHANNEL_NAME),
+            IF(c.LAST_QUEUED_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP = 0, NULL,
+                TIMESTAMPDIFF(MICROSECOND,
+                    c.LAST_QUEUED_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP,
+                    c.LAST_QUEUED_TRANSACTION_END_QUEUE_TIMESTAMP) / 1e6),
+            (SELECT MAX(IF(w.APPLYING_TRANSACTION = '', 0,
+                    IF(w.APPLYING_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP = 0, NULL,
+                        TIMESTAMPDIFF(MICROSECOND,
+                            w.APPLYING_TRANSACTION_ORIGINAL_COMMIT_TIMESTAMP,
+                            NOW(6)) / 1e6)))
+                FROM performance_schema.replication_applier_status_by_worker w
+                WHERE w.CHANNEL_NAME = c.CHANNEL_NAME)
+        FROM performance_schema.replication_connection_status c
+        JOIN performance_schema.replication_applier_status a
+            ON a.CHANNEL_NAME = c.CHANNEL_NAME
     `
 	perfSchemaTablesQuery = `
 		SELECT
@@ -654,6 +719,27 @@ func (m *Mysql) gatherServer(serv string, acc telegraf.Accumulator) error {
 			return err
 		}
 	}
+
+	if m.GatherGroupReplication {
+		err = m.gatherGroupReplication(db, serv, acc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.GatherCloneStatus {
+		err = m.gatherCloneStatus(db, serv, acc)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m.GatherReplicationLag {
+		err = m.gatherReplicationLag(db, serv, acc)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -788,6 +874,199 @@ func (m *Mysql) gatherBinaryLogs(db *sql.DB, serv string, acc telegraf.Accumulat
 	return nil
 }
 
+// gatherGroupReplication can be used to get the state of each group
+// replication member, and the transactions in its certification and applier
+// queues from the member stats
+func (m *Mysql) gatherGroupReplication(db *sql.DB, serv string, acc telegraf.Accumulator) error {
+	rows, err := db.Query(groupReplicationQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	servtag, err := parseDSN(serv)
+	if err != nil {
+		servtag = "localhost"
+	}
+
+	for rows.Next() {
+		var (
+			channel, memberState                             string
+			memberID, memberHost, memberRole                 sql.NullString
+			memberPort                                       sql.NullInt64
+			inQueue, checked, conflicts, validating          sql.NullInt64
+			remoteInQueue, remoteApplied, proposed, rollback sql.NullInt64
+		)
+		err = rows.Scan(&channel, &memberID, &memberHost, &memberPort,
+			&memberState, &memberRole, &inQueue, &checked, &conflicts,
+			&validating, &remoteInQueue, &remoteApplied, &proposed, &rollback)
+		if err != nil {
+			return err
+		}
+
+		// a server not in a group is an OFFLINE member with no id
+		tags := map[string]string{"server": servtag, "channel": channel}
+		if memberID.String != "" {
+			tags["member_id"] = memberID.String
+		}
+		if memberHost.String != "" {
+			tags["member_host"] = memberHost.String
+		}
+		if memberPort.Valid {
+			tags["member_port"] = strconv.FormatInt(memberPort.Int64, 10)
+		}
+		fields := map[string]interface{}{
+			"member_state": memberState,
+			"member_role":  memberRole.String,
+		}
+		// a member only has stats while it is in the group
+		addValidInts(fields, map[string]sql.NullInt64{
+			"transactions_in_queue":                inQueue,
+			"transactions_checked":                 checked,
+			"conflicts_detected":                   conflicts,
+			"transactions_rows_validating":         validating,
+			"transactions_remote_in_applier_queue": remoteInQueue,
+			"transactions_remote_applied":          remoteApplied,
+			"transactions_local_proposed":          proposed,
+			"transactions_local_rollback":          rollback,
+		})
+		acc.AddFields("mysql_group_replication", fields, tags)
+	}
+	return rows.Err()
+}
+
+// gatherCloneStatus can be used to get the state of the last clone on the
+// server, and the progress of each clone stage
+func (m *Mysql) gatherCloneStatus(db *sql.DB, serv string, acc telegraf.Accumulator) error {
+	servtag, err := parseDSN(serv)
+	if err != nil {
+		servtag = "localhost"
+	}
+
+	rows, err := db.Query(cloneStatusQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			source, state string
+			errorNo       int64
+			duration      sql.NullFloat64
+		)
+		if err := rows.Scan(&source, &state, &errorNo, &duration); err != nil {
+			return err
+		}
+		fields := map[string]interface{}{
+			"state":    state,
+			"error_no": errorNo,
+		}
+		if duration.Valid {
+			fields["duration"] = duration.Float64
+		}
+		acc.AddFields("mysql_clone", fields,
+			map[string]string{"server": servtag, "source": source})
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	rows, err = db.Query(cloneProgressQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			stage, state            string
+			threads                 int64
+			estimate, data, network int64
+			dataSpeed, networkSpeed int64
+			duration                sql.NullFloat64
+		)
+		err := rows.Scan(&stage, &state, &threads, &estimate, &data, &network,
+			&dataSpeed, &networkSpeed, &duration)
+		if err != nil {
+			return err
+		}
+		fields := map[string]interface{}{
+			"state":         state,
+			"threads":       threads,
+			"estimate":      estimate,
+			"data":          data,
+			"network":       network,
+			"data_speed":    dataSpeed,
+			"network_speed": networkSpeed,
+		}
+		if duration.Valid {
+			fields["duration"] = duration.Float64
+		}
+		acc.AddFields("mysql_clone_stage", fields,
+			map[string]string{"server": servtag, "stage": stage})
+	}
+	return rows.Err()
+}
+
+// gatherReplicationLag can be used to get the lag of each replication
+// channel. It uses the original commit timestamps of the transactions its
+// receiver queued and its workers are applying
+func (m *Mysql) gatherReplicationLag(db *sql.DB, serv string, acc telegraf.Accumulator) error {
+	rows, err := db.Query(replicationLagQuery)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	servtag, err := parseDSN(serv)
+	if err != nil {
+		servtag = "localhost"
+	}
+
+	for rows.Next() {
+		var (
+			channel, sourceUUID, ioState, sqlState string
+			ioError                                int64
+			sqlError                               sql.NullInt64
+			queueLag, applierLag                   sql.NullFloat64
+		)
+		err = rows.Scan(&channel, &sourceUUID, &ioState, &sqlState, &ioError,
+			&sqlError, &queueLag, &applierLag)
+		if err != nil {
+			return err
+		}
+
+		tags := map[string]string{"server": servtag, "channel": channel}
+		if sourceUUID != "" {
+			tags["source_uuid"] = sourceUUID
+		}
+		fields := map[string]interface{}{
+			"io_running":       ioState == "ON",
+			"sql_running":      sqlState == "ON",
+			"io_last_error_no": ioError,
+		}
+		if sqlError.Valid {
+			fields["sql_last_error_no"] = sqlError.Int64
+		}
+		if queueLag.Valid {
+			fields["queue_lag"] = queueLag.Float64
+		}
+		if applierLag.Valid {
+			fields["applier_lag"] = applierLag.Float64
+		}
+		acc.AddFields("mysql_replication_channel", fields, tags)
+	}
+	return rows.Err()
+}
+
+// addValidInts adds the values that are not null as fields
+func addValidInts(fields map[string]interface{}, values map[string]sql.NullInt64) {
+	for name, v := range values {
+		if v.Valid {
+			fields[name] = v.Int64
+		}
+	}
+}
+
 // gatherGlobalStatuses can be used to get MySQL status metrics
 // the mappings of actual names and names of each status to be exported
 // to output is provided on mappings variable
@@ -160,3 +160,24 @@ func TestNewNamespace(t *testing.T) {
 		}
 	}
 }
+
+func TestMysqlGatherReplicationStatus(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	m := &Mysql{
+		Servers:                []string{fmt.Sprintf("root@tcp(%s:3306)/", testutil.GetLocalHost())},
+		GatherGroupReplication: true,
+		GatherReplicationLag:   true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, m.Gather(&acc))
+
+	// a server in no group is an OFFLINE member on the group_replication_applier channel
+	point, ok := acc.Get("mysql_group_replication")
+	require.True(t, ok)
+	assert.Equal(t, "group_replication_applier", point.Tags["channel"])
+	assert.Contains(t, point.Fields, "member_state")
+}
@@ -7,6 +7,7 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/inputs"
@@ -15,11 +16,18 @@ import (
 )
 
 type Postgresql struct {
-	Address          string
-	Databases        []string
-	OrderedColumns   []string
-	AllColumns       []string
+	Address        string
+	Databases      []string
+	OrderedColumns []string
+	AllColumns     []string
+	// Replication gathers the WAL, archiver, replication slots and logical
+	// subscriptions.
+	Replication      bool
 	sanitizedAddress string
+
+	// the WAL position at the last gather, used for the WAL rate
+	lastWALPosition float64
+	lastWALTime     time.Time
 }
 
 var ignoredColumns = map[string]bool{"datid": true, "datname": true, "stats_reset": true}
@@ -43,6 +51,10 @@ var sampleConfig = `
   ## A list of databases to pull metrics about. If not specified, metrics for all
   ## databases are gathered.
   # databases = ["app_production", "testing"]
+
+  ## Gather the WAL position and rate, the archiver, the replication slot
+  ## lag and the logical subscription state. Needs PostgreSQL 9.4 or later
+  # replication = false
 `
 
 func (p *Postgresql) SampleConfig() string {
@@ -129,7 +141,14 @@ func (p *Postgresql) Gather(acc telegraf.Accumulator) error {
 		}
 	}
 	sort.Strings(p.AllColumns)
-	return bg_writer_row.Err()
+	if err := bg_writer_row.Err(); err != nil {
+		return err
+	}
+
+	if p.Replication {
+		return p.gatherReplication(db, acc)
+	}
+	return nil
 }
 
 type scanner interface {
@@ -150,3 +150,46 @@ func TestPostgresqlIgnoresUnwantedColumns(t *testing.T) {
 		assert.False(t, acc.HasMeasurement(col))
 	}
 }
+
+func TestPostgresqlGathersReplication(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Skipping integration test in short mode")
+	}
+
+	p := &Postgresql{
+		Address: fmt.Sprintf("host=%s user=postgres sslmode=disable",
+			testutil.GetLocalHost()),
+		Databases:   []string{"postgres"},
+		Replication: true,
+	}
+
+	var acc testutil.Accumulator
+	require.NoError(t, p.Gather(&acc))
+	require.NoError(t, p.Gather(&acc))
+
+	point, ok := acc.Get("postgresql_wal")
+	require.True(t, ok)
+	assert.Contains(t, point.Fields, "wal_position")
+	assert.Contains(t, point.Fields, "failed_count")
+	assert.True(t, acc.HasFloatField("postgresql_wal", "wal_rate"))
+}
+
+func TestReplicationQueries(t *testing.T) {
+	// the xlog functions were renamed to wal in PostgreSQL 10
+	assert.Contains(t, walQuery(90600), "pg_xlog_location_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_xlog_replay_location() ELSE pg_current_xlog_location() END, '0/0')")
+	assert.Contains(t, walQuery(100000), "pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery() THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END, '0/0')")
+
+	// confirmed_flush_lsn was added in 9.6, and the standby lag in 10
+	assert.Contains(t, slotsQuery(90400), "NULL::numeric")
+	assert.NotContains(t, slotsQuery(90400), "pg_stat_replication")
+	assert.Contains(t, slotsQuery(90600), "s.confirmed_flush_lsn")
+	assert.NotContains(t, slotsQuery(90600), "replay_lag")
+	assert.Contains(t, slotsQuery(100000), "EXTRACT(EPOCH FROM r.replay_lag)")
+	assert.Contains(t, slotsQuery(100000), "LEFT JOIN pg_stat_replication r ON r.pid = s.active_pid")
+
+	// subscription errors were added in 15, parallel apply workers in 16
+	assert.Contains(t, subscriptionsQuery(100000), "NULL::bigint, NULL::bigint")
+	assert.Contains(t, subscriptionsQuery(150000), "pg_stat_subscription_stats")
+	assert.NotContains(t, subscriptionsQuery(150000), "leader_pid")
+	assert.Contains(t, subscriptionsQuery(160000), "w.relid IS NULL AND w.leader_pid IS NULL")
+}
@@ -0,0 +1,240 @@
+package postgresql
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// walFunctions returns the functions for the current WAL location, the
+// replayed location and their difference. Before PostgreSQL 10 they are the
+// xlog functions.
+func walFunctions(version int) (current, replay, diff string) {
+	if version >= 100000 {
+		return "pg_current_wal_lsn", "pg_last_wal_replay_lsn", "pg_wal_lsn_diff"
+	}
+	return "pg_current_xlog_location", "pg_last_xlog_replay_location",
+		"pg_xlog_location_diff"
+}
+
+// walLocation returns the expression for the WAL location of a server. On a
+// standby it is the replayed location.
+func walLocation(version int) string {
+	current, replay, _ := walFunctions(version)
+	return fmt.Sprintf(
+		"CASE WHEN pg_is_in_recovery() THEN %s() ELSE %s() END",
+		replay, current)
+}
+
+// walQuery returns the query for the WAL location, the replay age on a
+// standby, and the archiver.
+func walQuery(version int) string {
+	_, _, diff := walFunctions(version)
+	return fmt.Sprintf(`SELECT pg_is_in_recovery(),
+	%s(%s, '0/0'),
+	CASE WHEN pg_is_in_recovery()
+		THEN EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp()) END,
+	a.archived_count, a.failed_count,
+	EXTRACT(EPOCH FROM now() - a.last_archived_time),
+	EXTRACT(EPOCH FROM now() - a.last_failed_time)
+FROM pg_stat_archiver a`, diff, walLocation(version))
+}
+
+// slotsQuery returns the query for the replication slots. It reads the WAL
+// bytes each slot retains, and the lag of the standby streaming from it.
+func slotsQuery(version int) string {
+	_, _, diff := walFunctions(version)
+	location := walLocation(version)
+	confirmed := "NULL::numeric"
+	if version >= 90600 {
+		confirmed = fmt.Sprintf("%s(%s, s.confirmed_flush_lsn)", diff, location)
+	}
+	lag, join := "NULL::float8, NULL::float8, NULL::float8", ""
+	if version >= 100000 {
+		lag = `EXTRACT(EPOCH FROM r.write_lag), EXTRACT(EPOCH FROM r.flush_lag),
+	EXTRACT(EPOCH FROM r.replay_lag)`
+		join = "\nLEFT JOIN pg_stat_replication r ON r.pid = s.active_pid"
+	}
+	return fmt.Sprintf(`SELECT s.slot_name, s.slot_type, coalesce(s.database, ''),
+	s.active, %s(%s, s.restart_lsn), %s,
+	%s
+FROM pg_replication_slots s%s`, diff, location, confirmed, lag, join)
+}
+
+// subscriptionsQuery returns the query for the logical subscriptions. It
+// reads the state of their apply workers and tables, and their error counts
+// on PostgreSQL 15 or later.
+func subscriptionsQuery(version int) string {
+	errors, join, worker := "NULL::bigint, NULL::bigint", "", ""
+	if version >= 150000 {
+		errors = "e.apply_error_count, e.sync_error_count"
+		join = "\nLEFT JOIN pg_stat_subscription_stats e ON e.subid = s.oid"
+	}
+	if version >= 160000 {
+		// skip the parallel apply workers of a leader
+		worker = " AND w.leader_pid IS NULL"
+	}
+	return fmt.Sprintf(`SELECT s.subname, d.datname, s.subenabled,
+	w.pid IS NOT NULL,
+	(SELECT count(*) FROM pg_subscription_rel r
+		WHERE r.srsubid = s.oid AND r.srsubstate <> 'r'),
+	EXTRACT(EPOCH FROM now() - w.last_msg_receipt_time),
+	EXTRACT(EPOCH FROM now() - w.latest_end_time),
+	%s
+FROM pg_subscription s
+JOIN pg_database d ON d.oid = s.subdbid
+LEFT JOIN pg_stat_subscription w ON w.subid = s.oid AND w.relid IS NULL%s%s`,
+		errors, worker, join)
+}
+
+// addValid adds the values that are not null as fields.
+func addValid(fields map[string]interface{}, values map[string]interface{}) {
+	for name, v := range values {
+		switch v := v.(type) {
+		case sql.NullBool:
+			if v.Valid {
+				fields[name] = v.Bool
+			}
+		case sql.NullInt64:
+			if v.Valid {
+				fields[name] = v.Int64
+			}
+		case sql.NullFloat64:
+			if v.Valid {
+				fields[name] = v.Float64
+			}
+		}
+	}
+}
+
+// gatherReplication gathers the WAL, archiver, replication slots and logical
+// subscriptions of a server. It needs PostgreSQL 9.4 or later.
+func (p *Postgresql) gatherReplication(db *sql.DB, acc telegraf.Accumulator) error {
+	var s string
+	if err := db.QueryRow("SHOW server_version_num").Scan(&s); err != nil {
+		return err
+	}
+	version, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if version < 90400 {
+		return nil
+	}
+	server, err := p.SanitizedAddress()
+	if err != nil {
+		return err
+	}
+
+	var (
+		inRecovery                  bool
+		position, replayAge         sql.NullFloat64
+		archived, failed            sql.NullInt64
+		lastArchivedAge, lastFailed sql.NullFloat64
+	)
+	err = db.QueryRow(walQuery(version)).Scan(&inRecovery, &position,
+		&replayAge, &archived, &failed, &lastArchivedAge, &lastFailed)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	fields := map[string]interface{}{"in_recovery": inRecovery}
+	addValid(fields, map[string]interface{}{
+		"wal_position":      position,
+		"replay_age":        replayAge,
+		"archived_count":    archived,
+		"failed_count":      failed,
+		"last_archived_age": lastArchivedAge,
+		"last_failed_age":   lastFailed,
+	})
+	if position.Valid {
+		if !p.lastWALTime.IsZero() && position.Float64 >= p.lastWALPosition {
+			fields["wal_rate"] = (position.Float64 - p.lastWALPosition) /
+				now.Sub(p.lastWALTime).Seconds()
+		}
+		p.lastWALPosition, p.lastWALTime = position.Float64, now
+	}
+	acc.AddFields("postgresql_wal", fields, map[string]string{"server": server})
+
+	rows, err := db.Query(slotsQuery(version))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name, slotType, database  string
+			active                    bool
+			restartLag, confirmedLag  sql.NullFloat64
+			writeLag, flushLag, delay sql.NullFloat64
+		)
+		err := rows.Scan(&name, &slotType, &database, &active, &restartLag,
+			&confirmedLag, &writeLag, &flushLag, &delay)
+		if err != nil {
+			return err
+		}
+		tags := map[string]string{
+			"server":    server,
+			"slot_name": name,
+			"slot_type": slotType,
+		}
+		if database != "" {
+			tags["db"] = database
+		}
+		fields := map[string]interface{}{"active": active}
+		addValid(fields, map[string]interface{}{
+			"restart_lag_bytes":         restartLag,
+			"confirmed_flush_lag_bytes": confirmedLag,
+			"write_lag":                 writeLag,
+			"flush_lag":                 flushLag,
+			"replay_lag":                delay,
+		})
+		acc.AddFields("postgresql_replication_slot", fields, tags)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if version < 100000 {
+		return nil
+	}
+	rows, err = db.Query(subscriptionsQuery(version))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			name, database           string
+			enabled, active          bool
+			notReady                 int64
+			receiptAge, latestEndAge sql.NullFloat64
+			applyErrors, syncErrors  sql.NullInt64
+		)
+		err := rows.Scan(&name, &database, &enabled, &active, &notReady,
+			&receiptAge, &latestEndAge, &applyErrors, &syncErrors)
+		if err != nil {
+			return err
+		}
+		fields := map[string]interface{}{
+			"enabled":          enabled,
+			"active":           active,
+			"tables_not_ready": notReady,
+		}
+		addValid(fields, map[string]interface{}{
+			"last_msg_receipt_age": receiptAge,
+			"latest_end_age":       latestEndAge,
+			"apply_error_count":    applyErrors,
+			"sync_error_count":     syncErrors,
+		})
+		acc.AddFields("postgresql_subscription", fields, map[string]string{
+			"server":       server,
+			"subscription": name,
+			"db":           database,
+		})
+	}
+	return rows.Err()
+}
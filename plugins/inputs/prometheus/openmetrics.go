@@ -0,0 +1,296 @@
+package prometheus
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// OpenMetricsType is the media type of the OpenMetrics text format.
+const OpenMetricsType = "application/openmetrics-text"
+
+// exemplar is the exemplar of an OpenMetrics sample, such as the trace of a
+// request it counts.
+type exemplar struct {
+	labels map[string]string
+	value  float64
+	// timestamp is the exemplar time in nanoseconds, set if hasTimestamp
+	timestamp    int64
+	hasTimestamp bool
+}
+
+// openMetricsTypes are the OpenMetrics types that the Prometheus text format
+// lacks. Their samples are untyped.
+var openMetricsTypes = map[string]bool{
+	"unknown":        true,
+	"info":           true,
+	"stateset":       true,
+	"gaugehistogram": true,
+}
+
+// extractExemplars returns the sample exemplars of a text payload, keyed by
+// sampleKey, and the payload without them for the Prometheus text parser.
+// With openMetrics, the payload is in the OpenMetrics text format. Its
+// types, counter names and timestamps are rewritten to the Prometheus
+// format.
+func extractExemplars(
+	buf []byte,
+	openMetrics bool,
+) ([]byte, map[string]*exemplar, error) {
+	exemplars := make(map[string]*exemplar)
+	var out bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, len(buf)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			if openMetrics {
+				line = rewriteType(line)
+			}
+			out.WriteString(line)
+			out.WriteByte('\n')
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			out.WriteByte('\n')
+			continue
+		}
+
+		name, labels, rest, err := splitSample(line)
+		if err != nil {
+			return nil, nil, err
+		}
+		sample := rest
+		if i := strings.Index(rest, " # "); i >= 0 {
+			sample = rest[:i]
+			e, err := parseExemplar(rest[i+3:])
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid exemplar for sample "+
+					"%s: %s", name, err)
+			}
+			exemplars[sampleKey(name, labels)] = e
+		}
+		if openMetrics {
+			// timestamps are in seconds, instead of milliseconds
+			if fields := strings.Fields(sample); len(fields) == 2 {
+				ts, err := parseTimestamp(fields[1])
+				if err != nil {
+					return nil, nil, fmt.Errorf("invalid timestamp %q for "+
+						"sample %s", fields[1], name)
+				}
+				sample = fmt.Sprintf(" %s %d", fields[0], ts/1e6)
+			}
+		}
+		out.WriteString(line[:len(line)-len(rest)])
+		out.WriteString(sample)
+		out.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), exemplars, nil
+}
+
+// rewriteType rewrites an OpenMetrics TYPE line into a Prometheus one.
+// Counters take the name of their samples, with the _total suffix. Types
+// that only OpenMetrics has become untyped.
+func rewriteType(line string) string {
+	fields := strings.Fields(line)
+	if len(fields) != 4 || fields[1] != "TYPE" {
+		return line
+	}
+	name, typ := fields[2], fields[3]
+	switch {
+	case typ == "counter" && !strings.HasSuffix(name, "_total"):
+		name += "_total"
+	case openMetricsTypes[typ]:
+		typ = "untyped"
+	}
+	return "# TYPE " + name + " " + typ
+}
+
+// splitSample splits a sample line into its name, its labels and the rest
+// of the line. The rest holds the value, timestamp and exemplar.
+func splitSample(line string) (string, map[string]string, string, error) {
+	i := strings.IndexAny(line, "{ \t")
+	if i < 0 {
+		return line, nil, "", nil
+	}
+	name := line[:i]
+	if line[i] != '{' {
+		return name, nil, line[i:], nil
+	}
+	labels, rest, err := parseLabels(line[i:])
+	if err != nil {
+		return "", nil, "", fmt.Errorf("invalid labels for sample %s: %s",
+			name, err)
+	}
+	return name, labels, rest, nil
+}
+
+// parseLabels parses the labels at the start of s, {name="value",...}, and
+// returns the rest of s.
+func parseLabels(s string) (map[string]string, string, error) {
+	labels := make(map[string]string)
+	s = s[1:]
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		if strings.HasPrefix(s, "}") {
+			return labels, s[1:], nil
+		}
+		i := strings.IndexByte(s, '=')
+		if i < 0 || len(s) < i+2 || s[i+1] != '"' {
+			return nil, "", fmt.Errorf("no label value")
+		}
+		name := strings.TrimSpace(s[:i])
+		s = s[i+2:]
+
+		var value []byte
+		for {
+			if s == "" {
+				return nil, "", fmt.Errorf("no closing quote for label %s",
+					name)
+			}
+			c := s[0]
+			s = s[1:]
+			if c == '"' {
+				break
+			}
+			if c == '\\' && s != "" {
+				c, s = s[0], s[1:]
+				if c == 'n' {
+					c = '\n'
+				}
+			}
+			value = append(value, c)
+		}
+		labels[name] = string(value)
+	}
+}
+
+// parseExemplar parses an exemplar, {labels} value [timestamp].
+func parseExemplar(s string) (*exemplar, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "{") {
+		return nil, fmt.Errorf("no labels")
+	}
+	labels, rest, err := parseLabels(s)
+	if err != nil {
+		return nil, err
+	}
+	e := &exemplar{labels: labels}
+	fields := strings.Fields(rest)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("no value")
+	}
+	if e.value, err = strconv.ParseFloat(fields[0], 64); err != nil {
+		return nil, fmt.Errorf("invalid value %q", fields[0])
+	}
+	if len(fields) == 2 {
+		if e.timestamp, err = parseTimestamp(fields[1]); err != nil {
+			return nil, fmt.Errorf("invalid timestamp %q", fields[1])
+		}
+		e.hasTimestamp = true
+	}
+	return e, nil
+}
+
+// parseTimestamp parses an OpenMetrics timestamp in seconds into
+// nanoseconds. It is exact for timestamps without an exponent.
+func parseTimestamp(s string) (int64, error) {
+	if strings.ContainsAny(s, "eE") {
+		f, err := strconv.ParseFloat(s, 64)
+		return int64(f * 1e9), err
+	}
+	secs, frac := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		secs, frac = s[:i], s[i+1:]
+	}
+	if len(frac) > 9 {
+		frac = frac[:9]
+	}
+	frac += strings.Repeat("0", 9-len(frac))
+	sec, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	nsec, err := strconv.ParseUint(frac, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if strings.HasPrefix(secs, "-") {
+		return sec*1e9 - int64(nsec), nil
+	}
+	return sec*1e9 + int64(nsec), nil
+}
+
+// sampleKey returns the exemplar key of a sample, from its name and labels.
+// The "le" bound of buckets is formatted like their field names.
+func sampleKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	key := name
+	for _, k := range keys {
+		v := labels[k]
+		if k == "le" {
+			if bound, err := strconv.ParseFloat(v, 64); err == nil {
+				v = fmt.Sprint(bound)
+			}
+		}
+		key += "\xff" + k + "=" + v
+	}
+	return key
+}
+
+// addExemplars adds the sample exemplars of a metric as fields:
+// "exemplar_value", "exemplar_time" and "exemplar_<label>". For histogram
+// buckets they are "<bound>_exemplar_value" and so on.
+func addExemplars(
+	fields map[string]interface{},
+	name string,
+	typ dto.MetricType,
+	m *dto.Metric,
+	exemplars map[string]*exemplar,
+) {
+	if len(exemplars) == 0 {
+		return
+	}
+	labels := make(map[string]string)
+	for _, lp := range m.Label {
+		labels[lp.GetName()] = lp.GetValue()
+	}
+
+	switch typ {
+	case dto.MetricType_HISTOGRAM:
+		for _, b := range m.GetHistogram().Bucket {
+			bound := fmt.Sprint(b.GetUpperBound())
+			labels["le"] = bound
+			if e, ok := exemplars[sampleKey(name+"_bucket", labels)]; ok {
+				e.addFields(fields, bound+"_exemplar_")
+			}
+		}
+	case dto.MetricType_SUMMARY:
+	default:
+		if e, ok := exemplars[sampleKey(name, labels)]; ok {
+			e.addFields(fields, "exemplar_")
+		}
+	}
+}
+
+func (e *exemplar) addFields(fields map[string]interface{}, prefix string) {
+	fields[prefix+"value"] = e.value
+	if e.hasTimestamp {
+		fields[prefix+"time"] = e.timestamp
+	}
+	for k, v := range e.labels {
+		fields[prefix+k] = v
+	}
+}
@@ -55,6 +55,15 @@ func (p *PrometheusParser) Parse(buf []byte) ([]telegraf.Metric, error) {
 			metricFamilies[metricFamily.GetName()] = metricFamily
 		}
 	} else {
+		// the text format parser does not support OpenMetrics exemplars
+		var exemplars map[string]*exemplar
+		buf, exemplars, err = extractExemplars(buf,
+			err == nil && mediatype == OpenMetricsType)
+		if err != nil {
+			return nil, fmt.Errorf("reading text format failed: %s", err)
+		}
+		reader = bufio.NewReader(bytes.NewBuffer(buf))
+
 		metricFamilies, err = parser.TextToMetricFamilies(reader)
 		if err != nil {
 			return nil, fmt.Errorf("reading text format failed: %s", err)
@@ -86,6 +95,7 @@ func (p *PrometheusParser) Parse(buf []byte) ([]telegraf.Metric, error) {
 					// standard metric
 					fields = getNameAndValue(m)
 				}
+				addExemplars(fields, metricName, mf.GetType(), m, exemplars)
 				// converting to telegraf metric
 				if len(fields) > 0 {
 					metric, err := telegraf.NewMetric(metricName, tags, fields)
@@ -173,3 +173,54 @@ func TestParseLineInvalidPrometheus(t *testing.T) {
 	assert.Nil(t, metric)
 
 }
+
+const validOpenMetrics = `# HELP http_requests Requests.
+# TYPE http_requests counter
+http_requests_total{code="200",path="/a # b"} 1027 1456857329.5 # {trace_id="4bf92f3577b34da6",span_id="00f067aa"} 1 1456857329.123
+# TYPE http_request_duration_seconds histogram
+http_request_duration_seconds_bucket{le="0.25"} 10 # {trace_id="a1"} 0.2
+http_request_duration_seconds_bucket{le="1.0"} 12
+http_request_duration_seconds_bucket{le="+Inf"} 13 # {trace_id="a2"} 3.5 1456857329
+http_request_duration_seconds_sum 7.5
+http_request_duration_seconds_count 13
+# TYPE build info
+build_info{version="1.2"} 1
+# EOF
+`
+
+func TestParseOpenMetricsExemplars(t *testing.T) {
+	parser := PrometheusParser{
+		PromFormat: map[string]string{
+			"Content-Type": OpenMetricsType + "; version=0.0.1; charset=utf-8",
+		},
+	}
+	metrics, err := parser.Parse([]byte(validOpenMetrics))
+	assert.NoError(t, err)
+	assert.Len(t, metrics, 3)
+
+	byName := make(map[string]map[string]interface{})
+	for _, m := range metrics {
+		byName[m.Name()] = m.Fields()
+	}
+	assert.Equal(t, map[string]interface{}{
+		"counter":           float64(1027),
+		"exemplar_value":    float64(1),
+		"exemplar_time":     int64(1456857329123000000),
+		"exemplar_trace_id": "4bf92f3577b34da6",
+		"exemplar_span_id":  "00f067aa",
+	}, byName["http_requests_total"])
+	assert.Equal(t, map[string]interface{}{
+		"0.25":                   10.0,
+		"1":                      12.0,
+		"+Inf":                   13.0,
+		"count":                  13.0,
+		"sum":                    0.0,
+		"0.25_exemplar_value":    0.2,
+		"0.25_exemplar_trace_id": "a1",
+		"+Inf_exemplar_value":    3.5,
+		"+Inf_exemplar_time":     int64(1456857329000000000),
+		"+Inf_exemplar_trace_id": "a2",
+	}, byName["http_request_duration_seconds"])
+	assert.Equal(t, map[string]interface{}{"value": 1.0},
+		byName["build_info"])
+}
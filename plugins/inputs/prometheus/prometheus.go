@@ -20,6 +20,8 @@ type Prometheus struct {
 	InsecureSkipVerify bool
 	// Bearer Token authorization file path
 	BearerToken string `toml:"bearer_token"`
+	// Request the OpenMetrics text format, to get sample exemplars
+	OpenMetrics bool `toml:"openmetrics"`
 }
 
 var sampleConfig = `
@@ -30,6 +32,10 @@ var sampleConfig = `
   # insecure_skip_verify = false
   ## Use bearer token for authorization
   # bearer_token = /path/to/bearer/token
+
+  ## Request the OpenMetrics text format. Its exemplars, such as trace IDs,
+  ## are added as metric fields
+  # openmetrics = false
 `
 
 func (p *Prometheus) SampleConfig() string {
@@ -75,6 +81,10 @@ func (p *Prometheus) gatherURL(url string, acc telegraf.Accumulator) error {
 	collectDate := time.Now()
 	var req, err = http.NewRequest("GET", url, nil)
 	req.Header = make(http.Header)
+	if p.OpenMetrics {
+		req.Header.Set("Accept", OpenMetricsType+"; version=0.0.1,"+
+			"text/plain;version=0.0.4;q=0.5,*/*;q=0.1")
+	}
 	var token []byte
 	var resp *http.Response
 
@@ -114,8 +124,8 @@ func (p *Prometheus) gatherURL(url string, acc telegraf.Accumulator) error {
 
 	// Headers
 	headers := make(map[string]string)
-	for key, value := range headers {
-		headers[key] = value
+	for key := range resp.Header {
+		headers[key] = resp.Header.Get(key)
 	}
 
 	// Prepare Prometheus parser config
@@ -0,0 +1,185 @@
+package redis
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+)
+
+// clusterNode is a node parsed from a CLUSTER NODES line: its id, address,
+// flags, master, config epoch, link state and slots.
+type clusterNode struct {
+	id          string
+	host        string
+	port        string
+	flags       map[string]bool
+	master      string
+	configEpoch int64
+	linkState   string
+	slots       int64
+	migrating   int64
+	importing   int64
+}
+
+// role returns the role of a node, master or slave as INFO reports it.
+func (n *clusterNode) role() string {
+	if n.flags["slave"] {
+		return "slave"
+	}
+	return "master"
+}
+
+// shard returns the id of the master that owns the slots of a node.
+func (n *clusterNode) shard() string {
+	if n.flags["slave"] && n.master != "-" {
+		return n.master
+	}
+	return n.id
+}
+
+// parseClusterNodes parses a CLUSTER NODES reply.
+func parseClusterNodes(s string) ([]*clusterNode, error) {
+	var nodes []*clusterNode
+	for _, line := range strings.Split(s, "\n") {
+		parts := strings.Fields(line)
+		if len(parts) == 0 {
+			continue
+		}
+		if len(parts) < 8 {
+			return nil, ErrProtocolError
+		}
+		n := &clusterNode{
+			id:        parts[0],
+			flags:     make(map[string]bool),
+			master:    parts[3],
+			linkState: parts[7],
+		}
+		// the address is ip:port@cport,hostname since Redis 7, ip:port before
+		addr := strings.SplitN(strings.SplitN(parts[1], ",", 2)[0], "@", 2)[0]
+		if i := strings.LastIndex(addr, ":"); i >= 0 {
+			n.host, n.port = addr[:i], addr[i+1:]
+		}
+		for _, flag := range strings.Split(parts[2], ",") {
+			n.flags[flag] = true
+		}
+		n.configEpoch, _ = strconv.ParseInt(parts[6], 10, 64)
+
+		// slots are single, ranges, or [slot->-id] and [slot-<-id] for
+		// migrating and importing slots
+		for _, slot := range parts[8:] {
+			switch {
+			case strings.Contains(slot, "->-"):
+				n.migrating++
+			case strings.Contains(slot, "-<-"):
+				n.importing++
+			case strings.Contains(slot, "-"):
+				r := strings.SplitN(slot, "-", 2)
+				start, err1 := strconv.ParseInt(r[0], 10, 64)
+				end, err2 := strconv.ParseInt(r[1], 10, 64)
+				if err1 != nil || err2 != nil {
+					return nil, ErrProtocolError
+				}
+				n.slots += end - start + 1
+			default:
+				n.slots++
+			}
+		}
+		nodes = append(nodes, n)
+	}
+	return nodes, nil
+}
+
+// gatherCluster gathers the cluster state from a seed node, and the slots
+// and links of every node as the seed knows them. It also gathers the INFO
+// of every node.
+func (r *Redis) gatherCluster(addr *url.URL, acc telegraf.Accumulator) error {
+	if _, _, err := net.SplitHostPort(addr.Host); err != nil {
+		addr.Host = addr.Host + ":" + defaultPort
+	}
+	c, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	info, err := c.doString("CLUSTER", "INFO")
+	if err != nil {
+		return err
+	}
+	list, err := c.doString("CLUSTER", "NODES")
+	if err != nil {
+		return err
+	}
+	nodes, err := parseClusterNodes(list)
+	if err != nil {
+		return err
+	}
+
+	host, port, _ := net.SplitHostPort(addr.Host)
+	acc.AddFields("redis_cluster", clusterInfoFields(info),
+		map[string]string{"server": host, "port": port})
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for _, n := range nodes {
+		tags := map[string]string{
+			"server": n.host,
+			"port":   n.port,
+			"role":   n.role(),
+			"shard":  n.shard(),
+		}
+		acc.AddFields("redis_cluster_node", map[string]interface{}{
+			"slots":           n.slots,
+			"migrating_slots": n.migrating,
+			"importing_slots": n.importing,
+			"config_epoch":    n.configEpoch,
+			"link_state":      n.linkState,
+			"pfail":           n.flags["fail?"],
+			"fail":            n.flags["fail"],
+		}, tags)
+
+		if n.host == "" || n.flags["noaddr"] || n.flags["handshake"] {
+			continue
+		}
+		wg.Add(1)
+		go func(n *clusterNode) {
+			defer wg.Done()
+			u := &url.URL{
+				Scheme: "tcp",
+				User:   addr.User,
+				Host:   net.JoinHostPort(n.host, n.port),
+			}
+			err := r.gatherServer(u, acc, map[string]string{"shard": n.shard()})
+			if err != nil {
+				errs <- err
+			}
+		}(n)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// clusterInfoFields returns the fields of a CLUSTER INFO reply, without the
+// cluster_ prefix. These are the state and the counters for slots, nodes and
+// bus messages, including the failover messages.
+func clusterInfoFields(info string) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, line := range strings.Split(info, "\n") {
+		parts := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		name := strings.TrimPrefix(parts[0], "cluster_")
+		if ival, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
+			fields[name] = ival
+		} else if name == "state" {
+			fields[name] = parts[1]
+		}
+	}
+	return fields
+}
@@ -0,0 +1,267 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+// bulk encodes a bulk string reply.
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+// array encodes an array reply with bulk strings.
+func array(values ...string) string {
+	reply := fmt.Sprintf("*%d\r\n", len(values))
+	for _, v := range values {
+		reply += bulk(v)
+	}
+	return reply
+}
+
+// newServer answers the commands it receives with the replies of handle. It
+// refuses any password other than "secret".
+func newServer(t *testing.T, handle func(cmd string) string) net.Listener {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				c := &client{conn: conn, r: bufio.NewReader(conn)}
+				for {
+					reply, err := c.read()
+					if err != nil {
+						return
+					}
+					var args []string
+					for _, arg := range reply.([]interface{}) {
+						args = append(args, arg.(string))
+					}
+					cmd := strings.Join(args, " ")
+					switch {
+					case cmd == "AUTH secret":
+						conn.Write([]byte("+OK\r\n"))
+					case args[0] == "AUTH":
+						conn.Write([]byte("-ERR invalid password\r\n"))
+					default:
+						conn.Write([]byte(handle(cmd)))
+					}
+				}
+			}()
+		}
+	}()
+	return l
+}
+
+func infoServer(t *testing.T, role string) net.Listener {
+	return newServer(t, func(cmd string) string {
+		if cmd != "INFO" {
+			return "-ERR unknown command\r\n"
+		}
+		return bulk("# Clients\r\nconnected_clients:3\r\n\r\n# Replication\r\nrole:" +
+			role + "\r\n")
+	})
+}
+
+func hostPort(l net.Listener) (string, string) {
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port
+}
+
+const (
+	idA = "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca"
+	idB = "07c37dfeb235213a872192d90877d0cd55635b91"
+	idC = "67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1"
+)
+
+func TestParseClusterNodes(t *testing.T) {
+	nodes, err := parseClusterNodes(idA + " 127.0.0.1:30001@31001,node-a myself,master - 0 1426238316232 1 connected 0-5460 5462 [5461->-" + idC + "]\n" +
+		idB + " :0@0 slave,fail?,noaddr " + idA + " 0 1426238317239 4 disconnected\n")
+	require.NoError(t, err)
+	require.Len(t, nodes, 2)
+
+	a, b := nodes[0], nodes[1]
+	assert.Equal(t, "127.0.0.1", a.host)
+	assert.Equal(t, "30001", a.port)
+	assert.Equal(t, "master", a.role())
+	assert.Equal(t, idA, a.shard())
+	assert.Equal(t, int64(5462), a.slots)
+	assert.Equal(t, int64(1), a.migrating)
+	assert.Equal(t, int64(1), a.configEpoch)
+
+	assert.Equal(t, "", b.host)
+	assert.Equal(t, "slave", b.role())
+	assert.Equal(t, idA, b.shard())
+	assert.True(t, b.flags["fail?"])
+	assert.Equal(t, "disconnected", b.linkState)
+
+	_, err = parseClusterNodes("invalid\n")
+	assert.Error(t, err)
+}
+
+func TestGatherCluster(t *testing.T) {
+	b := infoServer(t, "slave")
+	defer b.Close()
+	c := infoServer(t, "master")
+	defer c.Close()
+
+	var a net.Listener
+	a = newServer(t, func(cmd string) string {
+		switch cmd {
+		case "INFO":
+			return bulk("# Replication\r\nrole:master\r\n")
+		case "CLUSTER INFO":
+			return bulk("cluster_state:ok\r\ncluster_slots_assigned:16384\r\n" +
+				"cluster_slots_ok:16384\r\ncluster_slots_pfail:0\r\n" +
+				"cluster_slots_fail:0\r\ncluster_known_nodes:3\r\n" +
+				"cluster_size:2\r\ncluster_current_epoch:6\r\n" +
+				"cluster_stats_messages_failover_auth_request_sent:1\r\n")
+		case "CLUSTER NODES":
+			return bulk(fmt.Sprintf(
+				"%s %s@1 myself,master - 0 0 1 connected 0-8191\n"+
+					"%s %s@1 slave %s 0 0 1 connected\n"+
+					"%s %s@1 master - 0 0 2 connected 8192-16383\n",
+				idA, a.Addr(), idB, b.Addr(), idA, idC, c.Addr()))
+		}
+		return "-ERR unknown command\r\n"
+	})
+	defer a.Close()
+
+	acc := &testutil.Accumulator{}
+	r := &Redis{
+		Servers: []string{"tcp://:secret@" + a.Addr().String()},
+		Cluster: true,
+	}
+	require.NoError(t, r.Gather(acc))
+
+	host, port := hostPort(a)
+	acc.AssertContainsTaggedFields(t, "redis_cluster",
+		map[string]interface{}{
+			"state":          "ok",
+			"slots_assigned": int64(16384),
+			"slots_ok":       int64(16384),
+			"slots_pfail":    int64(0),
+			"slots_fail":     int64(0),
+			"known_nodes":    int64(3),
+			"size":           int64(2),
+			"current_epoch":  int64(6),
+			"stats_messages_failover_auth_request_sent": int64(1),
+		},
+		map[string]string{"server": host, "port": port})
+
+	for _, node := range []struct {
+		l     net.Listener
+		role  string
+		shard string
+		slots int64
+	}{
+		{a, "master", idA, 8192},
+		{b, "slave", idA, 0},
+		{c, "master", idC, 8192},
+	} {
+		host, port := hostPort(node.l)
+		tags := map[string]string{
+			"server": host,
+			"port":   port,
+			"role":   node.role,
+			"shard":  node.shard,
+		}
+		acc.AssertContainsTaggedFields(t, "redis_cluster_node",
+			map[string]interface{}{
+				"slots":           node.slots,
+				"migrating_slots": int64(0),
+				"importing_slots": int64(0),
+				"config_epoch":    map[string]int64{idA: 1, idC: 2}[node.shard],
+				"link_state":      "connected",
+				"pfail":           false,
+				"fail":            false,
+			}, tags)
+		// the INFO of every node, with its role and shard
+		found := false
+		for _, m := range acc.Metrics {
+			if m.Measurement == "redis" && m.Tags["port"] == port {
+				assert.Equal(t, tags, m.Tags)
+				found = true
+			}
+		}
+		assert.True(t, found, "no INFO for %s", node.l.Addr())
+	}
+
+	r.Servers = []string{"tcp://:wrong@" + a.Addr().String()}
+	assert.Error(t, r.Gather(&testutil.Accumulator{}))
+}
+
+func TestGatherSentinel(t *testing.T) {
+	master := infoServer(t, "master")
+	defer master.Close()
+	replica := infoServer(t, "slave")
+	defer replica.Close()
+	mHost, mPort := hostPort(master)
+	rHost, rPort := hostPort(replica)
+	down := infoServer(t, "slave")
+	dHost, dPort := hostPort(down)
+	down.Close()
+
+	sentinel := newServer(t, func(cmd string) string {
+		switch cmd {
+		case "SENTINEL MASTERS":
+			return "*1\r\n" + array("name", "mymaster", "ip", mHost,
+				"port", mPort, "flags", "master,s_down", "num-slaves", "2",
+				"num-other-sentinels", "2", "quorum", "2", "config-epoch", "3")
+		case "SENTINEL REPLICAS mymaster":
+			// a sentinel before Redis 5
+			return "-ERR Unknown sentinel subcommand 'replicas'\r\n"
+		case "SENTINEL SLAVES mymaster":
+			return "*2\r\n" + array("ip", rHost, "port", rPort, "flags",
+				"slave", "master-link-status", "ok") +
+				array("ip", dHost, "port", dPort, "flags",
+					"slave,s_down,disconnected", "master-link-status", "err")
+		}
+		return "-ERR unknown command\r\n"
+	})
+	defer sentinel.Close()
+
+	acc := &testutil.Accumulator{}
+	r := &Redis{
+		Servers:  []string{"tcp://" + sentinel.Addr().String()},
+		Sentinel: true,
+	}
+	// the replica down is unreachable
+	assert.Error(t, r.Gather(acc))
+
+	host, port := hostPort(sentinel)
+	acc.AssertContainsTaggedFields(t, "redis_sentinel_master",
+		map[string]interface{}{
+			"sdown":                true,
+			"odown":                false,
+			"failover_in_progress": false,
+			"num_slaves":           int64(2),
+			"num_other_sentinels":  int64(2),
+			"quorum":               int64(2),
+			"config_epoch":         int64(3),
+			"replicas_down":        int64(1),
+			"replicas_link_down":   int64(1),
+		},
+		map[string]string{"server": host, "port": port, "shard": "mymaster"})
+	acc.AssertContainsTaggedFields(t, "redis",
+		map[string]interface{}{"clients": uint64(3), "keyspace_hitrate": float64(0)},
+		map[string]string{"server": mHost, "port": mPort, "role": "master",
+			"shard": "mymaster"})
+	acc.AssertContainsTaggedFields(t, "redis",
+		map[string]interface{}{"clients": uint64(3), "keyspace_hitrate": float64(0)},
+		map[string]string{"server": rHost, "port": rPort, "role": "slave",
+			"shard": "mymaster"})
+}
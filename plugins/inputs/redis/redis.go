@@ -17,6 +17,11 @@ import (
 
 type Redis struct {
 	Servers []string
+	// Cluster gathers every node that CLUSTER NODES lists on each server.
+	Cluster bool
+	// Sentinel treats each server as a sentinel, and gathers the masters it
+	// monitors and all their replicas.
+	Sentinel bool
 }
 
 var sampleConfig = `
@@ -29,6 +34,14 @@ var sampleConfig = `
   ## If no servers are specified, then localhost is used as the host.
   ## If no port is specified, 6379 is used
   servers = ["tcp://localhost:6379"]
+
+  ## Treat each server as the seed of a Redis Cluster. Gather the cluster
+  ## state, slots and links it knows, and the INFO of every node
+  # cluster = false
+
+  ## Treat each server as a sentinel, with a default port of 26379. Gather
+  ## the masters it monitors, their failovers, and every master and replica
+  # sentinel = false
 `
 
 var defaultTimeout = 5 * time.Second
@@ -86,7 +99,7 @@ func (r *Redis) Gather(acc telegraf.Accumulator) error {
 		url := &url.URL{
 			Host: ":6379",
 		}
-		r.gatherServer(url, acc)
+		r.gather(url, acc)
 		return nil
 	}
 
@@ -107,7 +120,7 @@ func (r *Redis) Gather(acc telegraf.Accumulator) error {
 		wg.Add(1)
 		go func(serv string) {
 			defer wg.Done()
-			outerr = r.gatherServer(u, acc)
+			outerr = r.gather(u, acc)
 		}(serv)
 	}
 
@@ -118,47 +131,49 @@ func (r *Redis) Gather(acc telegraf.Accumulator) error {
 
 const defaultPort = "6379"
 
-func (r *Redis) gatherServer(addr *url.URL, acc telegraf.Accumulator) error {
+// gather gathers a server, or the deployment it is the seed for.
+func (r *Redis) gather(addr *url.URL, acc telegraf.Accumulator) error {
+	switch {
+	case r.Sentinel:
+		return r.gatherSentinel(addr, acc)
+	case r.Cluster:
+		return r.gatherCluster(addr, acc)
+	}
+	return r.gatherServer(addr, acc, nil)
+}
+
+// gatherServer gathers the INFO of a server. It is tagged with the server
+// address and with extra.
+func (r *Redis) gatherServer(
+	addr *url.URL,
+	acc telegraf.Accumulator,
+	extra map[string]string,
+) error {
 	_, _, err := net.SplitHostPort(addr.Host)
 	if err != nil {
 		addr.Host = addr.Host + ":" + defaultPort
 	}
 
-	c, err := net.DialTimeout("tcp", addr.Host, defaultTimeout)
+	c, err := dial(addr)
 	if err != nil {
-		return fmt.Errorf("Unable to connect to redis server '%s': %s", addr.Host, err)
+		return err
 	}
 	defer c.Close()
 
-	// Extend connection
-	c.SetDeadline(time.Now().Add(defaultTimeout))
-
-	if addr.User != nil {
-		pwd, set := addr.User.Password()
-		if set && pwd != "" {
-			c.Write([]byte(fmt.Sprintf("AUTH %s\r\n", pwd)))
-
-			rdr := bufio.NewReader(c)
-
-			line, err := rdr.ReadString('\n')
-			if err != nil {
-				return err
-			}
-			if line[0] != '+' {
-				return fmt.Errorf("%s", strings.TrimSpace(line)[1:])
-			}
-		}
+	info, err := c.doString("INFO")
+	if err != nil {
+		return err
 	}
-
-	c.Write([]byte("INFO\r\n"))
-	c.Write([]byte("EOF\r\n"))
-	rdr := bufio.NewReader(c)
+	rdr := bufio.NewReader(strings.NewReader(info))
 
 	// Setup tags for all redis metrics
 	host, port := "unknown", "unknown"
 	// If there's an error, ignore and use 'unknown' tags
 	host, port, _ = net.SplitHostPort(addr.Host)
 	tags := map[string]string{"server": host, "port": port}
+	for k, v := range extra {
+		tags[k] = v
+	}
 
 	return gatherInfoOutput(rdr, acc, tags)
 }
@@ -0,0 +1,139 @@
+package redis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisError is an error reply from a server.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// client is a connection to a server that sends RESP commands.
+type client struct {
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+// dial connects to a server, and authenticates with the password in its URL.
+func dial(addr *url.URL) (*client, error) {
+	conn, err := net.DialTimeout("tcp", addr.Host, defaultTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to connect to redis server '%s': %s", addr.Host, err)
+	}
+	// Extend connection
+	conn.SetDeadline(time.Now().Add(defaultTimeout))
+	c := &client{conn: conn, r: bufio.NewReader(conn)}
+
+	if addr.User != nil {
+		pwd, set := addr.User.Password()
+		if set && pwd != "" {
+			if _, err := c.do("AUTH", pwd); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+	}
+	return c, nil
+}
+
+func (c *client) Close() error {
+	return c.conn.Close()
+}
+
+// do sends a command and returns its reply: a string, an int64, a slice of
+// replies, or nil.
+func (c *client) do(args ...string) (interface{}, error) {
+	cmd := fmt.Sprintf("*%d\r\n", len(args))
+	for _, arg := range args {
+		cmd += fmt.Sprintf("$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := c.conn.Write([]byte(cmd)); err != nil {
+		return nil, err
+	}
+	return c.read()
+}
+
+// doString sends a command whose reply is a string.
+func (c *client) doString(args ...string) (string, error) {
+	reply, err := c.do(args...)
+	if err != nil {
+		return "", err
+	}
+	s, ok := reply.(string)
+	if !ok {
+		return "", ErrProtocolError
+	}
+	return s, nil
+}
+
+func (c *client) read() (interface{}, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if len(line) == 0 {
+		return nil, ErrProtocolError
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrProtocolError
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		b := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, b); err != nil {
+			return nil, err
+		}
+		return string(b[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, ErrProtocolError
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, ErrProtocolError
+}
+
+// pairs returns a reply of alternating names and values as a map. SENTINEL
+// replies use this layout.
+func pairs(reply interface{}) map[string]string {
+	m := make(map[string]string)
+	values, _ := reply.([]interface{})
+	for i := 0; i+1 < len(values); i += 2 {
+		k, _ := values[i].(string)
+		v, _ := values[i+1].(string)
+		m[k] = v
+	}
+	return m
+}
@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+)
+
+const defaultSentinelPort = "26379"
+
+// gatherSentinel gathers the masters a sentinel monitors, with their
+// failovers and replicas. It also gathers the INFO of every master and
+// replica.
+func (r *Redis) gatherSentinel(addr *url.URL, acc telegraf.Accumulator) error {
+	if _, _, err := net.SplitHostPort(addr.Host); err != nil {
+		addr.Host = addr.Host + ":" + defaultSentinelPort
+	}
+	c, err := dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	reply, err := c.do("SENTINEL", "MASTERS")
+	if err != nil {
+		return err
+	}
+	masters, ok := reply.([]interface{})
+	if !ok {
+		return ErrProtocolError
+	}
+
+	host, port, _ := net.SplitHostPort(addr.Host)
+	// the master and replica nodes, mapped to their shard
+	nodes := make(map[string]string)
+	for _, m := range masters {
+		master := pairs(m)
+		name := master["name"]
+		flags := sentinelFlags(master)
+		fields := map[string]interface{}{
+			"sdown":                flags["s_down"],
+			"odown":                flags["o_down"],
+			"failover_in_progress": flags["failover_in_progress"],
+		}
+		for _, k := range []string{"num-slaves", "num-other-sentinels",
+			"quorum", "config-epoch"} {
+			if ival, err := strconv.ParseInt(master[k], 10, 64); err == nil {
+				fields[strings.Replace(k, "-", "_", -1)] = ival
+			}
+		}
+		if master["ip"] != "" {
+			nodes[net.JoinHostPort(master["ip"], master["port"])] = name
+		}
+
+		// SENTINEL REPLICAS is SENTINEL SLAVES before Redis 5
+		reply, err := c.do("SENTINEL", "REPLICAS", name)
+		if _, ok := err.(redisError); ok {
+			reply, err = c.do("SENTINEL", "SLAVES", name)
+		}
+		if err != nil {
+			return err
+		}
+		replicas, _ := reply.([]interface{})
+		var down, linkDown int64
+		for _, rp := range replicas {
+			replica := pairs(rp)
+			if sentinelFlags(replica)["s_down"] {
+				down++
+			}
+			if replica["master-link-status"] != "ok" {
+				linkDown++
+			}
+			if replica["ip"] != "" {
+				nodes[net.JoinHostPort(replica["ip"], replica["port"])] = name
+			}
+		}
+		fields["replicas_down"] = down
+		fields["replicas_link_down"] = linkDown
+
+		acc.AddFields("redis_sentinel_master", fields, map[string]string{
+			"server": host,
+			"port":   port,
+			"shard":  name,
+		})
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(nodes))
+	for node, shard := range nodes {
+		wg.Add(1)
+		go func(node, shard string) {
+			defer wg.Done()
+			u := &url.URL{Scheme: "tcp", User: addr.User, Host: node}
+			err := r.gatherServer(u, acc, map[string]string{"shard": shard})
+			if err != nil {
+				errs <- err
+			}
+		}(node, shard)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// sentinelFlags returns the flags SENTINEL reports for a master or replica.
+func sentinelFlags(node map[string]string) map[string]bool {
+	flags := make(map[string]bool)
+	for _, flag := range strings.Split(node["flags"], ",") {
+		flags[flag] = true
+	}
+	return flags
+}
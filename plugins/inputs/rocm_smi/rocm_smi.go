@@ -0,0 +1,282 @@
+package rocm_smi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+// errNotSupported is returned for metrics that a device, or the ROCm SMI
+// library, does not support.
+var errNotSupported = errors.New("not supported")
+
+// ROCm SMI library temperature sensors
+const (
+	tempEdge = iota
+	tempJunction
+	tempMemory
+)
+
+// xgmiMetrics are the XGMI links of a device, from its GPU metrics table.
+// read and write are the kilobytes transferred over each link since the
+// driver loaded. Values the device does not report have all bits set.
+type xgmiMetrics struct {
+	linkWidth uint64
+	linkSpeed uint64
+	read      []uint64
+	write     []uint64
+}
+
+// processInfo is the usage of a device by a process.
+type processInfo struct {
+	vramUsage   uint64
+	sdmaUsage   uint64
+	cuOccupancy uint64
+}
+
+// library is the part of the ROCm SMI library that telegraf calls. Values
+// use the library units: bytes, millidegrees Celsius and microwatts.
+type library interface {
+	init() error
+	shutDown() error
+	numDevices() (uint32, error)
+	name(dev uint32) (string, error)
+	pciID(dev uint32) (uint64, error)
+	busyPercent(dev uint32) (uint32, error)
+	memoryBusyPercent(dev uint32) (uint32, error)
+	memoryTotal(dev uint32) (uint64, error)
+	memoryUsage(dev uint32) (uint64, error)
+	temperature(dev uint32, sensor int) (int64, error)
+	powerAverage(dev uint32) (uint64, error)
+	powerCap(dev uint32) (uint64, error)
+	xgmi(dev uint32) (xgmiMetrics, error)
+	processes() ([]uint32, error)
+	processDevices(pid uint32) ([]uint32, error)
+	processInfo(pid, dev uint32) (processInfo, error)
+}
+
+type RocmSmi struct {
+	GatherProcesses bool `toml:"gather_processes"`
+	GatherXgmi      bool `toml:"gather_xgmi"`
+
+	lib library
+}
+
+var sampleConfig = `
+  ## Gather the XGMI links of each GPU, needs ROCm 6.0 or later
+  # gather_xgmi = true
+
+  ## Gather the usage of each GPU by each process
+  # gather_processes = true
+`
+
+func (r *RocmSmi) SampleConfig() string {
+	return sampleConfig
+}
+
+func (r *RocmSmi) Description() string {
+	return "Read AMD GPU metrics from the ROCm SMI library"
+}
+
+func (r *RocmSmi) Gather(acc telegraf.Accumulator) error {
+	if err := r.lib.init(); err != nil {
+		return fmt.Errorf("rocm_smi: initializing: %s", err)
+	}
+	defer r.lib.shutDown()
+
+	n, err := r.lib.numDevices()
+	if err != nil {
+		return fmt.Errorf("rocm_smi: devices: %s", err)
+	}
+	devices := make(map[uint32]map[string]string)
+	for dev := uint32(0); dev < n; dev++ {
+		tags, err := r.gatherDevice(dev, acc)
+		if err != nil {
+			return fmt.Errorf("rocm_smi: GPU %d: %s", dev, err)
+		}
+		devices[dev] = tags
+	}
+
+	if r.GatherProcesses {
+		if err := r.gatherProcesses(devices, acc); err != nil {
+			return fmt.Errorf("rocm_smi: processes: %s", err)
+		}
+	}
+	return nil
+}
+
+// gatherDevice gathers the utilization, memory, temperatures and power of a
+// device, and its XGMI links, and returns its tags.
+func (r *RocmSmi) gatherDevice(
+	dev uint32,
+	acc telegraf.Accumulator,
+) (map[string]string, error) {
+	tags := map[string]string{"gpu": strconv.Itoa(int(dev))}
+	name, err := r.lib.name(dev)
+	if err != nil && err != errNotSupported {
+		return nil, err
+	}
+	if name != "" {
+		tags["name"] = name
+	}
+	id, err := r.lib.pciID(dev)
+	if err != nil && err != errNotSupported {
+		return nil, err
+	}
+	if err == nil {
+		tags["pci_bus_id"] = pciBusID(id)
+	}
+
+	fields := make(map[string]interface{})
+	// add adds a library value as a field, if the metric is supported
+	add := func(field string, v interface{}, err error) error {
+		if err == errNotSupported {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", field, err)
+		}
+		fields[field] = v
+		return nil
+	}
+
+	busy, err := r.lib.busyPercent(dev)
+	if err := add("utilization_gpu", int64(busy), err); err != nil {
+		return nil, err
+	}
+	busy, err = r.lib.memoryBusyPercent(dev)
+	if err := add("utilization_memory", int64(busy), err); err != nil {
+		return nil, err
+	}
+	total, err := r.lib.memoryTotal(dev)
+	if err := add("memory_total", int64(total), err); err != nil {
+		return nil, err
+	}
+	used, err := r.lib.memoryUsage(dev)
+	if err := add("memory_used", int64(used), err); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["memory_used"]; ok && total >= used {
+		fields["memory_free"] = int64(total - used)
+	}
+	for sensor, field := range map[int]string{
+		tempEdge:     "temperature_edge",
+		tempJunction: "temperature_junction",
+		tempMemory:   "temperature_memory",
+	} {
+		t, err := r.lib.temperature(dev, sensor)
+		if err := add(field, float64(t)/1000, err); err != nil {
+			return nil, err
+		}
+	}
+	power, err := r.lib.powerAverage(dev)
+	if err := add("power_draw", float64(power)/1e6, err); err != nil {
+		return nil, err
+	}
+	power, err = r.lib.powerCap(dev)
+	if err := add("power_cap", float64(power)/1e6, err); err != nil {
+		return nil, err
+	}
+	acc.AddFields("rocm_smi", fields, tags)
+
+	if !r.GatherXgmi {
+		return tags, nil
+	}
+	xgmi, err := r.lib.xgmi(dev)
+	if err == errNotSupported {
+		return tags, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("xgmi: %s", err)
+	}
+	for link := range xgmi.read {
+		// links that are not connected have no data
+		if !valid(xgmi.read[link]) || link >= len(xgmi.write) ||
+			!valid(xgmi.write[link]) {
+			continue
+		}
+		linkTags := map[string]string{"link": strconv.Itoa(link)}
+		for k, v := range tags {
+			linkTags[k] = v
+		}
+		linkFields := map[string]interface{}{
+			"read_kb":  int64(xgmi.read[link]),
+			"write_kb": int64(xgmi.write[link]),
+		}
+		if valid(xgmi.linkWidth) {
+			linkFields["link_width"] = int64(xgmi.linkWidth)
+		}
+		if valid(xgmi.linkSpeed) {
+			linkFields["link_speed"] = int64(xgmi.linkSpeed)
+		}
+		acc.AddFields("rocm_smi_xgmi", linkFields, linkTags)
+	}
+	return tags, nil
+}
+
+// gatherProcesses gathers the usage of each device by each process using it.
+func (r *RocmSmi) gatherProcesses(
+	devices map[uint32]map[string]string,
+	acc telegraf.Accumulator,
+) error {
+	pids, err := r.lib.processes()
+	if err == errNotSupported {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		devs, err := r.lib.processDevices(pid)
+		if err != nil {
+			// the processes exited since they were listed
+			continue
+		}
+		for _, dev := range devs {
+			info, err := r.lib.processInfo(pid, dev)
+			if err != nil {
+				continue
+			}
+			tags := map[string]string{"pid": strconv.Itoa(int(pid))}
+			if name := processName(pid); name != "" {
+				tags["process"] = name
+			}
+			for k, v := range devices[dev] {
+				tags[k] = v
+			}
+			acc.AddFields("rocm_smi_process", map[string]interface{}{
+				"vram_used":    int64(info.vramUsage),
+				"sdma_usage":   int64(info.sdmaUsage),
+				"cu_occupancy": int64(info.cuOccupancy),
+			}, tags)
+		}
+	}
+	return nil
+}
+
+// valid returns whether the device reports a GPU metrics table value.
+// Values it does not report have all bits set.
+func valid(v uint64) bool {
+	return v != ^uint64(0)
+}
+
+// pciBusID formats the BDF ID from the library as a PCI bus ID. The domain
+// is in the upper 32 bits, and the bus, device and function are in the
+// lower 16 bits.
+func pciBusID(id uint64) string {
+	return fmt.Sprintf("%04x:%02x:%02x.%x",
+		id>>32, (id>>8)&0xff, (id>>3)&0x1f, id&0x7)
+}
+
+// processName returns the command name of a process, empty if it exited.
+var processName = func(pid uint32) string {
+	comm, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/comm", pid))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(comm))
+}
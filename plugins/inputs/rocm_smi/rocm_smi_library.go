@@ -0,0 +1,209 @@
+// +build linux,rocm_smi
+
+package rocm_smi
+
+/*
+#cgo CFLAGS: -I/opt/rocm/include
+#cgo LDFLAGS: -L/opt/rocm/lib -Wl,-rpath,/opt/rocm/lib -lrocm_smi64
+#include <rocm_smi/rocm_smi.h>
+*/
+import "C"
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/plugins/inputs"
+)
+
+// rsmi is the ROCm SMI library in librocm_smi64.
+type rsmi struct{}
+
+// check converts a library status to an error.
+func check(status C.rsmi_status_t) error {
+	switch status {
+	case C.RSMI_STATUS_SUCCESS:
+		return nil
+	case C.RSMI_STATUS_NOT_SUPPORTED, C.RSMI_STATUS_NOT_YET_IMPLEMENTED:
+		return errNotSupported
+	}
+	var s *C.char
+	if C.rsmi_status_string(status, &s) == C.RSMI_STATUS_SUCCESS && s != nil {
+		return errors.New(C.GoString(s))
+	}
+	return fmt.Errorf("status %d", int(status))
+}
+
+func (rsmi) init() error {
+	return check(C.rsmi_init(0))
+}
+
+func (rsmi) shutDown() error {
+	return check(C.rsmi_shut_down())
+}
+
+func (rsmi) numDevices() (uint32, error) {
+	var n C.uint32_t
+	err := check(C.rsmi_num_monitor_devices(&n))
+	return uint32(n), err
+}
+
+func (rsmi) name(dev uint32) (string, error) {
+	buf := make([]C.char, 256)
+	err := check(C.rsmi_dev_name_get(C.uint32_t(dev), &buf[0], C.size_t(len(buf))))
+	if err != nil {
+		return "", err
+	}
+	return C.GoString(&buf[0]), nil
+}
+
+func (rsmi) pciID(dev uint32) (uint64, error) {
+	var id C.uint64_t
+	err := check(C.rsmi_dev_pci_id_get(C.uint32_t(dev), &id))
+	return uint64(id), err
+}
+
+func (rsmi) busyPercent(dev uint32) (uint32, error) {
+	var busy C.uint32_t
+	err := check(C.rsmi_dev_busy_percent_get(C.uint32_t(dev), &busy))
+	return uint32(busy), err
+}
+
+func (rsmi) memoryBusyPercent(dev uint32) (uint32, error) {
+	var busy C.uint32_t
+	err := check(C.rsmi_dev_memory_busy_percent_get(C.uint32_t(dev), &busy))
+	return uint32(busy), err
+}
+
+func (rsmi) memoryTotal(dev uint32) (uint64, error) {
+	var total C.uint64_t
+	err := check(C.rsmi_dev_memory_total_get(C.uint32_t(dev),
+		C.RSMI_MEM_TYPE_VRAM, &total))
+	return uint64(total), err
+}
+
+func (rsmi) memoryUsage(dev uint32) (uint64, error) {
+	var used C.uint64_t
+	err := check(C.rsmi_dev_memory_usage_get(C.uint32_t(dev),
+		C.RSMI_MEM_TYPE_VRAM, &used))
+	return uint64(used), err
+}
+
+func (rsmi) temperature(dev uint32, sensor int) (int64, error) {
+	sensors := map[int]C.uint32_t{
+		tempEdge:     C.RSMI_TEMP_TYPE_EDGE,
+		tempJunction: C.RSMI_TEMP_TYPE_JUNCTION,
+		tempMemory:   C.RSMI_TEMP_TYPE_MEMORY,
+	}
+	var t C.int64_t
+	err := check(C.rsmi_dev_temp_metric_get(C.uint32_t(dev), sensors[sensor],
+		C.RSMI_TEMP_CURRENT, &t))
+	return int64(t), err
+}
+
+func (rsmi) powerAverage(dev uint32) (uint64, error) {
+	var power C.uint64_t
+	err := check(C.rsmi_dev_power_ave_get(C.uint32_t(dev), 0, &power))
+	return uint64(power), err
+}
+
+func (rsmi) powerCap(dev uint32) (uint64, error) {
+	var power C.uint64_t
+	err := check(C.rsmi_dev_power_cap_get(C.uint32_t(dev), 0, &power))
+	return uint64(power), err
+}
+
+func (rsmi) xgmi(dev uint32) (xgmiMetrics, error) {
+	var metrics C.rsmi_gpu_metrics_t
+	err := check(C.rsmi_dev_gpu_metrics_info_get(C.uint32_t(dev), &metrics))
+	if err != nil {
+		return xgmiMetrics{}, err
+	}
+	// fields that are not reported have all bits of their width set
+	word := func(v C.uint16_t) uint64 {
+		if v == 0xffff {
+			return ^uint64(0)
+		}
+		return uint64(v)
+	}
+	x := xgmiMetrics{
+		linkWidth: word(metrics.xgmi_link_width),
+		linkSpeed: word(metrics.xgmi_link_speed),
+	}
+	for link := 0; link < C.RSMI_MAX_NUM_XGMI_LINKS; link++ {
+		x.read = append(x.read, uint64(metrics.xgmi_read_data_acc[link]))
+		x.write = append(x.write, uint64(metrics.xgmi_write_data_acc[link]))
+	}
+	return x, nil
+}
+
+func (rsmi) processes() ([]uint32, error) {
+	var n C.uint32_t
+	if err := check(C.rsmi_compute_process_info_get(nil, &n)); err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	// the processes started since they were counted are left out
+	procs := make([]C.rsmi_process_info_t, n)
+	status := C.rsmi_compute_process_info_get(&procs[0], &n)
+	if status != C.RSMI_STATUS_INSUFFICIENT_SIZE {
+		if err := check(status); err != nil {
+			return nil, err
+		}
+	}
+	if int(n) > len(procs) {
+		n = C.uint32_t(len(procs))
+	}
+	var pids []uint32
+	for _, proc := range procs[:n] {
+		pids = append(pids, uint32(proc.process_id))
+	}
+	return pids, nil
+}
+
+func (rsmi) processDevices(pid uint32) ([]uint32, error) {
+	var n C.uint32_t
+	err := check(C.rsmi_compute_process_gpus_get(C.uint32_t(pid), nil, &n))
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	devs := make([]C.uint32_t, n)
+	status := C.rsmi_compute_process_gpus_get(C.uint32_t(pid), &devs[0], &n)
+	if status != C.RSMI_STATUS_INSUFFICIENT_SIZE {
+		if err := check(status); err != nil {
+			return nil, err
+		}
+	}
+	if int(n) > len(devs) {
+		n = C.uint32_t(len(devs))
+	}
+	var devices []uint32
+	for _, dev := range devs[:n] {
+		devices = append(devices, uint32(dev))
+	}
+	return devices, nil
+}
+
+func (rsmi) processInfo(pid, dev uint32) (processInfo, error) {
+	var proc C.rsmi_process_info_t
+	err := check(C.rsmi_compute_process_info_by_device_get(C.uint32_t(pid),
+		C.uint32_t(dev), &proc))
+	return processInfo{
+		vramUsage:   uint64(proc.vram_usage),
+		sdmaUsage:   uint64(proc.sdma_usage),
+		cuOccupancy: uint64(proc.cu_occupancy),
+	}, err
+}
+
+func init() {
+	inputs.Add("rocm_smi", func() telegraf.Input {
+		return &RocmSmi{
+			GatherProcesses: true,
+			GatherXgmi:      true,
+			lib:             rsmi{},
+		}
+	})
+}
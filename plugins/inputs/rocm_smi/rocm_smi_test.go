@@ -0,0 +1,235 @@
+package rocm_smi
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/testutil"
+)
+
+// fakeDevice holds the metrics of a fake library device. Temperatures of
+// missing sensors are not supported.
+type fakeDevice struct {
+	name    string
+	pciID   uint64
+	busy    uint32
+	memBusy uint32
+	total   uint64
+	used    uint64
+	temps   map[int]int64
+	power   uint64
+	cap     uint64
+	xgmi    *xgmiMetrics
+}
+
+type fakeLibrary struct {
+	devices []fakeDevice
+	// the devices each process uses, and its usage of each
+	procs map[uint32]map[uint32]processInfo
+	err   error
+}
+
+func (f *fakeLibrary) init() error                 { return f.err }
+func (f *fakeLibrary) shutDown() error             { return nil }
+func (f *fakeLibrary) numDevices() (uint32, error) { return uint32(len(f.devices)), nil }
+
+func (f *fakeLibrary) name(dev uint32) (string, error) {
+	return f.devices[dev].name, nil
+}
+
+func (f *fakeLibrary) pciID(dev uint32) (uint64, error) {
+	return f.devices[dev].pciID, nil
+}
+
+func (f *fakeLibrary) busyPercent(dev uint32) (uint32, error) {
+	return f.devices[dev].busy, nil
+}
+
+func (f *fakeLibrary) memoryBusyPercent(dev uint32) (uint32, error) {
+	return f.devices[dev].memBusy, nil
+}
+
+func (f *fakeLibrary) memoryTotal(dev uint32) (uint64, error) {
+	return f.devices[dev].total, nil
+}
+
+func (f *fakeLibrary) memoryUsage(dev uint32) (uint64, error) {
+	return f.devices[dev].used, nil
+}
+
+func (f *fakeLibrary) temperature(dev uint32, sensor int) (int64, error) {
+	t, ok := f.devices[dev].temps[sensor]
+	if !ok {
+		return 0, errNotSupported
+	}
+	return t, nil
+}
+
+func (f *fakeLibrary) powerAverage(dev uint32) (uint64, error) {
+	return f.devices[dev].power, nil
+}
+
+func (f *fakeLibrary) powerCap(dev uint32) (uint64, error) {
+	return f.devices[dev].cap, nil
+}
+
+func (f *fakeLibrary) xgmi(dev uint32) (xgmiMetrics, error) {
+	if f.devices[dev].xgmi == nil {
+		return xgmiMetrics{}, errNotSupported
+	}
+	return *f.devices[dev].xgmi, nil
+}
+
+func (f *fakeLibrary) processes() ([]uint32, error) {
+	var pids []uint32
+	for pid := range f.procs {
+		pids = append(pids, pid)
+	}
+	// a process exited since it was listed
+	return append(pids, 999), nil
+}
+
+func (f *fakeLibrary) processDevices(pid uint32) ([]uint32, error) {
+	devs, ok := f.procs[pid]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	var devices []uint32
+	for dev := range devs {
+		devices = append(devices, dev)
+	}
+	return devices, nil
+}
+
+func (f *fakeLibrary) processInfo(pid, dev uint32) (processInfo, error) {
+	return f.procs[pid][dev], nil
+}
+
+func TestGather(t *testing.T) {
+	unset := ^uint64(0)
+	lib := &fakeLibrary{
+		devices: []fakeDevice{
+			{
+				name:    "AMD Instinct MI250X",
+				pciID:   0x0000c100,
+				busy:    87,
+				memBusy: 41,
+				total:   68702699520,
+				used:    25769803776,
+				temps: map[int]int64{
+					tempEdge:     45000,
+					tempJunction: 61000,
+					tempMemory:   58000,
+				},
+				power: 312000000,
+				cap:   560000000,
+				xgmi: &xgmiMetrics{
+					linkWidth: 16,
+					linkSpeed: 25,
+					// links 2 and up are not connected
+					read:  []uint64{1024, 2048, unset, unset},
+					write: []uint64{512, 4096, unset, unset},
+				},
+			},
+			{
+				name:    "AMD Radeon RX 7900 XTX",
+				pciID:   0x100000300,
+				busy:    3,
+				memBusy: 0,
+				total:   25753026560,
+				used:    1073741824,
+				// no junction or memory sensor
+				temps: map[int]int64{tempEdge: 38000},
+				power: 25000000,
+				cap:   327000000,
+			},
+		},
+		procs: map[uint32]map[uint32]processInfo{
+			4242: {
+				0: {vramUsage: 17179869184, sdmaUsage: 1200, cuOccupancy: 104},
+				1: {vramUsage: 536870912, sdmaUsage: 0, cuOccupancy: 2},
+			},
+		},
+	}
+	saveName := processName
+	defer func() { processName = saveName }()
+	processName = func(pid uint32) string { return "python3" }
+
+	r := &RocmSmi{GatherProcesses: true, GatherXgmi: true, lib: lib}
+	acc := &testutil.Accumulator{}
+	require.NoError(t, r.Gather(acc))
+
+	gpu0 := map[string]string{
+		"gpu":        "0",
+		"name":       "AMD Instinct MI250X",
+		"pci_bus_id": "0000:c1:00.0",
+	}
+	acc.AssertContainsTaggedFields(t, "rocm_smi",
+		map[string]interface{}{
+			"utilization_gpu":      int64(87),
+			"utilization_memory":   int64(41),
+			"memory_total":         int64(68702699520),
+			"memory_used":          int64(25769803776),
+			"memory_free":          int64(42932895744),
+			"temperature_edge":     float64(45),
+			"temperature_junction": float64(61),
+			"temperature_memory":   float64(58),
+			"power_draw":           float64(312),
+			"power_cap":            float64(560),
+		}, gpu0)
+	gpu1 := map[string]string{
+		"gpu":        "1",
+		"name":       "AMD Radeon RX 7900 XTX",
+		"pci_bus_id": "0001:03:00.0",
+	}
+	acc.AssertContainsTaggedFields(t, "rocm_smi",
+		map[string]interface{}{
+			"utilization_gpu":    int64(3),
+			"utilization_memory": int64(0),
+			"memory_total":       int64(25753026560),
+			"memory_used":        int64(1073741824),
+			"memory_free":        int64(24679284736),
+			"temperature_edge":   float64(38),
+			"power_draw":         float64(25),
+			"power_cap":          float64(327),
+		}, gpu1)
+
+	for link, fields := range []map[string]interface{}{
+		{"read_kb": int64(1024), "write_kb": int64(512)},
+		{"read_kb": int64(2048), "write_kb": int64(4096)},
+	} {
+		fields["link_width"] = int64(16)
+		fields["link_speed"] = int64(25)
+		tags := map[string]string{"link": []string{"0", "1"}[link]}
+		for k, v := range gpu0 {
+			tags[k] = v
+		}
+		acc.AssertContainsTaggedFields(t, "rocm_smi_xgmi", fields, tags)
+	}
+
+	for dev, fields := range map[string]map[string]interface{}{
+		"0": {"vram_used": int64(17179869184), "sdma_usage": int64(1200),
+			"cu_occupancy": int64(104)},
+		"1": {"vram_used": int64(536870912), "sdma_usage": int64(0),
+			"cu_occupancy": int64(2)},
+	} {
+		tags := map[string]string{"pid": "4242", "process": "python3"}
+		for k, v := range map[string]map[string]string{"0": gpu0, "1": gpu1}[dev] {
+			tags[k] = v
+		}
+		acc.AssertContainsTaggedFields(t, "rocm_smi_process", fields, tags)
+	}
+	// the links not connected and the exited process are skipped
+	assert.Len(t, acc.Metrics, 6)
+
+	r = &RocmSmi{lib: lib}
+	acc = &testutil.Accumulator{}
+	require.NoError(t, r.Gather(acc))
+	assert.Len(t, acc.Metrics, 2)
+
+	lib.err = errors.New("driver not initialized")
+	assert.Error(t, r.Gather(&testutil.Accumulator{}))
+}
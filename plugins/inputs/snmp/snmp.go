@@ -4,11 +4,14 @@ import (
 	"io/ioutil"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/cluster"
 	"github.com/influxdata/telegraf/plugins/inputs"
 
 	"github.com/soniah/gosnmp"
@@ -22,10 +25,18 @@ type Snmp struct {
 	Table             []Table
 	Subtable          []Subtable
 	SnmptranslateFile string
+	// Maximum number of requests running at a time, across all hosts.
+	// Default 32
+	MaxConcurrency int `toml:"max_concurrency"`
+	// GETBULK max-repetitions for walks. A host or bulk oid can override
+	// it. Default 32
+	MaxRepetitions uint8 `toml:"max_repetitions"`
 
 	nameToOid   map[string]string
 	initNode    Node
+	translator  *translator
 	subTableMap map[string]Subtable
+	cluster     *cluster.Cluster
 }
 
 type Host struct {
@@ -43,13 +54,15 @@ type Host struct {
 	GetOids []string
 	// Table
 	Table []HostTable
+	// GETBULK max-repetitions for walks, unless a bulk oid overrides it
+	MaxRepetitions uint8 `toml:"max_repetitions"`
 	// Oids
 	getOids  []Data
 	bulkOids []Data
 	tables   []HostTable
-	// array of processed oids
+	// processed oids
 	// to skip oid duplication
-	processedOids []string
+	processedOids *oidSet
 
 	OidInstanceMapping map[string]map[string]string
 }
@@ -122,6 +135,12 @@ var sampleConfig = `
   ## Or if you have an other MIB folder with custom MIBs
   ##   snmptranslate -M /mycustommibfolder -Tz -On -m all | sed -e 's/"//g' > oids.txt
   snmptranslate_file = "/tmp/oids.txt"
+  ## Maximum number of requests running at a time, across all hosts. Hosts
+  ## are gathered concurrently, and so are the get and bulk oids of a host.
+  # max_concurrency = 32
+  ## GETBULK max-repetitions for walks of bulk oids and tables. A host or a
+  ## bulk oid can override it.
+  # max_repetitions = 32
   [[inputs.snmp.host]]
     address = "192.168.2.2:161"
     # SNMP community
@@ -133,6 +152,8 @@ var sampleConfig = `
     timeout = 2.0 # default 2.0
     # SNMP request retries
     retries = 2 # default 2
+    # GETBULK max-repetitions, defaults to max_repetitions
+    # max_repetitions = 64
     # Which get/bulk do you want to collect for this host
     collect = ["mybulk", "sysservices", "sysdescr"]
     # Simple list of OIDs to get, in addition to "collect"
@@ -310,12 +331,24 @@ func (s *Snmp) Gather(acc telegraf.Accumulator) error {
 			}
 		}
 	}
+	if s.translator == nil {
+		s.translator = newTranslator(s.initNode)
+	}
+	maxConcurrency := s.MaxConcurrency
+	if maxConcurrency <= 0 {
+		maxConcurrency = 32
+	}
+	p := newPool(maxConcurrency)
 	// Fetching data
 	for _, host := range s.Host {
+		host := host
 		// Set default args
 		if len(host.Address) == 0 {
 			host.Address = "127.0.0.1:161"
 		}
+		if !s.cluster.Owns(host.Address) {
+			continue
+		}
 		if host.Community == "" {
 			host.Community = "public"
 		}
@@ -325,6 +358,13 @@ func (s *Snmp) Gather(acc telegraf.Accumulator) error {
 		if host.Retries <= 0 {
 			host.Retries = 2
 		}
+		if host.MaxRepetitions == 0 {
+			host.MaxRepetitions = s.MaxRepetitions
+		}
+		if host.MaxRepetitions == 0 {
+			host.MaxRepetitions = 32
+		}
+		host.processedOids = newOidSet()
 		// Prepare host
 		// Get Easy GET oids
 		for _, oidstring := range host.GetOids {
@@ -387,28 +427,82 @@ func (s *Snmp) Gather(acc telegraf.Accumulator) error {
 				}
 			}
 		}
-		// Launch Mapping
-		// TODO put this in cache on first run
-		// TODO save mapping and computed oids
-		// to do it only the first time
-		// only if len(s.OidInstanceMapping) == 0
-		if len(host.OidInstanceMapping) >= 0 {
-			if err := host.SNMPMap(acc, s.nameToOid, s.subTableMap); err != nil {
-				log.Printf("SNMP Mapping error for host '%s': %s", host.Address, err)
-				continue
-			}
-		}
-		// Launch Get requests
-		if err := host.SNMPGet(acc, s.initNode); err != nil {
-			log.Printf("SNMP Error for host '%s': %s", host.Address, err)
-		}
-		if err := host.SNMPBulk(acc, s.initNode); err != nil {
-			log.Printf("SNMP Error for host '%s': %s", host.Address, err)
-		}
+		p.Go(func() {
+			host.gather(acc, p, s.translator, s.nameToOid, s.subTableMap)
+		})
 	}
+	p.Wait()
 	return nil
 }
 
+// gather gathers the oids of the host as jobs in a pool. The mapping tables
+// are walked first, as they add oids. Then each batch of get oids and each
+// bulk oid walk runs as a separate job.
+func (h *Host) gather(
+	acc telegraf.Accumulator,
+	p *pool,
+	t *translator,
+	nameToOid map[string]string,
+	subTableMap map[string]Subtable,
+) {
+	// Launch Mapping
+	// TODO save mapping and computed oids
+	// to do it only the first time
+	if err := h.SNMPMap(acc, nameToOid, subTableMap); err != nil {
+		log.Printf("SNMP Mapping error for host '%s': %s", h.Address, err)
+		return
+	}
+
+	// Launch Get requests
+	getOids := oidsByRaw(h.getOids)
+	names := sortedOids(getOids)
+	// gosnmp.MAX_OIDS == 60
+	// TODO use gosnmp.MAX_OIDS instead of hard coded value
+	max_oids := 60
+	// limit 60 (MAX_OIDS) oids by requests
+	for i := 0; i < len(names); i = i + max_oids {
+		max_index := i + max_oids
+		if max_index > len(names) {
+			max_index = len(names)
+		}
+		batch := names[i:max_index]
+		p.Go(func() {
+			if err := h.SNMPGet(acc, t, getOids, batch); err != nil {
+				log.Printf("SNMP Error for host '%s': %s", h.Address, err)
+			}
+		})
+	}
+
+	// Launch GetBulk requests
+	bulkOids := oidsByRaw(h.bulkOids)
+	for _, oid := range sortedOids(bulkOids) {
+		oid := oid
+		p.Go(func() {
+			if err := h.SNMPBulk(acc, t, bulkOids, oid); err != nil {
+				log.Printf("SNMP Error for host '%s': %s", h.Address, err)
+			}
+		})
+	}
+}
+
+// oidsByRaw returns the oids keyed by raw oid, without duplicates.
+func oidsByRaw(oids []Data) map[string]Data {
+	oidsList := make(map[string]Data)
+	for _, oid := range oids {
+		oidsList[oid.rawOid] = oid
+	}
+	return oidsList
+}
+
+func sortedOids(oidsList map[string]Data) []string {
+	oidsNameList := make([]string, 0, len(oidsList))
+	for oid := range oidsList {
+		oidsNameList = append(oidsNameList, oid)
+	}
+	sort.Strings(oidsNameList)
+	return oidsNameList
+}
+
 func (h *Host) SNMPMap(
 	acc telegraf.Accumulator,
 	nameToOid map[string]string,
@@ -474,12 +568,10 @@ func (h *Host) SNMPMap(
 			oid_asked := table.mappingTable
 			oid_next := oid_asked
 			need_more_requests := true
-			// Set max repetition
-			maxRepetition := uint8(32)
 			// Launch requests
 			for need_more_requests {
 				// Launch request
-				result, err3 := snmpClient.GetBulk([]string{oid_next}, 0, maxRepetition)
+				result, err3 := snmpClient.GetBulk([]string{oid_next}, 0, h.MaxRepetitions)
 				if err3 != nil {
 					return err3
 				}
@@ -595,7 +687,13 @@ func (h *Host) SNMPMap(
 	return nil
 }
 
-func (h *Host) SNMPGet(acc telegraf.Accumulator, initNode Node) error {
+// SNMPGet gets a batch of at most 60 (MAX_OIDS) get oids.
+func (h *Host) SNMPGet(
+	acc telegraf.Accumulator,
+	t *translator,
+	oidsList map[string]Data,
+	batch []string,
+) error {
 	// Get snmp client
 	snmpClient, err := h.GetSNMPClient()
 	if err != nil {
@@ -603,40 +701,23 @@ func (h *Host) SNMPGet(acc telegraf.Accumulator, initNode Node) error {
 	}
 	// Deconnection
 	defer snmpClient.Conn.Close()
-	// Prepare OIDs
-	oidsList := make(map[string]Data)
-	for _, oid := range h.getOids {
-		oidsList[oid.rawOid] = oid
-	}
-	oidsNameList := make([]string, 0, len(oidsList))
-	for _, oid := range oidsList {
-		oidsNameList = append(oidsNameList, oid.rawOid)
-	}
-
-	// gosnmp.MAX_OIDS == 60
-	// TODO use gosnmp.MAX_OIDS instead of hard coded value
-	max_oids := 60
-	// limit 60 (MAX_OIDS) oids by requests
-	for i := 0; i < len(oidsList); i = i + max_oids {
-		// Launch request
-		max_index := i + max_oids
-		if i+max_oids > len(oidsList) {
-			max_index = len(oidsList)
-		}
-		result, err3 := snmpClient.Get(oidsNameList[i:max_index]) // Get() accepts up to g.MAX_OIDS
-		if err3 != nil {
-			return err3
-		}
-		// Handle response
-		_, err = h.HandleResponse(oidsList, result, acc, initNode)
-		if err != nil {
-			return err
-		}
+	// Launch request
+	result, err := snmpClient.Get(batch) // Get() accepts up to g.MAX_OIDS
+	if err != nil {
+		return err
 	}
-	return nil
+	// Handle response
+	_, err = h.HandleResponse(oidsList, result, acc, t)
+	return err
 }
 
-func (h *Host) SNMPBulk(acc telegraf.Accumulator, initNode Node) error {
+// SNMPBulk walks a bulk oid with GETBULK requests.
+func (h *Host) SNMPBulk(
+	acc telegraf.Accumulator,
+	t *translator,
+	oidsList map[string]Data,
+	oid string,
+) error {
 	// Get snmp client
 	snmpClient, err := h.GetSNMPClient()
 	if err != nil {
@@ -644,44 +725,31 @@ func (h *Host) SNMPBulk(acc telegraf.Accumulator, initNode Node) error {
 	}
 	// Deconnection
 	defer snmpClient.Conn.Close()
-	// Prepare OIDs
-	oidsList := make(map[string]Data)
-	for _, oid := range h.bulkOids {
-		oidsList[oid.rawOid] = oid
+	oid_asked := oid
+	need_more_requests := true
+	// Set max repetition
+	maxRepetition := oidsList[oid].MaxRepetition
+	if maxRepetition <= 0 {
+		maxRepetition = h.MaxRepetitions
 	}
-	oidsNameList := make([]string, 0, len(oidsList))
-	for _, oid := range oidsList {
-		oidsNameList = append(oidsNameList, oid.rawOid)
-	}
-	// TODO Trying to make requests with more than one OID
-	// to reduce the number of requests
-	for _, oid := range oidsNameList {
-		oid_asked := oid
-		need_more_requests := true
-		// Set max repetition
-		maxRepetition := oidsList[oid].MaxRepetition
-		if maxRepetition <= 0 {
-			maxRepetition = 32
+	// Launch requests
+	for need_more_requests {
+		// Launch request
+		result, err3 := snmpClient.GetBulk([]string{oid}, 0, maxRepetition)
+		if err3 != nil {
+			return err3
 		}
-		// Launch requests
-		for need_more_requests {
-			// Launch request
-			result, err3 := snmpClient.GetBulk([]string{oid}, 0, maxRepetition)
-			if err3 != nil {
-				return err3
-			}
-			// Handle response
-			last_oid, err := h.HandleResponse(oidsList, result, acc, initNode)
-			if err != nil {
-				return err
-			}
-			// Determine if we need more requests
-			if strings.HasPrefix(last_oid, oid_asked) {
-				need_more_requests = true
-				oid = last_oid
-			} else {
-				need_more_requests = false
-			}
+		// Handle response
+		last_oid, err := h.HandleResponse(oidsList, result, acc, t)
+		if err != nil {
+			return err
+		}
+		// Determine if we need more requests
+		if strings.HasPrefix(last_oid, oid_asked) && last_oid != oid {
+			need_more_requests = true
+			oid = last_oid
+		} else {
+			need_more_requests = false
 		}
 	}
 	return nil
@@ -727,90 +795,178 @@ func (h *Host) HandleResponse(
 	oids map[string]Data,
 	result *gosnmp.SnmpPacket,
 	acc telegraf.Accumulator,
-	initNode Node,
+	t *translator,
 ) (string, error) {
 	var lastOid string
 	for _, variable := range result.Variables {
 		lastOid = variable.Name
-	nextresult:
-		// Get only oid wanted
-		for oid_key, oid := range oids {
-			// Skip oids already processed
-			for _, processedOid := range h.processedOids {
-				if variable.Name == processedOid {
-					break nextresult
+		// Get only oid wanted:
+		// variable.Name is the same as oid_key
+		// OR
+		// the result is SNMP table which "." comes right after oid_key.
+		// ex: oid_key: .1.3.6.1.2.1.2.2.1.16, variable.Name: .1.3.6.1.2.1.2.2.1.16.1
+		oid_key := variable.Name
+		oid, ok := oids[oid_key]
+		for !ok {
+			i := strings.LastIndex(oid_key, ".")
+			if i <= 0 {
+				break
+			}
+			oid_key = oid_key[:i]
+			oid, ok = oids[oid_key]
+		}
+		if !ok {
+			continue
+		}
+		switch variable.Type {
+		// handle Metrics
+		case gosnmp.Boolean, gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32,
+			gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32, gosnmp.OctetString:
+			// Prepare tags
+			tags := make(map[string]string)
+			if oid.Unit != "" {
+				tags["unit"] = oid.Unit
+			}
+			// Get oidname and instance from translate file
+			oid_name, instance := t.translate(variable.Name)
+			// Set instance tag
+			// From mapping table
+			mapping, inMappingNoSubTable := h.OidInstanceMapping[oid_key]
+			if inMappingNoSubTable {
+				// filter if the instance in not in
+				// OidInstanceMapping mapping map
+				if instance_name, exists := mapping[instance]; exists {
+					tags["instance"] = instance_name
+				} else {
+					continue
 				}
+			} else if oid.Instance != "" {
+				// From config files
+				tags["instance"] = oid.Instance
+			} else if instance != "" {
+				// Using last id of the current oid, ie:
+				// with .1.3.6.1.2.1.31.1.1.1.10.3
+				// instance is 3
+				tags["instance"] = instance
 			}
-			// If variable.Name is the same as oid_key
-			// OR
-			// the result is SNMP table which "." comes right after oid_key.
-			// ex: oid_key: .1.3.6.1.2.1.2.2.1.16, variable.Name: .1.3.6.1.2.1.2.2.1.16.1
-			if variable.Name == oid_key || strings.HasPrefix(variable.Name, oid_key+".") {
-				switch variable.Type {
-				// handle Metrics
-				case gosnmp.Boolean, gosnmp.Integer, gosnmp.Counter32, gosnmp.Gauge32,
-					gosnmp.TimeTicks, gosnmp.Counter64, gosnmp.Uinteger32, gosnmp.OctetString:
-					// Prepare tags
-					tags := make(map[string]string)
-					if oid.Unit != "" {
-						tags["unit"] = oid.Unit
-					}
-					// Get name and instance
-					var oid_name string
-					var instance string
-					// Get oidname and instance from translate file
-					oid_name, instance = findnodename(initNode,
-						strings.Split(string(variable.Name[1:]), "."))
-					// Set instance tag
-					// From mapping table
-					mapping, inMappingNoSubTable := h.OidInstanceMapping[oid_key]
-					if inMappingNoSubTable {
-						// filter if the instance in not in
-						// OidInstanceMapping mapping map
-						if instance_name, exists := mapping[instance]; exists {
-							tags["instance"] = instance_name
-						} else {
-							continue
-						}
-					} else if oid.Instance != "" {
-						// From config files
-						tags["instance"] = oid.Instance
-					} else if instance != "" {
-						// Using last id of the current oid, ie:
-						// with .1.3.6.1.2.1.31.1.1.1.10.3
-						// instance is 3
-						tags["instance"] = instance
-					}
 
-					// Set name
-					var field_name string
-					if oid_name != "" {
-						// Set fieldname as oid name from translate file
-						field_name = oid_name
-					} else {
-						// Set fieldname as oid name from inputs.snmp.get section
-						// Because the result oid is equal to inputs.snmp.get section
-						field_name = oid.Name
-					}
-					tags["snmp_host"], _, _ = net.SplitHostPort(h.Address)
-					fields := make(map[string]interface{})
-					fields[string(field_name)] = variable.Value
-
-					h.processedOids = append(h.processedOids, variable.Name)
-					acc.AddFields(field_name, fields, tags)
-				case gosnmp.NoSuchObject, gosnmp.NoSuchInstance:
-					// Oid not found
-					log.Printf("[snmp input] Oid not found: %s", oid_key)
-				default:
-					// delete other data
-				}
-				break
+			// Set name
+			var field_name string
+			if oid_name != "" {
+				// Set fieldname as oid name from translate file
+				field_name = oid_name
+			} else {
+				// Set fieldname as oid name from inputs.snmp.get section
+				// Because the result oid is equal to inputs.snmp.get section
+				field_name = oid.Name
+			}
+			// Skip oids already processed
+			if !h.processedOids.add(variable.Name) {
+				continue
 			}
+			tags["snmp_host"], _, _ = net.SplitHostPort(h.Address)
+			fields := make(map[string]interface{})
+			fields[string(field_name)] = variable.Value
+
+			acc.AddFields(field_name, fields, tags)
+		case gosnmp.NoSuchObject, gosnmp.NoSuchInstance:
+			// Oid not found
+			log.Printf("[snmp input] Oid not found: %s", oid_key)
+		default:
+			// delete other data
 		}
 	}
 	return lastOid, nil
 }
 
+// oidSet is a set of oids shared by the concurrent requests of a host.
+type oidSet struct {
+	oids map[string]bool
+	sync.Mutex
+}
+
+func newOidSet() *oidSet {
+	return &oidSet{oids: make(map[string]bool)}
+}
+
+// add adds an oid to the set, returning false if it was already in it.
+func (o *oidSet) add(oid string) bool {
+	o.Lock()
+	defer o.Unlock()
+	if o.oids[oid] {
+		return false
+	}
+	o.oids[oid] = true
+	return true
+}
+
+// maxTranslations is the maximum number of cached oid translations.
+const maxTranslations = 1 << 20
+
+// translator translates variable oids to names and instances using the
+// snmptranslate file. Translations are cached across hosts, as their tables
+// mostly share the same oids.
+type translator struct {
+	node         Node
+	translations map[string][2]string
+	sync.RWMutex
+}
+
+func newTranslator(node Node) *translator {
+	return &translator{node: node, translations: make(map[string][2]string)}
+}
+
+// translate returns the name and instance of an oid, such as ifHCInOctets
+// and 3 for .1.3.6.1.2.1.31.1.1.1.6.3.
+func (t *translator) translate(oid string) (string, string) {
+	t.RLock()
+	tr, ok := t.translations[oid]
+	t.RUnlock()
+	if ok {
+		return tr[0], tr[1]
+	}
+	name, instance := findnodename(t.node, strings.Split(oid[1:], "."))
+	t.Lock()
+	if len(t.translations) >= maxTranslations {
+		t.translations = make(map[string][2]string)
+	}
+	t.translations[oid] = [2]string{name, instance}
+	t.Unlock()
+	return name, instance
+}
+
+// pool runs jobs with a limit on how many run at a time. A job may add
+// other jobs.
+type pool struct {
+	slots chan struct{}
+	wg    sync.WaitGroup
+}
+
+func newPool(size int) *pool {
+	return &pool{slots: make(chan struct{}, size)}
+}
+
+// Go runs a job once a slot is free.
+func (p *pool) Go(job func()) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		p.slots <- struct{}{}
+		defer func() { <-p.slots }()
+		job()
+	}()
+}
+
+// Wait waits for the jobs, and those they added, to be done.
+func (p *pool) Wait() {
+	p.wg.Wait()
+}
+
+// SetCluster sets the cluster the hosts are spread across.
+func (s *Snmp) SetCluster(c *cluster.Cluster) {
+	s.cluster = c
+}
+
 func init() {
 	inputs.Add("snmp", func() telegraf.Input {
 		return &Snmp{}
@@ -1,10 +1,14 @@
 package snmp
 
 import (
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/influxdata/telegraf/testutil"
 
+	"github.com/soniah/gosnmp"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -480,3 +484,96 @@ func dTestSNMPBulk2(t *testing.T) {
 		},
 	)
 }
+
+func TestHandleResponse(t *testing.T) {
+	node := Node{id: "1", subnodes: make(map[string]Node)}
+	fillnode(node, "ifHCInOctets", strings.Split("1.3.6.1.2.1.31.1.1.1.6", "."))
+	h := &Host{
+		Address:       "192.168.2.2:161",
+		processedOids: newOidSet(),
+		OidInstanceMapping: map[string]map[string]string{
+			".1.3.6.1.2.1.31.1.1.1.6": {"1": "eth0"},
+		},
+	}
+	oids := oidsByRaw([]Data{
+		{Name: "octets", rawOid: ".1.3.6.1.2.1.31.1.1.1.6"},
+		{Name: "sysuptime", Unit: "second", rawOid: ".1.3.6.1.2.1.1.3.0"},
+	})
+	result := &gosnmp.SnmpPacket{Variables: []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: 1000},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: 42},
+		// not in the mapping
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.2", Type: gosnmp.Counter64, Value: 7},
+		// not one of the oids
+		{Name: ".1.3.6.1.2.1.31.1.1.1.7.1", Type: gosnmp.Counter64, Value: 9},
+		// already processed
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: 42},
+	}}
+
+	var acc testutil.Accumulator
+	last, err := h.HandleResponse(oids, result, &acc, newTranslator(node))
+	require.NoError(t, err)
+	assert.Equal(t, ".1.3.6.1.2.1.31.1.1.1.6.1", last)
+	require.Len(t, acc.Metrics, 2)
+	acc.AssertContainsTaggedFields(t, "sysuptime",
+		map[string]interface{}{"sysuptime": 1000},
+		map[string]string{
+			"unit":      "second",
+			"snmp_host": "192.168.2.2",
+		})
+	acc.AssertContainsTaggedFields(t, "ifHCInOctets",
+		map[string]interface{}{"ifHCInOctets": 42},
+		map[string]string{
+			"instance":  "eth0",
+			"snmp_host": "192.168.2.2",
+		})
+}
+
+func TestTranslator(t *testing.T) {
+	node := Node{id: "1", subnodes: make(map[string]Node)}
+	fillnode(node, "ifHCInOctets", strings.Split("1.3.6.1.2.1.31.1.1.1.6", "."))
+	tr := newTranslator(node)
+
+	name, instance := tr.translate(".1.3.6.1.2.1.31.1.1.1.6.3")
+	assert.Equal(t, "ifHCInOctets", name)
+	assert.Equal(t, "3", instance)
+	assert.Len(t, tr.translations, 1)
+	// cached
+	name, instance = tr.translate(".1.3.6.1.2.1.31.1.1.1.6.3")
+	assert.Equal(t, "ifHCInOctets", name)
+	assert.Equal(t, "3", instance)
+	assert.Len(t, tr.translations, 1)
+
+	name, instance = tr.translate(".1.3.6.1.4.1.9.1")
+	assert.Equal(t, "", name)
+	assert.Equal(t, "", instance)
+}
+
+func TestPool(t *testing.T) {
+	p := newPool(3)
+	var lock sync.Mutex
+	var running, most, done int
+	job := func() {
+		lock.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		lock.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		lock.Lock()
+		running--
+		done++
+		lock.Unlock()
+	}
+	for i := 0; i < 5; i++ {
+		// the jobs add jobs of their own
+		p.Go(func() {
+			job()
+			p.Go(job)
+		})
+	}
+	p.Wait()
+	assert.Equal(t, 10, done)
+	assert.Equal(t, 3, most)
+}
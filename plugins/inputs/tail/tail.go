@@ -3,12 +3,15 @@ package tail
 import (
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"sync"
 
 	"github.com/hpcloud/tail"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/internal/globpath"
+	"github.com/influxdata/telegraf/internal/state"
 	"github.com/influxdata/telegraf/plugins/inputs"
 	"github.com/influxdata/telegraf/plugins/parsers"
 )
@@ -21,6 +24,8 @@ type Tail struct {
 	parser  parsers.Parser
 	wg      sync.WaitGroup
 	acc     telegraf.Accumulator
+	// state holds the offset of each tailed file
+	state *state.State
 
 	sync.Mutex
 }
@@ -84,11 +89,12 @@ func (t *Tail) Start(acc telegraf.Accumulator) error {
 			log.Printf("ERROR Glob %s failed to compile, %s", filepath, err)
 		}
 		for file, _ := range g.Match() {
+			location, offset := t.location(file, seek)
 			tailer, err := tail.TailFile(file,
 				tail.Config{
 					ReOpen:   true,
 					Follow:   true,
-					Location: &seek,
+					Location: &location,
 				})
 			if err != nil {
 				errS += err.Error() + " "
@@ -96,7 +102,7 @@ func (t *Tail) Start(acc telegraf.Accumulator) error {
 			}
 			// create a goroutine for each "tailer"
 			t.wg.Add(1)
-			go t.receiver(tailer)
+			go t.receiver(tailer, offset)
 			t.tailers = append(t.tailers, tailer)
 		}
 	}
@@ -107,23 +113,57 @@ func (t *Tail) Start(acc telegraf.Accumulator) error {
 	return nil
 }
 
+// location returns where to start tailing the file, and the offset it is at:
+// the offset saved in the state, unless the file was truncated below it, or
+// else the given default.
+func (t *Tail) location(file string, seek tail.SeekInfo) (tail.SeekInfo, int64) {
+	fi, err := os.Stat(file)
+	if err != nil {
+		return seek, 0
+	}
+	if v, ok := t.state.Get(file); ok {
+		offset, err := strconv.ParseInt(v, 10, 64)
+		if err == nil && offset <= fi.Size() {
+			return tail.SeekInfo{Offset: offset}, offset
+		}
+	}
+	if seek.Whence == 2 {
+		return seek, fi.Size()
+	}
+	return seek, 0
+}
+
 // this is launched as a goroutine to continuously watch a tailed logfile
-// for changes, parse any incoming msgs, and add to the accumulator.
-func (t *Tail) receiver(tailer *tail.Tail) {
+// for changes, parse any incoming msgs, and add to the accumulator. offset is
+// the position the file is tailed from, it is advanced by each line and saved
+// in the state.
+func (t *Tail) receiver(tailer *tail.Tail, offset int64) {
 	defer t.wg.Done()
 
 	var m telegraf.Metric
 	var err error
 	var line *tail.Line
+	var full bool
 	for line = range tailer.Lines {
 		if line.Err != nil {
 			log.Printf("ERROR tailing file %s, Error: %s\n",
 				tailer.Filename, err)
 			continue
 		}
+		offset += int64(len(line.Text)) + 1
+		err = t.state.Set(tailer.Filename, strconv.FormatInt(offset, 10))
+		if err != nil && !full {
+			log.Printf("ERROR saving offset of %s, Error: %s\n",
+				tailer.Filename, err)
+		}
+		full = err != nil
 		m, err = t.parser.ParseLine(line.Text)
 		if err == nil {
-			t.acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+			// lines of some formats, like directives of W3C logs, are not
+			// metrics
+			if m != nil {
+				t.acc.AddFields(m.Name(), m.Fields(), m.Tags(), m.Time())
+			}
 		} else {
 			log.Printf("Malformed log line in %s: [%s], Error: %s\n",
 				tailer.Filename, line.Text, err)
@@ -149,6 +189,12 @@ func (t *Tail) SetParser(parser parsers.Parser) {
 	t.parser = parser
 }
 
+// SetState sets the state the offsets of the tailed files are saved in, so
+// that tailing resumes where it stopped when telegraf is restarted.
+func (t *Tail) SetState(s *state.State) {
+	t.state = s
+}
+
 func init() {
 	inputs.Add("tail", func() telegraf.Input {
 		return NewTail()
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/influxdata/telegraf/internal/state"
 	"github.com/influxdata/telegraf/plugins/parsers"
 	"github.com/influxdata/telegraf/testutil"
 
@@ -99,3 +100,48 @@ func TestTailBadLine(t *testing.T) {
 
 	assert.Len(t, acc.Metrics, 0)
 }
+
+func TestTailResumesFromState(t *testing.T) {
+	tmpfile, err := ioutil.TempFile("", "")
+	require.NoError(t, err)
+	defer os.Remove(tmpfile.Name())
+	defer tmpfile.Close()
+	dir, err := ioutil.TempDir("", "state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	start := func(acc *testutil.Accumulator) (*Tail, *state.Store) {
+		store, err := state.Open("file://"+dir, 0)
+		require.NoError(t, err)
+		st, err := store.State("inputs.tail")
+		require.NoError(t, err)
+
+		tt := NewTail()
+		tt.FromBeginning = true
+		tt.Files = []string{tmpfile.Name()}
+		p, _ := parsers.NewInfluxParser()
+		tt.SetParser(p)
+		tt.SetState(st)
+		require.NoError(t, tt.Start(acc))
+		return tt, store
+	}
+
+	_, err = tmpfile.WriteString("cpu,line=1 usage_idle=100\ncpu,line=2 usage_idle=100\n")
+	require.NoError(t, err)
+	acc := testutil.Accumulator{}
+	tt, store := start(&acc)
+	time.Sleep(time.Millisecond * 100)
+	tt.Stop()
+	require.NoError(t, store.Close())
+	assert.Len(t, acc.Metrics, 2)
+
+	_, err = tmpfile.WriteString("cpu,line=3 usage_idle=100\n")
+	require.NoError(t, err)
+	acc = testutil.Accumulator{}
+	tt, _ = start(&acc)
+	defer tt.Stop()
+	time.Sleep(time.Millisecond * 100)
+
+	require.Len(t, acc.Metrics, 1)
+	assert.Equal(t, "3", acc.Metrics[0].Tags["line"])
+}
@@ -34,6 +34,8 @@ type TcpListener struct {
 	listener *net.TCPListener
 	// track current connections so we can close them in Stop()
 	conns map[string]*net.TCPConn
+	// pause stops the handlers from reading while paused
+	pause internal.PauseGate
 
 	parser parsers.Parser
 	acc    telegraf.Accumulator
@@ -136,6 +138,17 @@ func (t *TcpListener) Stop() {
 	log.Println("Stopped TCP listener service on ", t.ServiceAddress)
 }
 
+// Pause stops reading from the connections, so that TCP flow control slows
+// down the senders.
+func (t *TcpListener) Pause() {
+	t.pause.Pause()
+}
+
+// Resume reads from the connections again.
+func (t *TcpListener) Resume() {
+	t.pause.Resume()
+}
+
 // tcpListen listens for incoming TCP connections.
 func (t *TcpListener) tcpListen() error {
 	defer t.wg.Done()
@@ -199,6 +212,7 @@ func (t *TcpListener) handler(conn *net.TCPConn, id string) {
 		case <-t.done:
 			return
 		default:
+			t.pause.Wait(t.done)
 			if !scanner.Scan() {
 				return
 			}
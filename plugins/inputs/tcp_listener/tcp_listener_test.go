@@ -74,6 +74,36 @@ func TestConnectTCP(t *testing.T) {
 	}
 }
 
+// Test that nothing is read from the connections while paused
+func TestPauseTCP(t *testing.T) {
+	listener := TcpListener{
+		ServiceAddress:         ":8198",
+		AllowedPendingMessages: 10000,
+		MaxTCPConnections:      250,
+	}
+	listener.parser, _ = parsers.NewInfluxParser()
+
+	acc := &testutil.Accumulator{}
+	require.NoError(t, listener.Start(acc))
+	defer listener.Stop()
+
+	listener.Pause()
+	time.Sleep(time.Millisecond * 25)
+	conn, err := net.Dial("tcp", "127.0.0.1:8198")
+	require.NoError(t, err)
+
+	fmt.Fprintf(conn, testMsg)
+	time.Sleep(time.Millisecond * 15)
+	assert.Equal(t, 0, acc.NFields())
+
+	listener.Resume()
+	time.Sleep(time.Millisecond * 15)
+	acc.AssertContainsTaggedFields(t, "cpu_load_short",
+		map[string]interface{}{"value": float64(12)},
+		map[string]string{"host": "server01"},
+	)
+}
+
 // Test that MaxTCPConections is respected
 func TestConcurrentConns(t *testing.T) {
 	listener := TcpListener{
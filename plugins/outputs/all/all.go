@@ -3,11 +3,16 @@ package all
 import (
 	_ "github.com/influxdata/telegraf/plugins/outputs/amon"
 	_ "github.com/influxdata/telegraf/plugins/outputs/amqp"
+	_ "github.com/influxdata/telegraf/plugins/outputs/bigquery_storage"
+	_ "github.com/influxdata/telegraf/plugins/outputs/cassandra"
+	_ "github.com/influxdata/telegraf/plugins/outputs/clickhouse"
 	_ "github.com/influxdata/telegraf/plugins/outputs/cloudwatch"
 	_ "github.com/influxdata/telegraf/plugins/outputs/datadog"
 	_ "github.com/influxdata/telegraf/plugins/outputs/file"
 	_ "github.com/influxdata/telegraf/plugins/outputs/graphite"
 	_ "github.com/influxdata/telegraf/plugins/outputs/graylog"
+	_ "github.com/influxdata/telegraf/plugins/outputs/greptimedb"
+	_ "github.com/influxdata/telegraf/plugins/outputs/grpc"
 	_ "github.com/influxdata/telegraf/plugins/outputs/influxdb"
 	_ "github.com/influxdata/telegraf/plugins/outputs/instrumental"
 	_ "github.com/influxdata/telegraf/plugins/outputs/kafka"
@@ -17,5 +22,12 @@ import (
 	_ "github.com/influxdata/telegraf/plugins/outputs/nsq"
 	_ "github.com/influxdata/telegraf/plugins/outputs/opentsdb"
 	_ "github.com/influxdata/telegraf/plugins/outputs/prometheus_client"
+	_ "github.com/influxdata/telegraf/plugins/outputs/pulsar"
+	_ "github.com/influxdata/telegraf/plugins/outputs/questdb"
+	_ "github.com/influxdata/telegraf/plugins/outputs/redistimeseries"
 	_ "github.com/influxdata/telegraf/plugins/outputs/riemann"
+	_ "github.com/influxdata/telegraf/plugins/outputs/router"
+	_ "github.com/influxdata/telegraf/plugins/outputs/s3"
+	_ "github.com/influxdata/telegraf/plugins/outputs/tdengine"
+	_ "github.com/influxdata/telegraf/plugins/outputs/victoriametrics"
 )
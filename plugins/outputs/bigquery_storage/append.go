@@ -0,0 +1,193 @@
+package bigquery_storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// appendRowsPath is the path of the AppendRows method of the BigQueryWrite
+// gRPC service.
+const appendRowsPath = "/google.cloud.bigquery.storage.v1.BigQueryWrite/" +
+	"AppendRows"
+
+// maxRequestSize limits the serialized rows in one AppendRows request. It
+// stays below the 10 MB request limit.
+const maxRequestSize = 9 << 20
+
+// rowDescriptor returns the DescriptorProto of a row with the given
+// columns. The field number of each column is its index plus one.
+func rowDescriptor(columns []column) ([]byte, error) {
+	d := &descriptor.DescriptorProto{Name: proto.String("Row")}
+	for i, c := range columns {
+		var t descriptor.FieldDescriptorProto_Type
+		switch c.Type {
+		case typeTimestamp, typeInteger:
+			t = descriptor.FieldDescriptorProto_TYPE_INT64
+		case typeFloat:
+			t = descriptor.FieldDescriptorProto_TYPE_DOUBLE
+		case typeBoolean:
+			t = descriptor.FieldDescriptorProto_TYPE_BOOL
+		case typeString:
+			t = descriptor.FieldDescriptorProto_TYPE_STRING
+		default:
+			// columns of other types are never written
+			continue
+		}
+		d.Field = append(d.Field, &descriptor.FieldDescriptorProto{
+			Name:   proto.String(c.Name),
+			Number: proto.Int32(int32(i + 1)),
+			Label:  descriptor.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+			Type:   t.Enum(),
+		})
+	}
+	return proto.Marshal(d)
+}
+
+// appendRows appends the serialized rows to the default stream of a table.
+// It sends one AppendRows request per maxRequestSize of rows.
+func (b *BigQuery) appendRows(table string, schema []byte,
+	rows [][]byte) error {
+	stream := fmt.Sprintf("projects/%s/datasets/%s/tables/%s/streams/_default",
+		b.Project, b.Dataset, table)
+	for len(rows) > 0 {
+		n, size := 0, 0
+		for n < len(rows) && (n == 0 || size+len(rows[n]) <= maxRequestSize) {
+			size += len(rows[n])
+			n++
+		}
+
+		// AppendRowsRequest with ProtoData
+		req := &protowire.Encoder{}
+		req.String(1, stream)
+		req.Message(4, func(e *protowire.Encoder) {
+			e.Message(1, func(e *protowire.Encoder) {
+				e.Bytes(1, schema)
+			})
+			e.Message(2, func(e *protowire.Encoder) {
+				for _, row := range rows[:n] {
+					e.Bytes(1, row)
+				}
+			})
+		})
+		if err := b.call(stream, req.Buf); err != nil {
+			return err
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+// call sends a request to the AppendRows method as a gRPC message over
+// HTTP/2, and returns the error in the AppendRowsResponse.
+func (b *BigQuery) call(stream string, message []byte) error {
+	token, err := b.tokens.Token()
+	if err != nil {
+		return err
+	}
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	req, err := http.NewRequest("POST", b.storageURL+appendRowsPath,
+		bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("Authorization", "Bearer "+token)
+	// route the request to the region of the table
+	req.Header.Set("x-goog-request-params", "write_stream="+
+		url.QueryEscape(stream))
+	resp, err := b.grpcClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	status, msg := resp.Trailer.Get("Grpc-Status"),
+		resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, msg = resp.Header.Get("Grpc-Status"),
+			resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		// the message is percent-encoded, and a plus sign is not a space
+		if m, err := url.QueryUnescape(
+			strings.Replace(msg, "+", "%2B", -1)); err == nil {
+			msg = m
+		}
+		return fmt.Errorf("%s (gRPC status %s)", msg, status)
+	}
+
+	for len(body) >= 5 {
+		n := int(binary.BigEndian.Uint32(body[1:5]))
+		if len(body) < 5+n {
+			break
+		}
+		if err := checkAppendRowsResponse(body[5 : 5+n]); err != nil {
+			return err
+		}
+		body = body[5+n:]
+	}
+	if len(body) != 0 {
+		return fmt.Errorf("invalid gRPC response")
+	}
+	return nil
+}
+
+// checkAppendRowsResponse returns the error of an AppendRowsResponse, or its
+// first row error.
+func checkAppendRowsResponse(message []byte) error {
+	response, err := protowire.Fields(message)
+	if err != nil {
+		return fmt.Errorf("invalid response: %s", err)
+	}
+	for _, status := range response[2] {
+		status, err := protowire.Fields(status.([]byte))
+		if err != nil {
+			return fmt.Errorf("invalid response: %s", err)
+		}
+		var code uint64
+		var msg string
+		if len(status[1]) != 0 {
+			code = status[1][0].(uint64)
+		}
+		if len(status[2]) != 0 {
+			msg = string(status[2][0].([]byte))
+		}
+		return fmt.Errorf("%s (code %d)", msg, code)
+	}
+	for _, rowError := range response[4] {
+		rowError, err := protowire.Fields(rowError.([]byte))
+		if err != nil {
+			return fmt.Errorf("invalid response: %s", err)
+		}
+		var index uint64
+		var msg string
+		if len(rowError[1]) != 0 {
+			index = rowError[1][0].(uint64)
+		}
+		if len(rowError[3]) != 0 {
+			msg = string(rowError[3][0].([]byte))
+		}
+		return fmt.Errorf("row %d: %s", index, msg)
+	}
+	return nil
+}
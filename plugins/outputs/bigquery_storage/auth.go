@@ -0,0 +1,179 @@
+package bigquery_storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// scope is the OAuth2 scope of the BigQuery APIs.
+const scope = "https://www.googleapis.com/auth/bigquery"
+
+// metadataTokenURL is the token endpoint of the default service account of
+// the metadata server of Google Compute Engine.
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/" +
+	"instance/service-accounts/default/token"
+
+// tokenSource returns access tokens for a service account. With a key file
+// it exchanges a signed JWT, and without one it asks the metadata server.
+// Tokens are cached until a minute before they expire.
+type tokenSource struct {
+	client *http.Client
+
+	// fields from the service account key file
+	email    string
+	keyID    string
+	key      *rsa.PrivateKey
+	tokenURI string
+
+	token  string
+	expiry time.Time
+}
+
+// serviceAccount is the JSON key file of a service account.
+type serviceAccount struct {
+	Type         string `json:"type"`
+	ClientEmail  string `json:"client_email"`
+	PrivateKey   string `json:"private_key"`
+	TokenURI     string `json:"token_uri"`
+	PrivateKeyID string `json:"private_key_id"`
+}
+
+func newTokenSource(client *http.Client, credentialsFile string) (*tokenSource,
+	error) {
+	ts := &tokenSource{client: client}
+	if credentialsFile == "" {
+		return ts, nil
+	}
+	data, err := ioutil.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	var account serviceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %s",
+			credentialsFile, err)
+	}
+	if account.Type != "service_account" {
+		return nil, fmt.Errorf("invalid credentials file %s: type %q is not "+
+			"service_account", credentialsFile, account.Type)
+	}
+	block, _ := pem.Decode([]byte(account.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid credentials file %s: no private key",
+			credentialsFile)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("invalid credentials file %s: %s",
+				credentialsFile, err)
+		}
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("invalid credentials file %s: the private key "+
+			"is not of RSA", credentialsFile)
+	}
+	ts.email, ts.keyID, ts.key = account.ClientEmail, account.PrivateKeyID,
+		rsaKey
+	ts.tokenURI = account.TokenURI
+	if ts.tokenURI == "" {
+		ts.tokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return ts, nil
+}
+
+// Token returns the cached access token, or fetches a new one.
+func (ts *tokenSource) Token() (string, error) {
+	if ts.token != "" && time.Now().Before(ts.expiry) {
+		return ts.token, nil
+	}
+	var req *http.Request
+	var err error
+	if ts.key != nil {
+		assertion, err := ts.assertion()
+		if err != nil {
+			return "", err
+		}
+		form := url.Values{
+			"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+			"assertion":  {assertion},
+		}
+		req, err = http.NewRequest("POST", ts.tokenURI,
+			strings.NewReader(form.Encode()))
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		if req, err = http.NewRequest("GET", metadataTokenURL, nil); err != nil {
+			return "", err
+		}
+		req.Header.Set("Metadata-Flavor", "Google")
+	}
+
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting token failed: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting token failed, %s: %s", resp.Status,
+			strings.TrimSpace(string(body)))
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("invalid token response: %s", err)
+	}
+	ts.token = token.AccessToken
+	ts.expiry = time.Now().Add(time.Duration(token.ExpiresIn-60) * time.Second)
+	return ts.token, nil
+}
+
+// assertion returns the JWT of the service account, signed by its key.
+func (ts *tokenSource) assertion() (string, error) {
+	now := time.Now().Unix()
+	header, err := json.Marshal(map[string]string{"alg": "RS256",
+		"typ": "JWT", "kid": ts.keyID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   ts.email,
+		"scope": scope,
+		"aud":   ts.tokenURI,
+		"iat":   now,
+		"exp":   now + 3600,
+	})
+	if err != nil {
+		return "", err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(header) + "." +
+		base64.RawURLEncoding.EncodeToString(claims)
+	hash := sha256.Sum256([]byte(payload))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, ts.key, crypto.SHA256,
+		hash[:])
+	if err != nil {
+		return "", err
+	}
+	return payload + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
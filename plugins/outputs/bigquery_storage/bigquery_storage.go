@@ -0,0 +1,585 @@
+package bigquery_storage
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/protowire"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+// Column types, using the legacy names of the REST API.
+const (
+	typeTimestamp = "TIMESTAMP"
+	typeString    = "STRING"
+	typeFloat     = "FLOAT"
+	typeInteger   = "INTEGER"
+	typeBoolean   = "BOOLEAN"
+)
+
+// partitionings are the valid values of Partitioning.
+var partitionings = map[string]bool{
+	"": true, "HOUR": true, "DAY": true, "MONTH": true, "YEAR": true,
+}
+
+var (
+	errNotFound      = errors.New("not found")
+	errAlreadyExists = errors.New("already exists")
+)
+
+type BigQuery struct {
+	Project string
+	Dataset string
+	// CredentialsFile is the JSON key file of a service account. If empty,
+	// the default service account from the metadata server is used.
+	CredentialsFile string `toml:"credentials_file"`
+
+	// TimestampColumn is the name of the column for the metric time.
+	TimestampColumn string `toml:"timestamp_column"`
+	// CreateTables creates a table for each new measurement. The table is
+	// partitioned on TimestampColumn by Partitioning.
+	CreateTables bool   `toml:"create_tables"`
+	Partitioning string `toml:"partitioning"`
+	// UpdateSchema adds columns for new tags and fields to the tables.
+	UpdateSchema bool `toml:"update_schema"`
+
+	// Endpoint is the URL of the REST API. StorageEndpoint is the URL of
+	// the Storage Write API.
+	Endpoint        string `toml:"endpoint"`
+	StorageEndpoint string `toml:"storage_endpoint"`
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	client     *http.Client
+	grpcClient *http.Client
+	storageURL string
+	tokens     *tokenSource
+	// tables are the schemas of the tables known to exist
+	tables map[string]*table
+}
+
+var sampleConfig = `
+  ## Project and dataset to write to
+  project = "my-project"
+  dataset = "telegraf"
+
+  ## JSON key file of a service account. If unset, the default service
+  ## account from the Google Compute Engine metadata server is used.
+  # credentials_file = "/etc/telegraf/bigquery.json"
+
+  ## Name of the column for the metric time
+  # timestamp_column = "timestamp"
+
+  ## Create a table for each new measurement. Tables are partitioned on the
+  ## timestamp column by "HOUR", "DAY", "MONTH" or "YEAR", or not at all if "".
+  # create_tables = true
+  # partitioning = "DAY"
+
+  ## Add columns for new tags and fields to the tables
+  # update_schema = true
+
+  ## REST API and Storage Write API endpoints
+  # endpoint = "https://bigquery.googleapis.com"
+  # storage_endpoint = "https://bigquerystorage.googleapis.com"
+
+  ## Request timeout
+  # timeout = "10s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+// column is a column of a table schema.
+type column struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	Mode string `json:"mode,omitempty"`
+}
+
+// table is the schema of a table. It keeps the raw REST API fields with all
+// their properties, so that a schema patch does not drop any of them.
+type table struct {
+	columns []column
+	fields  []json.RawMessage
+	// index maps lower case column names to their index
+	index map[string]int
+}
+
+func newTable(fields []json.RawMessage) (*table, error) {
+	t := &table{fields: fields, index: make(map[string]int)}
+	for _, field := range fields {
+		var c column
+		if err := json.Unmarshal(field, &c); err != nil {
+			return nil, err
+		}
+		switch c.Type {
+		case "FLOAT64":
+			c.Type = typeFloat
+		case "INT64":
+			c.Type = typeInteger
+		case "BOOL":
+			c.Type = typeBoolean
+		}
+		t.index[strings.ToLower(c.Name)] = len(t.columns)
+		t.columns = append(t.columns, c)
+	}
+	return t, nil
+}
+
+func (b *BigQuery) Connect() error {
+	if b.Project == "" || b.Dataset == "" {
+		return fmt.Errorf("BigQuery: project and dataset must be set")
+	}
+	if sanitize(b.TimestampColumn) != b.TimestampColumn {
+		return fmt.Errorf("BigQuery: invalid timestamp_column %q, must be "+
+			"lower case letters, digits and underscores", b.TimestampColumn)
+	}
+	if !partitionings[b.Partitioning] {
+		return fmt.Errorf("BigQuery: invalid partitioning %q, must be HOUR, "+
+			"DAY, MONTH, YEAR or empty", b.Partitioning)
+	}
+	tlsConfig, err := internal.GetTLSConfig(b.SSLCert, b.SSLKey, b.SSLCA,
+		b.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	b.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: b.Timeout.Duration,
+	}
+	if b.tokens, err = newTokenSource(b.client, b.CredentialsFile); err != nil {
+		return fmt.Errorf("BigQuery: %s", err)
+	}
+
+	u, err := url.Parse(b.StorageEndpoint)
+	if err != nil {
+		return fmt.Errorf("BigQuery: invalid storage_endpoint %q: %s",
+			b.StorageEndpoint, err)
+	}
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	switch u.Scheme {
+	case "http":
+		// HTTP/2 without TLS, with prior knowledge, as used by emulators. The
+		// transport only accepts https URLs, so the URL is rewritten and
+		// DialTLS dials a plain connection.
+		transport.DialTLS = func(network, addr string,
+			_ *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, b.Timeout.Duration)
+		}
+		u.Scheme = "https"
+	case "https":
+	default:
+		return fmt.Errorf("BigQuery: invalid storage_endpoint %q, must be "+
+			"http or https", b.StorageEndpoint)
+	}
+	b.storageURL = strings.TrimSuffix(u.String(), "/")
+	b.grpcClient = &http.Client{
+		Transport: transport,
+		Timeout:   b.Timeout.Duration,
+	}
+	b.tables = make(map[string]*table)
+	return nil
+}
+
+func (b *BigQuery) Close() error {
+	if b.grpcClient != nil {
+		b.grpcClient.Transport.(*http2.Transport).CloseIdleConnections()
+	}
+	return nil
+}
+
+func (b *BigQuery) Description() string {
+	return "Configuration for Google BigQuery to send metrics to"
+}
+
+func (b *BigQuery) SampleConfig() string {
+	return sampleConfig
+}
+
+// Write appends one row per metric to the table of its measurement, using
+// the default stream of the Storage Write API.
+func (b *BigQuery) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	var names []string
+	tables := make(map[string][]telegraf.Metric)
+	for _, metric := range metrics {
+		name := sanitizeTable(metric.Name())
+		if _, ok := tables[name]; !ok {
+			names = append(names, name)
+		}
+		tables[name] = append(tables[name], metric)
+	}
+	for _, name := range names {
+		if err := b.writeTable(name, tables[name]); err != nil {
+			return fmt.Errorf("BigQuery: writing table %s failed: %s", name,
+				err)
+		}
+	}
+	return nil
+}
+
+// values returns the column values of a metric. Fields named like a tag or
+// the timestamp column are dropped.
+func (b *BigQuery) values(metric telegraf.Metric) map[string]interface{} {
+	values := make(map[string]interface{})
+	for k, v := range metric.Tags() {
+		if k = sanitize(k); k != b.TimestampColumn {
+			values[k] = v
+		}
+	}
+	for k, v := range metric.Fields() {
+		k = sanitize(k)
+		if _, ok := values[k]; ok || k == b.TimestampColumn {
+			continue
+		}
+		values[k] = v
+	}
+	return values
+}
+
+func (b *BigQuery) writeTable(name string, metrics []telegraf.Metric) error {
+	// columns are typed by their first value
+	rows := make([]map[string]interface{}, len(metrics))
+	types := make(map[string]string)
+	for i, metric := range metrics {
+		rows[i] = b.values(metric)
+		for k, v := range rows[i] {
+			if _, ok := types[k]; ok {
+				continue
+			}
+			if t := typeOf(v); t != "" {
+				types[k] = t
+			}
+		}
+	}
+	names := make([]string, 0, len(types))
+	for k := range types {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	columns := []column{{b.TimestampColumn, typeTimestamp, "REQUIRED"}}
+	for _, k := range names {
+		columns = append(columns, column{k, types[k], "NULLABLE"})
+	}
+
+	t, err := b.table(name, columns)
+	if err != nil {
+		return err
+	}
+	schema, err := rowDescriptor(t.columns)
+	if err != nil {
+		return err
+	}
+	serialized := make([][]byte, len(metrics))
+	for i, metric := range metrics {
+		e := &protowire.Encoder{}
+		for j, c := range t.columns {
+			if strings.ToLower(c.Name) == b.TimestampColumn {
+				if c.Type == typeTimestamp {
+					e.Varint(j+1, uint64(metric.UnixNano()/1e3))
+				}
+				continue
+			}
+			if v, ok := rows[i][strings.ToLower(c.Name)]; ok {
+				value(e, j+1, c.Type, v)
+			}
+		}
+		serialized[i] = e.Buf
+	}
+	return b.appendRows(name, schema, serialized)
+}
+
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return typeFloat
+	case int64, uint64:
+		return typeInteger
+	case bool:
+		return typeBoolean
+	case string:
+		return typeString
+	}
+	return ""
+}
+
+// value writes a value of the column type. Integers are converted to floats
+// for float columns, and other values that do not match the column type are
+// left null.
+func value(e *protowire.Encoder, field int, columnType string, v interface{}) {
+	switch columnType {
+	case typeFloat:
+		switch v := v.(type) {
+		case float64:
+			e.Double(field, v)
+		case int64:
+			e.Double(field, float64(v))
+		case uint64:
+			e.Double(field, float64(v))
+		}
+	case typeInteger:
+		switch v := v.(type) {
+		case int64:
+			e.Varint(field, uint64(v))
+		case uint64:
+			if v <= math.MaxInt64 {
+				e.Varint(field, v)
+			}
+		}
+	case typeBoolean:
+		if v, ok := v.(bool); ok {
+			b := uint64(0)
+			if v {
+				b = 1
+			}
+			e.Varint(field, b)
+		}
+	case typeString:
+		if v, ok := v.(string); ok {
+			e.String(field, v)
+		}
+	}
+}
+
+// table returns the schema of a table. It creates a missing table, and adds
+// the given columns the table does not have yet.
+func (b *BigQuery) table(name string, columns []column) (*table, error) {
+	t, ok := b.tables[name]
+	if !ok {
+		var err error
+		t, err = b.getTable(name)
+		if err == errNotFound {
+			if !b.CreateTables {
+				return nil, fmt.Errorf("table does not exist")
+			}
+			t, err = b.createTable(name, columns)
+		}
+		if err != nil {
+			return nil, err
+		}
+		b.tables[name] = t
+	}
+	if !b.UpdateSchema {
+		return t, nil
+	}
+
+	var missing []column
+	for _, c := range columns {
+		if _, ok := t.index[c.Name]; !ok {
+			c.Mode = "NULLABLE"
+			missing = append(missing, c)
+		}
+	}
+	if len(missing) == 0 {
+		return t, nil
+	}
+	fields := append([]json.RawMessage{}, t.fields...)
+	for _, c := range missing {
+		field, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, field)
+	}
+	t, err := b.patchTable(name, fields)
+	if err != nil {
+		return nil, err
+	}
+	b.tables[name] = t
+	return t, nil
+}
+
+// tableResource is the table resource of the REST API.
+type tableResource struct {
+	TableReference *tableReference `json:"tableReference,omitempty"`
+	Schema         struct {
+		Fields []json.RawMessage `json:"fields"`
+	} `json:"schema"`
+	TimePartitioning *timePartitioning `json:"timePartitioning,omitempty"`
+}
+
+type tableReference struct {
+	ProjectID string `json:"projectId"`
+	DatasetID string `json:"datasetId"`
+	TableID   string `json:"tableId"`
+}
+
+type timePartitioning struct {
+	Type  string `json:"type"`
+	Field string `json:"field"`
+}
+
+func (b *BigQuery) tablesPath() string {
+	return fmt.Sprintf("/bigquery/v2/projects/%s/datasets/%s/tables",
+		(&url.URL{Path: b.Project}).EscapedPath(),
+		(&url.URL{Path: b.Dataset}).EscapedPath())
+}
+
+func (b *BigQuery) getTable(name string) (*table, error) {
+	var resource tableResource
+	if err := b.do("GET", b.tablesPath()+"/"+name, nil,
+		&resource); err != nil {
+		return nil, err
+	}
+	return newTable(resource.Schema.Fields)
+}
+
+func (b *BigQuery) createTable(name string, columns []column) (*table,
+	error) {
+	resource := &tableResource{
+		TableReference: &tableReference{b.Project, b.Dataset, name},
+	}
+	for _, c := range columns {
+		field, err := json.Marshal(c)
+		if err != nil {
+			return nil, err
+		}
+		resource.Schema.Fields = append(resource.Schema.Fields, field)
+	}
+	if b.Partitioning != "" {
+		resource.TimePartitioning = &timePartitioning{b.Partitioning,
+			b.TimestampColumn}
+	}
+	var created tableResource
+	err := b.do("POST", b.tablesPath(), resource, &created)
+	if err == errAlreadyExists {
+		// the table was created by another writer
+		return b.getTable(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newTable(created.Schema.Fields)
+}
+
+func (b *BigQuery) patchTable(name string, fields []json.RawMessage) (*table,
+	error) {
+	var resource, patched tableResource
+	resource.Schema.Fields = fields
+	if err := b.do("PATCH", b.tablesPath()+"/"+name, &resource,
+		&patched); err != nil {
+		return nil, err
+	}
+	return newTable(patched.Schema.Fields)
+}
+
+// do sends a REST API request, encoding in and decoding out as JSON.
+func (b *BigQuery) do(method, path string, in, out interface{}) error {
+	var body []byte
+	if in != nil {
+		var err error
+		if body, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequest(method,
+		strings.TrimSuffix(b.Endpoint, "/")+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	token, err := b.tokens.Token()
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return json.Unmarshal(data, out)
+	case http.StatusNotFound:
+		return errNotFound
+	case http.StatusConflict:
+		return errAlreadyExists
+	}
+	var e struct {
+		Error struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(data, &e) == nil && e.Error.Message != "" {
+		return fmt.Errorf("%s %s failed, %s: %s", method, path, resp.Status,
+			e.Error.Message)
+	}
+	return fmt.Errorf("%s %s failed, %s", method, path, resp.Status)
+}
+
+// sanitize returns a valid BigQuery column name, which is also a valid
+// field name in the row descriptor. It contains lower case letters, digits
+// and underscores, and does not start with a digit.
+func sanitize(name string) string {
+	name = strings.ToLower(sanitizeTable(name))
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+	return name
+}
+
+// sanitizeTable returns a valid BigQuery table name, which contains only
+// letters, digits and underscores.
+func sanitizeTable(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' ||
+			r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func init() {
+	outputs.Add("bigquery_storage", func() telegraf.Output {
+		return &BigQuery{
+			TimestampColumn: "timestamp",
+			CreateTables:    true,
+			Partitioning:    "DAY",
+			UpdateSchema:    true,
+			Endpoint:        "https://bigquery.googleapis.com",
+			StorageEndpoint: "https://bigquerystorage.googleapis.com",
+			Timeout:         internal.Duration{Duration: 10 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,399 @@
+package bigquery_storage
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// message is a decoded protobuf message, the values of its fields by number.
+type message map[uint64][]interface{}
+
+func decode(t *testing.T, b []byte) message {
+	m, err := protowire.Fields(b)
+	require.NoError(t, err)
+	return message(m)
+}
+
+func (m message) message(t *testing.T, field uint64, i int) message {
+	require.True(t, len(m[field]) > i, "field %d", field)
+	return decode(t, m[field][i].([]byte))
+}
+
+// appended is an AppendRowsRequest of the fake Storage Write API.
+type appended struct {
+	stream string
+	schema *descriptor.DescriptorProto
+	rows   []message
+}
+
+// fakeBigQuery is the REST API, the token endpoint and the Storage Write
+// API of BigQuery.
+type fakeBigQuery struct {
+	t    *testing.T
+	key  *rsa.PrivateKey
+	rest *httptest.Server
+	grpc *httptest.Server
+
+	mu       sync.Mutex
+	tables   map[string]json.RawMessage
+	requests []string
+	appended []appended
+	// rowError, if set, is returned as a row error by every append
+	rowError string
+}
+
+func newFakeBigQuery(t *testing.T) *fakeBigQuery {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	f := &fakeBigQuery{t: t, key: key,
+		tables: make(map[string]json.RawMessage)}
+	f.rest = httptest.NewServer(http.HandlerFunc(f.serveREST))
+	f.grpc = newTLSServer(t, http.HandlerFunc(f.serveGRPC))
+	return f
+}
+
+// newTLSServer returns a server of a handler over HTTP/2 and TLS.
+func newTLSServer(t *testing.T, handler http.Handler) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	require.NoError(t, http2.ConfigureServer(ts.Config, &http2.Server{}))
+	ts.TLS = &tls.Config{NextProtos: []string{http2.NextProtoTLS}}
+	ts.StartTLS()
+	return ts
+}
+
+// newH2CServer serves a handler over HTTP/2 without TLS, with prior
+// knowledge, as the emulators do on http URLs. It returns the URL of the
+// server and a function closing it.
+func newH2CServer(t *testing.T, handler http.Handler) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn,
+				&http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { l.Close() }
+}
+
+func (f *fakeBigQuery) Close() {
+	f.rest.Close()
+	f.grpc.Close()
+}
+
+// credentials writes a service account key file that uses the token
+// endpoint of the fake.
+func (f *fakeBigQuery) credentials() string {
+	der, err := x509.MarshalPKCS8PrivateKey(f.key)
+	require.NoError(f.t, err)
+	data, err := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "telegraf@my-project.iam.gserviceaccount.com",
+		"private_key_id": "key1",
+		"private_key": string(pem.EncodeToMemory(&pem.Block{
+			Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri": f.rest.URL + "/token",
+	})
+	require.NoError(f.t, err)
+	file, err := ioutil.TempFile("", "bigquery")
+	require.NoError(f.t, err)
+	file.Write(data)
+	file.Close()
+	return file.Name()
+}
+
+func (f *fakeBigQuery) serveREST(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := ioutil.ReadAll(r.Body)
+	if r.URL.Path == "/token" {
+		form, err := url.ParseQuery(string(body))
+		require.NoError(f.t, err)
+		parts := strings.Split(form.Get("assertion"), ".")
+		require.Len(f.t, parts, 3)
+		signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+		require.NoError(f.t, err)
+		hash := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		assert.NoError(f.t, rsa.VerifyPKCS1v15(&f.key.PublicKey,
+			crypto.SHA256, hash[:], signature))
+		w.Write([]byte(`{"access_token":"token","expires_in":3600}`))
+		return
+	}
+	assert.Equal(f.t, "Bearer token", r.Header.Get("Authorization"))
+	f.requests = append(f.requests, r.Method+" "+r.URL.Path+" "+string(body))
+
+	const prefix = "/bigquery/v2/projects/my-project/datasets/telegraf/tables"
+	name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/")
+	switch r.Method {
+	case "GET":
+		table, ok := f.tables[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"message":"Not found: Table"}}`))
+			return
+		}
+		w.Write(table)
+	case "POST":
+		var table struct {
+			TableReference struct {
+				TableID string `json:"tableId"`
+			} `json:"tableReference"`
+		}
+		require.NoError(f.t, json.Unmarshal(body, &table))
+		f.tables[table.TableReference.TableID] = body
+		w.Write(body)
+	case "PATCH":
+		f.tables[name] = body
+		w.Write(body)
+	}
+}
+
+func (f *fakeBigQuery) serveGRPC(w http.ResponseWriter, r *http.Request) {
+	assert.Equal(f.t, appendRowsPath, r.URL.Path)
+	assert.Equal(f.t, "Bearer token", r.Header.Get("Authorization"))
+	body, err := ioutil.ReadAll(r.Body)
+	require.NoError(f.t, err)
+	require.True(f.t, len(body) >= 5)
+	req := decode(f.t, body[5:])
+
+	a := appended{stream: string(req[1][0].([]byte))}
+	assert.Equal(f.t, "write_stream="+strings.Replace(a.stream, "/", "%2F",
+		-1), r.Header.Get("x-goog-request-params"))
+	data := req.message(f.t, 4, 0)
+	a.schema = &descriptor.DescriptorProto{}
+	require.NoError(f.t, proto.Unmarshal(
+		data.message(f.t, 1, 0)[1][0].([]byte), a.schema))
+	rows := data.message(f.t, 2, 0)
+	for i := range rows[1] {
+		a.rows = append(a.rows, rows.message(f.t, 1, i))
+	}
+	f.mu.Lock()
+	f.appended = append(f.appended, a)
+	rowError := f.rowError
+	f.mu.Unlock()
+
+	// AppendRowsResponse with an AppendResult, or with a row error
+	resp := &protowire.Encoder{}
+	if rowError != "" {
+		resp.Message(4, func(e *protowire.Encoder) {
+			e.Varint(1, 0)
+			e.Varint(2, 1)
+			e.String(3, rowError)
+		})
+	} else {
+		resp.Message(1, func(e *protowire.Encoder) {})
+	}
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Trailer", "Grpc-Status")
+	frame := make([]byte, 5)
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(resp.Buf)))
+	w.Write(append(frame, resp.Buf...))
+	w.Header().Set("Grpc-Status", "0")
+}
+
+func newBigQuery(f *fakeBigQuery, credentials string) *BigQuery {
+	return &BigQuery{
+		Project:         "my-project",
+		Dataset:         "telegraf",
+		CredentialsFile: credentials,
+		TimestampColumn: "timestamp",
+		CreateTables:    true,
+		Partitioning:    "DAY",
+		UpdateSchema:    true,
+		Endpoint:        f.rest.URL,
+		StorageEndpoint: f.grpc.URL,
+		Timeout:         internal.Duration{Duration: 5 * time.Second},
+
+		InsecureSkipVerify: true,
+	}
+}
+
+func TestWrite(t *testing.T) {
+	f := newFakeBigQuery(t)
+	defer f.Close()
+	credentials := f.credentials()
+	defer os.Remove(credentials)
+
+	b := newBigQuery(f, credentials)
+	require.NoError(t, b.Connect())
+	defer b.Close()
+
+	m1, err := telegraf.NewMetric("cpu.usage",
+		map[string]string{"Host": "web1"},
+		map[string]interface{}{"idle": 91.5, "count": int64(3)},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	m2, err := telegraf.NewMetric("cpu.usage",
+		map[string]string{"Host": "web2"},
+		map[string]interface{}{"idle": int64(90), "count": 2.5},
+		time.Unix(1465839840, 0))
+	require.NoError(t, err)
+	require.NoError(t, b.Write([]telegraf.Metric{m1, m2}))
+
+	// the table is created with columns typed by the first values
+	f.mu.Lock()
+	require.Len(t, f.requests, 2)
+	assert.Equal(t, "GET /bigquery/v2/projects/my-project/datasets/"+
+		"telegraf/tables/cpu_usage ", f.requests[0])
+	assert.Equal(t, `POST /bigquery/v2/projects/my-project/datasets/`+
+		`telegraf/tables {"tableReference":{"projectId":"my-project",`+
+		`"datasetId":"telegraf","tableId":"cpu_usage"},"schema":{"fields":[`+
+		`{"name":"timestamp","type":"TIMESTAMP","mode":"REQUIRED"},`+
+		`{"name":"count","type":"INTEGER","mode":"NULLABLE"},`+
+		`{"name":"host","type":"STRING","mode":"NULLABLE"},`+
+		`{"name":"idle","type":"FLOAT","mode":"NULLABLE"}]},`+
+		`"timePartitioning":{"type":"DAY","field":"timestamp"}}`,
+		f.requests[1])
+
+	require.Len(t, f.appended, 1)
+	a := f.appended[0]
+	assert.Equal(t, "projects/my-project/datasets/telegraf/tables/cpu_usage/"+
+		"streams/_default", a.stream)
+	var names []string
+	for _, field := range a.schema.Field {
+		names = append(names, field.GetName())
+		assert.Equal(t, int32(len(names)), field.GetNumber())
+	}
+	assert.Equal(t, []string{"timestamp", "count", "host", "idle"}, names)
+	assert.Equal(t, descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+		a.schema.Field[3].GetType())
+
+	require.Len(t, a.rows, 2)
+	assert.Equal(t, message{1: {uint64(1465839830123456)}, 2: {uint64(3)},
+		3: {[]byte("web1")}, 4: {math.Float64bits(91.5)}}, a.rows[0])
+	// floats in integer columns are null, integers in float columns are floats
+	assert.Equal(t, message{1: {uint64(1465839840000000)},
+		3: {[]byte("web2")}, 4: {math.Float64bits(90)}}, a.rows[1])
+	f.requests = nil
+	f.mu.Unlock()
+
+	// the columns of new fields are added to the table
+	m3, err := telegraf.NewMetric("cpu.usage",
+		map[string]string{"Host": "web1"},
+		map[string]interface{}{"idle": 80.0, "up": true},
+		time.Unix(1465839850, 0))
+	require.NoError(t, err)
+	require.NoError(t, b.Write([]telegraf.Metric{m3}))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.Len(t, f.requests, 1)
+	assert.Equal(t, `PATCH /bigquery/v2/projects/my-project/datasets/`+
+		`telegraf/tables/cpu_usage {"schema":{"fields":[`+
+		`{"name":"timestamp","type":"TIMESTAMP","mode":"REQUIRED"},`+
+		`{"name":"count","type":"INTEGER","mode":"NULLABLE"},`+
+		`{"name":"host","type":"STRING","mode":"NULLABLE"},`+
+		`{"name":"idle","type":"FLOAT","mode":"NULLABLE"},`+
+		`{"name":"up","type":"BOOLEAN","mode":"NULLABLE"}]}}`, f.requests[0])
+	require.Len(t, f.appended, 2)
+	assert.Equal(t, message{1: {uint64(1465839850000000)},
+		3: {[]byte("web1")}, 4: {math.Float64bits(80)}, 5: {uint64(1)}},
+		f.appended[1].rows[0])
+}
+
+func TestWriteRowError(t *testing.T) {
+	f := newFakeBigQuery(t)
+	defer f.Close()
+	credentials := f.credentials()
+	defer os.Remove(credentials)
+	f.rowError = "Field up: type mismatch"
+
+	b := newBigQuery(f, credentials)
+	b.CreateTables = false
+	require.NoError(t, b.Connect())
+	defer b.Close()
+
+	m, err := telegraf.NewMetric("mem", nil,
+		map[string]interface{}{"used": int64(42)}, time.Unix(1465839830, 0))
+	require.NoError(t, err)
+	err = b.Write([]telegraf.Metric{m})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "table does not exist")
+
+	f.tables["mem"] = json.RawMessage(`{"schema":{"fields":[` +
+		`{"name":"timestamp","type":"TIMESTAMP"},` +
+		`{"name":"used","type":"INT64"}]}}`)
+	err = b.Write([]telegraf.Metric{m})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "row 0: Field up: type mismatch")
+}
+
+func TestWriteH2C(t *testing.T) {
+	f := newFakeBigQuery(t)
+	defer f.Close()
+	credentials := f.credentials()
+	defer os.Remove(credentials)
+	endpoint, closeServer := newH2CServer(t, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Nil(t, r.TLS)
+			f.serveGRPC(w, r)
+		}))
+	defer closeServer()
+	f.tables["mem"] = json.RawMessage(`{"schema":{"fields":[` +
+		`{"name":"timestamp","type":"TIMESTAMP"},` +
+		`{"name":"used","type":"INT64"}]}}`)
+
+	b := newBigQuery(f, credentials)
+	b.StorageEndpoint = endpoint
+	b.CreateTables = false
+	require.NoError(t, b.Connect())
+	defer b.Close()
+
+	m, err := telegraf.NewMetric("mem", nil,
+		map[string]interface{}{"used": int64(42)}, time.Unix(1465839830, 0))
+	require.NoError(t, err)
+	require.NoError(t, b.Write([]telegraf.Metric{m}))
+	require.Len(t, f.appended, 1)
+	assert.Equal(t, message{1: {uint64(1465839830000000)}, 2: {uint64(42)}},
+		f.appended[0].rows[0])
+}
+
+func TestConnectInvalid(t *testing.T) {
+	f := newFakeBigQuery(t)
+	defer f.Close()
+
+	b := newBigQuery(f, "")
+	b.Dataset = ""
+	assert.Error(t, b.Connect())
+	b = newBigQuery(f, "")
+	b.TimestampColumn = "Time"
+	assert.Error(t, b.Connect())
+	b = newBigQuery(f, "")
+	b.Partitioning = "WEEK"
+	assert.Error(t, b.Connect())
+	b = newBigQuery(f, "/nonexistent/bigquery.json")
+	assert.Error(t, b.Connect())
+}
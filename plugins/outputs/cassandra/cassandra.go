@@ -0,0 +1,639 @@
+package cassandra
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+// Table schemas. SchemaWide writes one row per field into a single table.
+// SchemaColumns writes into one table per measurement, with one column per
+// field.
+const (
+	SchemaWide    = "wide"
+	SchemaColumns = "columns"
+)
+
+type Cassandra struct {
+	// Servers are the nodes to connect to first. The token ring is read from
+	// them.
+	Servers     []string
+	Keyspace    string
+	Schema      string
+	Table       string
+	Consistency string
+	// CreateTables creates the tables. With the columns schema, it also adds
+	// a column for each new field.
+	CreateTables bool `toml:"create_tables"`
+	// TTL is the time to live of the rows. A metric with a TTLField field
+	// uses that value instead, in seconds.
+	TTL      internal.Duration
+	TTLField string `toml:"ttl_field"`
+	// BatchSize is the most rows in a batch. A batch holds one partition.
+	BatchSize int `toml:"batch_size"`
+	// TokenAware sends each batch to the node that owns its partition.
+	TokenAware bool `toml:"token_aware"`
+
+	Username string
+	Password string
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	consistency uint16
+	tlsConfig   *tls.Config
+	conns       map[string]*cqlConn
+	// ring is read again when nil
+	ring *ring
+	// columns are the column types of the columns schema tables, by table
+	// and column
+	columns map[string]map[string]string
+	// mismatched are the fields whose type has no matching column. Each one
+	// is logged once.
+	mismatched map[string]bool
+}
+
+var sampleConfig = `
+  ## Native protocol addresses of the cluster nodes. The token ring is read
+  ## from them.
+  servers = ["localhost:9042"]
+
+  ## Keyspace of the tables. It must exist.
+  keyspace = "telegraf"
+
+  ## Table schema. "wide" writes one row per field into the table, with the
+  ## partition key (measurement, tags, day). "columns" writes into one table
+  ## per measurement, with one column per field, and the partition key
+  ## (tags, day).
+  # schema = "wide"
+  # table = "metrics"
+
+  ## Create the tables. With the columns schema, also add a column for each
+  ## new field.
+  # create_tables = true
+
+  ## Time to live of the rows. A metric with a ttl_field field uses that
+  ## value instead, in seconds. The ttl_field field is not written.
+  # ttl = "0s"
+  # ttl_field = ""
+
+  ## Consistency level of the writes
+  # consistency = "LOCAL_QUORUM"
+
+  ## Send each unlogged batch to the node that owns its partition. A batch
+  ## holds one partition, and at most batch_size rows.
+  # token_aware = true
+  # batch_size = 100
+
+  ## Credentials for the PasswordAuthenticator
+  # username = ""
+  # password = ""
+
+  ## Timeout to connect, and for each write
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+// row is a row to insert with a prepared statement, and its partition key.
+type row struct {
+	stmt   string
+	values [][]byte
+	key    []byte
+}
+
+func (c *Cassandra) Connect() error {
+	if len(c.Servers) == 0 {
+		c.Servers = []string{"localhost:9042"}
+	}
+	for i, server := range c.Servers {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			c.Servers[i] = net.JoinHostPort(server, "9042")
+		}
+	}
+	if c.Keyspace == "" {
+		return fmt.Errorf("Cassandra: keyspace must be set")
+	}
+	switch c.Schema {
+	case "":
+		c.Schema = SchemaWide
+	case SchemaWide, SchemaColumns:
+	default:
+		return fmt.Errorf("Cassandra: invalid schema %q, must be wide or "+
+			"columns", c.Schema)
+	}
+	if c.Table == "" {
+		c.Table = "metrics"
+	}
+	if c.Consistency == "" {
+		c.Consistency = "LOCAL_QUORUM"
+	}
+	consistency, ok := consistencies[strings.ToUpper(c.Consistency)]
+	if !ok {
+		return fmt.Errorf("Cassandra: invalid consistency %q", c.Consistency)
+	}
+	c.consistency = consistency
+	if c.BatchSize <= 0 {
+		c.BatchSize = 100
+	}
+	tlsConfig, err := internal.GetTLSConfig(c.SSLCert, c.SSLKey, c.SSLCA,
+		c.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	c.tlsConfig = tlsConfig
+	c.conns = make(map[string]*cqlConn)
+	c.columns = make(map[string]map[string]string)
+	c.mismatched = make(map[string]bool)
+
+	conn, _, err := c.coordinator()
+	if err != nil {
+		return err
+	}
+	if c.CreateTables && c.Schema == SchemaWide {
+		if _, err := conn.query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS "+
+			"%s.%s (measurement text, tags text, day text, field text, "+
+			"time timestamp, value double, PRIMARY KEY ((measurement, tags, "+
+			"day), field, time))", quote(c.Keyspace),
+			quote(tableName(c.Table))), c.consistency); err != nil {
+			return fmt.Errorf("Cassandra: creating table %s failed: %s",
+				c.Table, err)
+		}
+	}
+	if c.TokenAware {
+		return c.readRing()
+	}
+	return nil
+}
+
+func (c *Cassandra) Close() error {
+	for addr, conn := range c.conns {
+		conn.Close()
+		delete(c.conns, addr)
+	}
+	return nil
+}
+
+func (c *Cassandra) Description() string {
+	return "Configuration for Cassandra or ScyllaDB to send metrics to"
+}
+
+func (c *Cassandra) SampleConfig() string {
+	return sampleConfig
+}
+
+// Write inserts the rows of the metrics in unlogged batches, one partition
+// per batch. With token_aware, each batch goes to the node that owns its
+// partition. A retried write upserts the same primary keys again, so writes
+// are idempotent.
+func (c *Cassandra) Write(metrics []telegraf.Metric) error {
+	var rows []row
+	for _, metric := range metrics {
+		if c.Schema == SchemaWide {
+			rows = append(rows, c.wideRows(metric)...)
+			continue
+		}
+		r, err := c.columnsRow(metric)
+		if err != nil {
+			return err
+		}
+		if r != nil {
+			rows = append(rows, *r)
+		}
+	}
+	if len(rows) == 0 {
+		return nil
+	}
+	if c.TokenAware && c.ring == nil {
+		if err := c.readRing(); err != nil {
+			return err
+		}
+	}
+
+	// the rows by node, then by partition
+	nodes := make(map[string]map[string][]row)
+	for _, r := range rows {
+		var node string
+		if c.TokenAware {
+			node = c.ring.node(r.key)
+		}
+		if nodes[node] == nil {
+			nodes[node] = make(map[string][]row)
+		}
+		nodes[node][string(r.key)] = append(nodes[node][string(r.key)], r)
+	}
+	var addrs []string
+	for addr := range nodes {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	for _, addr := range addrs {
+		if err := c.write(addr, nodes[addr]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// write writes the partitions owned by a node. If the node is unknown or
+// cannot be reached, they are written through a coordinator instead.
+func (c *Cassandra) write(addr string, partitions map[string][]row) error {
+	var conn *cqlConn
+	var err error
+	if addr != "" {
+		if conn, err = c.conn(addr); err != nil {
+			log.Printf("Cassandra: %s, writing to a coordinator", err)
+			c.ring = nil
+		}
+	}
+	if conn == nil {
+		if conn, addr, err = c.coordinator(); err != nil {
+			return err
+		}
+	}
+
+	for _, rows := range partitions {
+		for len(rows) > 0 {
+			n := len(rows)
+			if n > c.BatchSize {
+				n = c.BatchSize
+			}
+			stmts := make([]string, n)
+			values := make([][][]byte, n)
+			for i, r := range rows[:n] {
+				stmts[i], values[i] = r.stmt, r.values
+			}
+			if err := conn.batch(stmts, values, c.consistency); err != nil {
+				if _, ok := err.(*cqlError); !ok {
+					conn.Close()
+					delete(c.conns, addr)
+					c.ring = nil
+				}
+				return fmt.Errorf("Cassandra: writing to %s failed: %s",
+					addr, err)
+			}
+			rows = rows[n:]
+		}
+	}
+	return nil
+}
+
+// wideRows returns the wide schema rows for a metric, one per numeric or
+// boolean field.
+func (c *Cassandra) wideRows(metric telegraf.Metric) []row {
+	stmt := fmt.Sprintf("INSERT INTO %s.%s (measurement, tags, day, field, "+
+		"time, value) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?",
+		quote(c.Keyspace), quote(tableName(c.Table)))
+	measurement, tags, day := []byte(metric.Name()), seriesTags(metric),
+		[]byte(metric.Time().UTC().Format("2006-01-02"))
+	key := partitionKey(measurement, tags, day)
+	fields, ttl := c.ttl(metric)
+
+	var rows []row
+	for field, v := range fields {
+		var value float64
+		switch v := v.(type) {
+		case float64:
+			value = v
+		case int64:
+			value = float64(v)
+		case uint64:
+			value = float64(v)
+		case bool:
+			if v {
+				value = 1
+			}
+		default:
+			continue
+		}
+		rows = append(rows, row{stmt: stmt, key: key, values: [][]byte{
+			measurement, tags, day, []byte(field),
+			encodeTimestamp(metric.Time()), encodeValue(value), ttl}})
+	}
+	return rows
+}
+
+// columnsRow returns the columns schema row for a metric. Fields without a
+// matching column are dropped.
+func (c *Cassandra) columnsRow(metric telegraf.Metric) (*row, error) {
+	table := tableName(metric.Name())
+	fields, ttl := c.ttl(metric)
+	if err := c.ensureColumns(table, fields); err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(fields))
+	for name, v := range fields {
+		if columnType(v) == "" {
+			continue
+		}
+		if t := c.columns[table][name]; t != columnType(v) {
+			if !c.mismatched[table+"."+name] {
+				log.Printf("Cassandra: dropping field %s of %s, it has no "+
+					"column of type %s", name, table, columnType(v))
+				c.mismatched[table+"."+name] = true
+			}
+			continue
+		}
+		names = append(names, name)
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	sort.Strings(names)
+
+	tags, day := seriesTags(metric),
+		[]byte(metric.Time().UTC().Format("2006-01-02"))
+	columns := []string{"tags", "day", "time"}
+	values := [][]byte{tags, day, encodeTimestamp(metric.Time())}
+	for _, name := range names {
+		columns = append(columns, quote(name))
+		values = append(values, encodeValue(fields[name]))
+	}
+	stmt := fmt.Sprintf("INSERT INTO %s.%s (%s) VALUES (?%s) USING TTL ?",
+		quote(c.Keyspace), quote(table), strings.Join(columns, ", "),
+		strings.Repeat(", ?", len(columns)-1))
+	return &row{stmt: stmt, key: partitionKey(tags, day),
+		values: append(values, ttl)}, nil
+}
+
+// ensureColumns reads the columns of a columns schema table, if they were
+// not read yet. With create_tables, it also creates the table, and adds a
+// column for each new field.
+func (c *Cassandra) ensureColumns(table string,
+	fields map[string]interface{}) error {
+	columns, ok := c.columns[table]
+	var missing []string
+	for name, v := range fields {
+		if _, exists := columns[name]; !exists && columnType(v) != "" &&
+			!c.mismatched[table+"."+name] {
+			missing = append(missing, name)
+		}
+	}
+	if ok && (len(missing) == 0 || !c.CreateTables) {
+		return nil
+	}
+	sort.Strings(missing)
+
+	conn, _, err := c.coordinator()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		if c.CreateTables {
+			if _, err := conn.query(fmt.Sprintf("CREATE TABLE IF NOT EXISTS "+
+				"%s.%s (tags text, day text, time timestamp, PRIMARY KEY "+
+				"((tags, day), time))", quote(c.Keyspace), quote(table)),
+				c.consistency); err != nil {
+				return fmt.Errorf("Cassandra: creating table %s failed: %s",
+					table, err)
+			}
+		}
+		rows, err := conn.query(fmt.Sprintf("SELECT column_name, type FROM "+
+			"system_schema.columns WHERE keyspace_name = '%s' AND "+
+			"table_name = '%s'", strings.Replace(c.Keyspace, "'", "''", -1),
+			table), c.consistency)
+		if err != nil {
+			return fmt.Errorf("Cassandra: reading the columns of %s "+
+				"failed: %s", table, err)
+		}
+		columns = make(map[string]string)
+		for _, r := range rows {
+			if len(r) == 2 {
+				columns[string(r[0])] = string(r[1])
+			}
+		}
+		c.columns[table] = columns
+	}
+	if !c.CreateTables {
+		return nil
+	}
+
+	for _, name := range missing {
+		if _, exists := columns[name]; exists {
+			continue
+		}
+		t := columnType(fields[name])
+		if _, err := conn.query(fmt.Sprintf("ALTER TABLE %s.%s ADD %s %s",
+			quote(c.Keyspace), quote(table), quote(name), t),
+			c.consistency); err != nil {
+			return fmt.Errorf("Cassandra: adding column %s of %s failed: %s",
+				name, table, err)
+		}
+		columns[name] = t
+	}
+	return nil
+}
+
+// ttl returns the fields of a metric without the ttl field, and the time to
+// live of its rows.
+func (c *Cassandra) ttl(metric telegraf.Metric) (map[string]interface{},
+	[]byte) {
+	fields := metric.Fields()
+	ttl := int64(c.TTL.Duration / time.Second)
+	if v, ok := fields[c.TTLField]; ok && c.TTLField != "" {
+		delete(fields, c.TTLField)
+		switch v := v.(type) {
+		case float64:
+			ttl = int64(v)
+		case int64:
+			ttl = v
+		case uint64:
+			ttl = int64(v)
+		}
+	}
+	if ttl < 0 || ttl > math.MaxInt32 {
+		ttl = 0
+	}
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], uint32(ttl))
+	return fields, b[:]
+}
+
+// conn returns the connection to a node, and connects if there is none.
+func (c *Cassandra) conn(addr string) (*cqlConn, error) {
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := dialCQL(addr, c.Username, c.Password, c.Timeout.Duration,
+		c.tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to %s failed: %s", addr, err)
+	}
+	c.conns[addr] = conn
+	return conn, nil
+}
+
+// coordinator returns a connection to the first server that can be
+// reached.
+func (c *Cassandra) coordinator() (*cqlConn, string, error) {
+	var err error
+	for _, addr := range c.Servers {
+		var conn *cqlConn
+		if conn, err = c.conn(addr); err == nil {
+			return conn, addr, nil
+		}
+	}
+	return nil, "", fmt.Errorf("Cassandra: %s", err)
+}
+
+// readRing reads the tokens of the cluster nodes from system.local and
+// system.peers on a coordinator. The peers are assumed to listen on the
+// coordinator's port.
+func (c *Cassandra) readRing() error {
+	conn, addr, err := c.coordinator()
+	if err != nil {
+		return err
+	}
+	_, port, _ := net.SplitHostPort(addr)
+	tokens := make(map[string][]string)
+	for _, table := range []string{"local", "peers"} {
+		rows, err := conn.query("SELECT rpc_address, tokens FROM system."+
+			table, c.consistency)
+		if err != nil {
+			return fmt.Errorf("Cassandra: reading the tokens of the cluster "+
+				"failed: %s", err)
+		}
+		for _, r := range rows {
+			if len(r) != 2 {
+				continue
+			}
+			node := addr
+			if table == "peers" {
+				node = net.JoinHostPort(net.IP(r[0]).String(), port)
+			}
+			tokens[node] = append(tokens[node], decodeTexts(r[1])...)
+		}
+	}
+	c.ring = newRing(tokens)
+	return nil
+}
+
+// seriesTags returns the tags of a metric, as k=v sorted by key, separated
+// by commas.
+func seriesTags(metric telegraf.Metric) []byte {
+	tags := metric.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		pairs[i] = k + "=" + tags[k]
+	}
+	return []byte(strings.Join(pairs, ","))
+}
+
+// columnType returns the CQL column type for a field value, or "" if there
+// is none.
+func columnType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return "double"
+	case int64, uint64:
+		return "bigint"
+	case bool:
+		return "boolean"
+	case string:
+		return "text"
+	}
+	return ""
+}
+
+// encodeValue encodes a field value as its columnType. Unsigned integers
+// are clamped to the largest bigint.
+func encodeValue(v interface{}) []byte {
+	var b [8]byte
+	switch v := v.(type) {
+	case float64:
+		binary.BigEndian.PutUint64(b[:], math.Float64bits(v))
+	case int64:
+		binary.BigEndian.PutUint64(b[:], uint64(v))
+	case uint64:
+		if v > math.MaxInt64 {
+			v = math.MaxInt64
+		}
+		binary.BigEndian.PutUint64(b[:], v)
+	case bool:
+		if v {
+			return []byte{1}
+		}
+		return []byte{0}
+	case string:
+		return []byte(v)
+	}
+	return b[:]
+}
+
+func encodeTimestamp(t time.Time) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(t.UnixNano()/1e6))
+	return b[:]
+}
+
+// decodeTexts decodes a set<text>.
+func decodeTexts(b []byte) []string {
+	r := &frameReader{buf: b}
+	var texts []string
+	for n := r.int(); n > 0 && r.err == nil; n-- {
+		texts = append(texts, string(r.bytes()))
+	}
+	return texts
+}
+
+// quote returns a quoted identifier of CQL.
+func quote(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// tableName replaces the characters in a table name that are not letters,
+// digits or underscores with underscores. CQL table names allow no other
+// characters.
+func tableName(name string) string {
+	b := []byte(name)
+	for i, ch := range b {
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' ||
+			ch >= '0' && ch <= '9' || ch == '_') {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
+
+func init() {
+	outputs.Add("cassandra", func() telegraf.Output {
+		return &Cassandra{
+			Schema:       SchemaWide,
+			Table:        "metrics",
+			Consistency:  "LOCAL_QUORUM",
+			CreateTables: true,
+			BatchSize:    100,
+			TokenAware:   true,
+			Timeout:      internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
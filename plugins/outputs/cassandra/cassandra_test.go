@@ -0,0 +1,376 @@
+package cassandra
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+)
+
+// batchRow is a row in a batch sent to the fake node, and its statement.
+type batchRow struct {
+	stmt   string
+	values [][]byte
+}
+
+// fakeCassandra is a native protocol v4 node. With auth, it expects the user
+// "cassandra" and the password "secret". It serves the tokens of the node
+// and its peers, and keeps the table columns that ALTER TABLE adds.
+type fakeCassandra struct {
+	net.Listener
+	auth bool
+
+	mu       sync.Mutex
+	queries  []string
+	prepared map[string]string
+	rows     []batchRow
+	tokens   []string
+	peers    map[string][]string
+	columns  map[string]map[string]string
+}
+
+func newFakeCassandra(t *testing.T, addr string) *fakeCassandra {
+	l, err := net.Listen("tcp", addr)
+	require.NoError(t, err)
+	f := &fakeCassandra{Listener: l, prepared: make(map[string]string),
+		columns: make(map[string]map[string]string)}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeCassandra) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var header [9]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[5:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		f.mu.Lock()
+		op, reply := f.handle(header[4], &frameReader{buf: body})
+		f.mu.Unlock()
+		header[0], header[4] = 0x84, op
+		binary.BigEndian.PutUint32(header[5:], uint32(len(reply)))
+		conn.Write(append(header[:], reply...))
+	}
+}
+
+func (f *fakeCassandra) handle(op byte, r *frameReader) (byte, []byte) {
+	b := &frameBuffer{}
+	switch op {
+	case opStartup:
+		if !f.auth {
+			return opReady, nil
+		}
+		b.string("org.apache.cassandra.auth.PasswordAuthenticator")
+		return opAuthenticate, b.buf
+	case opAuthResponse:
+		if string(r.bytes()) != "\x00cassandra\x00secret" {
+			return errorFrame(0x0100, "bad credentials")
+		}
+		b.bytes(nil)
+		return opAuthSuccess, b.buf
+	case opQuery:
+		return f.query(r.longString())
+	case opPrepare:
+		stmt := r.longString()
+		id := strconv.Itoa(len(f.prepared))
+		f.prepared[id] = stmt
+		b.int(resultPrepared)
+		b.shortBytes([]byte(id))
+		b.int(0)
+		b.int(0)
+		b.int(0)
+		b.int(0x0004)
+		b.int(0)
+		return opResult, b.buf
+	case opBatch:
+		r.next(1)
+		var rows []batchRow
+		for n := r.short(); n > 0; n-- {
+			r.next(1)
+			id := string(r.shortBytes())
+			stmt, ok := f.prepared[id]
+			if !ok {
+				b.int(errUnprepared)
+				b.string("Prepared query with ID " + id + " not found")
+				b.shortBytes([]byte(id))
+				return opError, b.buf
+			}
+			row := batchRow{stmt: stmt}
+			for m := r.short(); m > 0; m-- {
+				row.values = append(row.values, r.bytes())
+			}
+			rows = append(rows, row)
+		}
+		f.rows = append(f.rows, rows...)
+		b.int(1)
+		return opResult, b.buf
+	}
+	return errorFrame(0x000A, "unsupported opcode")
+}
+
+// longString reads a [long string].
+func (r *frameReader) longString() string {
+	return string(r.next(int(r.int())))
+}
+
+func errorFrame(code int32, message string) (byte, []byte) {
+	b := &frameBuffer{}
+	b.int(code)
+	b.string(message)
+	return opError, b.buf
+}
+
+func (f *fakeCassandra) query(stmt string) (byte, []byte) {
+	f.queries = append(f.queries, stmt)
+	b := &frameBuffer{}
+	switch {
+	case strings.HasPrefix(stmt, "SELECT rpc_address, tokens FROM system."):
+		rows := map[string][]string{"127.0.0.1": f.tokens}
+		if strings.HasSuffix(stmt, "peers") {
+			rows = f.peers
+		}
+		b.int(resultRows)
+		b.int(0x0001)
+		b.int(2)
+		b.string("system")
+		b.string("peers")
+		b.string("rpc_address")
+		b.short(0x0010)
+		b.string("tokens")
+		b.short(typeSet)
+		b.short(0x000D)
+		b.int(int32(len(rows)))
+		for ip, tokens := range rows {
+			b.bytes(net.ParseIP(ip).To4())
+			set := &frameBuffer{}
+			set.int(int32(len(tokens)))
+			for _, token := range tokens {
+				set.bytes([]byte(token))
+			}
+			b.bytes(set.buf)
+		}
+	case strings.HasPrefix(stmt, "SELECT column_name, type FROM "+
+		"system_schema.columns"):
+		table := stmt[strings.LastIndex(stmt, "= '")+3 : len(stmt)-1]
+		b.int(resultRows)
+		b.int(0x0004)
+		b.int(2)
+		b.int(int32(len(f.columns[table])))
+		for column, t := range f.columns[table] {
+			b.bytes([]byte(column))
+			b.bytes([]byte(t))
+		}
+	case strings.HasPrefix(stmt, "ALTER TABLE"):
+		// ALTER TABLE "ks"."table" ADD "column" type
+		parts := strings.Fields(stmt)
+		table := strings.Trim(strings.Split(parts[2], ".")[1], `"`)
+		f.columns[table][strings.Trim(parts[4], `"`)] = parts[5]
+		b.int(1)
+	case strings.HasPrefix(stmt, "CREATE TABLE"):
+		table := strings.Trim(strings.Split(strings.Fields(stmt)[5], ".")[1],
+			`"`)
+		if f.columns[table] == nil {
+			f.columns[table] = map[string]string{"tags": "text",
+				"day": "text", "time": "timestamp"}
+		}
+		b.int(1)
+	default:
+		return errorFrame(0x2000, "unsupported query")
+	}
+	return opResult, b.buf
+}
+
+// batchRows returns the rows of all the batches the node received.
+func (f *fakeCassandra) batchRows() []batchRow {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]batchRow(nil), f.rows...)
+}
+
+func testMetric(t *testing.T, host string,
+	fields map[string]interface{}) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu", map[string]string{"host": host},
+		fields, time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC))
+	require.NoError(t, err)
+	return m
+}
+
+func float64At(b []byte) float64 {
+	return math.Float64frombits(binary.BigEndian.Uint64(b))
+}
+
+func TestWriteWide(t *testing.T) {
+	a := newFakeCassandra(t, "127.0.0.1:0")
+	defer a.Close()
+	_, port, _ := net.SplitHostPort(a.Addr().String())
+	b := newFakeCassandra(t, "127.0.0.2:"+port)
+	defer b.Close()
+	for _, f := range []*fakeCassandra{a, b} {
+		f.auth = true
+		f.tokens = []string{"0"}
+		f.peers = map[string][]string{"127.0.0.2": {"9223372036854775807"}}
+	}
+	b.tokens = []string{"9223372036854775807"}
+
+	c := &Cassandra{
+		Servers:      []string{a.Addr().String()},
+		Keyspace:     "telegraf",
+		CreateTables: true,
+		TokenAware:   true,
+		TTL:          internal.Duration{Duration: time.Hour},
+		Username:     "cassandra",
+		Password:     "secret",
+	}
+	require.NoError(t, c.Connect())
+	defer c.Close()
+	assert.Equal(t, `CREATE TABLE IF NOT EXISTS "telegraf"."metrics" `+
+		"(measurement text, tags text, day text, field text, time "+
+		"timestamp, value double, PRIMARY KEY ((measurement, tags, day), "+
+		"field, time))", a.queries[0])
+
+	var metrics []telegraf.Metric
+	for _, host := range []string{"a", "b", "c", "d"} {
+		metrics = append(metrics, testMetric(t, host, map[string]interface{}{
+			"usage": 42.5, "count": int64(3), "state": "ok"}))
+	}
+	require.NoError(t, c.Write(metrics))
+
+	// each row is written to the node that owns its partition
+	assert.Len(t, a.batchRows(), 4)
+	assert.Len(t, b.batchRows(), 4)
+	for _, f := range []*fakeCassandra{a, b} {
+		for _, r := range f.batchRows() {
+			assert.Equal(t, `INSERT INTO "telegraf"."metrics" (measurement, `+
+				"tags, day, field, time, value) VALUES (?, ?, ?, ?, ?, ?) "+
+				"USING TTL ?", r.stmt)
+			require.Len(t, r.values, 7)
+			token := murmur3Token(partitionKey(r.values[:3]...))
+			assert.Equal(t, f == a, token <= 0)
+			assert.Equal(t, "cpu", string(r.values[0]))
+			assert.Equal(t, "2016-06-13", string(r.values[2]))
+			assert.Equal(t, uint64(1465839830000),
+				binary.BigEndian.Uint64(r.values[4]))
+			assert.Equal(t, uint32(3600), binary.BigEndian.Uint32(r.values[6]))
+			if string(r.values[3]) == "usage" {
+				assert.Equal(t, 42.5, float64At(r.values[5]))
+			} else {
+				assert.Equal(t, "count", string(r.values[3]))
+				assert.Equal(t, 3.0, float64At(r.values[5]))
+			}
+		}
+	}
+}
+
+func TestWriteColumns(t *testing.T) {
+	f := newFakeCassandra(t, "127.0.0.1:0")
+	defer f.Close()
+	c := &Cassandra{
+		Servers:      []string{f.Addr().String()},
+		Keyspace:     "telegraf",
+		Schema:       SchemaColumns,
+		CreateTables: true,
+		TTLField:     "ttl",
+	}
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	require.NoError(t, c.Write([]telegraf.Metric{
+		testMetric(t, "a", map[string]interface{}{
+			"usage": 42.5, "state": "ok", "ttl": int64(60)}),
+	}))
+	assert.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS "telegraf"."cpu" (tags text, day text, ` +
+			"time timestamp, PRIMARY KEY ((tags, day), time))",
+		"SELECT column_name, type FROM system_schema.columns WHERE " +
+			"keyspace_name = 'telegraf' AND table_name = 'cpu'",
+		`ALTER TABLE "telegraf"."cpu" ADD "state" text`,
+		`ALTER TABLE "telegraf"."cpu" ADD "usage" double`,
+	}, f.queries)
+	rows := f.batchRows()
+	require.Len(t, rows, 1)
+	assert.Equal(t, `INSERT INTO "telegraf"."cpu" (tags, day, time, `+
+		`"state", "usage") VALUES (?, ?, ?, ?, ?) USING TTL ?`, rows[0].stmt)
+	assert.Equal(t, "host=a", string(rows[0].values[0]))
+	assert.Equal(t, "ok", string(rows[0].values[3]))
+	assert.Equal(t, 42.5, float64At(rows[0].values[4]))
+	assert.Equal(t, uint32(60), binary.BigEndian.Uint32(rows[0].values[5]))
+
+	// the columns are added once, and fields whose type has no matching
+	// column are dropped
+	require.NoError(t, c.Write([]telegraf.Metric{
+		testMetric(t, "b", map[string]interface{}{
+			"usage": int64(7), "count": int64(3)}),
+	}))
+	assert.Len(t, f.queries, 5)
+	assert.Equal(t, `ALTER TABLE "telegraf"."cpu" ADD "count" bigint`,
+		f.queries[4])
+	rows = f.batchRows()
+	require.Len(t, rows, 2)
+	assert.Equal(t, `INSERT INTO "telegraf"."cpu" (tags, day, time, `+
+		`"count") VALUES (?, ?, ?, ?) USING TTL ?`, rows[1].stmt)
+	assert.Equal(t, uint32(0), binary.BigEndian.Uint32(rows[1].values[4]))
+}
+
+func TestWriteUnprepared(t *testing.T) {
+	f := newFakeCassandra(t, "127.0.0.1:0")
+	defer f.Close()
+	c := &Cassandra{Servers: []string{f.Addr().String()}, Keyspace: "telegraf"}
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	metrics := []telegraf.Metric{
+		testMetric(t, "a", map[string]interface{}{"usage": 42.5})}
+	require.NoError(t, c.Write(metrics))
+	// statements that the node forgot are prepared again
+	f.mu.Lock()
+	f.prepared = make(map[string]string)
+	f.mu.Unlock()
+	require.NoError(t, c.Write(metrics))
+	assert.Len(t, f.batchRows(), 2)
+}
+
+func TestMurmur3Token(t *testing.T) {
+	assert.Equal(t, int64(-7468325962851647638),
+		murmur3Token([]byte("123")))
+	assert.Equal(t, int64(0), murmur3Token(nil))
+	assert.Equal(t, []byte("\x00\x03cpu\x00\x00\x01a\x00"),
+		partitionKey([]byte("cpu"), []byte("a")))
+	assert.Equal(t, []byte("cpu"), partitionKey([]byte("cpu")))
+
+	r := newRing(map[string][]string{"a": {"0"}, "b": {"100", "x"}})
+	assert.Equal(t, []int64{0, 100}, r.tokens)
+	assert.Equal(t, "a", r.node([]byte("123")))
+}
+
+func TestConnectInvalid(t *testing.T) {
+	c := &Cassandra{}
+	assert.Error(t, c.Connect())
+	c = &Cassandra{Keyspace: "telegraf", Schema: "narrow"}
+	assert.Error(t, c.Connect())
+	c = &Cassandra{Keyspace: "telegraf", Consistency: "MOST"}
+	assert.Error(t, c.Connect())
+}
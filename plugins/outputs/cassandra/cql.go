@@ -0,0 +1,391 @@
+package cassandra
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// Frame opcodes of the CQL native protocol v4.
+const (
+	opError        = 0x00
+	opStartup      = 0x01
+	opReady        = 0x02
+	opAuthenticate = 0x03
+	opQuery        = 0x07
+	opResult       = 0x08
+	opPrepare      = 0x09
+	opBatch        = 0x0D
+	opAuthResponse = 0x0F
+	opAuthSuccess  = 0x10
+)
+
+// CQL result kinds.
+const (
+	resultRows     = 0x0002
+	resultPrepared = 0x0004
+)
+
+// Option types that describe the columns of a result.
+const (
+	typeCustom = 0x0000
+	typeList   = 0x0020
+	typeMap    = 0x0021
+	typeSet    = 0x0022
+	typeUDT    = 0x0030
+	typeTuple  = 0x0031
+)
+
+// errUnprepared is the error code for an unknown prepared statement.
+const errUnprepared = 0x2500
+
+// consistencies are the CQL consistency levels.
+var consistencies = map[string]uint16{
+	"ANY": 0x00, "ONE": 0x01, "TWO": 0x02, "THREE": 0x03, "QUORUM": 0x04,
+	"ALL": 0x05, "LOCAL_QUORUM": 0x06, "EACH_QUORUM": 0x07, "LOCAL_ONE": 0x0A,
+}
+
+// cqlError is an ERROR frame from the server.
+type cqlError struct {
+	code    int32
+	message string
+}
+
+func (e *cqlError) Error() string {
+	return fmt.Sprintf("%s (code 0x%04x)", e.message, e.code)
+}
+
+// cqlConn is a connection to a node. It sends one request at a time, and
+// remembers the statements prepared on it.
+type cqlConn struct {
+	conn     net.Conn
+	rdr      *bufio.Reader
+	timeout  time.Duration
+	prepared map[string][]byte
+}
+
+// dialCQL connects to a node and starts a session. It authenticates with
+// the PasswordAuthenticator if the node asks for it.
+func dialCQL(addr, username, password string, timeout time.Duration,
+	tlsConfig *tls.Config) (*cqlConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		// copy the config, to verify the certificate against the node name
+		config := &tls.Config{
+			Certificates:       tlsConfig.Certificates,
+			RootCAs:            tlsConfig.RootCAs,
+			InsecureSkipVerify: tlsConfig.InsecureSkipVerify,
+			ServerName:         tlsConfig.ServerName,
+		}
+		if config.ServerName == "" {
+			config.ServerName, _, _ = net.SplitHostPort(addr)
+		}
+		conn = tls.Client(conn, config)
+	}
+	c := &cqlConn{conn: conn, rdr: bufio.NewReader(conn), timeout: timeout,
+		prepared: make(map[string][]byte)}
+	if err := c.startup(username, password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *cqlConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *cqlConn) startup(username, password string) error {
+	b := &frameBuffer{}
+	b.short(1)
+	b.string("CQL_VERSION")
+	b.string("3.0.0")
+	op, body, err := c.request(opStartup, b.buf)
+	if err != nil {
+		return err
+	}
+	switch op {
+	case opReady:
+		return nil
+	case opAuthenticate:
+		b = &frameBuffer{}
+		b.bytes([]byte("\x00" + username + "\x00" + password))
+		if op, body, err = c.request(opAuthResponse, b.buf); err != nil {
+			return err
+		}
+		if op == opAuthSuccess {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected response 0x%02x to startup: %q", op, body)
+}
+
+// query runs a statement without values, and returns its rows, if any.
+func (c *cqlConn) query(stmt string, consistency uint16) ([][][]byte,
+	error) {
+	b := &frameBuffer{}
+	b.longString(stmt)
+	b.short(consistency)
+	b.byte(0)
+	op, body, err := c.request(opQuery, b.buf)
+	if err != nil {
+		return nil, err
+	}
+	if op != opResult {
+		return nil, fmt.Errorf("unexpected response 0x%02x to query", op)
+	}
+	r := &frameReader{buf: body}
+	if r.int() != resultRows {
+		return nil, r.err
+	}
+	return r.rows()
+}
+
+// prepare returns the id of a prepared statement, and prepares it if needed.
+func (c *cqlConn) prepare(stmt string) ([]byte, error) {
+	if id, ok := c.prepared[stmt]; ok {
+		return id, nil
+	}
+	b := &frameBuffer{}
+	b.longString(stmt)
+	op, body, err := c.request(opPrepare, b.buf)
+	if err != nil {
+		return nil, err
+	}
+	r := &frameReader{buf: body}
+	if op != opResult || r.int() != resultPrepared {
+		return nil, fmt.Errorf("unexpected response 0x%02x to prepare", op)
+	}
+	id := r.shortBytes()
+	if r.err != nil {
+		return nil, r.err
+	}
+	c.prepared[stmt] = id
+	return id, nil
+}
+
+// batch runs an UNLOGGED batch, with one prepared statement per set of
+// values. If the node forgot the statements, they are prepared again.
+func (c *cqlConn) batch(stmts []string, values [][][]byte,
+	consistency uint16) error {
+	for retry := true; ; retry = false {
+		b := &frameBuffer{}
+		b.byte(1)
+		b.short(uint16(len(stmts)))
+		for i, stmt := range stmts {
+			id, err := c.prepare(stmt)
+			if err != nil {
+				return err
+			}
+			b.byte(1)
+			b.shortBytes(id)
+			b.short(uint16(len(values[i])))
+			for _, v := range values[i] {
+				b.bytes(v)
+			}
+		}
+		b.short(consistency)
+		b.byte(0)
+		_, _, err := c.request(opBatch, b.buf)
+		if e, ok := err.(*cqlError); ok && e.code == errUnprepared && retry {
+			c.prepared = make(map[string][]byte)
+			continue
+		}
+		return err
+	}
+}
+
+// request sends a frame and reads the response frame. An ERROR from the
+// server is returned as a *cqlError.
+func (c *cqlConn) request(op byte, body []byte) (byte, []byte, error) {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	header := [9]byte{0x04, 0, 0, 0, op}
+	binary.BigEndian.PutUint32(header[5:], uint32(len(body)))
+	if _, err := c.conn.Write(append(header[:], body...)); err != nil {
+		return 0, nil, err
+	}
+	if _, err := io.ReadFull(c.rdr, header[:]); err != nil {
+		return 0, nil, err
+	}
+	if header[0] != 0x84 {
+		return 0, nil, fmt.Errorf("unsupported protocol version 0x%02x",
+			header[0])
+	}
+	body = make([]byte, binary.BigEndian.Uint32(header[5:]))
+	if _, err := io.ReadFull(c.rdr, body); err != nil {
+		return 0, nil, err
+	}
+	if header[4] == opError {
+		r := &frameReader{buf: body}
+		e := &cqlError{code: r.int(), message: r.string()}
+		if r.err != nil {
+			return 0, nil, r.err
+		}
+		return 0, nil, e
+	}
+	return header[4], body, nil
+}
+
+// frameBuffer encodes frame bodies.
+type frameBuffer struct {
+	buf []byte
+}
+
+func (b *frameBuffer) byte(v byte) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *frameBuffer) short(v uint16) {
+	b.buf = append(b.buf, byte(v>>8), byte(v))
+}
+
+func (b *frameBuffer) int(v int32) {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], uint32(v))
+	b.buf = append(b.buf, p[:]...)
+}
+
+func (b *frameBuffer) string(s string) {
+	b.short(uint16(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+func (b *frameBuffer) longString(s string) {
+	b.int(int32(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+// bytes writes [bytes], and null for nil.
+func (b *frameBuffer) bytes(v []byte) {
+	if v == nil {
+		b.int(-1)
+		return
+	}
+	b.int(int32(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+func (b *frameBuffer) shortBytes(v []byte) {
+	b.short(uint16(len(v)))
+	b.buf = append(b.buf, v...)
+}
+
+// frameReader decodes frame bodies. err is set on the first read past the
+// end of the body.
+type frameReader struct {
+	buf []byte
+	err error
+}
+
+func (r *frameReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.buf) < n {
+		r.err = errors.New("short frame")
+		return nil
+	}
+	b := r.buf[:n]
+	r.buf = r.buf[n:]
+	return b
+}
+
+func (r *frameReader) short() uint16 {
+	b := r.next(2)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint16(b)
+}
+
+func (r *frameReader) int() int32 {
+	b := r.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (r *frameReader) string() string {
+	return string(r.next(int(r.short())))
+}
+
+func (r *frameReader) shortBytes() []byte {
+	return r.next(int(r.short()))
+}
+
+// bytes reads [bytes], and nil for null.
+func (r *frameReader) bytes() []byte {
+	n := r.int()
+	if n < 0 {
+		return nil
+	}
+	return r.next(int(n))
+}
+
+// option skips the type of a column.
+func (r *frameReader) option() {
+	switch r.short() {
+	case typeCustom:
+		r.string()
+	case typeList, typeSet:
+		r.option()
+	case typeMap:
+		r.option()
+		r.option()
+	case typeUDT:
+		r.string()
+		r.string()
+		for n := r.short(); n > 0 && r.err == nil; n-- {
+			r.string()
+			r.option()
+		}
+	case typeTuple:
+		for n := r.short(); n > 0 && r.err == nil; n-- {
+			r.option()
+		}
+	}
+}
+
+// rows reads the column values of each row in a Rows result.
+func (r *frameReader) rows() ([][][]byte, error) {
+	flags := r.int()
+	columns := int(r.int())
+	if flags&0x0002 != 0 {
+		r.bytes()
+	}
+	if flags&0x0004 == 0 {
+		if flags&0x0001 != 0 {
+			r.string()
+			r.string()
+		}
+		for i := 0; i < columns && r.err == nil; i++ {
+			if flags&0x0001 == 0 {
+				r.string()
+				r.string()
+			}
+			r.string()
+			r.option()
+		}
+	}
+	n := int(r.int())
+	var rows [][][]byte
+	for i := 0; i < n && r.err == nil; i++ {
+		row := make([][]byte, columns)
+		for j := range row {
+			row[j] = r.bytes()
+		}
+		rows = append(rows, row)
+	}
+	return rows, r.err
+}
@@ -0,0 +1,155 @@
+package cassandra
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// ring maps token ranges to their primary replica, for the
+// Murmur3Partitioner. Each node owns the tokens after the previous node's
+// token, up to and including its own.
+type ring struct {
+	tokens []int64
+	nodes  []string
+}
+
+// newRing returns the ring for the tokens of each node, given as text.
+func newRing(tokens map[string][]string) *ring {
+	r := &ring{}
+	var all []ringToken
+	for node, ts := range tokens {
+		for _, t := range ts {
+			v, err := strconv.ParseInt(t, 10, 64)
+			if err == nil {
+				all = append(all, ringToken{v, node})
+			}
+		}
+	}
+	sort.Sort(byToken(all))
+	for _, t := range all {
+		r.tokens = append(r.tokens, t.token)
+		r.nodes = append(r.nodes, t.node)
+	}
+	return r
+}
+
+// ringToken is a token and the node that has it.
+type ringToken struct {
+	token int64
+	node  string
+}
+
+// byToken sorts ring tokens by value.
+type byToken []ringToken
+
+func (t byToken) Len() int           { return len(t) }
+func (t byToken) Swap(i, j int)      { t[i], t[j] = t[j], t[i] }
+func (t byToken) Less(i, j int) bool { return t[i].token < t[j].token }
+
+// node returns the node that owns a partition key, or "" if the ring has no
+// tokens.
+func (r *ring) node(partitionKey []byte) string {
+	if len(r.tokens) == 0 {
+		return ""
+	}
+	t := murmur3Token(partitionKey)
+	i := sort.Search(len(r.tokens), func(i int) bool {
+		return r.tokens[i] >= t
+	})
+	if i == len(r.tokens) {
+		i = 0
+	}
+	return r.nodes[i]
+}
+
+// partitionKey serializes a partition key. A single component is used as
+// is. In a composite key, each component is written as its 2-byte length,
+// the component and a 0 byte.
+func partitionKey(components ...[]byte) []byte {
+	if len(components) == 1 {
+		return components[0]
+	}
+	var key []byte
+	for _, c := range components {
+		key = append(key, byte(len(c)>>8), byte(len(c)))
+		key = append(key, c...)
+		key = append(key, 0)
+	}
+	return key
+}
+
+// murmur3Token returns the Murmur3Partitioner token for a partition key. It
+// is the first half of the x64 128-bit MurmurHash3 with seed 0. As in
+// Cassandra, the tail bytes of the key are sign extended.
+func murmur3Token(key []byte) int64 {
+	const c1, c2 = 0x87c37b91114253d5, 0x4cf5ad432745937f
+	var h1, h2 uint64
+	nblocks := len(key) / 16
+	for i := 0; i < nblocks; i++ {
+		k1 := binary.LittleEndian.Uint64(key[i*16:])
+		k2 := binary.LittleEndian.Uint64(key[i*16+8:])
+		k1 *= c1
+		k1 = rotl64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+		h1 = rotl64(h1, 27)
+		h1 += h2
+		h1 = h1*5 + 0x52dce729
+		k2 *= c2
+		k2 = rotl64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+		h2 = rotl64(h2, 31)
+		h2 += h1
+		h2 = h2*5 + 0x38495ab5
+	}
+
+	tail := key[nblocks*16:]
+	var k1, k2 uint64
+	for i := len(tail) - 1; i >= 8; i-- {
+		k2 ^= uint64(int64(int8(tail[i]))) << uint((i-8)*8)
+	}
+	if len(tail) > 8 {
+		k2 *= c2
+		k2 = rotl64(k2, 33)
+		k2 *= c1
+		h2 ^= k2
+	}
+	for i := len(tail) - 1; i >= 0 && i < 8; i-- {
+		k1 ^= uint64(int64(int8(tail[i]))) << uint(i*8)
+	}
+	if len(tail) > 0 {
+		k1 *= c1
+		k1 = rotl64(k1, 31)
+		k1 *= c2
+		h1 ^= k1
+	}
+
+	h1 ^= uint64(len(key))
+	h2 ^= uint64(len(key))
+	h1 += h2
+	h2 += h1
+	h1 = fmix64(h1)
+	h2 = fmix64(h2)
+	h1 += h2
+
+	if int64(h1) == math.MinInt64 {
+		return math.MaxInt64
+	}
+	return int64(h1)
+}
+
+func rotl64(x uint64, r uint) uint64 {
+	return x<<r | x>>(64-r)
+}
+
+func fmix64(k uint64) uint64 {
+	k ^= k >> 33
+	k *= 0xff51afd7ed558ccd
+	k ^= k >> 33
+	k *= 0xc4ceb9fe1a85ec53
+	k ^= k >> 33
+	return k
+}
@@ -0,0 +1,310 @@
+package clickhouse
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+type ClickHouse struct {
+	Address  string
+	Database string
+	Username string
+	Password string
+
+	// Tables are named after the measurements, with TablePrefix prepended.
+	TablePrefix     string `toml:"table_prefix"`
+	TimestampColumn string `toml:"timestamp_column"`
+	// CreateTables creates a table with TableEngine for each new
+	// measurement, and adds columns for new tags and fields.
+	CreateTables bool   `toml:"create_tables"`
+	TableEngine  string `toml:"table_engine"`
+
+	AsyncInsert        bool `toml:"async_insert"`
+	WaitForAsyncInsert bool `toml:"wait_for_async_insert"`
+
+	Timeout internal.Duration
+
+	conn *conn
+	// columns are the known columns of each existing table
+	columns map[string]map[string]bool
+}
+
+var sampleConfig = `
+  ## Address of the ClickHouse native protocol port
+  address = "localhost:9000"
+  ## Database, username and password
+  database = "default"
+  # username = "default"
+  # password = ""
+
+  ## Prefix of the table names. Tables are named after the measurements.
+  # table_prefix = ""
+  ## Column for the metric time. Tags and fields get a column each.
+  # timestamp_column = "timestamp"
+
+  ## Create a table for each new measurement with this engine, and add
+  ## columns for new tags and fields
+  # create_tables = true
+  # table_engine = "MergeTree"
+
+  ## Use asynchronous inserts, which the server batches. Optionally wait
+  ## until they are flushed.
+  # async_insert = false
+  # wait_for_async_insert = true
+
+  ## Connection and query timeout
+  # timeout = "10s"
+`
+
+func (c *ClickHouse) Connect() error {
+	conn, err := dial(c.Address, c.Database, c.Username, c.Password,
+		c.Timeout.Duration)
+	if err != nil {
+		return fmt.Errorf("ClickHouse: connecting to %s failed: %s",
+			c.Address, err)
+	}
+	c.conn = conn
+	c.columns = make(map[string]map[string]bool)
+	return nil
+}
+
+func (c *ClickHouse) Close() error {
+	if c.conn == nil {
+		return nil
+	}
+	err := c.conn.Close()
+	c.conn = nil
+	return err
+}
+
+func (c *ClickHouse) Description() string {
+	return "Configuration for ClickHouse server to send metrics to"
+}
+
+func (c *ClickHouse) SampleConfig() string {
+	return sampleConfig
+}
+
+// table holds the columns for the metrics of one table in a batch.
+type table struct {
+	// types are the field types, taken from the first value of each
+	types  map[string]string
+	tags   map[string]bool
+	values map[string][]interface{}
+	rows   int
+}
+
+func (c *ClickHouse) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	if c.conn == nil {
+		if err := c.Connect(); err != nil {
+			return err
+		}
+	}
+
+	tables := make(map[string]*table)
+	var names []string
+	for _, metric := range metrics {
+		name := c.TablePrefix + metric.Name()
+		t, ok := tables[name]
+		if !ok {
+			t = &table{
+				types:  make(map[string]string),
+				tags:   make(map[string]bool),
+				values: make(map[string][]interface{}),
+			}
+			tables[name] = t
+			names = append(names, name)
+		}
+		t.add(c.TimestampColumn, metric.Time())
+		for k, v := range metric.Tags() {
+			if k == c.TimestampColumn {
+				continue
+			}
+			t.tags[k] = true
+			t.add(k, v)
+		}
+		for k, v := range metric.Fields() {
+			typ, ok := columnType(v)
+			if !ok || t.tags[k] {
+				continue
+			}
+			if _, ok := t.types[k]; !ok {
+				t.types[k] = typ
+			}
+			t.add(k, v)
+		}
+		t.rows++
+	}
+
+	for _, name := range names {
+		if err := c.write(name, tables[name]); err != nil {
+			// the state of the connection is unknown
+			c.Close()
+			return fmt.Errorf("ClickHouse: writing to table %s failed: %s",
+				name, err)
+		}
+	}
+	return nil
+}
+
+// add sets the value of a column in the current row, unless it is set.
+func (t *table) add(name string, v interface{}) {
+	values := t.values[name]
+	if len(values) > t.rows {
+		return
+	}
+	// fill earlier rows that have no value for the column
+	for len(values) < t.rows {
+		values = append(values, nil)
+	}
+	t.values[name] = append(values, v)
+}
+
+func (c *ClickHouse) write(name string, t *table) error {
+	var columns []string
+	for k, values := range t.values {
+		for len(values) < t.rows {
+			values = append(values, nil)
+		}
+		t.values[k] = values
+		columns = append(columns, k)
+	}
+	sort.Strings(columns)
+
+	if c.CreateTables {
+		if err := c.create(name, t, columns); err != nil {
+			return err
+		}
+	}
+
+	quoted := make([]string, len(columns))
+	for i, k := range columns {
+		quoted[i] = quote(k)
+	}
+	query := fmt.Sprintf("INSERT INTO %s.%s (%s)", quote(c.Database),
+		quote(name), strings.Join(quoted, ", "))
+	if c.AsyncInsert {
+		wait := 0
+		if c.WaitForAsyncInsert {
+			wait = 1
+		}
+		query += fmt.Sprintf(" SETTINGS async_insert=1, "+
+			"wait_for_async_insert=%d", wait)
+	}
+	return c.conn.Insert(query+" VALUES", t.values)
+}
+
+// create creates a table, and adds the columns it is not known to have.
+func (c *ClickHouse) create(name string, t *table, columns []string) error {
+	known, ok := c.columns[name]
+	if !ok {
+		// the tags and the time are the sorting key
+		var key []string
+		for _, k := range columns {
+			if t.tags[k] {
+				key = append(key, quote(k))
+			}
+		}
+		key = append(key, quote(c.TimestampColumn))
+
+		definitions := make([]string, len(columns))
+		for i, k := range columns {
+			definitions[i] = quote(k) + " " + c.definition(k, t)
+		}
+		err := c.conn.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s.%s "+
+			"(%s) ENGINE = %s ORDER BY (%s)", quote(c.Database), quote(name),
+			strings.Join(definitions, ", "), c.TableEngine,
+			strings.Join(key, ", ")))
+		if err != nil {
+			return err
+		}
+		known = make(map[string]bool)
+		for _, k := range columns {
+			known[k] = true
+		}
+		c.columns[name] = known
+		return nil
+	}
+
+	var additions []string
+	for _, k := range columns {
+		if !known[k] {
+			additions = append(additions, "ADD COLUMN IF NOT EXISTS "+
+				quote(k)+" "+c.definition(k, t))
+		}
+	}
+	if len(additions) == 0 {
+		return nil
+	}
+	err := c.conn.Exec(fmt.Sprintf("ALTER TABLE %s.%s %s", quote(c.Database),
+		quote(name), strings.Join(additions, ", ")))
+	if err != nil {
+		return err
+	}
+	for _, k := range columns {
+		known[k] = true
+	}
+	log.Printf("ClickHouse: added %d column(s) to table %s",
+		len(additions), name)
+	return nil
+}
+
+// definition returns the column type used in DDL. Fields are nullable, and
+// tags are empty when missing.
+func (c *ClickHouse) definition(name string, t *table) string {
+	switch {
+	case name == c.TimestampColumn:
+		return "DateTime64(9)"
+	case t.tags[name]:
+		return "String"
+	}
+	return "Nullable(" + t.types[name] + ")"
+}
+
+// columnType returns the column type for the values of a field.
+func columnType(v interface{}) (string, bool) {
+	switch v.(type) {
+	case int64:
+		return "Int64", true
+	case uint64:
+		return "UInt64", true
+	case float64:
+		return "Float64", true
+	case bool:
+		return "UInt8", true
+	case string:
+		return "String", true
+	}
+	return "", false
+}
+
+// quote quotes an identifier.
+func quote(name string) string {
+	return "`" + strings.NewReplacer("\\", "\\\\", "`", "\\`").Replace(name) +
+		"`"
+}
+
+func init() {
+	outputs.Add("clickhouse", func() telegraf.Output {
+		return &ClickHouse{
+			Address:            "localhost:9000",
+			Database:           "default",
+			Username:           "default",
+			TimestampColumn:    "timestamp",
+			CreateTables:       true,
+			TableEngine:        "MergeTree",
+			WaitForAsyncInsert: true,
+			Timeout:            internal.Duration{Duration: 10 * time.Second},
+		}
+	})
+}
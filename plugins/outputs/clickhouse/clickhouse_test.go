@@ -0,0 +1,285 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+)
+
+// fakeServer is a ClickHouse native protocol server that records the client
+// queries and the rows of their INSERTs.
+type fakeServer struct {
+	listener net.Listener
+	// types are the column types sent in INSERT headers
+	types   map[string]string
+	queries chan string
+	blocks  chan []column
+}
+
+func newFakeServer(t *testing.T, types map[string]string) *fakeServer {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	s := &fakeServer{
+		listener: listener,
+		types:    types,
+		queries:  make(chan string, 10),
+		blocks:   make(chan []column, 10),
+	}
+	go s.serve(t)
+	return s
+}
+
+func (s *fakeServer) serve(t *testing.T) {
+	nc, err := s.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	w := bufio.NewWriter(nc)
+
+	// client hello: name, version, revision, database, user and password
+	code, _ := readUvarint(r)
+	assert.Equal(t, uint64(clientHello), code)
+	readString(r)
+	for i := 0; i < 3; i++ {
+		readUvarint(r)
+	}
+	for i := 0; i < 3; i++ {
+		readString(r)
+	}
+	writeUvarint(w, serverHello)
+	writeString(w, "ClickHouse")
+	writeUvarint(w, 23)
+	writeUvarint(w, 8)
+	writeUvarint(w, 54466)
+	writeString(w, "UTC")
+	w.Flush()
+
+	for {
+		code, err := readUvarint(r)
+		if err != nil {
+			return
+		}
+		require.Equal(t, uint64(clientQuery), code)
+		query := readQuery(t, r)
+		s.queries <- query
+		readClientBlock(t, r)
+
+		if !strings.HasPrefix(query, "INSERT") {
+			writeUvarint(w, serverEndOfStream)
+			w.Flush()
+			continue
+		}
+		if strings.Contains(query, "`fail`") {
+			writeUvarint(w, serverException)
+			writeInt32(w, 60)
+			writeString(w, "DB::Exception")
+			writeString(w, "Table default.fail doesn't exist")
+			writeString(w, "")
+			w.WriteByte(0)
+			w.Flush()
+			continue
+		}
+
+		// INSERT header with the columns
+		names := strings.Split(query[strings.Index(query, "(")+1:strings.Index(
+			query, ")")], ", ")
+		writeUvarint(w, serverData)
+		writeString(w, "")
+		writeUvarint(w, 0)
+		writeUvarint(w, uint64(len(names)))
+		writeUvarint(w, 0)
+		for _, name := range names {
+			name = strings.Trim(name, "`")
+			writeString(w, name)
+			writeString(w, s.types[name])
+		}
+		w.Flush()
+
+		s.blocks <- readClientBlock(t, r)
+		require.Empty(t, readClientBlock(t, r))
+		writeUvarint(w, serverProgress)
+		for i := 0; i < 3; i++ {
+			writeUvarint(w, 1)
+		}
+		writeUvarint(w, serverEndOfStream)
+		w.Flush()
+	}
+}
+
+// readQuery reads a query packet at the client revision.
+func readQuery(t *testing.T, r *bufio.Reader) string {
+	readString(r)
+	r.ReadByte()
+	for i := 0; i < 3; i++ {
+		readString(r)
+	}
+	r.ReadByte()
+	for i := 0; i < 3; i++ {
+		readString(r)
+	}
+	for i := 0; i < 3; i++ {
+		readUvarint(r)
+	}
+	readString(r)
+	settings, _ := readString(r)
+	assert.Empty(t, settings)
+	stage, _ := readUvarint(r)
+	assert.Equal(t, uint64(stageComplete), stage)
+	compression, _ := readUvarint(r)
+	assert.Equal(t, uint64(0), compression)
+	query, _ := readString(r)
+	return query
+}
+
+// readClientBlock reads a client data packet. It only decodes the types used
+// in the tests.
+func readClientBlock(t *testing.T, r *bufio.Reader) []column {
+	code, _ := readUvarint(r)
+	require.Equal(t, uint64(clientData), code)
+	readString(r)
+	require.NoError(t, readBlockInfo(r))
+	n, _ := readUvarint(r)
+	rows, _ := readUvarint(r)
+	var columns []column
+	for i := uint64(0); i < n; i++ {
+		var col column
+		col.name, _ = readString(r)
+		col.typ, _ = readString(r)
+		typ, nullable := unwrap(col.typ, "Nullable")
+		nulls := make([]byte, rows)
+		if nullable {
+			io.ReadFull(r, nulls)
+		}
+		for j := uint64(0); j < rows; j++ {
+			var v interface{}
+			var b [8]byte
+			switch typ {
+			case "String":
+				v, _ = readString(r)
+			case "Float64":
+				io.ReadFull(r, b[:])
+				v = math.Float64frombits(binary.LittleEndian.Uint64(b[:]))
+			case "Int64", "DateTime64(9)":
+				io.ReadFull(r, b[:])
+				v = int64(binary.LittleEndian.Uint64(b[:]))
+			case "UInt8":
+				c, _ := r.ReadByte()
+				v = c
+			default:
+				t.Fatalf("unexpected type %s", typ)
+			}
+			if nulls[j] == 1 {
+				v = nil
+			}
+			col.values = append(col.values, v)
+		}
+		columns = append(columns, col)
+	}
+	return columns
+}
+
+func TestWrite(t *testing.T) {
+	s := newFakeServer(t, map[string]string{
+		"timestamp": "DateTime64(9)",
+		"host":      "String",
+		"cpu":       "String",
+		"usage":     "Nullable(Float64)",
+		"count":     "Nullable(Float64)",
+		"online":    "Nullable(UInt8)",
+		"state":     "Nullable(String)",
+	})
+	defer s.listener.Close()
+
+	c := &ClickHouse{
+		Address:            s.listener.Addr().String(),
+		Database:           "default",
+		Username:           "default",
+		TablePrefix:        "telegraf_",
+		TimestampColumn:    "timestamp",
+		CreateTables:       true,
+		TableEngine:        "MergeTree",
+		AsyncInsert:        true,
+		WaitForAsyncInsert: true,
+		Timeout:            internal.Duration{Duration: 5 * time.Second},
+	}
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	m1, _ := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "cpu": "cpu0"},
+		map[string]interface{}{"usage": 91.5, "count": int64(3),
+			"online": true},
+		time.Unix(1465839830, 123456789))
+	m2, _ := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web2"},
+		map[string]interface{}{"usage": 12.5, "state": "ok"},
+		time.Unix(1465839840, 0))
+	require.NoError(t, c.Write([]telegraf.Metric{m1, m2}))
+
+	assert.Equal(t, "CREATE TABLE IF NOT EXISTS `default`.`telegraf_cpu` "+
+		"(`count` Nullable(Int64), `cpu` String, `host` String, "+
+		"`online` Nullable(UInt8), `state` Nullable(String), "+
+		"`timestamp` DateTime64(9), `usage` Nullable(Float64)) "+
+		"ENGINE = MergeTree ORDER BY (`cpu`, `host`, `timestamp`)",
+		<-s.queries)
+	assert.Equal(t, "INSERT INTO `default`.`telegraf_cpu` (`count`, `cpu`, "+
+		"`host`, `online`, `state`, `timestamp`, `usage`) SETTINGS "+
+		"async_insert=1, wait_for_async_insert=1 VALUES", <-s.queries)
+	assert.Equal(t, []column{
+		// the values use the types of the table
+		{"count", "Nullable(Float64)", []interface{}{3.0, nil}},
+		{"cpu", "String", []interface{}{"cpu0", ""}},
+		{"host", "String", []interface{}{"web1", "web2"}},
+		{"online", "Nullable(UInt8)", []interface{}{byte(1), nil}},
+		{"state", "Nullable(String)", []interface{}{nil, "ok"}},
+		{"timestamp", "DateTime64(9)", []interface{}{
+			int64(1465839830123456789), int64(1465839840000000000)}},
+		{"usage", "Nullable(Float64)", []interface{}{91.5, 12.5}},
+	}, <-s.blocks)
+
+	// new fields add columns to the table
+	m3, _ := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1"},
+		map[string]interface{}{"usage": 1.5, "idle": 98.5},
+		time.Unix(1465839850, 0))
+	s.types["idle"] = "Nullable(Float64)"
+	require.NoError(t, c.Write([]telegraf.Metric{m3}))
+	assert.Equal(t, "ALTER TABLE `default`.`telegraf_cpu` "+
+		"ADD COLUMN IF NOT EXISTS `idle` Nullable(Float64)", <-s.queries)
+	assert.True(t, strings.HasPrefix(<-s.queries, "INSERT"))
+	<-s.blocks
+}
+
+func TestWriteException(t *testing.T) {
+	s := newFakeServer(t, nil)
+	defer s.listener.Close()
+
+	c := &ClickHouse{
+		Address:         s.listener.Addr().String(),
+		Database:        "default",
+		TimestampColumn: "timestamp",
+		Timeout:         internal.Duration{Duration: 5 * time.Second},
+	}
+	require.NoError(t, c.Connect())
+	defer c.Close()
+
+	m, _ := telegraf.NewMetric("fail", nil,
+		map[string]interface{}{"value": 1.0}, time.Now())
+	err := c.Write([]telegraf.Metric{m})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "code 60")
+	assert.Nil(t, c.conn)
+}
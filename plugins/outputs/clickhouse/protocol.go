@@ -0,0 +1,569 @@
+package clickhouse
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ClickHouse native protocol constants. Packets are not compressed. The only
+// revision-specific fields used are the client info in queries and the
+// server timezone in the hello.
+const (
+	clientName     = "telegraf"
+	clientRevision = 54213
+
+	// revisions that added packet fields
+	revisionTemporaryTables = 50264
+	revisionTotalRows       = 51554
+	revisionClientInfo      = 54032
+	revisionServerTimezone  = 54058
+	revisionQuotaKey        = 54060
+)
+
+// Client packet codes.
+const (
+	clientHello = 0
+	clientQuery = 1
+	clientData  = 2
+)
+
+// Server packet codes.
+const (
+	serverHello       = 0
+	serverData        = 1
+	serverException   = 2
+	serverProgress    = 3
+	serverPong        = 4
+	serverEndOfStream = 5
+	serverProfileInfo = 6
+	serverTotals      = 7
+	serverExtremes    = 8
+)
+
+// stageComplete is the query processing stage that runs queries to
+// completion.
+const stageComplete = 2
+
+// Exception is an exception sent by a ClickHouse server.
+type Exception struct {
+	Code    int32
+	Name    string
+	Message string
+}
+
+func (e *Exception) Error() string {
+	return fmt.Sprintf("code %d, %s: %s", e.Code, e.Name, e.Message)
+}
+
+// column is a column of a block. Its values are field values, time.Time,
+// or nil when a row has no value.
+type column struct {
+	name   string
+	typ    string
+	values []interface{}
+}
+
+// conn is a connection to a ClickHouse server.
+type conn struct {
+	conn     net.Conn
+	r        *bufio.Reader
+	w        *bufio.Writer
+	timeout  time.Duration
+	revision uint64
+}
+
+// dial connects to a ClickHouse server and sends the hello with the
+// database and user.
+func dial(
+	address string,
+	database string,
+	username string,
+	password string,
+	timeout time.Duration,
+) (*conn, error) {
+	nc, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &conn{
+		conn:    nc,
+		r:       bufio.NewReader(nc),
+		w:       bufio.NewWriter(nc),
+		timeout: timeout,
+	}
+	if err := c.hello(database, username, password); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *conn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *conn) hello(database, username, password string) error {
+	c.deadline()
+	writeUvarint(c.w, clientHello)
+	writeString(c.w, clientName)
+	writeUvarint(c.w, 1)
+	writeUvarint(c.w, 0)
+	writeUvarint(c.w, clientRevision)
+	writeString(c.w, database)
+	writeString(c.w, username)
+	writeString(c.w, password)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	code, err := readUvarint(c.r)
+	if err != nil {
+		return err
+	}
+	switch code {
+	case serverHello:
+	case serverException:
+		return readException(c.r)
+	default:
+		return fmt.Errorf("unexpected packet %d in the server hello", code)
+	}
+	var revision uint64
+	if _, err = readString(c.r); err != nil {
+		return err
+	}
+	for i := 0; i < 3; i++ {
+		if revision, err = readUvarint(c.r); err != nil {
+			return err
+		}
+	}
+	// use the lower of the two protocol revisions
+	c.revision = clientRevision
+	if revision < c.revision {
+		c.revision = revision
+	}
+	if c.revision >= revisionServerTimezone {
+		if _, err = readString(c.r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Exec executes a query without a result, such as DDL.
+func (c *conn) Exec(query string) error {
+	c.deadline()
+	c.writeQuery(query)
+	c.writeBlock(nil, 0)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	_, err := c.readUntil(serverEndOfStream)
+	return err
+}
+
+// Insert executes an INSERT query and sends the rows of the columns. Values
+// are converted to the column types of the table.
+func (c *conn) Insert(query string, columns map[string][]interface{}) error {
+	c.deadline()
+	c.writeQuery(query)
+	c.writeBlock(nil, 0)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+
+	// the INSERT header block lists the columns and their types
+	header, err := c.readUntil(serverData)
+	if err != nil {
+		return err
+	}
+	rows := 0
+	block := make([]column, 0, len(header))
+	for _, h := range header {
+		values, ok := columns[h.name]
+		if !ok {
+			return fmt.Errorf("no values for column %s", h.name)
+		}
+		rows = len(values)
+		block = append(block, column{name: h.name, typ: h.typ, values: values})
+	}
+
+	c.deadline()
+	if err := c.writeBlock(block, rows); err != nil {
+		return err
+	}
+	c.writeBlock(nil, 0)
+	if err := c.w.Flush(); err != nil {
+		return err
+	}
+	_, err = c.readUntil(serverEndOfStream)
+	return err
+}
+
+func (c *conn) deadline() {
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+func (c *conn) writeQuery(query string) {
+	writeUvarint(c.w, clientQuery)
+	writeString(c.w, "")
+	if c.revision >= revisionClientInfo {
+		hostname, _ := os.Hostname()
+		c.w.WriteByte(1) // initial query
+		writeString(c.w, "")
+		writeString(c.w, "")
+		writeString(c.w, "[::ffff:127.0.0.1]:0")
+		c.w.WriteByte(1) // TCP
+		writeString(c.w, hostname)
+		writeString(c.w, hostname)
+		writeString(c.w, clientName)
+		writeUvarint(c.w, 1)
+		writeUvarint(c.w, 0)
+		writeUvarint(c.w, clientRevision)
+		if c.revision >= revisionQuotaKey {
+			writeString(c.w, "")
+		}
+	}
+	// no settings
+	writeString(c.w, "")
+	writeUvarint(c.w, stageComplete)
+	writeUvarint(c.w, 0)
+	writeString(c.w, query)
+}
+
+// writeBlock writes a data packet with a block of columns. An empty block
+// marks the end of the data.
+func (c *conn) writeBlock(columns []column, rows int) error {
+	writeUvarint(c.w, clientData)
+	if c.revision >= revisionTemporaryTables {
+		writeString(c.w, "")
+	}
+	// block info: not overflows, bucket -1
+	writeUvarint(c.w, 1)
+	c.w.WriteByte(0)
+	writeUvarint(c.w, 2)
+	writeInt32(c.w, -1)
+	writeUvarint(c.w, 0)
+
+	writeUvarint(c.w, uint64(len(columns)))
+	writeUvarint(c.w, uint64(rows))
+	for _, col := range columns {
+		writeString(c.w, col.name)
+		writeString(c.w, col.typ)
+		if err := writeColumn(c.w, col.typ, col.values); err != nil {
+			return fmt.Errorf("column %s: %s", col.name, err)
+		}
+	}
+	return nil
+}
+
+// readUntil reads server packets until a packet with the given code. If it
+// is a data packet, it returns the columns of its block.
+func (c *conn) readUntil(code uint64) ([]column, error) {
+	for {
+		packet, err := readUvarint(c.r)
+		if err != nil {
+			return nil, err
+		}
+		switch packet {
+		case serverException:
+			return nil, readException(c.r)
+		case serverData, serverTotals, serverExtremes:
+			columns, err := c.readBlock()
+			if err != nil {
+				return nil, err
+			}
+			if packet == code {
+				return columns, nil
+			}
+		case serverProgress:
+			n := 2
+			if c.revision >= revisionTotalRows {
+				n = 3
+			}
+			for i := 0; i < n; i++ {
+				if _, err := readUvarint(c.r); err != nil {
+					return nil, err
+				}
+			}
+		case serverProfileInfo:
+			// rows, blocks, bytes, applied limit, rows before limit and
+			// calculated rows before limit, with booleans as bytes
+			for _, isByte := range []bool{false, false, false, true, false,
+				true} {
+				if isByte {
+					_, err = c.r.ReadByte()
+				} else {
+					_, err = readUvarint(c.r)
+				}
+				if err != nil {
+					return nil, err
+				}
+			}
+		case serverPong:
+		case serverEndOfStream:
+			if code == serverEndOfStream {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unexpected end of stream")
+		default:
+			return nil, fmt.Errorf("unexpected packet %d from the server",
+				packet)
+		}
+	}
+}
+
+// readBlock reads a block without rows from the server, such as the header
+// with the columns of an INSERT.
+func (c *conn) readBlock() ([]column, error) {
+	if c.revision >= revisionTemporaryTables {
+		if _, err := readString(c.r); err != nil {
+			return nil, err
+		}
+	}
+	if err := readBlockInfo(c.r); err != nil {
+		return nil, err
+	}
+	n, err := readUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := readUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if rows != 0 {
+		return nil, fmt.Errorf("unexpected block with %d rows from the "+
+			"server", rows)
+	}
+	columns := make([]column, n)
+	for i := range columns {
+		if columns[i].name, err = readString(c.r); err != nil {
+			return nil, err
+		}
+		if columns[i].typ, err = readString(c.r); err != nil {
+			return nil, err
+		}
+	}
+	return columns, nil
+}
+
+func readBlockInfo(r *bufio.Reader) error {
+	for {
+		field, err := readUvarint(r)
+		if err != nil {
+			return err
+		}
+		switch field {
+		case 0:
+			return nil
+		case 1:
+			_, err = r.ReadByte()
+		case 2:
+			_, err = readInt32(r)
+		default:
+			return fmt.Errorf("unexpected field %d of block info", field)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readException reads an exception and returns the outermost one.
+func readException(r *bufio.Reader) error {
+	var e *Exception
+	for {
+		code, err := readInt32(r)
+		if err != nil {
+			return err
+		}
+		var s [3]string // name, message and stack trace
+		for i := range s {
+			if s[i], err = readString(r); err != nil {
+				return err
+			}
+		}
+		if e == nil {
+			e = &Exception{Code: code, Name: s[0], Message: s[1]}
+		}
+		nested, err := r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if nested == 0 {
+			return e
+		}
+	}
+}
+
+// writeColumn writes the values of a column of type typ.
+func writeColumn(w *bufio.Writer, typ string, values []interface{}) error {
+	if inner, ok := unwrap(typ, "Nullable"); ok {
+		for _, v := range values {
+			if v == nil {
+				w.WriteByte(1)
+			} else {
+				w.WriteByte(0)
+			}
+		}
+		typ = inner
+	}
+
+	for _, v := range values {
+		switch {
+		case typ == "String":
+			s := ""
+			if v != nil {
+				s = toString(v)
+			}
+			writeString(w, s)
+		case typ == "Float64":
+			writeUint64(w, math.Float64bits(toFloat(v)))
+		case typ == "Float32":
+			writeUint32(w, math.Float32bits(float32(toFloat(v))))
+		case typ == "Int64", typ == "UInt64":
+			writeUint64(w, uint64(toInt(v)))
+		case typ == "Int32", typ == "UInt32":
+			writeUint32(w, uint32(toInt(v)))
+		case typ == "Int16", typ == "UInt16":
+			var b [2]byte
+			binary.LittleEndian.PutUint16(b[:], uint16(toInt(v)))
+			w.Write(b[:])
+		case typ == "Int8", typ == "UInt8", typ == "Bool":
+			w.WriteByte(byte(toInt(v)))
+		case typ == "DateTime", strings.HasPrefix(typ, "DateTime("):
+			t, _ := v.(time.Time)
+			writeUint32(w, uint32(t.Unix()))
+		case strings.HasPrefix(typ, "DateTime64("):
+			t, _ := v.(time.Time)
+			precision, err := strconv.Atoi(strings.TrimSpace(strings.SplitN(
+				typ[len("DateTime64("):len(typ)-1], ",", 2)[0]))
+			if err != nil || precision < 0 || precision > 9 {
+				return fmt.Errorf("unsupported type %s", typ)
+			}
+			ticks := t.UnixNano() / int64(math.Pow10(9-precision))
+			writeUint64(w, uint64(ticks))
+		default:
+			return fmt.Errorf("unsupported type %s", typ)
+		}
+	}
+	return nil
+}
+
+// unwrap returns the inner type of a wrapper type such as Nullable(String).
+func unwrap(typ, wrapper string) (string, bool) {
+	if strings.HasPrefix(typ, wrapper+"(") && strings.HasSuffix(typ, ")") {
+		return typ[len(wrapper)+1 : len(typ)-1], true
+	}
+	return typ, false
+}
+
+func toString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(v)
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+	case string:
+		f, _ := strconv.ParseFloat(v, 64)
+		return f
+	}
+	return 0
+}
+
+func toInt(v interface{}) int64 {
+	switch v := v.(type) {
+	case int64:
+		return v
+	case uint64:
+		return int64(v)
+	case float64:
+		return int64(v)
+	case bool:
+		if v {
+			return 1
+		}
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	}
+	return 0
+}
+
+func writeUvarint(w *bufio.Writer, v uint64) {
+	var b [binary.MaxVarintLen64]byte
+	w.Write(b[:binary.PutUvarint(b[:], v)])
+}
+
+func writeString(w *bufio.Writer, s string) {
+	writeUvarint(w, uint64(len(s)))
+	w.WriteString(s)
+}
+
+func writeInt32(w *bufio.Writer, v int32) {
+	writeUint32(w, uint32(v))
+}
+
+func writeUint32(w *bufio.Writer, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func writeUint64(w *bufio.Writer, v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	w.Write(b[:])
+}
+
+func readUvarint(r *bufio.Reader) (uint64, error) {
+	return binary.ReadUvarint(r)
+}
+
+func readString(r *bufio.Reader) (string, error) {
+	n, err := readUvarint(r)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func readInt32(r *bufio.Reader) (int32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return int32(binary.LittleEndian.Uint32(b[:])), nil
+}
@@ -3,7 +3,11 @@ package file
 import (
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/influxdata/telegraf"
 	"github.com/influxdata/telegraf/plugins/outputs"
@@ -21,6 +25,9 @@ type File struct {
 
 var sampleConfig = `
   ## Files to write to, "stdout" is a specially handled file.
+  ## Some data formats, like parquet, can not be appended to. With those,
+  ## each write creates a new file, with its time before the extension,
+  ## such as /tmp/metrics-20160613T174350.123456789Z.parquet.
   files = ["stdout", "/tmp/metrics.out"]
 
   ## Data format to output.
@@ -41,10 +48,15 @@ func (f *File) Connect() error {
 		f.Files = []string{"stdout"}
 	}
 
+	_, batch := f.serializer.(serializers.BatchSerializer)
 	for _, file := range f.Files {
+		if batch && file != "stdout" {
+			// a new file is created for each write
+			continue
+		}
 		if file == "stdout" {
+			// stdout is left open, so that telegraf can still print to it
 			writers = append(writers, os.Stdout)
-			f.closers = append(f.closers, os.Stdout)
 		} else {
 			var of *os.File
 			var err error
@@ -91,6 +103,10 @@ func (f *File) Write(metrics []telegraf.Metric) error {
 		return nil
 	}
 
+	if batch, ok := f.serializer.(serializers.BatchSerializer); ok {
+		return f.writeBatch(batch, metrics)
+	}
+
 	for _, metric := range metrics {
 		values, err := f.serializer.Serialize(metric)
 		if err != nil {
@@ -107,6 +123,42 @@ func (f *File) Write(metrics []telegraf.Metric) error {
 	return nil
 }
 
+// writeBatch writes the metrics serialized by a batch serializer to stdout,
+// and to a new file for each of the other files.
+func (f *File) writeBatch(
+	batch serializers.BatchSerializer,
+	metrics []telegraf.Metric,
+) error {
+	data, err := batch.SerializeBatch(metrics)
+	if err != nil {
+		return err
+	}
+	if len(data) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	for _, file := range f.Files {
+		if file == "stdout" {
+			_, err = f.writer.Write(data)
+		} else {
+			err = ioutil.WriteFile(batchFile(file, now), data, 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("FAILED to write batch: %s", err)
+		}
+	}
+	return nil
+}
+
+// batchFile returns the name of the file for a batch written at time t. It
+// is the file name with the time before its extension.
+func batchFile(file string, t time.Time) string {
+	ext := filepath.Ext(file)
+	return strings.TrimSuffix(file, ext) + "-" +
+		t.Format("20060102T150405.000000000Z") + ext
+}
+
 func init() {
 	outputs.Add("file", func() telegraf.Output {
 		return &File{}
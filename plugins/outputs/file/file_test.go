@@ -5,9 +5,12 @@ import (
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/influxdata/telegraf/internal"
 	"github.com/influxdata/telegraf/plugins/serializers"
@@ -170,6 +173,37 @@ func TestFileStdout(t *testing.T) {
 	assert.Equal(t, expNewFile, out)
 }
 
+func TestFileBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "file")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := serializers.NewParquetSerializer(nil, 0, "", "")
+	require.NoError(t, err)
+	f := File{
+		Files:      []string{filepath.Join(dir, "metrics.parquet")},
+		serializer: s,
+	}
+	require.NoError(t, f.Connect())
+
+	// each write is a new file
+	require.NoError(t, f.Write(testutil.MockMetrics()))
+	time.Sleep(time.Millisecond)
+	require.NoError(t, f.Write(testutil.MockMetrics()))
+	require.NoError(t, f.Close())
+
+	files, err := filepath.Glob(filepath.Join(dir, "*"))
+	require.NoError(t, err)
+	require.Len(t, files, 2)
+	for _, file := range files {
+		assert.Regexp(t, `/metrics-\d{8}T\d{6}\.\d{9}Z\.parquet$`, file)
+		buf, err := ioutil.ReadFile(file)
+		require.NoError(t, err)
+		assert.Equal(t, "PAR1", string(buf[:4]))
+		assert.Equal(t, "PAR1", string(buf[len(buf)-4:]))
+	}
+}
+
 func createFile() *os.File {
 	f, err := ioutil.TempFile("", "")
 	if err != nil {
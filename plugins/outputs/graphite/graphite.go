@@ -20,7 +20,11 @@ type Graphite struct {
 	Prefix   string
 	Template string
 	Timeout  int
-	conns    []net.Conn
+	// Protocol is plaintext, or pickle to send batches of PickleBatchSize
+	// metrics
+	Protocol        string
+	PickleBatchSize int `toml:"pickle_batch_size"`
+	conns           []net.Conn
 }
 
 var sampleConfig = `
@@ -33,6 +37,10 @@ var sampleConfig = `
   template = "host.tags.measurement.field"
   ## timeout in seconds for the write connection to graphite
   timeout = 2
+  ## Carbon protocol: plaintext (port 2003), or pickle (port 2004), which
+  ## sends batches of pickle_batch_size metrics
+  # protocol = "plaintext"
+  # pickle_batch_size = 500
 `
 
 func (g *Graphite) Connect() error {
@@ -40,8 +48,21 @@ func (g *Graphite) Connect() error {
 	if g.Timeout <= 0 {
 		g.Timeout = 2
 	}
+	switch g.Protocol {
+	case "", "plaintext", "pickle":
+	default:
+		return fmt.Errorf("Graphite: invalid protocol %q, must be plaintext "+
+			"or pickle", g.Protocol)
+	}
+	if g.PickleBatchSize <= 0 {
+		g.PickleBatchSize = 500
+	}
 	if len(g.Servers) == 0 {
-		g.Servers = append(g.Servers, "localhost:2003")
+		if g.Protocol == "pickle" {
+			g.Servers = append(g.Servers, "localhost:2004")
+		} else {
+			g.Servers = append(g.Servers, "localhost:2003")
+		}
 	}
 	// Get Connections
 	var conns []net.Conn
@@ -88,7 +109,12 @@ func (g *Graphite) Write(metrics []telegraf.Metric) error {
 		}
 		bp = append(bp, gMetrics...)
 	}
-	graphitePoints := strings.Join(bp, "\n") + "\n"
+	var data []byte
+	if g.Protocol == "pickle" {
+		data = pickleMessages(bp, g.PickleBatchSize)
+	} else {
+		data = []byte(strings.Join(bp, "\n") + "\n")
+	}
 
 	// This will get set to nil if a successful write occurs
 	err = errors.New("Could not write to any Graphite server in cluster\n")
@@ -96,7 +122,7 @@ func (g *Graphite) Write(metrics []telegraf.Metric) error {
 	// Send data to a random server
 	p := rand.Perm(len(g.conns))
 	for _, n := range p {
-		if _, e := fmt.Fprintf(g.conns[n], graphitePoints); e != nil {
+		if _, e := g.conns[n].Write(data); e != nil {
 			// Error
 			log.Println("ERROR: " + err.Error())
 			// Let's try the next one
@@ -97,3 +97,29 @@ func TCPServer(t *testing.T, wg *sync.WaitGroup) {
 	assert.Equal(t, "my.prefix.192_168_0_1.my_measurement 3.14 1289430000", data3)
 	conn.Close()
 }
+
+func TestPickleMessages(t *testing.T) {
+	data := pickleMessages([]string{
+		"my.prefix.myfield 3.14 1289430000",
+		"my.prefix.text ok 1289430000",
+		"my.prefix.value 42 1289430000",
+		"my.prefix.late 1 9999999999",
+	}, 2)
+
+	// [("my.prefix.myfield", (1289430000, 3.14)),
+	//  ("my.prefix.value", (1289430000, 42.0))]
+	first := "\x80\x02](" +
+		"X\x11\x00\x00\x00my.prefix.myfield" +
+		"J\xf0\x23\xdbL" + "G\x40\x09\x1e\xb8\x51\xeb\x85\x1f" + "\x86\x86" +
+		"X\x0f\x00\x00\x00my.prefix.value" +
+		"J\xf0\x23\xdbL" + "G\x40\x45\x00\x00\x00\x00\x00\x00" + "\x86\x86" +
+		"e."
+	// [("my.prefix.late", (9999999999.0, 1.0))]
+	second := "\x80\x02](" +
+		"X\x0e\x00\x00\x00my.prefix.late" +
+		"G\x42\x02\xa0\x5f\x1f\xf8\x00\x00" +
+		"G\x3f\xf0\x00\x00\x00\x00\x00\x00" + "\x86\x86" +
+		"e."
+	assert.Equal(t, "\x00\x00\x00\x50"+first+"\x00\x00\x00\x2d"+second,
+		string(data))
+}
@@ -0,0 +1,93 @@
+package graphite
+
+import (
+	"encoding/binary"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Pickle protocol 2 opcodes used in carbon messages.
+const (
+	pickleProto      = 0x80
+	pickleEmptyList  = ']'
+	pickleMark       = '('
+	pickleAppends    = 'e'
+	pickleBinUnicode = 'X'
+	pickleBinInt     = 'J'
+	pickleBinFloat   = 'G'
+	pickleTuple2     = 0x86
+	pickleStop       = '.'
+)
+
+// pickleMessages converts plaintext lines, "path value timestamp", into
+// carbon pickle messages of up to batchSize lines each. A message is a list
+// of (path, (timestamp, value)) tuples, prefixed by its length as a 4 byte
+// big endian integer. Lines whose value or timestamp is not a number are
+// skipped.
+func pickleMessages(lines []string, batchSize int) []byte {
+	var data []byte
+	var msg []byte
+	n := 0
+	flush := func() {
+		if n == 0 {
+			return
+		}
+		msg = append(msg, pickleAppends, pickleStop)
+		var size [4]byte
+		binary.BigEndian.PutUint32(size[:], uint32(len(msg)))
+		data = append(data, size[:]...)
+		data = append(data, msg...)
+		msg, n = msg[:0], 0
+	}
+
+	for _, line := range lines {
+		parts := strings.Fields(line)
+		if len(parts) != 3 {
+			continue
+		}
+		value, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(parts[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		if n == 0 {
+			msg = append(msg, pickleProto, 2, pickleEmptyList, pickleMark)
+		}
+		msg = pickleTuple(msg, parts[0], timestamp, value)
+		n++
+		if n == batchSize {
+			flush()
+		}
+	}
+	flush()
+	return data
+}
+
+// pickleTuple appends the (path, (timestamp, value)) tuple of a metric.
+func pickleTuple(b []byte, path string, timestamp int64,
+	value float64) []byte {
+	var buf [8]byte
+	b = append(b, pickleBinUnicode)
+	binary.LittleEndian.PutUint32(buf[:4], uint32(len(path)))
+	b = append(b, buf[:4]...)
+	b = append(b, path...)
+
+	// timestamps that do not fit in 32 bits are written as floats
+	if timestamp >= math.MinInt32 && timestamp <= math.MaxInt32 {
+		b = append(b, pickleBinInt)
+		binary.LittleEndian.PutUint32(buf[:4], uint32(int32(timestamp)))
+		b = append(b, buf[:4]...)
+	} else {
+		b = append(b, pickleBinFloat)
+		binary.BigEndian.PutUint64(buf[:], math.Float64bits(float64(timestamp)))
+		b = append(b, buf[:]...)
+	}
+	b = append(b, pickleBinFloat)
+	binary.BigEndian.PutUint64(buf[:], math.Float64bits(value))
+	b = append(b, buf[:]...)
+	return append(b, pickleTuple2, pickleTuple2)
+}
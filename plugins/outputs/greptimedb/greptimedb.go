@@ -0,0 +1,473 @@
+package greptimedb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/protowire"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+// handlePath is the path of the Handle method of the GreptimeDatabase gRPC
+// service, which accepts row inserts.
+const handlePath = "/greptime.v1.GreptimeDatabase/Handle"
+
+// ColumnDataType values for the supported value types.
+const (
+	typeBoolean             = 0
+	typeInt64               = 4
+	typeUint64              = 8
+	typeFloat64             = 10
+	typeString              = 12
+	typeTimestampNanosecond = 18
+)
+
+// SemanticType values of GreptimeDB columns.
+const (
+	semanticTag       = 0
+	semanticField     = 1
+	semanticTimestamp = 2
+)
+
+type GreptimeDB struct {
+	// URL is http://host:port of the gRPC API, or https://host:port to use
+	// TLS.
+	URL      string `toml:"url"`
+	Database string
+	Username string
+	Password string
+
+	// TimestampColumn is the name of the time index column.
+	TimestampColumn string `toml:"timestamp_column"`
+	// Compression of the requests, none or gzip.
+	Compression string
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	url    string
+	client *http.Client
+}
+
+var sampleConfig = `
+  ## URL of the GreptimeDB gRPC API. Use https:// to connect with TLS.
+  url = "http://127.0.0.1:4001"
+  ## Database to write to
+  database = "public"
+
+  ## GreptimeDB username and password
+  # username = ""
+  # password = ""
+
+  ## Name of the time index column, in nanoseconds
+  # timestamp_column = "greptime_timestamp"
+
+  ## Request compression: none or gzip
+  # compression = "gzip"
+
+  ## Connection and write timeout
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (g *GreptimeDB) Connect() error {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return fmt.Errorf("GreptimeDB: invalid url %q: %s", g.URL, err)
+	}
+	switch g.Compression {
+	case "", "none", "gzip":
+	default:
+		return fmt.Errorf("GreptimeDB: invalid compression %q, must be none "+
+			"or gzip", g.Compression)
+	}
+	if g.TimestampColumn == "" {
+		return fmt.Errorf("GreptimeDB: timestamp_column must not be empty")
+	}
+	tlsConfig, err := internal.GetTLSConfig(g.SSLCert, g.SSLKey, g.SSLCA,
+		g.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	switch u.Scheme {
+	case "http":
+		// HTTP/2 without TLS, with prior knowledge. The transport only accepts
+		// https URLs, so the URL is rewritten and DialTLS dials a plain
+		// connection.
+		transport.DialTLS = func(network, addr string,
+			_ *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, g.Timeout.Duration)
+		}
+		u.Scheme = "https"
+	case "https":
+	default:
+		return fmt.Errorf("GreptimeDB: invalid url %q, must be http or https",
+			g.URL)
+	}
+	g.url = strings.TrimSuffix(u.String(), "/")
+	g.client = &http.Client{
+		Transport: transport,
+		Timeout:   g.Timeout.Duration,
+	}
+	return nil
+}
+
+func (g *GreptimeDB) Close() error {
+	if g.client != nil {
+		g.client.Transport.(*http2.Transport).CloseIdleConnections()
+	}
+	return nil
+}
+
+func (g *GreptimeDB) Description() string {
+	return "Configuration for GreptimeDB server to send metrics to"
+}
+
+func (g *GreptimeDB) SampleConfig() string {
+	return sampleConfig
+}
+
+// Write inserts the metrics into one table per measurement. All rows are
+// sent in a single request.
+func (g *GreptimeDB) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	var names []string
+	tables := make(map[string][]telegraf.Metric)
+	for _, metric := range metrics {
+		if _, ok := tables[metric.Name()]; !ok {
+			names = append(names, metric.Name())
+		}
+		tables[metric.Name()] = append(tables[metric.Name()], metric)
+	}
+
+	// GreptimeRequest of RowInsertRequests
+	req := &protowire.Encoder{}
+	req.Message(1, g.header)
+	req.Message(6, func(e *protowire.Encoder) {
+		for _, name := range names {
+			e.Message(1, func(e *protowire.Encoder) {
+				e.String(1, name)
+				e.Message(2, func(e *protowire.Encoder) {
+					g.rows(e, tables[name])
+				})
+			})
+		}
+	})
+	return g.handle(req.Buf)
+}
+
+// header writes the RequestHeader of the database and the authentication.
+func (g *GreptimeDB) header(e *protowire.Encoder) {
+	if g.Username != "" {
+		e.Message(3, func(e *protowire.Encoder) {
+			e.Message(1, func(e *protowire.Encoder) {
+				e.String(1, g.Username)
+				e.String(2, g.Password)
+			})
+		})
+	}
+	e.String(4, g.Database)
+}
+
+type column struct {
+	name     string
+	datatype uint64
+	semantic uint64
+}
+
+// rows writes the Rows message for the metrics of one table. Each tag is a
+// tag column and each field is a field column typed by its first value.
+// The time index column comes last, and missing values are null.
+func (g *GreptimeDB) rows(e *protowire.Encoder, metrics []telegraf.Metric) {
+	tags := make(map[string]bool)
+	fields := make(map[string]uint64)
+	for _, metric := range metrics {
+		for k := range metric.Tags() {
+			if k != g.TimestampColumn {
+				tags[k] = true
+			}
+		}
+	}
+	for _, metric := range metrics {
+		for k, v := range metric.Fields() {
+			if _, ok := fields[k]; ok || tags[k] || k == g.TimestampColumn {
+				continue
+			}
+			if datatype, ok := datatypeOf(v); ok {
+				fields[k] = datatype
+			}
+		}
+	}
+
+	columns := make([]column, 0, len(tags)+len(fields)+1)
+	for _, k := range sortedKeys(tags) {
+		columns = append(columns, column{k, typeString, semanticTag})
+	}
+	var fieldNames []string
+	for k := range fields {
+		fieldNames = append(fieldNames, k)
+	}
+	sort.Strings(fieldNames)
+	for _, k := range fieldNames {
+		columns = append(columns, column{k, fields[k], semanticField})
+	}
+	columns = append(columns, column{g.TimestampColumn,
+		typeTimestampNanosecond, semanticTimestamp})
+
+	for _, c := range columns {
+		e.Message(1, func(e *protowire.Encoder) {
+			e.String(1, c.name)
+			e.Varint(2, c.datatype)
+			e.Varint(3, c.semantic)
+		})
+	}
+	for _, metric := range metrics {
+		metricTags := metric.Tags()
+		metricFields := metric.Fields()
+		e.Message(2, func(e *protowire.Encoder) {
+			for _, c := range columns {
+				e.Message(1, func(e *protowire.Encoder) {
+					switch c.semantic {
+					case semanticTag:
+						if v, ok := metricTags[c.name]; ok {
+							e.String(13, v)
+						}
+					case semanticField:
+						if v, ok := metricFields[c.name]; ok {
+							value(e, c.datatype, v)
+						}
+					case semanticTimestamp:
+						e.Varint(19, uint64(metric.UnixNano()))
+					}
+				})
+			}
+		})
+	}
+}
+
+func datatypeOf(v interface{}) (uint64, bool) {
+	switch v.(type) {
+	case float64:
+		return typeFloat64, true
+	case int64:
+		return typeInt64, true
+	case uint64:
+		return typeUint64, true
+	case bool:
+		return typeBoolean, true
+	case string:
+		return typeString, true
+	}
+	return 0, false
+}
+
+// value writes a Value of the column type. Integers are converted to floats
+// for float columns, and between signed and unsigned when they fit. Other
+// values that do not match the column type are written as null.
+func value(e *protowire.Encoder, datatype uint64, v interface{}) {
+	switch datatype {
+	case typeFloat64:
+		switch v := v.(type) {
+		case float64:
+			e.Double(10, v)
+		case int64:
+			e.Double(10, float64(v))
+		case uint64:
+			e.Double(10, float64(v))
+		}
+	case typeInt64:
+		switch v := v.(type) {
+		case int64:
+			e.Varint(4, uint64(v))
+		case uint64:
+			if v <= math.MaxInt64 {
+				e.Varint(4, v)
+			}
+		}
+	case typeUint64:
+		switch v := v.(type) {
+		case uint64:
+			e.Varint(8, v)
+		case int64:
+			if v >= 0 {
+				e.Varint(8, uint64(v))
+			}
+		}
+	case typeBoolean:
+		if v, ok := v.(bool); ok {
+			b := uint64(0)
+			if v {
+				b = 1
+			}
+			e.Varint(11, b)
+		}
+	case typeString:
+		if v, ok := v.(string); ok {
+			e.String(13, v)
+		}
+	}
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// handle sends a GreptimeRequest to the Handle method as a gRPC message over
+// HTTP/2, and checks the status of the GreptimeResponse.
+func (g *GreptimeDB) handle(message []byte) error {
+	var flag byte
+	if g.Compression == "gzip" {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(message)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		message = buf.Bytes()
+		flag = 1
+	}
+	frame := make([]byte, 5, 5+len(message))
+	frame[0] = flag
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+	frame = append(frame, message...)
+
+	req, err := http.NewRequest("POST", g.url+handlePath,
+		bytes.NewReader(frame))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	req.Header.Set("grpc-accept-encoding", "gzip")
+	if flag == 1 {
+		req.Header.Set("grpc-encoding", "gzip")
+	}
+	if g.Timeout.Duration > 0 {
+		req.Header.Set("grpc-timeout",
+			fmt.Sprintf("%dm", g.Timeout.Duration.Nanoseconds()/1e6))
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("GreptimeDB: %s", err)
+	}
+	defer resp.Body.Close()
+	// the trailers are only set after the body is read
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("GreptimeDB: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GreptimeDB: HTTP status %s", resp.Status)
+	}
+	status, msg := resp.Trailer.Get("Grpc-Status"),
+		resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		// error responses may have no body, and the status in the headers
+		status, msg = resp.Header.Get("Grpc-Status"),
+			resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		// the message is percent-encoded, and a plus sign is not a space
+		if m, err := url.QueryUnescape(
+			strings.Replace(msg, "+", "%2B", -1)); err == nil {
+			msg = m
+		}
+		return fmt.Errorf("GreptimeDB: %s (gRPC status %s)", msg, status)
+	}
+
+	if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+		return fmt.Errorf("GreptimeDB: invalid gRPC response")
+	}
+	message = body[5:]
+	if body[0] == 1 {
+		r, err := gzip.NewReader(bytes.NewReader(message))
+		if err != nil {
+			return fmt.Errorf("GreptimeDB: %s", err)
+		}
+		if message, err = ioutil.ReadAll(r); err != nil {
+			return fmt.Errorf("GreptimeDB: %s", err)
+		}
+	}
+	return checkResponse(message)
+}
+
+// checkResponse returns an error if the status in the ResponseHeader of a
+// GreptimeResponse is not a success.
+func checkResponse(message []byte) error {
+	response, err := protowire.Fields(message)
+	if err != nil {
+		return fmt.Errorf("GreptimeDB: invalid response: %s", err)
+	}
+	for _, header := range response[1] {
+		header, err := protowire.Fields(header.([]byte))
+		if err != nil {
+			return fmt.Errorf("GreptimeDB: invalid response: %s", err)
+		}
+		for _, status := range header[1] {
+			status, err := protowire.Fields(status.([]byte))
+			if err != nil {
+				return fmt.Errorf("GreptimeDB: invalid response: %s", err)
+			}
+			if len(status[1]) == 0 || status[1][0].(uint64) == 0 {
+				continue
+			}
+			var msg string
+			if len(status[2]) != 0 {
+				msg = string(status[2][0].([]byte))
+			}
+			return fmt.Errorf("GreptimeDB: %s (status code %d)", msg,
+				status[1][0].(uint64))
+		}
+	}
+	return nil
+}
+
+func init() {
+	outputs.Add("greptimedb", func() telegraf.Output {
+		return &GreptimeDB{
+			URL:             "http://127.0.0.1:4001",
+			Database:        "public",
+			TimestampColumn: "greptime_timestamp",
+			Compression:     "gzip",
+			Timeout:         internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
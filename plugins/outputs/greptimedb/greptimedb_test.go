@@ -0,0 +1,289 @@
+package greptimedb
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// message is a decoded protobuf message, the values of its fields by number.
+type message map[uint64][]interface{}
+
+func decode(t *testing.T, b []byte) message {
+	m, err := protowire.Fields(b)
+	require.NoError(t, err)
+	return message(m)
+}
+
+func (m message) message(t *testing.T, field uint64, i int) message {
+	require.True(t, len(m[field]) > i, "field %d", field)
+	return decode(t, m[field][i].([]byte))
+}
+
+func (m message) string(field uint64) string {
+	if len(m[field]) == 0 {
+		return ""
+	}
+	return string(m[field][0].([]byte))
+}
+
+func (m message) uint(field uint64) uint64 {
+	if len(m[field]) == 0 {
+		return 0
+	}
+	return m[field][0].(uint64)
+}
+
+// newServer returns a fake GreptimeDB gRPC server over HTTP/2 and TLS. It
+// sends the requests it receives to requests, and replies with response.
+func newServer(t *testing.T, requests chan<- message,
+	response []byte) *httptest.Server {
+	return newTLSServer(t, newHandler(t, requests, response))
+}
+
+// newHandler returns the handler of the fake GreptimeDB gRPC server.
+func newHandler(t *testing.T, requests chan<- message,
+	response []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, handlePath, r.URL.Path)
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		require.True(t, len(body) >= 5)
+		assert.Equal(t, len(body)-5, int(binary.BigEndian.Uint32(body[1:5])))
+		msg := body[5:]
+		if body[0] == 1 {
+			assert.Equal(t, "gzip", r.Header.Get("grpc-encoding"))
+			zr, err := gzip.NewReader(bytes.NewReader(msg))
+			require.NoError(t, err)
+			msg, err = ioutil.ReadAll(zr)
+			require.NoError(t, err)
+		}
+		requests <- decode(t, msg)
+
+		if response == nil {
+			// a trailers-only response of an error
+			w.Header().Set("Content-Type", "application/grpc")
+			w.Header().Set("Grpc-Status", "16")
+			w.Header().Set("Grpc-Message", "invalid%20credentials")
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status, Grpc-Message")
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(response)))
+		w.Write(append(frame, response...))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+// newTLSServer returns a server of a handler over HTTP/2 and TLS.
+func newTLSServer(t *testing.T, handler http.Handler) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	require.NoError(t, http2.ConfigureServer(ts.Config, &http2.Server{}))
+	ts.TLS = &tls.Config{NextProtos: []string{http2.NextProtoTLS}}
+	ts.StartTLS()
+	return ts
+}
+
+// newH2CServer serves a handler over HTTP/2 without TLS, with prior
+// knowledge, as gRPC servers do on http URLs. It returns the URL of the
+// server and a function closing it.
+func newH2CServer(t *testing.T, handler http.Handler) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn,
+				&http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { l.Close() }
+}
+
+// greptimeResponse returns a GreptimeResponse of a status code.
+func greptimeResponse(code uint64, msg string, rows uint64) []byte {
+	e := &protowire.Encoder{}
+	e.Message(1, func(e *protowire.Encoder) {
+		e.Message(1, func(e *protowire.Encoder) {
+			e.Varint(1, code)
+			e.String(2, msg)
+		})
+	})
+	e.Message(2, func(e *protowire.Encoder) {
+		e.Varint(1, rows)
+	})
+	return e.Buf
+}
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	m1, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1"},
+		map[string]interface{}{"usage": 91.5, "count": int64(3)},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	m2, err := telegraf.NewMetric("mem",
+		map[string]string{"host": "web2"},
+		map[string]interface{}{"used": uint64(42), "state": "ok"},
+		time.Unix(1465839840, 0))
+	require.NoError(t, err)
+	m3, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web2", "cpu": "cpu0"},
+		map[string]interface{}{"usage": int64(7), "up": true},
+		time.Unix(1465839850, 0))
+	require.NoError(t, err)
+	return []telegraf.Metric{m1, m2, m3}
+}
+
+func newGreptimeDB(url string) *GreptimeDB {
+	return &GreptimeDB{
+		URL:             url,
+		Database:        "metrics",
+		TimestampColumn: "greptime_timestamp",
+		Compression:     "gzip",
+		Timeout:         internal.Duration{Duration: 5 * time.Second},
+
+		InsecureSkipVerify: true,
+	}
+}
+
+func TestWrite(t *testing.T) {
+	requests := make(chan message, 1)
+	ts := newServer(t, requests, greptimeResponse(0, "", 3))
+	defer ts.Close()
+
+	g := newGreptimeDB(ts.URL)
+	g.Username = "greptime"
+	g.Password = "secret"
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	require.NoError(t, g.Write(testMetrics(t)))
+
+	req := <-requests
+	header := req.message(t, 1, 0)
+	assert.Equal(t, "metrics", header.string(4))
+	basic := header.message(t, 3, 0).message(t, 1, 0)
+	assert.Equal(t, "greptime", basic.string(1))
+	assert.Equal(t, "secret", basic.string(2))
+
+	// one table per measurement, in metric order
+	inserts := req.message(t, 6, 0)
+	require.Len(t, inserts[1], 2)
+	cpu := inserts.message(t, 1, 0)
+	assert.Equal(t, "cpu", cpu.string(1))
+	assert.Equal(t, "mem", inserts.message(t, 1, 1).string(1))
+
+	rows := cpu.message(t, 2, 0)
+	type schema struct {
+		name               string
+		datatype, semantic uint64
+	}
+	var columns []schema
+	for i := range rows[1] {
+		c := rows.message(t, 1, i)
+		columns = append(columns, schema{c.string(1), c.uint(2), c.uint(3)})
+	}
+	// field columns are typed by their first value
+	assert.Equal(t, []schema{
+		{"cpu", typeString, semanticTag},
+		{"host", typeString, semanticTag},
+		{"count", typeInt64, semanticField},
+		{"up", typeBoolean, semanticField},
+		{"usage", typeFloat64, semanticField},
+		{"greptime_timestamp", typeTimestampNanosecond, semanticTimestamp},
+	}, columns)
+
+	require.Len(t, rows[2], 2)
+	first := rows.message(t, 2, 0)
+	require.Len(t, first[1], 6)
+	assert.Empty(t, first.message(t, 1, 0))
+	assert.Equal(t, "web1", first.message(t, 1, 1).string(13))
+	assert.Equal(t, uint64(3), first.message(t, 1, 2).uint(4))
+	assert.Empty(t, first.message(t, 1, 3))
+	assert.Equal(t, math.Float64bits(91.5), first.message(t, 1, 4).uint(10))
+	assert.Equal(t, uint64(1465839830123456789),
+		first.message(t, 1, 5).uint(19))
+
+	second := rows.message(t, 2, 1)
+	assert.Equal(t, "cpu0", second.message(t, 1, 0).string(13))
+	assert.Empty(t, second.message(t, 1, 2))
+	assert.Equal(t, uint64(1), second.message(t, 1, 3).uint(11))
+	// integers of float columns are floats
+	assert.Equal(t, math.Float64bits(7), second.message(t, 1, 4).uint(10))
+}
+
+func TestWriteH2C(t *testing.T) {
+	requests := make(chan message, 1)
+	handler := newHandler(t, requests, greptimeResponse(0, "", 3))
+	url, closeServer := newH2CServer(t, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Nil(t, r.TLS)
+			handler.ServeHTTP(w, r)
+		}))
+	defer closeServer()
+
+	g := newGreptimeDB(url)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	require.NoError(t, g.Write(testMetrics(t)))
+	req := <-requests
+	assert.Equal(t, "metrics", req.message(t, 1, 0).string(4))
+}
+
+func TestWriteErrors(t *testing.T) {
+	requests := make(chan message, 1)
+	ts := newServer(t, requests, greptimeResponse(1004, "Invalid "+
+		"arguments: column usage type mismatch", 0))
+	defer ts.Close()
+
+	g := newGreptimeDB(ts.URL)
+	g.Compression = "none"
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	err := g.Write(testMetrics(t))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "type mismatch")
+	<-requests
+
+	ts = newServer(t, requests, nil)
+	defer ts.Close()
+	g = newGreptimeDB(ts.URL)
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	err = g.Write(testMetrics(t))
+	require.Error(t, err)
+	assert.Equal(t, "GreptimeDB: invalid credentials (gRPC status 16)",
+		err.Error())
+	<-requests
+}
+
+func TestConnectInvalid(t *testing.T) {
+	g := newGreptimeDB("grpc://127.0.0.1:4001")
+	assert.Error(t, g.Connect())
+	g = newGreptimeDB("http://127.0.0.1:4001")
+	g.Compression = "zstd"
+	assert.Error(t, g.Connect())
+	g = newGreptimeDB("http://127.0.0.1:4001")
+	g.TimestampColumn = ""
+	assert.Error(t, g.Connect())
+}
@@ -0,0 +1,493 @@
+package grpc
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// timestampType is the full name of the well-known timestamp type.
+const timestampType = ".google.protobuf.Timestamp"
+
+// registry holds the message types, enum types and methods in a descriptor
+// set, by full name, such as ".ingest.v1.Point" and "/ingest.v1.Ingest/Write".
+type registry struct {
+	messages map[string]*descriptor.DescriptorProto
+	enums    map[string]*descriptor.EnumDescriptorProto
+	methods  map[string]*descriptor.MethodDescriptorProto
+}
+
+func newRegistry(set *descriptor.FileDescriptorSet) *registry {
+	r := &registry{
+		messages: make(map[string]*descriptor.DescriptorProto),
+		enums:    make(map[string]*descriptor.EnumDescriptorProto),
+		methods:  make(map[string]*descriptor.MethodDescriptorProto),
+	}
+	for _, file := range set.GetFile() {
+		prefix := ""
+		if file.GetPackage() != "" {
+			prefix = "." + file.GetPackage()
+		}
+		for _, msg := range file.GetMessageType() {
+			r.addMessage(prefix, msg)
+		}
+		for _, enum := range file.GetEnumType() {
+			r.enums[prefix+"."+enum.GetName()] = enum
+		}
+		for _, service := range file.GetService() {
+			for _, method := range service.GetMethod() {
+				path := "/" + strings.TrimPrefix(prefix, ".")
+				if prefix != "" {
+					path += "."
+				}
+				r.methods[path+service.GetName()+"/"+method.GetName()] = method
+			}
+		}
+	}
+	return r
+}
+
+func (r *registry) addMessage(prefix string, msg *descriptor.DescriptorProto) {
+	name := prefix + "." + msg.GetName()
+	r.messages[name] = msg
+	for _, nested := range msg.GetNestedType() {
+		r.addMessage(name, nested)
+	}
+	for _, enum := range msg.GetEnumType() {
+		r.enums[name+"."+enum.GetName()] = enum
+	}
+}
+
+// field returns the field at a dotted path in a message. Every field on the
+// path but the last must be a singular message field.
+func (r *registry) field(
+	msg *descriptor.DescriptorProto,
+	path string,
+) (*descriptor.FieldDescriptorProto, error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		var field *descriptor.FieldDescriptorProto
+		for _, f := range msg.GetField() {
+			if f.GetName() == name {
+				field = f
+			}
+		}
+		if field == nil {
+			return nil, fmt.Errorf("%s has no field %s", msg.GetName(), name)
+		}
+		if i == len(names)-1 {
+			return field, nil
+		}
+		if field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE ||
+			isRepeated(field) {
+			return nil, fmt.Errorf("%s.%s is not a message", msg.GetName(),
+				name)
+		}
+		var ok bool
+		if msg, ok = r.messages[field.GetTypeName()]; !ok {
+			return nil, fmt.Errorf("unknown message type %s",
+				field.GetTypeName())
+		}
+	}
+	return nil, fmt.Errorf("empty field path")
+}
+
+// mapEntry returns the entry message of a map field, nil if the field is
+// not a map.
+func (r *registry) mapEntry(
+	field *descriptor.FieldDescriptorProto,
+) *descriptor.DescriptorProto {
+	if field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE {
+		return nil
+	}
+	msg := r.messages[field.GetTypeName()]
+	if !msg.GetOptions().GetMapEntry() {
+		return nil
+	}
+	return msg
+}
+
+func isRepeated(field *descriptor.FieldDescriptorProto) bool {
+	return field.GetLabel() == descriptor.FieldDescriptorProto_LABEL_REPEATED
+}
+
+// setPath sets the value at a dotted path, and creates the messages on the
+// way.
+func setPath(values map[string]interface{}, path string, value interface{}) {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		next, ok := values[name].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			values[name] = next
+		}
+		values = next
+	}
+	values[names[len(names)-1]] = value
+}
+
+// encoder encodes protobuf messages with the types of a registry.
+type encoder struct {
+	protowire.Encoder
+	registry *registry
+	// unit is the unit of integer time fields.
+	unit time.Duration
+}
+
+// encodeMessage encodes a message from its field values, by name, in field
+// order. A message field value is a map or an encoded message. A repeated
+// field value is a slice, and a map field value is a map. Scalars are
+// converted to the field type.
+func (r *registry) encodeMessage(
+	msg *descriptor.DescriptorProto,
+	values map[string]interface{},
+	unit time.Duration,
+) ([]byte, error) {
+	e := &encoder{registry: r, unit: unit}
+	if err := e.message(msg, values); err != nil {
+		return nil, err
+	}
+	return e.Buf, nil
+}
+
+func (e *encoder) message(
+	msg *descriptor.DescriptorProto,
+	values map[string]interface{},
+) error {
+	for _, field := range msg.GetField() {
+		value, ok := values[field.GetName()]
+		if !ok || value == nil {
+			continue
+		}
+		if err := e.field(field, value); err != nil {
+			return fmt.Errorf("%s.%s: %s", msg.GetName(), field.GetName(), err)
+		}
+	}
+	return nil
+}
+
+func (e *encoder) field(
+	field *descriptor.FieldDescriptorProto,
+	value interface{},
+) error {
+	if entry := e.registry.mapEntry(field); entry != nil {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%T is not a map", value)
+		}
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			// entries whose value does not fit the map type are skipped,
+			// such as string fields in a map of doubles
+			entry := &encoder{registry: e.registry, unit: e.unit}
+			err := entry.value(field, map[string]interface{}{"key": k,
+				"value": m[k]})
+			if err == nil {
+				e.Buf = append(e.Buf, entry.Buf...)
+			}
+		}
+		return nil
+	}
+	if values, ok := value.([]interface{}); ok && isRepeated(field) {
+		for _, v := range values {
+			if err := e.value(field, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return e.value(field, value)
+}
+
+// value writes a field value, converted to the field type.
+func (e *encoder) value(
+	field *descriptor.FieldDescriptorProto,
+	value interface{},
+) error {
+	number := int(field.GetNumber())
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		var b []byte
+		switch v := value.(type) {
+		case []byte:
+			b = v
+		case time.Time:
+			if field.GetTypeName() != timestampType {
+				return fmt.Errorf("a time is not a %s", field.GetTypeName())
+			}
+			m := &protowire.Encoder{}
+			if v.Unix() != 0 {
+				m.Varint(1, uint64(v.Unix()))
+			}
+			if v.Nanosecond() != 0 {
+				m.Varint(2, uint64(v.Nanosecond()))
+			}
+			b = m.Buf
+		case map[string]interface{}:
+			msg, ok := e.registry.messages[field.GetTypeName()]
+			if !ok {
+				return fmt.Errorf("unknown message type %s",
+					field.GetTypeName())
+			}
+			m := &encoder{registry: e.registry, unit: e.unit}
+			if err := m.message(msg, v); err != nil {
+				return err
+			}
+			b = m.Buf
+		default:
+			return fmt.Errorf("%T is not a message", value)
+		}
+		e.Bytes(number, b)
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		e.Bytes(number, []byte(e.toString(value)))
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		if b, ok := value.([]byte); ok {
+			e.Bytes(number, b)
+		} else {
+			e.Bytes(number, []byte(e.toString(value)))
+		}
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		v, err := e.toFloat(value)
+		if err != nil {
+			return err
+		}
+		e.Fixed64(number, math.Float64bits(v))
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		v, err := e.toFloat(value)
+		if err != nil {
+			return err
+		}
+		e.Fixed32(number, math.Float32bits(float32(v)))
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		v, err := toBool(value)
+		if err != nil {
+			return err
+		}
+		var b uint64
+		if v {
+			b = 1
+		}
+		e.Varint(number, b)
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		v, err := e.toEnum(field, value)
+		if err != nil {
+			return err
+		}
+		e.Varint(number, uint64(int64(v)))
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SINT64:
+		v, err := e.toInt(value, math.MinInt64, math.MaxInt64)
+		if err != nil {
+			return err
+		}
+		switch field.GetType() {
+		case descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+			e.Fixed64(number, uint64(v))
+		case descriptor.FieldDescriptorProto_TYPE_SINT64:
+			e.Varint(number, uint64(v<<1^v>>63))
+		default:
+			e.Varint(number, uint64(v))
+		}
+	case descriptor.FieldDescriptorProto_TYPE_INT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SINT32:
+		v, err := e.toInt(value, math.MinInt32, math.MaxInt32)
+		if err != nil {
+			return err
+		}
+		switch field.GetType() {
+		case descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+			e.Fixed32(number, uint32(v))
+		case descriptor.FieldDescriptorProto_TYPE_SINT32:
+			e.Varint(number, uint64(uint32(int32(v)<<1^int32(v)>>31)))
+		default:
+			// negative int32s are sign extended to 10 bytes
+			e.Varint(number, uint64(v))
+		}
+	case descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		v, err := e.toUint(value, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if field.GetType() == descriptor.FieldDescriptorProto_TYPE_FIXED64 {
+			e.Fixed64(number, v)
+		} else {
+			e.Varint(number, v)
+		}
+	case descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		v, err := e.toUint(value, math.MaxUint32)
+		if err != nil {
+			return err
+		}
+		if field.GetType() == descriptor.FieldDescriptorProto_TYPE_FIXED32 {
+			e.Fixed32(number, uint32(v))
+		} else {
+			e.Varint(number, v)
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", strings.ToLower(
+			strings.TrimPrefix(field.GetType().String(), "TYPE_")))
+	}
+	return nil
+}
+
+func (e *encoder) toString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func (e *encoder) toFloat(value interface{}) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int64:
+		return float64(v), nil
+	case uint64:
+		return float64(v), nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%q is not a number", v)
+		}
+		return f, nil
+	case time.Time:
+		return float64(v.UnixNano()) / float64(e.unit), nil
+	}
+	return 0, fmt.Errorf("%T is not a number", value)
+}
+
+// toInt returns a value as an integer between min and max.
+func (e *encoder) toInt(value interface{}, min, max int64) (int64, error) {
+	var i int64
+	switch v := value.(type) {
+	case int64:
+		i = v
+	case uint64:
+		if v > math.MaxInt64 {
+			return 0, fmt.Errorf("%d is out of range", v)
+		}
+		i = int64(v)
+	case float64:
+		if v < math.MinInt64 || v >= math.MaxInt64 || v != math.Trunc(v) {
+			return 0, fmt.Errorf("%v is not an integer", v)
+		}
+		i = int64(v)
+	case bool:
+		if v {
+			i = 1
+		}
+	case string:
+		var err error
+		if i, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("%q is not an integer", v)
+		}
+	case time.Time:
+		i = v.UnixNano() / int64(e.unit)
+	default:
+		return 0, fmt.Errorf("%T is not an integer", value)
+	}
+	if i < min || i > max {
+		return 0, fmt.Errorf("%d is out of range", i)
+	}
+	return i, nil
+}
+
+// toUint returns a value as an unsigned integer up to max.
+func (e *encoder) toUint(value interface{}, max uint64) (uint64, error) {
+	var u uint64
+	switch v := value.(type) {
+	case uint64:
+		u = v
+	case string:
+		var err error
+		if u, err = strconv.ParseUint(v, 10, 64); err != nil {
+			return 0, fmt.Errorf("%q is not an unsigned integer", v)
+		}
+	case float64:
+		if v < 0 || v >= math.MaxUint64 || v != math.Trunc(v) {
+			return 0, fmt.Errorf("%v is not an unsigned integer", v)
+		}
+		u = uint64(v)
+	default:
+		i, err := e.toInt(value, 0, math.MaxInt64)
+		if err != nil {
+			return 0, err
+		}
+		u = uint64(i)
+	}
+	if u > max {
+		return 0, fmt.Errorf("%d is out of range", u)
+	}
+	return u, nil
+}
+
+func toBool(value interface{}) (bool, error) {
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case int64:
+		return v != 0, nil
+	case uint64:
+		return v != 0, nil
+	case float64:
+		return v != 0, nil
+	case string:
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return false, fmt.Errorf("%q is not a boolean", v)
+		}
+		return b, nil
+	}
+	return false, fmt.Errorf("%T is not a boolean", value)
+}
+
+// toEnum returns the number of an enum value, given its name or number.
+func (e *encoder) toEnum(
+	field *descriptor.FieldDescriptorProto,
+	value interface{},
+) (int32, error) {
+	enum, ok := e.registry.enums[field.GetTypeName()]
+	if !ok {
+		return 0, fmt.Errorf("unknown enum type %s", field.GetTypeName())
+	}
+	if name, ok := value.(string); ok {
+		for _, v := range enum.GetValue() {
+			if v.GetName() == name {
+				return v.GetNumber(), nil
+			}
+		}
+	}
+	i, err := e.toInt(value, math.MinInt32, math.MaxInt32)
+	if err != nil {
+		return 0, fmt.Errorf("%v is not a value of %s", value, enum.GetName())
+	}
+	return int32(i), nil
+}
@@ -0,0 +1,400 @@
+package grpc
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"golang.org/x/net/http2"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/parsers/protobuf"
+)
+
+// timestampUnits are the units of integer time fields.
+var timestampUnits = map[string]time.Duration{
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+type GRPC struct {
+	// URL is the gRPC server address, http://host:port, or https://host:port
+	// for TLS.
+	URL string `toml:"url"`
+
+	// DescriptorSet is the FileDescriptorSet file of the service. Otherwise
+	// ProtoFiles are its .proto files, which protoc compiles with the
+	// ImportPaths.
+	DescriptorSet string `toml:"descriptor_set"`
+	ProtoFiles    []string
+	ImportPaths   []string
+	// Method is the full method name, such as ingest.v1.Ingest/Write.
+	Method string
+
+	// MetricsField is the path to a repeated message field of the request.
+	// All the metrics of a write go into it, one message each. If empty, each
+	// metric is a request of its own.
+	MetricsField string `toml:"metrics_field"`
+	// Mapping maps message field paths to what they are set from: name,
+	// time, tags, fields, tag.<key>, field.<key> or a 'literal'.
+	Mapping map[string]string
+	// TimestampUnit is the unit of integer time fields: s, ms, us or ns.
+	TimestampUnit string `toml:"timestamp_unit"`
+
+	// Headers are sent as metadata with every call, such as authorization.
+	Headers map[string]string
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	url      string
+	client   *http.Client
+	registry *registry
+	method   *descriptor.MethodDescriptorProto
+	request  *descriptor.DescriptorProto
+	metric   *descriptor.DescriptorProto
+	unit     time.Duration
+}
+
+var sampleConfig = `
+  ## Address of the gRPC server. Use http://host:port for HTTP/2 without TLS,
+  ## or https://host:port for TLS.
+  url = "http://127.0.0.1:50051"
+
+  ## FileDescriptorSet of the service, written by
+  ##   protoc --include_imports --descriptor_set_out=ingest.pb ingest.proto
+  descriptor_set = "/etc/telegraf/ingest.pb"
+  ## Or the .proto files of the service, and their import paths. They are
+  ## compiled with protoc, which must be in the PATH.
+  # proto_files = ["/etc/telegraf/ingest.proto"]
+  # import_paths = ["/usr/include"]
+
+  ## Full name of the method to call. It must be unary or client streaming.
+  method = "ingest.v1.Ingest/Write"
+
+  ## Path to a repeated message field of the request. All the metrics of a
+  ## write go into one request, one message each. If empty, each metric is a
+  ## request of its own. A client streaming method sends all the requests of
+  ## a write in one call.
+  # metrics_field = "points"
+
+  ## Unit of integer time fields: s, ms, us or ns
+  # timestamp_unit = "ns"
+
+  ## Timeout to connect, and for each call
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+
+  ## What to set each message field from. The keys are field paths. The
+  ## values are name, time, tags, fields, tag.<key>, field.<key> or a
+  ## 'literal'.
+  [outputs.grpc.mapping]
+    name = "name"
+    time = "time"
+    labels = "tags"
+    value = "field.value"
+
+  ## Metadata to send with every call
+  # [outputs.grpc.headers]
+  #   authorization = "Bearer secret"
+`
+
+func (g *GRPC) Connect() error {
+	u, err := url.Parse(g.URL)
+	if err != nil {
+		return fmt.Errorf("gRPC: invalid url %q: %s", g.URL, err)
+	}
+	var ok bool
+	if g.unit, ok = timestampUnits[g.TimestampUnit]; !ok {
+		return fmt.Errorf("gRPC: invalid timestamp_unit %q, must be s, ms, "+
+			"us or ns", g.TimestampUnit)
+	}
+	if err := g.loadMethod(); err != nil {
+		return fmt.Errorf("gRPC: %s", err)
+	}
+	tlsConfig, err := internal.GetTLSConfig(g.SSLCert, g.SSLKey, g.SSLCA,
+		g.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+
+	transport := &http2.Transport{TLSClientConfig: tlsConfig}
+	switch u.Scheme {
+	case "http":
+		// HTTP/2 without TLS, with prior knowledge. The transport only accepts
+		// https URLs, so the URL is rewritten and DialTLS dials a plain
+		// connection.
+		transport.DialTLS = func(network, addr string,
+			_ *tls.Config) (net.Conn, error) {
+			return net.DialTimeout(network, addr, g.Timeout.Duration)
+		}
+		u.Scheme = "https"
+	case "https":
+	default:
+		return fmt.Errorf("gRPC: invalid url %q, must be http or https", g.URL)
+	}
+	g.url = strings.TrimSuffix(u.String(), "/") + "/" +
+		strings.TrimPrefix(g.Method, "/")
+	g.client = &http.Client{
+		Transport: transport,
+		Timeout:   g.Timeout.Duration,
+	}
+	return nil
+}
+
+// loadMethod loads the service descriptors, and checks the method and the
+// field paths.
+func (g *GRPC) loadMethod() error {
+	var set *descriptor.FileDescriptorSet
+	var err error
+	switch {
+	case g.DescriptorSet != "":
+		set, err = protobuf.LoadDescriptorSet(g.DescriptorSet)
+	case len(g.ProtoFiles) != 0:
+		set, err = protobuf.CompileProtoFiles(g.ProtoFiles, g.ImportPaths)
+	default:
+		err = fmt.Errorf("descriptor_set or proto_files must be set")
+	}
+	if err != nil {
+		return err
+	}
+	g.registry = newRegistry(set)
+
+	method := "/" + strings.TrimPrefix(g.Method, "/")
+	if g.method = g.registry.methods[method]; g.method == nil {
+		return fmt.Errorf("method %s is not defined in the descriptors",
+			g.Method)
+	}
+	var ok bool
+	if g.request, ok = g.registry.messages[g.method.GetInputType()]; !ok {
+		return fmt.Errorf("unknown message type %s", g.method.GetInputType())
+	}
+
+	g.metric = g.request
+	if g.MetricsField != "" {
+		field, err := g.registry.field(g.request, g.MetricsField)
+		if err != nil {
+			return fmt.Errorf("invalid metrics_field: %s", err)
+		}
+		if field.GetType() != descriptor.FieldDescriptorProto_TYPE_MESSAGE ||
+			!isRepeated(field) || g.registry.mapEntry(field) != nil {
+			return fmt.Errorf("metrics_field %s is not a repeated message",
+				g.MetricsField)
+		}
+		if g.metric, ok = g.registry.messages[field.GetTypeName()]; !ok {
+			return fmt.Errorf("unknown message type %s", field.GetTypeName())
+		}
+	}
+
+	for path, source := range g.Mapping {
+		field, err := g.registry.field(g.metric, path)
+		if err != nil {
+			return fmt.Errorf("invalid mapping for %s: %s", path, err)
+		}
+		switch {
+		case source == "tags" || source == "fields":
+			if g.registry.mapEntry(field) == nil {
+				return fmt.Errorf("%s must be a map field for %s", path, source)
+			}
+		case source == "name" || source == "time",
+			strings.HasPrefix(source, "tag."),
+			strings.HasPrefix(source, "field."),
+			len(source) >= 2 && strings.HasPrefix(source, "'") &&
+				strings.HasSuffix(source, "'"):
+		default:
+			return fmt.Errorf("invalid source %q for %s, must be name, time, "+
+				"tags, fields, tag.<key>, field.<key> or a 'literal'", source,
+				path)
+		}
+	}
+	return nil
+}
+
+func (g *GRPC) Close() error {
+	if g.client != nil {
+		g.client.Transport.(*http2.Transport).CloseIdleConnections()
+	}
+	return nil
+}
+
+func (g *GRPC) Description() string {
+	return "Configuration for a gRPC method to send metrics to, from its " +
+		"service descriptors"
+}
+
+func (g *GRPC) SampleConfig() string {
+	return sampleConfig
+}
+
+// Write calls the method with the metrics. With a metrics_field, they all go
+// into one request. Otherwise each metric is a request. A client streaming
+// method sends the requests in one call, and a unary method in a call each.
+func (g *GRPC) Write(metrics []telegraf.Metric) error {
+	var messages []interface{}
+	for _, m := range metrics {
+		b, err := g.registry.encodeMessage(g.metric, g.values(m), g.unit)
+		if err != nil {
+			log.Printf("gRPC: dropping metric: %s", err)
+			continue
+		}
+		messages = append(messages, b)
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+
+	var requests [][]byte
+	if g.MetricsField != "" {
+		values := make(map[string]interface{})
+		setPath(values, g.MetricsField, messages)
+		b, err := g.registry.encodeMessage(g.request, values, g.unit)
+		if err != nil {
+			return fmt.Errorf("gRPC: %s", err)
+		}
+		requests = append(requests, b)
+	} else {
+		for _, b := range messages {
+			requests = append(requests, b.([]byte))
+		}
+	}
+
+	if g.method.GetClientStreaming() {
+		return g.call(requests)
+	}
+	for _, request := range requests {
+		if err := g.call([][]byte{request}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// values returns the message field values for a metric, by path, as set by
+// the mapping.
+func (g *GRPC) values(m telegraf.Metric) map[string]interface{} {
+	values := make(map[string]interface{})
+	paths := make([]string, 0, len(g.Mapping))
+	for path := range g.Mapping {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		var value interface{}
+		switch source := g.Mapping[path]; {
+		case source == "name":
+			value = m.Name()
+		case source == "time":
+			value = m.Time()
+		case source == "tags":
+			tags := make(map[string]interface{})
+			for k, v := range m.Tags() {
+				tags[k] = v
+			}
+			value = tags
+		case source == "fields":
+			value = m.Fields()
+		case strings.HasPrefix(source, "tag."):
+			if v, ok := m.Tags()[source[len("tag."):]]; ok {
+				value = v
+			}
+		case strings.HasPrefix(source, "field."):
+			value = m.Fields()[source[len("field."):]]
+		default:
+			value = source[1 : len(source)-1]
+		}
+		if value != nil {
+			setPath(values, path, value)
+		}
+	}
+	return values
+}
+
+// call calls the method over HTTP/2 with the requests, and checks the call
+// status. The response messages are ignored.
+func (g *GRPC) call(requests [][]byte) error {
+	var body []byte
+	for _, message := range requests {
+		var header [5]byte
+		binary.BigEndian.PutUint32(header[1:], uint32(len(message)))
+		body = append(body, header[:]...)
+		body = append(body, message...)
+	}
+
+	req, err := http.NewRequest("POST", g.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range g.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	if g.Timeout.Duration > 0 {
+		req.Header.Set("grpc-timeout",
+			fmt.Sprintf("%dm", g.Timeout.Duration.Nanoseconds()/1e6))
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("gRPC: %s", err)
+	}
+	defer resp.Body.Close()
+	// the trailers arrive after the body
+	if _, err := ioutil.ReadAll(resp.Body); err != nil {
+		return fmt.Errorf("gRPC: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("gRPC: HTTP status %s", resp.Status)
+	}
+	status, msg := resp.Trailer.Get("Grpc-Status"),
+		resp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		// error responses may have no body, and the status in the headers
+		status, msg = resp.Header.Get("Grpc-Status"),
+			resp.Header.Get("Grpc-Message")
+	}
+	if status != "0" {
+		// the message is percent-encoded, and a plus sign is not a space
+		if m, err := url.QueryUnescape(
+			strings.Replace(msg, "+", "%2B", -1)); err == nil {
+			msg = m
+		}
+		return fmt.Errorf("gRPC: %s (gRPC status %s)", msg, status)
+	}
+	return nil
+}
+
+func init() {
+	outputs.Add("grpc", func() telegraf.Output {
+		return &GRPC{
+			TimestampUnit: "ns",
+			Timeout:       internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
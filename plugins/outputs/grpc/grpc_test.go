@@ -0,0 +1,480 @@
+package grpc
+
+import (
+	"crypto/tls"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/http2"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+func field(
+	name string,
+	number int32,
+	typ descriptor.FieldDescriptorProto_Type,
+	typeName string,
+	repeated bool,
+) *descriptor.FieldDescriptorProto {
+	label := descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptor.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptor.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Type:   typ.Enum(),
+		Label:  label.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// The descriptors of:
+//
+//	package ingest.v1;
+//
+//	service Ingest {
+//	  rpc Write(WriteRequest) returns (WriteResponse);
+//	  rpc Stream(stream Point) returns (WriteResponse);
+//	}
+//
+//	message WriteRequest { repeated Point points = 1; }
+//	message WriteResponse {}
+//
+//	message Point {
+//	  enum Kind { UNKNOWN = 0; GAUGE = 1; }
+//	  message Resource { string host = 1; }
+//
+//	  string name = 1;
+//	  map<string, string> labels = 2;
+//	  double value = 3;
+//	  google.protobuf.Timestamp time = 4;
+//	  Resource resource = 5;
+//	  Kind kind = 6;
+//	  int64 time_ms = 7;
+//	  map<string, double> values = 8;
+//	}
+//
+// and of google/protobuf/timestamp.proto.
+var testDescriptors = &descriptor.FileDescriptorSet{
+	File: []*descriptor.FileDescriptorProto{{
+		Name:    proto.String("google/protobuf/timestamp.proto"),
+		Package: proto.String("google.protobuf"),
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Timestamp"),
+			Field: []*descriptor.FieldDescriptorProto{
+				field("seconds", 1, descriptor.FieldDescriptorProto_TYPE_INT64,
+					"", false),
+				field("nanos", 2, descriptor.FieldDescriptorProto_TYPE_INT32,
+					"", false),
+			},
+		}},
+	}, {
+		Name:       proto.String("ingest.proto"),
+		Package:    proto.String("ingest.v1"),
+		Dependency: []string{"google/protobuf/timestamp.proto"},
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("WriteRequest"),
+			Field: []*descriptor.FieldDescriptorProto{
+				field("points", 1, descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".ingest.v1.Point", true),
+			},
+		}, {
+			Name: proto.String("WriteResponse"),
+		}, {
+			Name: proto.String("Point"),
+			Field: []*descriptor.FieldDescriptorProto{
+				field("name", 1, descriptor.FieldDescriptorProto_TYPE_STRING,
+					"", false),
+				field("labels", 2, descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".ingest.v1.Point.LabelsEntry", true),
+				field("value", 3, descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+					"", false),
+				field("time", 4, descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".google.protobuf.Timestamp", false),
+				field("resource", 5,
+					descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".ingest.v1.Point.Resource", false),
+				field("kind", 6, descriptor.FieldDescriptorProto_TYPE_ENUM,
+					".ingest.v1.Point.Kind", false),
+				field("time_ms", 7, descriptor.FieldDescriptorProto_TYPE_INT64,
+					"", false),
+				field("values", 8, descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".ingest.v1.Point.ValuesEntry", true),
+			},
+			NestedType: []*descriptor.DescriptorProto{{
+				Name: proto.String("Resource"),
+				Field: []*descriptor.FieldDescriptorProto{
+					field("host", 1, descriptor.FieldDescriptorProto_TYPE_STRING,
+						"", false),
+				},
+			}, {
+				Name: proto.String("LabelsEntry"),
+				Field: []*descriptor.FieldDescriptorProto{
+					field("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING,
+						"", false),
+					field("value", 2,
+						descriptor.FieldDescriptorProto_TYPE_STRING, "", false),
+				},
+				Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+			}, {
+				Name: proto.String("ValuesEntry"),
+				Field: []*descriptor.FieldDescriptorProto{
+					field("key", 1, descriptor.FieldDescriptorProto_TYPE_STRING,
+						"", false),
+					field("value", 2,
+						descriptor.FieldDescriptorProto_TYPE_DOUBLE, "", false),
+				},
+				Options: &descriptor.MessageOptions{MapEntry: proto.Bool(true)},
+			}},
+			EnumType: []*descriptor.EnumDescriptorProto{{
+				Name: proto.String("Kind"),
+				Value: []*descriptor.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("GAUGE"), Number: proto.Int32(1)},
+				},
+			}},
+		}},
+		Service: []*descriptor.ServiceDescriptorProto{{
+			Name: proto.String("Ingest"),
+			Method: []*descriptor.MethodDescriptorProto{{
+				Name:       proto.String("Write"),
+				InputType:  proto.String(".ingest.v1.WriteRequest"),
+				OutputType: proto.String(".ingest.v1.WriteResponse"),
+			}, {
+				Name:            proto.String("Stream"),
+				InputType:       proto.String(".ingest.v1.Point"),
+				OutputType:      proto.String(".ingest.v1.WriteResponse"),
+				ClientStreaming: proto.Bool(true),
+			}},
+		}},
+	}},
+}
+
+// message is a decoded protobuf message, the values of its fields by number.
+type message map[uint64][]interface{}
+
+func decode(t *testing.T, b []byte) message {
+	m := make(message)
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		require.True(t, n > 0, "truncated key")
+		b = b[n:]
+		var v interface{}
+		switch key & 7 {
+		case protowire.WireVarint:
+			v, n = proto.DecodeVarint(b)
+			require.True(t, n > 0, "truncated varint")
+		case protowire.WireFixed64:
+			require.True(t, len(b) >= 8, "truncated fixed64")
+			v, n = binary.LittleEndian.Uint64(b), 8
+		case protowire.WireBytes:
+			size, k := proto.DecodeVarint(b)
+			require.True(t, k > 0 && uint64(len(b)-k) >= size,
+				"truncated bytes")
+			v, n = b[k:k+int(size)], k+int(size)
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		b = b[n:]
+		m[key>>3] = append(m[key>>3], v)
+	}
+	return m
+}
+
+func (m message) message(t *testing.T, field uint64, i int) message {
+	require.True(t, len(m[field]) > i, "field %d", field)
+	return decode(t, m[field][i].([]byte))
+}
+
+func (m message) string(field uint64) string {
+	if len(m[field]) == 0 {
+		return ""
+	}
+	return string(m[field][0].([]byte))
+}
+
+func (m message) uint(field uint64) uint64 {
+	if len(m[field]) == 0 {
+		return 0
+	}
+	return m[field][0].(uint64)
+}
+
+// newServer returns a fake gRPC server over HTTP/2 and TLS, replying with
+// status. Each call it receives is sent to calls as its messages, preceded
+// by a message holding the path of the call in field 0.
+func newServer(t *testing.T, calls chan<- []message,
+	status string) *httptest.Server {
+	return newTLSServer(t, newHandler(t, calls, status))
+}
+
+// newHandler returns the handler of the fake gRPC server.
+func newHandler(t *testing.T, calls chan<- []message,
+	status string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/grpc", r.Header.Get("Content-Type"))
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		body, err := ioutil.ReadAll(r.Body)
+		require.NoError(t, err)
+		var messages []message
+		for len(body) > 0 {
+			require.True(t, len(body) >= 5)
+			n := int(binary.BigEndian.Uint32(body[1:5]))
+			require.True(t, len(body) >= 5+n)
+			messages = append(messages, decode(t, body[5:5+n]))
+			body = body[5+n:]
+		}
+		calls <- append([]message{{0: {[]byte(r.URL.Path)}}}, messages...)
+
+		w.Header().Set("Content-Type", "application/grpc")
+		if status != "0" {
+			// a trailers-only response of an error
+			w.Header().Set("Grpc-Status", status)
+			w.Header().Set("Grpc-Message", "quota%20exceeded")
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Write(make([]byte, 5))
+		w.Header().Set("Grpc-Status", "0")
+	})
+}
+
+// newTLSServer returns a server of a handler over HTTP/2 and TLS.
+func newTLSServer(t *testing.T, handler http.Handler) *httptest.Server {
+	ts := httptest.NewUnstartedServer(handler)
+	require.NoError(t, http2.ConfigureServer(ts.Config, &http2.Server{}))
+	ts.TLS = &tls.Config{NextProtos: []string{http2.NextProtoTLS}}
+	ts.StartTLS()
+	return ts
+}
+
+// newH2CServer serves a handler over HTTP/2 without TLS, with prior
+// knowledge, as gRPC servers do on http URLs. It returns the URL of the
+// server and a function closing it.
+func newH2CServer(t *testing.T, handler http.Handler) (string, func()) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go (&http2.Server{}).ServeConn(conn,
+				&http2.ServeConnOpts{Handler: handler})
+		}
+	}()
+	return "http://" + l.Addr().String(), func() { l.Close() }
+}
+
+func writeDescriptors(t *testing.T) string {
+	data, err := proto.Marshal(testDescriptors)
+	require.NoError(t, err)
+	dir, err := ioutil.TempDir("", "grpc")
+	require.NoError(t, err)
+	path := filepath.Join(dir, "ingest.pb")
+	require.NoError(t, ioutil.WriteFile(path, data, 0600))
+	return path
+}
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	m1, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "cpu": "cpu0"},
+		map[string]interface{}{"value": 91.5, "state": "ok"},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	// a metric with a value that is not a double is dropped
+	m2, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web2"},
+		map[string]interface{}{"value": "high"},
+		time.Unix(1465839840, 0))
+	require.NoError(t, err)
+	m3, err := telegraf.NewMetric("mem",
+		map[string]string{"host": "web2"},
+		map[string]interface{}{"value": int64(42)},
+		time.Unix(1465839850, 0))
+	require.NoError(t, err)
+	return []telegraf.Metric{m1, m2, m3}
+}
+
+func newGRPC(t *testing.T, url string) *GRPC {
+	return &GRPC{
+		URL:           url,
+		DescriptorSet: writeDescriptors(t),
+		TimestampUnit: "ns",
+		Headers:       map[string]string{"authorization": "Bearer secret"},
+		Timeout:       internal.Duration{Duration: 5 * time.Second},
+
+		InsecureSkipVerify: true,
+	}
+}
+
+func TestWrite(t *testing.T) {
+	calls := make(chan []message, 1)
+	ts := newServer(t, calls, "0")
+	defer ts.Close()
+
+	g := newGRPC(t, ts.URL)
+	defer os.RemoveAll(filepath.Dir(g.DescriptorSet))
+	g.Method = "ingest.v1.Ingest/Write"
+	g.MetricsField = "points"
+	g.Mapping = map[string]string{
+		"name":          "name",
+		"labels":        "tags",
+		"value":         "field.value",
+		"time":          "time",
+		"resource.host": "tag.host",
+		"kind":          "'GAUGE'",
+	}
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	require.NoError(t, g.Write(testMetrics(t)))
+
+	call := <-calls
+	assert.Equal(t, "/ingest.v1.Ingest/Write", call[0].string(0))
+	require.Len(t, call, 2)
+	request := call[1]
+	require.Len(t, request[1], 2)
+
+	point := request.message(t, 1, 0)
+	assert.Equal(t, "cpu", point.string(1))
+	require.Len(t, point[2], 2)
+	cpu := point.message(t, 2, 0)
+	assert.Equal(t, "cpu", cpu.string(1))
+	assert.Equal(t, "cpu0", cpu.string(2))
+	assert.Equal(t, "host", point.message(t, 2, 1).string(1))
+	assert.Equal(t, math.Float64bits(91.5), point.uint(3))
+	ts1 := point.message(t, 4, 0)
+	assert.Equal(t, uint64(1465839830), ts1.uint(1))
+	assert.Equal(t, uint64(123456789), ts1.uint(2))
+	assert.Equal(t, "web1", point.message(t, 5, 0).string(1))
+	assert.Equal(t, uint64(1), point.uint(6))
+
+	// integers of double fields are doubles
+	point = request.message(t, 1, 1)
+	assert.Equal(t, "mem", point.string(1))
+	assert.Equal(t, math.Float64bits(42), point.uint(3))
+	ts3 := point.message(t, 4, 0)
+	assert.Equal(t, uint64(1465839850), ts3.uint(1))
+	assert.Empty(t, ts3[2])
+}
+
+func TestWriteStreaming(t *testing.T) {
+	calls := make(chan []message, 1)
+	ts := newServer(t, calls, "0")
+	defer ts.Close()
+
+	g := newGRPC(t, ts.URL)
+	defer os.RemoveAll(filepath.Dir(g.DescriptorSet))
+	g.Method = "/ingest.v1.Ingest/Stream"
+	g.TimestampUnit = "ms"
+	g.Mapping = map[string]string{
+		"name":    "name",
+		"time_ms": "time",
+		"values":  "fields",
+	}
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	require.NoError(t, g.Write(testMetrics(t)))
+
+	// one call, with a message for each metric
+	call := <-calls
+	assert.Equal(t, "/ingest.v1.Ingest/Stream", call[0].string(0))
+	require.Len(t, call, 4)
+	assert.Equal(t, "cpu", call[1].string(1))
+	assert.Equal(t, uint64(1465839830123), call[1].uint(7))
+	// fields that are not doubles are left out of the values
+	require.Len(t, call[1][8], 1)
+	value := call[1].message(t, 8, 0)
+	assert.Equal(t, "value", value.string(1))
+	assert.Equal(t, math.Float64bits(91.5), value.uint(2))
+	assert.Empty(t, call[2][8])
+	assert.Equal(t, "mem", call[3].string(1))
+	assert.Equal(t, uint64(1465839850000), call[3].uint(7))
+}
+
+func TestWriteH2C(t *testing.T) {
+	calls := make(chan []message, 1)
+	handler := newHandler(t, calls, "0")
+	url, closeServer := newH2CServer(t, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			assert.Nil(t, r.TLS)
+			handler.ServeHTTP(w, r)
+		}))
+	defer closeServer()
+
+	g := newGRPC(t, url)
+	defer os.RemoveAll(filepath.Dir(g.DescriptorSet))
+	g.Method = "ingest.v1.Ingest/Write"
+	g.MetricsField = "points"
+	g.Mapping = map[string]string{"name": "name"}
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	require.NoError(t, g.Write(testMetrics(t)))
+
+	call := <-calls
+	assert.Equal(t, "/ingest.v1.Ingest/Write", call[0].string(0))
+	require.Len(t, call, 2)
+	assert.Equal(t, "cpu", call[1].message(t, 1, 0).string(1))
+}
+
+func TestWriteStatus(t *testing.T) {
+	calls := make(chan []message, 3)
+	ts := newServer(t, calls, "8")
+	defer ts.Close()
+
+	g := newGRPC(t, ts.URL)
+	defer os.RemoveAll(filepath.Dir(g.DescriptorSet))
+	g.Method = "ingest.v1.Ingest/Write"
+	g.Mapping = map[string]string{"points.name": "name"}
+	require.Error(t, g.Connect())
+
+	// without metrics_field, a unary method makes one call per metric
+	g.Mapping = map[string]string{}
+	require.NoError(t, g.Connect())
+	defer g.Close()
+	err := g.Write(testMetrics(t))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "quota exceeded (gRPC status 8)")
+}
+
+func TestConnectErrors(t *testing.T) {
+	g := newGRPC(t, "http://127.0.0.1:50051")
+	defer os.RemoveAll(filepath.Dir(g.DescriptorSet))
+
+	g.Method = "ingest.v1.Ingest/Delete"
+	assert.Error(t, g.Connect())
+
+	g.Method = "ingest.v1.Ingest/Write"
+	g.MetricsField = "points.name"
+	assert.Error(t, g.Connect())
+
+	g.MetricsField = "points"
+	g.Mapping = map[string]string{"labels": "field.value"}
+	require.NoError(t, g.Connect())
+	g.Mapping = map[string]string{"value": "tags"}
+	assert.Error(t, g.Connect())
+	g.Mapping = map[string]string{"value": "value"}
+	assert.Error(t, g.Connect())
+	g.Mapping = map[string]string{"resource.port": "tag.port"}
+	assert.Error(t, g.Connect())
+}
@@ -2,6 +2,8 @@ package mqtt
 
 import (
 	"fmt"
+	"log"
+	"os"
 	"strings"
 	"sync"
 
@@ -32,6 +34,17 @@ var sampleConfig = `
   ## Use SSL but skip chain & host verification
   # insecure_skip_verify = false
 
+  ## Publish the metrics as a Sparkplug B edge node instead of using the
+  ## data format. The plugin sends NBIRTH, DBIRTH, NDATA, DDATA and NDEATH
+  ## messages. Each field is a Sparkplug metric named
+  ## <measurement>/<tag>=<value>/<field>. The device tag value selects the
+  ## device. Metrics without the tag belong to the edge node. The edge node
+  ## id defaults to the hostname.
+  # sparkplug_b = false
+  # sparkplug_group_id = "telegraf"
+  # sparkplug_edge_node_id = ""
+  # sparkplug_device_tag = ""
+
   ## Data format to output.
   ## Each data format has it's own unique set of configuration options, read
   ## more about them here:
@@ -57,8 +70,17 @@ type MQTT struct {
 	// Use SSL but skip chain & host verification
 	InsecureSkipVerify bool
 
-	client paho.Client
-	opts   *paho.ClientOptions
+	// SparkplugB publishes the metrics as a Sparkplug B edge node, with ids
+	// SparkplugGroupID and SparkplugEdgeNodeID. The value of
+	// SparkplugDeviceTag selects the device.
+	SparkplugB          bool   `toml:"sparkplug_b"`
+	SparkplugGroupID    string `toml:"sparkplug_group_id"`
+	SparkplugEdgeNodeID string `toml:"sparkplug_edge_node_id"`
+	SparkplugDeviceTag  string `toml:"sparkplug_device_tag"`
+
+	client    paho.Client
+	opts      *paho.ClientOptions
+	sparkplug *sparkplug
 
 	serializer serializers.Serializer
 
@@ -72,6 +94,11 @@ func (m *MQTT) Connect() error {
 	if m.QoS > 2 || m.QoS < 0 {
 		return fmt.Errorf("MQTT Output, invalid QoS value: %d", m.QoS)
 	}
+	if m.SparkplugB {
+		if err = m.initSparkplug(); err != nil {
+			return err
+		}
+	}
 
 	m.opts, err = m.createOpts()
 	if err != nil {
@@ -91,6 +118,15 @@ func (m *MQTT) SetSerializer(serializer serializers.Serializer) {
 }
 
 func (m *MQTT) Close() error {
+	m.Lock()
+	defer m.Unlock()
+	if m.sparkplug != nil && m.client.IsConnected() {
+		// the broker does not publish the will on a clean disconnect
+		death := m.sparkplug.death()
+		if err := m.publish(death.topic, death.payload, 1); err != nil {
+			log.Printf("MQTT Output, could not publish NDEATH: %s", err)
+		}
+	}
 	if m.client.IsConnected() {
 		m.client.Disconnect(20)
 	}
@@ -111,6 +147,9 @@ func (m *MQTT) Write(metrics []telegraf.Metric) error {
 	if len(metrics) == 0 {
 		return nil
 	}
+	if m.sparkplug != nil {
+		return m.writeSparkplug(metrics)
+	}
 	hostname, ok := metrics[0].Tags()["host"]
 	if !ok {
 		hostname = ""
@@ -135,7 +174,7 @@ func (m *MQTT) Write(metrics []telegraf.Metric) error {
 		}
 
 		for _, value := range values {
-			err = m.publish(topic, value)
+			err = m.publish(topic, value, m.QoS)
 			if err != nil {
 				return fmt.Errorf("Could not write to MQTT server, %s", err)
 			}
@@ -145,8 +184,8 @@ func (m *MQTT) Write(metrics []telegraf.Metric) error {
 	return nil
 }
 
-func (m *MQTT) publish(topic, body string) error {
-	token := m.client.Publish(topic, byte(m.QoS), false, body)
+func (m *MQTT) publish(topic string, body interface{}, qos int) error {
+	token := m.client.Publish(topic, byte(qos), false, body)
 	token.Wait()
 	if token.Error() != nil {
 		return token.Error()
@@ -189,9 +228,82 @@ func (m *MQTT) createOpts() (*paho.ClientOptions, error) {
 		opts.AddBroker(server)
 	}
 	opts.SetAutoReconnect(true)
+	if m.sparkplug != nil {
+		death := m.sparkplug.death()
+		opts.SetBinaryWill(death.topic, death.payload, 1, false)
+		opts.SetOnConnectHandler(m.onSparkplugConnect)
+	}
 	return opts, nil
 }
 
+// initSparkplug starts a new Sparkplug B session. Each connection uses the
+// next bdSeq in its will.
+func (m *MQTT) initSparkplug() error {
+	if m.SparkplugGroupID == "" {
+		return fmt.Errorf("MQTT Output, sparkplug_group_id must be set")
+	}
+	edgeNodeID := m.SparkplugEdgeNodeID
+	if edgeNodeID == "" {
+		var err error
+		if edgeNodeID, err = os.Hostname(); err != nil {
+			return err
+		}
+	}
+	for _, id := range []string{m.SparkplugGroupID, edgeNodeID} {
+		if strings.ContainsAny(id, "/+#") {
+			return fmt.Errorf("MQTT Output, invalid Sparkplug B id %q", id)
+		}
+	}
+	if m.sparkplug == nil {
+		m.sparkplug = newSparkplug(m.SparkplugGroupID, edgeNodeID,
+			m.SparkplugDeviceTag)
+	} else {
+		m.sparkplug.bdSeq = (m.sparkplug.bdSeq + 1) % 256
+	}
+	m.sparkplug.rebirth()
+	return nil
+}
+
+// onSparkplugConnect subscribes to the rebirth commands of the edge node on
+// each connection. The next write publishes the births again, as host
+// applications see a new session.
+func (m *MQTT) onSparkplugConnect(c paho.Client) {
+	m.Lock()
+	m.sparkplug.rebirth()
+	topic := m.sparkplug.topic("NCMD", "")
+	m.Unlock()
+
+	token := c.Subscribe(topic, 0, func(_ paho.Client, msg paho.Message) {
+		rebirth, err := isRebirth(msg.Payload())
+		if err != nil {
+			log.Printf("MQTT Output, NCMD: %s", err)
+			return
+		}
+		if rebirth {
+			m.Lock()
+			m.sparkplug.rebirth()
+			m.Unlock()
+		}
+	})
+	if token.Wait() && token.Error() != nil {
+		log.Printf("MQTT Output, could not subscribe to %s: %s", topic,
+			token.Error())
+	}
+}
+
+// writeSparkplug publishes the Sparkplug B messages for the metrics. If a
+// publish fails, the next write publishes the births again and restarts the
+// sequence numbers.
+func (m *MQTT) writeSparkplug(metrics []telegraf.Metric) error {
+	for _, msg := range m.sparkplug.messages(metrics) {
+		if err := m.publish(msg.topic, msg.payload, m.QoS); err != nil {
+			m.sparkplug.rebirth()
+			return fmt.Errorf("Could not write to MQTT server, %s", err)
+		}
+	}
+	return nil
+}
+
 func init() {
 	outputs.Add("mqtt", func() telegraf.Output {
 		return &MQTT{}
@@ -0,0 +1,336 @@
+package mqtt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// Sparkplug B metric data types.
+const (
+	sparkplugInt64   = 4
+	sparkplugUInt64  = 8
+	sparkplugDouble  = 10
+	sparkplugBoolean = 11
+	sparkplugString  = 12
+)
+
+// Names of the Sparkplug B edge node metrics.
+const (
+	sparkplugBdSeq   = "bdSeq"
+	sparkplugRebirth = "Node Control/Rebirth"
+)
+
+// sparkplugMessage is a Sparkplug B message with its topic and payload.
+type sparkplugMessage struct {
+	topic   string
+	payload []byte
+}
+
+// sparkplugMetric is a metric of an edge node or a device. It keeps its
+// alias and its last value.
+type sparkplugMetric struct {
+	name      string
+	alias     uint64
+	datatype  uint32
+	value     interface{}
+	timestamp int64
+}
+
+// sparkplugDevice is a device, or the edge node when the id is empty. names
+// lists its metrics in the order they were added.
+type sparkplugDevice struct {
+	id      string
+	metrics map[string]*sparkplugMetric
+	names   []string
+	// born is false after a new metric or a rebirth, until the birth of
+	// the device is published again
+	born bool
+}
+
+// sparkplug is the state of a Sparkplug B edge node. It holds the session
+// and message sequence numbers, and the aliases and values of the node and
+// device metrics, which the births need.
+type sparkplug struct {
+	groupID    string
+	edgeNodeID string
+	deviceTag  string
+
+	bdSeq     uint64
+	seq       uint64
+	nextAlias uint64
+
+	node      *sparkplugDevice
+	devices   map[string]*sparkplugDevice
+	deviceIDs []string
+}
+
+func newSparkplug(groupID, edgeNodeID, deviceTag string) *sparkplug {
+	return &sparkplug{
+		groupID:    groupID,
+		edgeNodeID: edgeNodeID,
+		deviceTag:  deviceTag,
+		nextAlias:  1,
+		node:       &sparkplugDevice{metrics: make(map[string]*sparkplugMetric)},
+		devices:    make(map[string]*sparkplugDevice),
+	}
+}
+
+func (s *sparkplug) topic(messageType, deviceID string) string {
+	topic := "spBv1.0/" + s.groupID + "/" + messageType + "/" + s.edgeNodeID
+	if deviceID != "" {
+		topic += "/" + deviceID
+	}
+	return topic
+}
+
+// rebirth makes the next messages publish the births of the edge node and
+// its devices again. It is used for a new session or a rebirth command.
+func (s *sparkplug) rebirth() {
+	s.node.born = false
+}
+
+// death returns the NDEATH for the bdSeq session. It is also the will of
+// the connection.
+func (s *sparkplug) death() sparkplugMessage {
+	e := &protowire.Encoder{}
+	e.Varint(1, uint64(time.Now().UnixNano()/1e6))
+	s.metric(e, &sparkplugMetric{name: sparkplugBdSeq,
+		datatype: sparkplugInt64, value: int64(s.bdSeq)}, true)
+	return sparkplugMessage{s.topic("NDEATH", ""), e.Buf}
+}
+
+// messages returns the messages for the metrics. The births of the edge
+// node and of devices with new metrics come first, then an NDATA or DDATA
+// for each metric. Each field is a Sparkplug B metric named
+// <measurement>/<tag>=<value>/.../<field>, without the device tag. The
+// device tag value is the device id, and metrics without it belong to the
+// edge node.
+func (s *sparkplug) messages(metrics []telegraf.Metric) []sparkplugMessage {
+	type data struct {
+		device  *sparkplugDevice
+		metrics []*sparkplugMetric
+		values  []interface{}
+		time    int64
+	}
+	var datas []data
+	for _, metric := range metrics {
+		device := s.device(metric.Tags()[s.deviceTag])
+		prefix := metricPrefix(metric, s.deviceTag)
+		d := data{device: device, time: metric.UnixNano() / 1e6}
+		fields := metric.Fields()
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			datatype, value, ok := sparkplugValue(fields[name])
+			if !ok {
+				continue
+			}
+			m := device.metric(prefix+name, datatype, &s.nextAlias)
+			m.value, m.timestamp = value, d.time
+			d.metrics = append(d.metrics, m)
+			d.values = append(d.values, value)
+		}
+		if len(d.metrics) != 0 {
+			datas = append(datas, d)
+		}
+	}
+
+	var messages []sparkplugMessage
+	now := time.Now().UnixNano() / 1e6
+	if !s.node.born {
+		// the NBIRTH starts the sequence, followed by the births of all
+		// devices
+		s.seq = 0
+		e := &protowire.Encoder{}
+		e.Varint(1, uint64(now))
+		s.metric(e, &sparkplugMetric{name: sparkplugBdSeq,
+			datatype: sparkplugInt64, value: int64(s.bdSeq), timestamp: now},
+			true)
+		s.metric(e, &sparkplugMetric{name: sparkplugRebirth,
+			datatype: sparkplugBoolean, value: false, timestamp: now}, true)
+		for _, name := range s.node.names {
+			s.metric(e, s.node.metrics[name], true)
+		}
+		e.Varint(3, s.seq)
+		messages = append(messages, sparkplugMessage{s.topic("NBIRTH", ""),
+			e.Buf})
+		s.node.born = true
+		for _, device := range s.devices {
+			device.born = false
+		}
+	}
+	for _, id := range s.deviceIDs {
+		device := s.devices[id]
+		if device.born {
+			continue
+		}
+		e := &protowire.Encoder{}
+		e.Varint(1, uint64(now))
+		for _, name := range device.names {
+			s.metric(e, device.metrics[name], true)
+		}
+		e.Varint(3, s.nextSeq())
+		messages = append(messages, sparkplugMessage{s.topic("DBIRTH", id),
+			e.Buf})
+		device.born = true
+	}
+
+	for _, d := range datas {
+		e := &protowire.Encoder{}
+		e.Varint(1, uint64(d.time))
+		for i, m := range d.metrics {
+			s.metric(e, &sparkplugMetric{alias: m.alias, datatype: m.datatype,
+				value: d.values[i], timestamp: d.time}, false)
+		}
+		e.Varint(3, s.nextSeq())
+		messageType := "DDATA"
+		if d.device == s.node {
+			messageType = "NDATA"
+		}
+		messages = append(messages, sparkplugMessage{
+			s.topic(messageType, d.device.id), e.Buf})
+	}
+	return messages
+}
+
+func (s *sparkplug) nextSeq() uint64 {
+	s.seq = (s.seq + 1) % 256
+	return s.seq
+}
+
+// device returns the device with an id, or the edge node if id is empty.
+func (s *sparkplug) device(id string) *sparkplugDevice {
+	if id == "" {
+		return s.node
+	}
+	device, ok := s.devices[id]
+	if !ok {
+		device = &sparkplugDevice{id: id,
+			metrics: make(map[string]*sparkplugMetric)}
+		s.devices[id] = device
+		s.deviceIDs = append(s.deviceIDs, id)
+	}
+	return device
+}
+
+// metric returns the metric with a name, and adds it with a new alias if
+// missing. A new metric or data type requires a new device birth.
+func (d *sparkplugDevice) metric(name string, datatype uint32,
+	nextAlias *uint64) *sparkplugMetric {
+	m, ok := d.metrics[name]
+	if !ok {
+		m = &sparkplugMetric{name: name, alias: *nextAlias}
+		*nextAlias++
+		d.metrics[name] = m
+		d.names = append(d.names, name)
+	}
+	if m.datatype != datatype {
+		m.datatype = datatype
+		d.born = false
+	}
+	return m
+}
+
+// metric writes a payload Metric. Births carry the name and alias, and
+// data messages only the alias.
+func (s *sparkplug) metric(e *protowire.Encoder, m *sparkplugMetric, birth bool) {
+	e.Message(2, func(e *protowire.Encoder) {
+		if birth {
+			e.String(1, m.name)
+		}
+		if m.alias != 0 {
+			e.Varint(2, m.alias)
+		}
+		if m.timestamp != 0 {
+			e.Varint(3, uint64(m.timestamp))
+		}
+		e.Varint(4, uint64(m.datatype))
+		switch v := m.value.(type) {
+		case int64:
+			e.Varint(11, uint64(v))
+		case uint64:
+			e.Varint(11, v)
+		case float64:
+			e.Double(13, v)
+		case bool:
+			b := uint64(0)
+			if v {
+				b = 1
+			}
+			e.Varint(14, b)
+		case string:
+			e.String(15, v)
+		}
+	})
+}
+
+// metricPrefix returns the name prefix for the fields of a metric. It is
+// the measurement and the tags other than the device tag.
+func metricPrefix(metric telegraf.Metric, deviceTag string) string {
+	tags := metric.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if k != deviceTag {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteString(metric.Name())
+	b.WriteByte('/')
+	for _, k := range keys {
+		b.WriteString(k + "=" + tags[k] + "/")
+	}
+	return b.String()
+}
+
+// sparkplugValue returns the data type and value of a field. NaNs are
+// rejected, as Sparkplug B cannot represent them.
+func sparkplugValue(value interface{}) (uint32, interface{}, bool) {
+	switch v := value.(type) {
+	case float64:
+		if math.IsNaN(v) {
+			return 0, nil, false
+		}
+		return sparkplugDouble, v, true
+	case int64:
+		return sparkplugInt64, v, true
+	case uint64:
+		return sparkplugUInt64, v, true
+	case bool:
+		return sparkplugBoolean, v, true
+	case string:
+		return sparkplugString, v, true
+	}
+	return 0, nil, false
+}
+
+// isRebirth returns whether the payload of an NCMD is a rebirth command.
+func isRebirth(payload []byte) (bool, error) {
+	p, err := protowire.Fields(payload)
+	if err != nil {
+		return false, fmt.Errorf("invalid payload: %s", err)
+	}
+	for _, b := range p[2] {
+		m, err := protowire.Fields(b.([]byte))
+		if err != nil {
+			return false, fmt.Errorf("invalid payload: %s", err)
+		}
+		if len(m[1]) == 0 || string(m[1][0].([]byte)) != sparkplugRebirth {
+			continue
+		}
+		if len(m[14]) != 0 && m[14][0].(uint64) != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
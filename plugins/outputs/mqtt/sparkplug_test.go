@@ -0,0 +1,191 @@
+package mqtt
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// payload is a decoded Sparkplug B payload.
+type payload struct {
+	timestamp uint64
+	seq       interface{}
+	metrics   []map[uint64][]interface{}
+}
+
+func decodePayload(t *testing.T, b []byte) payload {
+	p, err := protowire.Fields(b)
+	require.NoError(t, err)
+	var decoded payload
+	if len(p[1]) != 0 {
+		decoded.timestamp = p[1][0].(uint64)
+	}
+	if len(p[3]) != 0 {
+		decoded.seq = p[3][0]
+	}
+	for _, m := range p[2] {
+		metric, err := protowire.Fields(m.([]byte))
+		require.NoError(t, err)
+		decoded.metrics = append(decoded.metrics, metric)
+	}
+	return decoded
+}
+
+func topics(messages []sparkplugMessage) []string {
+	var topics []string
+	for _, msg := range messages {
+		topics = append(topics, msg.topic)
+	}
+	return topics
+}
+
+func newTestMetric(t *testing.T, tags map[string]string,
+	fields map[string]interface{}) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu", tags, fields,
+		time.Unix(1465839830, 0))
+	require.NoError(t, err)
+	return m
+}
+
+func TestSparkplugMessages(t *testing.T) {
+	s := newSparkplug("telegraf", "edge1", "device")
+	s.bdSeq = 3
+	s.rebirth()
+
+	metrics := []telegraf.Metric{
+		newTestMetric(t, map[string]string{"cpu": "cpu0"},
+			map[string]interface{}{"usage": 42.5}),
+		newTestMetric(t, map[string]string{"device": "plc1"},
+			map[string]interface{}{"running": true, "count": int64(-2)}),
+	}
+	messages := s.messages(metrics)
+	require.Equal(t, []string{
+		"spBv1.0/telegraf/NBIRTH/edge1",
+		"spBv1.0/telegraf/DBIRTH/edge1/plc1",
+		"spBv1.0/telegraf/NDATA/edge1",
+		"spBv1.0/telegraf/DDATA/edge1/plc1",
+	}, topics(messages))
+
+	// the NBIRTH has bdSeq, the rebirth control and the node metrics
+	nbirth := decodePayload(t, messages[0].payload)
+	assert.Equal(t, uint64(0), nbirth.seq)
+	require.Len(t, nbirth.metrics, 3)
+	assert.Equal(t, []interface{}{[]byte("bdSeq")}, nbirth.metrics[0][1])
+	assert.Equal(t, []interface{}{uint64(3)}, nbirth.metrics[0][11])
+	assert.Equal(t, []interface{}{[]byte("Node Control/Rebirth")},
+		nbirth.metrics[1][1])
+	assert.Equal(t, []interface{}{uint64(0)}, nbirth.metrics[1][14])
+	assert.Equal(t, []interface{}{[]byte("cpu/cpu=cpu0/usage")},
+		nbirth.metrics[2][1])
+	assert.Equal(t, []interface{}{uint64(1)}, nbirth.metrics[2][2])
+	assert.Equal(t, []interface{}{uint64(sparkplugDouble)},
+		nbirth.metrics[2][4])
+	assert.Equal(t, []interface{}{math.Float64bits(42.5)},
+		nbirth.metrics[2][13])
+
+	// the DBIRTH has the names and aliases of the device metrics
+	dbirth := decodePayload(t, messages[1].payload)
+	assert.Equal(t, uint64(1), dbirth.seq)
+	require.Len(t, dbirth.metrics, 2)
+	assert.Equal(t, []interface{}{[]byte("cpu/count")}, dbirth.metrics[0][1])
+	assert.Equal(t, []interface{}{uint64(2)}, dbirth.metrics[0][2])
+	assert.Equal(t, []interface{}{uint64(sparkplugInt64)},
+		dbirth.metrics[0][4])
+	assert.Equal(t, []interface{}{uint64(math.MaxUint64 - 1)},
+		dbirth.metrics[0][11])
+	assert.Equal(t, []interface{}{[]byte("cpu/running")},
+		dbirth.metrics[1][1])
+	assert.Equal(t, []interface{}{uint64(3)}, dbirth.metrics[1][2])
+
+	// data messages only use the aliases
+	ndata := decodePayload(t, messages[2].payload)
+	assert.Equal(t, uint64(2), ndata.seq)
+	assert.Equal(t, uint64(1465839830000), ndata.timestamp)
+	require.Len(t, ndata.metrics, 1)
+	assert.Empty(t, ndata.metrics[0][1])
+	assert.Equal(t, []interface{}{uint64(1)}, ndata.metrics[0][2])
+	ddata := decodePayload(t, messages[3].payload)
+	assert.Equal(t, uint64(3), ddata.seq)
+	require.Len(t, ddata.metrics, 2)
+	assert.Equal(t, []interface{}{uint64(3)}, ddata.metrics[1][2])
+	assert.Equal(t, []interface{}{uint64(1)}, ddata.metrics[1][14])
+
+	// births are published once, and again for devices with new metrics
+	messages = s.messages(metrics[1:])
+	require.Equal(t, []string{"spBv1.0/telegraf/DDATA/edge1/plc1"},
+		topics(messages))
+	assert.Equal(t, uint64(4), decodePayload(t, messages[0].payload).seq)
+	messages = s.messages([]telegraf.Metric{
+		newTestMetric(t, map[string]string{"device": "plc1"},
+			map[string]interface{}{"mode": "auto"}),
+	})
+	require.Equal(t, []string{
+		"spBv1.0/telegraf/DBIRTH/edge1/plc1",
+		"spBv1.0/telegraf/DDATA/edge1/plc1",
+	}, topics(messages))
+	dbirth = decodePayload(t, messages[0].payload)
+	require.Len(t, dbirth.metrics, 3)
+	assert.Equal(t, []interface{}{[]byte("cpu/mode")}, dbirth.metrics[2][1])
+	assert.Equal(t, []interface{}{[]byte("auto")}, dbirth.metrics[2][15])
+
+	// a rebirth restarts the sequence with the births of all metrics
+	s.rebirth()
+	messages = s.messages(nil)
+	require.Equal(t, []string{
+		"spBv1.0/telegraf/NBIRTH/edge1",
+		"spBv1.0/telegraf/DBIRTH/edge1/plc1",
+	}, topics(messages))
+	assert.Equal(t, uint64(0), decodePayload(t, messages[0].payload).seq)
+	assert.Equal(t, uint64(1), decodePayload(t, messages[1].payload).seq)
+}
+
+func TestSparkplugSeqWraps(t *testing.T) {
+	s := newSparkplug("telegraf", "edge1", "")
+	metric := newTestMetric(t, nil, map[string]interface{}{"value": 1.0})
+	s.messages([]telegraf.Metric{metric})
+	for i := 0; i < 254; i++ {
+		s.messages([]telegraf.Metric{metric})
+	}
+	messages := s.messages([]telegraf.Metric{metric})
+	assert.Equal(t, uint64(0), decodePayload(t, messages[0].payload).seq)
+}
+
+func TestSparkplugDeath(t *testing.T) {
+	s := newSparkplug("telegraf", "edge1", "")
+	s.bdSeq = 7
+	death := s.death()
+	assert.Equal(t, "spBv1.0/telegraf/NDEATH/edge1", death.topic)
+	p := decodePayload(t, death.payload)
+	assert.Nil(t, p.seq)
+	require.Len(t, p.metrics, 1)
+	assert.Equal(t, []interface{}{[]byte("bdSeq")}, p.metrics[0][1])
+	assert.Equal(t, []interface{}{uint64(7)}, p.metrics[0][11])
+}
+
+func TestIsRebirth(t *testing.T) {
+	ncmd := func(name string, value bool) []byte {
+		s := newSparkplug("telegraf", "edge1", "")
+		e := &protowire.Encoder{}
+		e.Varint(1, 1465839830000)
+		s.metric(e, &sparkplugMetric{name: name, datatype: sparkplugBoolean,
+			value: value}, true)
+		return e.Buf
+	}
+	rebirth, err := isRebirth(ncmd("Node Control/Rebirth", true))
+	require.NoError(t, err)
+	assert.True(t, rebirth)
+	rebirth, err = isRebirth(ncmd("Node Control/Rebirth", false))
+	require.NoError(t, err)
+	assert.False(t, rebirth)
+	rebirth, err = isRebirth(ncmd("Node Control/Reboot", true))
+	require.NoError(t, err)
+	assert.False(t, rebirth)
+	_, err = isRebirth([]byte{0xff})
+	assert.Error(t, err)
+}
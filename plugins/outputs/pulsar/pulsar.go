@@ -0,0 +1,564 @@
+package pulsar
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/serializers"
+)
+
+// compressions maps the compression option to the compression type of the
+// producers.
+var compressions = map[string]string{
+	"":       "",
+	"none":   "",
+	"lz4":    "LZ4",
+	"zlib":   "ZLIB",
+	"zstd":   "ZSTD",
+	"snappy": "SNAPPY",
+}
+
+type Pulsar struct {
+	// URL is the Pulsar web service URL, such as ws://localhost:8080. It
+	// serves the WebSocket API and the admin REST API.
+	URL string `toml:"url"`
+	// Topic is a Go template executed with each metric to get its topic,
+	// such as "persistent://public/default/telegraf-{{.Tags.region}}".
+	Topic string
+	// KeyTag is the tag used as the message key. Partitioned topics route
+	// messages by key.
+	KeyTag       string `toml:"key_tag"`
+	ProducerName string `toml:"producer_name"`
+
+	// Schema is the message schema. SchemaBytes sends the serialized data
+	// format. SchemaJSON and SchemaAvro send a record for each metric, and
+	// the schema is uploaded to the topics if UploadSchema is set.
+	Schema       string
+	UploadSchema bool `toml:"upload_schema"`
+
+	BatchingEnabled         bool              `toml:"batching_enabled"`
+	BatchingMaxMessages     int               `toml:"batching_max_messages"`
+	BatchingMaxPublishDelay internal.Duration `toml:"batching_max_publish_delay"`
+	Compression             string
+
+	// Token authenticates the producers and the admin requests. Otherwise,
+	// if OAuth2IssuerURL is set, a token is requested from it with the
+	// OAuth2ClientID and OAuth2ClientSecret client credentials.
+	Token              string
+	OAuth2IssuerURL    string `toml:"oauth2_issuer_url"`
+	OAuth2ClientID     string `toml:"oauth2_client_id"`
+	OAuth2ClientSecret string `toml:"oauth2_client_secret"`
+	OAuth2Audience     string `toml:"oauth2_audience"`
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	serializer serializers.Serializer
+	topic      *template.Template
+	url        *url.URL
+	tlsConfig  *tls.Config
+	client     *http.Client
+
+	mu sync.Mutex
+	// producers are the open producers, by topic
+	producers map[string]*producer
+	// schemas are the topics whose schema was uploaded
+	schemas map[string]bool
+	// token is the OAuth2 token, valid until tokenExpiry
+	token       string
+	tokenExpiry time.Time
+}
+
+var sampleConfig = `
+  ## Pulsar web service URL, which serves the WebSocket and admin APIs
+  url = "ws://localhost:8080"
+  ## Topic to write to. This is a Go template executed with each metric,
+  ## such as "persistent://public/default/telegraf-{{.Tags.region}}"
+  topic = "persistent://public/default/telegraf"
+  ## Tag to use as the message key, which selects the partition
+  # key_tag = "host"
+  # producer_name = ""
+
+  ## Message schema. "bytes" sends the data format below. "json" and "avro"
+  ## send a record with the name, tags, fields and timestamp of each metric,
+  ## and upload the record schema to the topics.
+  # schema = "bytes"
+  # upload_schema = true
+
+  ## Batching and compression of the messages. The compression is one of
+  ## none, lz4, zlib, zstd or snappy.
+  # batching_enabled = true
+  # batching_max_messages = 1000
+  # batching_max_publish_delay = "10ms"
+  # compression = "none"
+
+  ## Authenticate with a token, or with OAuth2 client credentials
+  # token = ""
+  # oauth2_issuer_url = "https://auth.example.com"
+  # oauth2_client_id = ""
+  # oauth2_client_secret = ""
+  # oauth2_audience = "urn:sn:pulsar:example:instance"
+
+  ## Timeout to connect, and to wait for messages to be acknowledged
+  # timeout = "10s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+
+  ## Data format to output, for the "bytes" schema.
+  ## Each data format has it's own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (p *Pulsar) SetSerializer(serializer serializers.Serializer) {
+	p.serializer = serializer
+}
+
+func (p *Pulsar) Connect() error {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		return fmt.Errorf("Pulsar: invalid url %q: %s", p.URL, err)
+	}
+	if u.Scheme != "ws" && u.Scheme != "wss" {
+		return fmt.Errorf("Pulsar: invalid url %q, must be ws or wss", p.URL)
+	}
+	switch p.Schema {
+	case "", SchemaBytes:
+		if p.serializer == nil {
+			return fmt.Errorf("Pulsar: the bytes schema needs a data format")
+		}
+	case SchemaJSON, SchemaAvro:
+	default:
+		return fmt.Errorf("Pulsar: invalid schema %q, must be bytes, json "+
+			"or avro", p.Schema)
+	}
+	if _, ok := compressions[strings.ToLower(p.Compression)]; !ok {
+		return fmt.Errorf("Pulsar: invalid compression %q, must be none, "+
+			"lz4, zlib, zstd or snappy", p.Compression)
+	}
+	if p.topic, err = template.New("topic").Option("missingkey=zero").Parse(
+		p.Topic); err != nil {
+		return fmt.Errorf("Pulsar: invalid topic: %s", err)
+	}
+	if p.tlsConfig, err = internal.GetTLSConfig(p.SSLCert, p.SSLKey,
+		p.SSLCA, p.InsecureSkipVerify); err != nil {
+		return err
+	}
+	p.url = u
+	p.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: p.tlsConfig,
+		},
+		Timeout: p.Timeout.Duration,
+	}
+	p.producers = make(map[string]*producer)
+	p.schemas = make(map[string]bool)
+	return nil
+}
+
+func (p *Pulsar) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for topic, producer := range p.producers {
+		producer.conn.Close()
+		delete(p.producers, topic)
+	}
+	return nil
+}
+
+func (p *Pulsar) Description() string {
+	return "Configuration for the Pulsar server to send metrics to"
+}
+
+func (p *Pulsar) SampleConfig() string {
+	return sampleConfig
+}
+
+// message is a message of the WebSocket API.
+type message struct {
+	Payload string `json:"payload"`
+	Context string `json:"context"`
+	Key     string `json:"key,omitempty"`
+}
+
+// ack is the acknowledgement of a message.
+type ack struct {
+	Result    string `json:"result"`
+	MessageID string `json:"messageId"`
+	ErrorMsg  string `json:"errorMsg"`
+	Context   string `json:"context"`
+}
+
+func (p *Pulsar) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// the messages by topic, in the order of the metrics
+	messages := make(map[string][]*message)
+	var topics []string
+	for _, metric := range metrics {
+		var buf bytes.Buffer
+		if err := p.topic.Execute(&buf, metric); err != nil {
+			return fmt.Errorf("Pulsar: topic for metric %s: %s", metric.Name(),
+				err)
+		}
+		topic, err := normalizeTopic(buf.String())
+		if err != nil {
+			return fmt.Errorf("Pulsar: %s", err)
+		}
+		payloads, err := p.encode(metric)
+		if err != nil {
+			return fmt.Errorf("Pulsar: %s", err)
+		}
+		if _, ok := messages[topic]; !ok {
+			topics = append(topics, topic)
+		}
+		for _, payload := range payloads {
+			messages[topic] = append(messages[topic], &message{
+				Payload: base64.StdEncoding.EncodeToString(payload),
+				Key:     metric.Tags()[p.KeyTag],
+			})
+		}
+	}
+
+	for _, topic := range topics {
+		if err := p.send(topic, messages[topic]); err != nil {
+			return fmt.Errorf("Pulsar: sending to topic %s failed: %s", topic,
+				err)
+		}
+	}
+	return nil
+}
+
+// encode returns the message payloads for a metric.
+func (p *Pulsar) encode(metric telegraf.Metric) ([][]byte, error) {
+	switch p.Schema {
+	case SchemaJSON:
+		payload, err := encodeJSON(metric)
+		return [][]byte{payload}, err
+	case SchemaAvro:
+		return [][]byte{encodeAvro(metric)}, nil
+	}
+	values, err := p.serializer.Serialize(metric)
+	if err != nil {
+		return nil, err
+	}
+	payloads := make([][]byte, len(values))
+	for i, v := range values {
+		payloads[i] = []byte(v)
+	}
+	return payloads, nil
+}
+
+// send sends messages to a topic, and waits until they are acknowledged.
+func (p *Pulsar) send(topic string, messages []*message) error {
+	if p.Schema != "" && p.Schema != SchemaBytes && p.UploadSchema &&
+		!p.schemas[topic] {
+		if err := p.uploadSchema(topic); err != nil {
+			return err
+		}
+		p.schemas[topic] = true
+	}
+	producer, ok := p.producers[topic]
+	if !ok {
+		var err error
+		if producer, err = p.newProducer(topic); err != nil {
+			return err
+		}
+		p.producers[topic] = producer
+	}
+	if err := producer.send(messages, p.Timeout.Duration); err != nil {
+		producer.conn.Close()
+		delete(p.producers, topic)
+		return err
+	}
+	return nil
+}
+
+// producer is a WebSocket API producer for one topic.
+type producer struct {
+	conn *websocket.Conn
+	// sequence is the context of the last message
+	sequence uint64
+}
+
+func (p *Pulsar) newProducer(topic string) (*producer, error) {
+	u := *p.url
+	u.Path = strings.TrimRight(u.Path, "/") + "/ws/v2/producer/" +
+		strings.Replace(topic, "://", "/", 1)
+	params := url.Values{}
+	if p.ProducerName != "" {
+		params.Set("producerName", p.ProducerName)
+	}
+	params.Set("batchingEnabled", strconv.FormatBool(p.BatchingEnabled))
+	if p.BatchingMaxMessages > 0 {
+		params.Set("batchingMaxMessages", strconv.Itoa(p.BatchingMaxMessages))
+	}
+	if p.BatchingMaxPublishDelay.Duration > 0 {
+		params.Set("batchingMaxPublishDelay", strconv.FormatInt(int64(
+			p.BatchingMaxPublishDelay.Duration/time.Millisecond), 10))
+	}
+	if c := compressions[strings.ToLower(p.Compression)]; c != "" {
+		params.Set("compressionType", c)
+	}
+	if p.Timeout.Duration > 0 {
+		params.Set("sendTimeoutMillis", strconv.FormatInt(int64(
+			p.Timeout.Duration/time.Millisecond), 10))
+	}
+	u.RawQuery = params.Encode()
+
+	origin := "http://" + u.Host
+	config, err := websocket.NewConfig(u.String(), origin)
+	if err != nil {
+		return nil, err
+	}
+	token, err := p.authorization()
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		config.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	dialer := &net.Dialer{Timeout: p.Timeout.Duration}
+	var conn net.Conn
+	if u.Scheme == "wss" {
+		tlsConfig := p.tlsConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		config.TlsConfig = tlsConfig
+		conn, err = tls.DialWithDialer(dialer, "tcp", hostPort(&u, "443"),
+			tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", hostPort(&u, "80"))
+	}
+	if err != nil {
+		return nil, err
+	}
+	ws, err := websocket.NewClient(config, conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &producer{conn: ws}, nil
+}
+
+func (pr *producer) send(messages []*message, timeout time.Duration) error {
+	if timeout > 0 {
+		pr.conn.SetDeadline(time.Now().Add(timeout))
+	}
+	for _, m := range messages {
+		pr.sequence++
+		m.Context = strconv.FormatUint(pr.sequence, 10)
+		if err := websocket.JSON.Send(pr.conn, m); err != nil {
+			return err
+		}
+	}
+	// wait for the acknowledgements of this batch
+	last := pr.sequence
+	first := last - uint64(len(messages)) + 1
+	for n := 0; n < len(messages); {
+		var a ack
+		if err := websocket.JSON.Receive(pr.conn, &a); err != nil {
+			return err
+		}
+		sequence, err := strconv.ParseUint(a.Context, 10, 64)
+		if err != nil || sequence < first || sequence > last {
+			continue
+		}
+		if a.Result != "ok" {
+			return fmt.Errorf("%s: %s", a.Result, a.ErrorMsg)
+		}
+		n++
+	}
+	return nil
+}
+
+// uploadSchema uploads the metric record schema to a topic.
+func (p *Pulsar) uploadSchema(topic string) error {
+	u := *p.url
+	u.Scheme = "http"
+	if p.url.Scheme == "wss" {
+		u.Scheme = "https"
+	}
+	// the schema path is the topic name without its domain
+	u.Path = strings.TrimRight(u.Path, "/") + "/admin/v2/schemas/" +
+		topic[strings.Index(topic, "://")+3:] + "/schema"
+	body, _ := json.Marshal(map[string]interface{}{
+		"type":       strings.ToUpper(p.Schema),
+		"schema":     metricSchema,
+		"properties": map[string]string{},
+	})
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	token, err := p.authorization()
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("uploading the schema returned HTTP status %s: %s",
+			resp.Status, bytes.TrimSpace(b))
+	}
+	return nil
+}
+
+// authorization returns the token for the requests. It is an OAuth2 token
+// if an issuer is set, and empty if there is no authentication.
+func (p *Pulsar) authorization() (string, error) {
+	if p.OAuth2IssuerURL == "" {
+		return p.Token, nil
+	}
+	if p.token != "" && time.Now().Before(p.tokenExpiry) {
+		return p.token, nil
+	}
+
+	// find the token endpoint in the issuer metadata
+	var metadata struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+	issuer := strings.TrimRight(p.OAuth2IssuerURL, "/")
+	if err := p.getJSON("GET", issuer+
+		"/.well-known/openid-configuration", nil, &metadata); err != nil {
+		return "", fmt.Errorf("OAuth2 metadata from %s: %s", issuer, err)
+	}
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {p.OAuth2ClientID},
+		"client_secret": {p.OAuth2ClientSecret},
+	}
+	if p.OAuth2Audience != "" {
+		form.Set("audience", p.OAuth2Audience)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := p.getJSON("POST", metadata.TokenEndpoint,
+		strings.NewReader(form.Encode()), &token); err != nil {
+		return "", fmt.Errorf("OAuth2 token from %s: %s", issuer, err)
+	}
+	p.token = token.AccessToken
+	// the token is renewed a minute before its expiry
+	p.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn-60) *
+		time.Second)
+	return p.token, nil
+}
+
+func (p *Pulsar) getJSON(
+	method string,
+	u string,
+	body *strings.Reader,
+	v interface{},
+) error {
+	var req *http.Request
+	var err error
+	if body == nil {
+		req, err = http.NewRequest(method, u, nil)
+	} else {
+		req, err = http.NewRequest(method, u, body)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("HTTP status %s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// normalizeTopic returns the full name of a topic. Short names are in the
+// public tenant and the default namespace.
+func normalizeTopic(topic string) (string, error) {
+	if topic == "" {
+		return "", fmt.Errorf("empty topic")
+	}
+	if !strings.Contains(topic, "://") {
+		topic = "persistent://public/default/" + topic
+	}
+	i := strings.Index(topic, "://")
+	domain, name := topic[:i], topic[i+3:]
+	if domain != "persistent" && domain != "non-persistent" {
+		return "", fmt.Errorf("invalid topic %q, must be persistent or "+
+			"non-persistent", topic)
+	}
+	if strings.Count(name, "/") != 2 {
+		return "", fmt.Errorf("invalid topic %q, must have a tenant and a "+
+			"namespace", topic)
+	}
+	return topic, nil
+}
+
+func hostPort(u *url.URL, port string) string {
+	if _, _, err := net.SplitHostPort(u.Host); err == nil {
+		return u.Host
+	}
+	return net.JoinHostPort(u.Host, port)
+}
+
+func init() {
+	outputs.Add("pulsar", func() telegraf.Output {
+		return &Pulsar{
+			Topic:                   "persistent://public/default/telegraf",
+			Schema:                  SchemaBytes,
+			UploadSchema:            true,
+			BatchingEnabled:         true,
+			BatchingMaxMessages:     1000,
+			BatchingMaxPublishDelay: internal.Duration{Duration: 10 * time.Millisecond},
+			Timeout:                 internal.Duration{Duration: 10 * time.Second},
+		}
+	})
+}
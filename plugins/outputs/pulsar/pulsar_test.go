@@ -0,0 +1,249 @@
+package pulsar
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/websocket"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/serializers/influx"
+)
+
+// fakePulsar fakes the Pulsar web service. It serves WebSocket API
+// producers, admin API schemas, and an OAuth2 issuer.
+type fakePulsar struct {
+	*httptest.Server
+	mu       sync.Mutex
+	messages map[string][]message
+	queries  map[string]string
+	schemas  map[string]string
+	auth     []string
+	// result is the result of the acknowledgements
+	result string
+}
+
+func newFakePulsar() *fakePulsar {
+	f := &fakePulsar{
+		messages: make(map[string][]message),
+		queries:  make(map[string]string),
+		schemas:  make(map[string]string),
+		result:   "ok",
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws/v2/producer/", websocket.Handler(f.produce))
+	mux.HandleFunc("/admin/v2/schemas/", func(w http.ResponseWriter,
+		r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		f.mu.Lock()
+		f.schemas[r.URL.Path] = string(body)
+		f.auth = append(f.auth, r.Header.Get("Authorization"))
+		f.mu.Unlock()
+	})
+	mux.HandleFunc("/.well-known/openid-configuration", func(
+		w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"token_endpoint":"` + f.URL + `/oauth/token"}`))
+	})
+	mux.HandleFunc("/oauth/token", func(w http.ResponseWriter,
+		r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("client_secret") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"access_token":"oauth-token","expires_in":3600}`))
+	})
+	f.Server = httptest.NewServer(mux)
+	return f
+}
+
+func (f *fakePulsar) produce(ws *websocket.Conn) {
+	topic := strings.TrimPrefix(ws.Request().URL.Path, "/ws/v2/producer/")
+	f.mu.Lock()
+	f.queries[topic] = ws.Request().URL.RawQuery
+	f.auth = append(f.auth, ws.Request().Header.Get("Authorization"))
+	f.mu.Unlock()
+	for {
+		var m message
+		if err := websocket.JSON.Receive(ws, &m); err != nil {
+			return
+		}
+		f.mu.Lock()
+		f.messages[topic] = append(f.messages[topic], m)
+		a := ack{Result: f.result, MessageID: "CAAQAw==", Context: m.Context}
+		f.mu.Unlock()
+		if a.Result != "ok" {
+			a.ErrorMsg = "topic is full"
+		}
+		websocket.JSON.Send(ws, &a)
+	}
+}
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	m1, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "region": "eu"},
+		map[string]interface{}{"usage": 91.5, "count": int64(3)},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	m2, err := telegraf.NewMetric("mem",
+		map[string]string{"host": "web2"},
+		map[string]interface{}{"used": int64(42), "ok": true,
+			"state": "up"},
+		time.Unix(1465839840, 0))
+	require.NoError(t, err)
+	return []telegraf.Metric{m1, m2}
+}
+
+func newPulsar(url string) *Pulsar {
+	return &Pulsar{
+		URL:                     url,
+		Topic:                   "persistent://public/default/telegraf",
+		Schema:                  SchemaBytes,
+		UploadSchema:            true,
+		BatchingEnabled:         true,
+		BatchingMaxMessages:     1000,
+		BatchingMaxPublishDelay: internal.Duration{Duration: 10 * time.Millisecond},
+		Timeout:                 internal.Duration{Duration: 5 * time.Second},
+	}
+}
+
+func TestWriteAvro(t *testing.T) {
+	f := newFakePulsar()
+	defer f.Close()
+
+	p := newPulsar("ws" + strings.TrimPrefix(f.URL, "http"))
+	p.Topic = "telegraf-{{.Tags.region}}"
+	p.KeyTag = "host"
+	p.Schema = SchemaAvro
+	p.Compression = "lz4"
+	p.OAuth2IssuerURL = f.URL
+	p.OAuth2ClientID = "telegraf"
+	p.OAuth2ClientSecret = "secret"
+	require.NoError(t, p.Connect())
+	defer p.Close()
+	require.NoError(t, p.Write(testMetrics(t)))
+	require.NoError(t, p.Write(testMetrics(t)[:1]))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	// the template topics, expanded from short names
+	eu := "persistent/public/default/telegraf-eu"
+	none := "persistent/public/default/telegraf-"
+	require.Len(t, f.messages[eu], 2)
+	require.Len(t, f.messages[none], 1)
+	assert.Equal(t, "batchingEnabled=true&batchingMaxMessages=1000&"+
+		"batchingMaxPublishDelay=10&compressionType=LZ4&"+
+		"sendTimeoutMillis=5000", f.queries[eu])
+
+	// the schemas are uploaded once, and the requests authenticated
+	assert.Len(t, f.schemas, 2)
+	var schema struct {
+		Type   string `json:"type"`
+		Schema string `json:"schema"`
+	}
+	require.NoError(t, json.Unmarshal([]byte(
+		f.schemas["/admin/v2/schemas/public/default/telegraf-eu/schema"]),
+		&schema))
+	assert.Equal(t, "AVRO", schema.Type)
+	assert.Equal(t, metricSchema, schema.Schema)
+	for _, auth := range f.auth {
+		assert.Equal(t, "Bearer oauth-token", auth)
+	}
+
+	m := f.messages[eu][0]
+	assert.Equal(t, "web1", m.Key)
+	assert.Equal(t, "1", m.Context)
+	assert.Equal(t, "2", f.messages[eu][1].Context)
+	payload, err := base64.StdEncoding.DecodeString(m.Payload)
+	require.NoError(t, err)
+	expected := []byte{6, 'c', 'p', 'u',
+		// the tags
+		4, 8, 'h', 'o', 's', 't', 8, 'w', 'e', 'b', '1',
+		12, 'r', 'e', 'g', 'i', 'o', 'n', 4, 'e', 'u', 0,
+		// the fields, a long and a double
+		4, 10, 'c', 'o', 'u', 'n', 't', 0, 6,
+		10, 'u', 's', 'a', 'g', 'e', 2, 0, 0, 0, 0, 0, 0xe0, 0x56, 0x40, 0,
+	}
+	expected = appendLong(expected, 1465839830123)
+	assert.Equal(t, expected, payload)
+}
+
+func TestWriteJSON(t *testing.T) {
+	f := newFakePulsar()
+	defer f.Close()
+
+	p := newPulsar("ws" + strings.TrimPrefix(f.URL, "http"))
+	p.Schema = SchemaJSON
+	p.UploadSchema = false
+	p.Token = "token"
+	require.NoError(t, p.Connect())
+	defer p.Close()
+	require.NoError(t, p.Write(testMetrics(t)[1:]))
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	assert.Empty(t, f.schemas)
+	assert.Equal(t, []string{"Bearer token"}, f.auth)
+	payload, err := base64.StdEncoding.DecodeString(
+		f.messages["persistent/public/default/telegraf"][0].Payload)
+	require.NoError(t, err)
+	assert.Equal(t, `{"name":"mem","tags":{"host":"web2"},"fields":{"ok":`+
+		`true,"state":"up","used":42},"timestamp":1465839840000}`,
+		string(payload))
+}
+
+func TestWriteBytesError(t *testing.T) {
+	f := newFakePulsar()
+	defer f.Close()
+
+	p := newPulsar("ws" + strings.TrimPrefix(f.URL, "http"))
+	p.SetSerializer(&influx.InfluxSerializer{})
+	require.NoError(t, p.Connect())
+	defer p.Close()
+	require.NoError(t, p.Write(testMetrics(t)[:1]))
+
+	f.mu.Lock()
+	payload, err := base64.StdEncoding.DecodeString(
+		f.messages["persistent/public/default/telegraf"][0].Payload)
+	f.result = "send-error:3"
+	f.mu.Unlock()
+	require.NoError(t, err)
+	assert.Equal(t, "cpu,host=web1,region=eu count=3i,usage=91.5 "+
+		"1465839830123456789", string(payload))
+
+	// the producers of errors are closed
+	err = p.Write(testMetrics(t)[:1])
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "topic is full")
+	assert.Empty(t, p.producers)
+}
+
+func TestConnectInvalid(t *testing.T) {
+	p := newPulsar("http://localhost:8080")
+	p.Schema = SchemaJSON
+	assert.Error(t, p.Connect())
+	p = newPulsar("ws://localhost:8080")
+	assert.Error(t, p.Connect())
+	p = newPulsar("ws://localhost:8080")
+	p.Schema = "protobuf"
+	assert.Error(t, p.Connect())
+	p = newPulsar("ws://localhost:8080")
+	p.Schema = SchemaJSON
+	p.Compression = "gzip"
+	assert.Error(t, p.Connect())
+
+	_, err := normalizeTopic("persistent://public/telegraf")
+	assert.Error(t, err)
+	_, err = normalizeTopic("kafka://public/default/telegraf")
+	assert.Error(t, err)
+}
@@ -0,0 +1,123 @@
+package pulsar
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Message schemas. SchemaBytes sends the serialized data format, and
+// SchemaJSON and SchemaAvro send metricSchema records.
+const (
+	SchemaBytes = "bytes"
+	SchemaJSON  = "json"
+	SchemaAvro  = "avro"
+)
+
+// metricSchema is the Avro schema of a metric record, used for both the JSON
+// and the Avro schema in Pulsar. Field values keep their types, and the
+// timestamp is in milliseconds.
+const metricSchema = `{"type":"record","name":"Metric",` +
+	`"namespace":"com.influxdata.telegraf","fields":[` +
+	`{"name":"name","type":"string"},` +
+	`{"name":"tags","type":{"type":"map","values":"string"}},` +
+	`{"name":"fields","type":{"type":"map","values":` +
+	`["long","double","string","boolean"]}},` +
+	`{"name":"timestamp","type":{"type":"long",` +
+	`"logicalType":"timestamp-millis"}}]}`
+
+// record is the JSON record of a metric.
+type record struct {
+	Name      string                 `json:"name"`
+	Tags      map[string]string      `json:"tags"`
+	Fields    map[string]interface{} `json:"fields"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+func encodeJSON(metric telegraf.Metric) ([]byte, error) {
+	return json.Marshal(&record{
+		Name:      metric.Name(),
+		Tags:      metric.Tags(),
+		Fields:    metric.Fields(),
+		Timestamp: metric.UnixNano() / 1e6,
+	})
+}
+
+// encodeAvro encodes a metric as a metricSchema record in the Avro binary
+// encoding. Tags and fields are sorted by key.
+func encodeAvro(metric telegraf.Metric) []byte {
+	buf := appendString(nil, metric.Name())
+
+	tags := metric.Tags()
+	buf = appendLong(buf, int64(len(tags)))
+	for _, k := range sortedKeys(tags) {
+		buf = appendString(buf, k)
+		buf = appendString(buf, tags[k])
+	}
+	if len(tags) != 0 {
+		buf = appendLong(buf, 0)
+	}
+
+	fields := metric.Fields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var entries []byte
+	n := 0
+	for _, k := range keys {
+		// the union branch index, then the value
+		var value []byte
+		switch v := fields[k].(type) {
+		case int64:
+			value = appendLong([]byte{0}, v)
+		case float64:
+			value = make([]byte, 9)
+			value[0] = 2
+			binary.LittleEndian.PutUint64(value[1:], math.Float64bits(v))
+		case string:
+			value = appendString([]byte{4}, v)
+		case bool:
+			value = []byte{6, 0}
+			if v {
+				value[1] = 1
+			}
+		default:
+			continue
+		}
+		entries = appendString(entries, k)
+		entries = append(entries, value...)
+		n++
+	}
+	buf = appendLong(buf, int64(n))
+	buf = append(buf, entries...)
+	if n != 0 {
+		buf = appendLong(buf, 0)
+	}
+
+	return appendLong(buf, metric.UnixNano()/1e6)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// appendLong appends a long, zig-zag encoded as a varint.
+func appendLong(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendString(buf []byte, v string) []byte {
+	buf = appendLong(buf, int64(len(v)))
+	return append(buf, v...)
+}
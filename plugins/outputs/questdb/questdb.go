@@ -0,0 +1,404 @@
+package questdb
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/serializers/influx"
+)
+
+// Designated timestamps. TimestampMetric uses the metric time, and
+// TimestampServer lets QuestDB use the time it receives the row.
+const (
+	TimestampMetric = "metric"
+	TimestampServer = "server"
+)
+
+// partitions are the valid values of PartitionBy.
+var partitions = map[string]bool{
+	"NONE": true, "HOUR": true, "DAY": true, "WEEK": true, "MONTH": true,
+	"YEAR": true,
+}
+
+// tableEscaper and columnEscaper replace the characters that QuestDB does
+// not allow in table and column names.
+var (
+	tableEscaper = strings.NewReplacer(
+		".", "_", "?", "_", ",", "_", "'", "_", `"`, "_", `\`, "_",
+		"/", "_", ":", "_", "(", "_", ")", "_", "+", "_", "*", "_",
+		"%", "_", "~", "_", "\x00", "_", "\r", "_", "\n", "_")
+	columnEscaper = strings.NewReplacer(
+		".", "_", "?", "_", ",", "_", "'", "_", `"`, "_", `\`, "_",
+		"/", "_", ":", "_", "(", "_", ")", "_", "+", "_", "-", "_",
+		"*", "_", "%", "_", "~", "_", "\x00", "_", "\r", "_", "\n", "_")
+)
+
+type QuestDB struct {
+	// URL is tcp://host:port for the InfluxDB line protocol over TCP, or
+	// http(s)://host:port for it over HTTP.
+	URL string `toml:"url"`
+
+	// AuthKeyID and AuthPrivateKey authenticate the TCP connection. The
+	// private key is the "d" value of the JWK.
+	AuthKeyID      string `toml:"auth_key_id"`
+	AuthPrivateKey string `toml:"auth_private_key"`
+	// Username and Password, or Token, authenticate HTTP requests.
+	Username string
+	Password string
+	Token    string
+
+	// Timestamp selects the designated timestamp, TimestampMetric or
+	// TimestampServer.
+	Timestamp string
+	// CreateTables creates the table of a new measurement before its first
+	// write. The table uses TimestampColumn as the designated timestamp and
+	// is partitioned by PartitionBy. It requires HTTP.
+	CreateTables    bool   `toml:"create_tables"`
+	TimestampColumn string `toml:"timestamp_column"`
+	PartitionBy     string `toml:"partition_by"`
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	url        *url.URL
+	conn       net.Conn
+	client     *http.Client
+	serializer *influx.InfluxSerializer
+	// tables are the tables known to exist
+	tables map[string]bool
+}
+
+var sampleConfig = `
+  ## URL of QuestDB. Use tcp://host:9009 to send the line protocol over TCP,
+  ## or http(s)://host:9000 to send it over HTTP. HTTP reports write errors.
+  url = "tcp://localhost:9009"
+
+  ## TCP authentication. The private key is the "d" value of the user's JWK.
+  # auth_key_id = "admin"
+  # auth_private_key = "5UjEMuA0Pj5pjK8a-fa24dyIf-Es5mYny3oE_Wmus48"
+  ## HTTP authentication. Set a username and password, or a token.
+  # username = "admin"
+  # password = "quest"
+  # token = ""
+
+  ## Designated timestamp. Use "metric" for the metric time, or "server" to
+  ## let QuestDB set the time it receives the row.
+  # timestamp = "metric"
+
+  ## Create the table of a new measurement with this timestamp column and
+  ## partitioning, instead of the server defaults. Requires HTTP.
+  # create_tables = false
+  # timestamp_column = "timestamp"
+  # partition_by = "DAY"
+
+  ## Connection and write timeout
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (q *QuestDB) Connect() error {
+	u, err := url.Parse(q.URL)
+	if err != nil {
+		return fmt.Errorf("QuestDB: invalid url %q: %s", q.URL, err)
+	}
+	switch q.Timestamp {
+	case "", TimestampMetric, TimestampServer:
+	default:
+		return fmt.Errorf("QuestDB: invalid timestamp %q, must be metric or "+
+			"server", q.Timestamp)
+	}
+	if !partitions[strings.ToUpper(q.PartitionBy)] {
+		return fmt.Errorf("QuestDB: invalid partition_by %q, must be NONE, "+
+			"HOUR, DAY, WEEK, MONTH or YEAR", q.PartitionBy)
+	}
+	if q.serializer, err = influx.NewInfluxSerializer(false, "", 0, false,
+		false); err != nil {
+		return err
+	}
+	tlsConfig, err := internal.GetTLSConfig(q.SSLCert, q.SSLKey, q.SSLCA,
+		q.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	q.url = u
+	q.tables = make(map[string]bool)
+
+	switch u.Scheme {
+	case "tcp":
+		if q.CreateTables {
+			return fmt.Errorf("QuestDB: create_tables needs an http(s) url")
+		}
+		return q.dial()
+	case "http", "https":
+		q.client = &http.Client{
+			Transport: &http.Transport{
+				Proxy:           http.ProxyFromEnvironment,
+				TLSClientConfig: tlsConfig,
+			},
+			Timeout: q.Timeout.Duration,
+		}
+		return nil
+	}
+	return fmt.Errorf("QuestDB: invalid url %q, must be tcp, http or https",
+		q.URL)
+}
+
+func (q *QuestDB) Close() error {
+	if q.conn == nil {
+		return nil
+	}
+	err := q.conn.Close()
+	q.conn = nil
+	return err
+}
+
+func (q *QuestDB) Description() string {
+	return "Configuration for QuestDB server to send metrics to"
+}
+
+func (q *QuestDB) SampleConfig() string {
+	return sampleConfig
+}
+
+func (q *QuestDB) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, metric := range metrics {
+		m, err := q.sanitize(metric)
+		if err != nil {
+			log.Printf("QuestDB: dropping metric: %s", err)
+			continue
+		}
+		if q.CreateTables && !q.tables[m.Name()] {
+			if err := q.createTable(m.Name()); err != nil {
+				return err
+			}
+			q.tables[m.Name()] = true
+		}
+		lines, err := q.serializer.Serialize(m)
+		if err != nil {
+			log.Printf("QuestDB: dropping metric: %s", err)
+			continue
+		}
+		for _, line := range lines {
+			if q.Timestamp == TimestampServer {
+				line = line[:strings.LastIndex(line, " ")]
+			}
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+
+	if q.url.Scheme == "tcp" {
+		return q.writeTCP(buf.Bytes())
+	}
+	return q.writeHTTP(buf.Bytes())
+}
+
+// sanitize returns a copy of the metric with table and column names that
+// QuestDB accepts.
+func (q *QuestDB) sanitize(metric telegraf.Metric) (telegraf.Metric, error) {
+	tags := make(map[string]string)
+	for k, v := range metric.Tags() {
+		tags[columnEscaper.Replace(k)] = v
+	}
+	fields := make(map[string]interface{})
+	for k, v := range metric.Fields() {
+		fields[columnEscaper.Replace(k)] = v
+	}
+	return telegraf.NewMetric(tableEscaper.Replace(metric.Name()), tags,
+		fields, metric.Time())
+}
+
+// dial connects to the line protocol TCP port and authenticates if a key
+// is set.
+func (q *QuestDB) dial() error {
+	conn, err := net.DialTimeout("tcp", q.url.Host, q.Timeout.Duration)
+	if err != nil {
+		return fmt.Errorf("QuestDB: connecting to %s failed: %s", q.url.Host,
+			err)
+	}
+	if q.AuthKeyID != "" {
+		if err := q.authenticate(conn); err != nil {
+			conn.Close()
+			return fmt.Errorf("QuestDB: authentication failed: %s", err)
+		}
+	}
+	q.conn = conn
+	return nil
+}
+
+// authenticate sends the key ID and signs the server challenge with the
+// ECDSA P-256 private key.
+func (q *QuestDB) authenticate(conn net.Conn) error {
+	d, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(
+		q.AuthPrivateKey, "="))
+	if err != nil {
+		return fmt.Errorf("invalid auth_private_key: %s", err)
+	}
+	key := &ecdsa.PrivateKey{D: new(big.Int).SetBytes(d)}
+	key.Curve = elliptic.P256()
+	key.X, key.Y = key.Curve.ScalarBaseMult(d)
+
+	if q.Timeout.Duration > 0 {
+		conn.SetDeadline(time.Now().Add(q.Timeout.Duration))
+		defer conn.SetDeadline(time.Time{})
+	}
+	if _, err := fmt.Fprintf(conn, "%s\n", q.AuthKeyID); err != nil {
+		return err
+	}
+	var challenge []byte
+	b := make([]byte, 1)
+	for {
+		if _, err := conn.Read(b); err != nil {
+			return err
+		}
+		if b[0] == '\n' {
+			break
+		}
+		challenge = append(challenge, b[0])
+	}
+
+	hash := sha256.Sum256(challenge)
+	r, s, err := ecdsa.Sign(rand.Reader, key, hash[:])
+	if err != nil {
+		return err
+	}
+	signature, err := asn1.Marshal(struct{ R, S *big.Int }{r, s})
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(conn, "%s\n",
+		base64.StdEncoding.EncodeToString(signature))
+	return err
+}
+
+func (q *QuestDB) writeTCP(data []byte) error {
+	if q.conn == nil {
+		if err := q.dial(); err != nil {
+			return err
+		}
+	}
+	if q.Timeout.Duration > 0 {
+		q.conn.SetWriteDeadline(time.Now().Add(q.Timeout.Duration))
+	}
+	if _, err := q.conn.Write(data); err != nil {
+		q.Close()
+		return fmt.Errorf("QuestDB: writing to %s failed: %s", q.url.Host,
+			err)
+	}
+	return nil
+}
+
+func (q *QuestDB) writeHTTP(data []byte) error {
+	u := *q.url
+	u.Path = strings.TrimRight(u.Path, "/") + "/write"
+	u.RawQuery = "precision=n"
+	req, err := http.NewRequest("POST", u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if err := q.do(req); err != nil {
+		return fmt.Errorf("QuestDB: writing to %s failed: %s", q.url.Host,
+			err)
+	}
+	return nil
+}
+
+// createTable creates a table with only the designated timestamp column.
+// The line protocol adds the other columns.
+func (q *QuestDB) createTable(name string) error {
+	u := *q.url
+	u.Path = strings.TrimRight(u.Path, "/") + "/exec"
+	u.RawQuery = url.Values{"query": {fmt.Sprintf(
+		`CREATE TABLE IF NOT EXISTS "%s" ("%s" TIMESTAMP) timestamp("%s") `+
+			"PARTITION BY %s", name, q.TimestampColumn, q.TimestampColumn,
+		strings.ToUpper(q.PartitionBy))}}.Encode()
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return err
+	}
+	if err := q.do(req); err != nil {
+		return fmt.Errorf("QuestDB: creating table %s failed: %s", name, err)
+	}
+	return nil
+}
+
+// do sends an authenticated request. It returns the QuestDB error if the
+// response is not a success.
+func (q *QuestDB) do(req *http.Request) error {
+	if q.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+q.Token)
+	} else if q.Username != "" {
+		req.SetBasicAuth(q.Username, q.Password)
+	}
+	resp, err := q.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+
+	// errors are JSON objects with a message, or an error for /exec
+	var e struct {
+		Message string `json:"message"`
+		Error   string `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && (e.Message != "" || e.Error != "") {
+		return fmt.Errorf("%s returned HTTP status %s: %s%s", req.URL.Path,
+			resp.Status, e.Message, e.Error)
+	}
+	return fmt.Errorf("%s returned HTTP status %s", req.URL.Path,
+		resp.Status)
+}
+
+func init() {
+	outputs.Add("questdb", func() telegraf.Output {
+		return &QuestDB{
+			URL:             "tcp://localhost:9009",
+			Timestamp:       TimestampMetric,
+			TimestampColumn: "timestamp",
+			PartitionBy:     "DAY",
+			Timeout:         internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package questdb
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+)
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	m1, err := telegraf.NewMetric("cpu.usage",
+		map[string]string{"host": "web1", "cpu-id": "cpu0"},
+		map[string]interface{}{"idle": 91.5, "count": int64(3),
+			"state": "ok"},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	m2, err := telegraf.NewMetric("mem", nil,
+		map[string]interface{}{"used": int64(42)},
+		time.Unix(1465839840, 0))
+	require.NoError(t, err)
+	return []telegraf.Metric{m1, m2}
+}
+
+func newQuestDB(url string) *QuestDB {
+	return &QuestDB{
+		URL:             url,
+		Timestamp:       TimestampMetric,
+		TimestampColumn: "timestamp",
+		PartitionBy:     "DAY",
+		Timeout:         internal.Duration{Duration: 5 * time.Second},
+	}
+}
+
+func TestWriteTCPAuth(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	d := make([]byte, 32)
+	key.D.FillBytes(d)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	lines := make(chan string, 10)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+
+		// read the key ID, send a challenge and verify the signature
+		id, _ := r.ReadString('\n')
+		assert.Equal(t, "admin\n", id)
+		conn.Write([]byte("challenge\n"))
+		line, _ := r.ReadString('\n')
+		signature, err := base64.StdEncoding.DecodeString(line[:len(line)-1])
+		require.NoError(t, err)
+		var rs struct{ R, S *big.Int }
+		_, err = asn1.Unmarshal(signature, &rs)
+		require.NoError(t, err)
+		hash := sha256.Sum256([]byte("challenge"))
+		assert.True(t, ecdsa.Verify(&key.PublicKey, hash[:], rs.R, rs.S))
+
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	q := newQuestDB("tcp://" + listener.Addr().String())
+	q.AuthKeyID = "admin"
+	q.AuthPrivateKey = base64.RawURLEncoding.EncodeToString(d)
+	require.NoError(t, q.Connect())
+	defer q.Close()
+	require.NoError(t, q.Write(testMetrics(t)))
+
+	// the names are sanitized, and unsigned integers are integers
+	assert.Equal(t, "cpu_usage,cpu_id=cpu0,host=web1 count=3i,idle=91.5,"+
+		`state="ok" 1465839830123456789`+"\n", <-lines)
+	assert.Equal(t, "mem used=42i 1465839840000000000\n", <-lines)
+}
+
+func TestWriteHTTP(t *testing.T) {
+	var queries []string
+	var body string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		switch r.URL.Path {
+		case "/exec":
+			queries = append(queries, r.URL.Query().Get("query"))
+			w.Write([]byte(`{"ddl":"OK"}`))
+		case "/write":
+			assert.Equal(t, "n", r.URL.Query().Get("precision"))
+			b, _ := ioutil.ReadAll(r.Body)
+			body = string(b)
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer ts.Close()
+
+	q := newQuestDB(ts.URL)
+	q.Token = "secret"
+	q.Timestamp = TimestampServer
+	q.CreateTables = true
+	q.PartitionBy = "month"
+	require.NoError(t, q.Connect())
+	require.NoError(t, q.Write(testMetrics(t)))
+	require.NoError(t, q.Write(testMetrics(t)))
+
+	// the tables are created once
+	assert.Equal(t, []string{
+		`CREATE TABLE IF NOT EXISTS "cpu_usage" ("timestamp" TIMESTAMP) ` +
+			`timestamp("timestamp") PARTITION BY MONTH`,
+		`CREATE TABLE IF NOT EXISTS "mem" ("timestamp" TIMESTAMP) ` +
+			`timestamp("timestamp") PARTITION BY MONTH`,
+	}, queries)
+	// the server sets the designated timestamps
+	assert.Equal(t, "cpu_usage,cpu_id=cpu0,host=web1 count=3i,idle=91.5,"+
+		"state=\"ok\"\nmem used=42i\n", body)
+}
+
+func TestWriteHTTPError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"code":"invalid","message":"failed to parse ` +
+			`line protocol:errors encountered on line(s):\nerror in ` +
+			`line 1: table: cpu_usage; cast error"}`))
+	}))
+	defer ts.Close()
+
+	q := newQuestDB(ts.URL)
+	require.NoError(t, q.Connect())
+	err := q.Write(testMetrics(t))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cast error")
+}
+
+func TestConnectInvalid(t *testing.T) {
+	q := newQuestDB("udp://localhost:9009")
+	assert.Error(t, q.Connect())
+	q = newQuestDB("tcp://localhost:9009")
+	q.CreateTables = true
+	assert.Error(t, q.Connect())
+	q = newQuestDB("http://localhost:9000")
+	q.PartitionBy = "DECADE"
+	assert.Error(t, q.Connect())
+	q = newQuestDB("http://localhost:9000")
+	q.Timestamp = "client"
+	assert.Error(t, q.Connect())
+}
@@ -0,0 +1,209 @@
+package redistimeseries
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clusterSlots is the number of hash slots in a Redis Cluster.
+const clusterSlots = 16384
+
+// redisError is an error reply from redis. It is the reply to a command, or
+// an element of the TS.MADD reply.
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+// redirected returns whether the error redirects a key to another cluster
+// node. The slots are then read again.
+func (e redisError) redirected() bool {
+	return strings.HasPrefix(string(e), "MOVED ") ||
+		strings.HasPrefix(string(e), "ASK ")
+}
+
+type redisConn struct {
+	conn    net.Conn
+	rdr     *bufio.Reader
+	timeout time.Duration
+}
+
+// dialRedis connects to a redis server, authenticates and selects the
+// database.
+func dialRedis(addr, password, db string,
+	timeout time.Duration) (*redisConn, error) {
+	conn, err := net.DialTimeout("tcp", addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{conn: conn, rdr: bufio.NewReader(conn), timeout: timeout}
+	var cmds [][]string
+	if password != "" {
+		cmds = append(cmds, []string{"AUTH", password})
+	}
+	if db != "" {
+		cmds = append(cmds, []string{"SELECT", db})
+	}
+	replies, err := c.pipeline(cmds)
+	if err == nil {
+		for _, reply := range replies {
+			if e, ok := reply.(redisError); ok {
+				err = e
+				break
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	return c.conn.Close()
+}
+
+// pipeline sends the commands at once and returns their replies. A reply is
+// a string, an int64, nil, a redisError, or an []interface{} of these.
+func (c *redisConn) pipeline(cmds [][]string) ([]interface{}, error) {
+	if len(cmds) == 0 {
+		return nil, nil
+	}
+	if c.timeout > 0 {
+		c.conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+	var b bytes.Buffer
+	for _, args := range cmds {
+		fmt.Fprintf(&b, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if _, err := c.conn.Write(b.Bytes()); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range replies {
+		reply, err := c.reply()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+func (c *redisConn) reply() (interface{}, error) {
+	line, err := c.rdr.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("empty reply from redis")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return redisError(line[1:]), nil
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.rdr, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		elems := make([]interface{}, n)
+		for i := range elems {
+			if elems[i], err = c.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return elems, nil
+	}
+	return nil, fmt.Errorf("unexpected reply from redis: %q", line)
+}
+
+// clusterSlotsOf returns the address of the master of each slot from the
+// CLUSTER SLOTS reply of the node at addr.
+func clusterSlotsOf(reply interface{}, addr string) ([]string, error) {
+	ranges, ok := reply.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("unexpected CLUSTER SLOTS reply: %v", reply)
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]string, clusterSlots)
+	for _, r := range ranges {
+		elems, ok := r.([]interface{})
+		if !ok || len(elems) < 3 {
+			return nil, fmt.Errorf("unexpected slot range in CLUSTER SLOTS: %v", r)
+		}
+		start, ok1 := elems[0].(int64)
+		end, ok2 := elems[1].(int64)
+		master, ok3 := elems[2].([]interface{})
+		if !ok1 || !ok2 || !ok3 || len(master) < 2 || start < 0 ||
+			end >= clusterSlots {
+			return nil, fmt.Errorf("unexpected slot range in CLUSTER SLOTS: %v", r)
+		}
+		ip, _ := master[0].(string)
+		port, _ := master[1].(int64)
+		// an empty ip means the node the slots were read from
+		if ip == "" {
+			ip = host
+		}
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = net.JoinHostPort(ip, strconv.FormatInt(port, 10))
+		}
+	}
+	return slots, nil
+}
+
+// keySlot returns the Redis Cluster hash slot of a key. If the key has a
+// hash tag, the first non-empty {...}, only the tag is hashed.
+func keySlot(key string) int {
+	if s := strings.IndexByte(key, '{'); s >= 0 {
+		if e := strings.IndexByte(key[s+1:], '}'); e > 0 {
+			key = key[s+1 : s+1+e]
+		}
+	}
+	return int(crc16(key)) % clusterSlots
+}
+
+// crc16 is the CRC16 XMODEM checksum used for Redis Cluster hash slots.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
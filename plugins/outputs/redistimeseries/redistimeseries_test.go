@@ -0,0 +1,305 @@
+package redistimeseries
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+// series is a key of the fake redis, with its TS.CREATE options.
+type series struct {
+	create  []string
+	samples []string
+}
+
+// fakeRedis serves AUTH, SELECT, CLUSTER SLOTS, TS.CREATE and TS.MADD. The
+// password is "secret" and keys use the BLOCK duplicate policy. A cluster
+// node serves the slots from first to last.
+type fakeRedis struct {
+	net.Listener
+	mu      sync.Mutex
+	keys    map[string]*series
+	creates int
+	// slots is the CLUSTER SLOTS reply, and first and last the node's slots
+	slots       string
+	first, last int
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	f := &fakeRedis{Listener: l, keys: make(map[string]*series), last: -1}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	rdr := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(rdr)
+		if err != nil {
+			return
+		}
+		f.mu.Lock()
+		var reply string
+		switch strings.ToUpper(args[0]) {
+		case "AUTH":
+			reply = "+OK\r\n"
+			if args[1] != "secret" {
+				reply = "-ERR invalid password\r\n"
+			}
+		case "SELECT":
+			reply = "+OK\r\n"
+		case "CLUSTER":
+			reply = f.slots
+		case "TS.CREATE":
+			reply = f.create(args)
+		case "TS.MADD":
+			reply = f.madd(args)
+		}
+		f.mu.Unlock()
+		io.WriteString(conn, reply)
+	}
+}
+
+func (f *fakeRedis) create(args []string) string {
+	if reply := f.moved(args[1:2]); reply != "" {
+		return reply
+	}
+	f.creates++
+	if _, ok := f.keys[args[1]]; ok {
+		return "-ERR TSDB: key already exists\r\n"
+	}
+	f.keys[args[1]] = &series{create: args[2:]}
+	return "+OK\r\n"
+}
+
+func (f *fakeRedis) madd(args []string) string {
+	var keys []string
+	for i := 1; i < len(args); i += 3 {
+		keys = append(keys, args[i])
+	}
+	if reply := f.moved(keys); reply != "" {
+		return reply
+	}
+	reply := fmt.Sprintf("*%d\r\n", len(keys))
+	for i := 1; i < len(args); i += 3 {
+		s, ok := f.keys[args[i]]
+		if !ok {
+			reply += "-ERR TSDB: the key does not exist\r\n"
+			continue
+		}
+		ts, _ := strconv.ParseInt(args[i+1], 10, 64)
+		duplicate := false
+		for _, sample := range s.samples {
+			duplicate = duplicate || strings.HasPrefix(sample, args[i+1]+" ")
+		}
+		if duplicate {
+			reply += "-ERR TSDB: duplicate sample blocked\r\n"
+			continue
+		}
+		s.samples = append(s.samples, args[i+1]+" "+args[i+2])
+		reply += ":" + strconv.FormatInt(ts, 10) + "\r\n"
+	}
+	return reply
+}
+
+// moved returns the error a cluster node replies for keys in several slots,
+// or in a slot of another node.
+func (f *fakeRedis) moved(keys []string) string {
+	if f.last < 0 {
+		return ""
+	}
+	slot := keySlot(keys[0])
+	for _, key := range keys {
+		if keySlot(key) != slot {
+			return "-CROSSSLOT Keys in request don't hash to the same slot\r\n"
+		}
+	}
+	if slot < f.first || slot > f.last {
+		return fmt.Sprintf("-MOVED %d 127.0.0.1:1\r\n", slot)
+	}
+	return ""
+}
+
+func readCommand(rdr *bufio.Reader) ([]string, error) {
+	line, err := rdr.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = rdr.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(rdr, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func testMetrics(t *testing.T, sec int64) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	for _, host := range []string{"a", "b"} {
+		m, err := telegraf.NewMetric("cpu", map[string]string{"host": host},
+			map[string]interface{}{
+				"usage": 42.5, "count": int64(3), "state": "ok",
+			}, time.Unix(sec, 0))
+		require.NoError(t, err)
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestWrite(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+	r := &RedisTimeSeries{
+		Servers:         []string{"tcp://:secret@" + f.Addr().String() + "/1"},
+		KeyPrefix:       "telegraf:",
+		DuplicatePolicy: "last",
+	}
+	r.Retention.Duration = time.Hour
+	require.NoError(t, r.Connect())
+	defer r.Close()
+
+	require.NoError(t, r.Write(testMetrics(t, 1465839830)))
+	require.Len(t, f.keys, 4)
+	assert.Equal(t, []string{"RETENTION", "3600000", "DUPLICATE_POLICY",
+		"LAST", "LABELS", "measurement", "cpu", "field", "usage", "host", "a"},
+		f.keys["telegraf:cpu:usage:host=a"].create)
+	assert.Equal(t, []string{"1465839830000 42.5"},
+		f.keys["telegraf:cpu:usage:host=a"].samples)
+	assert.Equal(t, []string{"1465839830000 3"},
+		f.keys["telegraf:cpu:count:host=b"].samples)
+
+	// the keys are created once
+	require.NoError(t, r.Write(testMetrics(t, 1465839831)))
+	assert.Equal(t, 4, f.creates)
+	assert.Equal(t, []string{"1465839830000 42.5", "1465839831000 42.5"},
+		f.keys["telegraf:cpu:usage:host=a"].samples)
+}
+
+func TestWriteKeyDeleted(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+	r := &RedisTimeSeries{Servers: []string{":secret@" + f.Addr().String()}}
+	require.NoError(t, r.Connect())
+	defer r.Close()
+
+	require.NoError(t, r.Write(testMetrics(t, 1465839830)))
+	f.mu.Lock()
+	delete(f.keys, "cpu:usage:host=a")
+	f.mu.Unlock()
+
+	// the keys that no longer exist are created again
+	require.NoError(t, r.Write(testMetrics(t, 1465839831)))
+	assert.Equal(t, 5, f.creates)
+	assert.Equal(t, []string{"1465839831000 42.5"},
+		f.keys["cpu:usage:host=a"].samples)
+	assert.Equal(t, []string{"1465839830000 42.5", "1465839831000 42.5"},
+		f.keys["cpu:usage:host=b"].samples)
+}
+
+func TestWriteRejected(t *testing.T) {
+	f := newFakeRedis(t)
+	defer f.Close()
+	r := &RedisTimeSeries{Servers: []string{":secret@" + f.Addr().String()}}
+	require.NoError(t, r.Connect())
+	defer r.Close()
+
+	// the duplicates blocked are dropped, rather than written again
+	require.NoError(t, r.Write(testMetrics(t, 1465839830)))
+	require.NoError(t, r.Write(testMetrics(t, 1465839830)))
+	assert.Equal(t, []string{"1465839830000 42.5"},
+		f.keys["cpu:usage:host=a"].samples)
+}
+
+func TestWriteCluster(t *testing.T) {
+	a, b := newFakeRedis(t), newFakeRedis(t)
+	defer a.Close()
+	defer b.Close()
+	slots := fmt.Sprintf("*2\r\n"+
+		"*3\r\n:0\r\n:8191\r\n*3\r\n$0\r\n\r\n:%s\r\n$2\r\nid\r\n"+
+		"*3\r\n:8192\r\n:16383\r\n*2\r\n$9\r\n127.0.0.1\r\n:%s\r\n",
+		port(t, a), port(t, b))
+	a.slots, a.first, a.last = slots, 0, 8191
+	b.slots, b.first, b.last = slots, 8192, 16383
+
+	r := &RedisTimeSeries{
+		Servers: []string{"tcp://127.0.0.1:1",
+			"tcp://:secret@" + a.Addr().String()},
+		Cluster: true,
+	}
+	require.NoError(t, r.Connect())
+	defer r.Close()
+
+	require.NoError(t, r.Write(testMetrics(t, 1465839830)))
+	for _, key := range []string{"cpu:usage:host=a", "cpu:usage:host=b",
+		"cpu:count:host=a", "cpu:count:host=b"} {
+		node := a
+		if keySlot(key) > 8191 {
+			node = b
+		}
+		require.Contains(t, node.keys, key)
+		assert.Len(t, node.keys[key].samples, 1)
+	}
+	assert.Equal(t, 4, len(a.keys)+len(b.keys))
+
+	// a redirection makes the slots be read again
+	for _, node := range []*fakeRedis{a, b} {
+		node.mu.Lock()
+		node.first = clusterSlots
+		node.mu.Unlock()
+	}
+	assert.Error(t, r.Write(testMetrics(t, 1465839831)))
+	assert.Nil(t, r.slots)
+}
+
+func port(t *testing.T, f *fakeRedis) string {
+	_, p, err := net.SplitHostPort(f.Addr().String())
+	require.NoError(t, err)
+	return p
+}
+
+func TestKeySlot(t *testing.T) {
+	assert.Equal(t, 12739, keySlot("123456789"))
+	assert.Equal(t, keySlot("user1000"), keySlot("{user1000}.following"))
+	assert.Equal(t, keySlot("{}.following"), keySlot("{}.following"))
+	assert.NotEqual(t, keySlot("{}.following"), keySlot("{}.followers"))
+}
+
+func TestConnectInvalid(t *testing.T) {
+	r := &RedisTimeSeries{Servers: []string{"localhost", "localhost:6380"}}
+	assert.Error(t, r.Connect())
+	r = &RedisTimeSeries{DuplicatePolicy: "newest"}
+	assert.Error(t, r.Connect())
+	r = &RedisTimeSeries{Servers: []string{"tcp://localhost/1"},
+		Cluster: true}
+	assert.Error(t, r.Connect())
+}
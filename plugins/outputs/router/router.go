@@ -0,0 +1,144 @@
+package router
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+// Router routes the metrics written to it to its child outputs, using the
+// route expressions in their [[outputs.router.route]] tables. Each child is
+// a separate running output with its own filters, batches and buffer, so a
+// failing child does not hold back the others.
+type Router struct {
+	routes []route
+}
+
+type route struct {
+	route     *internal_models.Route
+	isDefault bool
+	output    *internal_models.RunningOutput
+}
+
+var sampleConfig = `
+  ## Each route lists the match expressions for its output, in the same
+  ## syntax as route_match: key=pattern or key!=pattern predicates separated
+  ## by spaces, where key is a tag or _name for the measurement. Default
+  ## routes receive the metrics that match no route. Routes with neither
+  ## receive every metric.
+  [[outputs.router.route]]
+    match = ["_name=cpu", "region=eu-*"]
+    [outputs.router.route.influxdb]
+      urls = ["http://localhost:8086"]
+      database = "eu"
+
+  [[outputs.router.route]]
+    default = true
+    [outputs.router.route.file]
+      files = ["stdout"]
+`
+
+func (r *Router) AddRoute(
+	rt *internal_models.Route,
+	isDefault bool,
+	output *internal_models.RunningOutput,
+) {
+	r.routes = append(r.routes, route{rt, isDefault, output})
+}
+
+func (r *Router) SampleConfig() string {
+	return sampleConfig
+}
+
+func (r *Router) Description() string {
+	return "Route metrics to child outputs by their measurement and tags"
+}
+
+// Connect starts the child output services and connects the children.
+func (r *Router) Connect() error {
+	for _, rt := range r.routes {
+		if so, ok := rt.output.Output.(telegraf.ServiceOutput); ok {
+			if err := so.Start(); err != nil {
+				return fmt.Errorf("Router: output [%s] failed to start: %s",
+					rt.output.LogName(), err)
+			}
+		}
+		if err := rt.output.Output.Connect(); err != nil {
+			return fmt.Errorf("Router: output [%s] failed to connect: %s",
+				rt.output.LogName(), err)
+		}
+	}
+	return nil
+}
+
+// Close writes the metrics buffered by the child outputs, then closes them.
+func (r *Router) Close() error {
+	var err error
+	for _, rt := range r.routes {
+		if e := rt.output.Write(); e != nil {
+			log.Printf("Router: output [%s] failed to write on close: %s",
+				rt.output.LogName(), e)
+		}
+		if e := rt.output.Output.Close(); e != nil {
+			err = fmt.Errorf("Router: output [%s] failed to close: %s",
+				rt.output.LogName(), e)
+		}
+		if so, ok := rt.output.Output.(telegraf.ServiceOutput); ok {
+			so.Stop()
+		}
+	}
+	return err
+}
+
+// Write routes the metrics to the child outputs, and writes each child. If a
+// child fails, its metrics stay in its buffer for the next write. The router
+// write does not fail, as that would write the metrics again to every
+// child.
+func (r *Router) Write(metrics []telegraf.Metric) error {
+	for _, metric := range metrics {
+		r.route(metric)
+	}
+	for _, rt := range r.routes {
+		if err := rt.output.Write(); err != nil {
+			log.Printf("Router: output [%s] failed to write, its metrics are "+
+				"buffered: %s", rt.output.LogName(), err)
+		}
+	}
+	return nil
+}
+
+// route adds a metric to the child outputs it is routed to:
+//
+//   - the routes whose match it matches
+//   - the default routes, if it matched no route
+//   - the routes with neither, always
+func (r *Router) route(metric telegraf.Metric) {
+	routed := false
+	for _, rt := range r.routes {
+		if rt.route.Match(metric) {
+			internal_models.Tracef(metric, "routed to output [%s]",
+				rt.output.LogName())
+			rt.output.AddMetric(metric)
+			routed = true
+		}
+	}
+	for _, rt := range r.routes {
+		switch {
+		case rt.isDefault && !routed:
+			internal_models.Tracef(metric, "routed to default output [%s]",
+				rt.output.LogName())
+			rt.output.AddMetric(metric)
+		case rt.route == nil && !rt.isDefault:
+			rt.output.AddMetric(metric)
+		}
+	}
+}
+
+func init() {
+	outputs.Add("router", func() telegraf.Output {
+		return &Router{}
+	})
+}
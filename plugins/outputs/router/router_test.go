@@ -0,0 +1,116 @@
+package router
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/models"
+)
+
+// mockOutput records the names of the metrics written to it.
+type mockOutput struct {
+	names []string
+	// fail fails the writes
+	fail      bool
+	connected bool
+	closed    bool
+}
+
+func (m *mockOutput) Connect() error {
+	m.connected = true
+	return nil
+}
+
+func (m *mockOutput) Close() error {
+	m.closed = true
+	return nil
+}
+
+func (m *mockOutput) Description() string  { return "" }
+func (m *mockOutput) SampleConfig() string { return "" }
+
+func (m *mockOutput) Write(metrics []telegraf.Metric) error {
+	if m.fail {
+		return fmt.Errorf("failed write")
+	}
+	for _, metric := range metrics {
+		m.names = append(m.names, metric.Name()+","+metric.Tags()["region"])
+	}
+	return nil
+}
+
+// addMock adds a route with the exprs and a mock output to a router.
+func addMock(t *testing.T, r *Router, exprs []string,
+	isDefault bool) *mockOutput {
+	var rt *internal_models.Route
+	if exprs != nil {
+		var err error
+		rt, err = internal_models.NewRoute(exprs)
+		require.NoError(t, err)
+	}
+	m := &mockOutput{}
+	conf := &internal_models.OutputConfig{Name: "mock"}
+	r.AddRoute(rt, isDefault,
+		internal_models.NewRunningOutput("mock", m, conf, 1000, 10000))
+	return m
+}
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	for _, m := range []struct{ name, region string }{
+		{"cpu", "eu-west"},
+		{"cpu", "us-east"},
+		{"mem", "eu-west"},
+	} {
+		metric, err := telegraf.NewMetric(m.name,
+			map[string]string{"region": m.region},
+			map[string]interface{}{"value": 1.0}, time.Unix(1465839830, 0))
+		require.NoError(t, err)
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+func TestWriteRoutes(t *testing.T) {
+	r := &Router{}
+	eu := addMock(t, r, []string{"_name=cpu region=eu-*"}, false)
+	cpu := addMock(t, r, []string{"_name=cpu"}, false)
+	def := addMock(t, r, nil, true)
+	all := addMock(t, r, nil, false)
+	require.NoError(t, r.Connect())
+	assert.True(t, eu.connected)
+
+	require.NoError(t, r.Write(testMetrics(t)))
+	assert.Equal(t, []string{"cpu,eu-west"}, eu.names)
+	assert.Equal(t, []string{"cpu,eu-west", "cpu,us-east"}, cpu.names)
+	assert.Equal(t, []string{"mem,eu-west"}, def.names)
+	assert.Equal(t, []string{"cpu,eu-west", "cpu,us-east", "mem,eu-west"},
+		all.names)
+
+	require.NoError(t, r.Close())
+	assert.True(t, all.closed)
+}
+
+func TestWriteFailedChild(t *testing.T) {
+	r := &Router{}
+	failing := addMock(t, r, []string{"region=eu-*"}, false)
+	other := addMock(t, r, []string{"region=us-*"}, false)
+	require.NoError(t, r.Connect())
+
+	// a failing child does not fail the write or the other children
+	failing.fail = true
+	require.NoError(t, r.Write(testMetrics(t)))
+	assert.Empty(t, failing.names)
+	assert.Equal(t, []string{"cpu,us-east"}, other.names)
+
+	// the next write sends the metrics buffered by the child
+	failing.fail = false
+	require.NoError(t, r.Write(nil))
+	assert.Equal(t, []string{"cpu,eu-west", "mem,eu-west"}, failing.names)
+	assert.Equal(t, []string{"cpu,us-east"}, other.names)
+}
@@ -0,0 +1,321 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sort"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	internalaws "github.com/influxdata/telegraf/internal/config/aws"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/serializers"
+)
+
+type S3 struct {
+	Region    string `toml:"region"`
+	AccessKey string `toml:"access_key"`
+	SecretKey string `toml:"secret_key"`
+	RoleARN   string `toml:"role_arn"`
+	Profile   string `toml:"profile"`
+	Filename  string `toml:"shared_credential_file"`
+	Token     string `toml:"token"`
+
+	Bucket string `toml:"bucket"`
+	// Endpoint is the URL of an S3 compatible object store, such as the
+	// Google Cloud Storage XML API. ForcePathStyle uses path style
+	// requests.
+	Endpoint       string `toml:"endpoint"`
+	ForcePathStyle bool   `toml:"force_path_style"`
+
+	// Key is a Go template that renders the key prefix for a metric, for
+	// example "telegraf/{{.Name}}/{{.Time.Format "2006-01-02"}}/". The
+	// object key is the prefix, the start time and the Extension.
+	Key       string `toml:"key"`
+	Extension string `toml:"extension"`
+	// Compression of the objects, none or gzip.
+	Compression string `toml:"compression"`
+
+	// An object is uploaded once it reaches MaxObjectSize bytes before
+	// compression, or once it is older than RotationInterval.
+	MaxObjectSize    int64             `toml:"max_object_size"`
+	RotationInterval internal.Duration `toml:"rotation_interval"`
+
+	svc        *s3.S3
+	key        *template.Template
+	serializer serializers.Serializer
+
+	mu sync.Mutex
+	// objects are the objects being buffered, by key prefix
+	objects map[string]*object
+}
+
+// object is an object being buffered. Line based data formats buffer the
+// serialized metrics, and file based formats buffer the metrics.
+type object struct {
+	prefix  string
+	started time.Time
+	data    bytes.Buffer
+	metrics []telegraf.Metric
+	size    int64
+}
+
+var sampleConfig = `
+  ## Amazon REGION
+  region = "us-east-1"
+
+  ## Amazon Credentials
+  ## Credentials are loaded in the following order
+  ## 1) Assumed credentials via STS if role_arn is specified
+  ## 2) explicit credentials from 'access_key' and 'secret_key'
+  ## 3) shared profile from 'profile'
+  ## 4) environment variables
+  ## 5) shared credentials file
+  ## 6) EC2 Instance Profile
+  #access_key = ""
+  #secret_key = ""
+  #token = ""
+  #role_arn = ""
+  #profile = ""
+  #shared_credential_file = ""
+
+  ## Bucket to write to
+  bucket = "telegraf"
+
+  ## URL of an S3 compatible object store. For Google Cloud Storage with
+  ## HMAC keys, use "https://storage.googleapis.com". Path style requests
+  ## use "<endpoint>/<bucket>/<key>".
+  # endpoint = ""
+  # force_path_style = false
+
+  ## Go template for the key prefix of a metric. The object key is the
+  ## prefix, then the UTC start time such as 20160613T174350.123456789Z, then
+  ## the extension.
+  # key = 'telegraf/{{.Name}}/{{.Time.Format "2006-01-02"}}/'
+  # extension = ".influx"
+
+  ## Object compression: none or gzip. gzip adds ".gz" to the keys.
+  # compression = "gzip"
+
+  ## Upload an object once it reaches this size in bytes before
+  ## compression, or this age
+  # max_object_size = 67108864
+  # rotation_interval = "5m"
+
+  ## Data format to output.
+  ## Each data format has it's own unique set of configuration options, read
+  ## more about them here:
+  ## https://github.com/influxdata/telegraf/blob/master/docs/DATA_FORMATS_OUTPUT.md
+  data_format = "influx"
+`
+
+func (s *S3) SetSerializer(serializer serializers.Serializer) {
+	s.serializer = serializer
+}
+
+func (s *S3) SampleConfig() string {
+	return sampleConfig
+}
+
+func (s *S3) Description() string {
+	return "Configuration for AWS S3 output, writing metrics as objects."
+}
+
+func (s *S3) Connect() error {
+	if s.Bucket == "" {
+		return fmt.Errorf("S3: bucket must be set")
+	}
+	switch s.Compression {
+	case "", "none", "gzip":
+	default:
+		return fmt.Errorf("S3: invalid compression %q, must be none or gzip",
+			s.Compression)
+	}
+	if s.MaxObjectSize < 0 || s.RotationInterval.Duration < 0 {
+		return fmt.Errorf("S3: max_object_size and rotation_interval must " +
+			"not be negative")
+	}
+	var err error
+	if s.key, err = template.New("key").Option("missingkey=zero").Parse(
+		s.Key); err != nil {
+		return fmt.Errorf("S3: invalid key: %s", err)
+	}
+
+	credentialConfig := &internalaws.CredentialConfig{
+		Region:    s.Region,
+		AccessKey: s.AccessKey,
+		SecretKey: s.SecretKey,
+		RoleARN:   s.RoleARN,
+		Profile:   s.Profile,
+		Filename:  s.Filename,
+		Token:     s.Token,
+	}
+	config := &aws.Config{S3ForcePathStyle: aws.Bool(s.ForcePathStyle)}
+	if s.Endpoint != "" {
+		config.Endpoint = aws.String(s.Endpoint)
+	}
+	s.svc = s3.New(credentialConfig.Credentials(), config)
+	s.objects = make(map[string]*object)
+	return nil
+}
+
+// Close uploads the objects being buffered.
+func (s *S3) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.upload(true); err != nil {
+		return fmt.Errorf("S3: %s", err)
+	}
+	return nil
+}
+
+// Write buffers the metrics into the object of their key prefix, and uploads
+// the objects that reached max_object_size or rotation_interval. If an
+// upload fails, the metrics are removed from the objects that were not
+// uploaded, so that they are written again.
+func (s *S3) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// render all prefixes and serialize all metrics before buffering any,
+	// so that an error leaves the buffers unchanged
+	_, batch := s.serializer.(serializers.BatchSerializer)
+	prefixes := make([]string, len(metrics))
+	values := make([][]string, len(metrics))
+	for i, metric := range metrics {
+		var buf bytes.Buffer
+		if err := s.key.Execute(&buf, metric); err != nil {
+			return fmt.Errorf("S3: key of metric %s: %s", metric.Name(), err)
+		}
+		prefixes[i] = buf.String()
+		if batch {
+			continue
+		}
+		var err error
+		if values[i], err = s.serializer.Serialize(metric); err != nil {
+			return fmt.Errorf("S3: %s", err)
+		}
+	}
+
+	type mark struct {
+		data, metrics int
+		size          int64
+	}
+	marks := make(map[string]mark)
+	now := time.Now()
+	for i, metric := range metrics {
+		o, ok := s.objects[prefixes[i]]
+		if !ok {
+			o = &object{prefix: prefixes[i], started: now}
+			s.objects[o.prefix] = o
+		}
+		if _, ok := marks[o.prefix]; !ok {
+			marks[o.prefix] = mark{o.data.Len(), len(o.metrics), o.size}
+		}
+		if batch {
+			// estimate the file size with the line protocol size
+			o.metrics = append(o.metrics, metric)
+			o.size += int64(len(metric.String()))
+			continue
+		}
+		for _, value := range values[i] {
+			o.data.WriteString(value)
+			o.data.WriteByte('\n')
+			o.size += int64(len(value) + 1)
+		}
+	}
+
+	if err := s.upload(false); err != nil {
+		for prefix, m := range marks {
+			o, ok := s.objects[prefix]
+			if !ok {
+				continue
+			}
+			if m.data == 0 && m.metrics == 0 {
+				delete(s.objects, prefix)
+				continue
+			}
+			o.data.Truncate(m.data)
+			o.metrics = o.metrics[:m.metrics]
+			o.size = m.size
+		}
+		return fmt.Errorf("S3: %s", err)
+	}
+	return nil
+}
+
+// upload uploads the objects that reached max_object_size or
+// rotation_interval, or all of them, and removes the uploaded ones.
+func (s *S3) upload(all bool) error {
+	prefixes := make([]string, 0, len(s.objects))
+	for prefix := range s.objects {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	now := time.Now()
+	for _, prefix := range prefixes {
+		o := s.objects[prefix]
+		if !all && o.size < s.MaxObjectSize &&
+			now.Sub(o.started) < s.RotationInterval.Duration {
+			continue
+		}
+		if err := s.put(o); err != nil {
+			return err
+		}
+		delete(s.objects, prefix)
+	}
+	return nil
+}
+
+// put uploads an object, whose key is its prefix and start time.
+func (s *S3) put(o *object) error {
+	data := o.data.Bytes()
+	if batch, ok := s.serializer.(serializers.BatchSerializer); ok {
+		var err error
+		if data, err = batch.SerializeBatch(o.metrics); err != nil {
+			return err
+		}
+	}
+	key := o.prefix + o.started.UTC().Format("20060102T150405.000000000Z") +
+		s.Extension
+	if s.Compression == "gzip" {
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+		key += ".gz"
+	}
+	_, err := s.svc.PutObject(&s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("uploading object %s failed: %s", key, err)
+	}
+	return nil
+}
+
+func init() {
+	outputs.Add("s3", func() telegraf.Output {
+		return &S3{
+			Key:              `telegraf/{{.Name}}/{{.Time.Format "2006-01-02"}}/`,
+			Compression:      "gzip",
+			MaxObjectSize:    64 << 20,
+			RotationInterval: internal.Duration{Duration: 5 * time.Minute},
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/serializers"
+)
+
+// fakeS3 is an S3 compatible object store that serves path style requests
+// for the objects of one bucket.
+type fakeS3 struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	objects map[string][]byte
+	// fail fails the uploads
+	fail bool
+}
+
+func newFakeS3(t *testing.T) *fakeS3 {
+	f := &fakeS3{objects: make(map[string][]byte)}
+	f.Server = httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			assert.Equal(t, "PUT", r.Method)
+			if f.fail {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			f.objects[strings.TrimPrefix(r.URL.Path, "/telegraf/")] = body
+		}))
+	return f
+}
+
+// keys returns the object keys, with their times replaced by TIME.
+func (f *fakeS3) keys() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var keys []string
+	for key := range f.objects {
+		i := strings.LastIndex(key, "/")
+		keys = append(keys, key[:i+1]+"TIME"+
+			key[i+1+len("20060102T150405.000000000Z"):])
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// object returns the uncompressed data of the object with a prefix.
+func (f *fakeS3) object(t *testing.T, prefix string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for key, data := range f.objects {
+		if !strings.HasPrefix(key, prefix) ||
+			strings.Contains(key[len(prefix):], "/") {
+			continue
+		}
+		if strings.HasSuffix(key, ".gz") {
+			r, err := gzip.NewReader(bytes.NewReader(data))
+			require.NoError(t, err)
+			data, err = ioutil.ReadAll(r)
+			require.NoError(t, err)
+		}
+		return string(data)
+	}
+	t.Fatalf("no object of prefix %s", prefix)
+	return ""
+}
+
+func newS3(t *testing.T, f *fakeS3, dataFormat string) *S3 {
+	s := &S3{
+		Region:           "us-east-1",
+		AccessKey:        "access",
+		SecretKey:        "secret",
+		Bucket:           "telegraf",
+		Endpoint:         f.URL,
+		ForcePathStyle:   true,
+		Key:              `{{.Name}}/dt={{.Time.Format "2006-01-02"}}/{{.Tags.host}}/`,
+		Extension:        "." + dataFormat,
+		Compression:      "gzip",
+		MaxObjectSize:    64 << 20,
+		RotationInterval: internal.Duration{Duration: time.Hour},
+	}
+	serializer, err := serializers.NewSerializer(
+		&serializers.Config{DataFormat: dataFormat})
+	require.NoError(t, err)
+	s.SetSerializer(serializer)
+	require.NoError(t, s.Connect())
+	return s
+}
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	for i, host := range []string{"a", "b", "a"} {
+		m, err := telegraf.NewMetric("cpu", map[string]string{"host": host},
+			map[string]interface{}{"value": float64(i)},
+			time.Date(2016, 6, 13, 17, 43, 50+i, 0, time.UTC))
+		require.NoError(t, err)
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestWriteRotation(t *testing.T) {
+	f := newFakeS3(t)
+	defer f.Close()
+	s := newS3(t, f, "influx")
+
+	// the objects are buffered until they are of the rotation interval
+	require.NoError(t, s.Write(testMetrics(t)))
+	assert.Empty(t, f.keys())
+
+	s.RotationInterval.Duration = 0
+	require.NoError(t, s.Write(testMetrics(t)[:1]))
+	assert.Equal(t, []string{
+		"cpu/dt=2016-06-13/a/TIME.influx.gz",
+		"cpu/dt=2016-06-13/b/TIME.influx.gz",
+	}, f.keys())
+	assert.Equal(t,
+		"cpu,host=a value=0 1465839830000000000\n"+
+			"cpu,host=a value=2 1465839832000000000\n"+
+			"cpu,host=a value=0 1465839830000000000\n",
+		f.object(t, "cpu/dt=2016-06-13/a/"))
+	assert.Equal(t, "cpu,host=b value=1 1465839831000000000\n",
+		f.object(t, "cpu/dt=2016-06-13/b/"))
+	assert.Empty(t, s.objects)
+	require.NoError(t, s.Close())
+}
+
+func TestWriteMaxObjectSize(t *testing.T) {
+	f := newFakeS3(t)
+	defer f.Close()
+	s := newS3(t, f, "influx")
+	s.Compression = "none"
+	s.MaxObjectSize = 80
+
+	require.NoError(t, s.Write(testMetrics(t)))
+	assert.Empty(t, f.keys())
+	require.NoError(t, s.Write(testMetrics(t)[2:]))
+	assert.Equal(t, []string{"cpu/dt=2016-06-13/a/TIME.influx"}, f.keys())
+
+	// the rest is uploaded of the close
+	require.NoError(t, s.Close())
+	assert.Equal(t, []string{
+		"cpu/dt=2016-06-13/a/TIME.influx",
+		"cpu/dt=2016-06-13/b/TIME.influx",
+	}, f.keys())
+	assert.Equal(t, "cpu,host=b value=1 1465839831000000000\n",
+		f.object(t, "cpu/dt=2016-06-13/b/"))
+}
+
+func TestWriteFailed(t *testing.T) {
+	f := newFakeS3(t)
+	defer f.Close()
+	s := newS3(t, f, "influx")
+	metrics := testMetrics(t)
+
+	require.NoError(t, s.Write(metrics[:1]))
+	s.RotationInterval.Duration = 0
+	f.fail = true
+	require.Error(t, s.Write(metrics[1:]))
+	// the metrics of the failed write are removed, to be written again
+	require.Len(t, s.objects, 1)
+	assert.Equal(t, "cpu,host=a value=0 1465839830000000000\n",
+		s.objects["cpu/dt=2016-06-13/a/"].data.String())
+
+	f.fail = false
+	require.NoError(t, s.Write(metrics[1:]))
+	assert.Equal(t,
+		"cpu,host=a value=0 1465839830000000000\n"+
+			"cpu,host=a value=2 1465839832000000000\n",
+		f.object(t, "cpu/dt=2016-06-13/a/"))
+	assert.Equal(t, "cpu,host=b value=1 1465839831000000000\n",
+		f.object(t, "cpu/dt=2016-06-13/b/"))
+}
+
+func TestWriteBatchFormat(t *testing.T) {
+	f := newFakeS3(t)
+	defer f.Close()
+	s := newS3(t, f, "parquet")
+	s.Compression = "none"
+
+	require.NoError(t, s.Write(testMetrics(t)))
+	require.NoError(t, s.Close())
+	assert.Equal(t, []string{
+		"cpu/dt=2016-06-13/a/TIME.parquet",
+		"cpu/dt=2016-06-13/b/TIME.parquet",
+	}, f.keys())
+	data := f.object(t, "cpu/dt=2016-06-13/a/")
+	assert.True(t, strings.HasPrefix(data, "PAR1"))
+	assert.True(t, strings.HasSuffix(data, "PAR1"))
+}
+
+func TestConnectInvalid(t *testing.T) {
+	s := &S3{Key: "{{.Name}}/"}
+	assert.Error(t, s.Connect())
+	s = &S3{Bucket: "telegraf", Key: "{{.Name}}/", Compression: "zstd"}
+	assert.Error(t, s.Connect())
+	s = &S3{Bucket: "telegraf", Key: "{{.Name"}
+	assert.Error(t, s.Connect())
+}
@@ -0,0 +1,303 @@
+package tdengine
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+	"github.com/influxdata/telegraf/plugins/serializers/influx"
+)
+
+type TDengine struct {
+	// URL is the address of taosAdapter, the TDengine REST service.
+	URL      string `toml:"url"`
+	Database string
+	// CreateDatabase creates the database with nanosecond precision when
+	// connecting, if it does not exist.
+	CreateDatabase bool `toml:"create_database"`
+
+	// ChildTable is a Go template executed with each metric to get its
+	// sub-table name. The name is written to the ChildTableTag tag.
+	ChildTable    string `toml:"child_table"`
+	ChildTableTag string `toml:"child_table_tag"`
+
+	// Username and Password authenticate the requests. TDengine Cloud uses a
+	// Token instead.
+	Username string
+	Password string
+	Token    string
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	url        *url.URL
+	client     *http.Client
+	childTable *template.Template
+	serializer *influx.InfluxSerializer
+}
+
+var sampleConfig = `
+  ## URL of taosAdapter, the TDengine REST service
+  url = "http://localhost:6041"
+
+  ## Database to write to. With create_database, it is created with
+  ## nanosecond precision if it does not exist.
+  database = "telegraf"
+  # create_database = false
+
+  ## Sub-table name. This is a Go template executed with each metric, and the
+  ## name is written to the child_table_tag tag. TDengine only uses it if
+  ## smlChildTableName in taos.cfg is set to the same tag. If child_table is
+  ## empty, TDengine names the sub-table from a hash of the tags.
+  # child_table = '{{.Name}}_{{.Tags.host}}'
+  # child_table_tag = "tname"
+
+  ## Authenticate with a username and password, or with a TDengine Cloud
+  ## token
+  # username = "root"
+  # password = "taosdata"
+  # token = ""
+
+  ## Timeout of writes
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (t *TDengine) Connect() error {
+	u, err := url.Parse(t.URL)
+	if err != nil {
+		return fmt.Errorf("TDengine: invalid url %q: %s", t.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("TDengine: invalid url %q, must be http or https",
+			t.URL)
+	}
+	if t.Database == "" {
+		return fmt.Errorf("TDengine: database must be set")
+	}
+	if t.ChildTable != "" {
+		if t.ChildTableTag == "" {
+			return fmt.Errorf("TDengine: child_table_tag must be set with " +
+				"child_table")
+		}
+		t.childTable, err = template.New("child_table").
+			Option("missingkey=zero").Parse(t.ChildTable)
+		if err != nil {
+			return fmt.Errorf("TDengine: invalid child_table: %s", err)
+		}
+	}
+	if t.serializer, err = influx.NewInfluxSerializer(false, "", 0, false,
+		false); err != nil {
+		return err
+	}
+	tlsConfig, err := internal.GetTLSConfig(t.SSLCert, t.SSLKey, t.SSLCA,
+		t.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	t.url = u
+	t.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: t.Timeout.Duration,
+	}
+
+	if t.CreateDatabase {
+		return t.createDatabase()
+	}
+	return nil
+}
+
+func (t *TDengine) Close() error {
+	return nil
+}
+
+func (t *TDengine) Description() string {
+	return "Configuration for TDengine server to send metrics to"
+}
+
+func (t *TDengine) SampleConfig() string {
+	return sampleConfig
+}
+
+// Write sends the metrics in InfluxDB line protocol to the TDengine
+// schemaless write endpoint. TDengine creates a super table for each
+// measurement, a sub-table for each set of tags, and a column for each
+// field.
+func (t *TDengine) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	for _, metric := range metrics {
+		m, err := t.sanitize(metric)
+		if err != nil {
+			log.Printf("TDengine: dropping metric: %s", err)
+			continue
+		}
+		lines, err := t.serializer.Serialize(m)
+		if err != nil {
+			log.Printf("TDengine: dropping metric: %s", err)
+			continue
+		}
+		for _, line := range lines {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+		}
+	}
+	if buf.Len() == 0 {
+		return nil
+	}
+
+	u := *t.url
+	u.Path = strings.TrimRight(u.Path, "/") + "/influxdb/v1/write"
+	u.RawQuery = t.query(url.Values{
+		"db":        {t.Database},
+		"precision": {"ns"},
+	}).Encode()
+	req, err := http.NewRequest("POST", u.String(), &buf)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if err := t.do(req, false); err != nil {
+		return fmt.Errorf("TDengine: writing to %s failed: %s", t.url.Host,
+			err)
+	}
+	return nil
+}
+
+// sanitize returns a copy of a metric whose measurement, tag and field
+// names are valid TDengine identifiers. It also adds the sub-table name tag.
+func (t *TDengine) sanitize(metric telegraf.Metric) (telegraf.Metric, error) {
+	tags := make(map[string]string)
+	for k, v := range metric.Tags() {
+		tags[identifier(k)] = v
+	}
+	if t.childTable != nil {
+		var name bytes.Buffer
+		if err := t.childTable.Execute(&name, metric); err != nil {
+			return nil, fmt.Errorf("child_table for %s: %s", metric.Name(),
+				err)
+		}
+		tags[t.ChildTableTag] = identifier(name.String())
+	}
+	fields := make(map[string]interface{})
+	for k, v := range metric.Fields() {
+		fields[identifier(k)] = v
+	}
+	return telegraf.NewMetric(identifier(metric.Name()), tags, fields,
+		metric.Time())
+}
+
+// identifier makes a name a valid TDengine identifier. Characters other
+// than letters, digits and underscores become underscores, and a name that
+// starts with a digit gets an underscore prefix.
+func identifier(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' ||
+			c >= '0' && c <= '9' || c == '_') {
+			b[i] = '_'
+		}
+	}
+	if len(b) != 0 && b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// createDatabase creates the database with nanosecond precision, if it
+// does not exist.
+func (t *TDengine) createDatabase() error {
+	u := *t.url
+	u.Path = strings.TrimRight(u.Path, "/") + "/rest/sql"
+	u.RawQuery = t.query(url.Values{}).Encode()
+	req, err := http.NewRequest("POST", u.String(), strings.NewReader(
+		fmt.Sprintf("CREATE DATABASE IF NOT EXISTS %s PRECISION 'ns'",
+			identifier(t.Database))))
+	if err != nil {
+		return err
+	}
+	if err := t.do(req, true); err != nil {
+		return fmt.Errorf("TDengine: creating database %s failed: %s",
+			t.Database, err)
+	}
+	return nil
+}
+
+// query adds the TDengine Cloud token to the query of a request.
+func (t *TDengine) query(query url.Values) url.Values {
+	if t.Token != "" {
+		query.Set("token", t.Token)
+	}
+	return query
+}
+
+// do sends an authenticated request. It returns an error if taosAdapter
+// answers with an error status, or, for SQL requests, a non-zero code.
+func (t *TDengine) do(req *http.Request, sql bool) error {
+	if t.Username != "" {
+		req.SetBasicAuth(t.Username, t.Password)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	// errors are JSON objects with a code and a message, or a desc for SQL
+	var e struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Desc    string `json:"desc"`
+	}
+	decoded := json.Unmarshal(body, &e) == nil
+	if resp.StatusCode/100 == 2 && (!sql || decoded && e.Code == 0) {
+		return nil
+	}
+	if decoded && (e.Message != "" || e.Desc != "") {
+		return fmt.Errorf("%s returned HTTP status %s: %s%s", req.URL.Path,
+			resp.Status, e.Message, e.Desc)
+	}
+	return fmt.Errorf("%s returned HTTP status %s: %s", req.URL.Path,
+		resp.Status, strings.TrimSpace(string(body)))
+}
+
+func init() {
+	outputs.Add("tdengine", func() telegraf.Output {
+		return &TDengine{
+			URL:           "http://localhost:6041",
+			ChildTableTag: "tname",
+			Timeout:       internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,121 @@
+package tdengine
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+// request is a request to the fake taosAdapter.
+type request struct {
+	path  string
+	query string
+	user  string
+	body  string
+}
+
+func newFakeAdapter(t *testing.T, status int,
+	reply string) (*httptest.Server, *[]request) {
+	var requests []request
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			user, _, _ := r.BasicAuth()
+			requests = append(requests, request{r.URL.Path, r.URL.RawQuery,
+				user, string(body)})
+			w.WriteHeader(status)
+			w.Write([]byte(reply))
+		}))
+	return srv, &requests
+}
+
+func testMetric(t *testing.T) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu.usage",
+		map[string]string{"host": "a-1", "data-center": "eu"},
+		map[string]interface{}{"idle": 42.5, "5m": int64(3)},
+		time.Unix(1465839830, 0))
+	require.NoError(t, err)
+	return m
+}
+
+func TestWrite(t *testing.T) {
+	srv, requests := newFakeAdapter(t, http.StatusNoContent, "")
+	defer srv.Close()
+	td := &TDengine{
+		URL:           srv.URL,
+		Database:      "telegraf",
+		ChildTable:    "{{.Name}}_{{.Tags.host}}",
+		ChildTableTag: "tname",
+		Username:      "root",
+		Password:      "taosdata",
+	}
+	require.NoError(t, td.Connect())
+
+	require.NoError(t, td.Write([]telegraf.Metric{testMetric(t)}))
+	require.Len(t, *requests, 1)
+	r := (*requests)[0]
+	assert.Equal(t, "/influxdb/v1/write", r.path)
+	assert.Equal(t, "db=telegraf&precision=ns", r.query)
+	assert.Equal(t, "root", r.user)
+	assert.Equal(t, "cpu_usage,data_center=eu,host=a-1,tname=cpu_usage_a_1 "+
+		"_5m=3i,idle=42.5 1465839830000000000\n", r.body)
+}
+
+func TestWriteFailed(t *testing.T) {
+	srv, _ := newFakeAdapter(t, http.StatusInternalServerError,
+		`{"code":1281,"message":"Database not exist"}`)
+	defer srv.Close()
+	td := &TDengine{URL: srv.URL, Database: "telegraf"}
+	require.NoError(t, td.Connect())
+
+	err := td.Write([]telegraf.Metric{testMetric(t)})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Database not exist")
+}
+
+func TestCreateDatabase(t *testing.T) {
+	srv, requests := newFakeAdapter(t, http.StatusOK,
+		`{"code":0,"column_meta":[],"data":[],"rows":0}`)
+	defer srv.Close()
+	td := &TDengine{URL: srv.URL, Database: "telegraf", CreateDatabase: true,
+		Token: "secret"}
+	require.NoError(t, td.Connect())
+	require.Len(t, *requests, 1)
+	assert.Equal(t, "/rest/sql", (*requests)[0].path)
+	assert.Equal(t, "token=secret", (*requests)[0].query)
+	assert.Equal(t, "CREATE DATABASE IF NOT EXISTS telegraf PRECISION 'ns'",
+		(*requests)[0].body)
+
+	// SQL errors come back with a success status code
+	srv, _ = newFakeAdapter(t, http.StatusOK,
+		`{"code":9731,"desc":"Authentication failure"}`)
+	defer srv.Close()
+	td = &TDengine{URL: srv.URL, Database: "telegraf", CreateDatabase: true}
+	err := td.Connect()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "Authentication failure")
+}
+
+func TestConnectInvalid(t *testing.T) {
+	td := &TDengine{URL: "tcp://localhost:6030", Database: "telegraf"}
+	assert.Error(t, td.Connect())
+	td = &TDengine{URL: "http://localhost:6041"}
+	assert.Error(t, td.Connect())
+	td = &TDengine{URL: "http://localhost:6041", Database: "telegraf",
+		ChildTable: "{{.Name"}
+	assert.Error(t, td.Connect())
+}
+
+func TestIdentifier(t *testing.T) {
+	assert.Equal(t, "cpu_usage", identifier("cpu.usage"))
+	assert.Equal(t, "_5m", identifier("5m"))
+	assert.Equal(t, "host", identifier("host"))
+}
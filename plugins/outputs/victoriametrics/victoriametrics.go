@@ -0,0 +1,342 @@
+package victoriametrics
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+	"github.com/influxdata/telegraf/plugins/outputs"
+)
+
+type VictoriaMetrics struct {
+	// URL of a single-node VictoriaMetrics, or of vminsert in a cluster.
+	URL string `toml:"url"`
+	// Cluster imports each metric into its tenant using the vminsert
+	// paths.
+	Cluster bool
+	// AccountIDTag and ProjectIDTag name the tags that hold the tenant.
+	// DefaultAccountID and DefaultProjectID are used when a tag is missing.
+	AccountIDTag     string `toml:"account_id_tag"`
+	ProjectIDTag     string `toml:"project_id_tag"`
+	DefaultAccountID uint32 `toml:"default_account_id"`
+	DefaultProjectID uint32 `toml:"default_project_id"`
+	// ExtraLabels are name=value labels added to all metrics by
+	// VictoriaMetrics.
+	ExtraLabels []string `toml:"extra_labels"`
+	// ContentEncoding of the requests, identity or gzip.
+	ContentEncoding string `toml:"content_encoding"`
+
+	Username string
+	Password string
+	Token    string
+
+	Timeout internal.Duration
+
+	// Path to CA file
+	SSLCA string `toml:"ssl_ca"`
+	// Path to host cert file
+	SSLCert string `toml:"ssl_cert"`
+	// Path to cert key file
+	SSLKey string `toml:"ssl_key"`
+	// Use SSL but skip chain & host verification
+	InsecureSkipVerify bool
+
+	url    *url.URL
+	client *http.Client
+}
+
+var sampleConfig = `
+  ## URL of a single-node VictoriaMetrics, or of vminsert in a cluster
+  url = "http://localhost:8428"
+
+  ## Import each metric into its tenant through vminsert. The accountID and
+  ## projectID come from these tags, which are removed from the labels. The
+  ## defaults are used when a tag is missing.
+  # cluster = false
+  # account_id_tag = "vm_account_id"
+  # project_id_tag = "vm_project_id"
+  # default_account_id = 0
+  # default_project_id = 0
+
+  ## Labels added to all metrics, as name=value
+  # extra_labels = ["env=prod"]
+
+  ## Request compression: identity or gzip
+  # content_encoding = "gzip"
+
+  ## Authenticate with a username and password, or a bearer token
+  # username = ""
+  # password = ""
+  # token = ""
+
+  ## Write timeout
+  # timeout = "5s"
+
+  ## Optional SSL Config
+  # ssl_ca = "/etc/telegraf/ca.pem"
+  # ssl_cert = "/etc/telegraf/cert.pem"
+  # ssl_key = "/etc/telegraf/key.pem"
+  ## Use SSL but skip chain & host verification
+  # insecure_skip_verify = false
+`
+
+func (v *VictoriaMetrics) Connect() error {
+	u, err := url.Parse(v.URL)
+	if err != nil {
+		return fmt.Errorf("VictoriaMetrics: invalid url %q: %s", v.URL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("VictoriaMetrics: invalid url %q, must be http or "+
+			"https", v.URL)
+	}
+	switch v.ContentEncoding {
+	case "", "identity", "gzip":
+	default:
+		return fmt.Errorf("VictoriaMetrics: invalid content_encoding %q, "+
+			"must be identity or gzip", v.ContentEncoding)
+	}
+	for _, label := range v.ExtraLabels {
+		if i := strings.Index(label, "="); i <= 0 {
+			return fmt.Errorf("VictoriaMetrics: invalid extra_labels entry "+
+				"%q, must be name=value", label)
+		}
+	}
+	tlsConfig, err := internal.GetTLSConfig(v.SSLCert, v.SSLKey, v.SSLCA,
+		v.InsecureSkipVerify)
+	if err != nil {
+		return err
+	}
+	v.url = u
+	v.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+		Timeout: v.Timeout.Duration,
+	}
+	return nil
+}
+
+func (v *VictoriaMetrics) Close() error {
+	return nil
+}
+
+func (v *VictoriaMetrics) Description() string {
+	return "Configuration for VictoriaMetrics server to send metrics to"
+}
+
+func (v *VictoriaMetrics) SampleConfig() string {
+	return sampleConfig
+}
+
+// series is one line of the /api/v1/import JSON line format. It holds the
+// values of one time series.
+type series struct {
+	Metric     map[string]string `json:"metric"`
+	Values     []float64         `json:"values"`
+	Timestamps []int64           `json:"timestamps"`
+}
+
+// Write imports the metrics with one request per tenant. Each numeric or
+// boolean field is a time series named <measurement>_<field>, labeled with
+// the metric tags.
+func (v *VictoriaMetrics) Write(metrics []telegraf.Metric) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+	var tenants []string
+	lines := make(map[string][]*series)
+	index := make(map[string]*series)
+	for _, metric := range metrics {
+		tenant, labels, err := v.tenant(metric)
+		if err != nil {
+			log.Printf("VictoriaMetrics: dropping metric: %s", err)
+			continue
+		}
+		fields := metric.Fields()
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			value, ok := number(fields[name])
+			if !ok {
+				continue
+			}
+			metricName := metric.Name() + "_" + name
+			key := tenant + "\n" + seriesKey(metricName, labels)
+			s, ok := index[key]
+			if !ok {
+				s = &series{Metric: map[string]string{"__name__": metricName}}
+				for k, l := range labels {
+					s.Metric[k] = l
+				}
+				index[key] = s
+				if _, ok := lines[tenant]; !ok {
+					tenants = append(tenants, tenant)
+				}
+				lines[tenant] = append(lines[tenant], s)
+			}
+			s.Values = append(s.Values, value)
+			s.Timestamps = append(s.Timestamps, metric.UnixNano()/1e6)
+		}
+	}
+
+	for _, tenant := range tenants {
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		for _, s := range lines[tenant] {
+			if err := enc.Encode(s); err != nil {
+				return err
+			}
+		}
+		if err := v.write(tenant, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tenant returns the accountID:projectID tenant of a metric in cluster mode,
+// and the metric labels without the tenant tags.
+func (v *VictoriaMetrics) tenant(metric telegraf.Metric) (string,
+	map[string]string, error) {
+	labels := metric.Tags()
+	if !v.Cluster {
+		return "", labels, nil
+	}
+	accountID := strconv.FormatUint(uint64(v.DefaultAccountID), 10)
+	projectID := strconv.FormatUint(uint64(v.DefaultProjectID), 10)
+	if id, ok := labels[v.AccountIDTag]; ok && v.AccountIDTag != "" {
+		accountID = id
+	}
+	if id, ok := labels[v.ProjectIDTag]; ok && v.ProjectIDTag != "" {
+		projectID = id
+	}
+	for _, id := range []string{accountID, projectID} {
+		if _, err := strconv.ParseUint(id, 10, 32); err != nil {
+			return "", nil, fmt.Errorf("invalid tenant id %q", id)
+		}
+	}
+	filtered := make(map[string]string, len(labels))
+	for k, l := range labels {
+		if k != v.AccountIDTag && k != v.ProjectIDTag {
+			filtered[k] = l
+		}
+	}
+	return accountID + ":" + projectID, filtered, nil
+}
+
+func seriesKey(name string, labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	b.WriteString(name)
+	for _, k := range keys {
+		b.WriteByte('\x00')
+		b.WriteString(k)
+		b.WriteByte('\x00')
+		b.WriteString(labels[k])
+	}
+	return b.String()
+}
+
+// number returns the value of a numeric or boolean field. NaNs and
+// infinities are rejected, as the import JSON cannot represent them.
+func number(value interface{}) (float64, bool) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case int64:
+		f = float64(v)
+	case bool:
+		if v {
+			f = 1
+		}
+	default:
+		return 0, false
+	}
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+func (v *VictoriaMetrics) write(tenant string, data []byte) error {
+	u := *v.url
+	path := "/api/v1/import"
+	if tenant != "" {
+		path = "/insert/" + tenant + "/prometheus/api/v1/import"
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	query := u.Query()
+	for _, label := range v.ExtraLabels {
+		query.Add("extra_label", label)
+	}
+	u.RawQuery = query.Encode()
+
+	var body bytes.Buffer
+	if v.ContentEncoding == "gzip" {
+		w := gzip.NewWriter(&body)
+		w.Write(data)
+		if err := w.Close(); err != nil {
+			return err
+		}
+	} else {
+		body.Write(data)
+	}
+	req, err := http.NewRequest("POST", u.String(), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/stream+json")
+	if v.ContentEncoding == "gzip" {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+	if v.Username != "" {
+		req.SetBasicAuth(v.Username, v.Password)
+	} else if v.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+v.Token)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("VictoriaMetrics: %s", err)
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("VictoriaMetrics: import failed, %s: %s",
+			resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func init() {
+	outputs.Add("victoriametrics", func() telegraf.Output {
+		return &VictoriaMetrics{
+			URL:             "http://localhost:8428",
+			AccountIDTag:    "vm_account_id",
+			ProjectIDTag:    "vm_project_id",
+			ContentEncoding: "gzip",
+			Timeout:         internal.Duration{Duration: 5 * time.Second},
+		}
+	})
+}
@@ -0,0 +1,143 @@
+package victoriametrics
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal"
+)
+
+type request struct {
+	path  string
+	query string
+	body  string
+}
+
+func newServer(t *testing.T, requests *[]request) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		body := r.Body
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = zr
+		}
+		b, err := ioutil.ReadAll(body)
+		require.NoError(t, err)
+		*requests = append(*requests, request{r.URL.Path, r.URL.RawQuery,
+			string(b)})
+		w.WriteHeader(http.StatusNoContent)
+	}))
+}
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	m1, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "vm_account_id": "42"},
+		map[string]interface{}{"usage": 91.5, "up": true, "state": "ok"},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	m2, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "vm_account_id": "42"},
+		map[string]interface{}{"usage": int64(90)},
+		time.Unix(1465839840, 0))
+	require.NoError(t, err)
+	m3, err := telegraf.NewMetric("mem",
+		map[string]string{"host": "web2"},
+		map[string]interface{}{"used": int64(42)},
+		time.Unix(1465839850, 0))
+	require.NoError(t, err)
+	return []telegraf.Metric{m1, m2, m3}
+}
+
+func newVictoriaMetrics(url string) *VictoriaMetrics {
+	return &VictoriaMetrics{
+		URL:             url,
+		AccountIDTag:    "vm_account_id",
+		ProjectIDTag:    "vm_project_id",
+		ContentEncoding: "gzip",
+		Token:           "secret",
+		Timeout:         internal.Duration{Duration: 5 * time.Second},
+	}
+}
+
+func TestWrite(t *testing.T) {
+	var requests []request
+	ts := newServer(t, &requests)
+	defer ts.Close()
+
+	v := newVictoriaMetrics(ts.URL)
+	v.ExtraLabels = []string{"env=prod", "dc=eu"}
+	require.NoError(t, v.Connect())
+	require.NoError(t, v.Write(testMetrics(t)))
+
+	// each series is one line with all its values
+	require.Len(t, requests, 1)
+	assert.Equal(t, "/api/v1/import", requests[0].path)
+	assert.Equal(t, "extra_label=env%3Dprod&extra_label=dc%3Deu",
+		requests[0].query)
+	assert.Equal(t, `{"metric":{"__name__":"cpu_up","host":"web1",`+
+		`"vm_account_id":"42"},"values":[1],"timestamps":[1465839830123]}
+{"metric":{"__name__":"cpu_usage","host":"web1","vm_account_id":"42"},`+
+		`"values":[91.5,90],"timestamps":[1465839830123,1465839840000]}
+{"metric":{"__name__":"mem_used","host":"web2"},"values":[42],`+
+		`"timestamps":[1465839850000]}
+`, requests[0].body)
+}
+
+func TestWriteCluster(t *testing.T) {
+	var requests []request
+	ts := newServer(t, &requests)
+	defer ts.Close()
+
+	v := newVictoriaMetrics(ts.URL)
+	v.Cluster = true
+	v.DefaultProjectID = 7
+	v.ContentEncoding = "identity"
+	require.NoError(t, v.Connect())
+	require.NoError(t, v.Write(testMetrics(t)))
+
+	// the tenants are of the tags, removed from the labels
+	require.Len(t, requests, 2)
+	assert.Equal(t, "/insert/42:7/prometheus/api/v1/import", requests[0].path)
+	assert.Equal(t, `{"metric":{"__name__":"cpu_up","host":"web1"},`+
+		`"values":[1],"timestamps":[1465839830123]}
+{"metric":{"__name__":"cpu_usage","host":"web1"},"values":[91.5,90],`+
+		`"timestamps":[1465839830123,1465839840000]}
+`, requests[0].body)
+	assert.Equal(t, "/insert/0:7/prometheus/api/v1/import", requests[1].path)
+}
+
+func TestWriteError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("cannot parse json line\n"))
+	}))
+	defer ts.Close()
+
+	v := newVictoriaMetrics(ts.URL)
+	require.NoError(t, v.Connect())
+	err := v.Write(testMetrics(t))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cannot parse json line")
+}
+
+func TestConnectInvalid(t *testing.T) {
+	v := newVictoriaMetrics("udp://localhost:8428")
+	assert.Error(t, v.Connect())
+	v = newVictoriaMetrics("http://localhost:8428")
+	v.ContentEncoding = "zstd"
+	assert.Error(t, v.Connect())
+	v = newVictoriaMetrics("http://localhost:8428")
+	v.ExtraLabels = []string{"env"}
+	assert.Error(t, v.Connect())
+}
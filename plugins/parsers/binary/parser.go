@@ -0,0 +1,353 @@
+package binary
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+// sizes are the field type sizes in bytes. Strings and padding are missing,
+// since their field sets the size.
+var sizes = map[string]int{
+	"int8":    1,
+	"uint8":   1,
+	"bool":    1,
+	"int16":   2,
+	"uint16":  2,
+	"int32":   4,
+	"uint32":  4,
+	"float32": 4,
+	"int64":   8,
+	"uint64":  8,
+	"float64": 8,
+}
+
+// Field is one field in the record layout of binary data.
+type Field struct {
+	// Name is the metric field or tag name. It is empty for padding.
+	Name string `toml:"name"`
+	// Type is the type of the field: int8, int16, int32, int64, uint8,
+	// uint16, uint32, uint64, float32, float64, bool (a byte, true if not
+	// zero), string (Size bytes, up to the first NUL) or padding (Size
+	// bytes that are skipped).
+	Type string `toml:"type"`
+	// Offset is the field offset in the record, in bytes. If nil, the field
+	// starts at the end of the previous field.
+	Offset *int `toml:"offset"`
+	// Size is the size of string and padding fields, in bytes.
+	Size int `toml:"size"`
+	// Endianness is the byte order of the field. If empty, the parser byte
+	// order is used.
+	Endianness string `toml:"endianness"`
+	// Bits is the width of a bitfield in an integer or bool field, starting
+	// at the BitOffset'th least significant bit. If zero, every bit is used.
+	// Bitfields of signed types are sign extended.
+	Bits      int `toml:"bits"`
+	BitOffset int `toml:"bit_offset"`
+	// Count is the number of consecutive values in the field. If more than
+	// one, they are named <name>_0 to <name>_<count-1>.
+	Count int `toml:"count"`
+	// Tag is true if the value is a metric tag, not a field.
+	Tag bool `toml:"tag"`
+}
+
+// BinaryParser parses binary records with a fixed layout, such as the structs
+// sent by embedded devices, into one metric per record. The records are
+// consecutive, and the data must hold a whole number of them.
+type BinaryParser struct {
+	MetricName string
+	// Endianness is the byte order of the fields: big (if empty) or little.
+	Endianness string
+	Fields     []Field
+	// TimeKey is the name of the field holding the metric time, an epoch in
+	// TimeFormat. If empty, the parse time is used.
+	TimeKey string
+	// TimeFormat is the format of TimeKey: unix (if empty), unix_ms, unix_us
+	// or unix_ns.
+	TimeFormat  string
+	DefaultTags map[string]string
+
+	// offsets are the Fields offsets, and size is the record size
+	offsets []int
+	size    int
+}
+
+func NewBinaryParser(
+	metricName string,
+	endianness string,
+	fields []Field,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (*BinaryParser, error) {
+	if _, err := byteOrder(endianness); err != nil {
+		return nil, fmt.Errorf("invalid binary_endianness %q, %s", endianness,
+			err)
+	}
+	if _, ok := epochUnits[timeFormat]; !ok {
+		return nil, fmt.Errorf("invalid binary_time_format %q, must be unix, "+
+			"unix_ms, unix_us or unix_ns", timeFormat)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("no binary_fields")
+	}
+
+	p := &BinaryParser{
+		MetricName:  metricName,
+		Endianness:  endianness,
+		Fields:      fields,
+		TimeKey:     timeKey,
+		TimeFormat:  timeFormat,
+		DefaultTags: defaultTags,
+	}
+	offset := 0
+	for i, f := range fields {
+		size, err := f.validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid binary_fields %d (%s), %s", i+1,
+				f.Name, err)
+		}
+		if f.Offset != nil {
+			offset = *f.Offset
+		}
+		p.offsets = append(p.offsets, offset)
+		offset += size * f.count()
+		if offset > p.size {
+			p.size = offset
+		}
+	}
+	if p.size == 0 {
+		return nil, fmt.Errorf("binary_fields has no bytes")
+	}
+	return p, nil
+}
+
+// validate returns the size of one field element, or an error if the field
+// is invalid.
+func (f *Field) validate() (int, error) {
+	if _, err := byteOrder(f.Endianness); err != nil {
+		return 0, err
+	}
+	if f.Offset != nil && *f.Offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", *f.Offset)
+	}
+	if f.Count < 0 {
+		return 0, fmt.Errorf("negative count %d", f.Count)
+	}
+	if f.Type != "padding" && f.Name == "" {
+		return 0, fmt.Errorf("no name")
+	}
+
+	size, ok := sizes[f.Type]
+	switch {
+	case f.Type == "string" || f.Type == "padding":
+		if f.Size <= 0 {
+			return 0, fmt.Errorf("%s size must be positive", f.Type)
+		}
+		size = f.Size
+	case !ok:
+		return 0, fmt.Errorf("unknown type %q", f.Type)
+	case f.Size != 0 && f.Size != size:
+		return 0, fmt.Errorf("%s size %d must be %d", f.Type, f.Size, size)
+	}
+
+	if f.Bits != 0 || f.BitOffset != 0 {
+		if !ok || strings.HasPrefix(f.Type, "float") {
+			return 0, fmt.Errorf("bitfield on %s, must be an integer or bool",
+				f.Type)
+		}
+		if f.Bits <= 0 || f.BitOffset < 0 || f.BitOffset+f.Bits > size*8 {
+			return 0, fmt.Errorf("%d bit bitfield at bit %d is outside %s",
+				f.Bits, f.BitOffset, f.Type)
+		}
+	}
+	return size, nil
+}
+
+func (f *Field) count() int {
+	if f.Count == 0 {
+		return 1
+	}
+	return f.Count
+}
+
+func byteOrder(endianness string) (binary.ByteOrder, error) {
+	switch endianness {
+	case "", "big":
+		return binary.BigEndian, nil
+	case "little":
+		return binary.LittleEndian, nil
+	}
+	return nil, fmt.Errorf("must be big or little")
+}
+
+func (p *BinaryParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	if len(buf) == 0 || len(buf)%p.size != 0 {
+		return nil, fmt.Errorf("unable to parse out as binary, %d bytes are "+
+			"not a whole number of %d byte records", len(buf), p.size)
+	}
+
+	metrics := make([]telegraf.Metric, 0, len(buf)/p.size)
+	for ; len(buf) != 0; buf = buf[p.size:] {
+		metric, err := p.record(buf[:p.size])
+		if err != nil {
+			return nil, err
+		}
+		if metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// record returns the metric for a record, or nil if it has no fields.
+func (p *BinaryParser) record(record []byte) (telegraf.Metric, error) {
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	var timestamp interface{}
+
+	for i := range p.Fields {
+		f := &p.Fields[i]
+		if f.Type == "padding" {
+			continue
+		}
+		order, _ := byteOrder(f.Endianness)
+		if f.Endianness == "" {
+			order, _ = byteOrder(p.Endianness)
+		}
+		size, ok := sizes[f.Type]
+		if !ok {
+			size = f.Size
+		}
+
+		for n := 0; n < f.count(); n++ {
+			offset := p.offsets[i] + n*size
+			v := f.decode(order, record[offset:offset+size])
+			name := f.Name
+			if f.Count > 1 {
+				name += "_" + strconv.Itoa(n)
+			}
+			switch {
+			case name == p.TimeKey:
+				timestamp = v
+			case f.Tag:
+				tags[name] = fmt.Sprint(v)
+			default:
+				// NaN and infinities are not valid field values
+				if x, ok := v.(float64); ok &&
+					(math.IsNaN(x) || math.IsInf(x, 0)) {
+					continue
+				}
+				fields[name] = v
+			}
+		}
+	}
+
+	t := time.Now().UTC()
+	if p.TimeKey != "" {
+		var err error
+		if t, err = p.timestamp(timestamp); err != nil {
+			return nil, err
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return telegraf.NewMetric(p.MetricName, tags, fields, t)
+}
+
+// decode decodes the bytes of one field element into an int64, float64,
+// bool or string. InfluxDB has no unsigned integers, so unsigned values that
+// overflow an int64 become the largest int64, as in the accumulator.
+func (f *Field) decode(order binary.ByteOrder, b []byte) interface{} {
+	var u uint64
+	switch f.Type {
+	case "string":
+		if i := strings.IndexByte(string(b), 0); i >= 0 {
+			b = b[:i]
+		}
+		return string(b)
+	case "float32":
+		return float64(math.Float32frombits(order.Uint32(b)))
+	case "float64":
+		return math.Float64frombits(order.Uint64(b))
+	case "int8", "uint8", "bool":
+		u = uint64(b[0])
+	case "int16", "uint16":
+		u = uint64(order.Uint16(b))
+	case "int32", "uint32":
+		u = uint64(order.Uint32(b))
+	default:
+		u = order.Uint64(b)
+	}
+
+	bits := uint(len(b) * 8)
+	if f.Bits != 0 {
+		u >>= uint(f.BitOffset)
+		bits = uint(f.Bits)
+	}
+	if bits < 64 {
+		u &= 1<<bits - 1
+	}
+	switch {
+	case f.Type == "bool":
+		return u != 0
+	case strings.HasPrefix(f.Type, "uint"):
+		if u > math.MaxInt64 {
+			return int64(math.MaxInt64)
+		}
+		return int64(u)
+	}
+	// sign extend the bits
+	return int64(u<<(64-bits)) >> (64 - bits)
+}
+
+// timestamp converts the value of the TimeKey into a time.
+func (p *BinaryParser) timestamp(v interface{}) (time.Time, error) {
+	unit := epochUnits[p.TimeFormat]
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(0, v*int64(unit)).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(unit))).UTC(), nil
+	case nil:
+		return time.Time{}, fmt.Errorf("binary_time_key %s not found",
+			p.TimeKey)
+	}
+	return time.Time{}, fmt.Errorf("binary_time_key %s is %v, not an epoch",
+		p.TimeKey, v)
+}
+
+func (p *BinaryParser) ParseLine(line string) (telegraf.Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) < 1 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"binary", line)
+	}
+
+	return metrics[0], nil
+}
+
+func (p *BinaryParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
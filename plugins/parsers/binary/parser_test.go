@@ -0,0 +1,164 @@
+package binary
+
+import (
+	"encoding/binary"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func offset(n int) *int {
+	return &n
+}
+
+// record encodes a record with the testFields layout.
+func record(t *testing.T, id string, temperature int16, status byte,
+	counters [3]uint32, voltage float32, timestamp uint32) []byte {
+	b := make([]byte, 0, 28)
+	b = append(b, []byte(id)...)
+	b = append(b, make([]byte, 4-len(id))...)
+	b = append(b, byte(uint16(temperature)>>8), byte(temperature))
+	b = append(b, status, 0)
+	for _, c := range counters {
+		b = append(b, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint32(b[len(b)-4:], c)
+	}
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], math.Float32bits(voltage))
+	b = append(b, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[len(b)-4:], timestamp)
+	require.Len(t, b, 28)
+	return b
+}
+
+var testFields = []Field{
+	{Name: "id", Type: "string", Size: 4, Tag: true},
+	{Name: "temperature", Type: "int16"},
+	{Name: "alarm", Type: "bool", Bits: 1, BitOffset: 7},
+	{Name: "mode", Type: "int8", Offset: offset(6), Bits: 3},
+	{Type: "padding", Size: 1},
+	{Name: "counter", Type: "uint32", Count: 3, Endianness: "little"},
+	{Name: "voltage", Type: "float32"},
+	{Name: "time", Type: "uint32"},
+}
+
+func TestParse(t *testing.T) {
+	p, err := NewBinaryParser("device", "", testFields, "time", "", nil)
+	require.NoError(t, err)
+
+	buf := append(
+		record(t, "m1", -125, 0x85, [3]uint32{1, 2, 3}, 230.5, 1465839830),
+		record(t, "m2", 210, 0x06, [3]uint32{4, 5, 6}, 231, 1465839831)...)
+	metrics, err := p.Parse(buf)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "device", metrics[0].Name())
+	assert.Equal(t, map[string]string{"id": "m1"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"temperature": int64(-125),
+		"alarm":       true,
+		"mode":        int64(-3),
+		"counter_0":   int64(1),
+		"counter_1":   int64(2),
+		"counter_2":   int64(3),
+		"voltage":     230.5,
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Unix(1465839830, 0).UTC(), metrics[0].Time())
+
+	assert.Equal(t, map[string]string{"id": "m2"}, metrics[1].Tags())
+	assert.Equal(t, false, metrics[1].Fields()["alarm"])
+	assert.Equal(t, int64(-2), metrics[1].Fields()["mode"])
+	assert.Equal(t, int64(6), metrics[1].Fields()["counter_2"])
+	assert.Equal(t, time.Unix(1465839831, 0).UTC(), metrics[1].Time())
+}
+
+func TestParseEndianness(t *testing.T) {
+	fields := []Field{
+		{Name: "a", Type: "uint16"},
+		{Name: "b", Type: "int32", Endianness: "big"},
+		{Name: "c", Type: "float64"},
+	}
+	buf := []byte{1, 0, 0xff, 0xff, 0xff, 0xfe, 0, 0, 0, 0, 0, 0, 0xf0, 0x3f}
+
+	p, err := NewBinaryParser("binary", "little", fields, "", "", nil)
+	require.NoError(t, err)
+	m, err := p.ParseLine(string(buf))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"a": int64(1),
+		"b": int64(-2),
+		"c": 1.0,
+	}, m.Fields())
+
+	p, err = NewBinaryParser("binary", "", []Field{
+		{Name: "a", Type: "uint64"},
+	}, "", "", nil)
+	require.NoError(t, err)
+	m, err = p.ParseLine("\xff\xff\xff\xff\xff\xff\xff\xfe")
+	require.NoError(t, err)
+	assert.Equal(t, int64(math.MaxInt64), m.Fields()["a"])
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewBinaryParser("binary", "", []Field{
+		{Name: "a", Type: "uint16"},
+		{Name: "b", Type: "float32"},
+	}, "", "", nil)
+	require.NoError(t, err)
+
+	for _, buf := range [][]byte{nil, {1, 2, 3}, make([]byte, 7)} {
+		_, err := p.Parse(buf)
+		assert.Error(t, err)
+	}
+
+	// NaN is not a field, and a record without fields is not a metric
+	nan := make([]byte, 4)
+	binary.BigEndian.PutUint32(nan, math.Float32bits(float32(math.NaN())))
+	metrics, err := p.Parse(append([]byte{0, 1}, nan...))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, map[string]interface{}{"a": int64(1)},
+		metrics[0].Fields())
+
+	p, err = NewBinaryParser("binary", "", []Field{
+		{Name: "s", Type: "string", Size: 2, Tag: true},
+	}, "", "", nil)
+	require.NoError(t, err)
+	metrics, err = p.Parse([]byte("ab"))
+	require.NoError(t, err)
+	assert.Len(t, metrics, 0)
+	_, err = p.ParseLine("ab")
+	assert.Error(t, err)
+}
+
+func TestNewBinaryParserInvalid(t *testing.T) {
+	for _, f := range []Field{
+		{Name: "a", Type: "int24"},
+		{Type: "uint8"},
+		{Name: "a", Type: "string"},
+		{Type: "padding", Size: -1},
+		{Name: "a", Type: "uint8", Size: 2},
+		{Name: "a", Type: "uint8", Endianness: "middle"},
+		{Name: "a", Type: "uint8", Offset: offset(-1)},
+		{Name: "a", Type: "uint8", Count: -1},
+		{Name: "a", Type: "float32", Bits: 1},
+		{Name: "a", Type: "uint8", Bits: 4, BitOffset: 5},
+		{Name: "a", Type: "uint8", BitOffset: 1},
+	} {
+		_, err := NewBinaryParser("binary", "", []Field{f}, "", "", nil)
+		assert.Error(t, err, "%+v", f)
+	}
+
+	_, err := NewBinaryParser("binary", "", nil, "", "", nil)
+	assert.Error(t, err)
+	_, err = NewBinaryParser("binary", "native", testFields, "", "", nil)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "binary_endianness"))
+	_, err = NewBinaryParser("binary", "", testFields, "time", "unix_s", nil)
+	assert.Error(t, err)
+}
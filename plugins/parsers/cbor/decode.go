@@ -0,0 +1,337 @@
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+)
+
+// CBOR major types, RFC 7049.
+const (
+	majorUint   = 0
+	majorNegint = 1
+	majorBytes  = 2
+	majorText   = 3
+	majorArray  = 4
+	majorMap    = 5
+	majorTag    = 6
+	majorSimple = 7
+)
+
+// Date and time tags.
+const (
+	tagDateTime = 0
+	tagEpoch    = 1
+)
+
+// maxDepth is the deepest nesting of arrays, maps and tags that is decoded.
+const maxDepth = 128
+
+// indefinite is the additional information for an indefinite length.
+const indefinite = 31
+
+var (
+	errTruncated = errors.New("unexpected end of data")
+	errBreak     = errors.New("unexpected break")
+)
+
+// decoder decodes the CBOR data items in a buffer into values. Integers are
+// int64, or uint64 if they overflow int64. Floats are float64, byte strings
+// are []byte, text strings are string and arrays are []interface{}. Maps are
+// map[string]interface{} with their keys formatted. Dates in tags 0 and 1 are
+// time.Time. Other tags take the value of their item. Null and undefined are
+// nil.
+type decoder struct {
+	buf []byte
+}
+
+// decode decodes the next data item.
+func (d *decoder) decode(depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("nested deeper than %d", maxDepth)
+	}
+	if len(d.buf) == 0 {
+		return nil, errTruncated
+	}
+	major, info := d.buf[0]>>5, d.buf[0]&0x1f
+	d.buf = d.buf[1:]
+
+	if major == majorSimple {
+		return d.simple(info)
+	}
+
+	if info == indefinite {
+		return d.indefinite(major, depth)
+	}
+	n, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case majorUint:
+		if n > math.MaxInt64 {
+			return n, nil
+		}
+		return int64(n), nil
+	case majorNegint:
+		if n > math.MaxInt64 {
+			return nil, errors.New("negative integer overflows an int64")
+		}
+		return -1 - int64(n), nil
+	case majorBytes, majorText:
+		if uint64(len(d.buf)) < n {
+			return nil, errTruncated
+		}
+		b := d.buf[:n]
+		d.buf = d.buf[n:]
+		if major == majorText {
+			return string(b), nil
+		}
+		return append(make([]byte, 0, n), b...), nil
+	case majorArray:
+		// each item takes a byte at least
+		if uint64(len(d.buf)) < n {
+			return nil, errTruncated
+		}
+		values := make([]interface{}, 0, n)
+		for i := uint64(0); i < n; i++ {
+			v, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case majorMap:
+		if uint64(len(d.buf)) < 2*n {
+			return nil, errTruncated
+		}
+		values := make(map[string]interface{}, n)
+		for i := uint64(0); i < n; i++ {
+			if err := d.entry(values, depth); err != nil {
+				return nil, err
+			}
+		}
+		return values, nil
+	default:
+		v, err := d.decode(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		return tagged(n, v)
+	}
+}
+
+// argument decodes the argument of a data item, from its additional
+// information and the bytes following it.
+func (d *decoder) argument(info byte) (uint64, error) {
+	var size int
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, fmt.Errorf("invalid additional information %d", info)
+	}
+	if len(d.buf) < size {
+		return 0, errTruncated
+	}
+	var n uint64
+	switch size {
+	case 1:
+		n = uint64(d.buf[0])
+	case 2:
+		n = uint64(binary.BigEndian.Uint16(d.buf))
+	case 4:
+		n = uint64(binary.BigEndian.Uint32(d.buf))
+	case 8:
+		n = binary.BigEndian.Uint64(d.buf)
+	}
+	d.buf = d.buf[size:]
+	return n, nil
+}
+
+// indefinite decodes a string, array or map with an indefinite length. Its
+// items end with a break.
+func (d *decoder) indefinite(major byte, depth int) (interface{}, error) {
+	switch major {
+	case majorBytes, majorText:
+		b := []byte{}
+		for {
+			v, err := d.decode(depth + 1)
+			if err == errBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			switch chunk := v.(type) {
+			case []byte:
+				if major != majorBytes {
+					return nil, errors.New("invalid text string chunk")
+				}
+				b = append(b, chunk...)
+			case string:
+				if major != majorText {
+					return nil, errors.New("invalid byte string chunk")
+				}
+				b = append(b, chunk...)
+			default:
+				return nil, errors.New("invalid string chunk")
+			}
+		}
+		if major == majorText {
+			return string(b), nil
+		}
+		return b, nil
+	case majorArray:
+		values := []interface{}{}
+		for {
+			v, err := d.decode(depth + 1)
+			if err == errBreak {
+				return values, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+	case majorMap:
+		values := make(map[string]interface{})
+		for {
+			err := d.entry(values, depth)
+			if err == errBreak {
+				return values, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return nil, fmt.Errorf("invalid indefinite length for major type %d",
+		major)
+}
+
+// entry decodes the key and value of a map entry.
+func (d *decoder) entry(values map[string]interface{}, depth int) error {
+	k, err := d.decode(depth + 1)
+	if err != nil {
+		return err
+	}
+	v, err := d.decode(depth + 1)
+	if err == errBreak {
+		return errors.New("map key without a value")
+	}
+	if err != nil {
+		return err
+	}
+	switch key := k.(type) {
+	case string:
+		values[key] = v
+	case []byte:
+		values[string(key)] = v
+	default:
+		values[fmt.Sprint(key)] = v
+	}
+	return nil
+}
+
+// simple decodes a simple value or a float.
+func (d *decoder) simple(info byte) (interface{}, error) {
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23:
+		return nil, nil
+	case 25:
+		if len(d.buf) < 2 {
+			return nil, errTruncated
+		}
+		f := halfFloat(binary.BigEndian.Uint16(d.buf))
+		d.buf = d.buf[2:]
+		return f, nil
+	case 26:
+		if len(d.buf) < 4 {
+			return nil, errTruncated
+		}
+		f := math.Float32frombits(binary.BigEndian.Uint32(d.buf))
+		d.buf = d.buf[4:]
+		return float64(f), nil
+	case 27:
+		if len(d.buf) < 8 {
+			return nil, errTruncated
+		}
+		f := math.Float64frombits(binary.BigEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return f, nil
+	case indefinite:
+		return nil, errBreak
+	case 24:
+		// a simple value in the next byte, that has no meaning
+		if len(d.buf) < 1 {
+			return nil, errTruncated
+		}
+		d.buf = d.buf[1:]
+		return nil, nil
+	}
+	// the unassigned simple values
+	return nil, nil
+}
+
+// tagged returns the value of a tagged data item.
+func tagged(tag uint64, v interface{}) (interface{}, error) {
+	switch tag {
+	case tagDateTime:
+		s, ok := v.(string)
+		if !ok {
+			return nil, errors.New("date/time tag on a non-string")
+		}
+		return time.Parse(time.RFC3339Nano, s)
+	case tagEpoch:
+		switch t := v.(type) {
+		case int64:
+			return time.Unix(t, 0).UTC(), nil
+		case uint64:
+			return time.Unix(int64(t), 0).UTC(), nil
+		case float64:
+			sec, frac := math.Modf(t)
+			return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+		}
+		return nil, errors.New("epoch tag on a non-number")
+	}
+	return v, nil
+}
+
+// halfFloat returns the value of an IEEE 754 half-precision float.
+func halfFloat(h uint16) float64 {
+	exp, mant := int(h>>10)&0x1f, float64(h&0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
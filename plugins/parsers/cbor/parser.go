@@ -0,0 +1,283 @@
+package cbor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// CBORParser parses CBOR data, RFC 7049, into metrics. The data is a map, an
+// array of maps, or a sequence of either, and each map is a metric.
+//
+// Nested maps and arrays are flattened like JSON ones, but numbers keep
+// their type, integers staying integers.
+type CBORParser struct {
+	MetricName string
+	// TagKeys are the map keys whose values are tags.
+	TagKeys []string
+	// Query is the path to the map, or array of maps, holding the metrics. It
+	// is made of keys and array indexes separated by dots, such as
+	// "data.readings". If empty, the data itself is used.
+	Query string
+	// NameKey is the key whose value is the metric name, instead of
+	// MetricName.
+	NameKey string
+	// TimeKey is the key whose value is the metric time. The value is a
+	// date/time tag, an epoch in TimeFormat, or a string in its layout. If
+	// empty, the parse time is used.
+	TimeKey string
+	// TimeFormat is the format of TimeKey: unix (if empty), unix_ms, unix_us,
+	// unix_ns, or a Go time layout such as 2006-01-02T15:04:05Z07:00.
+	TimeFormat  string
+	DefaultTags map[string]string
+}
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+func NewCBORParser(
+	metricName string,
+	tagKeys []string,
+	query string,
+	nameKey string,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (*CBORParser, error) {
+	if _, ok := epochUnits[timeFormat]; !ok &&
+		strings.HasPrefix(timeFormat, "unix") {
+		return nil, fmt.Errorf("invalid cbor_time_format %q, must be unix, "+
+			"unix_ms, unix_us, unix_ns or a time layout", timeFormat)
+	}
+	return &CBORParser{
+		MetricName:  metricName,
+		TagKeys:     tagKeys,
+		Query:       query,
+		NameKey:     nameKey,
+		TimeKey:     timeKey,
+		TimeFormat:  timeFormat,
+		DefaultTags: defaultTags,
+	}, nil
+}
+
+func (p *CBORParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+
+	d := &decoder{buf: buf}
+	for len(d.buf) > 0 {
+		v, err := d.decode(0)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse out as CBOR, %s", err)
+		}
+
+		if p.Query != "" {
+			if v = lookup(v, p.Query); v == nil {
+				return nil, fmt.Errorf("cbor_query %s not found", p.Query)
+			}
+		}
+
+		switch t := v.(type) {
+		case map[string]interface{}:
+			metric, err := p.parseObject(t)
+			if err != nil {
+				return nil, err
+			}
+			metrics = append(metrics, metric)
+		case []interface{}:
+			for _, v := range t {
+				obj, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("CBOR array holds a %s, not a map",
+						typeName(v))
+				}
+				metric, err := p.parseObject(obj)
+				if err != nil {
+					return nil, err
+				}
+				metrics = append(metrics, metric)
+			}
+		default:
+			return nil, fmt.Errorf("CBOR data is a %s, not a map or an array",
+				typeName(v))
+		}
+	}
+	return metrics, nil
+}
+
+func (p *CBORParser) parseObject(obj map[string]interface{}) (
+	telegraf.Metric,
+	error,
+) {
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	for _, tag := range p.TagKeys {
+		switch v := obj[tag].(type) {
+		case string:
+			tags[tag] = v
+		case int64, uint64, float64, bool:
+			tags[tag] = fmt.Sprint(v)
+		}
+		delete(obj, tag)
+	}
+
+	name := p.MetricName
+	if p.NameKey != "" {
+		if v, ok := obj[p.NameKey].(string); ok && v != "" {
+			name = v
+		}
+		delete(obj, p.NameKey)
+	}
+
+	t := time.Now().UTC()
+	if p.TimeKey != "" {
+		var err error
+		if t, err = p.timestamp(obj[p.TimeKey]); err != nil {
+			return nil, err
+		}
+		delete(obj, p.TimeKey)
+	}
+
+	fields := make(map[string]interface{})
+	flatten(fields, "", obj)
+
+	return telegraf.NewMetric(name, tags, fields, t)
+}
+
+func (p *CBORParser) ParseLine(line string) (telegraf.Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) < 1 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"cbor", line)
+	}
+
+	return metrics[0], nil
+}
+
+func (p *CBORParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+// timestamp returns the time stored under the TimeKey.
+func (p *CBORParser) timestamp(v interface{}) (time.Time, error) {
+	unit, epoch := epochUnits[p.TimeFormat]
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), nil
+	case int64:
+		if epoch {
+			return time.Unix(0, t*int64(unit)).UTC(), nil
+		}
+	case uint64:
+		if epoch {
+			return time.Unix(0, int64(t)*int64(unit)).UTC(), nil
+		}
+	case float64:
+		if epoch {
+			return time.Unix(0, int64(t*float64(unit))).UTC(), nil
+		}
+	case string:
+		if !epoch {
+			parsed, err := time.Parse(p.TimeFormat, t)
+			if err != nil {
+				return time.Time{}, fmt.Errorf("cbor_time_key %s: %s",
+					p.TimeKey, err)
+			}
+			return parsed.UTC(), nil
+		}
+	case nil:
+		return time.Time{}, fmt.Errorf("cbor_time_key %s not found",
+			p.TimeKey)
+	}
+	return time.Time{}, fmt.Errorf("cbor_time_key %s is a %s, not a time in "+
+		"format %s", p.TimeKey, typeName(v), p.timeFormat())
+}
+
+func (p *CBORParser) timeFormat() string {
+	if p.TimeFormat == "" {
+		return "unix"
+	}
+	return p.TimeFormat
+}
+
+// lookup returns the value at a path, nil if there is none.
+func lookup(v interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// flatten adds the numbers of a value to fields, named by their path from
+// the value. Strings, booleans, byte strings, times and nulls are ignored,
+// as they are by the JSON parser.
+func flatten(fields map[string]interface{}, name string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			flatten(fields, joinName(name, k), v)
+		}
+	case []interface{}:
+		for i, v := range t {
+			flatten(fields, joinName(name, strconv.Itoa(i)), v)
+		}
+	case int64, uint64, float64:
+		fields[name] = t
+	}
+}
+
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+// typeName returns the CBOR type name of a decoded value.
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case map[string]interface{}:
+		return "map"
+	case []interface{}:
+		return "array"
+	case int64, uint64:
+		return "integer"
+	case float64:
+		return "float"
+	case string:
+		return "text string"
+	case []byte:
+		return "byte string"
+	case bool:
+		return "boolean"
+	case time.Time:
+		return "date/time"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,315 @@
+package cbor
+
+import (
+	"encoding/hex"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// encoder encodes CBOR data items.
+type encoder []byte
+
+func (e encoder) head(major byte, n uint64) encoder {
+	switch {
+	case n < 24:
+		return append(e, major<<5|byte(n))
+	case n <= math.MaxUint8:
+		return append(e, major<<5|24, byte(n))
+	case n <= math.MaxUint16:
+		return append(e, major<<5|25, byte(n>>8), byte(n))
+	case n <= math.MaxUint32:
+		return append(e, major<<5|26, byte(n>>24), byte(n>>16), byte(n>>8),
+			byte(n))
+	}
+	e = append(e, major<<5|27)
+	for i := uint(56); ; i -= 8 {
+		e = append(e, byte(n>>i))
+		if i == 0 {
+			return e
+		}
+	}
+}
+
+func (e encoder) text(s string) encoder {
+	return append(e.head(majorText, uint64(len(s))), s...)
+}
+
+func (e encoder) int(n int64) encoder {
+	if n < 0 {
+		return e.head(majorNegint, uint64(-1-n))
+	}
+	return e.head(majorUint, uint64(n))
+}
+
+func (e encoder) float(f float64) encoder {
+	e = append(e, majorSimple<<5|27)
+	bits := math.Float64bits(f)
+	for i := uint(56); ; i -= 8 {
+		e = append(e, byte(bits>>i))
+		if i == 0 {
+			return e
+		}
+	}
+}
+
+func (e encoder) mapHead(n int) encoder {
+	return e.head(majorMap, uint64(n))
+}
+
+func (e encoder) arrayHead(n int) encoder {
+	return e.head(majorArray, uint64(n))
+}
+
+func (e encoder) tag(n uint64) encoder {
+	return e.head(majorTag, n)
+}
+
+// Examples from RFC 7049, appendix A.
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		data  string
+		value interface{}
+	}{
+		{"00", int64(0)},
+		{"17", int64(23)},
+		{"1818", int64(24)},
+		{"1903e8", int64(1000)},
+		{"1b000000e8d4a51000", int64(1000000000000)},
+		{"1bffffffffffffffff", uint64(math.MaxUint64)},
+		{"20", int64(-1)},
+		{"3903e7", int64(-1000)},
+		{"f90000", 0.0},
+		{"f93c00", 1.0},
+		{"f9c400", -4.0},
+		{"f97bff", 65504.0},
+		{"f90001", 5.960464477539063e-8},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f5", true},
+		{"f6", nil},
+		{"f7", nil},
+		{"40", []byte{}},
+		{"4401020304", []byte{1, 2, 3, 4}},
+		{"6161", "a"},
+		{"62c3bc", "ü"},
+		{"80", []interface{}{}},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"a201020304", map[string]interface{}{"1": int64(2), "3": int64(4)}},
+		{"a26161016162820203", map[string]interface{}{
+			"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)},
+		}},
+		{"c074323031332d30332d32315432303a30343a30305a",
+			time.Date(2013, 3, 21, 20, 4, 0, 0, time.UTC)},
+		{"c11a514b67b0", time.Unix(1363896240, 0).UTC()},
+		{"c1fb41d452d9ec200000", time.Unix(1363896240, 500000000).UTC()},
+		{"d74401020304", []byte{1, 2, 3, 4}},
+		{"5f42010243030405ff", []byte{1, 2, 3, 4, 5}},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"9fff", []interface{}{}},
+		{"9f018202039f0405ffff", []interface{}{
+			int64(1),
+			[]interface{}{int64(2), int64(3)},
+			[]interface{}{int64(4), int64(5)},
+		}},
+		{"bf61610161629f0203ffff", map[string]interface{}{
+			"a": int64(1),
+			"b": []interface{}{int64(2), int64(3)},
+		}},
+	}
+	for _, test := range tests {
+		d := &decoder{buf: mustHex(test.data)}
+		v, err := d.decode(0)
+		require.NoError(t, err, test.data)
+		assert.Equal(t, test.value, v, test.data)
+		assert.Empty(t, d.buf, test.data)
+	}
+
+	v, err := (&decoder{buf: mustHex("f97e00")}).decode(0)
+	require.NoError(t, err)
+	assert.True(t, math.IsNaN(v.(float64)))
+	v, err = (&decoder{buf: mustHex("f9fc00")}).decode(0)
+	require.NoError(t, err)
+	assert.Equal(t, math.Inf(-1), v)
+}
+
+func TestDecodeInvalid(t *testing.T) {
+	for _, data := range []string{
+		"",
+		"19",                 // truncated argument
+		"1c",                 // reserved additional information
+		"64616263",           // truncated text string
+		"9b00000000ffffffff", // array longer than the data
+		"a16161",             // map key without a value
+		"bf6161ff",           // indefinite map key without a value
+		"ff",                 // break outside of an indefinite length
+		"5f6161ff",           // text chunk in a byte string
+		"c001",               // date/time on a non-string
+		"c06161",             // invalid date/time
+		"3bffffffffffffffff", // negative integer overflow
+	} {
+		_, err := (&decoder{buf: mustHex(data)}).decode(0)
+		assert.Error(t, err, data)
+	}
+
+	deep := make([]byte, maxDepth+2)
+	for i := range deep {
+		deep[i] = 0x81
+	}
+	_, err := (&decoder{buf: append(deep, 0x00)}).decode(0)
+	assert.Error(t, err)
+}
+
+func testReading(device string, temperature float64) encoder {
+	return encoder(nil).mapHead(5).
+		text("device").text(device).
+		text("temperature").float(temperature).
+		text("count").int(42).
+		text("offset").int(-3).
+		text("location").mapHead(3).
+		text("site").text("lab").
+		text("floor").int(2).
+		text("samples").arrayHead(2).float(1.5).int(7)
+}
+
+func TestParse(t *testing.T) {
+	p, err := NewCBORParser("sensor", []string{"device"}, "", "", "", "",
+		map[string]string{"source": "mqtt"})
+	require.NoError(t, err)
+
+	metrics, err := p.Parse(testReading("sensor-1", 21.5))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	m := metrics[0]
+	assert.Equal(t, "sensor", m.Name())
+	assert.Equal(t, map[string]string{
+		"device": "sensor-1",
+		"source": "mqtt",
+	}, m.Tags())
+	assert.Equal(t, map[string]interface{}{
+		"temperature":        21.5,
+		"count":              int64(42),
+		"offset":             int64(-3),
+		"location_floor":     int64(2),
+		"location_samples_0": 1.5,
+		"location_samples_1": int64(7),
+	}, m.Fields())
+}
+
+func TestParseSequence(t *testing.T) {
+	p, err := NewCBORParser("sensor", []string{"device"}, "", "", "", "", nil)
+	require.NoError(t, err)
+
+	data := append(testReading("sensor-1", 21.5), testReading("sensor-2", 22)...)
+	metrics, err := p.Parse(data)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "sensor-1", metrics[0].Tags()["device"])
+	assert.Equal(t, "sensor-2", metrics[1].Tags()["device"])
+	assert.Equal(t, 22.0, metrics[1].Fields()["temperature"])
+}
+
+func TestParseQuery(t *testing.T) {
+	data := encoder(nil).mapHead(2).
+		text("gateway").text("gw-1").
+		text("data").mapHead(1).
+		text("readings").arrayHead(2).
+		mapHead(3).
+		text("type").text("temperature").
+		text("time").int(1465839830123).
+		text("value").float(21.5).
+		mapHead(3).
+		text("type").text("humidity").
+		text("time").int(1465839831123).
+		text("value").int(40)
+
+	p, err := NewCBORParser("reading", nil, "data.readings", "type", "time",
+		"unix_ms", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse(data)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "temperature", metrics[0].Name())
+	assert.Equal(t, map[string]interface{}{"value": 21.5}, metrics[0].Fields())
+	assert.Equal(t, time.Unix(1465839830, 123000000).UTC(), metrics[0].Time())
+	assert.Equal(t, "humidity", metrics[1].Name())
+	assert.Equal(t, map[string]interface{}{"value": int64(40)},
+		metrics[1].Fields())
+
+	p.Query = "data.readings.1"
+	metrics, err = p.Parse(data)
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "humidity", metrics[0].Name())
+
+	p.Query = "data.missing"
+	_, err = p.Parse(data)
+	assert.Error(t, err)
+}
+
+func TestParseTime(t *testing.T) {
+	reading := func(key encoder) []byte {
+		return append(encoder(nil).mapHead(2).
+			text("value").int(1).
+			text("time"), key...)
+	}
+	expected := time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC)
+
+	tests := []struct {
+		format string
+		time   encoder
+	}{
+		{"", encoder(nil).int(1465839830)},
+		{"unix", encoder(nil).float(1465839830)},
+		{"unix_us", encoder(nil).int(1465839830000000)},
+		{"unix_ns", encoder(nil).int(1465839830000000000)},
+		{"", encoder(nil).tag(tagEpoch).int(1465839830)},
+		{"", encoder(nil).tag(tagDateTime).text("2016-06-13T19:43:50+02:00")},
+		{"2006-01-02 15:04:05", encoder(nil).text("2016-06-13 17:43:50")},
+	}
+	for _, test := range tests {
+		p, err := NewCBORParser("sensor", nil, "", "", "time", test.format, nil)
+		require.NoError(t, err)
+		m, err := p.ParseLine(string(reading(test.time)))
+		require.NoError(t, err, test.format)
+		assert.Equal(t, expected, m.Time(), test.format)
+		assert.Equal(t, map[string]interface{}{"value": int64(1)}, m.Fields())
+	}
+
+	p, err := NewCBORParser("sensor", nil, "", "", "time", "unix", nil)
+	require.NoError(t, err)
+	_, err = p.Parse(reading(encoder(nil).text("yesterday")))
+	assert.Error(t, err)
+	_, err = p.Parse(encoder(nil).mapHead(1).text("value").int(1))
+	assert.Error(t, err)
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewCBORParser("sensor", nil, "", "", "", "", nil)
+	require.NoError(t, err)
+
+	_, err = p.Parse(testReading("sensor-1", 21.5)[:10])
+	assert.Error(t, err)
+	_, err = p.Parse(encoder(nil).int(1))
+	assert.Error(t, err)
+	_, err = p.Parse(encoder(nil).arrayHead(1).int(1))
+	assert.Error(t, err)
+
+	_, err = NewCBORParser("sensor", nil, "", "", "time", "unix_s", nil)
+	assert.Error(t, err)
+}
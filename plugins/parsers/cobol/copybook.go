@@ -0,0 +1,475 @@
+package cobol
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// Item data kinds.
+const (
+	kindString = iota
+	// kindDisplay is a zoned decimal, one digit per byte
+	kindDisplay
+	// kindPacked is a packed decimal, COMP-3, one digit per nibble
+	kindPacked
+	// kindBinary is a big endian integer, COMP
+	kindBinary
+	// kindFloat32 and kindFloat64 are IBM hexadecimal floats, COMP-1 and
+	// COMP-2
+	kindFloat32
+	kindFloat64
+)
+
+// item is an elementary item in a record. Each item is a metric field.
+type item struct {
+	// name is the item name in lower case with underscores. It ends with
+	// the index for each OCCURS of the item or its groups
+	name   string
+	offset int
+	size   int
+	kind   int
+	// digits is the number of digits in numbers. scale is how many of them
+	// are after the implied decimal point
+	digits int
+	scale  int
+	signed bool
+}
+
+// entry is a data description entry in a copybook.
+type entry struct {
+	level     int
+	name      string
+	pic       string
+	usage     string
+	occurs    int
+	redefines bool
+	children  []*entry
+}
+
+// LoadCopybook reads a copybook, as shipped with the programs that write the
+// records, such as SMF30.cpy.
+func LoadCopybook(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// parseCopybook parses the items of a copybook record and returns them with
+// the record size. If record is empty, the first record is used.
+//
+// The copybook is either fixed format or free format. Fixed format has
+// sequence numbers in columns 1 to 6, the indicator in column 7 and the code
+// in columns 8 to 72. Free format comments start with "*>".
+func parseCopybook(copybook string, record string) ([]item, int, error) {
+	statements, err := split(copybook)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var records []*entry
+	// stack is the group path to the current entry
+	var stack []*entry
+	for _, tokens := range statements {
+		e, err := parseEntry(tokens)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %s", strings.Join(tokens, " "),
+				err)
+		}
+		if e == nil {
+			continue
+		}
+		if e.level == 1 || e.level == 77 {
+			records = append(records, e)
+			stack = []*entry{e}
+			continue
+		}
+		for len(stack) != 0 && stack[len(stack)-1].level >= e.level {
+			stack = stack[:len(stack)-1]
+		}
+		if len(stack) == 0 {
+			return nil, 0, fmt.Errorf("%s: level %02d outside of a record",
+				strings.Join(tokens, " "), e.level)
+		}
+		parent := stack[len(stack)-1]
+		if parent.pic != "" {
+			return nil, 0, fmt.Errorf("%s: subordinate to elementary item %s",
+				strings.Join(tokens, " "), parent.name)
+		}
+		parent.children = append(parent.children, e)
+		stack = append(stack, e)
+	}
+
+	var r *entry
+	for _, e := range records {
+		if record == "" || strings.EqualFold(e.name, record) {
+			r = e
+			break
+		}
+	}
+	if r == nil {
+		if record == "" {
+			return nil, 0, fmt.Errorf("no record")
+		}
+		return nil, 0, fmt.Errorf("no record %s", record)
+	}
+
+	var items []item
+	size, err := layout(r, "", "", 0, &items)
+	if err != nil {
+		return nil, 0, err
+	}
+	return items, size, nil
+}
+
+// split returns the tokens of each copybook statement. Statements end with
+// periods.
+func split(copybook string) ([][]string, error) {
+	var code []string
+	for _, line := range strings.Split(copybook, "\n") {
+		line = strings.TrimRight(line, "\r")
+		if fixed(line) {
+			if line[6] != ' ' && line[6] != '-' {
+				// a comment
+				continue
+			}
+			if len(line) > 72 {
+				line = line[:72]
+			}
+			line = line[7:]
+		}
+		if i := strings.Index(line, "*>"); i >= 0 {
+			line = line[:i]
+		}
+		code = append(code, line)
+	}
+
+	var statements [][]string
+	var tokens []string
+	for _, token := range tokenize(strings.Join(code, " ")) {
+		end := strings.HasSuffix(token, ".")
+		token = strings.TrimSuffix(token, ".")
+		if token != "" {
+			tokens = append(tokens, token)
+		}
+		if end && len(tokens) != 0 {
+			statements = append(statements, tokens)
+			tokens = nil
+		}
+	}
+	if len(tokens) != 0 {
+		return nil, fmt.Errorf("%s: no period", strings.Join(tokens, " "))
+	}
+	return statements, nil
+}
+
+// fixed returns true if a line is in fixed format. Such lines have sequence
+// numbers or spaces in columns 1 to 6, and an indicator in column 7.
+func fixed(line string) bool {
+	if len(line) < 7 {
+		return false
+	}
+	for _, c := range line[:6] {
+		if c != ' ' && (c < '0' || c > '9') {
+			return false
+		}
+	}
+	return strings.IndexByte(" *-/", line[6]) >= 0
+}
+
+// tokenize splits code on spaces, outside of quotes.
+func tokenize(code string) []string {
+	var tokens []string
+	var token []byte
+	var quote byte
+	for i := 0; i < len(code); i++ {
+		c := code[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ' ' || c == '\t' || c == ',' || c == ';':
+			if len(token) != 0 {
+				tokens = append(tokens, string(token))
+				token = nil
+			}
+			continue
+		}
+		token = append(token, c)
+	}
+	if len(token) != 0 {
+		tokens = append(tokens, string(token))
+	}
+	return tokens
+}
+
+// parseEntry parses the tokens of a data description entry. It returns nil
+// for condition name and renames entries.
+func parseEntry(tokens []string) (*entry, error) {
+	level, err := strconv.Atoi(tokens[0])
+	if err != nil || level < 1 || level > 49 && level != 66 &&
+		level != 77 && level != 88 {
+		return nil, fmt.Errorf("invalid level %s", tokens[0])
+	}
+	if level == 66 || level == 88 {
+		return nil, nil
+	}
+	e := &entry{level: level, name: "FILLER"}
+	tokens = tokens[1:]
+	if len(tokens) != 0 && !keyword(tokens[0]) {
+		e.name = strings.ToUpper(tokens[0])
+		tokens = tokens[1:]
+	}
+
+	for len(tokens) != 0 {
+		token := strings.ToUpper(tokens[0])
+		tokens = tokens[1:]
+		// next returns the next token, skipping an optional word
+		next := func(optional ...string) (string, error) {
+			for len(tokens) != 0 && len(optional) != 0 &&
+				strings.EqualFold(tokens[0], optional[0]) {
+				tokens = tokens[1:]
+			}
+			if len(tokens) == 0 {
+				return "", fmt.Errorf("%s without an operand", token)
+			}
+			t := tokens[0]
+			tokens = tokens[1:]
+			return t, nil
+		}
+
+		switch token {
+		case "PIC", "PICTURE":
+			if e.pic, err = next("IS"); err != nil {
+				return nil, err
+			}
+			e.pic = strings.ToUpper(e.pic)
+		case "USAGE":
+			usage, err := next("IS")
+			if err != nil {
+				return nil, err
+			}
+			if e.usage = normalizeUsage(usage); e.usage == "" {
+				return nil, fmt.Errorf("unsupported USAGE %s", usage)
+			}
+		case "OCCURS":
+			n, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if e.occurs, err = strconv.Atoi(n); err != nil || e.occurs < 1 {
+				return nil, fmt.Errorf("invalid OCCURS %s", n)
+			}
+			if len(tokens) != 0 && strings.EqualFold(tokens[0], "TO") {
+				return nil, fmt.Errorf("unsupported OCCURS DEPENDING ON")
+			}
+			if len(tokens) != 0 && strings.EqualFold(tokens[0], "TIMES") {
+				tokens = tokens[1:]
+			}
+		case "REDEFINES":
+			if _, err := next(); err != nil {
+				return nil, err
+			}
+			e.redefines = true
+		case "VALUE", "VALUES":
+			// the rest is the value literal
+			tokens = nil
+		case "JUST", "JUSTIFIED", "RIGHT", "BLANK", "WHEN", "ZERO", "ZEROS",
+			"ZEROES", "GLOBAL", "EXTERNAL":
+		default:
+			usage := normalizeUsage(token)
+			if usage == "" {
+				return nil, fmt.Errorf("unsupported clause %s", token)
+			}
+			e.usage = usage
+		}
+	}
+	return e, nil
+}
+
+// keyword returns true if a token is an entry clause keyword, not a name.
+func keyword(token string) bool {
+	switch strings.ToUpper(token) {
+	case "PIC", "PICTURE", "USAGE", "OCCURS", "REDEFINES", "VALUE", "VALUES":
+		return true
+	}
+	return normalizeUsage(token) != ""
+}
+
+// normalizeUsage returns the usage for a usage word: DISPLAY, COMP, COMP-1,
+// COMP-2 or COMP-3, or none if it is not supported.
+func normalizeUsage(usage string) string {
+	switch strings.ToUpper(usage) {
+	case "DISPLAY":
+		return "DISPLAY"
+	case "COMP", "COMPUTATIONAL", "COMP-4", "COMPUTATIONAL-4", "COMP-5",
+		"COMPUTATIONAL-5", "BINARY":
+		return "COMP"
+	case "COMP-1", "COMPUTATIONAL-1":
+		return "COMP-1"
+	case "COMP-2", "COMPUTATIONAL-2":
+		return "COMP-2"
+	case "COMP-3", "COMPUTATIONAL-3", "PACKED-DECIMAL":
+		return "COMP-3"
+	}
+	return ""
+}
+
+// layout appends the items of an entry at an offset to items, and returns
+// the entry size. usage is the group usage, and suffix holds the group
+// indexes.
+func layout(
+	e *entry,
+	usage string,
+	suffix string,
+	offset int,
+	items *[]item,
+) (int, error) {
+	if e.usage != "" {
+		usage = e.usage
+	}
+	occurs := e.occurs
+	if occurs == 0 {
+		occurs = 1
+	}
+
+	if e.redefines {
+		// items sharing the storage of another item are skipped
+		items = &[]item{}
+	}
+
+	size := 0
+	for i := 0; i < occurs; i++ {
+		s := suffix
+		if e.occurs != 0 {
+			s += "_" + strconv.Itoa(i)
+		}
+
+		if len(e.children) != 0 || e.pic == "" &&
+			usage != "COMP-1" && usage != "COMP-2" {
+			// a group, made of its children's items
+			start := offset + i*size
+			n := 0
+			for _, child := range e.children {
+				m, err := layout(child, usage, s, start+n, items)
+				if err != nil {
+					return 0, err
+				}
+				if !child.redefines {
+					n += m
+				}
+			}
+			if n == 0 {
+				return 0, fmt.Errorf("group %s has no items", e.name)
+			}
+			size = n
+			continue
+		}
+
+		it, err := parsePicture(e.pic, usage)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %s", e.name, err)
+		}
+		size = it.size
+		if e.name == "FILLER" {
+			continue
+		}
+		it.name = strings.Replace(strings.ToLower(e.name), "-", "_", -1) + s
+		it.offset = offset + i*size
+		*items = append(*items, it)
+	}
+	return size * occurs, nil
+}
+
+// parsePicture returns the item for a PICTURE string and a usage.
+func parsePicture(pic string, usage string) (item, error) {
+	switch usage {
+	case "COMP-1":
+		return item{kind: kindFloat32, size: 4}, nil
+	case "COMP-2":
+		return item{kind: kindFloat64, size: 8}, nil
+	}
+
+	// the picture symbols, with repetitions expanded
+	var symbols []byte
+	for i := 0; i < len(pic); i++ {
+		c := pic[i]
+		if c != '(' {
+			symbols = append(symbols, c)
+			continue
+		}
+		j := strings.IndexByte(pic[i:], ')')
+		if j < 0 || len(symbols) == 0 {
+			return item{}, fmt.Errorf("invalid PICTURE %s", pic)
+		}
+		n, err := strconv.Atoi(pic[i+1 : i+j])
+		if err != nil || n < 1 {
+			return item{}, fmt.Errorf("invalid PICTURE %s", pic)
+		}
+		for k := 1; k < n; k++ {
+			symbols = append(symbols, symbols[len(symbols)-1])
+		}
+		i += j
+	}
+
+	it := item{kind: kindDisplay}
+	decimal := false
+	for _, c := range symbols {
+		switch c {
+		case '9':
+			it.digits++
+			if decimal {
+				it.scale++
+			}
+		case 'S':
+			it.signed = true
+		case 'V':
+			decimal = true
+		case 'P':
+			return item{}, fmt.Errorf("unsupported PICTURE %s with scaling "+
+				"positions", pic)
+		default:
+			// alphanumeric or numeric edited, one character per symbol
+			it.kind = kindString
+		}
+	}
+	if it.kind == kindString {
+		if usage != "" && usage != "DISPLAY" {
+			return item{}, fmt.Errorf("PICTURE %s with USAGE %s", pic, usage)
+		}
+		it.size = len(symbols)
+		it.digits, it.scale, it.signed = 0, 0, false
+		return it, nil
+	}
+	if it.digits == 0 || it.digits > 31 {
+		return item{}, fmt.Errorf("invalid PICTURE %s", pic)
+	}
+
+	switch usage {
+	case "", "DISPLAY":
+		it.size = it.digits
+	case "COMP-3":
+		it.kind = kindPacked
+		it.size = it.digits/2 + 1
+	case "COMP":
+		it.kind = kindBinary
+		switch {
+		case it.digits <= 4:
+			it.size = 2
+		case it.digits <= 9:
+			it.size = 4
+		case it.digits <= 18:
+			it.size = 8
+		default:
+			return item{}, fmt.Errorf("PICTURE %s has more than 18 digits "+
+				"for USAGE COMP", pic)
+		}
+	}
+	return it, nil
+}
@@ -0,0 +1,447 @@
+package cobol
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Record character encodings.
+const (
+	EncodingEBCDIC = "ebcdic"
+	EncodingASCII  = "ascii"
+)
+
+// Record formats.
+const (
+	// FormatFixed is records of the copybook record size, one after
+	// another.
+	FormatFixed = "fixed"
+	// FormatRDW is variable size records, each prefixed by a record
+	// descriptor word. The word is the length, a 2 bytes big endian integer
+	// that counts the 4 word bytes, followed by 2 zero bytes.
+	FormatRDW = "rdw"
+)
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+// COBOLParser parses records described by a COBOL copybook record into one
+// metric per record. Each elementary item is a field, except the tags and
+// the time.
+//
+// Zoned decimals (USAGE DISPLAY), packed decimals (COMP-3) and binary
+// integers (COMP, COMP-4, COMP-5, BINARY) are int. They are float if they
+// have decimals (V) or more than 18 digits. COMP-1 and COMP-2 items are IBM
+// hexadecimal floats. Other items are strings with trailing spaces trimmed.
+// Items holding only spaces or low values mean no value, except binary ones.
+//
+// Field names are the item names in lower case, with underscores for
+// hyphens. Items that OCCUR get one field per occurrence, named with the
+// index, such as "amount_0". Items that REDEFINE others and FILLER are
+// skipped.
+type COBOLParser struct {
+	MetricName string
+	// TagKeys are the fields that are tags.
+	TagKeys []string
+	// Encoding is the record character encoding: EncodingEBCDIC, code page
+	// 037, or EncodingASCII.
+	Encoding string
+	// Format is the record format: FormatFixed or FormatRDW.
+	Format string
+	// TimeKey is the field holding the metric time. It is an epoch in
+	// TimeFormat, or a string in its layout. If empty, the parse time is
+	// used.
+	TimeKey string
+	// TimeFormat is the format of TimeKey: unix (if empty), unix_ms,
+	// unix_us, unix_ns, or a Go time layout such as 20060102150405.
+	TimeFormat  string
+	DefaultTags map[string]string
+
+	items []item
+	// size is the record size
+	size int
+}
+
+// NewCOBOLParser returns a parser for the given copybook record. If record
+// is empty, the first 01 level entry is used.
+func NewCOBOLParser(
+	metricName string,
+	tagKeys []string,
+	copybook string,
+	record string,
+	encoding string,
+	format string,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (*COBOLParser, error) {
+	switch encoding {
+	case "":
+		encoding = EncodingEBCDIC
+	case EncodingEBCDIC, EncodingASCII:
+	default:
+		return nil, fmt.Errorf("invalid cobol_encoding %q, must be ebcdic or "+
+			"ascii", encoding)
+	}
+	switch format {
+	case "":
+		format = FormatFixed
+	case FormatFixed, FormatRDW:
+	default:
+		return nil, fmt.Errorf("invalid cobol_record_format %q, must be fixed "+
+			"or rdw", format)
+	}
+	if _, ok := epochUnits[timeFormat]; !ok &&
+		strings.HasPrefix(timeFormat, "unix") {
+		return nil, fmt.Errorf("invalid cobol_time_format %q, must be unix, "+
+			"unix_ms, unix_us, unix_ns or a time layout", timeFormat)
+	}
+
+	items, size, err := parseCopybook(copybook, record)
+	if err != nil {
+		return nil, fmt.Errorf("invalid cobol_copybook, %s", err)
+	}
+	if timeKey != "" {
+		found := false
+		for _, it := range items {
+			found = found || it.name == timeKey
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid cobol_time_key %q, no such item in "+
+				"the record", timeKey)
+		}
+	}
+	return &COBOLParser{
+		MetricName:  metricName,
+		TagKeys:     tagKeys,
+		Encoding:    encoding,
+		Format:      format,
+		TimeKey:     timeKey,
+		TimeFormat:  timeFormat,
+		DefaultTags: defaultTags,
+		items:       items,
+		size:        size,
+	}, nil
+}
+
+func (p *COBOLParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	for len(buf) != 0 {
+		record := buf
+		if p.Format == FormatRDW {
+			if len(buf) < 4 {
+				return nil, fmt.Errorf("unable to parse out as COBOL, "+
+					"record descriptor word has %d bytes, not 4", len(buf))
+			}
+			n := int(binary.BigEndian.Uint16(buf))
+			if n < 4 || n > len(buf) {
+				return nil, fmt.Errorf("unable to parse out as COBOL, "+
+					"invalid record length %d with %d bytes left", n, len(buf))
+			}
+			record, buf = buf[4:n], buf[n:]
+		} else {
+			if len(buf)%p.size != 0 {
+				return nil, fmt.Errorf("unable to parse out as COBOL, %d "+
+					"bytes are not records of %d bytes", len(buf), p.size)
+			}
+			record, buf = buf[:p.size], buf[p.size:]
+		}
+
+		metric, err := p.metric(record)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse out as COBOL, %s", err)
+		}
+		if metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, nil
+}
+
+// metric returns the metric for a record, or nil if it has no fields.
+func (p *COBOLParser) metric(record []byte) (telegraf.Metric, error) {
+	if len(record) < p.size {
+		return nil, fmt.Errorf("record has %d bytes, not %d", len(record),
+			p.size)
+	}
+
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	t := time.Now().UTC()
+
+	for _, it := range p.items {
+		v, err := p.value(it, record[it.offset:it.offset+it.size])
+		if err != nil {
+			return nil, fmt.Errorf("item %s: %s", it.name, err)
+		}
+		if it.name == p.TimeKey {
+			if v == nil {
+				return nil, fmt.Errorf("cobol_time_key %s is no value",
+					it.name)
+			}
+			if t, err = p.time(it, v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if v == nil {
+			continue
+		}
+		if p.tag(it.name) {
+			tags[it.name] = fmt.Sprint(v)
+			continue
+		}
+		fields[it.name] = v
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return telegraf.NewMetric(p.MetricName, tags, fields, t)
+}
+
+func (p *COBOLParser) tag(name string) bool {
+	for _, key := range p.TagKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// time converts the value of the TimeKey into a time.
+func (p *COBOLParser) time(it item, v interface{}) (time.Time, error) {
+	unit, epoch := epochUnits[p.TimeFormat]
+	if !epoch {
+		s, ok := v.(string)
+		if !ok {
+			// the digits of the number, such as 20160613174350
+			s = fmt.Sprintf("%0*d", it.digits, v)
+		}
+		t, err := time.Parse(p.TimeFormat, s)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cobol_time_key %s: %s",
+				p.TimeKey, err)
+		}
+		return t.UTC(), nil
+	}
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(0, v*int64(unit)).UTC(), nil
+	case float64:
+		return time.Unix(0, int64(v*float64(unit))).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("cobol_time_key %s is %q, not an epoch",
+		p.TimeKey, v)
+}
+
+// value returns the value of an item's data. It returns nil if the data is
+// only spaces or low values. Binary items are the exception: for them low
+// values are zero.
+func (p *COBOLParser) value(it item, data []byte) (interface{}, error) {
+	switch it.kind {
+	case kindBinary, kindFloat32, kindFloat64:
+	default:
+		if p.blank(data) {
+			return nil, nil
+		}
+	}
+
+	switch it.kind {
+	case kindString:
+		s := p.decode(data)
+		s = strings.TrimRight(s, " \x00")
+		if s == "" {
+			return nil, nil
+		}
+		return s, nil
+	case kindFloat32:
+		return hexFloat(uint64(binary.BigEndian.Uint32(data)) << 32), nil
+	case kindFloat64:
+		return hexFloat(binary.BigEndian.Uint64(data)), nil
+	case kindBinary:
+		var n int64
+		switch len(data) {
+		case 2:
+			n = int64(binary.BigEndian.Uint16(data))
+			if it.signed {
+				n = int64(int16(n))
+			}
+		case 4:
+			n = int64(binary.BigEndian.Uint32(data))
+			if it.signed {
+				n = int64(int32(n))
+			}
+		default:
+			u := binary.BigEndian.Uint64(data)
+			if !it.signed && u > math.MaxInt64 {
+				return scale(float64(u), it.scale), nil
+			}
+			n = int64(u)
+		}
+		if it.scale != 0 {
+			return scale(float64(n), it.scale), nil
+		}
+		return n, nil
+	}
+
+	// the decimal digits and sign
+	var digits []byte
+	negative := false
+	if it.kind == kindPacked {
+		for i, b := range data {
+			digits = append(digits, b>>4)
+			if i != len(data)-1 {
+				digits = append(digits, b&0x0f)
+				continue
+			}
+			switch b & 0x0f {
+			case 0x0b, 0x0d:
+				negative = true
+			case 0x0a, 0x0c, 0x0e, 0x0f:
+			default:
+				return nil, fmt.Errorf("invalid packed decimal % x", data)
+			}
+		}
+	} else {
+		var err error
+		if digits, negative, err = p.zoned(data); err != nil {
+			return nil, err
+		}
+	}
+
+	var n int64
+	var f float64
+	for _, d := range digits {
+		if d > 9 {
+			return nil, fmt.Errorf("invalid decimal % x", data)
+		}
+		n = n*10 + int64(d)
+		f = f*10 + float64(d)
+	}
+	if negative {
+		n, f = -n, -f
+	}
+	if it.scale == 0 && len(digits) <= 18 {
+		return n, nil
+	}
+	if len(digits) <= 18 {
+		f = float64(n)
+	}
+	return scale(f, it.scale), nil
+}
+
+// zoned returns the digits and sign of a zoned decimal. The sign is
+// overpunched on the last digit.
+func (p *COBOLParser) zoned(data []byte) ([]byte, bool, error) {
+	digits := make([]byte, len(data))
+	negative := false
+	for i, b := range data {
+		last := i == len(data)-1
+		if p.Encoding == EncodingASCII {
+			switch {
+			case b >= '0' && b <= '9':
+				digits[i] = b - '0'
+			case last && b == '{':
+			case last && b >= 'A' && b <= 'I':
+				digits[i] = b - 'A' + 1
+			case last && b == '}':
+				negative = true
+			case last && b >= 'J' && b <= 'R':
+				digits[i] = b - 'J' + 1
+				negative = true
+			default:
+				return nil, false, fmt.Errorf("invalid zoned decimal %q",
+					data)
+			}
+			continue
+		}
+
+		zone := b >> 4
+		switch {
+		case zone == 0x0f:
+		case last && (zone == 0x0b || zone == 0x0d):
+			negative = true
+		case last && zone >= 0x0a:
+		default:
+			return nil, false, fmt.Errorf("invalid zoned decimal % x", data)
+		}
+		digits[i] = b & 0x0f
+	}
+	return digits, negative, nil
+}
+
+// blank returns true if data is spaces or low values only.
+func (p *COBOLParser) blank(data []byte) bool {
+	space := byte(0x40)
+	if p.Encoding == EncodingASCII {
+		space = ' '
+	}
+	spaces, lows := true, true
+	for _, b := range data {
+		spaces = spaces && b == space
+		lows = lows && b == 0
+	}
+	return spaces || lows
+}
+
+func (p *COBOLParser) decode(data []byte) string {
+	if p.Encoding == EncodingASCII {
+		return string(data)
+	}
+	s := make([]rune, len(data))
+	for i, b := range data {
+		s[i] = cp037[b]
+	}
+	return string(s)
+}
+
+// hexFloat returns the value of an IBM hexadecimal float. bits holds a
+// COMP-2, or a COMP-1 in the upper 32 bits. The format is a sign bit, a 7
+// bits base 16 exponent biased by 64, and a 56 bits fraction.
+func hexFloat(bits uint64) float64 {
+	fraction := float64(bits&(1<<56-1)) / (1 << 56)
+	v := math.Ldexp(fraction, 4*(int(bits>>56&0x7f)-64))
+	if bits>>63 != 0 {
+		v = -v
+	}
+	return v
+}
+
+// scale applies a number of implied decimals to f.
+func scale(f float64, decimals int) float64 {
+	if decimals == 0 {
+		return f
+	}
+	// dividing by an exact power of ten rounds correctly
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', -1, 64)+
+		"e-"+strconv.Itoa(decimals), 64)
+	return v
+}
+
+// ParseLine parses a line holding one record.
+func (p *COBOLParser) ParseLine(line string) (telegraf.Metric, error) {
+	metric, err := p.metric([]byte(line))
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"cobol, %s", line, err)
+	}
+	return metric, nil
+}
+
+func (p *COBOLParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
@@ -0,0 +1,193 @@
+package cobol
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// copybook is an SMF-style record in a fixed format copybook.
+const copybook = `
+000100* JOB ACCOUNTING RECORD
+000200 01  JOB-RECORD.
+000300     05  SYSTEM-ID         PIC X(4).
+000400     05  JOB-NAME          PIC X(8).
+000500     05  END-TIME          PIC 9(14).
+000600     05  RETURN-CODE       PIC S9(4)      COMP.
+000700     05  CPU-SECONDS       PIC S9(7)V99   COMP-3.
+000800     05  STEPS             PIC 9(3).
+000900     05  STEP-CPU          OCCURS 2 TIMES.
+001000         10  STEP-NAME     PIC X(4).
+001100         10  STEP-MS       PIC S9(5)      COMP-3.
+001200     05  FILLER            PIC X(2).
+001300     05  BALANCE           PIC S9(5)V9.
+001400     05  BALANCE-X REDEFINES BALANCE
+001500                           PIC X(6).
+001600     05  RATIO             COMP-2.
+001700         88  IDLE          VALUE ZERO.
+001800 01  OTHER-RECORD.
+001900     05  OTHER-ID          PIC X(4).
+`
+
+// ebcdic encodes a string in code page 037.
+func ebcdic(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		for i, c := range cp037 {
+			if c == r {
+				b = append(b, byte(i))
+				break
+			}
+		}
+	}
+	return b
+}
+
+// record is a JOB-RECORD for the copybook.
+func record(system, job, end string, rc, cpu, balance []byte) []byte {
+	var b []byte
+	b = append(b, ebcdic(system)...)
+	b = append(b, ebcdic(job)...)
+	b = append(b, ebcdic(end)...)
+	b = append(b, rc...)
+	b = append(b, cpu...)
+	b = append(b, ebcdic("002")...)
+	b = append(b, ebcdic("STP1")...)
+	b = append(b, 0x01, 0x23, 0x4c)
+	b = append(b, ebcdic("    ")...)
+	b = append(b, 0x00, 0x00, 0x0c)
+	b = append(b, ebcdic("  ")...)
+	b = append(b, balance...)
+	// 0.5 as an IBM hexadecimal float
+	b = append(b, 0x40, 0x80, 0, 0, 0, 0, 0, 0)
+	return b
+}
+
+func TestParse(t *testing.T) {
+	p, err := NewCOBOLParser("smf", []string{"system_id"}, copybook, "", "",
+		"", "end_time", "20060102150405", nil)
+	require.NoError(t, err)
+
+	buf := append(
+		record("SYSA", "PAYROLL ", "20160613174350", []byte{0xff, 0xf8},
+			[]byte{0x00, 0x01, 0x23, 0x45, 0x6d},
+			append(ebcdic("00012"), 0xd5)),
+		record("SYSB", "BACKUP  ", "20160613174351", []byte{0x00, 0x00},
+			[]byte{0x00, 0x00, 0x00, 0x00, 0x0c}, ebcdic("      "))...)
+	metrics, err := p.Parse(buf)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "smf", metrics[0].Name())
+	assert.Equal(t, map[string]string{"system_id": "SYSA"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"job_name":    "PAYROLL",
+		"return_code": int64(-8),
+		"cpu_seconds": -1234.56,
+		"steps":       int64(2),
+		"step_name_0": "STP1",
+		"step_ms_0":   int64(1234),
+		"step_ms_1":   int64(0),
+		"balance":     -12.5,
+		"ratio":       0.5,
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC),
+		metrics[0].Time())
+
+	assert.Equal(t, map[string]interface{}{
+		"job_name":    "BACKUP",
+		"return_code": int64(0),
+		"cpu_seconds": 0.0,
+		"steps":       int64(2),
+		"step_name_0": "STP1",
+		"step_ms_0":   int64(1234),
+		"step_ms_1":   int64(0),
+		"ratio":       0.5,
+	}, metrics[1].Fields())
+}
+
+func TestParseRDW(t *testing.T) {
+	// a free format copybook with ASCII records
+	p, err := NewCOBOLParser("cobol", nil, `
+       *> counters
+       01 COUNTERS.
+          05 NAME    PIC X(3).
+          05 VALUE-A PIC S9(3).
+          05 VALUE-B PIC 9(2)V9 USAGE IS DISPLAY.
+`, "counters", EncodingASCII, FormatRDW, "", "", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse([]byte(
+		"\x00\x0d\x00\x00abc12}123" + "\x00\x0e\x00\x00xyz00A999x"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, map[string]interface{}{
+		"name":    "abc",
+		"value_a": int64(-120),
+		"value_b": 12.3,
+	}, metrics[0].Fields())
+	assert.Equal(t, map[string]interface{}{
+		"name":    "xyz",
+		"value_a": int64(1),
+		"value_b": 99.9,
+	}, metrics[1].Fields())
+
+	_, err = p.Parse([]byte("\x00\x0d\x00\x00abc12}12"))
+	assert.Error(t, err)
+	_, err = p.Parse([]byte("\x00\x0d\x00\x00abc1x}123"))
+	assert.Error(t, err)
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewCOBOLParser("cobol", nil, copybook, "", "", "", "", "", nil)
+	require.NoError(t, err)
+	valid := record("SYSA", "PAYROLL ", "20160613174350", []byte{0, 0},
+		[]byte{0x00, 0x01, 0x23, 0x45, 0x6c}, ebcdic("000125"))
+
+	_, err = p.Parse(valid[1:])
+	assert.Error(t, err)
+	// an invalid sign in the packed decimal
+	invalid := append([]byte(nil), valid...)
+	invalid[32] = 0x45
+	_, err = p.Parse(invalid)
+	assert.Error(t, err)
+	// an invalid zone in the zoned decimal
+	invalid = append([]byte(nil), valid...)
+	invalid[12] = 0xc1
+	_, err = p.Parse(invalid)
+	assert.Error(t, err)
+}
+
+func TestNewCOBOLParserInvalid(t *testing.T) {
+	for _, c := range []string{
+		"",
+		"01 R. 05 A PIC X(2)",
+		"01 R. 05 A PIC 9P.",
+		"01 R. 05 A PIC X(2) COMP-3.",
+		"01 R. 05 A PIC 9 OCCURS 1 TO 5 DEPENDING ON B.",
+		"01 R. 05 A PIC 9 SYNC.",
+		"05 A PIC 9.",
+		"01 R. 05 A PIC 9. 10 B PIC 9.",
+	} {
+		_, err := NewCOBOLParser("cobol", nil, c, "", "", "", "", "", nil)
+		assert.Error(t, err, c)
+	}
+	_, err := NewCOBOLParser("cobol", nil, copybook, "NO-RECORD", "", "", "",
+		"", nil)
+	assert.Error(t, err)
+	_, err = NewCOBOLParser("cobol", nil, copybook, "", "utf-8", "", "", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewCOBOLParser("cobol", nil, copybook, "", "", "vb", "", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewCOBOLParser("cobol", nil, copybook, "", "", "", "end", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewCOBOLParser("cobol", nil, copybook, "", "", "", "end_time",
+		"unix_s", nil)
+	assert.True(t, strings.Contains(err.Error(), "cobol_time_format"))
+}
@@ -0,0 +1,452 @@
+package csv
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Column value types.
+const (
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeString = "string"
+)
+
+// DefaultInferRows is the number of rows sampled to infer the column types.
+const DefaultInferRows = 100
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+// CSVParser parses CSV data into one metric per row. Each column is a field,
+// except the tags and the timestamp. The data may be compressed with gzip.
+//
+// Column types not set in ColumnTypes are inferred from the first InferRows
+// rows. A column is int, float or bool if all its values in those rows are,
+// and string otherwise.
+//
+// When data is parsed line by line, the parser keeps the header between
+// calls. No rows are sampled, so values not covered by ColumnTypes keep
+// their own type.
+type CSVParser struct {
+	MetricName string
+	// TagKeys are the columns that are tags.
+	TagKeys []string
+	// ColumnNames are the column names. If empty, the first row is the
+	// header.
+	ColumnNames []string
+	// ColumnTypes are column types: TypeInt, TypeFloat, TypeBool or
+	// TypeString.
+	ColumnTypes map[string]string
+	// InferRows is the number of rows sampled to infer the other column
+	// types.
+	InferRows int
+	// NullValues are the values that mean no value, by column. The "*"
+	// column applies to every column. Empty values always mean no value.
+	NullValues map[string]map[string]bool
+	// Delimiter is the value delimiter, "," if zero.
+	Delimiter rune
+	// TimestampColumn is the column holding the metric time. It is an epoch
+	// in TimestampFormat, or a string in its layout. If empty, the parse time
+	// is used.
+	TimestampColumn string
+	// TimestampFormat is the format of TimestampColumn: unix (if empty),
+	// unix_ms, unix_us, unix_ns, or a Go time layout such as
+	// 2006-01-02T15:04:05Z07:00.
+	TimestampFormat string
+	DefaultTags     map[string]string
+
+	mu sync.Mutex
+	// header is the header of the data parsed line by line
+	header []string
+}
+
+func NewCSVParser(
+	metricName string,
+	tagKeys []string,
+	columnNames []string,
+	columnTypes []string,
+	inferRows int,
+	nullValues []string,
+	delimiter string,
+	timestampColumn string,
+	timestampFormat string,
+	defaultTags map[string]string,
+) (*CSVParser, error) {
+	p := &CSVParser{
+		MetricName:      metricName,
+		TagKeys:         tagKeys,
+		ColumnNames:     columnNames,
+		ColumnTypes:     make(map[string]string),
+		InferRows:       inferRows,
+		NullValues:      make(map[string]map[string]bool),
+		Delimiter:       ',',
+		TimestampColumn: timestampColumn,
+		TimestampFormat: timestampFormat,
+		DefaultTags:     defaultTags,
+	}
+	if inferRows < 0 {
+		return nil, fmt.Errorf("invalid csv_infer_rows %d, must not be "+
+			"negative", inferRows)
+	}
+	if inferRows == 0 {
+		p.InferRows = DefaultInferRows
+	}
+	for _, entry := range columnTypes {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid csv_column_types entry %q, must "+
+				"be <column>:<type>", entry)
+		}
+		name, typ := entry[:i], entry[i+1:]
+		switch typ {
+		case TypeInt, TypeFloat, TypeBool, TypeString:
+		default:
+			return nil, fmt.Errorf("invalid type %q in csv_column_types "+
+				"entry %q, must be int, float, bool or string", typ, entry)
+		}
+		p.ColumnTypes[name] = typ
+	}
+	for _, entry := range nullValues {
+		i := strings.Index(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid csv_null_values entry %q, must "+
+				"be <column>:<value>, or *:<value> for every column", entry)
+		}
+		name, value := entry[:i], entry[i+1:]
+		if p.NullValues[name] == nil {
+			p.NullValues[name] = make(map[string]bool)
+		}
+		p.NullValues[name][value] = true
+	}
+	if delimiter != "" {
+		r, size := utf8.DecodeRuneInString(delimiter)
+		if size != len(delimiter) || r == '"' || r == '\r' || r == '\n' ||
+			r == utf8.RuneError {
+			return nil, fmt.Errorf("invalid csv_delimiter %q, must be a "+
+				"character", delimiter)
+		}
+		p.Delimiter = r
+	}
+	if _, ok := epochUnits[timestampFormat]; !ok &&
+		strings.HasPrefix(timestampFormat, "unix") {
+		return nil, fmt.Errorf("invalid csv_timestamp_format %q, must be "+
+			"unix, unix_ms, unix_us, unix_ns or a time layout",
+			timestampFormat)
+	}
+	return p, nil
+}
+
+func (p *CSVParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	err := p.ParseReader(bytes.NewReader(buf),
+		func(metric telegraf.Metric) error {
+			metrics = append(metrics, metric)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// ParseReader parses the data read from r, which may be gzip compressed. It
+// passes the metric for each row to fn as soon as the column types are
+// inferred. It stops at the first error fn returns.
+func (p *CSVParser) ParseReader(
+	r io.Reader,
+	fn func(telegraf.Metric) error,
+) error {
+	br := bufio.NewReader(r)
+	if magic, err := br.Peek(2); err == nil &&
+		magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return fmt.Errorf("unable to parse out as CSV, %s", err)
+		}
+		defer gz.Close()
+		r = gz
+	} else {
+		r = br
+	}
+
+	cr := p.reader(r)
+	header := p.ColumnNames
+	if len(header) == 0 {
+		row, err := cr.Read()
+		if err == io.EOF {
+			return fmt.Errorf("unable to parse out as CSV, no header")
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse out as CSV, %s", err)
+		}
+		header = trimBOM(row)
+	}
+
+	// rows are sampled until the column types are inferred
+	var sample [][]string
+	var types []string
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse out as CSV, %s", err)
+		}
+		if len(row) != len(header) {
+			return fmt.Errorf("unable to parse out as CSV, %d values for %d "+
+				"columns", len(row), len(header))
+		}
+		if types == nil {
+			sample = append(sample, row)
+			if len(sample) < p.InferRows {
+				continue
+			}
+			types = p.infer(header, sample)
+			if err := p.emit(header, types, sample, fn); err != nil {
+				return err
+			}
+			sample = nil
+			continue
+		}
+		if err := p.emit(header, types, [][]string{row}, fn); err != nil {
+			return err
+		}
+	}
+	if types == nil {
+		return p.emit(header, p.infer(header, sample), sample, fn)
+	}
+	return nil
+}
+
+func (p *CSVParser) reader(r io.Reader) *csv.Reader {
+	cr := csv.NewReader(r)
+	cr.Comma = p.Delimiter
+	cr.FieldsPerRecord = -1
+	return cr
+}
+
+// trimBOM trims the byte order mark from the header of CSV exported by
+// spreadsheets.
+func trimBOM(header []string) []string {
+	if len(header) != 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	return header
+}
+
+// emit passes the row metrics to fn.
+func (p *CSVParser) emit(
+	header []string,
+	types []string,
+	rows [][]string,
+	fn func(telegraf.Metric) error,
+) error {
+	for _, row := range rows {
+		metric, err := p.metric(header, types, row)
+		if err != nil {
+			return err
+		}
+		if metric == nil {
+			continue
+		}
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// infer returns the column types for a sample of rows. A type set in
+// ColumnTypes is used as is. Otherwise a column is int, float or bool if all
+// its values are, string if not, and has no type if they are all null.
+func (p *CSVParser) infer(header []string, sample [][]string) []string {
+	types := make([]string, len(header))
+	for i, name := range header {
+		if typ, ok := p.ColumnTypes[name]; ok {
+			types[i] = typ
+			continue
+		}
+		for _, row := range sample {
+			if p.null(name, row[i]) {
+				continue
+			}
+			typ := valueType(row[i])
+			switch {
+			case types[i] == "" || types[i] == typ:
+				types[i] = typ
+			case types[i] == TypeInt && typ == TypeFloat ||
+				types[i] == TypeFloat && typ == TypeInt:
+				types[i] = TypeFloat
+			default:
+				types[i] = TypeString
+			}
+		}
+	}
+	return types
+}
+
+// valueType returns the type of a single value.
+func valueType(v string) string {
+	if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return TypeInt
+	}
+	if _, err := strconv.ParseFloat(v, 64); err == nil {
+		return TypeFloat
+	}
+	if strings.EqualFold(v, "true") || strings.EqualFold(v, "false") {
+		return TypeBool
+	}
+	return TypeString
+}
+
+func (p *CSVParser) null(name, v string) bool {
+	return v == "" || p.NullValues[name][v] || p.NullValues["*"][v]
+}
+
+// metric returns the metric for a row, or nil if it has no fields.
+func (p *CSVParser) metric(
+	header []string,
+	types []string,
+	row []string,
+) (telegraf.Metric, error) {
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	t := time.Now().UTC()
+
+	for i, name := range header {
+		v := row[i]
+		if p.null(name, v) {
+			if name == p.TimestampColumn {
+				return nil, fmt.Errorf("csv_timestamp_column %s is null",
+					name)
+			}
+			continue
+		}
+		if name == p.TimestampColumn {
+			var err error
+			if t, err = p.timestamp(v); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.tag(name) {
+			tags[name] = v
+			continue
+		}
+		typ := types[i]
+		if typ == "" {
+			typ = valueType(v)
+		}
+		value, err := convert(typ, v)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse out as CSV, value %q in "+
+				"column %s is not a %s, set its csv_column_types or a "+
+				"larger csv_infer_rows", v, name, typ)
+		}
+		fields[name] = value
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return telegraf.NewMetric(p.MetricName, tags, fields, t)
+}
+
+func (p *CSVParser) tag(name string) bool {
+	for _, key := range p.TagKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+func convert(typ, v string) (interface{}, error) {
+	switch typ {
+	case TypeInt:
+		return strconv.ParseInt(v, 10, 64)
+	case TypeFloat:
+		return strconv.ParseFloat(v, 64)
+	case TypeBool:
+		return strconv.ParseBool(strings.ToLower(v))
+	}
+	return v, nil
+}
+
+// timestamp converts the value of the TimestampColumn into a time.
+func (p *CSVParser) timestamp(v string) (time.Time, error) {
+	unit, epoch := epochUnits[p.TimestampFormat]
+	if !epoch {
+		t, err := time.Parse(p.TimestampFormat, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("csv_timestamp_column %s: %s",
+				p.TimestampColumn, err)
+		}
+		return t.UTC(), nil
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, i*int64(unit)).UTC(), nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("csv_timestamp_column %s is %q, not "+
+			"an epoch", p.TimestampColumn, v)
+	}
+	return time.Unix(0, int64(f*float64(unit))).UTC(), nil
+}
+
+// ParseLine parses one row of data that is parsed line by line. For the
+// header and blank lines it returns no metric and no error.
+func (p *CSVParser) ParseLine(line string) (telegraf.Metric, error) {
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	row, err := p.reader(strings.NewReader(line)).Read()
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"csv, %s", line, err)
+	}
+
+	p.mu.Lock()
+	header := p.ColumnNames
+	if len(header) == 0 {
+		if p.header == nil {
+			p.header = trimBOM(row)
+			p.mu.Unlock()
+			return nil, nil
+		}
+		header = p.header
+	}
+	p.mu.Unlock()
+
+	if len(row) != len(header) {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"csv, %d values for %d columns", line, len(row), len(header))
+	}
+	return p.metric(header, p.infer(header, nil), row)
+}
+
+func (p *CSVParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
@@ -0,0 +1,188 @@
+package csv
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+const export = "\ufefftime,host,usage,count,ok,note\n" +
+	"1465839830,a,90,1,true,NA\n" +
+	"1465839831,b,90.5,2,FALSE,\"high, load\"\n" +
+	"1465839832,c,,NA,true,x\n"
+
+func TestParse(t *testing.T) {
+	p, err := NewCSVParser("csv", []string{"host"}, nil, nil, 0,
+		[]string{"*:NA"}, "", "time", "", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse([]byte(export))
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+
+	assert.Equal(t, map[string]string{"host": "a"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"usage": 90.0,
+		"count": int64(1),
+		"ok":    true,
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Unix(1465839830, 0).UTC(), metrics[0].Time())
+
+	assert.Equal(t, map[string]interface{}{
+		"usage": 90.5,
+		"count": int64(2),
+		"ok":    false,
+		"note":  "high, load",
+	}, metrics[1].Fields())
+
+	assert.Equal(t, map[string]interface{}{
+		"ok":   true,
+		"note": "x",
+	}, metrics[2].Fields())
+}
+
+func TestParseInferRows(t *testing.T) {
+	// the usage of the second row is not sampled, and not an int
+	p, err := NewCSVParser("csv", nil, nil, nil, 1, nil, "", "", "", nil)
+	require.NoError(t, err)
+	_, err = p.Parse([]byte(export))
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "usage"))
+
+	p, err = NewCSVParser("csv", nil, nil,
+		[]string{"usage:float", "count:string"}, 1, nil, "", "", "", nil)
+	require.NoError(t, err)
+	metrics, err := p.Parse([]byte(export))
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+	assert.Equal(t, 90.0, metrics[0].Fields()["usage"])
+	assert.Equal(t, "NA", metrics[2].Fields()["count"])
+}
+
+func TestParseGzip(t *testing.T) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write([]byte("1;a;1.5\n2;b;2\n"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	p, err := NewCSVParser("csv", []string{"host"},
+		[]string{"time", "host", "value"}, nil, 0, nil, ";", "time",
+		"unix_ms", nil)
+	require.NoError(t, err)
+	metrics, err := p.Parse(buf.Bytes())
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, map[string]interface{}{"value": 2.0}, metrics[1].Fields())
+	assert.Equal(t, time.Unix(0, 2e6).UTC(), metrics[1].Time())
+}
+
+// reader is a CSV of many rows, generated as it is read.
+type reader struct {
+	n, count int
+	buf      []byte
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		switch {
+		case r.n == 0:
+			r.buf = []byte("value\n")
+		case r.n <= r.count:
+			r.buf = []byte(fmt.Sprintf("%d\n", r.n))
+		default:
+			return 0, io.EOF
+		}
+		r.n++
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Verify that the metrics of the rows are passed once the types are
+// inferred, without the data being read first.
+func TestParseReader(t *testing.T) {
+	p, err := NewCSVParser("csv", nil, nil, nil, 10, nil, "", "", "", nil)
+	require.NoError(t, err)
+
+	r := &reader{count: 100000}
+	n := int64(0)
+	stop := fmt.Errorf("stop")
+	err = p.ParseReader(r, func(m telegraf.Metric) error {
+		n++
+		assert.Equal(t, n, m.Fields()["value"])
+		// a few rows at most are read ahead
+		assert.True(t, r.n-int(n) < 1000)
+		if n == 50000 {
+			return stop
+		}
+		return nil
+	})
+	assert.Equal(t, stop, err)
+	assert.Equal(t, int64(50000), n)
+}
+
+func TestParseLine(t *testing.T) {
+	p, err := NewCSVParser("csv", nil, nil, []string{"count:float"}, 0,
+		[]string{"usage:-"}, "", "", "", nil)
+	require.NoError(t, err)
+
+	m, err := p.ParseLine("usage,count")
+	require.NoError(t, err)
+	assert.Nil(t, m)
+
+	m, err = p.ParseLine("90.5,2")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"usage": 90.5, "count": 2.0},
+		m.Fields())
+
+	m, err = p.ParseLine("-,3")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"count": 3.0}, m.Fields())
+
+	_, err = p.ParseLine("1,2,3")
+	assert.Error(t, err)
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewCSVParser("csv", nil, nil, nil, 0, nil, "", "", "", nil)
+	require.NoError(t, err)
+	for _, data := range []string{
+		"",
+		"a,b\n1\n",
+		"a\n\"1\n",
+		"\x1f\x8bnot gzip",
+	} {
+		_, err := p.Parse([]byte(data))
+		assert.Error(t, err, data)
+	}
+}
+
+func TestNewCSVParserInvalid(t *testing.T) {
+	_, err := NewCSVParser("csv", nil, nil, []string{"a"}, 0, nil, "", "", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewCSVParser("csv", nil, nil, []string{"a:date"}, 0, nil, "", "",
+		"", nil)
+	assert.Error(t, err)
+	_, err = NewCSVParser("csv", nil, nil, nil, -1, nil, "", "", "", nil)
+	assert.Error(t, err)
+	_, err = NewCSVParser("csv", nil, nil, nil, 0, []string{"NA"}, "", "", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewCSVParser("csv", nil, nil, nil, 0, nil, ";;", "", "", nil)
+	assert.Error(t, err)
+	_, err = NewCSVParser("csv", nil, nil, nil, 0, nil, "", "t", "unix_s", nil)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "csv_timestamp_format"))
+}
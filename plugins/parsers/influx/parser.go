@@ -3,16 +3,74 @@ package influx
 import (
 	"bytes"
 	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/influxdata/telegraf"
 
 	"github.com/influxdata/influxdb/models"
 )
 
+// Ways to handle malformed lines.
+const (
+	// ParseErrorsFail fails data with a malformed line. The metrics of the
+	// other lines are returned with the error.
+	ParseErrorsFail = "fail"
+	// ParseErrorsRecover skips malformed lines, returning an error metric
+	// for each instead of an error.
+	ParseErrorsRecover = "recover"
+)
+
+// DefaultErrorMeasurement is the measurement for malformed line error
+// metrics. DefaultErrorPayloadSize is the size their payload is truncated to.
+const (
+	DefaultErrorMeasurement = "influx_parse_error"
+	DefaultErrorPayloadSize = 256
+)
+
 // InfluxParser is an object for Parsing incoming metrics.
 type InfluxParser struct {
 	// DefaultTags will be added to every parsed metric
 	DefaultTags map[string]string
+
+	// ParseErrors sets how malformed lines are handled: ParseErrorsFail (if
+	// empty) or ParseErrorsRecover.
+	ParseErrors string
+	// ErrorMeasurement is the measurement for ParseErrorsRecover error
+	// metrics. Their fields are the "line" number in the data, the "reason"
+	// the line is malformed, and the line "payload" truncated to
+	// ErrorPayloadSize bytes.
+	ErrorMeasurement string
+	ErrorPayloadSize int
+}
+
+func NewInfluxParser(
+	parseErrors string,
+	errorMeasurement string,
+	errorPayloadSize int,
+) (*InfluxParser, error) {
+	switch parseErrors {
+	case "", ParseErrorsFail, ParseErrorsRecover:
+	default:
+		return nil, fmt.Errorf("invalid influx_parse_errors %q, must be fail "+
+			"or recover", parseErrors)
+	}
+	if errorMeasurement == "" {
+		errorMeasurement = DefaultErrorMeasurement
+	}
+	if errorPayloadSize < 0 {
+		return nil, fmt.Errorf("invalid influx_error_payload_size %d, must "+
+			"not be negative", errorPayloadSize)
+	}
+	if errorPayloadSize == 0 {
+		errorPayloadSize = DefaultErrorPayloadSize
+	}
+	return &InfluxParser{
+		ParseErrors:      parseErrors,
+		ErrorMeasurement: errorMeasurement,
+		ErrorPayloadSize: errorPayloadSize,
+	}, nil
 }
 
 // Parse returns a slice of Metrics from a text representation of a
@@ -21,24 +79,100 @@ type InfluxParser struct {
 // a non-nil error will be returned in addition to the metrics that parsed
 // successfully.
 func (p *InfluxParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	if p.ParseErrors == ParseErrorsRecover {
+		return p.recover(buf), nil
+	}
+
 	// parse even if the buffer begins with a newline
 	buf = bytes.TrimPrefix(buf, []byte("\n"))
 	points, err := models.ParsePoints(buf)
 	metrics := make([]telegraf.Metric, len(points))
 	for i, point := range points {
-		tags := point.Tags()
-		for k, v := range p.DefaultTags {
-			// Only set tags not in parsed metric
-			if _, ok := tags[k]; !ok {
-				tags[k] = v
+		metrics[i] = p.metric(point.Name(), point.Tags(), point.Fields(),
+			point.Time())
+	}
+	return metrics, err
+}
+
+func (p *InfluxParser) metric(
+	name string,
+	tags map[string]string,
+	fields map[string]interface{},
+	t time.Time,
+) telegraf.Metric {
+	for k, v := range p.DefaultTags {
+		// Only set tags not in parsed metric
+		if _, ok := tags[k]; !ok {
+			tags[k] = v
+		}
+	}
+	// Ignore error here because it's impossible that a model.Point
+	// wouldn't parse into client.Point properly
+	metric, _ := telegraf.NewMetric(name, tags, fields, t)
+	return metric
+}
+
+// recover parses the data one line at a time, so a malformed line does not
+// fail the others. An error metric is returned in its place. String fields
+// can not span lines.
+func (p *InfluxParser) recover(buf []byte) []telegraf.Metric {
+	metrics := make([]telegraf.Metric, 0)
+	for i, line := range bytes.Split(buf, []byte("\n")) {
+		points, err := models.ParsePoints(line)
+		if err != nil {
+			metrics = append(metrics, p.errorMetric(i+1, line, err))
+			continue
+		}
+		for _, point := range points {
+			metrics = append(metrics, p.metric(point.Name(), point.Tags(),
+				point.Fields(), point.Time()))
+		}
+	}
+	return metrics
+}
+
+// errorMetric returns the error metric for a malformed line.
+func (p *InfluxParser) errorMetric(
+	number int,
+	line []byte,
+	err error,
+) telegraf.Metric {
+	// the error reason, without the line
+	reason := strings.TrimPrefix(err.Error(), fmt.Sprintf(
+		"unable to parse '%s': ", bytes.TrimLeft(line, " \t")))
+
+	size := p.ErrorPayloadSize
+	if size == 0 {
+		size = DefaultErrorPayloadSize
+	}
+	payload := line
+	if len(payload) > size {
+		payload = payload[:size]
+		// not splitting a character
+		for i := 0; i < utf8.UTFMax && len(payload) != 0; i++ {
+			r, n := utf8.DecodeLastRune(payload)
+			if r != utf8.RuneError || n != 1 {
+				break
 			}
+			payload = payload[:len(payload)-1]
 		}
-		// Ignore error here because it's impossible that a model.Point
-		// wouldn't parse into client.Point properly
-		metrics[i], _ = telegraf.NewMetric(point.Name(), tags,
-			point.Fields(), point.Time())
 	}
-	return metrics, err
+
+	name := p.ErrorMeasurement
+	if name == "" {
+		name = DefaultErrorMeasurement
+	}
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	metric, _ := telegraf.NewMetric(name, tags,
+		map[string]interface{}{
+			"line":    int64(number),
+			"reason":  reason,
+			"payload": string(payload),
+		}, time.Now().UTC())
+	return metric
 }
 
 func (p *InfluxParser) ParseLine(line string) (telegraf.Metric, error) {
@@ -1,10 +1,14 @@
 package influx
 
 import (
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
 )
 
 var exptime = time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
@@ -192,3 +196,56 @@ func TestParseInvalidInflux(t *testing.T) {
 	_, err = parser.ParseLine(invalidInflux2)
 	assert.Error(t, err)
 }
+
+func TestParseRecover(t *testing.T) {
+	parser, err := NewInfluxParser(ParseErrorsRecover, "", 10)
+	require.NoError(t, err)
+	parser.SetDefaultTags(map[string]string{"source": "legacy"})
+
+	metrics, err := parser.Parse([]byte(influxMultiSomeInvalid))
+	require.NoError(t, err)
+	require.Len(t, metrics, 6)
+
+	var failed []telegraf.Metric
+	for _, metric := range metrics {
+		if metric.Name() == DefaultErrorMeasurement {
+			failed = append(failed, metric)
+			continue
+		}
+		assert.Equal(t, "cpu", metric.Name())
+	}
+	require.Len(t, failed, 2)
+	assert.Equal(t, map[string]string{"source": "legacy"}, failed[0].Tags())
+	assert.Equal(t, int64(5), failed[0].Fields()["line"])
+	assert.Equal(t, "cpu,cpu=cp", failed[0].Fields()["payload"])
+	reason := failed[0].Fields()["reason"].(string)
+	assert.NotEmpty(t, reason)
+	assert.False(t, strings.Contains(reason, "cpu3"), reason)
+	assert.Equal(t, int64(6), failed[1].Fields()["line"])
+
+	m, err := parser.ParseLine(invalidInflux)
+	require.NoError(t, err)
+	assert.Equal(t, DefaultErrorMeasurement, m.Name())
+	assert.Equal(t, int64(1), m.Fields()["line"])
+}
+
+func TestParseRecoverUnterminatedString(t *testing.T) {
+	parser, err := NewInfluxParser(ParseErrorsRecover, "errors", 0)
+	require.NoError(t, err)
+
+	metrics, err := parser.Parse([]byte("log message=\"unterminated\n" +
+		"cpu value=1 1257894000000000000\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+	assert.Equal(t, "errors", metrics[0].Name())
+	assert.Equal(t, "log message=\"unterminated", metrics[0].Fields()["payload"])
+	assert.Equal(t, "cpu", metrics[1].Name())
+	assert.Equal(t, exptime, metrics[1].Time().UTC())
+}
+
+func TestNewInfluxParserInvalid(t *testing.T) {
+	_, err := NewInfluxParser("skip", "", 0)
+	assert.Error(t, err)
+	_, err = NewInfluxParser(ParseErrorsRecover, "", -1)
+	assert.Error(t, err)
+}
@@ -0,0 +1,391 @@
+package keyvalue
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Key value types.
+const (
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeString = "string"
+)
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+// KeyValueParser parses lines of key-value pairs into one metric per line.
+// Examples are logfmt, "at=info method=GET status=200", and appliance logs,
+// "devname: fw1 | action: deny". Each key is a field, except the tags and
+// the time.
+//
+// A value can be quoted with one of Quotes and use backslash escapes. A key
+// without a delimiter and a value is true. Keys not in Types get the type of
+// their value: int, float, bool (true or false), or else string. Quoted
+// values are strings. Empty values mean no value.
+type KeyValueParser struct {
+	MetricName string
+	// TagKeys are the keys that are tags.
+	TagKeys []string
+	// PairDelimiter is the pair delimiter, spaces and tabs if empty.
+	// Delimiter separates keys from values, "=" if empty. Spaces around keys and values are trimmed if PairDelimiter is
+	// not spaces.
+	PairDelimiter string
+	Delimiter     string
+	// Quotes are the value quote characters, none if empty.
+	Quotes string
+	// Types are key types: TypeInt, TypeFloat, TypeBool or
+	// TypeString.
+	Types map[string]string
+	// TimeKey is the key holding the metric time. It is an epoch in
+	// TimeFormat, or a string in its layout. If empty, the parse time is
+	// used.
+	TimeKey string
+	// TimeFormat is the format of TimeKey: unix (if empty), unix_ms,
+	// unix_us, unix_ns, or a Go time layout such as 2006-01-02T15:04:05Z07:00.
+	TimeFormat  string
+	DefaultTags map[string]string
+}
+
+func NewKeyValueParser(
+	metricName string,
+	tagKeys []string,
+	pairDelimiter string,
+	delimiter string,
+	quotes string,
+	types []string,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (*KeyValueParser, error) {
+	p := &KeyValueParser{
+		MetricName:    metricName,
+		TagKeys:       tagKeys,
+		PairDelimiter: pairDelimiter,
+		Delimiter:     delimiter,
+		Quotes:        quotes,
+		Types:         make(map[string]string),
+		TimeKey:       timeKey,
+		TimeFormat:    timeFormat,
+		DefaultTags:   defaultTags,
+	}
+	if p.Delimiter == "" {
+		p.Delimiter = "="
+	}
+	if strings.TrimSpace(p.Delimiter) == "" {
+		return nil, fmt.Errorf("invalid keyvalue_delimiter %q, must not be "+
+			"spaces", delimiter)
+	}
+	if p.PairDelimiter != "" && strings.TrimSpace(p.PairDelimiter) == "" {
+		// runs of spaces are one delimiter
+		p.PairDelimiter = ""
+	}
+	if p.PairDelimiter == p.Delimiter {
+		return nil, fmt.Errorf("invalid keyvalue_pair_delimiter %q, must not "+
+			"be the keyvalue_delimiter", pairDelimiter)
+	}
+	if strings.ContainsAny(quotes, p.Delimiter+p.PairDelimiter+" \t\\") {
+		return nil, fmt.Errorf("invalid keyvalue_quotes %q, must not be "+
+			"delimiters, spaces or backslashes", quotes)
+	}
+	for _, entry := range types {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid keyvalue_types entry %q, must "+
+				"be <key>:<type>", entry)
+		}
+		name, typ := entry[:i], entry[i+1:]
+		switch typ {
+		case TypeInt, TypeFloat, TypeBool, TypeString:
+		default:
+			return nil, fmt.Errorf("invalid type %q in keyvalue_types entry "+
+				"%q, must be int, float, bool or string", typ, entry)
+		}
+		p.Types[name] = typ
+	}
+	if _, ok := epochUnits[timeFormat]; !ok &&
+		strings.HasPrefix(timeFormat, "unix") {
+		return nil, fmt.Errorf("invalid keyvalue_time_format %q, must be "+
+			"unix, unix_ms, unix_us, unix_ns or a time layout", timeFormat)
+	}
+	return p, nil
+}
+
+func (p *KeyValueParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, len(buf)+1)
+	for scanner.Scan() {
+		metric, err := p.ParseLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, scanner.Err()
+}
+
+// ParseLine parses a line of pairs. For blank lines and lines without fields
+// it returns no metric and no error.
+func (p *KeyValueParser) ParseLine(line string) (telegraf.Metric, error) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+	pairs, err := p.split(line)
+	if err != nil {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"keyvalue, %s", line, err)
+	}
+
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	fields := make(map[string]interface{})
+	t := time.Now().UTC()
+	for _, pair := range pairs {
+		switch {
+		case pair.key == p.TimeKey:
+			if t, err = p.time(pair.value); err != nil {
+				return nil, err
+			}
+		case pair.value == "" && !pair.bare:
+		case p.isTag(pair.key):
+			tags[pair.key] = pair.value
+		default:
+			v, err := p.convert(pair)
+			if err != nil {
+				return nil, err
+			}
+			fields[pair.key] = v
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return telegraf.NewMetric(p.MetricName, tags, fields, t)
+}
+
+// pair is a key and its value.
+type pair struct {
+	key, value string
+	// quoted is true if the value is quoted, and bare if the key has no
+	// value
+	quoted, bare bool
+}
+
+// split splits a line into its pairs.
+func (p *KeyValueParser) split(line string) ([]pair, error) {
+	var pairs []pair
+	for {
+		line = p.skipPairDelimiter(line)
+		if line == "" {
+			return pairs, nil
+		}
+
+		// the key, up to the delimiter or the end of the pair
+		end, next := p.pairEnd(line)
+		i := strings.Index(line[:end], p.Delimiter)
+		if i < 0 {
+			if key := p.trim(line[:end]); key != "" {
+				pairs = append(pairs, pair{key: key, bare: true})
+			}
+			line = line[next:]
+			continue
+		}
+		key := p.trim(line[:i])
+		line = line[i+len(p.Delimiter):]
+		if p.PairDelimiter != "" {
+			line = strings.TrimLeft(line, " \t")
+		}
+
+		var v pair
+		if line != "" && strings.IndexByte(p.Quotes, line[0]) >= 0 {
+			value, n, err := unquote(line)
+			if err != nil {
+				return nil, fmt.Errorf("value of key %s: %s", key, err)
+			}
+			v = pair{key: key, value: value, quoted: true}
+			line = line[n:]
+			if p.PairDelimiter != "" {
+				line = strings.TrimLeft(line, " \t")
+			}
+			if end, _ := p.pairEnd(line); end != 0 {
+				return nil, fmt.Errorf("value of key %s: characters after "+
+					"the quote", key)
+			}
+		} else {
+			end, next := p.pairEnd(line)
+			v = pair{key: key, value: p.trim(line[:end])}
+			line = line[next:]
+		}
+		if key != "" {
+			pairs = append(pairs, v)
+		}
+	}
+}
+
+// pairEnd returns where the first pair in line ends, and where the next one
+// starts.
+func (p *KeyValueParser) pairEnd(line string) (int, int) {
+	if p.PairDelimiter == "" {
+		i := strings.IndexAny(line, " \t")
+		if i < 0 {
+			return len(line), len(line)
+		}
+		return i, i
+	}
+	i := strings.Index(line, p.PairDelimiter)
+	if i < 0 {
+		return len(line), len(line)
+	}
+	return i, i + len(p.PairDelimiter)
+}
+
+func (p *KeyValueParser) skipPairDelimiter(line string) string {
+	if p.PairDelimiter == "" {
+		return strings.TrimLeft(line, " \t")
+	}
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if !strings.HasPrefix(line, p.PairDelimiter) {
+			return line
+		}
+		line = line[len(p.PairDelimiter):]
+	}
+}
+
+// trim trims the spaces around keys and values when the delimiters are not
+// spaces.
+func (p *KeyValueParser) trim(s string) string {
+	if p.PairDelimiter == "" {
+		return s
+	}
+	return strings.TrimSpace(s)
+}
+
+// unquote returns the quoted value at the start of s, and its length with
+// the quotes. Backslashes escape the quote, backslashes, and \n and \t.
+func unquote(s string) (string, int, error) {
+	quote := s[0]
+	var value []byte
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == quote:
+			return string(value), i + 1, nil
+		case c == '\\' && i+1 < len(s):
+			i++
+			switch s[i] {
+			case 'n':
+				value = append(value, '\n')
+			case 't':
+				value = append(value, '\t')
+			case quote, '\\':
+				value = append(value, s[i])
+			default:
+				value = append(value, '\\', s[i])
+			}
+		default:
+			value = append(value, c)
+		}
+	}
+	return "", 0, fmt.Errorf("no closing quote")
+}
+
+func (p *KeyValueParser) isTag(key string) bool {
+	for _, k := range p.TagKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// convert converts a pair value to the type set for its key.
+func (p *KeyValueParser) convert(pair pair) (interface{}, error) {
+	typ, ok := p.Types[pair.key]
+	if pair.bare {
+		if ok && typ != TypeBool {
+			return nil, fmt.Errorf("key %s has no value, not a(n) %s",
+				pair.key, typ)
+		}
+		return true, nil
+	}
+	if !ok {
+		if pair.quoted {
+			return pair.value, nil
+		}
+		if v, err := strconv.ParseInt(pair.value, 10, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseFloat(pair.value, 64); err == nil {
+			return v, nil
+		}
+		if strings.EqualFold(pair.value, "true") ||
+			strings.EqualFold(pair.value, "false") {
+			return strings.EqualFold(pair.value, "true"), nil
+		}
+		return pair.value, nil
+	}
+
+	var v interface{}
+	var err error
+	switch typ {
+	case TypeInt:
+		v, err = strconv.ParseInt(pair.value, 10, 64)
+	case TypeFloat:
+		v, err = strconv.ParseFloat(pair.value, 64)
+	case TypeBool:
+		v, err = strconv.ParseBool(strings.ToLower(pair.value))
+	default:
+		v = pair.value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for key %s, not a(n) %s",
+			pair.value, pair.key, typ)
+	}
+	return v, nil
+}
+
+// time converts the value of the TimeKey into a time.
+func (p *KeyValueParser) time(v string) (time.Time, error) {
+	unit, epoch := epochUnits[p.TimeFormat]
+	if !epoch {
+		t, err := time.Parse(p.TimeFormat, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("keyvalue_time_key %s: %s",
+				p.TimeKey, err)
+		}
+		return t.UTC(), nil
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, i*int64(unit)).UTC(), nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("keyvalue_time_key %s is %q, not an "+
+			"epoch", p.TimeKey, v)
+	}
+	return time.Unix(0, int64(f*float64(unit))).UTC(), nil
+}
+
+func (p *KeyValueParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
@@ -0,0 +1,95 @@
+package keyvalue
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	p, err := NewKeyValueParser("app", []string{"method"}, "", "", `"`, nil,
+		"ts", "2006-01-02T15:04:05Z07:00", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse([]byte(
+		`ts=2016-06-13T17:43:50Z at=info method=GET status=200 ` +
+			`duration=0.25 cached=true path="/a \"b\"" empty= debug` + "\n\n" +
+			`ts=2016-06-13T17:43:51Z method=POST code="42"` + "\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "app", metrics[0].Name())
+	assert.Equal(t, map[string]string{"method": "GET"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"at":       "info",
+		"status":   int64(200),
+		"duration": 0.25,
+		"cached":   true,
+		"path":     `/a "b"`,
+		"debug":    true,
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC),
+		metrics[0].Time())
+
+	// quoted values are strings
+	assert.Equal(t, map[string]interface{}{"code": "42"}, metrics[1].Fields())
+}
+
+func TestParseAppliance(t *testing.T) {
+	p, err := NewKeyValueParser("fw", []string{"devname"}, "|", ":", `"'`,
+		[]string{"port:string", "bytes:float"}, "time", "unix_ms", nil)
+	require.NoError(t, err)
+
+	m, err := p.ParseLine(`time: 1465839830123 | devname: fw 1 | ` +
+		`action: deny | msg: 'a | b' | port: 443 | bytes: 1024 ||`)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"devname": "fw 1"}, m.Tags())
+	assert.Equal(t, map[string]interface{}{
+		"action": "deny",
+		"msg":    "a | b",
+		"port":   "443",
+		"bytes":  1024.0,
+	}, m.Fields())
+	assert.Equal(t, time.Unix(0, 1465839830123e6).UTC(), m.Time())
+
+	m, err = p.ParseLine("devname: fw1")
+	require.NoError(t, err)
+	assert.Nil(t, m)
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewKeyValueParser("app", nil, "", "", `"`,
+		[]string{"status:int"}, "", "", nil)
+	require.NoError(t, err)
+	for _, line := range []string{
+		`msg="unterminated`,
+		`msg="a"b`,
+		`status=ok`,
+		`status`,
+	} {
+		_, err := p.ParseLine(line)
+		assert.Error(t, err, line)
+	}
+}
+
+func TestNewKeyValueParserInvalid(t *testing.T) {
+	_, err := NewKeyValueParser("app", nil, "", " ", "", nil, "", "", nil)
+	assert.Error(t, err)
+	_, err = NewKeyValueParser("app", nil, ",", ",", "", nil, "", "", nil)
+	assert.Error(t, err)
+	_, err = NewKeyValueParser("app", nil, "", "", "=", nil, "", "", nil)
+	assert.Error(t, err)
+	_, err = NewKeyValueParser("app", nil, "", "", "", []string{"a"}, "", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewKeyValueParser("app", nil, "", "", "", []string{"a:date"}, "",
+		"", nil)
+	assert.Error(t, err)
+	_, err = NewKeyValueParser("app", nil, "", "", "", nil, "ts", "unix_s",
+		nil)
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "keyvalue_time_format"))
+}
@@ -0,0 +1,570 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+
+	"github.com/golang/snappy"
+)
+
+const magic = "PAR1"
+
+// Physical column value types.
+const (
+	typeBoolean   = 0
+	typeInt32     = 1
+	typeInt64     = 2
+	typeInt96     = 3
+	typeFloat     = 4
+	typeDouble    = 5
+	typeByteArray = 6
+	typeFixed     = 7
+)
+
+// Repetitions of the columns.
+const (
+	required = 0
+	optional = 1
+	repeated = 2
+)
+
+// Converted types of the columns, that are still written with the logical
+// types replacing them.
+const (
+	convertedNone            = -1
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedUint8           = 11
+	convertedUint16          = 12
+	convertedUint32          = 13
+	convertedUint64          = 14
+)
+
+// Column chunk page types.
+const (
+	pageData       = 0
+	pageDictionary = 2
+	pageDataV2     = 3
+)
+
+// Page value encodings.
+const (
+	encodingPlain         = 0
+	encodingPlainDict     = 2
+	encodingRLE           = 3
+	encodingRLEDictionary = 8
+)
+
+// Compression codecs of the pages.
+const (
+	compressionNone   = 0
+	compressionSnappy = 1
+	compressionGzip   = 2
+)
+
+// logicalTimestamp is the field number of the timestamp type in the
+// logical type union.
+const logicalTimestamp = 8
+
+// The largest page, and the most values of a page, read.
+const (
+	maxPageSize   = 1 << 30
+	maxPageValues = 1 << 24
+)
+
+// column is a top-level, non-repeated column in the file schema.
+type column struct {
+	name       string
+	typ        int64
+	typeLength int
+	optional   bool
+	converted  int64
+	// unit is the unit duration of a timestamp column, or 0 for other
+	// columns.
+	unit int64
+}
+
+// file is the metadata of a parquet file, whose row groups and column chunks
+// are read on demand.
+type file struct {
+	r         io.ReaderAt
+	size      int64
+	columns   map[string]*column
+	rowGroups []tstruct
+}
+
+// openFile reads the footer of a parquet file.
+func openFile(r io.ReaderAt, size int64) (*file, error) {
+	if size < int64(2*len(magic)+4) {
+		return nil, errors.New("not a parquet file, too short")
+	}
+	tail := make([]byte, 4+len(magic))
+	if _, err := r.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return nil, err
+	}
+	if string(tail[4:]) != magic {
+		return nil, errors.New("not a parquet file, no PAR1 footer")
+	}
+	length := int64(binary.LittleEndian.Uint32(tail))
+	if length > size-int64(len(tail)+len(magic)) {
+		return nil, errors.New("invalid footer length")
+	}
+	footer := make([]byte, length)
+	if _, err := r.ReadAt(footer, size-int64(len(tail))-length); err != nil {
+		return nil, err
+	}
+
+	meta, err := (&thriftDecoder{buf: footer}).readStruct(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid file metadata, %s", err)
+	}
+	f := &file{r: r, size: size, columns: make(map[string]*column)}
+	for _, v := range meta.list(4) {
+		if rowGroup, ok := v.(tstruct); ok {
+			f.rowGroups = append(f.rowGroups, rowGroup)
+		}
+	}
+
+	// the first element is the schema root, and the elements inside groups
+	// follow each group, depth first
+	var schema []tstruct
+	for _, v := range meta.list(2) {
+		if element, ok := v.(tstruct); ok {
+			schema = append(schema, element)
+		}
+	}
+	if len(schema) == 0 {
+		return nil, errors.New("invalid file metadata, no schema")
+	}
+	for i := 1; i < len(schema); i++ {
+		element := schema[i]
+		if n := element.i64(5); n > 0 {
+			// nested columns are not supported, and skipped
+			i += descendants(schema[i:])
+			continue
+		}
+		if element.i64(3) == repeated {
+			continue
+		}
+		c := &column{
+			name:       element.str(4),
+			typ:        element.i64(1),
+			typeLength: int(element.i64(2)),
+			optional:   element.i64(3) == optional,
+			converted:  convertedNone,
+		}
+		if element.has(6) {
+			c.converted = element.i64(6)
+		}
+		c.unit = timeUnit(c, element.strct(10))
+		f.columns[c.name] = c
+	}
+	return f, nil
+}
+
+// descendants returns the number of schema elements under the group at the
+// start of schema.
+func descendants(schema []tstruct) int {
+	n := 0
+	for children := schema[0].i64(5); children > 0; children-- {
+		if 1+n >= len(schema) {
+			return n
+		}
+		n += 1 + descendants(schema[1+n:])
+	}
+	return n
+}
+
+// timeUnit returns the unit of a timestamp column in nanoseconds, from its
+// logical or converted type. It returns 0 for other columns.
+func timeUnit(c *column, logical tstruct) int64 {
+	if ts := logical.strct(logicalTimestamp); ts != nil {
+		unit := ts.strct(2)
+		switch {
+		case unit.has(1):
+			return 1e6
+		case unit.has(2):
+			return 1e3
+		case unit.has(3):
+			return 1
+		}
+	}
+	switch c.converted {
+	case convertedTimestampMillis:
+		return 1e6
+	case convertedTimestampMicros:
+		return 1e3
+	}
+	return 0
+}
+
+// chunk returns the column chunk of a column in a row group, nil if it has
+// none.
+func chunk(rowGroup tstruct, name string) tstruct {
+	for _, v := range rowGroup.list(1) {
+		c, _ := v.(tstruct)
+		path := c.strct(3).list(3)
+		if len(path) != 1 {
+			continue
+		}
+		if p, ok := path[0].([]byte); ok && string(p) == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// readChunk reads the values in the column chunk of a column. Values are
+// bool, int32, int64, float32, float64, []byte, or [12]byte for INT96. Nulls
+// are nil.
+func (f *file) readChunk(c *column, chunk tstruct) ([]interface{}, error) {
+	meta := chunk.strct(3)
+	if chunk.str(1) != "" {
+		return nil, fmt.Errorf("column %s is in another file", c.name)
+	}
+	offset := meta.i64(9)
+	if dict := meta.i64(11); dict > 0 && dict < offset {
+		offset = dict
+	}
+	length := meta.i64(7)
+	if offset < 0 || length < 0 || offset+length > f.size {
+		return nil, fmt.Errorf("column %s: invalid column chunk", c.name)
+	}
+	buf := make([]byte, length)
+	if _, err := f.r.ReadAt(buf, offset); err != nil {
+		return nil, err
+	}
+
+	count := meta.i64(5)
+	codec := meta.i64(4)
+	values := make([]interface{}, 0, minInt64(count, length*8))
+	var dict []interface{}
+	for int64(len(values)) < count {
+		d := &thriftDecoder{buf: buf}
+		header, err := d.readStruct(0)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: invalid page header, %s",
+				c.name, err)
+		}
+		buf = d.buf
+		size := header.i64(3)
+		if size < 0 || size > int64(len(buf)) {
+			return nil, fmt.Errorf("column %s: %s", c.name, errTruncated)
+		}
+		page := buf[:size]
+		buf = buf[size:]
+
+		switch header.i64(1) {
+		case pageDictionary:
+			data, err := decompress(codec, page, header.i64(2))
+			if err != nil {
+				return nil, fmt.Errorf("column %s: %s", c.name, err)
+			}
+			n := header.strct(7).i64(1)
+			if dict, err = decodePlain(c, data, n); err != nil {
+				return nil, fmt.Errorf("column %s: dictionary page, %s",
+					c.name, err)
+			}
+		case pageData, pageDataV2:
+			if values, err = c.readPage(values, header, page, codec,
+				dict); err != nil {
+				return nil, fmt.Errorf("column %s: data page, %s", c.name, err)
+			}
+		}
+	}
+	return values, nil
+}
+
+// readPage appends the values of a data page to values.
+func (c *column) readPage(
+	values []interface{},
+	header tstruct,
+	page []byte,
+	codec int64,
+	dict []interface{},
+) ([]interface{}, error) {
+	var n, encoding int64
+	var levels, data []byte
+	var err error
+	if header.i64(1) == pageData {
+		h := header.strct(5)
+		n, encoding = h.i64(1), h.i64(2)
+		if data, err = decompress(codec, page, header.i64(2)); err != nil {
+			return nil, err
+		}
+		if c.optional {
+			if len(data) < 4 {
+				return nil, errTruncated
+			}
+			size := binary.LittleEndian.Uint32(data)
+			if uint64(size) > uint64(len(data)-4) {
+				return nil, errTruncated
+			}
+			levels, data = data[4:4+size], data[4+size:]
+		}
+	} else {
+		h := header.strct(8)
+		n, encoding = h.i64(1), h.i64(4)
+		defs, reps := h.i64(5), h.i64(6)
+		if defs < 0 || reps < 0 || defs+reps > int64(len(page)) {
+			return nil, errTruncated
+		}
+		levels, data = page[reps:reps+defs], page[reps+defs:]
+		compressed, ok := h[7].(bool)
+		if !ok || compressed {
+			size := header.i64(2) - defs - reps
+			if data, err = decompress(codec, data, size); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if n < 0 || n > maxPageValues {
+		return nil, errors.New("invalid number of values")
+	}
+
+	defined := int(n)
+	var defs []int
+	if c.optional {
+		if defs, err = decodeHybrid(levels, 1, int(n)); err != nil {
+			return nil, err
+		}
+		defined = 0
+		for _, def := range defs {
+			defined += def
+		}
+	}
+
+	var decoded []interface{}
+	switch encoding {
+	case encodingPlain:
+		decoded, err = decodePlain(c, data, int64(defined))
+	case encodingPlainDict, encodingRLEDictionary:
+		decoded, err = decodeDict(data, dict, defined)
+	case encodingRLE:
+		if c.typ != typeBoolean {
+			return nil, errors.New("RLE encoding of a non-boolean")
+		}
+		if len(data) < 4 {
+			return nil, errTruncated
+		}
+		var bits []int
+		bits, err = decodeHybrid(data[4:], 1, defined)
+		for _, b := range bits {
+			decoded = append(decoded, b == 1)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported encoding %d", encoding)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if defs == nil {
+		return append(values, decoded...), nil
+	}
+	for _, def := range defs {
+		if def == 0 {
+			values = append(values, nil)
+			continue
+		}
+		values = append(values, decoded[0])
+		decoded = decoded[1:]
+	}
+	return values, nil
+}
+
+// decompress decompresses the data of a page.
+func decompress(codec int64, data []byte, size int64) ([]byte, error) {
+	if size < 0 || size > maxPageSize {
+		return nil, errors.New("invalid page size")
+	}
+	switch codec {
+	case compressionNone:
+		return data, nil
+	case compressionSnappy:
+		n, err := snappy.DecodedLen(data)
+		if err != nil {
+			return nil, err
+		}
+		if int64(n) != size {
+			return nil, errors.New("invalid snappy page size")
+		}
+		return snappy.Decode(nil, data)
+	case compressionGzip:
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(io.LimitReader(r, size))
+	}
+	return nil, fmt.Errorf("unsupported compression codec %d", codec)
+}
+
+// decodePlain decodes n values of the plain encoding.
+func decodePlain(c *column, data []byte, n int64) ([]interface{}, error) {
+	size := int64(1)
+	switch c.typ {
+	case typeInt32, typeFloat:
+		size = 4
+	case typeInt64, typeDouble:
+		size = 8
+	case typeInt96:
+		size = 12
+	case typeFixed:
+		size = int64(c.typeLength)
+	}
+	if c.typ == typeBoolean {
+		if n < 0 || (n+7)/8 > int64(len(data)) {
+			return nil, errTruncated
+		}
+	} else if n < 0 || n*size > int64(len(data)) {
+		// byte arrays are 4 bytes at least
+		return nil, errTruncated
+	}
+
+	values := make([]interface{}, 0, n)
+	for i := int64(0); i < n; i++ {
+		switch c.typ {
+		case typeBoolean:
+			values = append(values, data[i/8]>>(uint(i)%8)&1 == 1)
+		case typeInt32:
+			values = append(values, int32(binary.LittleEndian.Uint32(data)))
+			data = data[4:]
+		case typeInt64:
+			values = append(values, int64(binary.LittleEndian.Uint64(data)))
+			data = data[8:]
+		case typeInt96:
+			var v [12]byte
+			copy(v[:], data)
+			values = append(values, v)
+			data = data[12:]
+		case typeFloat:
+			values = append(values,
+				math.Float32frombits(binary.LittleEndian.Uint32(data)))
+			data = data[4:]
+		case typeDouble:
+			values = append(values,
+				math.Float64frombits(binary.LittleEndian.Uint64(data)))
+			data = data[8:]
+		case typeByteArray:
+			if len(data) < 4 {
+				return nil, errTruncated
+			}
+			length := binary.LittleEndian.Uint32(data)
+			if uint64(length) > uint64(len(data)-4) {
+				return nil, errTruncated
+			}
+			values = append(values, data[4:4+length])
+			data = data[4+length:]
+		case typeFixed:
+			if len(data) < c.typeLength {
+				return nil, errTruncated
+			}
+			values = append(values, data[:c.typeLength])
+			data = data[c.typeLength:]
+		default:
+			return nil, fmt.Errorf("invalid type %d", c.typ)
+		}
+	}
+	return values, nil
+}
+
+// decodeDict decodes n values with a dictionary encoding. Each value is an
+// index into the dictionary.
+func decodeDict(data []byte, dict []interface{}, n int) (
+	[]interface{},
+	error,
+) {
+	if n == 0 {
+		return nil, nil
+	}
+	if len(data) == 0 {
+		return nil, errTruncated
+	}
+	indexes, err := decodeHybrid(data[1:], int(data[0]), n)
+	if err != nil {
+		return nil, err
+	}
+	values := make([]interface{}, 0, n)
+	for _, i := range indexes {
+		if i >= len(dict) {
+			return nil, fmt.Errorf("dictionary index %d out of range", i)
+		}
+		values = append(values, dict[i])
+	}
+	return values, nil
+}
+
+// decodeHybrid decodes n values with the RLE/bit-packing hybrid encoding,
+// which is used for definition levels and dictionary indexes.
+func decodeHybrid(data []byte, bitWidth int, n int) ([]int, error) {
+	if bitWidth > 32 {
+		return nil, fmt.Errorf("invalid bit width %d", bitWidth)
+	}
+	values := make([]int, 0, n)
+	for len(values) < n {
+		header, k := binary.Uvarint(data)
+		if k <= 0 {
+			return nil, errTruncated
+		}
+		data = data[k:]
+
+		if header&1 == 0 {
+			// a run of a value
+			width := (bitWidth + 7) / 8
+			if len(data) < width {
+				return nil, errTruncated
+			}
+			v := 0
+			for i := 0; i < width; i++ {
+				v |= int(data[i]) << (8 * uint(i))
+			}
+			data = data[width:]
+			for count := header >> 1; count > 0 && len(values) < n; count-- {
+				values = append(values, v)
+			}
+			continue
+		}
+
+		// groups of 8 bit-packed values, the last one possibly padded
+		count := header >> 1 * 8
+		if count > uint64(n-len(values)) {
+			count = uint64(n - len(values))
+		}
+		if uint64(len(data))*8 < count*uint64(bitWidth) {
+			return nil, errTruncated
+		}
+		bit := uint(0)
+		for i := uint64(0); i < count; i++ {
+			v := 0
+			for j := 0; j < bitWidth; j++ {
+				if data[bit/8]>>(bit%8)&1 == 1 {
+					v |= 1 << uint(j)
+				}
+				bit++
+			}
+			values = append(values, v)
+		}
+		groups := int(header>>1) * bitWidth
+		if groups > len(data) {
+			groups = len(data)
+		}
+		data = data[groups:]
+	}
+	return values, nil
+}
+
+func minInt64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
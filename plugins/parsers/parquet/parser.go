@@ -0,0 +1,375 @@
+package parquet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// ParquetParser parses Apache Parquet files into metrics, a metric for each
+// row of the file. The columns are the tags and fields of the metrics,
+// named by the columns, and the time of the metrics can be a column too.
+//
+// The file is read a row group at a time, and only the columns of the tags,
+// fields and time are read. Only the columns at the top of the schema, that
+// are not repeated, are supported. Nested and repeated columns are ignored.
+type ParquetParser struct {
+	MetricName string
+	// TagKeys are the columns that are tags.
+	TagKeys []string
+	// Fields are the columns that are fields. If empty, every column other
+	// than the tags and the time is a field. Byte array columns are fields
+	// only if they are strings, or listed here.
+	Fields []string
+	// TimestampColumn is the column holding the metric time. It is a
+	// timestamp, or an integer in TimestampUnit since the epoch. If empty,
+	// the parse time is used.
+	TimestampColumn string
+	// TimestampUnit is the unit of an integer TimestampColumn: s (if empty),
+	// ms, us or ns.
+	TimestampUnit string
+	// After and Before, if not zero, limit the metrics to the range from
+	// After, included, to Before, excluded. Row groups whose statistics show
+	// no rows in that range are skipped without being read.
+	After  time.Time
+	Before time.Time
+
+	DefaultTags map[string]string
+}
+
+var timestampUnits = map[string]int64{
+	"":   1e9,
+	"s":  1e9,
+	"ms": 1e6,
+	"us": 1e3,
+	"ns": 1,
+}
+
+func NewParquetParser(
+	metricName string,
+	tagKeys []string,
+	fields []string,
+	timestampColumn string,
+	timestampUnit string,
+	after string,
+	before string,
+	defaultTags map[string]string,
+) (*ParquetParser, error) {
+	p := &ParquetParser{
+		MetricName:      metricName,
+		TagKeys:         tagKeys,
+		Fields:          fields,
+		TimestampColumn: timestampColumn,
+		TimestampUnit:   timestampUnit,
+		DefaultTags:     defaultTags,
+	}
+	if _, ok := timestampUnits[timestampUnit]; !ok {
+		return nil, fmt.Errorf("invalid parquet_timestamp_unit %q, must be "+
+			"s, ms, us or ns", timestampUnit)
+	}
+	for _, option := range []struct {
+		key   string
+		value string
+		time  *time.Time
+	}{
+		{"parquet_time_after", after, &p.After},
+		{"parquet_time_before", before, &p.Before},
+	} {
+		if option.value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, option.value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q, must be an RFC 3339 time",
+				option.key, option.value)
+		}
+		*option.time = t
+	}
+	if (!p.After.IsZero() || !p.Before.IsZero()) && timestampColumn == "" {
+		return nil, fmt.Errorf("parquet_time_after and parquet_time_before " +
+			"need a parquet_timestamp_column")
+	}
+	return p, nil
+}
+
+func (p *ParquetParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	err := p.ParseFile(bytes.NewReader(buf), int64(len(buf)),
+		func(rowGroup []telegraf.Metric) error {
+			metrics = append(metrics, rowGroup...)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// ParseFile parses a parquet file with the given size. It passes the metrics
+// of each row group to fn, so the file does not have to fit in memory. It
+// stops at the first error fn returns.
+func (p *ParquetParser) ParseFile(
+	r io.ReaderAt,
+	size int64,
+	fn func([]telegraf.Metric) error,
+) error {
+	f, err := openFile(r, size)
+	if err != nil {
+		return fmt.Errorf("unable to parse out as parquet, %s", err)
+	}
+
+	var timestamp *column
+	if p.TimestampColumn != "" {
+		if timestamp = f.columns[p.TimestampColumn]; timestamp == nil {
+			return fmt.Errorf("parquet_timestamp_column %s is not a column",
+				p.TimestampColumn)
+		}
+	}
+	tags, fields := p.columns(f)
+
+	for i, rowGroup := range f.rowGroups {
+		if timestamp != nil && p.skip(timestamp,
+			chunk(rowGroup, timestamp.name)) {
+			continue
+		}
+		metrics, err := p.parseRowGroup(f, rowGroup, tags, fields, timestamp)
+		if err != nil {
+			return fmt.Errorf("row group %d: %s", i, err)
+		}
+		if err := fn(metrics); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// columns returns the columns of the tags and fields.
+func (p *ParquetParser) columns(f *file) ([]*column, []*column) {
+	var tags, fields []*column
+	isTag := make(map[string]bool)
+	for _, name := range p.TagKeys {
+		if c, ok := f.columns[name]; ok {
+			tags = append(tags, c)
+			isTag[name] = true
+		}
+	}
+	if len(p.Fields) != 0 {
+		for _, name := range p.Fields {
+			if c, ok := f.columns[name]; ok {
+				fields = append(fields, c)
+			}
+		}
+		return tags, fields
+	}
+	for name, c := range f.columns {
+		if isTag[name] || name == p.TimestampColumn {
+			continue
+		}
+		if c.typ == typeByteArray && c.converted != convertedUTF8 {
+			continue
+		}
+		if c.typ == typeFixed || c.typ == typeInt96 {
+			continue
+		}
+		fields = append(fields, c)
+	}
+	return tags, fields
+}
+
+func (p *ParquetParser) parseRowGroup(
+	f *file,
+	rowGroup tstruct,
+	tags []*column,
+	fields []*column,
+	timestamp *column,
+) ([]telegraf.Metric, error) {
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	rows := rowGroup.i64(3)
+	read := func(c *column) ([]interface{}, error) {
+		ch := chunk(rowGroup, c.name)
+		if ch == nil {
+			return nil, fmt.Errorf("no column chunk of %s", c.name)
+		}
+		values, err := f.readChunk(c, ch)
+		if err != nil {
+			return nil, err
+		}
+		if int64(len(values)) != rows {
+			return nil, fmt.Errorf("column %s has %d values of %d rows",
+				c.name, len(values), rows)
+		}
+		return values, nil
+	}
+
+	tagValues := make([][]interface{}, len(tags))
+	for i, c := range tags {
+		values, err := read(c)
+		if err != nil {
+			return nil, err
+		}
+		tagValues[i] = values
+	}
+	fieldValues := make([][]interface{}, len(fields))
+	for i, c := range fields {
+		values, err := read(c)
+		if err != nil {
+			return nil, err
+		}
+		fieldValues[i] = values
+	}
+	var times []interface{}
+	if timestamp != nil {
+		var err error
+		if times, err = read(timestamp); err != nil {
+			return nil, err
+		}
+	}
+
+	metrics := make([]telegraf.Metric, 0, rows)
+	now := time.Now().UTC()
+	for row := int64(0); row < rows; row++ {
+		t := now
+		if timestamp != nil {
+			var ok bool
+			if t, ok = p.time(timestamp, times[row]); !ok {
+				continue
+			}
+			if !p.After.IsZero() && t.Before(p.After) ||
+				!p.Before.IsZero() && !t.Before(p.Before) {
+				continue
+			}
+		}
+
+		rowTags := make(map[string]string)
+		for k, v := range p.DefaultTags {
+			rowTags[k] = v
+		}
+		for i, c := range tags {
+			if v := value(c, tagValues[i][row]); v != nil {
+				rowTags[c.name] = fmt.Sprint(v)
+			}
+		}
+		rowFields := make(map[string]interface{})
+		for i, c := range fields {
+			if v := value(c, fieldValues[i][row]); v != nil {
+				rowFields[c.name] = v
+			}
+		}
+		if len(rowFields) == 0 {
+			continue
+		}
+
+		metric, err := telegraf.NewMetric(p.MetricName, rowTags, rowFields, t)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// skip returns true if the timestamp column chunk statistics of a row group
+// show that none of its rows is between After and Before.
+func (p *ParquetParser) skip(timestamp *column, chunk tstruct) bool {
+	if p.After.IsZero() && p.Before.IsZero() {
+		return false
+	}
+	stats := chunk.strct(3).strct(12)
+	min, max := stats.bytes(6), stats.bytes(5)
+	if min == nil || max == nil {
+		// the deprecated statistics are the same for signed integers
+		min, max = stats.bytes(2), stats.bytes(1)
+	}
+	var minTime, maxTime time.Time
+	var ok bool
+	switch {
+	case timestamp.typ == typeInt64 && len(min) == 8 && len(max) == 8:
+		if minTime, ok = p.time(timestamp,
+			int64(binary.LittleEndian.Uint64(min))); !ok {
+			return false
+		}
+		maxTime, ok = p.time(timestamp, int64(binary.LittleEndian.Uint64(max)))
+	case timestamp.typ == typeInt32 && len(min) == 4 && len(max) == 4:
+		if minTime, ok = p.time(timestamp,
+			int32(binary.LittleEndian.Uint32(min))); !ok {
+			return false
+		}
+		maxTime, ok = p.time(timestamp, int32(binary.LittleEndian.Uint32(max)))
+	}
+	if !ok {
+		return false
+	}
+	return !p.After.IsZero() && maxTime.Before(p.After) ||
+		!p.Before.IsZero() && !minTime.Before(p.Before)
+}
+
+// time converts a timestamp column value to a time. It returns false if the
+// value is null.
+func (p *ParquetParser) time(c *column, v interface{}) (time.Time, bool) {
+	unit := c.unit
+	if unit == 0 {
+		unit = timestampUnits[p.TimestampUnit]
+	}
+	switch t := v.(type) {
+	case int64:
+		return time.Unix(0, t*unit).UTC(), true
+	case int32:
+		return time.Unix(0, int64(t)*unit).UTC(), true
+	case [12]byte:
+		// nanoseconds of the day, and julian day
+		nanos := int64(binary.LittleEndian.Uint64(t[:8]))
+		day := int64(binary.LittleEndian.Uint32(t[8:]))
+		return time.Unix((day-2440588)*86400, nanos).UTC(), true
+	}
+	return time.Time{}, false
+}
+
+// value converts a column value to a tag or field value. It returns nil if
+// there is none.
+func value(c *column, v interface{}) interface{} {
+	switch t := v.(type) {
+	case bool, float64:
+		return t
+	case float32:
+		return float64(t)
+	case int32:
+		if c.converted == convertedUint32 {
+			return int64(uint32(t))
+		}
+		return int64(t)
+	case int64:
+		if c.converted == convertedUint64 {
+			return uint64(t)
+		}
+		return t
+	case []byte:
+		if c.typ == typeByteArray {
+			return string(t)
+		}
+	}
+	return nil
+}
+
+func (p *ParquetParser) ParseLine(line string) (telegraf.Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) < 1 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"parquet", line)
+	}
+
+	return metrics[0], nil
+}
+
+func (p *ParquetParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
@@ -0,0 +1,604 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/influxdata/telegraf"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// tfield is a field of a thrift struct to encode.
+type tfield struct {
+	id    int16
+	typ   byte
+	value interface{}
+}
+
+// tlist is a thrift list to encode.
+type tlist struct {
+	elem   byte
+	values []interface{}
+}
+
+func encodeStruct(fields ...tfield) []byte {
+	var b []byte
+	var last int16
+	for _, f := range fields {
+		typ := f.typ
+		if typ == thriftTrue && !f.value.(bool) {
+			typ = thriftFalse
+		}
+		if delta := f.id - last; delta > 0 && delta <= 15 {
+			b = append(b, byte(delta)<<4|typ)
+		} else {
+			b = append(b, typ)
+			b = appendVarint(b, int64(f.id))
+		}
+		last = f.id
+		if typ != thriftTrue && typ != thriftFalse {
+			b = append(b, encodeValue(typ, f.value)...)
+		}
+	}
+	return append(b, thriftStop)
+}
+
+func encodeValue(typ byte, v interface{}) []byte {
+	switch typ {
+	case thriftI32, thriftI64:
+		return appendVarint(nil, int64(v.(int)))
+	case thriftBinary:
+		var s []byte
+		switch t := v.(type) {
+		case string:
+			s = []byte(t)
+		case []byte:
+			s = t
+		}
+		b := appendUvarint(nil, uint64(len(s)))
+		return append(b, s...)
+	case thriftList:
+		l := v.(tlist)
+		var b []byte
+		if len(l.values) < 15 {
+			b = []byte{byte(len(l.values))<<4 | l.elem}
+		} else {
+			b = appendUvarint([]byte{0xf0 | l.elem}, uint64(len(l.values)))
+		}
+		for _, v := range l.values {
+			b = append(b, encodeValue(l.elem, v)...)
+		}
+		return b
+	case thriftStruct:
+		return encodeStruct(v.([]tfield)...)
+	}
+	panic("unexpected type")
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// testColumn is a column of a file to write.
+type testColumn struct {
+	name      string
+	typ       int
+	optional  bool
+	converted int
+	// millis makes the column a logical timestamp of milliseconds
+	millis bool
+}
+
+// testOptions are the encodings of a file to write.
+type testOptions struct {
+	codec      int
+	dictionary bool
+	v2         bool
+	stats      bool
+}
+
+func plain(c testColumn, values []interface{}) []byte {
+	var b []byte
+	for i, v := range values {
+		switch c.typ {
+		case typeBoolean:
+			if i%8 == 0 {
+				b = append(b, 0)
+			}
+			if v.(bool) {
+				b[len(b)-1] |= 1 << uint(i%8)
+			}
+		case typeInt32:
+			b = append(b, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(v.(int32)))
+		case typeInt64:
+			b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(b[len(b)-8:], uint64(v.(int64)))
+		case typeInt96:
+			t := v.([12]byte)
+			b = append(b, t[:]...)
+		case typeDouble:
+			b = append(b, 0, 0, 0, 0, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint64(b[len(b)-8:],
+				math.Float64bits(v.(float64)))
+		case typeByteArray:
+			b = append(b, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(len(v.(string))))
+			b = append(b, v.(string)...)
+		}
+	}
+	return b
+}
+
+// bitPacked encodes values with a bit width as bit-packed groups in the
+// RLE/bit-packing hybrid encoding.
+func bitPacked(values []int, bitWidth int) []byte {
+	groups := (len(values) + 7) / 8
+	b := appendUvarint(nil, uint64(groups<<1|1))
+	packed := make([]byte, groups*bitWidth)
+	bit := uint(0)
+	for _, v := range values {
+		for j := 0; j < bitWidth; j++ {
+			if v>>uint(j)&1 == 1 {
+				packed[bit/8] |= 1 << (bit % 8)
+			}
+			bit++
+		}
+	}
+	return append(b, packed...)
+}
+
+func compress(codec int, data []byte) []byte {
+	switch codec {
+	case compressionSnappy:
+		return snappy.Encode(nil, data)
+	case compressionGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		w.Write(data)
+		w.Close()
+		return buf.Bytes()
+	}
+	return data
+}
+
+func page(header []tfield, data []byte) []byte {
+	return append(encodeStruct(header...), data...)
+}
+
+// writeColumnChunk writes the pages for a column in a row group to the file,
+// and returns its column chunk.
+func writeColumnChunk(
+	file *[]byte,
+	c testColumn,
+	values []interface{},
+	opts testOptions,
+) []tfield {
+	var defs []int
+	var defined []interface{}
+	for _, v := range values {
+		if v == nil {
+			defs = append(defs, 0)
+			continue
+		}
+		defs = append(defs, 1)
+		defined = append(defined, v)
+	}
+
+	start := len(*file)
+	var dictOffset int
+	encoding := encodingPlain
+	data := plain(c, defined)
+	if opts.dictionary {
+		var dict []interface{}
+		var indexes []int
+		for _, v := range defined {
+			i := 0
+			for i < len(dict) && dict[i] != v {
+				i++
+			}
+			if i == len(dict) {
+				dict = append(dict, v)
+			}
+			indexes = append(indexes, i)
+		}
+		raw := plain(c, dict)
+		compressed := compress(opts.codec, raw)
+		dictOffset = len(*file)
+		*file = append(*file, page([]tfield{
+			{1, thriftI32, pageDictionary},
+			{2, thriftI32, len(raw)},
+			{3, thriftI32, len(compressed)},
+			{7, thriftStruct, []tfield{
+				{1, thriftI32, len(dict)},
+				{2, thriftI32, encodingPlain},
+			}},
+		}, compressed)...)
+		encoding = encodingRLEDictionary
+		data = append([]byte{2}, bitPacked(indexes, 2)...)
+	}
+
+	dataOffset := len(*file)
+	var levels []byte
+	if c.optional {
+		levels = bitPacked(defs, 1)
+	}
+	if opts.v2 {
+		compressed := compress(opts.codec, data)
+		*file = append(*file, page([]tfield{
+			{1, thriftI32, pageDataV2},
+			{2, thriftI32, len(levels) + len(data)},
+			{3, thriftI32, len(levels) + len(compressed)},
+			{8, thriftStruct, []tfield{
+				{1, thriftI32, len(values)},
+				{2, thriftI32, len(values) - len(defined)},
+				{3, thriftI32, len(values)},
+				{4, thriftI32, encoding},
+				{5, thriftI32, len(levels)},
+				{6, thriftI32, 0},
+			}},
+		}, append(levels, compressed...))...)
+	} else {
+		if c.optional {
+			prefix := make([]byte, 4)
+			binary.LittleEndian.PutUint32(prefix, uint32(len(levels)))
+			data = append(append(prefix, levels...), data...)
+		}
+		compressed := compress(opts.codec, data)
+		*file = append(*file, page([]tfield{
+			{1, thriftI32, pageData},
+			{2, thriftI32, len(data)},
+			{3, thriftI32, len(compressed)},
+			{5, thriftStruct, []tfield{
+				{1, thriftI32, len(values)},
+				{2, thriftI32, encoding},
+				{3, thriftI32, encodingRLE},
+				{4, thriftI32, encodingRLE},
+			}},
+		}, compressed)...)
+	}
+
+	meta := []tfield{
+		{1, thriftI32, c.typ},
+		{2, thriftList, tlist{thriftI32, []interface{}{encoding}}},
+		{3, thriftList, tlist{thriftBinary, []interface{}{c.name}}},
+		{4, thriftI32, opts.codec},
+		{5, thriftI64, len(values)},
+		{6, thriftI64, len(*file) - start},
+		{7, thriftI64, len(*file) - start},
+		{9, thriftI64, dataOffset},
+	}
+	if opts.dictionary {
+		meta = append(meta, tfield{11, thriftI64, dictOffset})
+	}
+	if opts.stats && c.typ == typeInt64 {
+		min, max := defined[0].(int64), defined[0].(int64)
+		for _, v := range defined {
+			if v.(int64) < min {
+				min = v.(int64)
+			}
+			if v.(int64) > max {
+				max = v.(int64)
+			}
+		}
+		meta = append(meta, tfield{12, thriftStruct, []tfield{
+			{5, thriftBinary, plain(c, []interface{}{max})},
+			{6, thriftBinary, plain(c, []interface{}{min})},
+		}})
+	}
+	return []tfield{
+		{2, thriftI64, start},
+		{3, thriftStruct, meta},
+	}
+}
+
+// writeFile writes a parquet file with the given column values for each
+// row group.
+func writeFile(
+	columns []testColumn,
+	rowGroups [][][]interface{},
+	opts testOptions,
+) []byte {
+	file := []byte(magic)
+
+	schema := []interface{}{
+		[]tfield{
+			{4, thriftBinary, "schema"},
+			{5, thriftI32, len(columns) + 1},
+		},
+		// a nested column, that is ignored
+		[]tfield{
+			{3, thriftI32, optional},
+			{4, thriftBinary, "location"},
+			{5, thriftI32, 1},
+		},
+		[]tfield{
+			{1, thriftI32, typeByteArray},
+			{3, thriftI32, optional},
+			{4, thriftBinary, "site"},
+		},
+	}
+	for _, c := range columns {
+		repetition := required
+		if c.optional {
+			repetition = optional
+		}
+		element := []tfield{
+			{1, thriftI32, c.typ},
+			{3, thriftI32, repetition},
+			{4, thriftBinary, c.name},
+		}
+		if c.converted != convertedNone {
+			element = append(element, tfield{6, thriftI32, c.converted})
+		}
+		if c.millis {
+			element = append(element, tfield{10, thriftStruct, []tfield{
+				{8, thriftStruct, []tfield{
+					{1, thriftTrue, true},
+					{2, thriftStruct, []tfield{
+						{1, thriftStruct, []tfield{}},
+					}},
+				}},
+			}})
+		}
+		schema = append(schema, element)
+	}
+
+	var groups []interface{}
+	rows := 0
+	for _, values := range rowGroups {
+		var chunks []interface{}
+		for i, c := range columns {
+			chunks = append(chunks, writeColumnChunk(&file, c, values[i], opts))
+		}
+		groups = append(groups, []tfield{
+			{1, thriftList, tlist{thriftStruct, chunks}},
+			{2, thriftI64, len(file)},
+			{3, thriftI64, len(values[0])},
+		})
+		rows += len(values[0])
+	}
+
+	footer := encodeStruct(
+		tfield{1, thriftI32, 1},
+		tfield{2, thriftList, tlist{thriftStruct, schema}},
+		tfield{3, thriftI64, rows},
+		tfield{4, thriftList, tlist{thriftStruct, groups}},
+	)
+	file = append(file, footer...)
+	length := make([]byte, 4)
+	binary.LittleEndian.PutUint32(length, uint32(len(footer)))
+	file = append(file, length...)
+	return append(file, magic...)
+}
+
+var testColumns = []testColumn{
+	{name: "time", typ: typeInt64, converted: convertedNone, millis: true},
+	{name: "device", typ: typeByteArray, converted: convertedUTF8},
+	{name: "temperature", typ: typeDouble, optional: true,
+		converted: convertedNone},
+	{name: "count", typ: typeInt32, converted: convertedUint32},
+	{name: "ok", typ: typeBoolean, optional: true, converted: convertedNone},
+	{name: "raw", typ: typeByteArray, converted: convertedNone},
+}
+
+const testTime = 1465839830123
+
+func testRowGroups() [][][]interface{} {
+	return [][][]interface{}{
+		{
+			{int64(testTime), int64(testTime + 1000), int64(testTime + 2000)},
+			{"sensor-1", "sensor-2", "sensor-1"},
+			{21.5, nil, 22.5},
+			{int32(1), int32(-1), int32(3)},
+			{true, nil, false},
+			{"a", "b", "c"},
+		},
+		{
+			{int64(testTime + 60000), int64(testTime + 61000)},
+			{"sensor-2", "sensor-2"},
+			{23.5, 24.5},
+			{int32(4), int32(5)},
+			{true, true},
+			{"d", "e"},
+		},
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, opts := range []testOptions{
+		{},
+		{codec: compressionSnappy},
+		{codec: compressionGzip, dictionary: true},
+		{codec: compressionSnappy, dictionary: true, v2: true},
+		{v2: true},
+	} {
+		data := writeFile(testColumns, testRowGroups(), opts)
+		p, err := NewParquetParser("sensor", []string{"device"}, nil, "time",
+			"", "", "", map[string]string{"source": "lake"})
+		require.NoError(t, err)
+
+		metrics, err := p.Parse(data)
+		require.NoError(t, err, "%+v", opts)
+		require.Len(t, metrics, 5, "%+v", opts)
+
+		m := metrics[0]
+		assert.Equal(t, "sensor", m.Name())
+		assert.Equal(t, map[string]string{
+			"device": "sensor-1",
+			"source": "lake",
+		}, m.Tags())
+		assert.Equal(t, map[string]interface{}{
+			"temperature": 21.5,
+			"count":       int64(1),
+			"ok":          true,
+		}, m.Fields(), "%+v", opts)
+		assert.Equal(t, time.Unix(0, testTime*1e6).UTC(), m.Time())
+
+		assert.Equal(t, map[string]interface{}{
+			"count": int64(math.MaxUint32),
+		}, metrics[1].Fields(), "%+v", opts)
+		assert.Equal(t, "sensor-2", metrics[4].Tags()["device"])
+		assert.Equal(t, 24.5, metrics[4].Fields()["temperature"])
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	data := writeFile(testColumns, testRowGroups(), testOptions{})
+	p, err := NewParquetParser("sensor", nil, []string{"raw", "temperature"},
+		"", "", "", "", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse(data)
+	require.NoError(t, err)
+	require.Len(t, metrics, 5)
+	assert.Equal(t, map[string]interface{}{
+		"raw":         "a",
+		"temperature": 21.5,
+	}, metrics[0].Fields())
+	assert.Equal(t, map[string]interface{}{"raw": "b"}, metrics[1].Fields())
+	assert.Empty(t, metrics[0].Tags())
+}
+
+func TestParseTimestamps(t *testing.T) {
+	// 2016-06-13T17:43:50Z, as nanoseconds of the day and julian day
+	var int96 [12]byte
+	binary.LittleEndian.PutUint64(int96[:8], uint64(63830*time.Second))
+	binary.LittleEndian.PutUint32(int96[8:], 2457553)
+	expected := time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC)
+
+	tests := []struct {
+		column testColumn
+		unit   string
+		value  interface{}
+	}{
+		{testColumn{typ: typeInt96, converted: convertedNone}, "", int96},
+		{testColumn{typ: typeInt64, converted: convertedNone}, "",
+			int64(1465839830)},
+		{testColumn{typ: typeInt64, converted: convertedNone}, "us",
+			int64(1465839830000000)},
+		{testColumn{typ: typeInt64, converted: convertedTimestampMicros}, "",
+			int64(1465839830000000)},
+		{testColumn{typ: typeInt32, converted: convertedNone}, "s",
+			int32(1465839830)},
+	}
+	for _, test := range tests {
+		test.column.name = "ts"
+		columns := []testColumn{
+			test.column,
+			{name: "value", typ: typeDouble, converted: convertedNone},
+		}
+		data := writeFile(columns, [][][]interface{}{
+			{{test.value}, {1.0}},
+		}, testOptions{})
+		p, err := NewParquetParser("sensor", nil, nil, "ts", test.unit, "", "",
+			nil)
+		require.NoError(t, err)
+		m, err := p.ParseLine(string(data))
+		require.NoError(t, err)
+		assert.Equal(t, expected, m.Time(), "%+v", test)
+		assert.Equal(t, map[string]interface{}{"value": 1.0}, m.Fields())
+	}
+}
+
+// readerAt counts the bytes read from a file.
+type readerAt struct {
+	*bytes.Reader
+	read int
+}
+
+func (r *readerAt) ReadAt(b []byte, off int64) (int, error) {
+	r.read += len(b)
+	return r.Reader.ReadAt(b, off)
+}
+
+func TestParseFileTimeRange(t *testing.T) {
+	data := writeFile(testColumns, testRowGroups(), testOptions{stats: true})
+	after := time.Unix(0, (testTime+1000)*1e6).UTC().Format(time.RFC3339Nano)
+	before := time.Unix(0, (testTime+2000)*1e6).UTC().Format(time.RFC3339Nano)
+
+	p, err := NewParquetParser("sensor", nil, nil, "time", "", after, before,
+		nil)
+	require.NoError(t, err)
+
+	r := &readerAt{Reader: bytes.NewReader(data)}
+	var rowGroups [][]telegraf.Metric
+	err = p.ParseFile(r, int64(len(data)), func(m []telegraf.Metric) error {
+		rowGroups = append(rowGroups, m)
+		return nil
+	})
+	require.NoError(t, err)
+	// the second row group is skipped
+	require.Len(t, rowGroups, 1)
+	require.Len(t, rowGroups[0], 1)
+	assert.Equal(t, int64(math.MaxUint32), rowGroups[0][0].Fields()["count"])
+	assert.True(t, r.read < len(data), "read %d of %d bytes", r.read,
+		len(data))
+
+	// the first row group is skipped
+	p, err = NewParquetParser("sensor", nil, nil, "time", "",
+		time.Unix(0, (testTime+60000)*1e6).UTC().Format(time.RFC3339), "",
+		nil)
+	require.NoError(t, err)
+	metrics, err := p.Parse(data)
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	errStop := errors.New("stop")
+	err = p.ParseFile(bytes.NewReader(data), int64(len(data)),
+		func([]telegraf.Metric) error { return errStop })
+	assert.Equal(t, errStop, err)
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewParquetParser("sensor", nil, nil, "", "", "", "", nil)
+	require.NoError(t, err)
+
+	data := writeFile(testColumns, testRowGroups(), testOptions{})
+	for _, invalid := range [][]byte{
+		[]byte("PAR1"),
+		[]byte("not a parquet file at all"),
+		data[:len(data)-1],
+		append(append([]byte{}, data[:4]...), data[20:]...),
+	} {
+		_, err = p.Parse(invalid)
+		assert.Error(t, err)
+	}
+
+	p.TimestampColumn = "missing"
+	_, err = p.Parse(data)
+	assert.Error(t, err)
+
+	_, err = NewParquetParser("sensor", nil, nil, "time", "h", "", "", nil)
+	assert.Error(t, err)
+	_, err = NewParquetParser("sensor", nil, nil, "time", "", "yesterday", "",
+		nil)
+	assert.Error(t, err)
+	_, err = NewParquetParser("sensor", nil, nil, "", "",
+		"2016-06-13T00:00:00Z", "", nil)
+	assert.Error(t, err)
+}
+
+func TestDecodeHybrid(t *testing.T) {
+	// a run of 5 threes, and 3 bit-packed values
+	data := append([]byte{5 << 1, 3}, bitPacked([]int{1, 2, 3}, 2)...)
+	values, err := decodeHybrid(data, 2, 8)
+	require.NoError(t, err)
+	assert.Equal(t, []int{3, 3, 3, 3, 3, 1, 2, 3}, values)
+
+	_, err = decodeHybrid(data[:3], 2, 8)
+	assert.Error(t, err)
+}
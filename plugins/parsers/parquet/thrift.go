@@ -0,0 +1,222 @@
+package parquet
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Thrift compact protocol types. Parquet file metadata is encoded with this
+// protocol.
+const (
+	thriftStop    = 0
+	thriftTrue    = 1
+	thriftFalse   = 2
+	thriftByte    = 3
+	thriftI16     = 4
+	thriftI32     = 5
+	thriftI64     = 6
+	thriftDouble  = 7
+	thriftBinary  = 8
+	thriftList    = 9
+	thriftSet     = 10
+	thriftMap     = 11
+	thriftStruct  = 12
+	thriftMaxNest = 64
+)
+
+var errTruncated = errors.New("unexpected end of data")
+
+// tstruct is a decoded thrift struct, its values by field id: bool, int64
+// for every integer type, float64, []byte, []interface{} for lists and sets,
+// and tstruct. Maps are skipped.
+type tstruct map[int16]interface{}
+
+func (s tstruct) i64(id int16) int64 {
+	v, _ := s[id].(int64)
+	return v
+}
+
+func (s tstruct) bytes(id int16) []byte {
+	v, _ := s[id].([]byte)
+	return v
+}
+
+func (s tstruct) str(id int16) string {
+	return string(s.bytes(id))
+}
+
+func (s tstruct) strct(id int16) tstruct {
+	v, _ := s[id].(tstruct)
+	return v
+}
+
+func (s tstruct) list(id int16) []interface{} {
+	v, _ := s[id].([]interface{})
+	return v
+}
+
+func (s tstruct) has(id int16) bool {
+	_, ok := s[id]
+	return ok
+}
+
+// thriftDecoder decodes thrift compact protocol structs from a buffer.
+type thriftDecoder struct {
+	buf []byte
+}
+
+// readStruct decodes a struct, up to its stop field.
+func (d *thriftDecoder) readStruct(depth int) (tstruct, error) {
+	if depth > thriftMaxNest {
+		return nil, fmt.Errorf("nested deeper than %d", thriftMaxNest)
+	}
+	s := make(tstruct)
+	var id int16
+	for {
+		if len(d.buf) == 0 {
+			return nil, errTruncated
+		}
+		b := d.buf[0]
+		d.buf = d.buf[1:]
+		typ := b & 0x0f
+		if typ == thriftStop {
+			return s, nil
+		}
+		if delta := int16(b >> 4); delta != 0 {
+			id += delta
+		} else {
+			v, err := d.readInt()
+			if err != nil {
+				return nil, err
+			}
+			id = int16(v)
+		}
+
+		var v interface{}
+		var err error
+		switch typ {
+		case thriftTrue:
+			v = true
+		case thriftFalse:
+			v = false
+		default:
+			v, err = d.readValue(typ, depth)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if v != nil {
+			s[id] = v
+		}
+	}
+}
+
+// readValue decodes a value of a type. Boolean fields are not handled here.
+func (d *thriftDecoder) readValue(typ byte, depth int) (interface{}, error) {
+	switch typ {
+	case thriftTrue, thriftFalse:
+		// booleans of lists are bytes
+		if len(d.buf) == 0 {
+			return nil, errTruncated
+		}
+		v := d.buf[0] == thriftTrue
+		d.buf = d.buf[1:]
+		return v, nil
+	case thriftByte:
+		if len(d.buf) == 0 {
+			return nil, errTruncated
+		}
+		v := int64(int8(d.buf[0]))
+		d.buf = d.buf[1:]
+		return v, nil
+	case thriftI16, thriftI32, thriftI64:
+		return d.readInt()
+	case thriftDouble:
+		if len(d.buf) < 8 {
+			return nil, errTruncated
+		}
+		v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+		d.buf = d.buf[8:]
+		return v, nil
+	case thriftBinary:
+		n, err := d.readSize()
+		if err != nil {
+			return nil, err
+		}
+		v := d.buf[:n]
+		d.buf = d.buf[n:]
+		return v, nil
+	case thriftList, thriftSet:
+		if len(d.buf) == 0 {
+			return nil, errTruncated
+		}
+		n, elem := int(d.buf[0]>>4), d.buf[0]&0x0f
+		d.buf = d.buf[1:]
+		if n == 15 {
+			var err error
+			if n, err = d.readSize(); err != nil {
+				return nil, err
+			}
+		}
+		if n > len(d.buf) {
+			return nil, errTruncated
+		}
+		values := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := d.readValue(elem, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, v)
+		}
+		return values, nil
+	case thriftMap:
+		n, err := d.readSize()
+		if err != nil || n == 0 {
+			return nil, err
+		}
+		if len(d.buf) == 0 {
+			return nil, errTruncated
+		}
+		key, value := d.buf[0]>>4, d.buf[0]&0x0f
+		d.buf = d.buf[1:]
+		for i := 0; i < n; i++ {
+			if _, err := d.readValue(key, depth+1); err != nil {
+				return nil, err
+			}
+			if _, err := d.readValue(value, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	case thriftStruct:
+		return d.readStruct(depth + 1)
+	}
+	return nil, fmt.Errorf("invalid thrift type %d", typ)
+}
+
+// readInt decodes a zigzag varint.
+func (d *thriftDecoder) readInt() (int64, error) {
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	return v, nil
+}
+
+// readSize decodes the size of a binary, list or map, which must fit in the
+// buffer.
+func (d *thriftDecoder) readSize() (int, error) {
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		return 0, errTruncated
+	}
+	d.buf = d.buf[n:]
+	if v > uint64(len(d.buf)) {
+		return 0, errTruncated
+	}
+	return int(v), nil
+}
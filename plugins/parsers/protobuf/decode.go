@@ -0,0 +1,280 @@
+package protobuf
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// LoadDescriptorSet reads a FileDescriptorSet, as written by
+// `protoc --include_imports --descriptor_set_out=<path>`.
+func LoadDescriptorSet(path string) (*descriptor.FileDescriptorSet, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	set := &descriptor.FileDescriptorSet{}
+	if err := proto.Unmarshal(data, set); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set %s, %s", path, err)
+	}
+	return set, nil
+}
+
+// CompileProtoFiles compiles .proto files, and the files they import, into a
+// FileDescriptorSet with the protoc compiler, which must be in the PATH.
+func CompileProtoFiles(
+	files []string,
+	importPaths []string,
+) (*descriptor.FileDescriptorSet, error) {
+	out, err := ioutil.TempFile("", "telegraf-protobuf")
+	if err != nil {
+		return nil, err
+	}
+	out.Close()
+	defer os.Remove(out.Name())
+
+	args := []string{"--include_imports", "--descriptor_set_out=" + out.Name()}
+	for _, path := range importPaths {
+		args = append(args, "--proto_path="+path)
+	}
+	args = append(args, files...)
+	output, err := exec.Command("protoc", args...).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("protoc %s failed, %s: %s",
+			strings.Join(files, " "), err, strings.TrimSpace(string(output)))
+	}
+	return LoadDescriptorSet(out.Name())
+}
+
+// registry holds the message and enum types of a descriptor set, keyed by
+// their full name, such as ".sensors.Reading".
+type registry struct {
+	messages map[string]*descriptor.DescriptorProto
+	enums    map[string]*descriptor.EnumDescriptorProto
+}
+
+func newRegistry(set *descriptor.FileDescriptorSet) *registry {
+	r := &registry{
+		messages: make(map[string]*descriptor.DescriptorProto),
+		enums:    make(map[string]*descriptor.EnumDescriptorProto),
+	}
+	for _, file := range set.GetFile() {
+		prefix := ""
+		if file.GetPackage() != "" {
+			prefix = "." + file.GetPackage()
+		}
+		for _, msg := range file.GetMessageType() {
+			r.addMessage(prefix, msg)
+		}
+		for _, enum := range file.GetEnumType() {
+			r.enums[prefix+"."+enum.GetName()] = enum
+		}
+	}
+	return r
+}
+
+func (r *registry) addMessage(prefix string, msg *descriptor.DescriptorProto) {
+	name := prefix + "." + msg.GetName()
+	r.messages[name] = msg
+	for _, nested := range msg.GetNestedType() {
+		r.addMessage(name, nested)
+	}
+	for _, enum := range msg.GetEnumType() {
+		r.enums[name+"."+enum.GetName()] = enum
+	}
+}
+
+// decodeMessage decodes a message into a map of its fields by name. Repeated
+// fields are []interface{}, maps are map[string]interface{}, like nested
+// messages, and enums are their value names. Unknown fields are
+// skipped.
+func (r *registry) decodeMessage(
+	msg *descriptor.DescriptorProto,
+	buf []byte,
+) (map[string]interface{}, error) {
+	fields := make(map[string]interface{})
+	err := protowire.Range(buf, func(f protowire.Field) error {
+		field := findField(msg, int32(f.Number))
+		if field == nil {
+			return nil
+		}
+		if err := r.decodeField(fields, field, f); err != nil {
+			return fmt.Errorf("%s.%s: %s", msg.GetName(), field.GetName(),
+				err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (r *registry) decodeField(
+	fields map[string]interface{},
+	field *descriptor.FieldDescriptorProto,
+	f protowire.Field,
+) error {
+	name := field.GetName()
+	repeated := field.GetLabel() ==
+		descriptor.FieldDescriptorProto_LABEL_REPEATED
+
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+		descriptor.FieldDescriptorProto_TYPE_STRING,
+		descriptor.FieldDescriptorProto_TYPE_BYTES:
+		if f.Wire != protowire.WireBytes {
+			return fmt.Errorf("unexpected wire type %d", f.Wire)
+		}
+	}
+
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_MESSAGE:
+		msg, ok := r.messages[field.GetTypeName()]
+		if !ok {
+			return fmt.Errorf("unknown message type %s", field.GetTypeName())
+		}
+		v, err := r.decodeMessage(msg, f.Raw)
+		if err != nil {
+			return err
+		}
+		if msg.GetOptions().GetMapEntry() {
+			entries, _ := fields[name].(map[string]interface{})
+			if entries == nil {
+				entries = make(map[string]interface{})
+				fields[name] = entries
+			}
+			entries[fmt.Sprint(v["key"])] = v["value"]
+			return nil
+		}
+		setField(fields, name, v, repeated)
+		return nil
+	case descriptor.FieldDescriptorProto_TYPE_STRING:
+		setField(fields, name, string(f.Raw), repeated)
+		return nil
+	case descriptor.FieldDescriptorProto_TYPE_BYTES:
+		setField(fields, name, append([]byte(nil), f.Raw...), repeated)
+		return nil
+	case descriptor.FieldDescriptorProto_TYPE_GROUP:
+		return errors.New("groups are not supported")
+	}
+
+	if f.Wire != protowire.WireBytes {
+		v, err := r.scalar(field, f.Value)
+		if err != nil {
+			return err
+		}
+		setField(fields, name, v, repeated)
+		return nil
+	}
+
+	// packed repeated scalars
+	wire := protowire.WireVarint
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		wire = protowire.WireFixed64
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		wire = protowire.WireFixed32
+	}
+	return protowire.Packed(f.Raw, wire, func(value uint64) error {
+		v, err := r.scalar(field, value)
+		if err != nil {
+			return err
+		}
+		setField(fields, name, v, true)
+		return nil
+	})
+}
+
+// scalar returns the value of a scalar field from its varint or fixed
+// encoding.
+func (r *registry) scalar(
+	field *descriptor.FieldDescriptorProto,
+	value uint64,
+) (interface{}, error) {
+	switch field.GetType() {
+	case descriptor.FieldDescriptorProto_TYPE_DOUBLE:
+		return math.Float64frombits(value), nil
+	case descriptor.FieldDescriptorProto_TYPE_FLOAT:
+		return float64(math.Float32frombits(uint32(value))), nil
+	case descriptor.FieldDescriptorProto_TYPE_INT64,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED64:
+		return int64(value), nil
+	case descriptor.FieldDescriptorProto_TYPE_INT32,
+		descriptor.FieldDescriptorProto_TYPE_SFIXED32:
+		return int64(int32(value)), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT64,
+		descriptor.FieldDescriptorProto_TYPE_FIXED64:
+		// InfluxDB has no unsigned integers, the values overflowing an int64
+		// are capped like the accumulator does
+		if value > math.MaxInt64 {
+			return int64(math.MaxInt64), nil
+		}
+		return int64(value), nil
+	case descriptor.FieldDescriptorProto_TYPE_UINT32,
+		descriptor.FieldDescriptorProto_TYPE_FIXED32:
+		return int64(uint32(value)), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT32:
+		return int64(int32(uint32(value)>>1) ^ -int32(value&1)), nil
+	case descriptor.FieldDescriptorProto_TYPE_SINT64:
+		return int64(value>>1) ^ -int64(value&1), nil
+	case descriptor.FieldDescriptorProto_TYPE_BOOL:
+		return value != 0, nil
+	case descriptor.FieldDescriptorProto_TYPE_ENUM:
+		enum, ok := r.enums[field.GetTypeName()]
+		if !ok {
+			return nil, fmt.Errorf("unknown enum type %s", field.GetTypeName())
+		}
+		for _, v := range enum.GetValue() {
+			if v.GetNumber() == int32(value) {
+				return v.GetName(), nil
+			}
+		}
+		return int64(int32(value)), nil
+	}
+	return nil, fmt.Errorf("unexpected wire type for a %s",
+		strings.ToLower(strings.TrimPrefix(field.GetType().String(), "TYPE_")))
+}
+
+// setField sets the value of a field, or appends it to the values of a
+// repeated one.
+func setField(
+	fields map[string]interface{},
+	name string,
+	value interface{},
+	repeated bool,
+) {
+	if !repeated {
+		fields[name] = value
+		return
+	}
+	values, _ := fields[name].([]interface{})
+	fields[name] = append(values, value)
+}
+
+// findField returns the message field with a number, or nil if there is
+// none.
+func findField(
+	msg *descriptor.DescriptorProto,
+	number int32,
+) *descriptor.FieldDescriptorProto {
+	for _, field := range msg.GetField() {
+		if field.GetNumber() == number {
+			return field
+		}
+	}
+	return nil
+}
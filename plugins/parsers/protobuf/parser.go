@@ -0,0 +1,229 @@
+package protobuf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/influxdata/telegraf"
+)
+
+// ProtobufParser parses protobuf messages into metrics. The message type is
+// defined at runtime by a FileDescriptorSet.
+//
+// Message fields are addressed by paths of field names separated by dots,
+// such as "device.id". Paths include the index for repeated fields and the
+// key for maps, such as "readings.0.value" or "labels.site". The tag or field
+// name is the path with its dots replaced by underscores.
+type ProtobufParser struct {
+	MetricName string
+	// MessageType is the full name of the message type, such as
+	// sensors.Reading.
+	MessageType string
+	// TagKeys are the paths of the fields that are tags.
+	TagKeys []string
+	// FieldPaths are the paths of the fields to keep. All fields are kept if
+	// empty.
+	FieldPaths []string
+	// TimestampPath is the path of the metric time. It is either a
+	// google.protobuf.Timestamp or an integer in TimestampUnit since the
+	// epoch. If empty, the parse time is used.
+	TimestampPath string
+	// TimestampUnit is the unit of an integer timestamp: s (if empty), ms, us
+	// or ns.
+	TimestampUnit string
+	DefaultTags   map[string]string
+
+	registry *registry
+	message  *descriptor.DescriptorProto
+}
+
+var timestampUnits = map[string]time.Duration{
+	"":   time.Second,
+	"s":  time.Second,
+	"ms": time.Millisecond,
+	"us": time.Microsecond,
+	"ns": time.Nanosecond,
+}
+
+func NewProtobufParser(
+	set *descriptor.FileDescriptorSet,
+	messageType string,
+	metricName string,
+	tagKeys []string,
+	fieldPaths []string,
+	timestampPath string,
+	timestampUnit string,
+	defaultTags map[string]string,
+) (*ProtobufParser, error) {
+	p := &ProtobufParser{
+		MetricName:    metricName,
+		MessageType:   messageType,
+		TagKeys:       tagKeys,
+		FieldPaths:    fieldPaths,
+		TimestampPath: timestampPath,
+		TimestampUnit: timestampUnit,
+		DefaultTags:   defaultTags,
+		registry:      newRegistry(set),
+	}
+	if messageType == "" {
+		return nil, fmt.Errorf("protobuf_message_type must be set")
+	}
+	name := "." + strings.TrimPrefix(messageType, ".")
+	var ok bool
+	if p.message, ok = p.registry.messages[name]; !ok {
+		return nil, fmt.Errorf("message type %s is not defined in the "+
+			"descriptors", messageType)
+	}
+	if _, ok := timestampUnits[timestampUnit]; !ok {
+		return nil, fmt.Errorf("invalid protobuf_timestamp_unit %q, must be "+
+			"s, ms, us or ns", timestampUnit)
+	}
+	return p, nil
+}
+
+func (p *ProtobufParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	values, err := p.registry.decodeMessage(p.message, buf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse out as protobuf %s, %s",
+			p.MessageType, err)
+	}
+
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	for _, path := range p.TagKeys {
+		switch v := lookup(values, path).(type) {
+		case nil, map[string]interface{}, []interface{}:
+		case []byte:
+			tags[pathName(path)] = string(v)
+		default:
+			tags[pathName(path)] = fmt.Sprint(v)
+		}
+	}
+
+	t := time.Now().UTC()
+	if p.TimestampPath != "" {
+		if t, err = p.timestamp(lookup(values, p.TimestampPath)); err != nil {
+			return nil, err
+		}
+	}
+
+	// the tags and timestamp are not fields too
+	for _, path := range p.TagKeys {
+		remove(values, path)
+	}
+	if p.TimestampPath != "" {
+		remove(values, p.TimestampPath)
+	}
+	fields := make(map[string]interface{})
+	if len(p.FieldPaths) == 0 {
+		flatten(fields, "", values)
+	}
+	for _, path := range p.FieldPaths {
+		flatten(fields, pathName(path), lookup(values, path))
+	}
+
+	metric, err := telegraf.NewMetric(p.MetricName, tags, fields, t)
+	if err != nil {
+		return nil, err
+	}
+	return []telegraf.Metric{metric}, nil
+}
+
+func (p *ProtobufParser) ParseLine(line string) (telegraf.Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) < 1 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"protobuf", line)
+	}
+
+	return metrics[0], nil
+}
+
+func (p *ProtobufParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
+
+// timestamp returns the time stored at the TimestampPath.
+func (p *ProtobufParser) timestamp(v interface{}) (time.Time, error) {
+	unit := timestampUnits[p.TimestampUnit]
+	switch v := v.(type) {
+	case map[string]interface{}:
+		// a google.protobuf.Timestamp
+		seconds, _ := v["seconds"].(int64)
+		nanos, _ := v["nanos"].(int64)
+		return time.Unix(seconds, nanos).UTC(), nil
+	case int64:
+		return time.Unix(0, v*int64(unit)).UTC(), nil
+	}
+	return time.Time{}, fmt.Errorf("protobuf_timestamp_path %s is not a "+
+		"timestamp or an integer", p.TimestampPath)
+}
+
+// lookup returns the value at a path, nil if there is none.
+func lookup(v interface{}, path string) interface{} {
+	for _, key := range strings.Split(path, ".") {
+		switch t := v.(type) {
+		case map[string]interface{}:
+			v = t[key]
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil
+			}
+			v = t[i]
+		default:
+			return nil
+		}
+	}
+	return v
+}
+
+// remove removes the value at a path, if it is in a message or a map.
+func remove(values map[string]interface{}, path string) {
+	i := strings.LastIndex(path, ".")
+	if i < 0 {
+		delete(values, path)
+		return
+	}
+	if parent, ok := lookup(values, path[:i]).(map[string]interface{}); ok {
+		delete(parent, path[i+1:])
+	}
+}
+
+// flatten adds the numbers, booleans and strings of a value to fields, named
+// by their path from the value. Bytes are ignored.
+func flatten(fields map[string]interface{}, name string, v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, v := range t {
+			flatten(fields, joinName(name, k), v)
+		}
+	case []interface{}:
+		for i, v := range t {
+			flatten(fields, joinName(name, strconv.Itoa(i)), v)
+		}
+	case int64, float64, bool, string:
+		fields[name] = t
+	}
+}
+
+func joinName(prefix, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + "_" + name
+}
+
+func pathName(path string) string {
+	return strings.Replace(path, ".", "_", -1)
+}
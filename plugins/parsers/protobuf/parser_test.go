@@ -0,0 +1,268 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+func field(
+	name string,
+	number int32,
+	typ descriptor.FieldDescriptorProto_Type,
+	typeName string,
+	repeated bool,
+) *descriptor.FieldDescriptorProto {
+	label := descriptor.FieldDescriptorProto_LABEL_OPTIONAL
+	if repeated {
+		label = descriptor.FieldDescriptorProto_LABEL_REPEATED
+	}
+	f := &descriptor.FieldDescriptorProto{
+		Name:   proto.String(name),
+		Number: proto.Int32(number),
+		Type:   typ.Enum(),
+		Label:  label.Enum(),
+	}
+	if typeName != "" {
+		f.TypeName = proto.String(typeName)
+	}
+	return f
+}
+
+// The descriptors of:
+//
+//	package sensors;
+//
+//	message Reading {
+//	  enum Status { UNKNOWN = 0; OK = 1; FAILED = 2; }
+//	  message Location { string site = 1; sint32 floor = 2; }
+//	  message Time { int64 seconds = 1; int32 nanos = 2; }
+//
+//	  string device = 1;
+//	  double temperature = 2;
+//	  int64 time_ms = 3;
+//	  repeated float samples = 4 [packed = true];
+//	  Location location = 5;
+//	  map<string, string> labels = 6;
+//	  Status status = 7;
+//	  Time observed = 8;
+//	  bytes raw = 9;
+//	  uint64 count = 10;
+//	}
+var testDescriptors = &descriptor.FileDescriptorSet{
+	File: []*descriptor.FileDescriptorProto{{
+		Name:    proto.String("sensors.proto"),
+		Package: proto.String("sensors"),
+		MessageType: []*descriptor.DescriptorProto{{
+			Name: proto.String("Reading"),
+			Field: []*descriptor.FieldDescriptorProto{
+				field("device", 1, descriptor.FieldDescriptorProto_TYPE_STRING,
+					"", false),
+				field("temperature", 2,
+					descriptor.FieldDescriptorProto_TYPE_DOUBLE, "", false),
+				field("time_ms", 3, descriptor.FieldDescriptorProto_TYPE_INT64,
+					"", false),
+				field("samples", 4, descriptor.FieldDescriptorProto_TYPE_FLOAT,
+					"", true),
+				field("location", 5,
+					descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".sensors.Reading.Location", false),
+				field("labels", 6, descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".sensors.Reading.LabelsEntry", true),
+				field("status", 7, descriptor.FieldDescriptorProto_TYPE_ENUM,
+					".sensors.Reading.Status", false),
+				field("observed", 8,
+					descriptor.FieldDescriptorProto_TYPE_MESSAGE,
+					".sensors.Reading.Time", false),
+				field("raw", 9, descriptor.FieldDescriptorProto_TYPE_BYTES, "",
+					false),
+				field("count", 10, descriptor.FieldDescriptorProto_TYPE_UINT64,
+					"", false),
+			},
+			NestedType: []*descriptor.DescriptorProto{
+				{
+					Name: proto.String("Location"),
+					Field: []*descriptor.FieldDescriptorProto{
+						field("site", 1,
+							descriptor.FieldDescriptorProto_TYPE_STRING, "",
+							false),
+						field("floor", 2,
+							descriptor.FieldDescriptorProto_TYPE_SINT32, "",
+							false),
+					},
+				},
+				{
+					Name: proto.String("LabelsEntry"),
+					Field: []*descriptor.FieldDescriptorProto{
+						field("key", 1,
+							descriptor.FieldDescriptorProto_TYPE_STRING, "",
+							false),
+						field("value", 2,
+							descriptor.FieldDescriptorProto_TYPE_STRING, "",
+							false),
+					},
+					Options: &descriptor.MessageOptions{
+						MapEntry: proto.Bool(true),
+					},
+				},
+				{
+					Name: proto.String("Time"),
+					Field: []*descriptor.FieldDescriptorProto{
+						field("seconds", 1,
+							descriptor.FieldDescriptorProto_TYPE_INT64, "",
+							false),
+						field("nanos", 2,
+							descriptor.FieldDescriptorProto_TYPE_INT32, "",
+							false),
+					},
+				},
+			},
+			EnumType: []*descriptor.EnumDescriptorProto{{
+				Name: proto.String("Status"),
+				Value: []*descriptor.EnumValueDescriptorProto{
+					{Name: proto.String("UNKNOWN"), Number: proto.Int32(0)},
+					{Name: proto.String("OK"), Number: proto.Int32(1)},
+					{Name: proto.String("FAILED"), Number: proto.Int32(2)},
+				},
+			}},
+		}},
+	}},
+}
+
+func testReading() []byte {
+	var samples []byte
+	for _, v := range []float32{1.5, 2.5} {
+		var b [4]byte
+		binary.LittleEndian.PutUint32(b[:], math.Float32bits(v))
+		samples = append(samples, b[:]...)
+	}
+	e := &protowire.Encoder{}
+	e.String(1, "sensor-1")
+	e.Double(2, 21.5)
+	e.Varint(3, 1465839830123)
+	e.Bytes(4, samples)
+	e.Message(5, func(e *protowire.Encoder) {
+		e.String(1, "lab")
+		e.Varint(2, 3) // zigzag -2
+	})
+	e.Message(6, func(e *protowire.Encoder) {
+		e.String(1, "rack")
+		e.String(2, "r1")
+	})
+	e.Varint(7, 2)
+	e.Message(8, func(e *protowire.Encoder) {
+		e.Varint(1, 1465839830)
+		e.Varint(2, 5)
+	})
+	e.Bytes(9, []byte{0xff})
+	e.Varint(10, 42)
+	e.Varint(99, 1) // unknown field
+	return e.Buf
+}
+
+func TestParse(t *testing.T) {
+	p, err := NewProtobufParser(testDescriptors, "sensors.Reading", "sensor",
+		[]string{"device", "labels.rack"}, nil, "time_ms", "ms", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse(testReading())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+
+	m := metrics[0]
+	assert.Equal(t, "sensor", m.Name())
+	assert.Equal(t, map[string]string{
+		"device":      "sensor-1",
+		"labels_rack": "r1",
+	}, m.Tags())
+	assert.Equal(t, map[string]interface{}{
+		"temperature":      21.5,
+		"samples_0":        1.5,
+		"samples_1":        2.5,
+		"location_site":    "lab",
+		"location_floor":   int64(-2),
+		"status":           "FAILED",
+		"observed_seconds": int64(1465839830),
+		"observed_nanos":   int64(5),
+		"count":            int64(42),
+	}, m.Fields())
+	assert.Equal(t, time.Unix(1465839830, 123000000).UTC(), m.Time())
+}
+
+func TestParseFieldPaths(t *testing.T) {
+	p, err := NewProtobufParser(testDescriptors, ".sensors.Reading", "sensor",
+		nil, []string{"temperature", "location"}, "observed", "", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse(testReading())
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, map[string]interface{}{
+		"temperature":    21.5,
+		"location_site":  "lab",
+		"location_floor": int64(-2),
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Unix(1465839830, 5).UTC(), metrics[0].Time())
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewProtobufParser(testDescriptors, "sensors.Reading", "sensor",
+		nil, nil, "", "", nil)
+	require.NoError(t, err)
+
+	_, err = p.Parse(testReading()[:5])
+	assert.Error(t, err)
+	// device as a varint
+	e := &protowire.Encoder{}
+	e.Varint(1, 1)
+	_, err = p.Parse(e.Buf)
+	assert.Error(t, err)
+}
+
+func TestNewProtobufParserInvalid(t *testing.T) {
+	_, err := NewProtobufParser(testDescriptors, "sensors.Unknown", "sensor",
+		nil, nil, "", "", nil)
+	assert.Error(t, err)
+	_, err = NewProtobufParser(testDescriptors, "", "sensor", nil, nil, "",
+		"", nil)
+	assert.Error(t, err)
+	_, err = NewProtobufParser(testDescriptors, "sensors.Reading", "sensor",
+		nil, nil, "time_ms", "h", nil)
+	assert.Error(t, err)
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "protobuf")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	data, err := proto.Marshal(testDescriptors)
+	require.NoError(t, err)
+	path := filepath.Join(dir, "sensors.pb")
+	require.NoError(t, ioutil.WriteFile(path, data, 0644))
+
+	set, err := LoadDescriptorSet(path)
+	require.NoError(t, err)
+	p, err := NewProtobufParser(set, "sensors.Reading", "sensor", nil, nil,
+		"", "", nil)
+	require.NoError(t, err)
+	m, err := p.ParseLine(string(testReading()))
+	require.NoError(t, err)
+	assert.Equal(t, 21.5, m.Fields()["temperature"])
+
+	require.NoError(t, ioutil.WriteFile(path, []byte("invalid"), 0644))
+	_, err = LoadDescriptorSet(path)
+	assert.Error(t, err)
+}
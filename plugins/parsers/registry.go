@@ -3,13 +3,25 @@ package parsers
 import (
 	"fmt"
 
+	"github.com/golang/protobuf/protoc-gen-go/descriptor"
+
 	"github.com/influxdata/telegraf"
 
+	"github.com/influxdata/telegraf/plugins/parsers/binary"
+	"github.com/influxdata/telegraf/plugins/parsers/cbor"
+	"github.com/influxdata/telegraf/plugins/parsers/cobol"
+	"github.com/influxdata/telegraf/plugins/parsers/csv"
 	"github.com/influxdata/telegraf/plugins/parsers/graphite"
 	"github.com/influxdata/telegraf/plugins/parsers/influx"
 	"github.com/influxdata/telegraf/plugins/parsers/json"
+	"github.com/influxdata/telegraf/plugins/parsers/keyvalue"
 	"github.com/influxdata/telegraf/plugins/parsers/nagios"
+	"github.com/influxdata/telegraf/plugins/parsers/parquet"
+	"github.com/influxdata/telegraf/plugins/parsers/protobuf"
+	"github.com/influxdata/telegraf/plugins/parsers/syslog"
 	"github.com/influxdata/telegraf/plugins/parsers/value"
+	"github.com/influxdata/telegraf/plugins/parsers/w3c"
+	"github.com/influxdata/telegraf/plugins/parsers/xml"
 )
 
 // ParserInput is an interface for input plugins that are able to parse
@@ -40,7 +52,8 @@ type Parser interface {
 // Config is a struct that covers the data types needed for all parser types,
 // and can be used to instantiate _any_ of the parsers.
 type Config struct {
-	// Dataformat can be one of: json, influx, graphite, value, nagios
+	// Dataformat can be one of: json, influx, graphite, value, nagios,
+	// protobuf, cbor, parquet, w3c, syslog, xml, binary, csv, cobol, keyvalue
 	DataFormat string
 
 	// Separator only applied to Graphite data.
@@ -48,14 +61,141 @@ type Config struct {
 	// Templates only apply to Graphite data.
 	Templates []string
 
-	// TagKeys only apply to JSON data
+	// TagKeys only apply to JSON, protobuf, CBOR, parquet, W3C, XML, CSV, COBOL and key-value data
 	TagKeys []string
-	// MetricName applies to JSON & value. This will be the name of the measurement.
+	// MetricName applies to JSON, CBOR, parquet, W3C, syslog, XML, binary, CSV, COBOL, key-value & value. This will be the name of the measurement.
 	MetricName string
 
 	// DataType only applies to value, this will be the type to parse value to
 	DataType string
 
+	// InfluxParseErrors sets how malformed InfluxDB line protocol lines are
+	// handled: fail, or recover for one error metric per line. Error metrics
+	// use the InfluxErrorMeasurement measurement, and their payload is
+	// truncated to InfluxErrorPayloadSize bytes.
+	InfluxParseErrors      string
+	InfluxErrorMeasurement string
+	InfluxErrorPayloadSize int
+
+	// ProtobufDescriptorSet is the FileDescriptorSet defining the protobuf
+	// messages. Otherwise ProtobufProtoFiles are the .proto files defining
+	// them, compiled by protoc with the ProtobufImportPaths.
+	ProtobufDescriptorSet string
+	ProtobufProtoFiles    []string
+	ProtobufImportPaths   []string
+	// ProtobufMessageType is the full name of the protobuf message type, such
+	// as sensors.Reading.
+	ProtobufMessageType string
+	// ProtobufFields are the paths of the protobuf fields to keep. All are
+	// kept if empty.
+	ProtobufFields []string
+	// ProtobufTimestampPath is the path of the protobuf time.
+	// ProtobufTimestampUnit is its unit if it is an integer.
+	ProtobufTimestampPath string
+	ProtobufTimestampUnit string
+
+	// CBORQuery is the path to the map, or array of maps, holding the metrics
+	// in CBOR data.
+	CBORQuery string
+	// CBORNameKey is the CBOR map key holding the metric name.
+	CBORNameKey string
+	// CBORTimeKey is the CBOR map key holding the metric time.
+	// CBORTimeFormat is its format: unix, unix_ms, unix_us, unix_ns or a time
+	// layout.
+	CBORTimeKey    string
+	CBORTimeFormat string
+
+	// ParquetFields are the parquet columns that are fields. All columns are
+	// fields if empty.
+	ParquetFields []string
+	// ParquetTimestampColumn is the parquet column holding the time.
+	// ParquetTimestampUnit is its unit if it is an integer.
+	ParquetTimestampColumn string
+	ParquetTimestampUnit   string
+	// ParquetTimeAfter and ParquetTimeBefore are RFC 3339 times that limit
+	// the parquet rows parsed.
+	ParquetTimeAfter  string
+	ParquetTimeBefore string
+
+	// W3CFieldTypes are the W3C log field types, as <field>:<type>.
+	W3CFieldTypes []string
+	// W3CFields are the W3C log entry fields before any #Fields directive.
+	W3CFields []string
+
+	// SyslogFormat is the format of syslog messages: auto, rfc5424 or
+	// rfc3164.
+	SyslogFormat string
+	// SyslogTimezone is the time zone for RFC 3164 syslog timestamps.
+	// SyslogYear is their year, inferred if empty.
+	SyslogTimezone string
+	SyslogYear     string
+
+	// XMLQuery is the path of the metric elements in XML documents.
+	XMLQuery string
+	// XMLTimeKey is the name of the XML value holding the metric time.
+	// XMLTimeFormat is its format: unix, unix_ms, unix_us, unix_ns or a time
+	// layout.
+	XMLTimeKey    string
+	XMLTimeFormat string
+
+	// BinaryEndianness is the byte order of binary data: big or little.
+	BinaryEndianness string
+	// BinaryFields are the fields in the record layout of binary data.
+	BinaryFields []binary.Field
+	// BinaryTimeKey is the name of the binary field holding the metric time.
+	// BinaryTimeFormat is its format: unix, unix_ms, unix_us or unix_ns.
+	BinaryTimeKey    string
+	BinaryTimeFormat string
+
+	// CSVColumnNames are the CSV column names. If empty, the first row is the
+	// header.
+	CSVColumnNames []string
+	// CSVColumnTypes are CSV column types, as <column>:<type>. The other
+	// types are inferred from the first CSVInferRows rows.
+	CSVColumnTypes []string
+	CSVInferRows   int
+	// CSVNullValues are the CSV values that mean no value, as
+	// <column>:<value>, or *:<value> for every column.
+	CSVNullValues []string
+	// CSVDelimiter is the CSV value delimiter.
+	CSVDelimiter string
+	// CSVTimestampColumn is the CSV column holding the time.
+	// CSVTimestampFormat is its format: unix, unix_ms, unix_us, unix_ns or a
+	// time layout.
+	CSVTimestampColumn string
+	CSVTimestampFormat string
+
+	// COBOLCopybook is the path to the COBOL copybook that describes the
+	// records. COBOLRecord is the record name. If empty, the first record is
+	// used.
+	COBOLCopybook string
+	COBOLRecord   string
+	// COBOLEncoding is the COBOL record character encoding: ebcdic or
+	// ascii.
+	COBOLEncoding string
+	// COBOLRecordFormat is the COBOL record format: fixed, or rdw for
+	// variable records with record descriptor words.
+	COBOLRecordFormat string
+	// COBOLTimeKey is the COBOL item holding the metric time.
+	// COBOLTimeFormat is its format: unix, unix_ms, unix_us,
+	// unix_ns or a time layout.
+	COBOLTimeKey    string
+	COBOLTimeFormat string
+
+	// KeyValuePairDelimiter is the key-value pair delimiter, spaces if
+	// empty. KeyValueDelimiter separates keys from values.
+	KeyValuePairDelimiter string
+	KeyValueDelimiter     string
+	// KeyValueQuotes are the key-value quote characters.
+	KeyValueQuotes string
+	// KeyValueTypes are key types for key-value data, as <key>:<type>.
+	KeyValueTypes []string
+	// KeyValueTimeKey is the key holding the time in key-value data.
+	// KeyValueTimeFormat is its format: unix, unix_ms, unix_us, unix_ns or a
+	// time layout.
+	KeyValueTimeKey    string
+	KeyValueTimeFormat string
+
 	// DefaultTags are the default tags that will be added to all parsed metrics.
 	DefaultTags map[string]string
 }
@@ -72,12 +212,40 @@ func NewParser(config *Config) (Parser, error) {
 		parser, err = NewValueParser(config.MetricName,
 			config.DataType, config.DefaultTags)
 	case "influx":
-		parser, err = NewInfluxParser()
+		parser, err = NewInfluxParserConfig(config)
 	case "nagios":
 		parser, err = NewNagiosParser()
 	case "graphite":
 		parser, err = NewGraphiteParser(config.Separator,
 			config.Templates, config.DefaultTags)
+	case "protobuf":
+		parser, err = NewProtobufParser(config)
+	case "cbor":
+		parser, err = NewCBORParser(config.MetricName, config.TagKeys,
+			config.CBORQuery, config.CBORNameKey, config.CBORTimeKey,
+			config.CBORTimeFormat, config.DefaultTags)
+	case "parquet":
+		parser, err = NewParquetParser(config)
+	case "w3c":
+		parser, err = NewW3CParser(config.MetricName, config.TagKeys,
+			config.W3CFieldTypes, config.W3CFields, config.DefaultTags)
+	case "syslog":
+		parser, err = NewSyslogParser(config.MetricName, config.SyslogFormat,
+			config.SyslogTimezone, config.SyslogYear, config.DefaultTags)
+	case "xml":
+		parser, err = NewXMLParser(config.MetricName, config.TagKeys,
+			config.XMLQuery, config.XMLTimeKey, config.XMLTimeFormat,
+			config.DefaultTags)
+	case "binary":
+		parser, err = NewBinaryParser(config.MetricName,
+			config.BinaryEndianness, config.BinaryFields, config.BinaryTimeKey,
+			config.BinaryTimeFormat, config.DefaultTags)
+	case "csv":
+		parser, err = NewCSVParser(config)
+	case "cobol":
+		parser, err = NewCOBOLParser(config)
+	case "keyvalue":
+		parser, err = NewKeyValueParser(config)
 	default:
 		err = fmt.Errorf("Invalid data format: %s", config.DataFormat)
 	}
@@ -105,6 +273,16 @@ func NewInfluxParser() (Parser, error) {
 	return &influx.InfluxParser{}, nil
 }
 
+func NewInfluxParserConfig(config *Config) (Parser, error) {
+	parser, err := influx.NewInfluxParser(config.InfluxParseErrors,
+		config.InfluxErrorMeasurement, config.InfluxErrorPayloadSize)
+	if err != nil {
+		return nil, err
+	}
+	parser.SetDefaultTags(config.DefaultTags)
+	return parser, nil
+}
+
 func NewGraphiteParser(
 	separator string,
 	templates []string,
@@ -124,3 +302,155 @@ func NewValueParser(
 		DefaultTags: defaultTags,
 	}, nil
 }
+
+func NewProtobufParser(config *Config) (Parser, error) {
+	var set *descriptor.FileDescriptorSet
+	var err error
+	switch {
+	case config.ProtobufDescriptorSet != "":
+		set, err = protobuf.LoadDescriptorSet(config.ProtobufDescriptorSet)
+	case len(config.ProtobufProtoFiles) != 0:
+		set, err = protobuf.CompileProtoFiles(config.ProtobufProtoFiles,
+			config.ProtobufImportPaths)
+	default:
+		err = fmt.Errorf("protobuf_descriptor_set or protobuf_proto_files " +
+			"must be set")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return protobuf.NewProtobufParser(set, config.ProtobufMessageType,
+		config.MetricName, config.TagKeys, config.ProtobufFields,
+		config.ProtobufTimestampPath, config.ProtobufTimestampUnit,
+		config.DefaultTags)
+}
+
+func NewCBORParser(
+	metricName string,
+	tagKeys []string,
+	query string,
+	nameKey string,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	parser, err := cbor.NewCBORParser(metricName, tagKeys, query, nameKey,
+		timeKey, timeFormat, defaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewParquetParser(config *Config) (Parser, error) {
+	parser, err := parquet.NewParquetParser(config.MetricName, config.TagKeys,
+		config.ParquetFields, config.ParquetTimestampColumn,
+		config.ParquetTimestampUnit, config.ParquetTimeAfter,
+		config.ParquetTimeBefore, config.DefaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewW3CParser(
+	metricName string,
+	tagKeys []string,
+	fieldTypes []string,
+	fields []string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	parser, err := w3c.NewW3CParser(metricName, tagKeys, fieldTypes, fields,
+		defaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewSyslogParser(
+	metricName string,
+	format string,
+	timezone string,
+	year string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	parser, err := syslog.NewSyslogParser(metricName, format, timezone, year,
+		defaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewXMLParser(
+	metricName string,
+	tagKeys []string,
+	query string,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	parser, err := xml.NewXMLParser(metricName, tagKeys, query, timeKey,
+		timeFormat, defaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewBinaryParser(
+	metricName string,
+	endianness string,
+	fields []binary.Field,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (Parser, error) {
+	parser, err := binary.NewBinaryParser(metricName, endianness, fields,
+		timeKey, timeFormat, defaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewCSVParser(config *Config) (Parser, error) {
+	parser, err := csv.NewCSVParser(config.MetricName, config.TagKeys,
+		config.CSVColumnNames, config.CSVColumnTypes, config.CSVInferRows,
+		config.CSVNullValues, config.CSVDelimiter, config.CSVTimestampColumn,
+		config.CSVTimestampFormat, config.DefaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewCOBOLParser(config *Config) (Parser, error) {
+	if config.COBOLCopybook == "" {
+		return nil, fmt.Errorf("cobol_copybook must be set")
+	}
+	copybook, err := cobol.LoadCopybook(config.COBOLCopybook)
+	if err != nil {
+		return nil, err
+	}
+	parser, err := cobol.NewCOBOLParser(config.MetricName, config.TagKeys,
+		copybook, config.COBOLRecord, config.COBOLEncoding,
+		config.COBOLRecordFormat, config.COBOLTimeKey, config.COBOLTimeFormat,
+		config.DefaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
+
+func NewKeyValueParser(config *Config) (Parser, error) {
+	parser, err := keyvalue.NewKeyValueParser(config.MetricName,
+		config.TagKeys, config.KeyValuePairDelimiter, config.KeyValueDelimiter,
+		config.KeyValueQuotes, config.KeyValueTypes, config.KeyValueTimeKey,
+		config.KeyValueTimeFormat, config.DefaultTags)
+	if err != nil {
+		return nil, err
+	}
+	return parser, nil
+}
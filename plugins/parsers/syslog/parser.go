@@ -0,0 +1,381 @@
+package syslog
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Syslog message formats.
+const (
+	FormatAuto    = "auto"
+	FormatRFC5424 = "rfc5424"
+	FormatRFC3164 = "rfc3164"
+)
+
+var severities = []string{"emerg", "alert", "crit", "err", "warning",
+	"notice", "info", "debug"}
+
+var facilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog",
+	"lpr", "news", "uucp", "cron", "authpriv", "ftp", "ntp", "security",
+	"console", "solaris-cron", "local0", "local1", "local2", "local3",
+	"local4", "local5", "local6", "local7"}
+
+// SyslogParser parses syslog messages, one per line, into metrics. Messages
+// use the RFC 5424 format, or the legacy BSD format from RFC 3164, with or
+// without a priority, as syslog daemons write them to files.
+//
+// The severity, facility, hostname and app name are tags. The other parts of
+// the message are fields. This includes the structured data parameters,
+// named <SD-ID>_<name>.
+type SyslogParser struct {
+	MetricName string
+	// Format is the message format: FormatRFC5424, FormatRFC3164 or
+	// FormatAuto, which detects it from each message.
+	Format string
+	// Location is the time zone for RFC 3164 timestamps.
+	Location *time.Location
+	// Year is the year for RFC 3164 timestamps, which have none. If zero, it
+	// is the current year. For timestamps more than a day in the future it
+	// is the previous year, such as for December logs read in January.
+	Year        int
+	DefaultTags map[string]string
+
+	// now returns the current time, for tests
+	now func() time.Time
+}
+
+func NewSyslogParser(
+	metricName string,
+	format string,
+	timezone string,
+	year string,
+	defaultTags map[string]string,
+) (*SyslogParser, error) {
+	p := &SyslogParser{
+		MetricName:  metricName,
+		Format:      format,
+		DefaultTags: defaultTags,
+		now:         time.Now,
+	}
+	switch format {
+	case "":
+		p.Format = FormatAuto
+	case FormatAuto, FormatRFC5424, FormatRFC3164:
+	default:
+		return nil, fmt.Errorf("invalid syslog_format %q, must be auto, "+
+			"rfc5424 or rfc3164", format)
+	}
+	if timezone == "" {
+		timezone = "Local"
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog_timezone %q, %s", timezone, err)
+	}
+	p.Location = location
+	if year != "" {
+		if p.Year, err = strconv.Atoi(year); err != nil || p.Year < 1 {
+			return nil, fmt.Errorf("invalid syslog_year %q, must be a year",
+				year)
+		}
+	}
+	return p, nil
+}
+
+func (p *SyslogParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, len(buf)+1)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		metric, err := p.ParseLine(line)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, scanner.Err()
+}
+
+func (p *SyslogParser) ParseLine(line string) (telegraf.Metric, error) {
+	m := &message{
+		tags:   make(map[string]string),
+		fields: make(map[string]interface{}),
+		line:   line,
+		rest:   line,
+	}
+	for k, v := range p.DefaultTags {
+		m.tags[k] = v
+	}
+
+	hasPriority := strings.HasPrefix(m.rest, "<")
+	if hasPriority {
+		if err := m.priority(); err != nil {
+			return nil, err
+		}
+	}
+
+	format := p.Format
+	if format == FormatAuto {
+		format = FormatRFC3164
+		i := strings.IndexByte(m.rest, ' ')
+		if hasPriority && i > 0 && i <= 2 && m.rest[0] >= '1' &&
+			m.rest[0] <= '9' {
+			format = FormatRFC5424
+		}
+	}
+
+	var err error
+	if format == FormatRFC5424 {
+		if !hasPriority {
+			return nil, m.errorf("no priority")
+		}
+		err = p.parseRFC5424(m)
+	} else {
+		err = p.parseRFC3164(m)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return telegraf.NewMetric(p.MetricName, m.tags, m.fields, m.time)
+}
+
+// message is a message being parsed. rest is the part not parsed yet.
+type message struct {
+	tags   map[string]string
+	fields map[string]interface{}
+	time   time.Time
+	line   string
+	rest   string
+}
+
+func (m *message) errorf(format string, a ...interface{}) error {
+	return fmt.Errorf("Can not parse the line: %s, for data format: syslog, "+
+		"%s", m.line, fmt.Sprintf(format, a...))
+}
+
+// priority parses the message <PRI> into its severity and facility.
+func (m *message) priority() error {
+	end := strings.IndexByte(m.rest, '>')
+	if end < 2 || end > 4 {
+		return m.errorf("invalid priority")
+	}
+	pri, err := strconv.Atoi(m.rest[1:end])
+	if err != nil || pri > 191 {
+		return m.errorf("invalid priority %s", m.rest[1:end])
+	}
+	m.tags["severity"] = severities[pri%8]
+	m.tags["facility"] = facilities[pri/8]
+	m.fields["severity_code"] = int64(pri % 8)
+	m.fields["facility_code"] = int64(pri / 8)
+	m.rest = m.rest[end+1:]
+	return nil
+}
+
+// token returns the next part of the message, up to a space, and the rest
+// of the message after it.
+func (m *message) token() string {
+	i := strings.IndexByte(m.rest, ' ')
+	if i < 0 {
+		token := m.rest
+		m.rest = ""
+		return token
+	}
+	token := m.rest[:i]
+	m.rest = m.rest[i+1:]
+	return token
+}
+
+// parseRFC5424 parses the message after its priority. The format is
+// VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA [MSG].
+func (p *SyslogParser) parseRFC5424(m *message) error {
+	version, err := strconv.Atoi(m.token())
+	if err != nil {
+		return m.errorf("invalid version")
+	}
+	m.fields["version"] = int64(version)
+
+	m.time = p.now().UTC()
+	if timestamp := m.token(); timestamp != "-" {
+		m.time, err = time.Parse(time.RFC3339Nano, timestamp)
+		if err != nil {
+			return m.errorf("invalid timestamp %s", timestamp)
+		}
+	}
+
+	for _, part := range []struct {
+		name string
+		tag  bool
+	}{
+		{"hostname", true},
+		{"appname", true},
+		{"procid", false},
+		{"msgid", false},
+	} {
+		token := m.token()
+		if token == "" {
+			return m.errorf("no %s", part.name)
+		}
+		if token == "-" {
+			continue
+		}
+		if part.tag {
+			m.tags[part.name] = token
+		} else {
+			m.fields[part.name] = token
+		}
+	}
+
+	if err := m.structuredData(); err != nil {
+		return err
+	}
+	if strings.HasPrefix(m.rest, " ") {
+		// the message, which can start with a UTF-8 byte order mark
+		msg := strings.TrimPrefix(m.rest[1:], "\ufeff")
+		if msg != "" {
+			m.fields["message"] = msg
+		}
+	} else if m.rest != "" {
+		return m.errorf("invalid structured data")
+	}
+	return nil
+}
+
+// structuredData parses the structured data of an RFC 5424 message into
+// fields. It is "-" or elements [SD-ID name="value" ...].
+func (m *message) structuredData() error {
+	if strings.HasPrefix(m.rest, "-") {
+		m.rest = m.rest[1:]
+		return nil
+	}
+	if !strings.HasPrefix(m.rest, "[") {
+		return m.errorf("no structured data")
+	}
+	for strings.HasPrefix(m.rest, "[") {
+		m.rest = m.rest[1:]
+		end := strings.IndexAny(m.rest, " ]")
+		if end < 1 {
+			return m.errorf("invalid structured data")
+		}
+		id := m.rest[:end]
+		m.rest = m.rest[end:]
+		for strings.HasPrefix(m.rest, " ") {
+			m.rest = m.rest[1:]
+			eq := strings.Index(m.rest, "=\"")
+			if eq < 1 {
+				return m.errorf("invalid structured data in %s", id)
+			}
+			name := m.rest[:eq]
+			m.rest = m.rest[eq+2:]
+			var value []byte
+			closed := false
+			for i := 0; i < len(m.rest); i++ {
+				c := m.rest[i]
+				if c == '\\' && i+1 < len(m.rest) &&
+					strings.IndexByte(`"\]`, m.rest[i+1]) >= 0 {
+					value = append(value, m.rest[i+1])
+					i++
+					continue
+				}
+				if c == '"' {
+					m.rest = m.rest[i+1:]
+					closed = true
+					break
+				}
+				value = append(value, c)
+			}
+			if !closed {
+				return m.errorf("unterminated value for %s in %s", name, id)
+			}
+			m.fields[id+"_"+name] = string(value)
+		}
+		if !strings.HasPrefix(m.rest, "]") {
+			return m.errorf("invalid structured data in %s", id)
+		}
+		m.rest = m.rest[1:]
+	}
+	return nil
+}
+
+// parseRFC3164 parses the message after its priority, if it has one. The
+// format is TIMESTAMP HOSTNAME TAG[PID]: MSG. The hostname can be missing,
+// and a message without a timestamp has no hostname either.
+func (p *SyslogParser) parseRFC3164(m *message) error {
+	const layout = "Jan _2 15:04:05"
+	now := p.now().In(p.Location)
+	m.time = now
+	hasTime := false
+	if len(m.rest) >= len(layout) {
+		t, err := time.ParseInLocation(layout, m.rest[:len(layout)],
+			p.Location)
+		if err == nil {
+			year := p.Year
+			if year == 0 {
+				year = now.Year()
+			}
+			m.time = time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(),
+				t.Second(), 0, p.Location)
+			if p.Year == 0 && m.time.After(now.Add(24*time.Hour)) {
+				m.time = m.time.AddDate(-1, 0, 0)
+			}
+			m.rest = strings.TrimPrefix(m.rest[len(layout):], " ")
+			hasTime = true
+		}
+	}
+
+	// the hostname is the first word, unless it is the tag
+	if hasTime {
+		i := strings.IndexByte(m.rest, ' ')
+		if i > 0 && strings.IndexAny(m.rest[:i], ":[") < 0 {
+			m.tags["hostname"] = m.rest[:i]
+			m.rest = m.rest[i+1:]
+		}
+	}
+
+	// the tag is the app name, and a pid, followed by a colon
+	end := 0
+	for end < len(m.rest) && end < 48 && strings.IndexByte(":[ ",
+		m.rest[end]) < 0 {
+		end++
+	}
+	if end > 0 && end < len(m.rest) && m.rest[end] != ' ' {
+		appname := m.rest[:end]
+		rest := m.rest[end:]
+		var procid string
+		if rest[0] == '[' {
+			if j := strings.IndexByte(rest, ']'); j > 1 {
+				procid, rest = rest[1:j], rest[j+1:]
+			} else {
+				rest = ""
+			}
+		}
+		if strings.HasPrefix(rest, ":") {
+			m.tags["appname"] = appname
+			if procid != "" {
+				m.fields["procid"] = procid
+			}
+			m.rest = strings.TrimPrefix(rest[1:], " ")
+		}
+	}
+
+	if m.rest != "" {
+		m.fields["message"] = m.rest
+	}
+	if len(m.fields) == 0 {
+		return m.errorf("no message")
+	}
+	return nil
+}
+
+func (p *SyslogParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
@@ -0,0 +1,146 @@
+package syslog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newParser(t *testing.T, format, timezone, year string) *SyslogParser {
+	p, err := NewSyslogParser("syslog", format, timezone, year, nil)
+	require.NoError(t, err)
+	p.now = func() time.Time {
+		return time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC)
+	}
+	return p
+}
+
+func TestParseRFC5424(t *testing.T) {
+	p := newParser(t, "", "UTC", "")
+	m, err := p.ParseLine(`<165>1 2016-06-13T17:43:50.123+02:00 web01 ` +
+		`myapp 1234 ID47 [exampleSDID@32473 iut="3" ` +
+		`eventSource="App\"lication\]"][origin ip="10.0.0.1"] ` +
+		"\ufeffAn application event")
+	require.NoError(t, err)
+
+	assert.Equal(t, "syslog", m.Name())
+	assert.Equal(t, map[string]string{
+		"severity": "notice",
+		"facility": "local4",
+		"hostname": "web01",
+		"appname":  "myapp",
+	}, m.Tags())
+	assert.Equal(t, map[string]interface{}{
+		"version":                       int64(1),
+		"severity_code":                 int64(5),
+		"facility_code":                 int64(20),
+		"procid":                        "1234",
+		"msgid":                         "ID47",
+		"exampleSDID@32473_iut":         "3",
+		"exampleSDID@32473_eventSource": `App"lication]`,
+		"origin_ip":                     "10.0.0.1",
+		"message":                       "An application event",
+	}, m.Fields())
+	assert.Equal(t, time.Date(2016, 6, 13, 15, 43, 50, 123e6, time.UTC),
+		m.Time().UTC())
+}
+
+func TestParseRFC5424Nil(t *testing.T) {
+	p := newParser(t, FormatRFC5424, "", "")
+	m, err := p.ParseLine("<13>1 - - - - - -")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"severity": "notice",
+		"facility": "user",
+	}, m.Tags())
+	assert.Equal(t, map[string]interface{}{
+		"version":       int64(1),
+		"severity_code": int64(5),
+		"facility_code": int64(1),
+	}, m.Fields())
+	assert.Equal(t, p.now(), m.Time())
+}
+
+func TestParseRFC3164(t *testing.T) {
+	p := newParser(t, "", "America/New_York", "")
+	metrics, err := p.Parse([]byte(
+		"<34>Jan  2 00:04:05 mymachine su: 'su root' failed on /dev/pts/8\n" +
+			"Dec 31 23:59:59 web01 sshd[4321]: Accepted publickey\n" +
+			"<13>a message without a timestamp\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 3)
+
+	ny, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	assert.Equal(t, map[string]string{
+		"severity": "crit",
+		"facility": "auth",
+		"hostname": "mymachine",
+		"appname":  "su",
+	}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"severity_code": int64(2),
+		"facility_code": int64(4),
+		"message":       "'su root' failed on /dev/pts/8",
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Date(2016, 1, 2, 0, 4, 5, 0, ny).UnixNano(),
+		metrics[0].UnixNano())
+
+	// December logs are from the previous year
+	assert.Equal(t, map[string]string{
+		"hostname": "web01",
+		"appname":  "sshd",
+	}, metrics[1].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"procid":  "4321",
+		"message": "Accepted publickey",
+	}, metrics[1].Fields())
+	assert.Equal(t, time.Date(2015, 12, 31, 23, 59, 59, 0, ny).UnixNano(),
+		metrics[1].UnixNano())
+
+	assert.Equal(t, "a message without a timestamp",
+		metrics[2].Fields()["message"])
+	assert.Equal(t, p.now().UnixNano(), metrics[2].UnixNano())
+}
+
+func TestParseRFC3164Year(t *testing.T) {
+	p := newParser(t, FormatRFC3164, "UTC", "2014")
+	m, err := p.ParseLine("Dec 31 23:59:59 web01 kernel: oops")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2014, 12, 31, 23, 59, 59, 0, time.UTC),
+		m.Time())
+}
+
+func TestParseInvalid(t *testing.T) {
+	p := newParser(t, "", "UTC", "")
+	for _, line := range []string{
+		"<192>1 - - - - - -",
+		"<abc>message",
+		"<13>1 yesterday - - - - -",
+		"<13>1 - - - - - [id",
+		`<13>1 - - - - - [id a="b]`,
+		"<13>1 - - - - -x",
+	} {
+		_, err := p.ParseLine(line)
+		assert.Error(t, err, line)
+	}
+
+	p = newParser(t, FormatRFC5424, "UTC", "")
+	_, err := p.ParseLine("Jan  2 00:04:05 mymachine su: message")
+	assert.Error(t, err)
+}
+
+func TestNewSyslogParserInvalid(t *testing.T) {
+	for _, options := range [][]string{
+		{"rfc3339", "", ""},
+		{"", "Mars/Olympus_Mons", ""},
+		{"", "", "last"},
+	} {
+		_, err := NewSyslogParser("syslog", options[0], options[1],
+			options[2], nil)
+		assert.Error(t, err, "%v", options)
+	}
+}
@@ -0,0 +1,280 @@
+package w3c
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Entry field value types.
+const (
+	TypeInt    = "int"
+	TypeFloat  = "float"
+	TypeBool   = "bool"
+	TypeString = "string"
+)
+
+// W3CParser parses logs in the W3C extended log file format, such as IIS
+// logs, into one metric per entry.
+//
+// The #Fields directive names the entry fields, and a new directive changes
+// them for the entries that follow it. The metric time comes from the date
+// and time fields. If there is only a time field, the date comes from the
+// #Date directive. Every other field is a tag or a field. A "-" means no
+// value.
+//
+// When logs are parsed line by line, the parser keeps the fields of the last
+// directive between calls.
+type W3CParser struct {
+	MetricName string
+	// TagKeys are the entry fields that are tags.
+	TagKeys []string
+	// FieldTypes are the entry field types: TypeInt, TypeFloat, TypeBool or
+	// TypeString. Other fields get their type from their values: int, float,
+	// or else string.
+	FieldTypes map[string]string
+	// Fields are the entry fields before any #Fields directive, for logs
+	// tailed from the middle.
+	Fields      []string
+	DefaultTags map[string]string
+
+	mu sync.Mutex
+	// fields are the fields in the last #Fields directive
+	fields []string
+	// date is the date in the last #Date directive
+	date string
+}
+
+func NewW3CParser(
+	metricName string,
+	tagKeys []string,
+	fieldTypes []string,
+	fields []string,
+	defaultTags map[string]string,
+) (*W3CParser, error) {
+	p := &W3CParser{
+		MetricName:  metricName,
+		TagKeys:     tagKeys,
+		FieldTypes:  make(map[string]string),
+		Fields:      fields,
+		DefaultTags: defaultTags,
+	}
+	for _, entry := range fieldTypes {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid w3c_field_types entry %q, must "+
+				"be <field>:<type>", entry)
+		}
+		name, typ := entry[:i], entry[i+1:]
+		switch typ {
+		case TypeInt, TypeFloat, TypeBool, TypeString:
+		default:
+			return nil, fmt.Errorf("invalid type %q in w3c_field_types entry "+
+				"%q, must be int, float, bool or string", typ, entry)
+		}
+		p.FieldTypes[name] = typ
+	}
+	return p, nil
+}
+
+func (p *W3CParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(buf))
+	scanner.Buffer(nil, len(buf)+1)
+	for scanner.Scan() {
+		metric, err := p.ParseLine(scanner.Text())
+		if err != nil {
+			return nil, err
+		}
+		if metric != nil {
+			metrics = append(metrics, metric)
+		}
+	}
+	return metrics, scanner.Err()
+}
+
+// ParseLine parses a log line. For directives and blank lines it returns no
+// metric and no error.
+func (p *W3CParser) ParseLine(line string) (telegraf.Metric, error) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return nil, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if strings.HasPrefix(line, "#") {
+		p.directive(line[1:])
+		return nil, nil
+	}
+
+	fields := p.fields
+	if fields == nil {
+		fields = p.Fields
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"w3c, no #Fields directive before it", line)
+	}
+	values := split(line)
+	if len(values) != len(fields) {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"w3c, %d values for %d fields", line, len(values), len(fields))
+	}
+
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	entry := make(map[string]interface{})
+	var date, clock string
+	for i, name := range fields {
+		value := values[i]
+		switch {
+		case name == "date":
+			date = value
+		case name == "time":
+			clock = value
+		case value == "-":
+		case p.isTag(name):
+			tags[name] = value
+		default:
+			v, err := p.convert(name, value)
+			if err != nil {
+				return nil, err
+			}
+			entry[name] = v
+		}
+	}
+	if len(entry) == 0 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"w3c, no fields", line)
+	}
+
+	t := time.Now().UTC()
+	if clock != "" {
+		if date == "" {
+			date = p.date
+		}
+		var err error
+		t, err = time.Parse("2006-01-02 15:04:05", date+" "+clock)
+		if err != nil {
+			return nil, fmt.Errorf("invalid date and time %s %s in the line: "+
+				"%s", date, clock, line)
+		}
+	}
+
+	return telegraf.NewMetric(p.MetricName, tags, entry, t)
+}
+
+// directive handles a directive, the line without its "#".
+func (p *W3CParser) directive(line string) {
+	i := strings.Index(line, ":")
+	if i < 0 {
+		return
+	}
+	value := strings.TrimSpace(line[i+1:])
+	switch line[:i] {
+	case "Fields":
+		p.fields = strings.Fields(value)
+	case "Date":
+		// such as 2016-06-13 17:43:50, where the time is when the directive
+		// was written, not the entry time
+		if fields := strings.Fields(value); len(fields) != 0 {
+			p.date = fields[0]
+		}
+	}
+}
+
+func (p *W3CParser) isTag(name string) bool {
+	for _, key := range p.TagKeys {
+		if key == name {
+			return true
+		}
+	}
+	return false
+}
+
+// convert converts a field value to the field type.
+func (p *W3CParser) convert(name, value string) (interface{}, error) {
+	typ, ok := p.FieldTypes[name]
+	if !ok {
+		if v, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return v, nil
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			return v, nil
+		}
+		return value, nil
+	}
+
+	var v interface{}
+	var err error
+	switch typ {
+	case TypeInt:
+		v, err = strconv.ParseInt(value, 10, 64)
+	case TypeFloat:
+		v, err = strconv.ParseFloat(value, 64)
+	case TypeBool:
+		v, err = strconv.ParseBool(value)
+	default:
+		v = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid value %q for field %s, not a(n) %s",
+			value, name, typ)
+	}
+	return v, nil
+}
+
+// split splits an entry into its values, separated by spaces or tabs. A
+// value can be quoted, with quotes in it doubled.
+func split(line string) []string {
+	var values []string
+	for {
+		line = strings.TrimLeft(line, " \t")
+		if line == "" {
+			return values
+		}
+		if line[0] != '"' {
+			i := strings.IndexAny(line, " \t")
+			if i < 0 {
+				i = len(line)
+			}
+			values = append(values, line[:i])
+			line = line[i:]
+			continue
+		}
+
+		var value []byte
+		i := 1
+		for ; i < len(line); i++ {
+			if line[i] != '"' {
+				value = append(value, line[i])
+				continue
+			}
+			if i+1 < len(line) && line[i+1] == '"' {
+				value = append(value, '"')
+				i++
+				continue
+			}
+			break
+		}
+		values = append(values, string(value))
+		if i < len(line) {
+			i++
+		}
+		line = line[i:]
+	}
+}
+
+func (p *W3CParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
@@ -0,0 +1,123 @@
+package w3c
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const iisLog = `#Software: Microsoft Internet Information Services 10.0
+#Version: 1.0
+#Date: 2016-06-13 17:43:00
+#Fields: date time s-ip cs-method cs-uri-stem sc-status sc-bytes time-taken
+2016-06-13 17:43:50 10.0.0.1 GET /index.html 200 5120 15
+2016-06-13 17:43:51.250 10.0.0.1 POST /login - 1024 3.5
+`
+
+func TestParse(t *testing.T) {
+	p, err := NewW3CParser("iis", []string{"cs-method", "cs-uri-stem"}, nil,
+		nil, map[string]string{"host": "web01"})
+	require.NoError(t, err)
+
+	metrics, err := p.Parse([]byte(iisLog))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "iis", metrics[0].Name())
+	assert.Equal(t, map[string]string{
+		"host":        "web01",
+		"cs-method":   "GET",
+		"cs-uri-stem": "/index.html",
+	}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"s-ip":       "10.0.0.1",
+		"sc-status":  int64(200),
+		"sc-bytes":   int64(5120),
+		"time-taken": int64(15),
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC),
+		metrics[0].Time())
+
+	assert.Equal(t, map[string]interface{}{
+		"s-ip":       "10.0.0.1",
+		"sc-bytes":   int64(1024),
+		"time-taken": 3.5,
+	}, metrics[1].Fields())
+	assert.Equal(t, time.Date(2016, 6, 13, 17, 43, 51, 250e6, time.UTC),
+		metrics[1].Time())
+}
+
+func TestParseFieldTypes(t *testing.T) {
+	p, err := NewW3CParser("iis", nil, []string{"sc-status:string",
+		"time-taken:float", "cached:bool"}, nil, nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse([]byte("#Fields: sc-status time-taken cached\n" +
+		"200 15 true\n"))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, map[string]interface{}{
+		"sc-status":  "200",
+		"time-taken": 15.0,
+		"cached":     true,
+	}, metrics[0].Fields())
+
+	_, err = p.Parse([]byte("404 slow true\n"))
+	assert.Error(t, err)
+}
+
+// Verify that a #Fields directive changes the fields of the entries that
+// follow it, also when later lines are parsed one at a time.
+func TestParseLineSchemaChange(t *testing.T) {
+	p, err := NewW3CParser("cdn", nil, nil, nil, nil)
+	require.NoError(t, err)
+
+	_, err = p.ParseLine("200 512")
+	assert.Error(t, err)
+
+	for _, line := range []string{"#Fields: time sc-status sc-bytes",
+		"#Date: 2016-06-13 00:00:00"} {
+		m, err := p.ParseLine(line)
+		require.NoError(t, err)
+		assert.Nil(t, m)
+	}
+	m, err := p.ParseLine("17:43:50 200 512")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"sc-status": int64(200),
+		"sc-bytes":  int64(512),
+	}, m.Fields())
+	assert.Equal(t, time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC), m.Time())
+
+	_, err = p.ParseLine("#Fields: cs(User-Agent)\tsc-bytes")
+	require.NoError(t, err)
+	m, err = p.ParseLine("\"Mozilla/5.0 (X11; \"\"Linux\"\")\"\t1024")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{
+		"cs(User-Agent)": `Mozilla/5.0 (X11; "Linux")`,
+		"sc-bytes":       int64(1024),
+	}, m.Fields())
+
+	_, err = p.ParseLine("1024")
+	assert.Error(t, err)
+}
+
+// Verify that the default fields are used for logs tailed from the middle,
+// after their #Fields directive.
+func TestParseLineDefaultFields(t *testing.T) {
+	p, err := NewW3CParser("iis", nil, nil, []string{"sc-status"}, nil)
+	require.NoError(t, err)
+	m, err := p.ParseLine("200")
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"sc-status": int64(200)},
+		m.Fields())
+}
+
+func TestNewW3CParserInvalid(t *testing.T) {
+	for _, types := range [][]string{{"sc-status"}, {"sc-status:integer"}} {
+		_, err := NewW3CParser("iis", nil, types, nil, nil)
+		assert.Error(t, err, "%v", types)
+	}
+}
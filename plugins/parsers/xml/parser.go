@@ -0,0 +1,274 @@
+package xml
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
+// XMLParser parses XML documents into metrics, one for each element that
+// matches a path. The document is streamed, and only the element being
+// parsed is kept in memory. So documents of hundreds of megabytes, like bulk
+// exports from telecom and industrial equipment, can be parsed.
+//
+// The metric values are the attributes of the element and the texts of its
+// descendants. They are named by their path from the element, joined by
+// underscores, such as "voltage" or "phase_current" for
+// <reading><phase><current>1.2</current></phase></reading>. Numeric values
+// are fields, and other values are ignored unless they are tags.
+type XMLParser struct {
+	MetricName string
+	// TagKeys are the names of the values to use as tags.
+	TagKeys []string
+	// Query is the path of the metric elements, in a subset of XPath. Steps
+	// are element names, or "*", separated by "/", or by "//" for
+	// descendants. A step can have an attribute predicate, such as
+	// "//device[@type='meter']/reading".
+	Query string
+	// TimeKey is the name of the value holding the metric time. It is an
+	// epoch in TimeFormat, or a string in its layout. If empty, the parse
+	// time is used.
+	TimeKey string
+	// TimeFormat is the format of TimeKey: unix (if empty), unix_ms, unix_us,
+	// unix_ns, or a Go time layout such as 2006-01-02T15:04:05Z07:00.
+	TimeFormat  string
+	DefaultTags map[string]string
+
+	steps []step
+}
+
+func NewXMLParser(
+	metricName string,
+	tagKeys []string,
+	query string,
+	timeKey string,
+	timeFormat string,
+	defaultTags map[string]string,
+) (*XMLParser, error) {
+	steps, err := parsePath(query)
+	if err != nil {
+		return nil, fmt.Errorf("invalid xml_query %q, %s", query, err)
+	}
+	if _, ok := epochUnits[timeFormat]; !ok &&
+		strings.HasPrefix(timeFormat, "unix") {
+		return nil, fmt.Errorf("invalid xml_time_format %q, must be unix, "+
+			"unix_ms, unix_us, unix_ns or a time layout", timeFormat)
+	}
+	return &XMLParser{
+		MetricName:  metricName,
+		TagKeys:     tagKeys,
+		Query:       query,
+		TimeKey:     timeKey,
+		TimeFormat:  timeFormat,
+		DefaultTags: defaultTags,
+		steps:       steps,
+	}, nil
+}
+
+func (p *XMLParser) Parse(buf []byte) ([]telegraf.Metric, error) {
+	metrics := make([]telegraf.Metric, 0)
+	err := p.ParseReader(bytes.NewReader(buf),
+		func(metric telegraf.Metric) error {
+			metrics = append(metrics, metric)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// ParseReader parses a document read from r. It passes the metric for each
+// element matching the query to fn as soon as the element ends. It stops at
+// the first error fn returns.
+func (p *XMLParser) ParseReader(
+	r io.Reader,
+	fn func(telegraf.Metric) error,
+) error {
+	d := xml.NewDecoder(r)
+	// path is the path of the current element, outside metric elements
+	var path []*element
+	// values are the values of the metric element being parsed. names and
+	// texts hold the names and texts of its current descendants
+	var values map[string]string
+	var names []string
+	var texts []*bytes.Buffer
+	root := false
+
+	for {
+		token, err := d.Token()
+		if err == io.EOF {
+			if !root {
+				return fmt.Errorf("unable to parse out as XML, no element")
+			}
+			if len(path) != 0 || values != nil {
+				return fmt.Errorf("unable to parse out as XML, unexpected EOF")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to parse out as XML, %s", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			root = true
+			if values == nil {
+				e := &element{
+					name:       t.Name.Local,
+					attributes: make(map[string]string),
+				}
+				for _, attr := range t.Attr {
+					e.attributes[attr.Name.Local] = attr.Value
+				}
+				path = append(path, e)
+				if !match(p.steps, path) {
+					continue
+				}
+				values = make(map[string]string)
+				names = []string{t.Name.Local}
+				texts = []*bytes.Buffer{{}}
+				for _, attr := range t.Attr {
+					values[attr.Name.Local] = attr.Value
+				}
+				continue
+			}
+
+			name := t.Name.Local
+			if len(names) > 1 {
+				name = names[len(names)-1] + "_" + name
+			}
+			names = append(names, name)
+			texts = append(texts, &bytes.Buffer{})
+			for _, attr := range t.Attr {
+				values[name+"_"+attr.Name.Local] = attr.Value
+			}
+
+		case xml.CharData:
+			if values != nil {
+				texts[len(texts)-1].Write(t)
+			}
+
+		case xml.EndElement:
+			if values == nil {
+				path = path[:len(path)-1]
+				continue
+			}
+			text := strings.TrimSpace(texts[len(texts)-1].String())
+			if text != "" {
+				values[names[len(names)-1]] = text
+			}
+			names = names[:len(names)-1]
+			texts = texts[:len(texts)-1]
+			if len(names) != 0 {
+				continue
+			}
+
+			metric, err := p.metric(values)
+			if err != nil {
+				return err
+			}
+			if metric != nil {
+				if err := fn(metric); err != nil {
+					return err
+				}
+			}
+			values = nil
+			path = path[:len(path)-1]
+		}
+	}
+}
+
+// metric builds a metric from the values of an element. It returns nil if
+// there are no fields.
+func (p *XMLParser) metric(values map[string]string) (telegraf.Metric, error) {
+	tags := make(map[string]string)
+	for k, v := range p.DefaultTags {
+		tags[k] = v
+	}
+	for _, tag := range p.TagKeys {
+		if v, ok := values[tag]; ok {
+			tags[tag] = v
+			delete(values, tag)
+		}
+	}
+
+	t := time.Now().UTC()
+	if p.TimeKey != "" {
+		var err error
+		if t, err = p.timestamp(values[p.TimeKey]); err != nil {
+			return nil, err
+		}
+		delete(values, p.TimeKey)
+	}
+
+	fields := make(map[string]interface{})
+	for k, v := range values {
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			fields[k] = i
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil {
+			fields[k] = f
+		}
+	}
+	if len(fields) == 0 {
+		return nil, nil
+	}
+	return telegraf.NewMetric(p.MetricName, tags, fields, t)
+}
+
+// timestamp parses the value of the TimeKey into a time.
+func (p *XMLParser) timestamp(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, fmt.Errorf("xml_time_key %s not found", p.TimeKey)
+	}
+	unit, epoch := epochUnits[p.TimeFormat]
+	if !epoch {
+		t, err := time.Parse(p.TimeFormat, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("xml_time_key %s: %s", p.TimeKey,
+				err)
+		}
+		return t.UTC(), nil
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("xml_time_key %s is %q, not an epoch",
+			p.TimeKey, v)
+	}
+	if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return time.Unix(0, i*int64(unit)).UTC(), nil
+	}
+	return time.Unix(0, int64(f*float64(unit))).UTC(), nil
+}
+
+func (p *XMLParser) ParseLine(line string) (telegraf.Metric, error) {
+	metrics, err := p.Parse([]byte(line))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(metrics) < 1 {
+		return nil, fmt.Errorf("Can not parse the line: %s, for data format: "+
+			"xml", line)
+	}
+
+	return metrics[0], nil
+}
+
+func (p *XMLParser) SetDefaultTags(tags map[string]string) {
+	p.DefaultTags = tags
+}
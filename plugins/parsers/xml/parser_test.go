@@ -0,0 +1,161 @@
+package xml
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+const export = `<?xml version="1.0" encoding="UTF-8"?>
+<export>
+  <site name="plant-1">
+    <device id="m1" type="meter">
+      <reading time="1465839830">
+        <voltage>230.5</voltage>
+        <phase n="1"><current>1.2</current></phase>
+        <status>ok</status>
+      </reading>
+    </device>
+    <device id="s1" type="sensor">
+      <reading time="1465839831"><temperature>21</temperature></reading>
+    </device>
+  </site>
+</export>`
+
+func TestParse(t *testing.T) {
+	p, err := NewXMLParser("xml", []string{"status"},
+		"//device[@type='meter']/reading", "time", "", nil)
+	require.NoError(t, err)
+
+	metrics, err := p.Parse([]byte(export))
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, map[string]string{"status": "ok"}, metrics[0].Tags())
+	assert.Equal(t, map[string]interface{}{
+		"voltage":       230.5,
+		"phase_n":       int64(1),
+		"phase_current": 1.2,
+	}, metrics[0].Fields())
+	assert.Equal(t, time.Unix(1465839830, 0).UTC(), metrics[0].Time())
+}
+
+func TestParseQueries(t *testing.T) {
+	for query, expected := range map[string]int{
+		"/export/site/device/reading": 2,
+		"//reading":                   2,
+		"reading":                     2,
+		"site/*/reading":              2,
+		"/site/device/reading":        0,
+		"//device[@id]":               2,
+		`//device[@id="s1"]`:          1,
+		"//device[@type='sensor']":    1,
+		"//export":                    1,
+	} {
+		p, err := NewXMLParser("xml", nil, query, "", "", nil)
+		require.NoError(t, err, query)
+		metrics, err := p.Parse([]byte(export))
+		require.NoError(t, err, query)
+		assert.Len(t, metrics, expected, query)
+	}
+}
+
+// reader is a document with many elements, generated as it is read.
+type reader struct {
+	n, count int
+	buf      []byte
+}
+
+func (r *reader) Read(b []byte) (int, error) {
+	for len(r.buf) == 0 {
+		switch {
+		case r.n == 0:
+			r.buf = []byte("<readings>")
+		case r.n <= r.count:
+			r.buf = []byte(fmt.Sprintf("<r><value>%d</value></r>", r.n))
+		case r.n == r.count+1:
+			r.buf = []byte("</readings>")
+		default:
+			return 0, io.EOF
+		}
+		r.n++
+	}
+	n := copy(b, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// Verify that metrics are passed as their elements end,
+// without the document being read first.
+func TestParseReader(t *testing.T) {
+	p, err := NewXMLParser("xml", nil, "/readings/r", "", "", nil)
+	require.NoError(t, err)
+
+	r := &reader{count: 100000}
+	n := int64(0)
+	err = p.ParseReader(r, func(m telegraf.Metric) error {
+		n++
+		assert.Equal(t, n, m.Fields()["value"])
+		// a few elements at most are read ahead
+		assert.True(t, r.n-int(n) < 1000)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int64(100000), n)
+
+	stop := fmt.Errorf("stop")
+	err = p.ParseReader(&reader{count: 10}, func(telegraf.Metric) error {
+		return stop
+	})
+	assert.Equal(t, stop, err)
+}
+
+func TestParseTimeFormat(t *testing.T) {
+	p, err := NewXMLParser("xml", nil, "//r", "t", "2006-01-02T15:04:05Z07:00",
+		nil)
+	require.NoError(t, err)
+	m, err := p.ParseLine(`<r t="2016-06-13T17:43:50Z"><v>1</v></r>`)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2016, 6, 13, 17, 43, 50, 0, time.UTC), m.Time())
+
+	p, err = NewXMLParser("xml", nil, "//r", "t", "unix_ms", nil)
+	require.NoError(t, err)
+	m, err = p.ParseLine(`<r><t>1465839830123</t><v>1</v></r>`)
+	require.NoError(t, err)
+	assert.Equal(t, time.Unix(1465839830, 123e6).UTC(), m.Time())
+
+	_, err = p.ParseLine(`<r><v>1</v></r>`)
+	assert.Error(t, err)
+}
+
+func TestParseInvalid(t *testing.T) {
+	p, err := NewXMLParser("xml", nil, "//r", "", "", nil)
+	require.NoError(t, err)
+	for _, doc := range []string{
+		"<a><r><v>1</v></r>",
+		"<a><r><v>1</r></a>",
+		"not xml",
+	} {
+		_, err := p.Parse([]byte(doc))
+		assert.Error(t, err, doc)
+	}
+	_, err = p.ParseLine("<a><b>1</b></a>")
+	assert.Error(t, err)
+}
+
+func TestNewXMLParserInvalid(t *testing.T) {
+	for _, query := range []string{"", "/", "//a[", "//a[b]", "//a[@b=c]",
+		"//a[@]", "a//[@b]"} {
+		_, err := NewXMLParser("xml", nil, query, "", "", nil)
+		assert.Error(t, err, query)
+	}
+	_, err := NewXMLParser("xml", nil, "//a", "t", "unix_s", nil)
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "xml_time_format"))
+}
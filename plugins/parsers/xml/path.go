@@ -0,0 +1,133 @@
+package xml
+
+import (
+	"fmt"
+	"strings"
+)
+
+// step is a path step. It matches an element by name, or any name, and
+// optionally by an attribute and its value.
+type step struct {
+	// descendant is true if the element can be any descendant of the
+	// previous step's element, not only a child
+	descendant bool
+	name       string
+	attribute  string
+	value      *string
+}
+
+// element is one element on the path to the current element of a document.
+type element struct {
+	name       string
+	attributes map[string]string
+}
+
+// parsePath parses a path in a subset of XPath. Steps are element names, or
+// "*", separated by "/", or by "//" for descendants. A step can have an
+// attribute predicate, such as "//device[@type='meter']/reading". A relative
+// path matches elements anywhere in the document.
+func parsePath(path string) ([]step, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty path")
+	}
+	descendant := true
+	if strings.HasPrefix(path, "/") {
+		descendant = strings.HasPrefix(path, "//")
+		path = strings.TrimLeft(path, "/")
+	}
+
+	var steps []step
+	for {
+		end := strings.IndexByte(path, '/')
+		if bracket := strings.IndexByte(path, '['); bracket >= 0 &&
+			(end < 0 || bracket < end) {
+			// slashes in predicates are quoted
+			j := bracket + strings.IndexByte(path[bracket:], ']')
+			if j < bracket {
+				return nil, fmt.Errorf("unterminated predicate")
+			}
+			if end = strings.IndexByte(path[j:], '/'); end >= 0 {
+				end += j
+			}
+		}
+		text := path
+		if end >= 0 {
+			text = path[:end]
+		}
+		s, err := parseStep(text)
+		if err != nil {
+			return nil, err
+		}
+		s.descendant = descendant
+		steps = append(steps, s)
+		if end < 0 {
+			return steps, nil
+		}
+		path = path[end+1:]
+		descendant = strings.HasPrefix(path, "/")
+		path = strings.TrimPrefix(path, "/")
+	}
+}
+
+func parseStep(text string) (step, error) {
+	var s step
+	s.name = text
+	if i := strings.IndexByte(text, '['); i >= 0 {
+		s.name = text[:i]
+		predicate := text[i+1:]
+		if !strings.HasSuffix(predicate, "]") ||
+			!strings.HasPrefix(predicate, "@") {
+			return s, fmt.Errorf("invalid predicate %s, must be [@name] or "+
+				"[@name='value']", text[i:])
+		}
+		predicate = predicate[1 : len(predicate)-1]
+		s.attribute = predicate
+		if eq := strings.IndexByte(predicate, '='); eq >= 0 {
+			s.attribute = predicate[:eq]
+			value := predicate[eq+1:]
+			if len(value) < 2 || value[0] != value[len(value)-1] ||
+				value[0] != '\'' && value[0] != '"' {
+				return s, fmt.Errorf("invalid predicate value %s, must be "+
+					"quoted", value)
+			}
+			value = value[1 : len(value)-1]
+			s.value = &value
+		}
+		if s.attribute == "" {
+			return s, fmt.Errorf("empty attribute in predicate %s", text[i:])
+		}
+	}
+	if s.name == "" || strings.ContainsAny(s.name, "@[]=") {
+		return s, fmt.Errorf("invalid step %q", text)
+	}
+	return s, nil
+}
+
+func (s *step) matches(e *element) bool {
+	if s.name != "*" && s.name != e.name {
+		return false
+	}
+	if s.attribute == "" {
+		return true
+	}
+	v, ok := e.attributes[s.attribute]
+	return ok && (s.value == nil || *s.value == v)
+}
+
+// match returns true if the steps match the path to an element, from the
+// document root to the element.
+func match(steps []step, path []*element) bool {
+	if len(steps) == 0 {
+		return len(path) == 0
+	}
+	s := &steps[0]
+	if !s.descendant {
+		return len(path) != 0 && s.matches(path[0]) && match(steps[1:], path[1:])
+	}
+	for i := range path {
+		if s.matches(path[i]) && match(steps[1:], path[i+1:]) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,374 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Avro field value types.
+const (
+	typeNull    = "null"
+	typeBoolean = "boolean"
+	typeLong    = "long"
+	typeDouble  = "double"
+	typeString  = "string"
+)
+
+// AvroSerializer serializes metrics into Avro records in the Confluent wire
+// format. Each record is a zero byte, the big endian schema id in a schema
+// registry, and the Avro binary encoding of the record.
+//
+// Records are named after the measurement, under the subject
+// <namespace>.<measurement>. Each has a "timestamp" in microseconds, a
+// "tags" map, and one optional field per metric field. The schema starts
+// from the latest subject version in the registry. A new field, or a new
+// type for a field, is added to the schema, which is then registered as a
+// new version. Schemas stay backward and forward compatible: fields are only
+// added, as unions with null that default to null, and types are only added
+// to the unions.
+type AvroSerializer struct {
+	// SchemaRegistry is the URL of the schema registry.
+	SchemaRegistry string
+	// Namespace is the record namespace.
+	Namespace string
+
+	registry *registry
+
+	mu sync.Mutex
+	// schemas are the schemas by measurement
+	schemas map[string]*schema
+}
+
+func NewAvroSerializer(
+	schemaRegistry string,
+	namespace string,
+) (*AvroSerializer, error) {
+	if schemaRegistry == "" {
+		return nil, fmt.Errorf("avro_schema_registry must be set")
+	}
+	if namespace == "" {
+		namespace = "telegraf"
+	}
+	if !validNamespace(namespace) {
+		return nil, fmt.Errorf("invalid avro_namespace %q, must be names made of "+
+			"letters, digits and underscores separated by dots", namespace)
+	}
+	return &AvroSerializer{
+		SchemaRegistry: schemaRegistry,
+		Namespace:      namespace,
+		registry:       newRegistry(schemaRegistry),
+		schemas:        make(map[string]*schema),
+	}, nil
+}
+
+// field is an optional record field. Its type is a union of null and its
+// types.
+type field struct {
+	name  string
+	types []string
+}
+
+// schema is the record schema for a measurement.
+type schema struct {
+	name   string
+	id     int
+	fields []*field
+	// index are the fields by name
+	index map[string]*field
+}
+
+func (s *AvroSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sc, err := s.schema(metric)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]interface{})
+	for k, v := range metric.Fields() {
+		if v, _ := value(v); v != nil {
+			values[fieldName(k)] = v
+		}
+	}
+
+	buf := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(buf[1:], uint32(sc.id))
+	buf = appendLong(buf, metric.UnixNano()/1000)
+
+	tags := metric.Tags()
+	if len(tags) != 0 {
+		keys := make([]string, 0, len(tags))
+		for k := range tags {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		buf = appendLong(buf, int64(len(tags)))
+		for _, k := range keys {
+			buf = appendString(buf, k)
+			buf = appendString(buf, tags[k])
+		}
+	}
+	buf = appendLong(buf, 0)
+
+	for _, f := range sc.fields {
+		v, ok := values[f.name]
+		if !ok {
+			// the null branch of the union
+			buf = appendLong(buf, 0)
+			continue
+		}
+		_, typ := value(v)
+		for i, t := range f.types {
+			if t == typ {
+				buf = appendLong(buf, int64(i+1))
+			}
+		}
+		switch t := v.(type) {
+		case bool:
+			if t {
+				buf = append(buf, 1)
+			} else {
+				buf = append(buf, 0)
+			}
+		case int64:
+			buf = appendLong(buf, t)
+		case float64:
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(t))
+			buf = append(buf, b[:]...)
+		case string:
+			buf = appendString(buf, t)
+		}
+	}
+	return []string{string(buf)}, nil
+}
+
+// schema returns the schema for the metric's measurement, including the
+// metric's fields. If some are missing, it registers a new schema version.
+func (s *AvroSerializer) schema(metric telegraf.Metric) (*schema, error) {
+	name := sanitize(metric.Name())
+	subject := s.Namespace + "." + name
+	sc, ok := s.schemas[name]
+	if !ok {
+		id, latest, err := s.registry.latest(subject)
+		if err != nil {
+			return nil, err
+		}
+		if sc, err = parseSchema(name, latest); err != nil {
+			return nil, fmt.Errorf("latest schema for subject %s: %s",
+				subject, err)
+		}
+		sc.id = id
+		s.schemas[name] = sc
+	}
+
+	evolved := &schema{name: name, index: make(map[string]*field)}
+	for _, f := range sc.fields {
+		f := &field{name: f.name, types: append([]string(nil), f.types...)}
+		evolved.fields = append(evolved.fields, f)
+		evolved.index[f.name] = f
+	}
+	changed := false
+	fields := metric.Fields()
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		_, typ := value(fields[k])
+		if typ == "" {
+			continue
+		}
+		f, ok := evolved.index[fieldName(k)]
+		if !ok {
+			f = &field{name: fieldName(k)}
+			evolved.fields = append(evolved.fields, f)
+			evolved.index[f.name] = f
+		}
+		if !f.has(typ) {
+			f.types = append(f.types, typ)
+			changed = true
+		}
+	}
+	if !changed && sc.id != 0 {
+		return sc, nil
+	}
+
+	id, err := s.registry.register(subject, s.encodeSchema(evolved))
+	if err != nil {
+		return nil, fmt.Errorf("unable to register schema for subject %s, %s",
+			subject, err)
+	}
+	evolved.id = id
+	s.schemas[name] = evolved
+	return evolved, nil
+}
+
+func (f *field) has(typ string) bool {
+	for _, t := range f.types {
+		if t == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeSchema returns an Avro schema as JSON.
+func (s *AvroSerializer) encodeSchema(sc *schema) string {
+	fields := []interface{}{
+		map[string]interface{}{
+			"name": "timestamp",
+			"type": map[string]string{
+				"type":        typeLong,
+				"logicalType": "timestamp-micros",
+			},
+		},
+		map[string]interface{}{
+			"name": "tags",
+			"type": map[string]string{"type": "map", "values": typeString},
+		},
+	}
+	for _, f := range sc.fields {
+		fields = append(fields, map[string]interface{}{
+			"name":    f.name,
+			"type":    append([]string{typeNull}, f.types...),
+			"default": nil,
+		})
+	}
+	encoded, _ := json.Marshal(map[string]interface{}{
+		"type":      "record",
+		"name":      sc.name,
+		"namespace": s.Namespace,
+		"fields":    fields,
+	})
+	return string(encoded)
+}
+
+// parseSchema parses the fields of a schema registered by the serializer. An
+// empty schema has no fields.
+func parseSchema(name, encoded string) (*schema, error) {
+	sc := &schema{name: name, index: make(map[string]*field)}
+	if encoded == "" {
+		return sc, nil
+	}
+	var record struct {
+		Type   string `json:"type"`
+		Fields []struct {
+			Name string          `json:"name"`
+			Type json.RawMessage `json:"type"`
+		} `json:"fields"`
+	}
+	if err := json.Unmarshal([]byte(encoded), &record); err != nil {
+		return nil, err
+	}
+	if record.Type != "record" {
+		return nil, fmt.Errorf("a %s, not a record", record.Type)
+	}
+	for _, f := range record.Fields {
+		if f.Name == "timestamp" || f.Name == "tags" {
+			continue
+		}
+		var types []string
+		if json.Unmarshal(f.Type, &types) != nil || len(types) < 2 ||
+			types[0] != typeNull {
+			return nil, fmt.Errorf("field %s is not a union of null and "+
+				"primitive types", f.Name)
+		}
+		for _, t := range types[1:] {
+			switch t {
+			case typeBoolean, typeLong, typeDouble, typeString:
+			default:
+				return nil, fmt.Errorf("field %s is a union with a %s", f.Name,
+					t)
+			}
+		}
+		field := &field{name: f.Name, types: types[1:]}
+		sc.fields = append(sc.fields, field)
+		sc.index[f.Name] = field
+	}
+	return sc, nil
+}
+
+// value returns a field value and its Avro type, or nil if it has none.
+func value(v interface{}) (interface{}, string) {
+	switch t := v.(type) {
+	case bool:
+		return t, typeBoolean
+	case int64:
+		return t, typeLong
+	case uint64:
+		if t > math.MaxInt64 {
+			return float64(t), typeDouble
+		}
+		return int64(t), typeLong
+	case float64:
+		return t, typeDouble
+	case string:
+		return t, typeString
+	}
+	return nil, ""
+}
+
+// sanitize returns an Avro name for a measurement or a field. The name has
+// only letters, digits and underscores, and does not start with a digit.
+func sanitize(name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' ||
+			c >= '0' && c <= '9') {
+			b[i] = '_'
+		}
+	}
+	if len(b) == 0 || b[0] >= '0' && b[0] <= '9' {
+		return "_" + string(b)
+	}
+	return string(b)
+}
+
+// fieldName returns the record field name for a metric field. The names
+// timestamp and tags get a trailing underscore.
+func fieldName(name string) string {
+	name = sanitize(name)
+	if name == "timestamp" || name == "tags" {
+		return name + "_"
+	}
+	return name
+}
+
+// validNamespace returns true if a namespace is Avro names separated by dots.
+func validNamespace(namespace string) bool {
+	start := true
+	for _, c := range namespace {
+		switch {
+		case c == '.' && !start:
+			start = true
+			continue
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9' && !start:
+		default:
+			return false
+		}
+		start = false
+	}
+	return !start
+}
+
+// appendLong appends a long, zigzag encoded.
+func appendLong(buf []byte, v int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], v)]...)
+}
+
+func appendString(buf []byte, v string) []byte {
+	buf = appendLong(buf, int64(len(v)))
+	return append(buf, v...)
+}
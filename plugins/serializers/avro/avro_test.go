@@ -0,0 +1,256 @@
+package avro
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+// fakeRegistry is an in-memory schema registry of subject versions.
+type fakeRegistry struct {
+	sync.Mutex
+	schemas  []string
+	subjects map[string][]int
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{subjects: make(map[string][]int)}
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	defer r.Unlock()
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/"), "/")
+	if len(parts) < 3 || parts[0] != "subjects" || parts[2] != "versions" {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	subject := parts[1]
+	versions := r.subjects[subject]
+
+	if req.Method == "GET" {
+		if len(versions) == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(registryError{subjectNotFound,
+				"Subject not found."})
+			return
+		}
+		id := versions[len(versions)-1]
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"subject": subject,
+			"id":      id,
+			"schema":  r.schemas[id-1],
+		})
+		return
+	}
+
+	var body struct{ Schema string }
+	json.NewDecoder(req.Body).Decode(&body)
+	id := 0
+	for i, schema := range r.schemas {
+		if schema == body.Schema {
+			id = i + 1
+		}
+	}
+	if id == 0 {
+		r.schemas = append(r.schemas, body.Schema)
+		id = len(r.schemas)
+	}
+	r.subjects[subject] = append(versions, id)
+	json.NewEncoder(w).Encode(map[string]int{"id": id})
+}
+
+// decoder decodes the Avro binary encoding.
+type decoder struct {
+	t   *testing.T
+	buf []byte
+}
+
+func (d *decoder) long() int64 {
+	v, n := binary.Varint(d.buf)
+	require.True(d.t, n > 0)
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) string() string {
+	n := int(d.long())
+	v := string(d.buf[:n])
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) double() float64 {
+	v := math.Float64frombits(binary.LittleEndian.Uint64(d.buf))
+	d.buf = d.buf[8:]
+	return v
+}
+
+// decodeRecord decodes a record in the wire format. It returns the schema id, the
+// timestamp, the tags, and the field branches and values.
+func decodeRecord(
+	t *testing.T,
+	record string,
+	types [][]string,
+) (int, int64, map[string]string, []interface{}) {
+	require.True(t, len(record) > 5)
+	require.Equal(t, byte(0), record[0])
+	id := int(binary.BigEndian.Uint32([]byte(record[1:5])))
+	d := &decoder{t, []byte(record[5:])}
+
+	timestamp := d.long()
+	tags := make(map[string]string)
+	for n := d.long(); n != 0; n = d.long() {
+		for i := int64(0); i < n; i++ {
+			k := d.string()
+			tags[k] = d.string()
+		}
+	}
+	var values []interface{}
+	for _, union := range types {
+		branch := d.long()
+		if branch == 0 {
+			values = append(values, nil)
+			continue
+		}
+		switch union[branch] {
+		case typeLong:
+			values = append(values, d.long())
+		case typeDouble:
+			values = append(values, d.double())
+		case typeString:
+			values = append(values, d.string())
+		case typeBoolean:
+			values = append(values, d.buf[0] == 1)
+			d.buf = d.buf[1:]
+		}
+	}
+	assert.Empty(t, d.buf)
+	return id, timestamp, tags, values
+}
+
+func newMetric(
+	t *testing.T,
+	tags map[string]string,
+	fields map[string]interface{},
+) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu", tags, fields,
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	return m
+}
+
+func TestSerialize(t *testing.T) {
+	ts := httptest.NewServer(newFakeRegistry())
+	defer ts.Close()
+	s, err := NewAvroSerializer(ts.URL, "")
+	require.NoError(t, err)
+
+	out, err := s.Serialize(newMetric(t,
+		map[string]string{"host": "a", "cpu": "cpu0"},
+		map[string]interface{}{"usage_idle": 91.5, "state": "ok"}))
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	id, timestamp, tags, values := decodeRecord(t, out[0],
+		[][]string{{typeNull, typeString}, {typeNull, typeDouble}})
+	assert.Equal(t, 1, id)
+	assert.Equal(t, int64(1465839830123456), timestamp)
+	assert.Equal(t, map[string]string{"host": "a", "cpu": "cpu0"}, tags)
+	assert.Equal(t, []interface{}{"ok", 91.5}, values)
+
+	var schema map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(s.encodeSchema(s.schemas["cpu"])),
+		&schema))
+	assert.Equal(t, "cpu", schema["name"])
+	assert.Equal(t, "telegraf", schema["namespace"])
+}
+
+// Verify that new fields and new field types register new schema versions,
+// with the fields appended.
+func TestSerializeSchemaEvolution(t *testing.T) {
+	registry := newFakeRegistry()
+	ts := httptest.NewServer(registry)
+	defer ts.Close()
+	s, err := NewAvroSerializer(ts.URL, "metrics")
+	require.NoError(t, err)
+
+	_, err = s.Serialize(newMetric(t, nil,
+		map[string]interface{}{"usage": int64(1)}))
+	require.NoError(t, err)
+
+	// the same schema
+	out, err := s.Serialize(newMetric(t, nil,
+		map[string]interface{}{"usage": int64(2)}))
+	require.NoError(t, err)
+	id, _, _, values := decodeRecord(t, out[0], [][]string{{typeNull, typeLong}})
+	assert.Equal(t, 1, id)
+	assert.Equal(t, []interface{}{int64(2)}, values)
+
+	out, err = s.Serialize(newMetric(t, nil,
+		map[string]interface{}{"usage": 2.5, "up": true}))
+	require.NoError(t, err)
+	id, _, _, values = decodeRecord(t, out[0], [][]string{
+		{typeNull, typeLong, typeDouble}, {typeNull, typeBoolean}})
+	assert.Equal(t, 2, id)
+	assert.Equal(t, []interface{}{2.5, true}, values)
+
+	// an older metric is written with the latest schema
+	out, err = s.Serialize(newMetric(t, nil,
+		map[string]interface{}{"usage": int64(3)}))
+	require.NoError(t, err)
+	id, _, _, values = decodeRecord(t, out[0], [][]string{
+		{typeNull, typeLong, typeDouble}, {typeNull, typeBoolean}})
+	assert.Equal(t, 2, id)
+	assert.Equal(t, []interface{}{int64(3), nil}, values)
+	assert.Equal(t, []int{1, 2}, registry.subjects["metrics.cpu"])
+
+	// a new serializer starts from the latest schema in the registry
+	s, err = NewAvroSerializer(ts.URL, "metrics")
+	require.NoError(t, err)
+	out, err = s.Serialize(newMetric(t, nil,
+		map[string]interface{}{"up": false}))
+	require.NoError(t, err)
+	id, _, _, values = decodeRecord(t, out[0], [][]string{
+		{typeNull, typeLong, typeDouble}, {typeNull, typeBoolean}})
+	assert.Equal(t, 2, id)
+	assert.Equal(t, []interface{}{nil, false}, values)
+}
+
+func TestSerializeRegistryError(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}))
+	defer ts.Close()
+	s, err := NewAvroSerializer(ts.URL, "")
+	require.NoError(t, err)
+	_, err = s.Serialize(newMetric(t, nil,
+		map[string]interface{}{"usage": 1.0}))
+	assert.Error(t, err)
+}
+
+func TestFieldName(t *testing.T) {
+	assert.Equal(t, "cs_method", fieldName("cs-method"))
+	assert.Equal(t, "_5xx", fieldName("5xx"))
+	assert.Equal(t, "timestamp_", fieldName("timestamp"))
+}
+
+func TestNewAvroSerializerInvalid(t *testing.T) {
+	_, err := NewAvroSerializer("", "")
+	assert.Error(t, err)
+	for _, namespace := range []string{"1abc", "a..b", "a.", "a-b"} {
+		_, err := NewAvroSerializer("http://localhost:8081", namespace)
+		assert.Error(t, err, namespace)
+	}
+}
@@ -0,0 +1,116 @@
+package avro
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// contentType is the content type for schema registry API requests.
+const contentType = "application/vnd.schemaregistry.v1+json"
+
+// registry is a client for a Confluent compatible schema registry.
+type registry struct {
+	url    string
+	client *http.Client
+}
+
+func newRegistry(u string) *registry {
+	return &registry{
+		url:    strings.TrimSuffix(u, "/"),
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// registryError is the body of registry error responses.
+type registryError struct {
+	ErrorCode int    `json:"error_code"`
+	Message   string `json:"message"`
+}
+
+// subjectNotFound is the error code for a subject without a schema.
+const subjectNotFound = 40401
+
+// latest returns the id and schema of the latest subject version. The id is
+// 0 if the subject has no versions.
+func (r *registry) latest(subject string) (int, string, error) {
+	var version struct {
+		ID     int    `json:"id"`
+		Schema string `json:"schema"`
+	}
+	code, err := r.do("GET", "/subjects/"+url.QueryEscape(subject)+
+		"/versions/latest", nil, &version)
+	if code == subjectNotFound {
+		return 0, "", nil
+	}
+	if err != nil {
+		return 0, "", err
+	}
+	return version.ID, version.Schema, nil
+}
+
+// register registers a schema as a subject version, unless it already is
+// one, and returns its id.
+func (r *registry) register(subject, schema string) (int, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	var registered struct {
+		ID int `json:"id"`
+	}
+	_, err = r.do("POST", "/subjects/"+url.QueryEscape(subject)+"/versions",
+		bytes.NewReader(body), &registered)
+	if err != nil {
+		return 0, err
+	}
+	return registered.ID, nil
+}
+
+// do sends a request to the registry and decodes its response into out. It
+// returns the error code from an error response.
+func (r *registry) do(
+	method string,
+	path string,
+	body io.Reader,
+	out interface{},
+) (int, error) {
+	req, err := http.NewRequest(method, r.url+path, body)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Accept", contentType)
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var e registryError
+		if json.Unmarshal(data, &e) == nil && e.Message != "" {
+			return e.ErrorCode, fmt.Errorf("schema registry %s returned %s, "+
+				"%s", r.url, resp.Status, e.Message)
+		}
+		return 0, fmt.Errorf("schema registry %s returned %s", r.url,
+			resp.Status)
+	}
+	if err := json.Unmarshal(data, out); err != nil {
+		return 0, fmt.Errorf("invalid response from schema registry %s, %s",
+			r.url, err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,223 @@
+package emf
+
+import (
+	ejson "encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+)
+
+// DefaultNamespace is the namespace for metrics without one.
+const DefaultNamespace = "Telegraf"
+
+// maxMetrics and maxDimensions are the CloudWatch limits on metrics per
+// document and dimensions per metric.
+const (
+	maxMetrics    = 100
+	maxDimensions = 30
+)
+
+// units are the CloudWatch units.
+var units = map[string]bool{
+	"Seconds": true, "Microseconds": true, "Milliseconds": true,
+	"Bytes": true, "Kilobytes": true, "Megabytes": true, "Gigabytes": true,
+	"Terabytes": true, "Bits": true, "Kilobits": true, "Megabits": true,
+	"Gigabits": true, "Terabits": true, "Percent": true, "Count": true,
+	"Bytes/Second": true, "Kilobytes/Second": true, "Megabytes/Second": true,
+	"Gigabytes/Second": true, "Terabytes/Second": true, "Bits/Second": true,
+	"Kilobits/Second": true, "Megabits/Second": true,
+	"Gigabits/Second": true, "Terabits/Second": true, "Count/Second": true,
+	"None": true,
+}
+
+// EMFSerializer serializes metrics into CloudWatch Embedded Metric Format
+// JSON documents. CloudWatch then extracts the metrics from logs holding
+// them, such as Kinesis or Firehose streams to CloudWatch Logs.
+//
+// Each numeric or boolean field is a CloudWatch metric named
+// <measurement>_<field>, with the tags as dimensions. Booleans are 1 or 0,
+// and string fields are dropped. A metric with more than 100 fields is split
+// into one document per 100 fields.
+type EMFSerializer struct {
+	// Namespace is the metric namespace, DefaultNamespace if empty.
+	// NamespaceTag is the tag that sets a metric's namespace instead. That
+	// tag is not a dimension. Metrics without it use Namespace.
+	Namespace    string
+	NamespaceTag string
+	// Dimensions are the tags that are dimensions, all tags if empty. The
+	// other tags are document properties, not dimensions.
+	Dimensions []string
+	// Units are the CloudWatch field units. They are keyed by metric name,
+	// <measurement>_<field>, or else by field key.
+	Units map[string]string
+}
+
+func NewEMFSerializer(
+	namespace string,
+	namespaceTag string,
+	dimensions []string,
+	unitHints []string,
+) (*EMFSerializer, error) {
+	if namespace == "" {
+		namespace = DefaultNamespace
+	}
+	if len(dimensions) > maxDimensions {
+		return nil, fmt.Errorf("invalid emf_dimensions, must be at most %d "+
+			"tags", maxDimensions)
+	}
+	s := &EMFSerializer{
+		Namespace:    namespace,
+		NamespaceTag: namespaceTag,
+		Dimensions:   dimensions,
+		Units:        make(map[string]string),
+	}
+	for _, entry := range unitHints {
+		i := strings.LastIndex(entry, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid emf_units entry %q, must be "+
+				"<field>:<unit>", entry)
+		}
+		name, unit := entry[:i], entry[i+1:]
+		if !units[unit] {
+			return nil, fmt.Errorf("invalid unit %q in emf_units entry %q, "+
+				"must be a CloudWatch unit such as Seconds, Bytes or Percent",
+				unit, entry)
+		}
+		s.Units[name] = unit
+	}
+	return s, nil
+}
+
+// document is the "_aws" metadata of a document.
+type document struct {
+	Timestamp         int64       `json:"Timestamp"`
+	CloudWatchMetrics []directive `json:"CloudWatchMetrics"`
+}
+
+// directive is a metric directive in a document.
+type directive struct {
+	Namespace  string       `json:"Namespace"`
+	Dimensions [][]string   `json:"Dimensions"`
+	Metrics    []definition `json:"Metrics"`
+}
+
+// definition is a metric definition in a directive.
+type definition struct {
+	Name string `json:"Name"`
+	Unit string `json:"Unit,omitempty"`
+}
+
+func (s *EMFSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	var names []string
+	values := make(map[string]float64)
+	for k, v := range metric.Fields() {
+		if value, ok := toFloat(v); ok {
+			name := metric.Name() + "_" + k
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+	sort.Strings(names)
+
+	// the tags, both dimensions and properties, except those named like a
+	// metric, since the metric values use those names
+	namespace := s.Namespace
+	tags := make(map[string]string)
+	for k, v := range metric.Tags() {
+		if k == s.NamespaceTag && s.NamespaceTag != "" {
+			if v != "" {
+				namespace = v
+			}
+			continue
+		}
+		if _, ok := values[k]; !ok {
+			tags[k] = v
+		}
+	}
+	dimensions := []string{}
+	if len(s.Dimensions) == 0 {
+		for k := range tags {
+			dimensions = append(dimensions, k)
+		}
+		sort.Strings(dimensions)
+		if len(dimensions) > maxDimensions {
+			return []string{}, fmt.Errorf("metric %s has %d tags, more "+
+				"than the %d CloudWatch dimensions", metric.Name(),
+				len(dimensions), maxDimensions)
+		}
+	} else {
+		for _, k := range s.Dimensions {
+			if _, ok := tags[k]; ok {
+				dimensions = append(dimensions, k)
+			}
+		}
+	}
+
+	out := []string{}
+	for i := 0; i < len(names); i += maxMetrics {
+		chunk := names[i:]
+		if len(chunk) > maxMetrics {
+			chunk = chunk[:maxMetrics]
+		}
+
+		d := make(map[string]interface{}, len(tags)+len(chunk)+1)
+		for k, v := range tags {
+			d[k] = v
+		}
+		definitions := make([]definition, 0, len(chunk))
+		for _, name := range chunk {
+			d[name] = values[name]
+			definitions = append(definitions, definition{
+				Name: name,
+				Unit: s.unit(metric.Name(), name),
+			})
+		}
+		d["_aws"] = &document{
+			Timestamp: metric.UnixNano() / 1e6,
+			CloudWatchMetrics: []directive{{
+				Namespace:  namespace,
+				Dimensions: [][]string{dimensions},
+				Metrics:    definitions,
+			}},
+		}
+
+		serialized, err := ejson.Marshal(d)
+		if err != nil {
+			return []string{}, err
+		}
+		out = append(out, string(serialized))
+	}
+	return out, nil
+}
+
+// unit returns the unit for a field's metric, or none if there is no unit
+// hint.
+func (s *EMFSerializer) unit(measurement, name string) string {
+	if unit, ok := s.Units[name]; ok {
+		return unit
+	}
+	return s.Units[strings.TrimPrefix(name, measurement+"_")]
+}
+
+// toFloat returns the metric value for a field, or false if it has none.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
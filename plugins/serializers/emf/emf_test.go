@@ -0,0 +1,101 @@
+package emf
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+func TestSerialize(t *testing.T) {
+	s, err := NewEMFSerializer("", "", nil,
+		[]string{"usage_idle:Percent", "cpu_count:Count"})
+	require.NoError(t, err)
+
+	m, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "cpu": "cpu0"},
+		map[string]interface{}{
+			"usage_idle": 91.5,
+			"count":      int64(3),
+			"online":     true,
+			"state":      "ok",
+		},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	out, err := s.Serialize(m)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"_aws":{"Timestamp":1465839830123,"CloudWatchMetrics":[{` +
+			`"Namespace":"Telegraf","Dimensions":[["cpu","host"]],` +
+			`"Metrics":[{"Name":"cpu_count","Unit":"Count"},` +
+			`{"Name":"cpu_online"},` +
+			`{"Name":"cpu_usage_idle","Unit":"Percent"}]}]},` +
+			`"cpu":"cpu0","cpu_count":3,"cpu_online":1,` +
+			`"cpu_usage_idle":91.5,"host":"web1"}`,
+	}, out)
+}
+
+func TestSerializeNamespaceDimensions(t *testing.T) {
+	s, err := NewEMFSerializer("App", "namespace", []string{"service", "az"},
+		nil)
+	require.NoError(t, err)
+
+	m, err := telegraf.NewMetric("http",
+		map[string]string{
+			"namespace": "Checkout",
+			"service":   "api",
+			"pod":       "api-1",
+		},
+		map[string]interface{}{"latency": 0.25},
+		time.Unix(1465839830, 0))
+	require.NoError(t, err)
+	out, err := s.Serialize(m)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"_aws":{"Timestamp":1465839830000,"CloudWatchMetrics":[{` +
+			`"Namespace":"Checkout","Dimensions":[["service"]],` +
+			`"Metrics":[{"Name":"http_latency"}]}]},` +
+			`"http_latency":0.25,"pod":"api-1","service":"api"}`,
+	}, out)
+
+	// metrics without numeric fields produce no documents
+	m, err = telegraf.NewMetric("log", nil,
+		map[string]interface{}{"message": "x"}, time.Now())
+	require.NoError(t, err)
+	out, err = s.Serialize(m)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
+
+func TestSerializeManyFields(t *testing.T) {
+	s, err := NewEMFSerializer("", "", nil, nil)
+	require.NoError(t, err)
+
+	fields := make(map[string]interface{})
+	for i := 0; i < 150; i++ {
+		fields[fmt.Sprintf("f%03d", i)] = int64(i)
+	}
+	m, err := telegraf.NewMetric("big", nil, fields, time.Now())
+	require.NoError(t, err)
+	out, err := s.Serialize(m)
+	require.NoError(t, err)
+	require.Len(t, out, 2)
+	assert.Equal(t, 100, strings.Count(out[0], `"Name"`))
+	assert.Equal(t, 50, strings.Count(out[1], `"Name"`))
+	assert.Contains(t, out[1], `"big_f149":149`)
+}
+
+func TestNewEMFSerializerInvalid(t *testing.T) {
+	_, err := NewEMFSerializer("", "", nil, []string{"latency"})
+	assert.Error(t, err)
+	_, err = NewEMFSerializer("", "", nil, []string{"latency:seconds"})
+	assert.Error(t, err)
+	dimensions := make([]string, 31)
+	_, err = NewEMFSerializer("", "", dimensions, nil)
+	assert.Error(t, err)
+}
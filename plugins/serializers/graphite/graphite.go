@@ -2,23 +2,148 @@ package graphite
 
 import (
 	"fmt"
+	"hash/fnv"
 	"sort"
 	"strings"
 
+	"github.com/gobwas/glob"
+
 	"github.com/influxdata/telegraf"
 )
 
 const DEFAULT_TEMPLATE = "host.tags.measurement.field"
 
+// Metric name sanitize modes.
+const (
+	// SanitizeClassic replaces "/", "@", "*" by "-" and spaces by "_".
+	SanitizeClassic = "classic"
+	// SanitizeStrict replaces the same characters as SanitizeClassic. It
+	// then replaces every character except letters, digits, ".", "-", "_"
+	// and ":" by "_".
+	SanitizeStrict = "strict"
+	// SanitizeTags writes Graphite 1.1 tagged series. Tags not in the
+	// template are written as ";<tag>=<value>". The characters that have a
+	// meaning there, ";", "!", "^", "=", "~" and spaces, are replaced by
+	// "_".
+	SanitizeTags = "tags"
+)
+
 var fieldDeleter = strings.NewReplacer(".FIELDNAME", "", "FIELDNAME.", "")
 
 type GraphiteSerializer struct {
 	Prefix   string
 	Template string
+	// Templates are templates for the measurements matching their filter,
+	// as "<filter> <template>", such as "disk* host.measurement.tags.field".
+	// The first matching template wins. If none matches, Template is used,
+	// or else the template without a filter.
+	Templates []string
+	// SanitizeMode sets how names are sanitized: SanitizeClassic (if
+	// empty), SanitizeStrict or SanitizeTags.
+	SanitizeMode string
+	// Replacements override the SanitizeMode character replacements, as
+	// "<old>:<new>".
+	Replacements []string
+	// MaxLength is the maximum name path length, 0 for no maximum. Longer
+	// paths are truncated and suffixed by a hash of the full path, so they
+	// stay unique.
+	MaxLength int
+
+	matcher  *matcher
+	replacer *strings.Replacer
 }
 
 var sanitizedChars = strings.NewReplacer("/", "-", "@", "-", "*", "-", " ", "_", "..", ".")
 
+var sanitizedTagChars = strings.NewReplacer(";", "_", "!", "_", "^", "_",
+	"=", "_", "~", "_", " ", "_")
+
+func NewGraphiteSerializer(
+	prefix string,
+	template string,
+	templates []string,
+	sanitizeMode string,
+	replacements []string,
+	maxLength int,
+) (*GraphiteSerializer, error) {
+	s := &GraphiteSerializer{
+		Prefix:       prefix,
+		Template:     template,
+		Templates:    templates,
+		SanitizeMode: sanitizeMode,
+		Replacements: replacements,
+		MaxLength:    maxLength,
+	}
+	switch sanitizeMode {
+	case "", SanitizeClassic, SanitizeStrict, SanitizeTags:
+	default:
+		return nil, fmt.Errorf("invalid graphite_sanitize_mode %q, must be "+
+			"classic, strict or tags", sanitizeMode)
+	}
+	if maxLength != 0 && maxLength < 32 {
+		return nil, fmt.Errorf("invalid graphite_max_length %d, must be 0 "+
+			"or at least 32", maxLength)
+	}
+
+	if len(replacements) != 0 {
+		var oldnew []string
+		for _, entry := range replacements {
+			i := strings.LastIndex(entry, ":")
+			if i <= 0 {
+				return nil, fmt.Errorf("invalid graphite_replacements entry "+
+					"%q, must be <old>:<new>", entry)
+			}
+			oldnew = append(oldnew, entry[:i], entry[i+1:])
+		}
+		s.replacer = strings.NewReplacer(oldnew...)
+	}
+
+	s.matcher = &matcher{}
+	for _, entry := range templates {
+		parts := strings.Fields(entry)
+		switch len(parts) {
+		case 1:
+			s.Template = parts[0]
+		case 2:
+			filter, err := glob.Compile(parts[0])
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter in graphite_templates "+
+					"entry %q, %s", entry, err)
+			}
+			s.matcher.templates = append(s.matcher.templates,
+				filteredTemplate{filter, parts[1]})
+		default:
+			return nil, fmt.Errorf("invalid graphite_templates entry %q, "+
+				"must be [<filter>] <template>", entry)
+		}
+	}
+	return s, nil
+}
+
+// matcher matches measurements to their templates.
+type matcher struct {
+	templates []filteredTemplate
+}
+
+type filteredTemplate struct {
+	filter   glob.Glob
+	template string
+}
+
+// match returns the template for the first filter matching a measurement,
+// or none.
+func (m *matcher) match(measurement string) string {
+	if m == nil {
+		return ""
+	}
+	for _, t := range m.templates {
+		if t.filter.Match(measurement) {
+			return t.template
+		}
+	}
+	return ""
+}
+
 func (s *GraphiteSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
 	out := []string{}
 
@@ -32,7 +157,7 @@ func (s *GraphiteSerializer) Serialize(metric telegraf.Metric) ([]string, error)
 		valueS := fmt.Sprintf("%#v", value)
 		point := fmt.Sprintf("%s %s %d",
 			// insert "field" section of template
-			InsertField(bucket, fieldName),
+			s.shorten(InsertField(bucket, s.sanitize(fieldName))),
 			valueS,
 			timestamp)
 		out = append(out, point)
@@ -41,8 +166,9 @@ func (s *GraphiteSerializer) Serialize(metric telegraf.Metric) ([]string, error)
 }
 
 // SerializeBucketName will take the given measurement name and tags and
-// produce a graphite bucket. It will use the GraphiteSerializer.Template
-// to generate this, or DEFAULT_TEMPLATE.
+// produce a graphite bucket. It will use the template in
+// GraphiteSerializer.Templates that matches the measurement, or the
+// GraphiteSerializer.Template, or DEFAULT_TEMPLATE to generate this.
 //
 // NOTE: SerializeBucketName replaces the "field" portion of the template with
 // FIELDNAME. It is up to the user to replace this. This is so that
@@ -52,8 +178,12 @@ func (s *GraphiteSerializer) SerializeBucketName(
 	measurement string,
 	tags map[string]string,
 ) string {
-	if s.Template == "" {
-		s.Template = DEFAULT_TEMPLATE
+	template := s.matcher.match(measurement)
+	if template == "" {
+		template = s.Template
+	}
+	if template == "" {
+		template = DEFAULT_TEMPLATE
 	}
 	tagsCopy := make(map[string]string)
 	for k, v := range tags {
@@ -61,14 +191,17 @@ func (s *GraphiteSerializer) SerializeBucketName(
 	}
 
 	var out []string
-	templateParts := strings.Split(s.Template, ".")
+	templateParts := strings.Split(template, ".")
 	for _, templatePart := range templateParts {
 		switch templatePart {
 		case "measurement":
 			out = append(out, measurement)
 		case "tags":
-			// we will replace this later
-			out = append(out, "TAGS")
+			// we will replace this later, tagged series have their tags
+			// after the path
+			if s.SanitizeMode != SanitizeTags {
+				out = append(out, "TAGS")
+			}
 		case "field":
 			// user of SerializeBucketName needs to replace this
 			out = append(out, "FIELDNAME")
@@ -89,10 +222,76 @@ func (s *GraphiteSerializer) SerializeBucketName(
 		}
 	}
 
-	if s.Prefix == "" {
-		return sanitizedChars.Replace(strings.Join(out, "."))
+	path := strings.Join(out, ".")
+	if s.Prefix != "" {
+		path = s.Prefix + "." + path
+	}
+	path = s.sanitize(path)
+	if s.SanitizeMode == SanitizeTags {
+		path += s.seriesTags(tagsCopy)
+	}
+	return path
+}
+
+// sanitize replaces the characters in a name that SanitizeMode, or
+// Replacements, apply to.
+func (s *GraphiteSerializer) sanitize(name string) string {
+	replacer := sanitizedChars
+	if s.SanitizeMode == SanitizeTags {
+		replacer = sanitizedTagChars
+	}
+	if s.replacer != nil {
+		replacer = s.replacer
+	}
+	name = replacer.Replace(name)
+
+	if s.SanitizeMode == SanitizeStrict {
+		name = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z',
+				r >= '0' && r <= '9', r == '.', r == '-', r == '_', r == ':':
+				return r
+			}
+			return '_'
+		}, name)
+	}
+	return name
+}
+
+// seriesTags returns the tags for a tagged series, as ";<tag>=<value>",
+// sorted by tag. Tags with empty values are dropped.
+func (s *GraphiteSerializer) seriesTags(tags map[string]string) string {
+	var keys []string
+	for k, v := range tags {
+		if v != "" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var out string
+	for _, k := range keys {
+		out += ";" + s.sanitize(k) + "=" + s.sanitize(tags[k])
+	}
+	return out
+}
+
+// shorten truncates a name path longer than MaxLength, and suffixes it with
+// the FNV-1a hash of the full path.
+func (s *GraphiteSerializer) shorten(name string) string {
+	path, tags := name, ""
+	if s.SanitizeMode == SanitizeTags {
+		if i := strings.IndexByte(name, ';'); i >= 0 {
+			path, tags = name[:i], name[i:]
+		}
+	}
+	if s.MaxLength == 0 || len(path) <= s.MaxLength {
+		return name
 	}
-	return sanitizedChars.Replace(s.Prefix + "." + strings.Join(out, "."))
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return fmt.Sprintf("%s.%016x%s",
+		strings.TrimRight(path[:s.MaxLength-17], "."), h.Sum64(), tags)
 }
 
 // InsertField takes the bucket string from SerializeBucketName and replaces the
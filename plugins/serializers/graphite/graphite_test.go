@@ -3,6 +3,7 @@ package graphite
 import (
 	"fmt"
 	"sort"
+	"strings"
 	"testing"
 	"time"
 
@@ -351,3 +352,112 @@ func TestTemplate6(t *testing.T) {
 	expS := "localhost.cpu0.us-west-2.cpu.FIELDNAME"
 	assert.Equal(t, expS, mS)
 }
+
+func TestSerializeTemplates(t *testing.T) {
+	s, err := NewGraphiteSerializer("", "", []string{
+		"disk* host.measurement.tags.field",
+		"cpu host.measurement.field",
+		"tags.measurement.field",
+	}, "", nil, 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "localhost.cpu.FIELDNAME",
+		s.SerializeBucketName("cpu", defaultTags))
+	assert.Equal(t, "localhost.diskio.cpu0.us-west-2.FIELDNAME",
+		s.SerializeBucketName("diskio", defaultTags))
+	assert.Equal(t, "cpu0.us-west-2.localhost.mem.FIELDNAME",
+		s.SerializeBucketName("mem", defaultTags))
+
+	_, err = NewGraphiteSerializer("", "", []string{"[ measurement.field"},
+		"", nil, 0)
+	assert.Error(t, err)
+	_, err = NewGraphiteSerializer("", "", []string{"a b c"}, "", nil, 0)
+	assert.Error(t, err)
+}
+
+func TestSerializeSanitizeModes(t *testing.T) {
+	now := time.Date(2010, time.November, 10, 23, 0, 0, 0, time.UTC)
+	m, err := telegraf.NewMetric("disk usage",
+		map[string]string{
+			"host": "web@1", "path": "/var/log", "mode": "ro;rw", "empty": "",
+		},
+		map[string]interface{}{"used%": int64(42)}, now)
+	assert.NoError(t, err)
+
+	s, err := NewGraphiteSerializer("", "", nil, SanitizeClassic, nil, 0)
+	assert.NoError(t, err)
+	mS, err := s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("web-1.ro;rw.-var-log.disk_usage.used%% 42 %d", now.Unix()),
+	}, mS)
+
+	s, err = NewGraphiteSerializer("", "", nil, SanitizeStrict, nil, 0)
+	assert.NoError(t, err)
+	mS, err = s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("web-1.ro_rw.-var-log.disk_usage.used_ 42 %d", now.Unix()),
+	}, mS)
+
+	s, err = NewGraphiteSerializer("telegraf", "measurement.field", nil,
+		SanitizeTags, nil, 0)
+	assert.NoError(t, err)
+	mS, err = s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("telegraf.disk_usage.used%%;host=web@1;mode=ro_rw;"+
+			"path=/var/log 42 %d", now.Unix()),
+	}, mS)
+
+	s, err = NewGraphiteSerializer("", "measurement.field", nil, SanitizeTags,
+		[]string{" :-", "/:", ";:+"}, 0)
+	assert.NoError(t, err)
+	mS, err = s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		fmt.Sprintf("disk-usage.used%%;host=web@1;mode=ro+rw;path=varlog 42 %d",
+			now.Unix()),
+	}, mS)
+
+	_, err = NewGraphiteSerializer("", "", nil, "loose", nil, 0)
+	assert.Error(t, err)
+	_, err = NewGraphiteSerializer("", "", nil, "", []string{"-"}, 0)
+	assert.Error(t, err)
+}
+
+func TestSerializeMaxLength(t *testing.T) {
+	now := time.Now()
+	host := strings.Repeat("h", 40)
+	m, err := telegraf.NewMetric("cpu", map[string]string{"host": host},
+		map[string]interface{}{"usage_idle": 91.5}, now)
+	assert.NoError(t, err)
+
+	s, err := NewGraphiteSerializer("", "", nil, "", nil, 32)
+	assert.NoError(t, err)
+	mS, err := s.Serialize(m)
+	assert.NoError(t, err)
+	path := strings.Fields(mS[0])[0]
+	assert.Len(t, path, 32)
+	assert.True(t, strings.HasPrefix(path, strings.Repeat("h", 15)+"."))
+
+	// the hashes of different paths differ
+	m2, err := telegraf.NewMetric("cpu", map[string]string{"host": host},
+		map[string]interface{}{"usage_busy": 8.5}, now)
+	assert.NoError(t, err)
+	mS2, err := s.Serialize(m2)
+	assert.NoError(t, err)
+	assert.NotEqual(t, path, strings.Fields(mS2[0])[0])
+
+	// tags of tagged series are not truncated
+	s, err = NewGraphiteSerializer("", "measurement.field", nil, SanitizeTags,
+		nil, 32)
+	assert.NoError(t, err)
+	mS, err = s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("cpu.usage_idle;host=%s 91.5 %d", host,
+		now.Unix()), mS[0])
+
+	_, err = NewGraphiteSerializer("", "", nil, "", nil, 10)
+	assert.Error(t, err)
+}
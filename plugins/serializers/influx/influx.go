@@ -1,12 +1,249 @@
 package influx
 
 import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
 	"github.com/influxdata/telegraf"
 )
 
+// Field float formats.
+const (
+	// FloatDecimal is decimal notation, such as 1234567.5
+	FloatDecimal = "decimal"
+	// FloatExponent is scientific notation, such as 1.2345675e+06
+	FloatExponent = "exponent"
+	// FloatCompact is scientific notation for large exponents, decimal
+	// notation otherwise.
+	FloatCompact = "compact"
+)
+
+var floatFormats = map[string]byte{
+	"":            'f',
+	FloatDecimal:  'f',
+	FloatExponent: 'e',
+	FloatCompact:  'g',
+}
+
+var (
+	measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	keyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	stringEscaper      = strings.NewReplacer(`"`, `\"`, `\`, `\\`)
+)
+
 type InfluxSerializer struct {
+	// UintSupport is true if unsigned integers are written as such, like
+	// 42u. This needs InfluxDB 1.4 or later. Otherwise they are written as
+	// integers, clamped to the largest one.
+	UintSupport bool
+	// FloatFormat is the float format: decimal (if empty), exponent or
+	// compact. FloatPrecision is the number of digits after the decimal
+	// point, or the number of significant digits for compact. If zero, the
+	// fewest digits that represent the float exactly are used.
+	FloatFormat    string
+	FloatPrecision int
+	// UnsortedTags is true if the tags are written unsorted. This skips the
+	// sort that InfluxDB expects for its best performance.
+	UnsortedTags bool
+	// Strict is true if metrics with invalid names are errors. Otherwise
+	// invalid characters in names, which are control characters and invalid
+	// UTF-8, are replaced by underscores, and fields without names are
+	// dropped.
+	Strict bool
+}
+
+func NewInfluxSerializer(
+	uintSupport bool,
+	floatFormat string,
+	floatPrecision int,
+	unsortedTags bool,
+	strict bool,
+) (*InfluxSerializer, error) {
+	if _, ok := floatFormats[floatFormat]; !ok {
+		return nil, fmt.Errorf("invalid influx_float_format %q, must be "+
+			"decimal, exponent or compact", floatFormat)
+	}
+	if floatPrecision < 0 {
+		return nil, fmt.Errorf("invalid influx_float_precision %d, must not "+
+			"be negative", floatPrecision)
+	}
+	return &InfluxSerializer{
+		UintSupport:    uintSupport,
+		FloatFormat:    floatFormat,
+		FloatPrecision: floatPrecision,
+		UnsortedTags:   unsortedTags,
+		Strict:         strict,
+	}, nil
 }
 
 func (s *InfluxSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
-	return []string{metric.String()}, nil
+	name, err := s.name("measurement", metric.Name())
+	if err != nil {
+		return nil, err
+	}
+	b := []byte(measurementEscaper.Replace(name))
+
+	tags := metric.Tags()
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	// the tags are only sorted if they are not already
+	if !s.UnsortedTags && !sort.StringsAreSorted(keys) {
+		sort.Strings(keys)
+	}
+	for _, k := range keys {
+		v := tags[k]
+		// InfluxDB drops empty tags
+		if v == "" {
+			continue
+		}
+		if k, err = s.name("tag", k); err != nil {
+			return nil, err
+		}
+		if v, err = s.name("value of tag "+k, v); err != nil {
+			return nil, err
+		}
+		b = append(b, ',')
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = append(b, keyEscaper.Replace(v)...)
+	}
+
+	fields := metric.Fields()
+	keys = keys[:0]
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	n := 0
+	for _, k := range keys {
+		v := fields[k]
+		if k == "" && !s.Strict {
+			continue
+		}
+		if k, err = s.name("field", k); err != nil {
+			return nil, err
+		}
+		value, ok := s.value(v)
+		if !ok {
+			continue
+		}
+		if n == 0 {
+			b = append(b, ' ')
+		} else {
+			b = append(b, ',')
+		}
+		b = append(b, keyEscaper.Replace(k)...)
+		b = append(b, '=')
+		b = append(b, value...)
+		n++
+	}
+	if n == 0 {
+		return nil, fmt.Errorf("metric %s has no fields", metric.Name())
+	}
+
+	b = append(b, ' ')
+	b = strconv.AppendInt(b, metric.UnixNano(), 10)
+	return []string{string(b)}, nil
+}
+
+// name returns a metric name with invalid characters replaced by
+// underscores. If the serializer is strict, it returns an error instead.
+func (s *InfluxSerializer) name(kind, name string) (string, error) {
+	valid := name != "" && utf8.ValidString(name)
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			valid = false
+			break
+		}
+	}
+	// a trailing backslash would escape the separator following the name
+	if strings.HasSuffix(name, `\`) {
+		valid = false
+	}
+	if valid {
+		return name, nil
+	}
+	if s.Strict {
+		return "", fmt.Errorf("invalid name for %s %q", kind, name)
+	}
+
+	sanitized := make([]rune, 0, len(name))
+	for i := 0; i < len(name); {
+		r, size := utf8.DecodeRuneInString(name[i:])
+		if r == utf8.RuneError && size == 1 || unicode.IsControl(r) {
+			r = '_'
+		}
+		sanitized = append(sanitized, r)
+		i += size
+	}
+	if n := len(sanitized); n != 0 && sanitized[n-1] == '\\' {
+		sanitized[n-1] = '_'
+	}
+	if len(sanitized) == 0 {
+		return "_", nil
+	}
+	return string(sanitized), nil
+}
+
+// value returns a field value in line protocol, or false if the value is
+// not valid.
+func (s *InfluxSerializer) value(v interface{}) ([]byte, bool) {
+	var b []byte
+	switch v := v.(type) {
+	case float64:
+		return s.float(b, v)
+	case float32:
+		return s.float(b, float64(v))
+	case int64:
+		return append(strconv.AppendInt(b, v, 10), 'i'), true
+	case int:
+		return append(strconv.AppendInt(b, int64(v), 10), 'i'), true
+	case int32:
+		return append(strconv.AppendInt(b, int64(v), 10), 'i'), true
+	case uint64:
+		if s.UintSupport {
+			return append(strconv.AppendUint(b, v, 10), 'u'), true
+		}
+		if v > math.MaxInt64 {
+			v = math.MaxInt64
+		}
+		return append(strconv.AppendUint(b, v, 10), 'i'), true
+	case uint32:
+		if s.UintSupport {
+			return append(strconv.AppendUint(b, uint64(v), 10), 'u'), true
+		}
+		return append(strconv.AppendUint(b, uint64(v), 10), 'i'), true
+	case bool:
+		return strconv.AppendBool(b, v), true
+	case string:
+		b = append(b, '"')
+		b = append(b, stringEscaper.Replace(v)...)
+		return append(b, '"'), true
+	case nil:
+		return nil, false
+	}
+	b = append(b, '"')
+	b = append(b, stringEscaper.Replace(fmt.Sprint(v))...)
+	return append(b, '"'), true
+}
+
+// float returns a float in line protocol. It returns false for NaN and
+// infinite values, which are not valid.
+func (s *InfluxSerializer) float(b []byte, f float64) ([]byte, bool) {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil, false
+	}
+	precision := s.FloatPrecision
+	if precision == 0 {
+		precision = -1
+	}
+	return strconv.AppendFloat(b, f, floatFormats[s.FloatFormat], precision,
+		64), true
 }
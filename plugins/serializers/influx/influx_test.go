@@ -2,10 +2,12 @@ package influx
 
 import (
 	"fmt"
+	"math"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 
 	"github.com/influxdata/telegraf"
 )
@@ -66,3 +68,102 @@ func TestSerializeMetricString(t *testing.T) {
 	expS := []string{fmt.Sprintf("cpu,cpu=cpu0 usage_idle=\"foobar\" %d", now.UnixNano())}
 	assert.Equal(t, expS, mS)
 }
+
+func TestSerializeMetricOrder(t *testing.T) {
+	m, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "a", "cpu": "cpu0", "empty": ""},
+		map[string]interface{}{"b": 1, "a": true}, time.Unix(0, 42))
+	assert.NoError(t, err)
+
+	s := InfluxSerializer{}
+	mS, err := s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu,cpu=cpu0,host=a a=true,b=1i 42"}, mS)
+}
+
+// uintMetric is a metric of uint64 fields, which the metrics of
+// telegraf.NewMetric keep as strings.
+type uintMetric struct {
+	telegraf.Metric
+	fields map[string]interface{}
+}
+
+func (m *uintMetric) Fields() map[string]interface{} {
+	return m.fields
+}
+
+func newUintMetric(t *testing.T, v uint64) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu", nil,
+		map[string]interface{}{"a": 0}, time.Unix(0, 42))
+	require.NoError(t, err)
+	return &uintMetric{m, map[string]interface{}{"a": v}}
+}
+
+func TestSerializeMetricUint(t *testing.T) {
+	m := newUintMetric(t, 42)
+	big := newUintMetric(t, math.MaxUint64)
+
+	s, err := NewInfluxSerializer(true, "", 0, false, false)
+	require.NoError(t, err)
+	mS, err := s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu a=42u 42"}, mS)
+
+	s, err = NewInfluxSerializer(false, "", 0, false, false)
+	require.NoError(t, err)
+	mS, err = s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu a=42i 42"}, mS)
+	mS, err = s.Serialize(big)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"cpu a=9223372036854775807i 42"}, mS)
+}
+
+func TestSerializeMetricFloatFormat(t *testing.T) {
+	m, err := telegraf.NewMetric("cpu", nil,
+		map[string]interface{}{"a": 1234567.125}, time.Unix(0, 42))
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		format    string
+		precision int
+		expected  string
+	}{
+		{"", 0, "cpu a=1234567.125 42"},
+		{FloatDecimal, 2, "cpu a=1234567.12 42"},
+		{FloatExponent, 0, "cpu a=1.234567125e+06 42"},
+		{FloatExponent, 3, "cpu a=1.235e+06 42"},
+		{FloatCompact, 4, "cpu a=1.235e+06 42"},
+		{FloatCompact, 10, "cpu a=1234567.125 42"},
+	} {
+		s, err := NewInfluxSerializer(false, tt.format, tt.precision, false,
+			false)
+		require.NoError(t, err)
+		mS, err := s.Serialize(m)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{tt.expected}, mS, tt.format)
+	}
+
+	_, err = NewInfluxSerializer(false, "hex", 0, false, false)
+	assert.Error(t, err)
+	_, err = NewInfluxSerializer(false, "", -1, false, false)
+	assert.Error(t, err)
+}
+
+func TestSerializeMetricEscaping(t *testing.T) {
+	m, err := telegraf.NewMetric("c p,u",
+		map[string]string{"h=o st": "a,b c=d", "line\nbreak": "x"},
+		map[string]interface{}{"f ,=": `say "hi" \o/`, "bad\xff": 1},
+		time.Unix(0, 42))
+	assert.NoError(t, err)
+
+	s := InfluxSerializer{}
+	mS, err := s.Serialize(m)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{`c\ p\,u,h\=o\ st=a\,b\ c\=d,line_break=x ` +
+		`bad_=1i,f\ \,\=="say \"hi\" \\o/" 42`}, mS)
+
+	s.Strict = true
+	_, err = s.Serialize(m)
+	assert.Error(t, err)
+}
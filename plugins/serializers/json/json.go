@@ -2,21 +2,96 @@ package json
 
 import (
 	ejson "encoding/json"
+	"fmt"
+	"strings"
+	"time"
 
 	"github.com/influxdata/telegraf"
 )
 
+// Metric JSON object layouts.
+const (
+	// LayoutNested is {"name": ..., "tags": {...}, "fields": {...}, ...}
+	LayoutNested = "nested"
+	// LayoutFlat is {"name": ..., "<tag>": ..., "<field>": ..., ...}
+	LayoutFlat = "flat"
+	// LayoutMeasurement is {"<name>": {<fields>}, "tags": {...}, ...}
+	LayoutMeasurement = "measurement"
+)
+
+var epochUnits = map[string]time.Duration{
+	"":        time.Second,
+	"unix":    time.Second,
+	"unix_ms": time.Millisecond,
+	"unix_us": time.Microsecond,
+	"unix_ns": time.Nanosecond,
+}
+
 type JsonSerializer struct {
+	// Layout is the metric object layout, LayoutNested if empty.
+	Layout string
+	// TimestampKey is the key holding the metric time, "timestamp" if
+	// empty.
+	TimestampKey string
+	// TimestampFormat is the metric time format: unix (if empty), unix_ms,
+	// unix_us, unix_ns, or a Go time layout such as
+	// 2006-01-02T15:04:05Z07:00.
+	TimestampFormat string
+}
+
+func NewJsonSerializer(
+	layout string,
+	timestampKey string,
+	timestampFormat string,
+) (*JsonSerializer, error) {
+	switch layout {
+	case "", LayoutNested, LayoutFlat, LayoutMeasurement:
+	default:
+		return nil, fmt.Errorf("invalid json_layout %q, must be nested, "+
+			"flat or measurement", layout)
+	}
+	if _, ok := epochUnits[timestampFormat]; !ok &&
+		strings.HasPrefix(timestampFormat, "unix") {
+		return nil, fmt.Errorf("invalid json_timestamp_format %q, must be "+
+			"unix, unix_ms, unix_us, unix_ns or a time layout",
+			timestampFormat)
+	}
+	return &JsonSerializer{
+		Layout:          layout,
+		TimestampKey:    timestampKey,
+		TimestampFormat: timestampFormat,
+	}, nil
 }
 
 func (s *JsonSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
 	out := []string{}
 
 	m := make(map[string]interface{})
-	m["tags"] = metric.Tags()
-	m["fields"] = metric.Fields()
-	m["name"] = metric.Name()
-	m["timestamp"] = metric.UnixNano() / 1000000000
+	switch s.Layout {
+	case LayoutFlat:
+		// fields win over tags with the same name, and the name and the
+		// time win over both
+		for k, v := range metric.Tags() {
+			m[k] = v
+		}
+		for k, v := range metric.Fields() {
+			m[k] = v
+		}
+		m["name"] = metric.Name()
+	case LayoutMeasurement:
+		m["tags"] = metric.Tags()
+		m[metric.Name()] = metric.Fields()
+	default:
+		m["tags"] = metric.Tags()
+		m["fields"] = metric.Fields()
+		m["name"] = metric.Name()
+	}
+	key := s.TimestampKey
+	if key == "" {
+		key = "timestamp"
+	}
+	m[key] = s.timestamp(metric.Time())
+
 	serialized, err := ejson.Marshal(m)
 	if err != nil {
 		return []string{}, err
@@ -25,3 +100,11 @@ func (s *JsonSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
 
 	return out, nil
 }
+
+// timestamp returns a time formatted with the TimestampFormat.
+func (s *JsonSerializer) timestamp(t time.Time) interface{} {
+	if unit, ok := epochUnits[s.TimestampFormat]; ok {
+		return t.UnixNano() / int64(unit)
+	}
+	return t.UTC().Format(s.TimestampFormat)
+}
@@ -85,3 +85,42 @@ func TestSerializeMultiFields(t *testing.T) {
 	expS := []string{fmt.Sprintf("{\"fields\":{\"usage_idle\":90,\"usage_total\":8559615},\"name\":\"cpu\",\"tags\":{\"cpu\":\"cpu0\"},\"timestamp\":%d}", now.Unix())}
 	assert.Equal(t, expS, mS)
 }
+
+func TestSerializeLayouts(t *testing.T) {
+	now := time.Unix(1465839830, 123456789)
+	tags := map[string]string{
+		"cpu": "cpu0",
+	}
+	fields := map[string]interface{}{
+		"usage_idle": 91.5,
+	}
+	m, err := telegraf.NewMetric("cpu", tags, fields, now)
+	assert.NoError(t, err)
+
+	for _, tt := range []struct {
+		layout, key, format string
+		expected            string
+	}{
+		{"", "", "",
+			`{"fields":{"usage_idle":91.5},"name":"cpu","tags":{"cpu":"cpu0"},"timestamp":1465839830}`},
+		{"flat", "time", "unix_ms",
+			`{"cpu":"cpu0","name":"cpu","time":1465839830123,"usage_idle":91.5}`},
+		{"measurement", "", "2006-01-02T15:04:05.000Z07:00",
+			`{"cpu":{"usage_idle":91.5},"tags":{"cpu":"cpu0"},"timestamp":"2016-06-13T17:43:50.123Z"}`},
+		{"nested", "ts", "unix_ns",
+			`{"fields":{"usage_idle":91.5},"name":"cpu","tags":{"cpu":"cpu0"},"ts":1465839830123456789}`},
+	} {
+		s, err := NewJsonSerializer(tt.layout, tt.key, tt.format)
+		assert.NoError(t, err)
+		mS, err := s.Serialize(m)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{tt.expected}, mS, tt.layout)
+	}
+}
+
+func TestNewJsonSerializerInvalid(t *testing.T) {
+	_, err := NewJsonSerializer("tree", "", "")
+	assert.Error(t, err)
+	_, err = NewJsonSerializer("", "", "unix_s")
+	assert.Error(t, err)
+}
@@ -0,0 +1,215 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+)
+
+// encoder encodes MessagePack values with the smallest encoding for their
+// type.
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) put8(v uint8) {
+	e.buf = append(e.buf, v)
+}
+
+func (e *encoder) put16(v uint16) {
+	var b [2]byte
+	binary.BigEndian.PutUint16(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) put32(v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) put64(v uint64) {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	e.buf = append(e.buf, b[:]...)
+}
+
+func (e *encoder) null() {
+	e.put8(0xc0)
+}
+
+func (e *encoder) bool(v bool) {
+	if v {
+		e.put8(0xc3)
+	} else {
+		e.put8(0xc2)
+	}
+}
+
+func (e *encoder) int(v int64) {
+	switch {
+	case v >= 0:
+		e.uint(uint64(v))
+	case v >= -32:
+		e.put8(uint8(v))
+	case v >= math.MinInt8:
+		e.put8(0xd0)
+		e.put8(uint8(v))
+	case v >= math.MinInt16:
+		e.put8(0xd1)
+		e.put16(uint16(v))
+	case v >= math.MinInt32:
+		e.put8(0xd2)
+		e.put32(uint32(v))
+	default:
+		e.put8(0xd3)
+		e.put64(uint64(v))
+	}
+}
+
+func (e *encoder) uint(v uint64) {
+	switch {
+	case v <= math.MaxInt8:
+		e.put8(uint8(v))
+	case v <= math.MaxUint8:
+		e.put8(0xcc)
+		e.put8(uint8(v))
+	case v <= math.MaxUint16:
+		e.put8(0xcd)
+		e.put16(uint16(v))
+	case v <= math.MaxUint32:
+		e.put8(0xce)
+		e.put32(uint32(v))
+	default:
+		e.put8(0xcf)
+		e.put64(v)
+	}
+}
+
+func (e *encoder) float(v float64) {
+	e.put8(0xcb)
+	e.put64(math.Float64bits(v))
+}
+
+func (e *encoder) string(v string) {
+	n := len(v)
+	switch {
+	case n <= 31:
+		e.put8(0xa0 | uint8(n))
+	case n <= math.MaxUint8:
+		e.put8(0xd9)
+		e.put8(uint8(n))
+	case n <= math.MaxUint16:
+		e.put8(0xda)
+		e.put16(uint16(n))
+	default:
+		e.put8(0xdb)
+		e.put32(uint32(n))
+	}
+	e.buf = append(e.buf, v...)
+}
+
+func (e *encoder) arrayHeader(n int) {
+	switch {
+	case n <= 15:
+		e.put8(0x90 | uint8(n))
+	case n <= math.MaxUint16:
+		e.put8(0xdc)
+		e.put16(uint16(n))
+	default:
+		e.put8(0xdd)
+		e.put32(uint32(n))
+	}
+}
+
+func (e *encoder) mapHeader(n int) {
+	switch {
+	case n <= 15:
+		e.put8(0x80 | uint8(n))
+	case n <= math.MaxUint16:
+		e.put8(0xde)
+		e.put16(uint16(n))
+	default:
+		e.put8(0xdf)
+		e.put32(uint32(n))
+	}
+}
+
+// ext writes an extension type value with 1, 2, 4, 8 or 16 bytes of data.
+func (e *encoder) ext(typ int8, data []byte) {
+	switch len(data) {
+	case 1:
+		e.put8(0xd4)
+	case 2:
+		e.put8(0xd5)
+	case 4:
+		e.put8(0xd6)
+	case 8:
+		e.put8(0xd7)
+	case 16:
+		e.put8(0xd8)
+	default:
+		e.put8(0xc7)
+		e.put8(uint8(len(data)))
+	}
+	e.put8(uint8(typ))
+	e.buf = append(e.buf, data...)
+}
+
+// value writes a field value. Types that MessagePack has no encoding for are
+// written as strings.
+func (e *encoder) value(v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		e.null()
+	case bool:
+		e.bool(v)
+	case int64:
+		e.int(v)
+	case int:
+		e.int(int64(v))
+	case int32:
+		e.int(int64(v))
+	case uint64:
+		e.uint(v)
+	case uint32:
+		e.uint(uint64(v))
+	case float64:
+		e.float(v)
+	case float32:
+		e.float(float64(v))
+	case string:
+		e.string(v)
+	default:
+		e.string(fmt.Sprint(v))
+	}
+}
+
+// strings writes a map of strings, sorted by key.
+func (e *encoder) strings(m map[string]string) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.mapHeader(len(keys))
+	for _, k := range keys {
+		e.string(k)
+		e.string(m[k])
+	}
+}
+
+// values writes a map of field values, sorted by key.
+func (e *encoder) values(m map[string]interface{}) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	e.mapHeader(len(keys))
+	for _, k := range keys {
+		e.string(k)
+		e.value(m[k])
+	}
+}
@@ -0,0 +1,166 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/influxdata/telegraf"
+)
+
+// Metric MessagePack object formats.
+const (
+	// FormatMetric is a map of "name", "tags", "fields" and "time". The time
+	// uses the timestamp extension type.
+	FormatMetric = "metric"
+	// FormatForward is a Fluentd forward protocol message,
+	// [tag, time, record]. The tag is "<forward tag>.<name>", the time uses
+	// the EventTime extension type, and the record holds the tags and the
+	// fields.
+	FormatForward = "forward"
+)
+
+// Batch framings.
+const (
+	// FramingStream writes the metric objects one after another, since
+	// MessagePack objects delimit themselves.
+	FramingStream = "stream"
+	// FramingLengthPrefixed prefixes each metric object with its length, a
+	// 4 bytes big endian integer.
+	FramingLengthPrefixed = "length_prefixed"
+)
+
+// DefaultForwardTag is the forward message tag prefix.
+const DefaultForwardTag = "telegraf"
+
+// MsgpackSerializer serializes metrics into a MessagePack object each.
+type MsgpackSerializer struct {
+	// Format is the object format, FormatMetric if empty.
+	Format string
+	// ForwardTag is the tag prefix for FormatForward messages.
+	ForwardTag string
+}
+
+func NewMsgpackSerializer(
+	format string,
+	forwardTag string,
+) (*MsgpackSerializer, error) {
+	switch format {
+	case "", FormatMetric, FormatForward:
+	default:
+		return nil, fmt.Errorf("invalid msgpack_format %q, must be metric "+
+			"or forward", format)
+	}
+	if forwardTag == "" {
+		forwardTag = DefaultForwardTag
+	}
+	return &MsgpackSerializer{Format: format, ForwardTag: forwardTag}, nil
+}
+
+func (s *MsgpackSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	e := &encoder{}
+	s.encode(e, metric)
+	return []string{string(e.buf)}, nil
+}
+
+func (s *MsgpackSerializer) encode(e *encoder, metric telegraf.Metric) {
+	if s.Format == FormatForward {
+		e.arrayHeader(3)
+		e.string(s.ForwardTag + "." + metric.Name())
+		eventTime(e, metric.Time())
+		// fields win over tags with the same name
+		record := make(map[string]interface{})
+		for k, v := range metric.Tags() {
+			record[k] = v
+		}
+		for k, v := range metric.Fields() {
+			record[k] = v
+		}
+		e.values(record)
+		return
+	}
+
+	e.mapHeader(4)
+	e.string("name")
+	e.string(metric.Name())
+	e.string("tags")
+	e.strings(metric.Tags())
+	e.string("fields")
+	e.values(metric.Fields())
+	e.string("time")
+	timestamp(e, metric.Time())
+}
+
+// timestamp writes a time with the timestamp extension type, in the 32, 64
+// or 96 bits format.
+func timestamp(e *encoder, t time.Time) {
+	sec, nsec := t.Unix(), uint32(t.Nanosecond())
+	switch {
+	case sec >= 0 && sec>>32 == 0 && nsec == 0:
+		var b [4]byte
+		binary.BigEndian.PutUint32(b[:], uint32(sec))
+		e.ext(-1, b[:])
+	case sec >= 0 && sec>>34 == 0:
+		var b [8]byte
+		binary.BigEndian.PutUint64(b[:], uint64(nsec)<<34|uint64(sec))
+		e.ext(-1, b[:])
+	default:
+		var b [12]byte
+		binary.BigEndian.PutUint32(b[:4], nsec)
+		binary.BigEndian.PutUint64(b[4:], uint64(sec))
+		e.ext(-1, b[:])
+	}
+}
+
+// eventTime writes a time with the Fluentd EventTime extension type.
+func eventTime(e *encoder, t time.Time) {
+	var b [8]byte
+	binary.BigEndian.PutUint32(b[:4], uint32(t.Unix()))
+	binary.BigEndian.PutUint32(b[4:], uint32(t.Nanosecond()))
+	e.ext(0, b[:])
+}
+
+// BatchMsgpackSerializer serializes a batch of metrics into one object per
+// metric, framed one after another.
+type BatchMsgpackSerializer struct {
+	*MsgpackSerializer
+	// Framing is the object framing, FramingStream or
+	// FramingLengthPrefixed.
+	Framing string
+}
+
+func NewBatchMsgpackSerializer(
+	format string,
+	forwardTag string,
+	framing string,
+) (*BatchMsgpackSerializer, error) {
+	switch framing {
+	case FramingStream, FramingLengthPrefixed:
+	default:
+		return nil, fmt.Errorf("invalid msgpack_batch %q, must be stream or "+
+			"length_prefixed", framing)
+	}
+	s, err := NewMsgpackSerializer(format, forwardTag)
+	if err != nil {
+		return nil, err
+	}
+	return &BatchMsgpackSerializer{MsgpackSerializer: s, Framing: framing}, nil
+}
+
+func (s *BatchMsgpackSerializer) SerializeBatch(
+	metrics []telegraf.Metric,
+) ([]byte, error) {
+	e := &encoder{}
+	for _, metric := range metrics {
+		if s.Framing != FramingLengthPrefixed {
+			s.encode(e, metric)
+			continue
+		}
+		start := len(e.buf)
+		e.put32(0)
+		s.encode(e, metric)
+		binary.BigEndian.PutUint32(e.buf[start:],
+			uint32(len(e.buf)-start-4))
+	}
+	return e.buf, nil
+}
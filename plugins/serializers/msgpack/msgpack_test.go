@@ -0,0 +1,257 @@
+package msgpack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+// ext is a decoded extension.
+type ext struct {
+	typ  int8
+	data []byte
+}
+
+// decode decodes the MessagePack object at the start of b, returning the
+// rest of b.
+func decode(t *testing.T, b []byte) (interface{}, []byte) {
+	require.NotEmpty(t, b)
+	c, b := b[0], b[1:]
+	n := func(size int) uint64 {
+		var v uint64
+		for _, x := range b[:size] {
+			v = v<<8 | uint64(x)
+		}
+		b = b[size:]
+		return v
+	}
+	str := func(size int) (interface{}, []byte) {
+		s := string(b[:size])
+		return s, b[size:]
+	}
+	array := func(size int) (interface{}, []byte) {
+		a := make([]interface{}, size)
+		for i := range a {
+			a[i], b = decode(t, b)
+		}
+		return a, b
+	}
+	object := func(size int) (interface{}, []byte) {
+		m := make(map[string]interface{}, size)
+		for i := 0; i < size; i++ {
+			var k, v interface{}
+			k, b = decode(t, b)
+			v, b = decode(t, b)
+			m[k.(string)] = v
+		}
+		return m, b
+	}
+	extension := func(size int) (interface{}, []byte) {
+		typ := int8(b[0])
+		return ext{typ, b[1 : 1+size]}, b[1+size:]
+	}
+
+	switch {
+	case c <= 0x7f:
+		return int64(c), b
+	case c >= 0xe0:
+		return int64(int8(c)), b
+	case c&0xe0 == 0xa0:
+		return str(int(c & 0x1f))
+	case c&0xf0 == 0x90:
+		return array(int(c & 0x0f))
+	case c&0xf0 == 0x80:
+		return object(int(c & 0x0f))
+	}
+	switch c {
+	case 0xc0:
+		return nil, b
+	case 0xc2, 0xc3:
+		return c == 0xc3, b
+	case 0xcb:
+		return math.Float64frombits(n(8)), b
+	case 0xcc:
+		return int64(n(1)), b
+	case 0xcd:
+		return int64(n(2)), b
+	case 0xce:
+		return int64(n(4)), b
+	case 0xcf:
+		v := n(8)
+		if v > math.MaxInt64 {
+			return v, b
+		}
+		return int64(v), b
+	case 0xd0:
+		return int64(int8(n(1))), b
+	case 0xd1:
+		return int64(int16(n(2))), b
+	case 0xd2:
+		return int64(int32(n(4))), b
+	case 0xd3:
+		return int64(n(8)), b
+	case 0xd9:
+		return str(int(n(1)))
+	case 0xda:
+		return str(int(n(2)))
+	case 0xdc:
+		return array(int(n(2)))
+	case 0xde:
+		return object(int(n(2)))
+	case 0xd6:
+		return extension(4)
+	case 0xd7:
+		return extension(8)
+	case 0xc7:
+		return extension(int(n(1)))
+	}
+	t.Fatalf("unexpected MessagePack byte %#x", c)
+	return nil, nil
+}
+
+func testMetric(t *testing.T, tm time.Time) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "a", "cpu": "cpu0"},
+		map[string]interface{}{
+			"usage": 90.5,
+			"count": int64(-1000),
+			"big":   int64(1) << 40,
+			"ok":    true,
+			"note":  strings.Repeat("x", 40),
+		}, tm)
+	require.NoError(t, err)
+	return m
+}
+
+func TestSerialize(t *testing.T) {
+	s, err := NewMsgpackSerializer("", "")
+	require.NoError(t, err)
+
+	out, err := s.Serialize(testMetric(t, time.Unix(1465839830, 0)))
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	v, rest := decode(t, []byte(out[0]))
+	assert.Empty(t, rest)
+
+	var sec [4]byte
+	binary.BigEndian.PutUint32(sec[:], 1465839830)
+	assert.Equal(t, map[string]interface{}{
+		"name": "cpu",
+		"tags": map[string]interface{}{"host": "a", "cpu": "cpu0"},
+		"fields": map[string]interface{}{
+			"usage": 90.5,
+			"count": int64(-1000),
+			"big":   int64(1) << 40,
+			"ok":    true,
+			"note":  strings.Repeat("x", 40),
+		},
+		"time": ext{-1, sec[:]},
+	}, v)
+}
+
+func TestSerializeTimestamp(t *testing.T) {
+	s, err := NewMsgpackSerializer(FormatMetric, "")
+	require.NoError(t, err)
+
+	for _, tm := range []time.Time{
+		time.Unix(1465839830, 123),
+		time.Unix(1<<33, 0),
+		time.Unix(-1, 0),
+	} {
+		out, err := s.Serialize(testMetric(t, tm))
+		require.NoError(t, err)
+		v, _ := decode(t, []byte(out[0]))
+		ts := v.(map[string]interface{})["time"].(ext)
+		assert.Equal(t, int8(-1), ts.typ)
+
+		var sec int64
+		var nsec uint32
+		switch len(ts.data) {
+		case 8:
+			x := binary.BigEndian.Uint64(ts.data)
+			sec, nsec = int64(x&(1<<34-1)), uint32(x>>34)
+		case 12:
+			nsec = binary.BigEndian.Uint32(ts.data)
+			sec = int64(binary.BigEndian.Uint64(ts.data[4:]))
+		default:
+			t.Fatalf("timestamp has %d bytes", len(ts.data))
+		}
+		assert.Equal(t, tm.UnixNano(), time.Unix(sec, int64(nsec)).UnixNano())
+	}
+}
+
+func TestSerializeForward(t *testing.T) {
+	s, err := NewMsgpackSerializer(FormatForward, "app")
+	require.NoError(t, err)
+
+	out, err := s.Serialize(testMetric(t, time.Unix(1465839830, 5)))
+	require.NoError(t, err)
+	v, rest := decode(t, []byte(out[0]))
+	assert.Empty(t, rest)
+
+	message := v.([]interface{})
+	require.Len(t, message, 3)
+	assert.Equal(t, "app.cpu", message[0])
+	assert.Equal(t, ext{0, []byte{0x57, 0x5e, 0xf0, 0xd6, 0, 0, 0, 5}},
+		message[1])
+	assert.Equal(t, map[string]interface{}{
+		"host":  "a",
+		"cpu":   "cpu0",
+		"usage": 90.5,
+		"count": int64(-1000),
+		"big":   int64(1) << 40,
+		"ok":    true,
+		"note":  strings.Repeat("x", 40),
+	}, message[2])
+}
+
+func TestSerializeBatch(t *testing.T) {
+	metrics := []telegraf.Metric{
+		testMetric(t, time.Unix(1465839830, 0)),
+		testMetric(t, time.Unix(1465839831, 0)),
+	}
+
+	s, err := NewBatchMsgpackSerializer("", "", FramingLengthPrefixed)
+	require.NoError(t, err)
+	out, err := s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	for _, m := range metrics {
+		require.True(t, len(out) > 4)
+		n := binary.BigEndian.Uint32(out)
+		one, err := s.Serialize(m)
+		require.NoError(t, err)
+		assert.Equal(t, uint32(len(one[0])), n)
+		assert.Equal(t, one[0], string(out[4:4+n]))
+		out = out[4+n:]
+	}
+	assert.Empty(t, out)
+
+	s, err = NewBatchMsgpackSerializer(FormatForward, "", FramingStream)
+	require.NoError(t, err)
+	out, err = s.SerializeBatch(metrics)
+	require.NoError(t, err)
+	for i := range metrics {
+		var v interface{}
+		v, out = decode(t, out)
+		tag := v.([]interface{})[0]
+		assert.Equal(t, "telegraf.cpu", tag, fmt.Sprint(i))
+	}
+	assert.Empty(t, out)
+}
+
+func TestNewMsgpackSerializerInvalid(t *testing.T) {
+	_, err := NewMsgpackSerializer("json", "")
+	assert.Error(t, err)
+	_, err = NewBatchMsgpackSerializer("", "", "lines")
+	assert.Error(t, err)
+	_, err = NewBatchMsgpackSerializer("json", "", FramingStream)
+	assert.Error(t, err)
+}
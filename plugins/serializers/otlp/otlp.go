@@ -0,0 +1,160 @@
+package otlp
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/influxdata/telegraf"
+	"github.com/influxdata/telegraf/internal/protowire"
+)
+
+// scopeName is the instrumentation scope name for the metrics.
+const scopeName = "telegraf"
+
+// OTLPSerializer serializes metrics into OpenTelemetry
+// ExportMetricsServiceRequest protobuf messages, the OTLP payload. Any output
+// can then send metrics to OpenTelemetry collectors.
+//
+// Each numeric or boolean field is a gauge named <measurement>_<field>, with
+// one data point. The metric tags are the data point attributes, and string
+// fields are dropped. Resource tags are resource attributes instead.
+type OTLPSerializer struct {
+	// ResourceTags maps the tags that are resource attributes to attribute
+	// names.
+	ResourceTags map[string]string
+}
+
+// NewOTLPSerializer returns a serializer with the given resource tags. Each
+// is <tag>, or <tag>:<attribute> to rename it, such as "host:host.name".
+func NewOTLPSerializer(resourceTags []string) (*OTLPSerializer, error) {
+	s := &OTLPSerializer{ResourceTags: make(map[string]string)}
+	attributes := make(map[string]bool)
+	for _, entry := range resourceTags {
+		tag, attribute := entry, entry
+		if i := strings.Index(entry, ":"); i >= 0 {
+			tag, attribute = entry[:i], entry[i+1:]
+		}
+		if tag == "" || attribute == "" {
+			return nil, fmt.Errorf("invalid otlp_resource_tags entry %q, must "+
+				"be <tag> or <tag>:<attribute>", entry)
+		}
+		if _, ok := s.ResourceTags[tag]; ok || attributes[attribute] {
+			return nil, fmt.Errorf("duplicate otlp_resource_tags entry %q",
+				entry)
+		}
+		s.ResourceTags[tag] = attribute
+		attributes[attribute] = true
+	}
+	return s, nil
+}
+
+// Serialize returns an ExportMetricsServiceRequest for the metric, or none if
+// it has no numeric or boolean fields.
+func (s *OTLPSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	fields := metric.Fields()
+	names := make([]string, 0, len(fields))
+	for name, value := range fields {
+		if _, ok := number(value); ok {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return []string{}, nil
+	}
+	sort.Strings(names)
+
+	resource := make(map[string]string)
+	attributes := make(map[string]string)
+	for k, v := range metric.Tags() {
+		if attribute, ok := s.ResourceTags[k]; ok {
+			resource[attribute] = v
+		} else {
+			attributes[k] = v
+		}
+	}
+
+	request := &protowire.Encoder{}
+	request.Message(1, func(e *protowire.Encoder) {
+		// ResourceMetrics
+		e.Message(1, func(e *protowire.Encoder) {
+			writeAttributes(e, 1, resource)
+		})
+		e.Message(2, func(e *protowire.Encoder) {
+			// ScopeMetrics
+			e.Message(1, func(e *protowire.Encoder) {
+				e.String(1, scopeName)
+			})
+			for _, name := range names {
+				e.Message(2, func(e *protowire.Encoder) {
+					s.writeGauge(e, metric, name, attributes)
+				})
+			}
+		})
+	})
+	return []string{string(request.Buf)}, nil
+}
+
+// writeGauge writes the Metric for a field, as a gauge with one data point.
+func (s *OTLPSerializer) writeGauge(
+	e *protowire.Encoder,
+	metric telegraf.Metric,
+	field string,
+	attributes map[string]string,
+) {
+	e.String(1, metric.Name()+"_"+field)
+	e.Message(5, func(e *protowire.Encoder) {
+		// NumberDataPoint
+		e.Message(1, func(e *protowire.Encoder) {
+			e.Fixed64(3, uint64(metric.UnixNano()))
+			value, _ := number(metric.Fields()[field])
+			switch v := value.(type) {
+			case float64:
+				e.Double(4, v)
+			case int64:
+				e.Fixed64(6, uint64(v))
+			}
+			writeAttributes(e, 7, attributes)
+		})
+	})
+}
+
+// writeAttributes writes a repeated KeyValue field with string attributes,
+// sorted by key.
+func writeAttributes(
+	e *protowire.Encoder,
+	field int,
+	attributes map[string]string,
+) {
+	keys := make([]string, 0, len(attributes))
+	for k := range attributes {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		e.Message(field, func(e *protowire.Encoder) {
+			e.String(1, k)
+			// AnyValue
+			e.Message(2, func(e *protowire.Encoder) {
+				e.String(1, attributes[k])
+			})
+		})
+	}
+}
+
+// number returns the data point value for a field, a float64 or an int64.
+// It returns false if the field is not numeric or boolean.
+func number(value interface{}) (interface{}, bool) {
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case int64:
+		return v, true
+	case bool:
+		if v {
+			return int64(1), true
+		}
+		return int64(0), true
+	}
+	return nil, false
+}
@@ -0,0 +1,141 @@
+package otlp
+
+import (
+	"encoding/binary"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+// message is a decoded protobuf message, with field values by number. Values
+// are uint64 for varints and fixed64, and []byte for length delimited fields.
+type message map[uint64][]interface{}
+
+func decode(t *testing.T, b []byte) message {
+	m := make(message)
+	for len(b) > 0 {
+		key, n := proto.DecodeVarint(b)
+		require.True(t, n > 0, "truncated key")
+		b = b[n:]
+		var v interface{}
+		switch key & 7 {
+		case 0:
+			v, n = proto.DecodeVarint(b)
+			require.True(t, n > 0, "truncated varint")
+		case 1:
+			require.True(t, len(b) >= 8, "truncated fixed64")
+			v, n = binary.LittleEndian.Uint64(b), 8
+		case 2:
+			size, k := proto.DecodeVarint(b)
+			require.True(t, k > 0 && uint64(len(b)-k) >= size,
+				"truncated bytes")
+			v, n = b[k:k+int(size)], k+int(size)
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+		b = b[n:]
+		m[key>>3] = append(m[key>>3], v)
+	}
+	return m
+}
+
+func (m message) message(t *testing.T, field uint64, i int) message {
+	require.True(t, len(m[field]) > i, "field %d", field)
+	return decode(t, m[field][i].([]byte))
+}
+
+func (m message) string(field uint64) string {
+	if len(m[field]) == 0 {
+		return ""
+	}
+	return string(m[field][0].([]byte))
+}
+
+// attributes decodes a repeated KeyValue field with string values.
+func (m message) attributes(t *testing.T, field uint64) map[string]string {
+	attributes := make(map[string]string)
+	for i := range m[field] {
+		kv := m.message(t, field, i)
+		attributes[kv.string(1)] = kv.message(t, 2, 0).string(1)
+	}
+	return attributes
+}
+
+func TestSerialize(t *testing.T) {
+	now := time.Unix(1465839830, 100)
+	m, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "a", "region": "eu", "cpu": "cpu0"},
+		map[string]interface{}{
+			"usage_idle": 91.5,
+			"count":      int64(-3),
+			"up":         true,
+			"status":     "ok",
+		}, now)
+	require.NoError(t, err)
+
+	s, err := NewOTLPSerializer([]string{"host:host.name", "region"})
+	require.NoError(t, err)
+	out, err := s.Serialize(m)
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+
+	request := decode(t, []byte(out[0]))
+	require.Len(t, request[1], 1)
+	resourceMetrics := request.message(t, 1, 0)
+	assert.Equal(t, map[string]string{"host.name": "a", "region": "eu"},
+		resourceMetrics.message(t, 1, 0).attributes(t, 1))
+
+	scopeMetrics := resourceMetrics.message(t, 2, 0)
+	assert.Equal(t, "telegraf", scopeMetrics.message(t, 1, 0).string(1))
+	require.Len(t, scopeMetrics[2], 3)
+
+	expected := []struct {
+		name  string
+		field uint64
+		value uint64
+	}{
+		{"cpu_count", 6, uint64(0xfffffffffffffffd)},
+		{"cpu_up", 6, 1},
+		{"cpu_usage_idle", 4, math.Float64bits(91.5)},
+	}
+	for i, e := range expected {
+		metric := scopeMetrics.message(t, 2, i)
+		assert.Equal(t, e.name, metric.string(1))
+		point := metric.message(t, 5, 0).message(t, 1, 0)
+		assert.Equal(t, []interface{}{uint64(now.UnixNano())}, point[3])
+		assert.Equal(t, []interface{}{e.value}, point[e.field], e.name)
+		assert.Equal(t, map[string]string{"cpu": "cpu0"},
+			point.attributes(t, 7))
+	}
+}
+
+func TestSerializeNoNumericFields(t *testing.T) {
+	m, err := telegraf.NewMetric("log", nil,
+		map[string]interface{}{"message": "hello"}, time.Now())
+	require.NoError(t, err)
+
+	s, err := NewOTLPSerializer(nil)
+	require.NoError(t, err)
+	out, err := s.Serialize(m)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
+
+func TestNewOTLPSerializerInvalid(t *testing.T) {
+	for _, tags := range [][]string{
+		{""},
+		{"host:"},
+		{":host.name"},
+		{"host", "host:host.name"},
+		{"host:name", "hostname:name"},
+	} {
+		_, err := NewOTLPSerializer(tags)
+		assert.Error(t, err, "%v", tags)
+	}
+}
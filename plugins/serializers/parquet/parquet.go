@@ -0,0 +1,636 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/golang/snappy"
+
+	"github.com/influxdata/telegraf"
+)
+
+const magic = "PAR1"
+
+// Schema column types. Every file has the time and measurement columns.
+// Tag columns use TypeTag, and field columns use the other types.
+const (
+	TypeTime        = "time"
+	TypeMeasurement = "measurement"
+	TypeTag         = "tag"
+	TypeDouble      = "double"
+	TypeInt64       = "int64"
+	TypeUint64      = "uint64"
+	TypeBoolean     = "boolean"
+	TypeString      = "string"
+)
+
+// Parquet physical and converted types, encodings and compression codecs.
+const (
+	physicalBoolean   = 0
+	physicalInt64     = 2
+	physicalDouble    = 5
+	physicalByteArray = 6
+
+	convertedUTF8            = 0
+	convertedTimestampMillis = 9
+	convertedTimestampMicros = 10
+	convertedUint64          = 14
+
+	required = 0
+	optional = 1
+
+	encodingPlain = 0
+	encodingRLE   = 3
+
+	codecNone   = 0
+	codecSnappy = 1
+	codecGzip   = 2
+)
+
+var codecs = map[string]int32{
+	"":       codecSnappy,
+	"snappy": codecSnappy,
+	"gzip":   codecGzip,
+	"none":   codecNone,
+}
+
+// timeUnits are the time column units, in nanoseconds.
+var timeUnits = map[string]int64{
+	"":   1e3,
+	"ms": 1e6,
+	"us": 1e3,
+	"ns": 1,
+}
+
+// Column is a column in the schema of the written files.
+type Column struct {
+	Name string
+	// Type is TypeTag, or a field type: TypeDouble, TypeInt64,
+	// TypeUint64, TypeBoolean or TypeString.
+	Type string
+}
+
+// ParquetSerializer serializes batches of metrics into Apache Parquet files,
+// one row per metric. Every file has a "time" and a "measurement" column.
+// The other columns are the metric tags and fields, or the Schema columns.
+// Tags and fields not in the Schema are dropped. So are values that do not
+// convert to their column type.
+type ParquetSerializer struct {
+	// Schema are the file columns, in order. If empty, each file gets one
+	// column per metric tag and field, sorted by name. A field column has
+	// the type of its values. It is double if they mix integers and floats,
+	// and otherwise the type of the first value.
+	Schema []Column
+	// RowGroupSize is the number of rows per row group, 10000 if 0.
+	RowGroupSize int
+	// Compression is the page compression codec: snappy (if empty),
+	// gzip or none.
+	Compression string
+	// TimestampUnit is the time column unit: ms, us (if empty) or ns.
+	TimestampUnit string
+}
+
+func NewParquetSerializer(
+	schema []string,
+	rowGroupSize int,
+	compression string,
+	timestampUnit string,
+) (*ParquetSerializer, error) {
+	s := &ParquetSerializer{
+		RowGroupSize:  rowGroupSize,
+		Compression:   compression,
+		TimestampUnit: timestampUnit,
+	}
+	if rowGroupSize < 0 {
+		return nil, fmt.Errorf("invalid parquet_row_group_size %d",
+			rowGroupSize)
+	}
+	if _, ok := codecs[compression]; !ok {
+		return nil, fmt.Errorf("invalid parquet_compression %q, must be "+
+			"snappy, gzip or none", compression)
+	}
+	if _, ok := timeUnits[timestampUnit]; !ok {
+		return nil, fmt.Errorf("invalid parquet_timestamp_unit %q, must be "+
+			"ms, us or ns", timestampUnit)
+	}
+
+	names := map[string]bool{TypeTime: true, TypeMeasurement: true}
+	for _, column := range schema {
+		i := strings.LastIndex(column, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid parquet_schema column %q, must "+
+				"be <name>:<type>", column)
+		}
+		c := Column{Name: column[:i], Type: column[i+1:]}
+		switch c.Type {
+		case TypeTag, TypeDouble, TypeInt64, TypeUint64, TypeBoolean,
+			TypeString:
+		default:
+			return nil, fmt.Errorf("invalid parquet_schema column %q, the "+
+				"type must be tag, double, int64, uint64, boolean or string",
+				column)
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("duplicate parquet_schema column %q",
+				c.Name)
+		}
+		names[c.Name] = true
+		s.Schema = append(s.Schema, c)
+	}
+	return s, nil
+}
+
+// Serialize serializes a metric into a file with a single row. Outputs that
+// write one message per metric use it.
+func (s *ParquetSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	file, err := s.SerializeBatch([]telegraf.Metric{metric})
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(file)}, nil
+}
+
+// SerializeBatch serializes metrics into a file.
+func (s *ParquetSerializer) SerializeBatch(
+	metrics []telegraf.Metric,
+) ([]byte, error) {
+	columns := s.Schema
+	if len(columns) == 0 {
+		columns = deriveSchema(metrics)
+	}
+	columns = append([]Column{
+		{Name: TypeTime, Type: TypeTime},
+		{Name: TypeMeasurement, Type: TypeMeasurement},
+	}, columns...)
+
+	size := s.RowGroupSize
+	if size == 0 {
+		size = 10000
+	}
+	w := &fileWriter{
+		buf:   []byte(magic),
+		codec: codecs[s.Compression],
+		unit:  timeUnits[s.TimestampUnit],
+	}
+	for start := 0; start < len(metrics); start += size {
+		end := start + size
+		if end > len(metrics) {
+			end = len(metrics)
+		}
+		if err := w.writeRowGroup(columns, metrics[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	w.writeFooter(columns, len(metrics))
+	return w.buf, nil
+}
+
+// deriveSchema returns one column per metric tag and field.
+func deriveSchema(metrics []telegraf.Metric) []Column {
+	tags := make(map[string]bool)
+	fields := make(map[string]string)
+	for _, metric := range metrics {
+		for k := range metric.Tags() {
+			tags[k] = true
+		}
+		for k, v := range metric.Fields() {
+			typ := fieldType(v)
+			if typ == "" {
+				continue
+			}
+			if prev, ok := fields[k]; ok {
+				typ = mergeTypes(prev, typ)
+			}
+			fields[k] = typ
+		}
+	}
+
+	var columns []Column
+	for k := range tags {
+		if k != TypeTime && k != TypeMeasurement {
+			columns = append(columns, Column{Name: k, Type: TypeTag})
+		}
+	}
+	for k, typ := range fields {
+		// tags win over fields with the same name
+		if k != TypeTime && k != TypeMeasurement && !tags[k] {
+			columns = append(columns, Column{Name: k, Type: typ})
+		}
+	}
+	sort.Sort(byName(columns))
+	return columns
+}
+
+type byName []Column
+
+func (c byName) Len() int           { return len(c) }
+func (c byName) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byName) Less(i, j int) bool { return c[i].Name < c[j].Name }
+
+func fieldType(v interface{}) string {
+	switch v.(type) {
+	case float64:
+		return TypeDouble
+	case int64:
+		return TypeInt64
+	case uint64:
+		return TypeUint64
+	case bool:
+		return TypeBoolean
+	case string:
+		return TypeString
+	}
+	return ""
+}
+
+// mergeTypes returns the column type for values of two types.
+func mergeTypes(a, b string) string {
+	numeric := map[string]bool{TypeDouble: true, TypeInt64: true,
+		TypeUint64: true}
+	switch {
+	case a == b:
+		return a
+	case numeric[a] && numeric[b] && (a == TypeDouble || b == TypeDouble):
+		return TypeDouble
+	case numeric[a] && numeric[b]:
+		return TypeInt64
+	}
+	return a
+}
+
+// value returns the metric value for a column, or nil if it has none.
+func value(c Column, metric telegraf.Metric, unit int64) interface{} {
+	switch c.Type {
+	case TypeTime:
+		return metric.UnixNano() / unit
+	case TypeMeasurement:
+		return metric.Name()
+	case TypeTag:
+		if v, ok := metric.Tags()[c.Name]; ok {
+			return v
+		}
+		return nil
+	}
+
+	v := metric.Fields()[c.Name]
+	switch c.Type {
+	case TypeDouble:
+		switch t := v.(type) {
+		case float64:
+			return t
+		case int64:
+			return float64(t)
+		case uint64:
+			return float64(t)
+		}
+	case TypeInt64:
+		switch t := v.(type) {
+		case int64:
+			return t
+		case uint64:
+			if t <= math.MaxInt64 {
+				return int64(t)
+			}
+		}
+	case TypeUint64:
+		switch t := v.(type) {
+		case uint64:
+			return int64(t)
+		case int64:
+			if t >= 0 {
+				return t
+			}
+		}
+	case TypeBoolean:
+		if t, ok := v.(bool); ok {
+			return t
+		}
+	case TypeString:
+		if t, ok := v.(string); ok {
+			return t
+		}
+	}
+	return nil
+}
+
+// fileWriter writes the row groups and footer of a file.
+type fileWriter struct {
+	buf   []byte
+	codec int32
+	unit  int64
+	// rowGroups are the encoded metadata of the row groups written
+	rowGroups [][]byte
+}
+
+// writeRowGroup writes the column chunks for a row group of metrics.
+func (w *fileWriter) writeRowGroup(
+	columns []Column,
+	metrics []telegraf.Metric,
+) error {
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.listField(1, thriftStruct, len(columns))
+	var total int64
+	for _, c := range columns {
+		values := make([]interface{}, len(metrics))
+		for i, metric := range metrics {
+			values[i] = value(c, metric, w.unit)
+		}
+		n, err := w.writeColumnChunk(meta, c, values)
+		if err != nil {
+			return fmt.Errorf("column %s: %s", c.Name, err)
+		}
+		total += n
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(metrics)))
+	meta.endStruct()
+	w.rowGroups = append(w.rowGroups, meta.buf)
+	return nil
+}
+
+// writeColumnChunk writes the values of a column in a single data page, and
+// its column chunk metadata to meta. It returns the uncompressed size of the
+// chunk.
+func (w *fileWriter) writeColumnChunk(
+	meta *thriftWriter,
+	c Column,
+	values []interface{},
+) (int64, error) {
+	// the plain encoded values, after their definition levels
+	var data []byte
+	var levels []byte
+	nulls := 0
+	if isOptional(c) {
+		defs := make([]byte, 0, len(values))
+		for _, v := range values {
+			if v == nil {
+				defs = append(defs, 0)
+				nulls++
+			} else {
+				defs = append(defs, 1)
+			}
+		}
+		levels = bitPack(defs)
+		data = make([]byte, 4, 4+len(levels))
+		binary.LittleEndian.PutUint32(data, uint32(len(levels)))
+		data = append(data, levels...)
+	}
+	bits := 0
+	for _, v := range values {
+		switch t := v.(type) {
+		case nil:
+		case bool:
+			if bits%8 == 0 {
+				data = append(data, 0)
+			}
+			if t {
+				data[len(data)-1] |= 1 << uint(bits%8)
+			}
+			bits++
+		case int64:
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], uint64(t))
+			data = append(data, b[:]...)
+		case float64:
+			var b [8]byte
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(t))
+			data = append(data, b[:]...)
+		case string:
+			var b [4]byte
+			binary.LittleEndian.PutUint32(b[:], uint32(len(t)))
+			data = append(data, b[:]...)
+			data = append(data, t...)
+		}
+	}
+
+	compressed, err := compress(w.codec, data)
+	if err != nil {
+		return 0, err
+	}
+	header := &thriftWriter{}
+	header.beginStruct()
+	header.i32(1, 0) // a data page
+	header.i32(2, int32(len(data)))
+	header.i32(3, int32(len(compressed)))
+	header.structField(5)
+	header.i32(1, int32(len(values)))
+	header.i32(2, encodingPlain)
+	header.i32(3, encodingRLE)
+	header.i32(4, encodingRLE)
+	header.endStruct()
+	header.endStruct()
+
+	offset := int64(len(w.buf))
+	w.buf = append(w.buf, header.buf...)
+	w.buf = append(w.buf, compressed...)
+	uncompressed := int64(len(header.buf) + len(data))
+
+	meta.beginStruct()
+	meta.i64(2, offset)
+	meta.structField(3)
+	meta.i32(1, physicalType(c))
+	meta.listField(2, thriftI32, 2)
+	meta.varint(encodingPlain)
+	meta.varint(encodingRLE)
+	meta.listField(3, thriftBinary, 1)
+	meta.bytes([]byte(c.Name))
+	meta.i32(4, w.codec)
+	meta.i64(5, int64(len(values)))
+	meta.i64(6, uncompressed)
+	meta.i64(7, int64(len(w.buf))-offset)
+	meta.i64(9, offset)
+	writeStatistics(meta, c, values, nulls)
+	meta.endStruct()
+	meta.endStruct()
+	return uncompressed, nil
+}
+
+// writeStatistics writes the column statistics: the minimum and maximum for
+// signed integers and floats. Readers use them to skip the row groups they
+// do not need.
+func writeStatistics(
+	meta *thriftWriter,
+	c Column,
+	values []interface{},
+	nulls int,
+) {
+	var min, max []byte
+	switch c.Type {
+	case TypeTime, TypeInt64:
+		first := true
+		var lo, hi int64
+		for _, v := range values {
+			if t, ok := v.(int64); ok {
+				if first || t < lo {
+					lo = t
+				}
+				if first || t > hi {
+					hi = t
+				}
+				first = false
+			}
+		}
+		if !first {
+			min, max = make([]byte, 8), make([]byte, 8)
+			binary.LittleEndian.PutUint64(min, uint64(lo))
+			binary.LittleEndian.PutUint64(max, uint64(hi))
+		}
+	case TypeDouble:
+		first := true
+		var lo, hi float64
+		for _, v := range values {
+			if t, ok := v.(float64); ok && !math.IsNaN(t) {
+				if first || t < lo {
+					lo = t
+				}
+				if first || t > hi {
+					hi = t
+				}
+				first = false
+			}
+		}
+		if !first {
+			min, max = make([]byte, 8), make([]byte, 8)
+			binary.LittleEndian.PutUint64(min, math.Float64bits(lo))
+			binary.LittleEndian.PutUint64(max, math.Float64bits(hi))
+		}
+	}
+
+	meta.structField(12)
+	meta.i64(3, int64(nulls))
+	if min != nil {
+		meta.binary(5, max)
+		meta.binary(6, min)
+	}
+	meta.endStruct()
+}
+
+// writeFooter writes the file metadata and the file end.
+func (w *fileWriter) writeFooter(columns []Column, rows int) {
+	meta := &thriftWriter{}
+	meta.beginStruct()
+	meta.i32(1, 1)
+	meta.listField(2, thriftStruct, len(columns)+1)
+	meta.beginStruct()
+	meta.binary(4, []byte("telegraf"))
+	meta.i32(5, int32(len(columns)))
+	meta.endStruct()
+	for _, c := range columns {
+		writeSchemaElement(meta, c, w.unit)
+	}
+	meta.i64(3, int64(rows))
+	meta.listField(4, thriftStruct, len(w.rowGroups))
+	for _, rowGroup := range w.rowGroups {
+		meta.buf = append(meta.buf, rowGroup...)
+	}
+	meta.binary(6, []byte("telegraf"))
+	meta.endStruct()
+
+	w.buf = append(w.buf, meta.buf...)
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(meta.buf)))
+	w.buf = append(w.buf, length[:]...)
+	w.buf = append(w.buf, magic...)
+}
+
+// writeSchemaElement writes the schema element of a column, with its
+// converted type, for older readers, and its logical type.
+func writeSchemaElement(meta *thriftWriter, c Column, unit int64) {
+	meta.beginStruct()
+	meta.i32(1, physicalType(c))
+	if isOptional(c) {
+		meta.i32(3, optional)
+	} else {
+		meta.i32(3, required)
+	}
+	meta.binary(4, []byte(c.Name))
+	switch c.Type {
+	case TypeTime:
+		switch unit {
+		case 1e6:
+			meta.i32(6, convertedTimestampMillis)
+		case 1e3:
+			meta.i32(6, convertedTimestampMicros)
+		}
+		meta.structField(10)
+		meta.structField(8)
+		meta.bool(1, true)
+		meta.structField(2)
+		// the MILLIS, MICROS and NANOS members in the TimeUnit union
+		id := map[int64]int16{1e6: 1, 1e3: 2, 1: 3}[unit]
+		meta.structField(id)
+		meta.endStruct()
+		meta.endStruct()
+		meta.endStruct()
+		meta.endStruct()
+	case TypeMeasurement, TypeTag, TypeString:
+		meta.i32(6, convertedUTF8)
+		meta.structField(10)
+		meta.structField(1)
+		meta.endStruct()
+		meta.endStruct()
+	case TypeUint64:
+		meta.i32(6, convertedUint64)
+		meta.structField(10)
+		meta.structField(10)
+		meta.i8(1, 64)
+		meta.bool(2, false)
+		meta.endStruct()
+		meta.endStruct()
+	}
+	meta.endStruct()
+}
+
+func physicalType(c Column) int32 {
+	switch c.Type {
+	case TypeTime, TypeInt64, TypeUint64:
+		return physicalInt64
+	case TypeDouble:
+		return physicalDouble
+	case TypeBoolean:
+		return physicalBoolean
+	}
+	return physicalByteArray
+}
+
+func isOptional(c Column) bool {
+	return c.Type != TypeTime && c.Type != TypeMeasurement
+}
+
+// bitPack encodes one bit definition levels as bit-packed groups in the
+// RLE/bit-packing hybrid encoding.
+func bitPack(levels []byte) []byte {
+	groups := (len(levels) + 7) / 8
+	var header [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(header[:], uint64(groups)<<1|1)
+	b := make([]byte, n+groups)
+	copy(b, header[:n])
+	for i, level := range levels {
+		b[n+i/8] |= level << uint(i%8)
+	}
+	return b
+}
+
+func compress(codec int32, data []byte) ([]byte, error) {
+	switch codec {
+	case codecSnappy:
+		return snappy.Encode(nil, data), nil
+	case codecGzip:
+		var buf bytes.Buffer
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return data, nil
+}
@@ -0,0 +1,153 @@
+package parquet
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+	parser "github.com/influxdata/telegraf/plugins/parsers/parquet"
+)
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	base := time.Unix(1465839830, 123456000).UTC()
+	var metrics []telegraf.Metric
+	for i, values := range []struct {
+		name   string
+		tags   map[string]string
+		fields map[string]interface{}
+	}{
+		{"cpu", map[string]string{"host": "a"}, map[string]interface{}{
+			"usage": 21.5, "count": int64(1), "up": true, "status": "ok"}},
+		{"cpu", map[string]string{"host": "b"}, map[string]interface{}{
+			"usage": int64(22), "count": int64(2)}},
+		{"mem", map[string]string{"host": "a", "region": "eu"},
+			map[string]interface{}{"free": int64(1 << 40)}},
+	} {
+		m, err := telegraf.NewMetric(values.name, values.tags, values.fields,
+			base.Add(time.Duration(i)*time.Minute))
+		require.NoError(t, err)
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func parse(t *testing.T, file []byte) []telegraf.Metric {
+	p, err := parser.NewParquetParser("parquet", []string{"measurement",
+		"host", "region"}, nil, "time", "", "", "", nil)
+	require.NoError(t, err)
+	metrics, err := p.Parse(file)
+	require.NoError(t, err)
+	return metrics
+}
+
+func TestSerializeBatch(t *testing.T) {
+	for _, compression := range []string{"", "gzip", "none"} {
+		s, err := NewParquetSerializer(nil, 2, compression, "")
+		require.NoError(t, err)
+
+		file, err := s.SerializeBatch(testMetrics(t))
+		require.NoError(t, err)
+		metrics := parse(t, file)
+		require.Len(t, metrics, 3)
+
+		assert.Equal(t, map[string]string{
+			"measurement": "cpu",
+			"host":        "a",
+		}, metrics[0].Tags())
+		assert.Equal(t, map[string]interface{}{
+			"usage":  21.5,
+			"count":  int64(1),
+			"up":     true,
+			"status": "ok",
+		}, metrics[0].Fields(), compression)
+		assert.Equal(t, time.Unix(1465839830, 123456000).UTC(),
+			metrics[0].Time())
+
+		// integers in a float column are floats
+		assert.Equal(t, map[string]interface{}{
+			"usage": 22.0,
+			"count": int64(2),
+		}, metrics[1].Fields())
+
+		assert.Equal(t, map[string]string{
+			"measurement": "mem",
+			"host":        "a",
+			"region":      "eu",
+		}, metrics[2].Tags())
+		assert.Equal(t, map[string]interface{}{
+			"free": int64(1 << 40),
+		}, metrics[2].Fields())
+	}
+}
+
+func TestSerializeBatchSchema(t *testing.T) {
+	s, err := NewParquetSerializer([]string{"host:tag", "usage:int64",
+		"status:string"}, 0, "", "ms")
+	require.NoError(t, err)
+
+	file, err := s.SerializeBatch(testMetrics(t))
+	require.NoError(t, err)
+	metrics := parse(t, file)
+	// the mem metric has none of the fields
+	require.Len(t, metrics, 2)
+	assert.Equal(t, map[string]interface{}{"status": "ok"},
+		metrics[0].Fields())
+	assert.Equal(t, map[string]interface{}{"usage": int64(22)},
+		metrics[1].Fields())
+	assert.Equal(t, time.Unix(1465839830, 123000000).UTC(),
+		metrics[0].Time())
+}
+
+// Verify that the statistics of the time column let readers skip row
+// groups.
+func TestSerializeBatchStatistics(t *testing.T) {
+	s, err := NewParquetSerializer(nil, 1, "", "ns")
+	require.NoError(t, err)
+	file, err := s.SerializeBatch(testMetrics(t))
+	require.NoError(t, err)
+
+	p, err := parser.NewParquetParser("parquet", nil, nil, "time", "",
+		"2016-06-13T17:44:00Z", "", nil)
+	require.NoError(t, err)
+	groups := 0
+	err = p.ParseFile(bytes.NewReader(file), int64(len(file)),
+		func(metrics []telegraf.Metric) error {
+			groups++
+			return nil
+		})
+	require.NoError(t, err)
+	assert.Equal(t, 2, groups)
+}
+
+func TestSerialize(t *testing.T) {
+	s, err := NewParquetSerializer(nil, 0, "", "")
+	require.NoError(t, err)
+	out, err := s.Serialize(testMetrics(t)[0])
+	require.NoError(t, err)
+	require.Len(t, out, 1)
+	metrics := parse(t, []byte(out[0]))
+	require.Len(t, metrics, 1)
+	assert.Equal(t, 21.5, metrics[0].Fields()["usage"])
+}
+
+func TestNewParquetSerializerInvalid(t *testing.T) {
+	for _, schema := range [][]string{
+		{"host"},
+		{"host:float"},
+		{"host:tag", "host:string"},
+		{"time:int64"},
+	} {
+		_, err := NewParquetSerializer(schema, 0, "", "")
+		assert.Error(t, err, "%v", schema)
+	}
+	_, err := NewParquetSerializer(nil, -1, "", "")
+	assert.Error(t, err)
+	_, err = NewParquetSerializer(nil, 0, "lzo", "")
+	assert.Error(t, err)
+	_, err = NewParquetSerializer(nil, 0, "", "s")
+	assert.Error(t, err)
+}
@@ -3,9 +3,16 @@ package serializers
 import (
 	"github.com/influxdata/telegraf"
 
+	"github.com/influxdata/telegraf/plugins/serializers/avro"
+	"github.com/influxdata/telegraf/plugins/serializers/emf"
 	"github.com/influxdata/telegraf/plugins/serializers/graphite"
 	"github.com/influxdata/telegraf/plugins/serializers/influx"
 	"github.com/influxdata/telegraf/plugins/serializers/json"
+	"github.com/influxdata/telegraf/plugins/serializers/msgpack"
+	"github.com/influxdata/telegraf/plugins/serializers/otlp"
+	"github.com/influxdata/telegraf/plugins/serializers/parquet"
+	"github.com/influxdata/telegraf/plugins/serializers/splunkmetric"
+	"github.com/influxdata/telegraf/plugins/serializers/template"
 )
 
 // SerializerOutput is an interface for output plugins that are able to
@@ -22,18 +29,118 @@ type Serializer interface {
 	Serialize(metric telegraf.Metric) ([]string, error)
 }
 
+// BatchSerializer is an interface for file format serializers, like parquet.
+// Each document holds a batch of metrics, and documents can not be appended
+// to each other. Outputs writing files should write each batch to its own
+// file.
+type BatchSerializer interface {
+	Serializer
+
+	// SerializeBatch turns a batch of metrics into a document.
+	SerializeBatch(metrics []telegraf.Metric) ([]byte, error)
+}
+
 // Config is a struct that covers the data types needed for all serializer types,
 // and can be used to instantiate _any_ of the serializers.
 type Config struct {
-	// Dataformat can be one of: influx, graphite
+	// Dataformat can be one of: influx, graphite, json, parquet, otlp,
+	// avro, template, msgpack, splunkmetric, emf
 	DataFormat string
 
 	// Prefix to add to all measurements, only supports Graphite
 	Prefix string
 
-	// Template for converting telegraf metrics into Graphite
-	// only supports Graphite
+	// InfluxUintSupport is true if unsigned integers are written as such in
+	// InfluxDB line protocol, like 42u.
+	InfluxUintSupport bool
+	// InfluxFloatFormat is the InfluxDB line protocol float format: decimal,
+	// exponent or compact. InfluxFloatPrecision is the number of digits. If
+	// zero, the fewest digits that represent the float exactly are used.
+	InfluxFloatFormat    string
+	InfluxFloatPrecision int
+	// InfluxUnsortedTags is true if InfluxDB line protocol tags are not
+	// sorted.
+	InfluxUnsortedTags bool
+	// InfluxStrict is true if metrics with invalid names are errors, instead
+	// of having their names sanitized.
+	InfluxStrict bool
+
+	// Template for converting telegraf metrics into Graphite, or the Go
+	// template for each line in the template data format
 	Template string
+	// GraphiteTemplates are the templates for the measurements matching
+	// their filter, as [<filter>] <template>.
+	GraphiteTemplates []string
+	// GraphiteSanitizeMode sets how Graphite names are sanitized: classic,
+	// strict or tags. GraphiteReplacements override its character
+	// replacements, as <old>:<new>.
+	GraphiteSanitizeMode string
+	GraphiteReplacements []string
+	// GraphiteMaxLength is the maximum Graphite path length. Longer paths are
+	// truncated and suffixed by a hash.
+	GraphiteMaxLength int
+	// TemplateHeader and TemplateFooter are the Go templates for the batch
+	// header and footer in the template data format.
+	TemplateHeader string
+	TemplateFooter string
+
+	// JSONLayout is the metric JSON object layout: nested, flat or
+	// measurement.
+	JSONLayout string
+	// JSONTimestampKey is the JSON key holding the time.
+	// JSONTimestampFormat is its format: unix, unix_ms, unix_us, unix_ns or a
+	// time layout.
+	JSONTimestampKey    string
+	JSONTimestampFormat string
+
+	// ParquetSchema are the parquet file columns, as <name>:<type>. If
+	// empty, they are derived from the metrics.
+	ParquetSchema []string
+	// ParquetRowGroupSize is the number of rows per parquet row group.
+	ParquetRowGroupSize int
+	// ParquetCompression is the parquet compression codec: snappy,
+	// gzip or none.
+	ParquetCompression string
+	// ParquetTimestampUnit is the parquet time column unit: ms, us or ns.
+	ParquetTimestampUnit string
+
+	// OTLPResourceTags are the tags that become OTLP resource attributes, as
+	// <tag> or <tag>:<attribute>.
+	OTLPResourceTags []string
+
+	// AvroSchemaRegistry is the URL of the schema registry for Avro record
+	// schemas. AvroNamespace is the record namespace.
+	AvroSchemaRegistry string
+	AvroNamespace      string
+
+	// MsgpackFormat is the metric MessagePack object format: metric or
+	// forward. MsgpackForwardTag is the forward message tag prefix.
+	MsgpackFormat     string
+	MsgpackForwardTag string
+	// MsgpackBatch is the batch object framing: stream or length_prefixed,
+	// or empty for one object at a time.
+	MsgpackBatch string
+
+	// SplunkMetricHECRouting is true if Splunk metric events use the HTTP
+	// Event Collector envelope, with SplunkMetricIndex, SplunkMetricSource
+	// and SplunkMetricSourceType.
+	SplunkMetricHECRouting bool
+	SplunkMetricIndex      string
+	SplunkMetricSource     string
+	SplunkMetricSourceType string
+	// SplunkMetricMultiMetric is true if all fields of a metric go into a
+	// single Splunk multiple-metric event.
+	SplunkMetricMultiMetric bool
+
+	// EMFNamespace is the CloudWatch namespace for Embedded Metric Format
+	// metrics, unless they have an EMFNamespaceTag tag.
+	EMFNamespace    string
+	EMFNamespaceTag string
+	// EMFDimensions are the tags that are CloudWatch dimensions, all tags if
+	// empty.
+	EMFDimensions []string
+	// EMFUnits are CloudWatch field units, as <field>:<unit>.
+	EMFUnits []string
 }
 
 // NewSerializer a Serializer interface based on the given config.
@@ -42,26 +149,157 @@ func NewSerializer(config *Config) (Serializer, error) {
 	var serializer Serializer
 	switch config.DataFormat {
 	case "influx":
-		serializer, err = NewInfluxSerializer()
+		serializer, err = NewInfluxSerializerConfig(config)
 	case "graphite":
-		serializer, err = NewGraphiteSerializer(config.Prefix, config.Template)
+		serializer, err = NewGraphiteSerializerConfig(config)
 	case "json":
-		serializer, err = NewJsonSerializer()
+		serializer, err = NewJsonSerializer(config.JSONLayout,
+			config.JSONTimestampKey, config.JSONTimestampFormat)
+	case "parquet":
+		serializer, err = NewParquetSerializer(config.ParquetSchema,
+			config.ParquetRowGroupSize, config.ParquetCompression,
+			config.ParquetTimestampUnit)
+	case "otlp":
+		serializer, err = NewOTLPSerializer(config.OTLPResourceTags)
+	case "template":
+		serializer, err = NewTemplateSerializer(config.Template,
+			config.TemplateHeader, config.TemplateFooter)
+	case "avro":
+		serializer, err = NewAvroSerializer(config.AvroSchemaRegistry,
+			config.AvroNamespace)
+	case "msgpack":
+		serializer, err = NewMsgpackSerializer(config.MsgpackFormat,
+			config.MsgpackForwardTag, config.MsgpackBatch)
+	case "splunkmetric":
+		serializer, err = NewSplunkMetricSerializer(config)
+	case "emf":
+		serializer, err = NewEMFSerializer(config)
 	}
 	return serializer, err
 }
 
-func NewJsonSerializer() (Serializer, error) {
-	return &json.JsonSerializer{}, nil
+func NewJsonSerializer(
+	layout string,
+	timestampKey string,
+	timestampFormat string,
+) (Serializer, error) {
+	serializer, err := json.NewJsonSerializer(layout, timestampKey,
+		timestampFormat)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
 }
 
 func NewInfluxSerializer() (Serializer, error) {
 	return &influx.InfluxSerializer{}, nil
 }
 
+func NewInfluxSerializerConfig(config *Config) (Serializer, error) {
+	serializer, err := influx.NewInfluxSerializer(config.InfluxUintSupport,
+		config.InfluxFloatFormat, config.InfluxFloatPrecision,
+		config.InfluxUnsortedTags, config.InfluxStrict)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
 func NewGraphiteSerializer(prefix, template string) (Serializer, error) {
 	return &graphite.GraphiteSerializer{
 		Prefix:   prefix,
 		Template: template,
 	}, nil
 }
+
+func NewGraphiteSerializerConfig(config *Config) (Serializer, error) {
+	serializer, err := graphite.NewGraphiteSerializer(config.Prefix,
+		config.Template, config.GraphiteTemplates, config.GraphiteSanitizeMode,
+		config.GraphiteReplacements, config.GraphiteMaxLength)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
+func NewParquetSerializer(
+	schema []string,
+	rowGroupSize int,
+	compression string,
+	timestampUnit string,
+) (Serializer, error) {
+	serializer, err := parquet.NewParquetSerializer(schema, rowGroupSize,
+		compression, timestampUnit)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
+func NewOTLPSerializer(resourceTags []string) (Serializer, error) {
+	serializer, err := otlp.NewOTLPSerializer(resourceTags)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
+func NewAvroSerializer(schemaRegistry, namespace string) (Serializer, error) {
+	serializer, err := avro.NewAvroSerializer(schemaRegistry, namespace)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
+// NewTemplateSerializer returns a batch serializer if there is a header or a
+// footer, and a single metric serializer otherwise.
+func NewTemplateSerializer(line, header, footer string) (Serializer, error) {
+	if header == "" && footer == "" {
+		serializer, err := template.NewTemplateSerializer(line)
+		if err != nil {
+			return nil, err
+		}
+		return serializer, nil
+	}
+	serializer, err := template.NewBatchTemplateSerializer(line, header,
+		footer)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
+// NewMsgpackSerializer returns a batch serializer if a batch framing is
+// set, and a single metric serializer otherwise.
+func NewMsgpackSerializer(format, forwardTag, batch string) (Serializer, error) {
+	if batch == "" {
+		serializer, err := msgpack.NewMsgpackSerializer(format, forwardTag)
+		if err != nil {
+			return nil, err
+		}
+		return serializer, nil
+	}
+	serializer, err := msgpack.NewBatchMsgpackSerializer(format, forwardTag,
+		batch)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
+
+func NewSplunkMetricSerializer(config *Config) (Serializer, error) {
+	return splunkmetric.NewSplunkMetricSerializer(
+		config.SplunkMetricHECRouting, config.SplunkMetricMultiMetric,
+		config.SplunkMetricIndex, config.SplunkMetricSource,
+		config.SplunkMetricSourceType), nil
+}
+
+func NewEMFSerializer(config *Config) (Serializer, error) {
+	serializer, err := emf.NewEMFSerializer(config.EMFNamespace,
+		config.EMFNamespaceTag, config.EMFDimensions, config.EMFUnits)
+	if err != nil {
+		return nil, err
+	}
+	return serializer, nil
+}
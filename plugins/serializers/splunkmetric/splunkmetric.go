@@ -0,0 +1,159 @@
+package splunkmetric
+
+import (
+	ejson "encoding/json"
+	"sort"
+
+	"github.com/influxdata/telegraf"
+)
+
+// SplunkMetricSerializer serializes metrics into Splunk HTTP Event Collector
+// JSON metric events. Any output can then send metrics to Splunk metric
+// indexes.
+//
+// Each numeric or boolean field is a measurement named
+// <measurement>.<field>. It is stored in the "metric_name" and "_value"
+// event fields, and the metric tags are the other fields, as dimensions.
+// Booleans are 1 or 0, and string fields are dropped.
+type SplunkMetricSerializer struct {
+	// HECRouting is true if the events use the HTTP Event Collector
+	// envelope. The envelope holds the time, the host from the "host" tag,
+	// the index, the source and the sourcetype, and the measurements in its
+	// "fields". Otherwise the events are only the fields and the time, as in
+	// the metric files that forwarders monitor.
+	HECRouting bool
+	// MultiMetric is true if all measurements of a metric go into a single
+	// event, as "metric_name:<name>": <value>, instead of one event each.
+	// This needs Splunk 8.0 or later.
+	MultiMetric bool
+	// Index, Source and SourceType are set on HECRouting events. If empty,
+	// the HTTP Event Collector token defaults apply.
+	Index      string
+	Source     string
+	SourceType string
+}
+
+func NewSplunkMetricSerializer(
+	hecRouting bool,
+	multiMetric bool,
+	index string,
+	source string,
+	sourceType string,
+) *SplunkMetricSerializer {
+	return &SplunkMetricSerializer{
+		HECRouting:  hecRouting,
+		MultiMetric: multiMetric,
+		Index:       index,
+		Source:      source,
+		SourceType:  sourceType,
+	}
+}
+
+// event is an HTTP Event Collector event.
+type event struct {
+	Time       float64                `json:"time"`
+	Event      string                 `json:"event"`
+	Host       string                 `json:"host,omitempty"`
+	Index      string                 `json:"index,omitempty"`
+	Source     string                 `json:"source,omitempty"`
+	SourceType string                 `json:"sourcetype,omitempty"`
+	Fields     map[string]interface{} `json:"fields"`
+}
+
+func (s *SplunkMetricSerializer) Serialize(
+	metric telegraf.Metric,
+) ([]string, error) {
+	// the time is in seconds, with millisecond precision
+	t := float64(metric.UnixNano()/1e6) / 1e3
+
+	host := ""
+	dimensions := make(map[string]interface{})
+	for k, v := range metric.Tags() {
+		if k == "host" && s.HECRouting {
+			host = v
+			continue
+		}
+		dimensions[k] = v
+	}
+
+	var names []string
+	values := make(map[string]float64)
+	for k, v := range metric.Fields() {
+		if value, ok := toFloat(v); ok {
+			name := metric.Name() + "." + k
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+	sort.Strings(names)
+
+	var fields []map[string]interface{}
+	if s.MultiMetric {
+		if len(names) != 0 {
+			f := copyMap(dimensions)
+			for _, name := range names {
+				f["metric_name:"+name] = values[name]
+			}
+			fields = append(fields, f)
+		}
+	} else {
+		for _, name := range names {
+			f := copyMap(dimensions)
+			f["metric_name"] = name
+			f["_value"] = values[name]
+			fields = append(fields, f)
+		}
+	}
+
+	out := []string{}
+	for _, f := range fields {
+		var e interface{}
+		if s.HECRouting {
+			e = &event{
+				Time:       t,
+				Event:      "metric",
+				Host:       host,
+				Index:      s.Index,
+				Source:     s.Source,
+				SourceType: s.SourceType,
+				Fields:     f,
+			}
+		} else {
+			f["time"] = t
+			e = f
+		}
+		serialized, err := ejson.Marshal(e)
+		if err != nil {
+			return []string{}, err
+		}
+		out = append(out, string(serialized))
+	}
+	return out, nil
+}
+
+func copyMap(m map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(m)+2)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// toFloat returns the measurement value for a field, or false if the field
+// is not a measurement.
+func toFloat(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	case bool:
+		if v {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,69 @@
+package splunkmetric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+func testMetric(t *testing.T) telegraf.Metric {
+	m, err := telegraf.NewMetric("cpu",
+		map[string]string{"host": "web1", "cpu": "cpu0"},
+		map[string]interface{}{
+			"usage_idle": 91.5,
+			"count":      int64(3),
+			"online":     true,
+			"state":      "ok",
+		},
+		time.Unix(1465839830, 123456789))
+	require.NoError(t, err)
+	return m
+}
+
+func TestSerialize(t *testing.T) {
+	s := NewSplunkMetricSerializer(false, false, "", "", "")
+	out, err := s.Serialize(testMetric(t))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"_value":3,"cpu":"cpu0","host":"web1","metric_name":"cpu.count",` +
+			`"time":1465839830.123}`,
+		`{"_value":1,"cpu":"cpu0","host":"web1","metric_name":"cpu.online",` +
+			`"time":1465839830.123}`,
+		`{"_value":91.5,"cpu":"cpu0","host":"web1",` +
+			`"metric_name":"cpu.usage_idle","time":1465839830.123}`,
+	}, out)
+}
+
+func TestSerializeHECRouting(t *testing.T) {
+	s := NewSplunkMetricSerializer(true, false, "metrics", "telegraf", "")
+	out, err := s.Serialize(testMetric(t))
+	require.NoError(t, err)
+	require.Len(t, out, 3)
+	assert.Equal(t, `{"time":1465839830.123,"event":"metric","host":"web1",`+
+		`"index":"metrics","source":"telegraf","fields":{"_value":3,`+
+		`"cpu":"cpu0","metric_name":"cpu.count"}}`, out[0])
+}
+
+func TestSerializeMultiMetric(t *testing.T) {
+	s := NewSplunkMetricSerializer(true, true, "", "", "telegraf")
+	out, err := s.Serialize(testMetric(t))
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		`{"time":1465839830.123,"event":"metric","host":"web1",` +
+			`"sourcetype":"telegraf","fields":{"cpu":"cpu0",` +
+			`"metric_name:cpu.count":3,"metric_name:cpu.online":1,` +
+			`"metric_name:cpu.usage_idle":91.5}}`,
+	}, out)
+
+	// metrics without numeric fields produce no events
+	m, err := telegraf.NewMetric("log", nil,
+		map[string]interface{}{"message": "x"}, time.Now())
+	require.NoError(t, err)
+	out, err = s.Serialize(m)
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
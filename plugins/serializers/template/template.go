@@ -0,0 +1,105 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"github.com/influxdata/telegraf"
+)
+
+// funcs are the template functions added to the builtin ones.
+var funcs = template.FuncMap{
+	// json encodes a value as JSON, so a string keeps its quotes
+	"json": func(v interface{}) (string, error) {
+		encoded, err := json.Marshal(v)
+		return string(encoded), err
+	},
+}
+
+// TemplateSerializer serializes metrics with a Go text template. The metric
+// is the template data, for example
+// "{{.Name}} {{.Tags.host}} {{.Fields.value}} {{.Time.Unix}}".
+type TemplateSerializer struct {
+	Template *template.Template
+}
+
+func NewTemplateSerializer(line string) (*TemplateSerializer, error) {
+	if line == "" {
+		return nil, fmt.Errorf("template must be set")
+	}
+	t, err := parse("template", line)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateSerializer{Template: t}, nil
+}
+
+func (s *TemplateSerializer) Serialize(metric telegraf.Metric) ([]string, error) {
+	var buf bytes.Buffer
+	if err := s.Template.Execute(&buf, metric); err != nil {
+		return nil, err
+	}
+	return []string{buf.String()}, nil
+}
+
+// BatchTemplateSerializer serializes batches of metrics into documents with a
+// header, one line per metric, and a footer. The header and the footer are
+// templates whose data is the batch of metrics, for example
+// "{{len .}} metrics".
+type BatchTemplateSerializer struct {
+	*TemplateSerializer
+	Header *template.Template
+	Footer *template.Template
+}
+
+func NewBatchTemplateSerializer(
+	line string,
+	header string,
+	footer string,
+) (*BatchTemplateSerializer, error) {
+	s, err := NewTemplateSerializer(line)
+	if err != nil {
+		return nil, err
+	}
+	batch := &BatchTemplateSerializer{TemplateSerializer: s}
+	if batch.Header, err = parse("template_header", header); err != nil {
+		return nil, err
+	}
+	if batch.Footer, err = parse("template_footer", footer); err != nil {
+		return nil, err
+	}
+	return batch, nil
+}
+
+// SerializeBatch returns a document with the header, one line per metric,
+// and the footer. The lines end with a newline, and the header and
+// the footer with their own.
+func (s *BatchTemplateSerializer) SerializeBatch(
+	metrics []telegraf.Metric,
+) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := s.Header.Execute(&buf, metrics); err != nil {
+		return nil, err
+	}
+	for _, metric := range metrics {
+		if err := s.Template.Execute(&buf, metric); err != nil {
+			return nil, err
+		}
+		buf.WriteByte('\n')
+	}
+	if err := s.Footer.Execute(&buf, metrics); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func parse(option, text string) (*template.Template, error) {
+	t, err := template.New(option).Funcs(funcs).Option("missingkey=zero").
+		Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s, %s", option, err)
+	}
+	return t, nil
+}
@@ -0,0 +1,79 @@
+package template
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/influxdata/telegraf"
+)
+
+func testMetrics(t *testing.T) []telegraf.Metric {
+	var metrics []telegraf.Metric
+	for i, host := range []string{"a", "b"} {
+		m, err := telegraf.NewMetric("cpu", map[string]string{"host": host},
+			map[string]interface{}{"usage": 90.5 + float64(i), "count": 1},
+			time.Unix(1465839830+int64(i), 0))
+		require.NoError(t, err)
+		metrics = append(metrics, m)
+	}
+	return metrics
+}
+
+func TestSerialize(t *testing.T) {
+	s, err := NewTemplateSerializer(
+		`{{.Name}}|{{.Tags.host}}|{{.Tags.region}}|{{.Fields.usage}}|` +
+			`{{.Time.Unix}}{{range $k, $v := .Fields}} {{$k}}={{$v}}{{end}}`)
+	require.NoError(t, err)
+	out, err := s.Serialize(testMetrics(t)[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{"cpu|a||90.5|1465839830 count=1 usage=90.5"},
+		out)
+}
+
+func TestSerializeJSON(t *testing.T) {
+	s, err := NewTemplateSerializer(`{"measurement": {{json .Name}}, ` +
+		`"tags": {{json .Tags}}}`)
+	require.NoError(t, err)
+	out, err := s.Serialize(testMetrics(t)[0])
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"measurement": "cpu", "tags": {"host":"a"}}`},
+		out)
+}
+
+func TestSerializeBatch(t *testing.T) {
+	s, err := NewBatchTemplateSerializer("  <metric host={{json .Tags.host}}/>",
+		"<metrics count=\"{{len .}}\">\n", "</metrics>\n")
+	require.NoError(t, err)
+
+	out, err := s.SerializeBatch(testMetrics(t))
+	require.NoError(t, err)
+	assert.Equal(t, `<metrics count="2">
+  <metric host="a"/>
+  <metric host="b"/>
+</metrics>
+`, string(out))
+
+	// a metric at a time, for other outputs than files
+	lines, err := s.Serialize(testMetrics(t)[1])
+	require.NoError(t, err)
+	assert.Equal(t, []string{`  <metric host="b"/>`}, lines)
+}
+
+func TestSerializeError(t *testing.T) {
+	s, err := NewTemplateSerializer(`{{.Nope}}`)
+	require.NoError(t, err)
+	_, err = s.Serialize(testMetrics(t)[0])
+	assert.Error(t, err)
+}
+
+func TestNewTemplateSerializerInvalid(t *testing.T) {
+	_, err := NewTemplateSerializer("")
+	assert.Error(t, err)
+	_, err = NewTemplateSerializer("{{.Name")
+	assert.Error(t, err)
+	_, err = NewBatchTemplateSerializer("{{.Name}}", "{{end}}", "")
+	assert.Error(t, err)
+}